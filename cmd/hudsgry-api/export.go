@@ -0,0 +1,166 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportColumns are the CSV columns, one row per item served.
+var exportColumns = []string{"serve_date", "meal", "food_name", "menu_category", "calories", "protein", "total_fat", "total_carb", "allergens", "vegan", "vegetarian"}
+
+// export writes the stored menus in a range, by default everything stored.
+func export(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	storeFlags := addStoreFlags(flags)
+	format := flags.String("format", "json", "output format: json or csv")
+	from := flags.String("from", "", "first serve date to export (default the earliest stored)")
+	to := flags.String("to", "", "last serve date to export (default the latest stored)")
+	output := flags.String("output", "", "file to write to (default stdout)")
+	out := flags.String("out", "", "write a full backup of every stored day to this file instead, gzipped if it ends in .gz; see the import command")
+	flags.Parse(args)
+
+	if *out != "" {
+		return exportBackup(storeFlags, *out)
+	}
+
+	if *format != "json" && *format != "csv" {
+		return fmt.Errorf("--format must be json or csv, got %q", *format)
+	}
+	var start, end string
+	for _, date := range []struct {
+		name  string
+		value string
+		dest  *string
+	}{{"from", *from, &start}, {"to", *to, &end}} {
+		if date.value == "" {
+			continue
+		}
+		t, err := parseDate(date.name, date.value)
+		if err != nil {
+			return err
+		}
+		*date.dest = t.Format(huds.ServeDateLayout)
+	}
+
+	a, err := setup(storeFlags)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	ctx, cancel := a.context()
+	defer cancel()
+	earliest, latest, err := a.store.EarliestLatest(ctx)
+	if err != nil {
+		return err
+	}
+	if start == "" {
+		start = earliest
+	}
+	if end == "" {
+		end = latest
+	}
+	menus := []huds.CondensedMenu{}
+	if start != "" && end != "" {
+		if menus, err = a.store.GetRange(ctx, start, end); err != nil {
+			return err
+		}
+	}
+
+	w := io.Writer(os.Stdout)
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	if *format == "csv" {
+		return exportCSV(w, menus)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(menus)
+}
+
+// exportBackup writes every stored day in the backup format, which import
+// reads back into any storage backend.
+func exportBackup(storeFlags storeFlags, path string) error {
+	a, err := setup(storeFlags)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := io.Writer(file)
+	var gz *gzip.Writer
+	if strings.HasSuffix(path, ".gz") {
+		gz = gzip.NewWriter(file)
+		w = gz
+	}
+
+	ctx, cancel := a.context()
+	defer cancel()
+	days, err := store.WriteBackup(ctx, a.store, w, time.Now())
+	if err != nil {
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	log.Printf("Backed up %d days to %s\n", days, path)
+	return nil
+}
+
+func exportCSV(w io.Writer, menus []huds.CondensedMenu) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(exportColumns); err != nil {
+		return err
+	}
+	for _, menu := range menus {
+		for _, meal := range menu.Meals() {
+			for _, item := range huds.MealItems(menu, meal) {
+				err := writer.Write([]string{
+					menu.ServeDate,
+					meal,
+					item.FoodName,
+					item.MenuCategory,
+					item.Calories,
+					item.Protein,
+					item.TotalFat,
+					item.TotalCarb,
+					item.Allergens,
+					strconv.FormatBool(item.Vegan),
+					strconv.FormatBool(item.Vegetarian),
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hudsgry-api/internal/api"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/site"
+	"log"
+	"time"
+)
+
+// siteIndex is dates.json, listing every date the site has a menu for.
+type siteIndex struct {
+	Earliest string    `json:"earliest"`
+	Latest   string    `json:"latest"`
+	Dates    []string  `json:"dates"`
+	Updated  time.Time `json:"updated"`
+}
+
+// exportSite writes stored menus as static files that can be hosted without
+// the server:
+//
+//	huds-data/YYYY-MM-DD.json  each day's menu
+//	dates.json                 the dates exported
+//	menu/YYYY-MM-DD.html       each day's menu page, with --html
+//	index.html                 the latest day's menu page, with --html
+func exportSite(args []string) error {
+	flags := flag.NewFlagSet("export-site", flag.ExitOnError)
+	storeFlags := addStoreFlags(flags)
+	output := flags.String("output", "", "directory, or s3://<bucket>/<prefix>, to write the site to (required)")
+	from := flags.String("from", "", "first serve date to export (default the earliest stored)")
+	to := flags.String("to", "", "last serve date to export (default the latest stored)")
+	html := flags.Bool("html", false, "also write an HTML page for each day")
+	flags.Parse(args)
+
+	if *output == "" {
+		return fmt.Errorf("--output is required")
+	}
+	target, err := site.Open(*output)
+	if err != nil {
+		return err
+	}
+	var start, end string
+	for _, date := range []struct {
+		name  string
+		value string
+		dest  *string
+	}{{"from", *from, &start}, {"to", *to, &end}} {
+		if date.value == "" {
+			continue
+		}
+		t, err := parseDate(date.name, date.value)
+		if err != nil {
+			return err
+		}
+		*date.dest = t.Format(huds.ServeDateLayout)
+	}
+
+	a, err := setup(storeFlags)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	ctx, cancel := a.context()
+	defer cancel()
+	earliest, latest, err := a.store.EarliestLatest(ctx)
+	if err != nil {
+		return err
+	}
+	if start == "" {
+		start = earliest
+	}
+	if end == "" {
+		end = latest
+	}
+	var menus []huds.CondensedMenu
+	if start != "" && end != "" {
+		if menus, err = a.store.GetRange(ctx, start, end); err != nil {
+			return err
+		}
+	}
+
+	index := siteIndex{Dates: []string{}, Updated: time.Now().UTC()}
+	type siteDay struct {
+		date time.Time
+		menu huds.CondensedMenu
+	}
+	days := make([]siteDay, 0, len(menus))
+	for _, menu := range menus {
+		day, err := time.Parse(huds.ServeDateLayout, menu.ServeDate)
+		if err != nil {
+			log.Printf("Skipping menu with malformed serve date %q\n", menu.ServeDate)
+			continue
+		}
+		days = append(days, siteDay{day, menu})
+		index.Dates = append(index.Dates, day.Format("2006-01-02"))
+	}
+	if len(index.Dates) > 0 {
+		index.Earliest, index.Latest = index.Dates[0], index.Dates[len(index.Dates)-1]
+	}
+
+	for i, day := range days {
+		data, err := json.MarshalIndent(day.menu, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := target.Write(ctx, "huds-data/"+index.Dates[i]+".json", "application/json", data); err != nil {
+			return err
+		}
+		if !*html {
+			continue
+		}
+		page, err := sitePage(day.date, &day.menu, index.Dates, i, "")
+		if err != nil {
+			return err
+		}
+		if err := target.Write(ctx, "menu/"+index.Dates[i]+".html", "text/html; charset=utf-8", page); err != nil {
+			return err
+		}
+		if i == len(days)-1 {
+			page, err := sitePage(day.date, &day.menu, index.Dates, i, "menu/")
+			if err != nil {
+				return err
+			}
+			if err := target.Write(ctx, "index.html", "text/html; charset=utf-8", page); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := target.Write(ctx, "dates.json", "application/json", data); err != nil {
+		return err
+	}
+	log.Printf("Exported %d days to %s\n", len(index.Dates), *output)
+	return nil
+}
+
+// sitePage renders the page for dates[i], linking to the days exported either
+// side of it. dir is where the day pages are relative to the page.
+func sitePage(day time.Time, menu *huds.CondensedMenu, dates []string, i int, dir string) ([]byte, error) {
+	var links api.MenuPageLinks
+	if i > 0 {
+		links.Previous = dir + dates[i-1] + ".html"
+	}
+	if i < len(dates)-1 {
+		links.Next = dir + dates[i+1] + ".html"
+	}
+	var buf bytes.Buffer
+	if err := api.WriteMenuPage(&buf, day, menu, links); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"hudsgry-api/internal/store"
+	"log"
+	"os"
+)
+
+// importBackup restores a backup written by export --out, replacing what is
+// stored for the days it covers.
+func importBackup(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	storeFlags := addStoreFlags(flags)
+	in := flags.String("in", "", "backup file to restore, gzipped or not")
+	flags.Parse(args)
+
+	if *in == "" {
+		return fmt.Errorf("--in is required")
+	}
+	file, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	backup, err := store.ReadBackup(file)
+	if err != nil {
+		return err
+	}
+
+	a, err := setup(storeFlags)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	ctx, cancel := a.context()
+	defer cancel()
+	days, err := a.server.Restore(ctx, backup)
+	if err != nil {
+		return fmt.Errorf("restored %d of %d days: %w", days, len(backup.Days), err)
+	}
+	log.Printf("Restored %d days from a backup exported at %s\n", days, backup.ExportedAt.Format("2006-01-02 15:04:05"))
+	return nil
+}
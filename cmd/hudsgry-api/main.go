@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/mongo"
+	"hudsgry-api/internal/alerting"
+	"hudsgry-api/internal/api"
+	"hudsgry-api/internal/config"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/provider"
+	"hudsgry-api/internal/reporting"
+	"hudsgry-api/internal/scheduler"
+	"hudsgry-api/internal/store"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const usage = `Usage: hudsgry-api [command] [flags]
+
+Commands:
+  serve         serve the API (the default when no command is given)
+  fetch         fetch menus from HUDS once and store them
+  backfill      fetch and store every date in a range, one day at a time
+  export        write stored menus as JSON or CSV, or a full backup with --out
+  import        restore a backup written by export --out
+  export-site   write stored menus as a static site to a directory or S3
+  sandbox       serve recorded HUDS responses for HUDS_CLIENT=sandbox
+
+Run "hudsgry-api <command> -h" for a command's flags.
+`
+
+var commands = map[string]func(args []string) error{
+	"serve":       serve,
+	"fetch":       fetch,
+	"backfill":    backfill,
+	"export":      export,
+	"import":      importBackup,
+	"export-site": exportSite,
+	"sandbox":     sandbox,
+}
+
+func main() {
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found")
+	}
+
+	// Plain "hudsgry-api --storage=..." still serves, as it always has
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		fmt.Print(usage)
+		return
+	}
+	command, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", name, usage)
+		os.Exit(2)
+	}
+	if err := command(args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// storeFlags are the flags every command has for picking and seeding the
+// store.
+type storeFlags struct {
+	config      *string
+	storage     *string
+	fixture     *string
+	skipIndexes *bool
+	// readOnly is set by commands, such as a dry run, that must not write:
+	// the store is opened read-only, without the shadow store, and isn't
+	// seeded
+	readOnly bool
+}
+
+func addStoreFlags(flags *flag.FlagSet) storeFlags {
+	return storeFlags{
+		config:  flags.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML config file; environment variables override it"),
+		storage: flags.String("storage", "", "menu storage backend: mongo, postgres, sqlite or memory (default $MENU_STORE)"),
+		fixture: flags.String("fixture", "", "saved HUDS API response to seed menus from on startup (default $MENU_FIXTURE)"),
+		// Index builds on a large collection can take a while and may be
+		// better left to a maintenance window
+		skipIndexes: flags.Bool("skip-index-create", false, "only check that MongoDB indexes exist instead of creating missing ones (default $SKIP_INDEX_CREATE)"),
+	}
+}
+
+// app is the service wired up from the config file and environment, shared by
+// every command.
+type app struct {
+	server   *api.Server
+	store    store.MenuStore
+	refresh  scheduler.RefreshConfig
+	tls      api.TLSConfig
+	timeouts api.Timeouts
+	close    func()
+}
+
+// context bounds a command's own storage work.
+func (a *app) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), a.timeouts.Job)
+}
+
+func setup(flags storeFlags) (*app, error) {
+	// The file only fills in what the environment leaves unset
+	if *flags.config != "" {
+		if err := config.Load(*flags.config); err != nil {
+			return nil, err
+		}
+	}
+	storage, fixture := *flags.storage, *flags.fixture
+	if storage == "" {
+		storage = os.Getenv("MENU_STORE")
+	}
+	if fixture == "" {
+		fixture = os.Getenv("MENU_FIXTURE")
+	}
+	skipIndexes := *flags.skipIndexes || os.Getenv("SKIP_INDEX_CREATE") == "true"
+	timeouts, err := api.LoadTimeouts()
+	if err != nil {
+		return nil, err
+	}
+
+	uri := os.Getenv("MONGODB_URI")
+
+	if uri == "" && (storage == "" || storage == "mongo") {
+		return nil, fmt.Errorf("You must set your 'MONGODB_URI' environmental variable. See\n\t https://www.mongodb.com/docs/drivers/go/current/usage-examples/#environment-variable")
+	}
+
+	// Without MongoDB only the menu endpoints are served
+	a := &app{timeouts: timeouts, close: func() {}}
+	var client *mongo.Client
+	var db *mongo.Database
+	if uri != "" {
+		clientOptions, err := store.LoadMongoClientOptions(uri)
+		if err != nil {
+			return nil, err
+		}
+		client, err = mongo.Connect(context.Background(), clientOptions)
+		if err != nil {
+			return nil, err
+		}
+		a.close = func() {
+			ctx, cancel := context.WithTimeout(context.Background(), timeouts.DB)
+			defer cancel()
+			if err := client.Disconnect(ctx); err != nil {
+				log.Printf("Failed to disconnect from MongoDB: %v\n", err)
+			}
+		}
+		db = client.Database("huds")
+	}
+
+	ctx, cancel := a.context()
+	defer cancel()
+	opts := store.OpenOptions{CreateIndexes: !skipIndexes, ReadOnly: flags.readOnly}
+	a.store, err = store.Open(ctx, storage, client, opts)
+	if err != nil {
+		return nil, err
+	}
+	var shadow *store.Shadow
+	if backend := os.Getenv("SHADOW_STORE"); backend != "" && !flags.readOnly {
+		shadow, err = store.OpenShadow(ctx, backend, client, opts)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Writing to a %s shadow store alongside the primary\n", backend)
+	}
+
+	retry, err := huds.LoadRetryPolicy()
+	if err != nil {
+		return nil, err
+	}
+	upstream, err := huds.LoadClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	dining, err := provider.Load()
+	if err != nil {
+		return nil, err
+	}
+	meals, err := huds.LoadMealMapping()
+	if err != nil {
+		return nil, err
+	}
+	huds.UseMealMapping(meals)
+	densityWeights, err := huds.LoadDensityWeights()
+	if err != nil {
+		return nil, err
+	}
+	huds.UseDensityWeights(densityWeights)
+	a.refresh, err = scheduler.LoadRefreshConfig()
+	if err != nil {
+		return nil, err
+	}
+	clock, err := scheduler.LoadClock()
+	if err != nil {
+		return nil, err
+	}
+	dateFormat, err := api.LoadDateFormat()
+	if err != nil {
+		return nil, err
+	}
+	a.tls, err = api.LoadTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	reporter, err := reporting.Load()
+	if err != nil {
+		return nil, err
+	}
+	alerter, err := alerting.Load()
+	if err != nil {
+		return nil, err
+	}
+	menuCache, err := store.LoadFileCache()
+	if err != nil {
+		return nil, err
+	}
+	retention, err := store.LoadRetentionPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	a.server = api.New(api.Options{
+		Store:        a.store,
+		Provider:     dining,
+		Retry:        retry,
+		FetchTimeout: upstream.Timeout,
+		Clock:        clock,
+		Refresh:      a.refresh,
+		DateFormat:   dateFormat,
+		Timeouts:     timeouts,
+		Mongo:        db,
+		MenuCache:    menuCache,
+		SkipIndexes:  skipIndexes || flags.readOnly,
+		Reporter:     reporter,
+		Alerter:      alerter,
+		Retention:    retention,
+		Shadow:       shadow,
+		ReloadConfig: func() error {
+			if *flags.config == "" {
+				return nil
+			}
+			return config.Load(*flags.config)
+		},
+	})
+
+	if fixture != "" && !flags.readOnly {
+		if err := a.server.SeedFromFixture(fixture); err != nil {
+			return nil, fmt.Errorf("Failed to seed menus from %s: %v", fixture, err)
+		}
+	}
+	return a, nil
+}
+
+// parseDate accepts a serve date as MM/DD/YYYY or YYYY-MM-DD.
+func parseDate(name string, value string) (time.Time, error) {
+	for _, layout := range []string{huds.ServeDateLayout, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("--%s must be MM/DD/YYYY or YYYY-MM-DD, got %q", name, value)
+}
+
+func serve(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	storeFlags := addStoreFlags(flags)
+	reprocess := flags.Bool("reprocess", false, "rebuild every stored menu from the raw HUDS archive on startup")
+	flags.Parse(args)
+
+	a, err := setup(storeFlags)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	addr := ":8080"
+	if port := os.Getenv("PORT"); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("PORT must be a number between 1 and 65535, got %q", port)
+		}
+		addr = ":" + port
+	}
+
+	if *reprocess {
+		if err := a.server.Reprocess(time.Time{}, time.Now().AddDate(1, 0, 0)); err != nil {
+			return fmt.Errorf("Failed to reprocess raw HUDS data: %v", err)
+		}
+	}
+	ctx, cancel := a.context()
+	defer cancel()
+	storedEarliest, _, err := a.store.EarliestLatest(ctx)
+	if err != nil {
+		return err
+	}
+
+	// By default, fetch data only if there is no data in the database
+	if a.refresh.ShouldFetchOnStart(storedEarliest == "") {
+		log.Println("Fetching and processing data on start...")
+		if err := a.server.Refresh(); err != nil {
+			log.Printf("Failed to fetch HUDS data: %v\n", err)
+		} else {
+			log.Println("Fetched HUDS data successfully (in main)")
+		}
+	}
+
+	// SIGHUP reloads what can change without a restart
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if _, err := a.server.Reload(); err != nil {
+				log.Printf("Failed to reload configuration: %v\n", err)
+			}
+		}
+	}()
+
+	return a.server.RunTLS(addr, a.tls)
+}
+
+// fetch refreshes once, like the nightly job, or just one date.
+func fetch(args []string) error {
+	flags := flag.NewFlagSet("fetch", flag.ExitOnError)
+	storeFlags := addStoreFlags(flags)
+	date := flags.String("date", "", "only fetch this serve date (default the whole feed)")
+	dryRun := flags.Bool("dry-run", false, "print what would be written and how it differs from what is stored, without writing")
+	flags.Parse(args)
+
+	dates := provider.DateRange{}
+	if *date != "" {
+		day, err := parseDate("date", *date)
+		if err != nil {
+			return err
+		}
+		dates = provider.Day(day)
+	}
+
+	storeFlags.readOnly = *dryRun
+	a, err := setup(storeFlags)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+	if *dryRun {
+		report, err := a.server.DryRun(dates)
+		if err != nil {
+			return err
+		}
+		printDryRun(report)
+		return nil
+	}
+	return a.server.Fetch(dates)
+}
+
+func backfill(args []string) error {
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	storeFlags := addStoreFlags(flags)
+	from := flags.String("from", "", "first serve date to fetch (required)")
+	to := flags.String("to", "", "last serve date to fetch (default --from)")
+	dryRun := flags.Bool("dry-run", false, "print what would be written and how it differs from what is stored, without writing")
+	flags.Parse(args)
+
+	if *from == "" {
+		return fmt.Errorf("--from is required")
+	}
+	if *to == "" {
+		*to = *from
+	}
+	start, err := parseDate("from", *from)
+	if err != nil {
+		return err
+	}
+	end, err := parseDate("to", *to)
+	if err != nil {
+		return err
+	}
+	if end.Before(start) {
+		return fmt.Errorf("--to must not be before --from")
+	}
+
+	storeFlags.readOnly = *dryRun
+	a, err := setup(storeFlags)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+	if *dryRun {
+		var report api.DryRunReport
+		for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+			daily, err := a.server.DryRun(provider.Day(day))
+			if err != nil {
+				log.Printf("Failed to fetch %s: %v\n", day.Format(huds.ServeDateLayout), err)
+				continue
+			}
+			report.Fetched += daily.Fetched
+			report.Days = append(report.Days, daily.Days...)
+		}
+		printDryRun(report)
+		return nil
+	}
+	return a.server.Backfill(start, end)
+}
+
+// printDryRun writes a dry run's report to stdout, one line per serve date
+// followed by the food names each changed meal would gain (+) or lose (-).
+func printDryRun(report api.DryRunReport) {
+	counts := make(map[string]int)
+	for _, day := range report.Days {
+		counts[day.Status]++
+		meals := make([]string, 0, len(day.Meals))
+		for _, meal := range day.Meals {
+			meals = append(meals, fmt.Sprintf("%s %d", meal.Meal, meal.Items))
+		}
+		fmt.Printf("%s  %-9s  %s\n", day.ServeDate, day.Status, strings.Join(meals, ", "))
+		if day.Status != api.DryRunChanged {
+			continue
+		}
+		for _, change := range day.Changes {
+			for _, name := range change.Added {
+				fmt.Printf("    + %s: %s\n", change.Meal, name)
+			}
+			for _, name := range change.Removed {
+				fmt.Printf("    - %s: %s\n", change.Meal, name)
+			}
+		}
+	}
+	fmt.Printf("Dry run: %d upstream items, %d serve dates (%d new, %d changed, %d unchanged); nothing was written\n",
+		report.Fetched, len(report.Days), counts[api.DryRunNew], counts[api.DryRunChanged], counts[api.DryRunUnchanged])
+}
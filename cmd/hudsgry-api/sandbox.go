@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"hudsgry-api/internal/huds"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// sandbox serves recorded HUDS responses over HTTP, for staging servers run
+// with HUDS_CLIENT=sandbox to fetch from instead of the production API.
+func sandbox(args []string) error {
+	dir := os.Getenv("HUDS_FIXTURE_DIR")
+	if dir == "" {
+		dir = "testdata/huds"
+	}
+	flags := flag.NewFlagSet("sandbox", flag.ExitOnError)
+	addr := flags.String("addr", "", "address to listen on (default the host and port of HUDS_SANDBOX_URL, or :8089)")
+	fixtures := flags.String("dir", dir, "directory of recorded responses, as written by HUDS_CLIENT=record")
+	flags.Parse(args)
+
+	endpoint := os.Getenv("HUDS_SANDBOX_URL")
+	if endpoint == "" {
+		endpoint = huds.DefaultSandboxURL
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("HUDS_SANDBOX_URL must be an http or https URL, got %q", endpoint)
+	}
+	if *addr == "" {
+		*addr = ":" + u.Port()
+		if u.Port() == "" {
+			*addr = ":8089"
+		}
+	}
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, huds.SandboxHandler(*fixtures))
+	log.Printf("Serving HUDS fixtures from %s at %s%s\n", *fixtures, *addr, path)
+	return http.ListenAndServe(*addr, mux)
+}
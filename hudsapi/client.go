@@ -0,0 +1,198 @@
+// Package hudsapi is a Go client for hudsgry-api, for services and bots that
+// want menus without hand-rolling HTTP calls and JSON structs.
+package hudsapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the public deployment.
+const DefaultBaseURL = "https://hudsgry-api.fly.dev"
+
+const serveDateLayout = "01/02/2006"
+
+// apiPrefix pins the client to the API version its models describe.
+const apiPrefix = "/v1"
+
+// ErrNotFound is returned when there is no menu for the requested date.
+var ErrNotFound = errors.New("hudsapi: not found")
+
+// Error is an error response from the API.
+type Error struct {
+	StatusCode int
+	// Code is the API's machine-readable error code, e.g. DATE_OUT_OF_RANGE
+	Code    string
+	Message string
+	// RequestID identifies the request in the API's logs
+	RequestID string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("hudsapi: %d %s", e.StatusCode, e.Message)
+}
+
+// Client calls hudsgry-api. GET requests that fail with a network error, 429
+// or 5xx are retried with exponential backoff.
+type Client struct {
+	BaseURL string
+	// Token is a session token from POST /sessions, needed for anything under /me
+	Token      string
+	HTTPClient *http.Client
+	// Retries is how many times a failed GET is retried
+	Retries   int
+	BaseDelay time.Duration
+}
+
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		Retries:    3,
+		BaseDelay:  500 * time.Millisecond,
+	}
+}
+
+// GetMenu returns the house default menu served on date.
+func (c *Client) GetMenu(ctx context.Context, date time.Time) (Menu, error) {
+	var menu Menu
+	query := url.Values{"serve_date": {date.Format(serveDateLayout)}}
+	err := c.do(ctx, http.MethodGet, "/huds-data", query, nil, &menu)
+	return menu, err
+}
+
+// GetMenuVersion returns the content hash of the menu stored for date, which
+// changes whenever the menu does. Polling it is far cheaper than GetMenu.
+func (c *Client) GetMenuVersion(ctx context.Context, date time.Time) (MenuVersion, error) {
+	var version MenuVersion
+	query := url.Values{"serve_date": {date.Format(serveDateLayout)}}
+	err := c.do(ctx, http.MethodGet, "/huds-data/version", query, nil, &version)
+	return version, err
+}
+
+// GetIngredients returns the ingredients of an item by its ID, as of the most
+// recent day it was served.
+func (c *Client) GetIngredients(ctx context.Context, id int) (ItemIngredients, error) {
+	var ingredients ItemIngredients
+	err := c.do(ctx, http.MethodGet, "/items/"+strconv.Itoa(id)+"/ingredients", nil, nil, &ingredients)
+	return ingredients, err
+}
+
+// Search ranks served items against a free-text query, best match first.
+func (c *Client) Search(ctx context.Context, q string, opts SearchOptions) ([]SearchResult, error) {
+	query := url.Values{"q": {q}}
+	if !opts.Start.IsZero() {
+		query.Set("start", opts.Start.Format(serveDateLayout))
+	}
+	if !opts.End.IsZero() {
+		query.Set("end", opts.End.Format(serveDateLayout))
+	}
+	if opts.Meal != "" {
+		query.Set("meal", opts.Meal)
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	var resp struct {
+		Results []SearchResult `json:"results"`
+	}
+	err := c.do(ctx, http.MethodGet, "/search", query, nil, &resp)
+	return resp.Results, err
+}
+
+// SubscribeWebhook registers target to receive a WebhookDelivery with every
+// batch of menu changes. It needs Token.
+func (c *Client) SubscribeWebhook(ctx context.Context, target string) (Webhook, error) {
+	var hook Webhook
+	err := c.do(ctx, http.MethodPost, "/me/webhooks", nil, map[string]string{"url": target}, &hook)
+	return hook, err
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body interface{}, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	endpoint := c.BaseURL + apiPrefix + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	attempts := 1
+	if method == http.MethodGet {
+		attempts += c.Retries
+	}
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(c.BaseDelay << (attempt - 1)):
+			}
+		}
+		var retry bool
+		retry, err = c.attempt(ctx, method, endpoint, payload, out)
+		if !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// attempt makes one request, reporting whether a failure is worth retrying.
+func (c *Client) attempt(ctx context.Context, method string, endpoint string, payload []byte, out interface{}) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error     string `json:"error"`
+			Code      string `json:"code"`
+			RequestID string `json:"request_id"`
+		}
+		data, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(data, &apiErr) != nil || apiErr.Error == "" {
+			apiErr.Error = http.StatusText(resp.StatusCode)
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			return false, fmt.Errorf("%w: %s", ErrNotFound, apiErr.Error)
+		}
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, &Error{StatusCode: resp.StatusCode, Code: apiErr.Code, Message: apiErr.Error, RequestID: apiErr.RequestID}
+	}
+	if out == nil {
+		return false, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return false, fmt.Errorf("hudsapi: failed to decode response: %v", err)
+	}
+	return false, nil
+}
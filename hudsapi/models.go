@@ -0,0 +1,189 @@
+package hudsapi
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// MenuItem is one dish as served by the API.
+type MenuItem struct {
+	ID                 int     `json:"ID,omitempty"`
+	FoodName           string  `json:"Food_Name"`
+	MenuCategory       string  `json:"Menu_Category_Name"`
+	RecipeNumber       string  `json:"Recipe_Number,omitempty"`
+	Allergens          string  `json:"Allergens"`
+	Ingredients        string  `json:"Ingredient_List,omitempty"`
+	HouseLocation      bool    `json:"House_Location"`
+	MealNumber         *int    `json:"Meal_Number,omitempty"`
+	ServeDate          *string `json:"Serve_Date,omitempty"`
+	Vegan              bool    `json:"Vegan"`
+	Vegetarian         bool    `json:"Vegetarian"`
+	Halal              bool    `json:"Halal"`
+	GlutenFree         bool    `json:"Gluten_Free"`
+	WholeGrain         bool    `json:"Whole_Grain"`
+	Local              bool    `json:"Local"`
+	SustainableSeafood bool    `json:"Sustainable_Seafood"`
+	ServingSize        string  `json:"Serving_Size,omitempty"`
+	Calories           string  `json:"Calories"`
+	Cholesterol        string  `json:"Cholesterol,omitempty"`
+	DietaryFiber       string  `json:"Dietary_Fiber,omitempty"`
+	Protein            string  `json:"Protein,omitempty"`
+	SatFat             string  `json:"Sat_Fat,omitempty"`
+	Sodium             string  `json:"Sodium,omitempty"`
+	Sugars             string  `json:"Sugars,omitempty"`
+	TotalCarb          string  `json:"Total_Carb,omitempty"`
+	TotalFat           string  `json:"Total_Fat,omitempty"`
+	TransFat           string  `json:"Trans_Fat,omitempty"`
+	// Nutrition and Serving are only set when requested with
+	// include=nutrition
+	Nutrition *NutritionFacts `json:"nutrition,omitempty"`
+	Serving   *Serving        `json:"serving,omitempty"`
+	// PortionCost and SellingPrice are in dollars, only set when requested
+	// with include=prices and HUDS has costed the dish
+	PortionCost  *float64 `json:"Portion_Cost,omitempty"`
+	SellingPrice *float64 `json:"Selling_Price,omitempty"`
+	// DerivedAllergens are allergens found in the dish's ingredients that
+	// Allergens doesn't list
+	DerivedAllergens []DerivedAllergen `json:"derived_allergens,omitempty"`
+}
+
+// DerivedAllergen is an allergen found in an ingredient list. Confidence is
+// "high" when the ingredient names it outright and "low" when it only often
+// contains it, e.g. flour.
+type DerivedAllergen struct {
+	Allergen   string `json:"allergen"`
+	Ingredient string `json:"ingredient"`
+	Confidence string `json:"confidence"`
+}
+
+// Amount is a nutrition value parsed into a number and its unit.
+type Amount struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+}
+
+// Serving is a dish's Serving_Size parsed. Grams or Milliliters are set when
+// the unit gives the serving's weight or volume.
+type Serving struct {
+	Quantity    float64  `json:"quantity"`
+	Unit        string   `json:"unit"`
+	Grams       *float64 `json:"grams,omitempty"`
+	Milliliters *float64 `json:"ml,omitempty"`
+}
+
+// NutritionFacts are a dish's nutrition values as numbers. Values HUDS didn't
+// give are nil.
+type NutritionFacts struct {
+	Calories     *Amount `json:"calories,omitempty"`
+	TotalFat     *Amount `json:"total_fat,omitempty"`
+	SatFat       *Amount `json:"sat_fat,omitempty"`
+	TransFat     *Amount `json:"trans_fat,omitempty"`
+	Cholesterol  *Amount `json:"cholesterol,omitempty"`
+	Sodium       *Amount `json:"sodium,omitempty"`
+	TotalCarb    *Amount `json:"total_carb,omitempty"`
+	DietaryFiber *Amount `json:"dietary_fiber,omitempty"`
+	Sugars       *Amount `json:"sugars,omitempty"`
+	Protein      *Amount `json:"protein,omitempty"`
+}
+
+// Menu is the house default menu for one day. Meal periods beyond breakfast,
+// lunch and dinner (e.g. "Brain_Break") are collected in Extra by their
+// response key.
+type Menu struct {
+	ServeDate string                `json:"Serve_Date,omitempty"`
+	Breakfast []MenuItem            `json:"Breakfast"`
+	Lunch     []MenuItem            `json:"Lunch"`
+	Dinner    []MenuItem            `json:"Dinner"`
+	Extra     map[string][]MenuItem `json:"-"`
+}
+
+func (m *Menu) UnmarshalJSON(data []byte) error {
+	// plain drops the UnmarshalJSON method so the default decoding is used
+	type plain Menu
+	if err := json.Unmarshal(data, (*plain)(m)); err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for key, raw := range fields {
+		switch key {
+		case "Serve_Date", "Breakfast", "Lunch", "Dinner":
+			continue
+		}
+		var items []MenuItem
+		if err := json.Unmarshal(raw, &items); err != nil {
+			continue
+		}
+		if m.Extra == nil {
+			m.Extra = make(map[string][]MenuItem)
+		}
+		m.Extra[key] = items
+	}
+	return nil
+}
+
+// MenuVersion identifies the menu stored for a day.
+type MenuVersion struct {
+	ServeDate   string    `json:"Serve_Date"`
+	Hash        string    `json:"hash"`
+	LastUpdated time.Time `json:"last_updated"`
+}
+
+// ItemIngredients is everything HUDS says about what is in an item.
+type ItemIngredients struct {
+	ID                 int             `json:"ID"`
+	FoodName           string          `json:"Food_Name"`
+	ServeDate          string          `json:"Serve_Date"`
+	Meal               string          `json:"meal"`
+	Allergens          string          `json:"Allergens"`
+	IngredientList     string          `json:"Ingredient_List"`
+	ProductInformation string          `json:"Recipe_Product_Information"`
+	Ingredients        []string        `json:"ingredients"`
+	Nutrition          *NutritionFacts `json:"nutrition"`
+}
+
+// SearchOptions narrow a search. Zero values leave that filter off.
+type SearchOptions struct {
+	Start time.Time
+	End   time.Time
+	// Meal is breakfast, lunch or dinner
+	Meal  string
+	Limit int
+}
+
+// SearchResult is one item served at one meal on one day, with its text
+// relevance score.
+type SearchResult struct {
+	ID           int     `json:"ID,omitempty"`
+	ServeDate    string  `json:"Serve_Date"`
+	Meal         string  `json:"meal"`
+	FoodName     string  `json:"Food_Name"`
+	MenuCategory string  `json:"Menu_Category_Name"`
+	Vegan        bool    `json:"Vegan"`
+	Vegetarian   bool    `json:"Vegetarian"`
+	Score        float64 `json:"score"`
+}
+
+// Webhook is a URL that receives a POST with every batch of menu changes.
+type Webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is the body POSTed to a webhook with each batch of changes.
+type WebhookDelivery struct {
+	Events []MenuChange `json:"events"`
+}
+
+// MenuChange describes how one meal on one day changed.
+type MenuChange struct {
+	Type       string    `json:"type"`
+	ServeDate  string    `json:"Serve_Date"`
+	Meal       string    `json:"meal"`
+	Added      []string  `json:"added"`
+	Removed    []string  `json:"removed"`
+	DetectedAt time.Time `json:"detected_at"`
+}
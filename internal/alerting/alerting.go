@@ -0,0 +1,120 @@
+// Package alerting tells operators when something needs a person: a Slack
+// channel, PagerDuty and email, whichever are configured.
+package alerting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Alert is one problem, or the news that it has gone away.
+type Alert struct {
+	// Key identifies the problem, so that the alert resolving it closes the
+	// same incident, e.g. "huds-fetch"
+	Key     string
+	Summary string
+	Details string
+	// Resolved is set when the problem has gone away
+	Resolved bool
+	Time     time.Time
+}
+
+// text is the alert as a short message for chat and email.
+func (a Alert) text() string {
+	prefix := "[ALERT]"
+	if a.Resolved {
+		prefix = "[RESOLVED]"
+	}
+	if a.Details == "" {
+		return prefix + " " + a.Summary
+	}
+	return prefix + " " + a.Summary + "\n" + a.Details
+}
+
+// Alerter delivers alerts.
+type Alerter interface {
+	Send(ctx context.Context, alert Alert) error
+}
+
+// Multi sends every alert to each of its alerters, and fails if any of them
+// does.
+type Multi []Alerter
+
+func (m Multi) Send(ctx context.Context, alert Alert) error {
+	var errs []error
+	for _, alerter := range m {
+		if err := alerter.Send(ctx, alert); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Load returns an alerter for every channel configured in the environment,
+// or nil if there are none:
+//
+//   - ALERT_SLACK_WEBHOOK_URL posts to a Slack incoming webhook
+//   - ALERT_PAGERDUTY_ROUTING_KEY triggers and resolves PagerDuty incidents
+//   - ALERT_EMAIL_TO, a comma-separated list, emails through SMTP_HOST,
+//     SMTP_PORT (default 587), SMTP_USERNAME and SMTP_PASSWORD, from
+//     ALERT_EMAIL_FROM (default SMTP_USERNAME)
+func Load() (Alerter, error) {
+	var alerters Multi
+	if url := os.Getenv("ALERT_SLACK_WEBHOOK_URL"); url != "" {
+		if !strings.HasPrefix(url, "https://") {
+			return nil, fmt.Errorf("ALERT_SLACK_WEBHOOK_URL must be an https URL, got %q", url)
+		}
+		alerters = append(alerters, NewSlack(url))
+	}
+	if key := os.Getenv("ALERT_PAGERDUTY_ROUTING_KEY"); key != "" {
+		alerters = append(alerters, NewPagerDuty(key))
+	}
+	if to := os.Getenv("ALERT_EMAIL_TO"); to != "" {
+		host := os.Getenv("SMTP_HOST")
+		if host == "" {
+			return nil, fmt.Errorf("ALERT_EMAIL_TO needs SMTP_HOST")
+		}
+		port := 587
+		if v := os.Getenv("SMTP_PORT"); v != "" {
+			p, err := strconv.Atoi(v)
+			if err != nil || p <= 0 || p > 65535 {
+				return nil, fmt.Errorf("SMTP_PORT must be a port number, got %q", v)
+			}
+			port = p
+		}
+		username := os.Getenv("SMTP_USERNAME")
+		from := os.Getenv("ALERT_EMAIL_FROM")
+		if from == "" {
+			from = username
+		}
+		if from == "" {
+			return nil, fmt.Errorf("ALERT_EMAIL_TO needs ALERT_EMAIL_FROM or SMTP_USERNAME")
+		}
+		var recipients []string
+		for _, address := range strings.Split(to, ",") {
+			if address = strings.TrimSpace(address); address != "" {
+				recipients = append(recipients, address)
+			}
+		}
+		alerters = append(alerters, &Email{
+			Host:     host,
+			Port:     port,
+			Username: username,
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+			To:       recipients,
+		})
+	}
+	switch len(alerters) {
+	case 0:
+		return nil, nil
+	case 1:
+		return alerters[0], nil
+	}
+	return alerters, nil
+}
@@ -0,0 +1,59 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Email sends alerts through an SMTP server, authenticating when a username
+// is set. net/smtp upgrades to TLS when the server offers STARTTLS.
+type Email struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (e *Email) Send(ctx context.Context, alert Alert) error {
+	subject := alert.Summary
+	if alert.Resolved {
+		subject = "Resolved: " + subject
+	}
+	when := alert.Time
+	if when.IsZero() {
+		when = time.Now()
+	}
+	var message strings.Builder
+	fmt.Fprintf(&message, "From: %s\r\n", e.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&message, "Subject: [hudsgry-api] %s\r\n", strings.ReplaceAll(subject, "\n", " "))
+	fmt.Fprintf(&message, "Date: %s\r\n", when.Format(time.RFC1123Z))
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	message.WriteString(strings.ReplaceAll(alert.text(), "\n", "\r\n"))
+	message.WriteString("\r\n")
+
+	var auth smtp.Auth
+	if e.Username != "" {
+		auth = smtp.PlainAuth("", e.Username, e.Password, e.Host)
+	}
+	addr := net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+	// smtp.SendMail takes no context, so it runs aside and is abandoned if
+	// ctx ends first
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(addr, auth, e.From, e.To, []byte(message.String()))
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
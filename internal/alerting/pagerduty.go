@@ -0,0 +1,82 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// pagerDutyEventsURL is the Events API v2 endpoint.
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// PagerDuty triggers an incident for each alert, and resolves it when the
+// alert with the same key is resolved.
+type PagerDuty struct {
+	routingKey string
+	source     string
+	httpClient *http.Client
+}
+
+func NewPagerDuty(routingKey string) *PagerDuty {
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "hudsgry-api"
+	}
+	return &PagerDuty{routingKey: routingKey, source: hostname, httpClient: &http.Client{Timeout: 10 * time.Second}}
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key,omitempty"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	Timestamp     string            `json:"timestamp,omitempty"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+func (p *PagerDuty) Send(ctx context.Context, alert Alert) error {
+	event := pagerDutyEvent{RoutingKey: p.routingKey, EventAction: "trigger", DedupKey: alert.Key}
+	if alert.Resolved {
+		// A resolve without a key has nothing to close
+		if alert.Key == "" {
+			return nil
+		}
+		event.EventAction = "resolve"
+	} else {
+		event.Payload = &pagerDutyPayload{Summary: alert.Summary, Source: p.source, Severity: "critical"}
+		if !alert.Time.IsZero() {
+			event.Payload.Timestamp = alert.Time.UTC().Format(time.RFC3339)
+		}
+		if alert.Details != "" {
+			event.Payload.CustomDetails = map[string]string{"details": alert.Details}
+		}
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, pagerDutyEventsURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("pagerduty responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Slack posts alerts to a Slack incoming webhook.
+type Slack struct {
+	url        string
+	httpClient *http.Client
+}
+
+func NewSlack(url string) *Slack {
+	return &Slack{url: url, httpClient: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *Slack) Send(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(map[string]string{"text": alert.text()})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("slack responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
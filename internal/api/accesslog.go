@@ -0,0 +1,378 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/scheduler"
+	"hudsgry-api/internal/store"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultAccessLogMB is how big the capped collection is made unless
+	// ACCESS_LOG_MAX_MB says otherwise; the oldest entries make way for new
+	// ones once it is full
+	defaultAccessLogMB = 256
+	// accessLogBatch is how many entries are written at once when requests
+	// come in faster than the minutely flush
+	accessLogBatch = 500
+	// maxAccessLogPending bounds the entries held while the store is
+	// unreachable; more than this are dropped
+	maxAccessLogPending = 20000
+
+	defaultAccessLogLimit = 100
+	maxAccessLogLimit     = 1000
+)
+
+// apiKeyIDKey is where trackAPIKey leaves the ID of the key a request was
+// made with.
+const apiKeyIDKey = "api_key_id"
+
+// AccessLogEntry records one request. Unlike telemetry, it says who made the
+// request, so it is only kept when ACCESS_LOG_ENABLED is set.
+type AccessLogEntry struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	Time      time.Time          `json:"time" bson:"time"`
+	RequestID string             `json:"request_id" bson:"request_id"`
+	Method    string             `json:"method" bson:"method"`
+	// Route is the matched route pattern, e.g. /v2/recipes/:number, or
+	// "unmatched"; Path is what was asked for
+	Route     string  `json:"route" bson:"route"`
+	Path      string  `json:"path" bson:"path"`
+	Status    int     `json:"status" bson:"status"`
+	LatencyMs float64 `json:"latency_ms" bson:"latency_ms"`
+	Bytes     int     `json:"bytes" bson:"bytes"`
+	IP        string  `json:"ip" bson:"ip"`
+	KeyID     string  `json:"key_id,omitempty" bson:"key_id,omitempty"`
+	UserID    string  `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	// ServeDate is the menu date requested, for the routes that take one
+	ServeDate string `json:"Serve_Date,omitempty" bson:"serve_date,omitempty"`
+	UserAgent string `json:"user_agent,omitempty" bson:"user_agent,omitempty"`
+}
+
+// AccessLogGroup counts the entries sharing a value of the field grouped by.
+type AccessLogGroup struct {
+	Value        string  `json:"value"`
+	Count        int     `json:"count"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+	Errors       int     `json:"errors"`
+}
+
+// accessLogGroupFields are what /admin/access-logs can group by, with the
+// stored field each is kept in.
+var accessLogGroupFields = map[string]string{
+	"route":      "route",
+	"ip":         "ip",
+	"key_id":     "key_id",
+	"user_id":    "user_id",
+	"status":     "status",
+	"serve_date": "serve_date",
+}
+
+type AccessLog struct {
+	server     *Server
+	collection *mongo.Collection
+
+	mu      sync.Mutex
+	pending []AccessLogEntry
+	dropped int
+}
+
+// startAccessLog creates the capped access log collection, if there isn't one
+// yet, and flushes logged requests to it once a minute.
+func (s *Server) startAccessLog(jobs scheduler.Scheduler) error {
+	megabytes := defaultAccessLogMB
+	if v := os.Getenv("ACCESS_LOG_MAX_MB"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("ACCESS_LOG_MAX_MB must be a positive integer, got %q", v)
+		}
+		megabytes = n
+	}
+
+	ctx, cancel := s.jobContext()
+	defer cancel()
+	err := s.db.CreateCollection(ctx, "access_logs", options.CreateCollection().SetCapped(true).SetSizeInBytes(int64(megabytes)<<20))
+	var commandErr mongo.CommandError
+	// A collection that already exists keeps the size it was created with
+	if err != nil && !(errors.As(err, &commandErr) && commandErr.Name == "NamespaceExists") {
+		log.Printf("Failed to create the access log collection: %v\n", err)
+	}
+	s.accessLog = &AccessLog{server: s, collection: s.db.Collection("access_logs")}
+	s.ensureIndexes("access log",
+		store.IndexSpec{Collection: "access_logs", Model: mongo.IndexModel{Keys: bson.D{{Key: "time", Value: -1}}}},
+		store.IndexSpec{Collection: "access_logs", Model: mongo.IndexModel{Keys: bson.D{{Key: "route", Value: 1}, {Key: "time", Value: -1}}}},
+		store.IndexSpec{Collection: "access_logs", Model: mongo.IndexModel{Keys: bson.D{{Key: "ip", Value: 1}, {Key: "time", Value: -1}}}},
+		store.IndexSpec{Collection: "access_logs", Model: mongo.IndexModel{Keys: bson.D{{Key: "key_id", Value: 1}, {Key: "time", Value: -1}}}},
+	)
+	if _, err := jobs.AddFunc("* * * * *", s.recoverJob("access log", s.accessLog.flush)); err != nil {
+		log.Printf("Failed to schedule access log flush: %v\n", err)
+	}
+	log.Printf("Access logging enabled, keeping up to %d MB\n", megabytes)
+	return nil
+}
+
+func (l *AccessLog) middleware(c *gin.Context) {
+	started := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	entry := AccessLogEntry{
+		ID:        primitive.NewObjectID(),
+		Time:      l.server.clock.Now(),
+		RequestID: c.GetString(requestIDKey),
+		Method:    c.Request.Method,
+		Route:     route,
+		Path:      c.Request.URL.Path,
+		Status:    c.Writer.Status(),
+		LatencyMs: float64(time.Since(started).Microseconds()) / 1000,
+		Bytes:     c.Writer.Size(),
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+	if entry.Bytes < 0 {
+		entry.Bytes = 0
+	}
+	if id, ok := c.Get(apiKeyIDKey); ok {
+		entry.KeyID = id.(primitive.ObjectID).Hex()
+	}
+	if user, ok := c.Get("user"); ok {
+		entry.UserID = user.(User).ID.Hex()
+	}
+	if date, ok := c.Get(serveDateKey); ok {
+		entry.ServeDate = date.(time.Time).Format(huds.ServeDateLayout)
+	}
+
+	l.mu.Lock()
+	if len(l.pending) >= maxAccessLogPending {
+		l.dropped++
+	} else {
+		l.pending = append(l.pending, entry)
+	}
+	full := len(l.pending) == accessLogBatch
+	l.mu.Unlock()
+	if full {
+		go l.server.recoverJob("access log", l.flush)()
+	}
+}
+
+// flush writes the entries logged since the last flush, keeping them for the
+// next one if the write fails.
+func (l *AccessLog) flush() {
+	l.mu.Lock()
+	pending, dropped := l.pending, l.dropped
+	l.pending, l.dropped = nil, 0
+	l.mu.Unlock()
+	if dropped > 0 {
+		log.Printf("Dropped %d access log entries while the store was behind\n", dropped)
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	docs := make([]interface{}, len(pending))
+	for i, entry := range pending {
+		docs[i] = entry
+	}
+	ctx, cancel := l.server.jobContext()
+	defer cancel()
+	if _, err := l.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false)); err != nil {
+		log.Printf("Failed to write access log entries: %v\n", err)
+		if mongo.IsDuplicateKeyError(err) {
+			return
+		}
+		l.mu.Lock()
+		if room := maxAccessLogPending - len(l.pending); room > 0 {
+			if len(pending) > room {
+				l.dropped += len(pending) - room
+				pending = pending[len(pending)-room:]
+			}
+			l.pending = append(pending, l.pending...)
+		} else {
+			l.dropped += len(pending)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// accessLogFilter builds the query for /admin/access-logs from its filters,
+// answering 400 itself when one is invalid.
+func (s *Server) accessLogFilter(c *gin.Context) (bson.M, bool) {
+	filter := bson.M{}
+	for _, name := range []string{"route", "ip", "key_id", "user_id", "method"} {
+		if value := strings.TrimSpace(c.Query(name)); value != "" {
+			if name == "method" {
+				value = strings.ToUpper(value)
+			}
+			filter[name] = value
+		}
+	}
+	if value := c.Query("status"); value != "" {
+		// 4xx and 5xx match a whole class of statuses
+		if class := strings.TrimSuffix(strings.ToLower(value), "xx"); len(class) == 1 && class != value {
+			n, err := strconv.Atoi(class)
+			if err != nil || n < 1 || n > 5 {
+				respondErrorDetails(c, http.StatusBadRequest, CodeInvalidRequest, "status must be a status code or a class such as 5xx", gin.H{"status": value})
+				return nil, false
+			}
+			filter["status"] = bson.M{"$gte": n * 100, "$lt": (n + 1) * 100}
+		} else {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 100 || n > 599 {
+				respondErrorDetails(c, http.StatusBadRequest, CodeInvalidRequest, "status must be a status code or a class such as 5xx", gin.H{"status": value})
+				return nil, false
+			}
+			filter["status"] = n
+		}
+	}
+	date, ok := s.queryDate(c, "serve_date")
+	if !ok {
+		return nil, false
+	}
+	if !date.IsZero() {
+		filter["serve_date"] = date.Format(huds.ServeDateLayout)
+	}
+	if value := c.Query("min_latency_ms"); value != "" {
+		ms, err := strconv.ParseFloat(value, 64)
+		if err != nil || ms < 0 {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "min_latency_ms must be a non-negative number")
+			return nil, false
+		}
+		filter["latency_ms"] = bson.M{"$gte": ms}
+	}
+	window := bson.M{}
+	for param, op := range map[string]string{"since": "$gte", "until": "$lt"} {
+		if value := c.Query(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				respondErrorDetails(c, http.StatusBadRequest, CodeInvalidRequest, param+" must be an RFC 3339 time", gin.H{param: value})
+				return nil, false
+			}
+			window[op] = t
+		}
+	}
+	if len(window) > 0 {
+		filter["time"] = window
+	}
+	if value := c.Query("before"); value != "" {
+		id, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "before must be the id of an entry")
+			return nil, false
+		}
+		filter["_id"] = bson.M{"$lt": id}
+	}
+	return filter, true
+}
+
+// handleAdminAccessLogs lists logged requests newest first, filtered by
+// route, ip, key_id, user_id, method, status, serve_date, min_latency_ms and a
+// since/until window. Passing the last entry's id as before pages back
+// through older entries. With group_by, it counts the matching entries by
+// that field instead, most requested first.
+func (s *Server) handleAdminAccessLogs(c *gin.Context) {
+	if s.accessLog == nil {
+		respondError(c, http.StatusNotImplemented, CodeNotImplemented, "access logging is off; set ACCESS_LOG_ENABLED=true")
+		return
+	}
+	filter, ok := s.accessLogFilter(c)
+	if !ok {
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultAccessLogLimit)))
+	if err != nil || limit < 1 || limit > maxAccessLogLimit {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "limit must be between 1 and "+strconv.Itoa(maxAccessLogLimit))
+		return
+	}
+	// Entries still waiting for the minutely flush should show up too
+	s.accessLog.flush()
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	if groupBy := c.Query("group_by"); groupBy != "" {
+		field, ok := accessLogGroupFields[groupBy]
+		if !ok {
+			respondErrorDetails(c, http.StatusBadRequest, CodeInvalidRequest, "group_by must be route, ip, key_id, user_id, status or serve_date", gin.H{"group_by": groupBy})
+			return
+		}
+		groups, err := s.accessLog.groups(ctx, filter, field, limit)
+		if err != nil {
+			log.Printf("Failed to group access logs: %v\n", err)
+			respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load access logs")
+			return
+		}
+		respond(c, http.StatusOK, gin.H{"group_by": groupBy, "groups": groups}, ResponseMeta{})
+		return
+	}
+
+	cursor, err := s.accessLog.collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(limit)))
+	if err != nil {
+		log.Printf("Failed to query access logs: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load access logs")
+		return
+	}
+	entries := []AccessLogEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		log.Printf("Failed to decode access logs: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load access logs")
+		return
+	}
+	result := gin.H{"entries": entries}
+	if len(entries) == limit {
+		result["next_before"] = entries[len(entries)-1].ID.Hex()
+	}
+	respond(c, http.StatusOK, result, ResponseMeta{})
+}
+
+// groups counts the entries matching filter by field, most first.
+func (l *AccessLog) groups(ctx context.Context, filter bson.M, field string, limit int) ([]AccessLogGroup, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{
+			"_id":         "$" + field,
+			"count":       bson.M{"$sum": 1},
+			"avg_latency": bson.M{"$avg": "$latency_ms"},
+			"errors":      bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$gte": bson.A{"$status", 500}}, 1, 0}}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+	}
+	cursor, err := l.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var docs []struct {
+		Value      interface{} `bson:"_id"`
+		Count      int         `bson:"count"`
+		AvgLatency float64     `bson:"avg_latency"`
+		Errors     int         `bson:"errors"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	groups := make([]AccessLogGroup, len(docs))
+	for i, doc := range docs {
+		value := ""
+		if doc.Value != nil {
+			value = fmt.Sprint(doc.Value)
+		}
+		groups[i] = AccessLogGroup{Value: value, Count: doc.Count, AvgLatencyMs: round1(doc.AvgLatency), Errors: doc.Errors}
+	}
+	return groups, nil
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"crypto/subtle"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// serviceStats are the in-process counters behind /admin/stats. They reset
+// when the process restarts.
+type serviceStats struct {
+	sync.Mutex
+	startedAt         time.Time
+	lastFetch         time.Time
+	lastFetchDuration time.Duration
+	lastFetchFailure  time.Time
+	lastFetchError    string
+	fetchFailures     int
+	cacheHits         int
+	cacheMisses       int
+	// warm counts lookups of the days held around today, and fileCache
+	// lookups of the local file when the store failed
+	warmHits        int
+	warmMisses      int
+	fileCacheHits   int
+	fileCacheMisses int
+	requests        map[string]int
+}
+
+type FetchStats struct {
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	LastDurationSeconds float64    `json:"last_duration_seconds"`
+	LastFailure         *time.Time `json:"last_failure,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	Failures            int        `json:"failures"`
+}
+
+type CacheStats struct {
+	// ServeDate is the day held in the local cache, if any
+	ServeDate     string `json:"Serve_Date,omitempty"`
+	Hits          int    `json:"hits"`
+	Misses        int    `json:"misses"`
+	CatalogLoaded bool   `json:"catalog_loaded"`
+}
+
+type AdminStats struct {
+	StartedAt     time.Time         `json:"started_at"`
+	UptimeSeconds float64           `json:"uptime_seconds"`
+	Documents     map[string]int64  `json:"documents,omitempty"`
+	Fetch         FetchStats        `json:"fetch"`
+	Upstream      huds.BreakerStats `json:"upstream"`
+	Cache         CacheStats        `json:"cache"`
+	Requests      map[string]int    `json:"requests"`
+	Maintenance   MaintenanceStatus `json:"maintenance"`
+	// Retention is omitted when every day is kept
+	Retention *RetentionStatus `json:"retention,omitempty"`
+	// Shadow is omitted unless a shadow store is configured
+	Shadow *store.ShadowStats `json:"shadow,omitempty"`
+}
+
+// recordFetch notes how a full fetch from HUDS went.
+func (s *Server) recordFetch(started time.Time, err error) {
+	s.stats.Lock()
+	defer s.stats.Unlock()
+	now := time.Now()
+	if err != nil {
+		s.stats.fetchFailures++
+		s.stats.lastFetchFailure = now
+		s.stats.lastFetchError = err.Error()
+		return
+	}
+	s.stats.lastFetch = now
+	s.stats.lastFetchDuration = now.Sub(started)
+}
+
+// recordCacheLookup counts whether a request for today was served from the
+// local cache.
+func (s *Server) recordCacheLookup(hit bool) {
+	s.stats.Lock()
+	defer s.stats.Unlock()
+	if hit {
+		s.stats.cacheHits++
+	} else {
+		s.stats.cacheMisses++
+	}
+}
+
+// recordWarmLookup counts whether a day was held in memory.
+func (s *Server) recordWarmLookup(hit bool) {
+	s.stats.Lock()
+	defer s.stats.Unlock()
+	if hit {
+		s.stats.warmHits++
+	} else {
+		s.stats.warmMisses++
+	}
+}
+
+// recordFileCacheLookup counts whether the local file cache had a day the
+// store couldn't serve.
+func (s *Server) recordFileCacheLookup(hit bool) {
+	s.stats.Lock()
+	defer s.stats.Unlock()
+	if hit {
+		s.stats.fileCacheHits++
+	} else {
+		s.stats.fileCacheMisses++
+	}
+}
+
+// countRequests counts requests by method and route pattern, so /v1/items/7
+// and /items/8 count as different routes but /items/7 and /items/8 don't.
+func (s *Server) countRequests(c *gin.Context) {
+	c.Next()
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	s.stats.Lock()
+	s.stats.requests[c.Request.Method+" "+route]++
+	s.stats.Unlock()
+}
+
+// requireAdmin checks the bearer token against ADMIN_TOKEN.
+func (s *Server) requireAdmin(c *gin.Context) {
+	token := bearerToken(c)
+	if token == "" {
+		respondError(c, http.StatusUnauthorized, CodeUnauthorized, "missing bearer token")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+		respondError(c, http.StatusForbidden, CodeForbidden, "invalid admin token")
+		return
+	}
+	c.Next()
+}
+
+// handleAdminStats reports on the service's health: what is stored, how
+// fetches from HUDS have gone, the cache and the routes being used.
+func (s *Server) handleAdminStats(c *gin.Context) {
+	var documents map[string]int64
+	if counter, ok := s.store.(store.Counter); ok {
+		ctx, cancel := s.dbContext(c.Request.Context())
+		defer cancel()
+		var err error
+		if documents, err = counter.Counts(ctx); err != nil {
+			respondStoreError(c, err)
+			return
+		}
+	}
+	cached := s.cachedMenu()
+	s.catalog.Lock()
+	catalogLoaded := s.catalog.menus != nil
+	s.catalog.Unlock()
+
+	s.stats.Lock()
+	stats := AdminStats{
+		StartedAt:     s.stats.startedAt,
+		UptimeSeconds: time.Since(s.stats.startedAt).Seconds(),
+		Documents:     documents,
+		Fetch: FetchStats{
+			LastDurationSeconds: s.stats.lastFetchDuration.Seconds(),
+			LastError:           s.stats.lastFetchError,
+			Failures:            s.stats.fetchFailures,
+		},
+		Upstream: s.breaker.Stats(),
+		Cache: CacheStats{
+			ServeDate:     cached.ServeDate,
+			Hits:          s.stats.cacheHits,
+			Misses:        s.stats.cacheMisses,
+			CatalogLoaded: catalogLoaded,
+		},
+		Requests:    make(map[string]int, len(s.stats.requests)),
+		Maintenance: s.maintenanceStatus(),
+		Retention:   s.retentionStatus(),
+	}
+	if s.shadow != nil {
+		shadow := s.shadow.Stats()
+		stats.Shadow = &shadow
+	}
+	if !s.stats.lastFetch.IsZero() {
+		lastFetch := s.stats.lastFetch
+		stats.Fetch.LastSuccess = &lastFetch
+	}
+	if !s.stats.lastFetchFailure.IsZero() {
+		lastFailure := s.stats.lastFetchFailure
+		stats.Fetch.LastFailure = &lastFailure
+	}
+	for route, count := range s.stats.requests {
+		stats.Requests[route] = count
+	}
+	s.stats.Unlock()
+
+	respond(c, http.StatusOK, stats, ResponseMeta{})
+}
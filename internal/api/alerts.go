@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hudsgry-api/internal/alerting"
+	"log"
+	"sync"
+	"time"
+)
+
+// fetchAlertKey identifies a failing refresh to the alert channels, so that
+// the refresh that recovers closes the same incident.
+const fetchAlertKey = "huds-fetch"
+
+// alertTimeout bounds delivering one alert to every channel.
+const alertTimeout = 30 * time.Second
+
+// errEmptyFeed is a full refresh that fetched nothing at all, which HUDS
+// never serves on purpose.
+var errEmptyFeed = errors.New("the HUDS feed had no menu items")
+
+// fetchAlert is whether a failing refresh has been alerted and not yet
+// resolved.
+type fetchAlert struct {
+	sync.Mutex
+	failing bool
+	since   time.Time
+}
+
+// sendAlert delivers an alert to the configured channels, if any.
+func (s *Server) sendAlert(alert alerting.Alert) {
+	if s.alerter == nil {
+		return
+	}
+	if alert.Time.IsZero() {
+		alert.Time = time.Now()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), alertTimeout)
+	defer cancel()
+	if err := s.alerter.Send(ctx, alert); err != nil {
+		log.Printf("Failed to send alert %q: %v\n", alert.Summary, err)
+	}
+}
+
+// alertFetchOutcome alerts when a full refresh fails, after its retries, and
+// again when one next succeeds, so a refresh failing on every schedule is
+// only alerted once.
+func (s *Server) alertFetchOutcome(err error) {
+	s.fetchAlert.Lock()
+	failing, since := s.fetchAlert.failing, s.fetchAlert.since
+	switch {
+	case err != nil && !failing:
+		s.fetchAlert.failing, s.fetchAlert.since = true, time.Now()
+	case err == nil && failing:
+		s.fetchAlert.failing = false
+	}
+	s.fetchAlert.Unlock()
+
+	switch {
+	case err != nil && !failing:
+		s.sendAlert(alerting.Alert{
+			Key:     fetchAlertKey,
+			Summary: "Fetching menus from HUDS failed",
+			Details: fmt.Sprintf("%v\nServed menus won't change until a refresh succeeds.", err),
+		})
+	case err == nil && failing:
+		s.sendAlert(alerting.Alert{
+			Key:      fetchAlertKey,
+			Summary:  "Fetching menus from HUDS succeeded again",
+			Details:  fmt.Sprintf("Refreshes had been failing since %s.", since.Format(time.RFC1123)),
+			Resolved: true,
+		})
+	}
+}
@@ -0,0 +1,389 @@
+package api
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	_ "crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Alexa rejects responses to requests older than this, so we do the same to
+// guard against replayed requests.
+const alexaTimestampTolerance = 150 * time.Second
+
+type AlexaSlot struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type AlexaIntent struct {
+	Name  string               `json:"name"`
+	Slots map[string]AlexaSlot `json:"slots"`
+}
+
+type AlexaRequestEnvelope struct {
+	Version string `json:"version"`
+	Session struct {
+		Application struct {
+			ApplicationID string `json:"applicationId"`
+		} `json:"application"`
+	} `json:"session"`
+	Context struct {
+		System struct {
+			Application struct {
+				ApplicationID string `json:"applicationId"`
+			} `json:"application"`
+		} `json:"System"`
+	} `json:"context"`
+	Request struct {
+		Type      string      `json:"type"`
+		Timestamp string      `json:"timestamp"`
+		Intent    AlexaIntent `json:"intent"`
+	} `json:"request"`
+}
+
+type AlexaOutputSpeech struct {
+	Type string `json:"type"`
+	SSML string `json:"ssml"`
+}
+
+type AlexaCard struct {
+	Type    string `json:"type"`
+	Title   string `json:"title"`
+	Content string `json:"content"`
+}
+
+type AlexaResponse struct {
+	OutputSpeech     AlexaOutputSpeech `json:"outputSpeech"`
+	Card             *AlexaCard        `json:"card,omitempty"`
+	ShouldEndSession bool              `json:"shouldEndSession"`
+}
+
+type AlexaResponseEnvelope struct {
+	Version  string        `json:"version"`
+	Response AlexaResponse `json:"response"`
+}
+
+const alexaHelp = "You can ask what's for breakfast, lunch, or dinner, today or on another day. What would you like to know?"
+
+const (
+	// alexaCertHost and alexaCertPath are where Amazon's signing certificate
+	// chains are served from; a SignatureCertChainUrl anywhere else is forged
+	alexaCertHost = "s3.amazonaws.com"
+	alexaCertPath = "/echo.api/"
+	// alexaCertName is the name the signing certificate must be issued to
+	alexaCertName = "echo-api.amazon.com"
+	// maxAlexaRequestBytes is far more than any request envelope
+	maxAlexaRequestBytes = 128 << 10
+)
+
+// AlexaSkill answers the Alexa Skills Kit requests for one skill, checking
+// each is signed by Amazon as the hosting requirements ask.
+type AlexaSkill struct {
+	skillID    string
+	httpClient *http.Client
+	// certs are the signing certificates fetched so far, by chain URL
+	certs struct {
+		sync.Mutex
+		byURL map[string]*x509.Certificate
+	}
+}
+
+// setupAlexa serves /alexa for the skill ALEXA_SKILL_ID. Without a skill ID
+// requests from any skill would be answered, so the route isn't served.
+func (s *Server) setupAlexa() {
+	skillID := os.Getenv("ALEXA_SKILL_ID")
+	if skillID == "" {
+		log.Println("ALEXA_SKILL_ID is not set; /alexa is disabled")
+		return
+	}
+	s.alexa = &AlexaSkill{skillID: skillID, httpClient: &http.Client{Timeout: 10 * time.Second}}
+	s.alexa.certs.byURL = make(map[string]*x509.Certificate)
+}
+
+func (s *Server) handleAlexa(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxAlexaRequestBytes))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid Alexa request")
+		return
+	}
+	if err := s.alexa.verifySignature(c, body); err != nil {
+		log.Printf("Rejected an Alexa request: %v\n", err)
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid Alexa request signature")
+		return
+	}
+	var envelope AlexaRequestEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid Alexa request")
+		return
+	}
+
+	// Only answer our own skill
+	appID := envelope.Context.System.Application.ApplicationID
+	if appID == "" {
+		appID = envelope.Session.Application.ApplicationID
+	}
+	if appID != s.alexa.skillID {
+		respondError(c, http.StatusForbidden, CodeForbidden, "unknown skill")
+		return
+	}
+
+	timestamp, err := time.Parse(time.RFC3339, envelope.Request.Timestamp)
+	if err != nil || math.Abs(time.Since(timestamp).Seconds()) > alexaTimestampTolerance.Seconds() {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "request timestamp out of range")
+		return
+	}
+
+	switch envelope.Request.Type {
+	case "LaunchRequest":
+		c.JSON(http.StatusOK, alexaSpeech("Welcome to HUDS. "+alexaHelp, false))
+	case "IntentRequest":
+		c.JSON(http.StatusOK, s.handleAlexaIntent(c.Request.Context(), envelope.Request.Intent))
+	case "SessionEndedRequest":
+		c.JSON(http.StatusOK, AlexaResponseEnvelope{Version: "1.0", Response: AlexaResponse{ShouldEndSession: true}})
+	default:
+		c.JSON(http.StatusOK, alexaSpeech(alexaHelp, false))
+	}
+}
+
+func (s *Server) handleAlexaIntent(ctx context.Context, intent AlexaIntent) AlexaResponseEnvelope {
+	switch intent.Name {
+	case "AMAZON.HelpIntent":
+		return alexaSpeech(alexaHelp, false)
+	case "AMAZON.StopIntent", "AMAZON.CancelIntent":
+		return alexaSpeech("Enjoy your meal!", true)
+	case "GetMenuIntent":
+		return s.alexaMenuResponse(ctx, intent)
+	default:
+		return alexaSpeech("Sorry, I didn't get that. "+alexaHelp, false)
+	}
+}
+
+func (s *Server) alexaMenuResponse(ctx context.Context, intent AlexaIntent) AlexaResponseEnvelope {
+	// AMAZON.DATE slots resolve to YYYY-MM-DD; anything else falls back to today
+	day := s.localNow()
+	if slot, ok := intent.Slots["Date"]; ok && slot.Value != "" {
+		if parsed, err := time.Parse("2006-01-02", slot.Value); err == nil {
+			day = parsed
+		}
+	}
+
+	meal := strings.ToLower(intent.Slots["Meal"].Value)
+	if meal != "breakfast" && meal != "lunch" {
+		meal = "dinner"
+	}
+
+	date := day.Format(huds.ServeDateLayout)
+	ctx, cancel := s.dbContext(ctx)
+	defer cancel()
+	menu, err := s.menuByDate(ctx, date)
+	if err != nil {
+		if err != store.ErrMenuNotFound {
+			log.Printf("Failed to fetch menu for Alexa: %v\n", err)
+			return alexaSpeech("Sorry, I couldn't reach the dining menu right now. Please try again later.", true)
+		}
+		return alexaSpeech(fmt.Sprintf("HUDS hasn't published the %s menu %s yet.", meal, s.spokenDate(day)), true)
+	}
+
+	items := menu.Dinner
+	if meal == "breakfast" {
+		items = menu.Breakfast
+	} else if meal == "lunch" {
+		items = menu.Lunch
+	}
+
+	response := alexaSpeech(s.mealSummarySSML(meal, day, items), true)
+	response.Response.Card = &AlexaCard{
+		Type:    "Simple",
+		Title:   fmt.Sprintf("HUDS %s, %s", meal, date),
+		Content: formatMenuText(menu, date, meal),
+	}
+	return response
+}
+
+// mealSummarySSML reads out every item of a meal as a natural-sounding list.
+func (s *Server) mealSummarySSML(meal string, day time.Time, items []huds.CondensedMenuItem) string {
+	if len(items) == 0 {
+		return fmt.Sprintf("There's nothing listed for %s %s.", meal, s.spokenDate(day))
+	}
+
+	names := make([]string, 0, len(items))
+	for _, item := range items {
+		names = append(names, ssmlEscape(item.FoodName))
+	}
+	list := names[0]
+	if len(names) == 2 {
+		list = names[0] + " and " + names[1]
+	} else if len(names) > 2 {
+		list = strings.Join(names[:len(names)-1], ", ") + ", and " + names[len(names)-1]
+	}
+
+	return fmt.Sprintf("For %s %s, HUDS is serving <break time=\"300ms\"/> %s.", meal, s.spokenDate(day), list)
+}
+
+func (s *Server) spokenDate(day time.Time) string {
+	now := s.localNow()
+	if day.Format(huds.ServeDateLayout) == now.Format(huds.ServeDateLayout) {
+		return "today"
+	}
+	if day.Format(huds.ServeDateLayout) == now.AddDate(0, 0, 1).Format(huds.ServeDateLayout) {
+		return "tomorrow"
+	}
+	return fmt.Sprintf("on <say-as interpret-as=\"date\">????%s</say-as>", day.Format("0102"))
+}
+
+var ssmlReplacer = strings.NewReplacer("&", "and", "<", "", ">", "", "\"", "", "'", "")
+
+func ssmlEscape(s string) string {
+	return ssmlReplacer.Replace(s)
+}
+
+func alexaSpeech(text string, endSession bool) AlexaResponseEnvelope {
+	return AlexaResponseEnvelope{
+		Version: "1.0",
+		Response: AlexaResponse{
+			OutputSpeech: AlexaOutputSpeech{
+				Type: "SSML",
+				SSML: "<speak>" + text + "</speak>",
+			},
+			ShouldEndSession: endSession,
+		},
+	}
+}
+
+// verifySignature checks the request was signed by Amazon: its certificate
+// chain must come from Amazon's bucket, be valid now, chain to a trusted root
+// and be issued to echo-api.amazon.com, and its key must have signed the
+// body. Signature-256 is checked when sent, and the older SHA-1 Signature
+// otherwise.
+func (skill *AlexaSkill) verifySignature(c *gin.Context, body []byte) error {
+	chainURL := c.GetHeader("SignatureCertChainUrl")
+	if chainURL == "" {
+		return fmt.Errorf("no SignatureCertChainUrl")
+	}
+	cert, err := skill.signingCert(c.Request.Context(), chainURL)
+	if err != nil {
+		return err
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("signing certificate doesn't have an RSA key")
+	}
+
+	hash, header := crypto.SHA256, c.GetHeader("Signature-256")
+	if header == "" {
+		hash, header = crypto.SHA1, c.GetHeader("Signature")
+	}
+	if header == "" {
+		return fmt.Errorf("no Signature")
+	}
+	signature, err := base64.StdEncoding.DecodeString(header)
+	if err != nil {
+		return fmt.Errorf("malformed Signature")
+	}
+	digest := hash.New()
+	digest.Write(body)
+	if err := rsa.VerifyPKCS1v15(key, hash, digest.Sum(nil), signature); err != nil {
+		return fmt.Errorf("bad signature")
+	}
+	return nil
+}
+
+// signingCert fetches and checks the certificate chain at chainURL, or
+// returns the one fetched before while it is still valid.
+func (skill *AlexaSkill) signingCert(ctx context.Context, chainURL string) (*x509.Certificate, error) {
+	if err := checkAlexaCertURL(chainURL); err != nil {
+		return nil, err
+	}
+	skill.certs.Lock()
+	cert, ok := skill.certs.byURL[chainURL]
+	skill.certs.Unlock()
+	if ok && time.Now().Before(cert.NotAfter) {
+		return cert, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, chainURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := skill.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the certificate chain: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("certificate chain returned %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the certificate chain: %v", err)
+	}
+
+	var chain []*x509.Certificate
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		parsed, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("malformed certificate chain: %v", err)
+		}
+		chain = append(chain, parsed)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("empty certificate chain")
+	}
+	intermediates := x509.NewCertPool()
+	for _, intermediate := range chain[1:] {
+		intermediates.AddCert(intermediate)
+	}
+	// Verify checks the validity period and name as well as the chain
+	_, err = chain[0].Verify(x509.VerifyOptions{
+		DNSName:       alexaCertName,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("untrusted signing certificate: %v", err)
+	}
+
+	skill.certs.Lock()
+	skill.certs.byURL[chainURL] = chain[0]
+	skill.certs.Unlock()
+	return chain[0], nil
+}
+
+// checkAlexaCertURL checks a SignatureCertChainUrl points into Amazon's
+// bucket: https, s3.amazonaws.com on the default port, and a path under
+// /echo.api/ once dot segments are resolved.
+func checkAlexaCertURL(chainURL string) error {
+	u, err := url.Parse(chainURL)
+	if err != nil {
+		return fmt.Errorf("malformed SignatureCertChainUrl")
+	}
+	if !strings.EqualFold(u.Scheme, "https") || !strings.EqualFold(u.Hostname(), alexaCertHost) || (u.Port() != "" && u.Port() != "443") {
+		return fmt.Errorf("SignatureCertChainUrl %q isn't Amazon's", chainURL)
+	}
+	if !strings.HasPrefix(path.Clean(u.Path), alexaCertPath) {
+		return fmt.Errorf("SignatureCertChainUrl %q isn't Amazon's", chainURL)
+	}
+	return nil
+}
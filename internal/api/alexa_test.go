@@ -0,0 +1,140 @@
+package api
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testSkillID   = "amzn1.ask.skill.test"
+	testChainURL  = "https://s3.amazonaws.com/echo.api/echo-api-cert-12.pem"
+	otherChainURL = "https://s3.amazonaws.com/echo.api/echo-api-cert-13.pem"
+)
+
+// selfSignedCert is a certificate for echo-api.amazon.com that no trusted
+// root issued.
+func selfSignedCert(t *testing.T) (*rsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: alexaCertName},
+		DNSNames:     []string{alexaCertName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, cert
+}
+
+func alexaRequest(skillID string, at time.Time) string {
+	return fmt.Sprintf(`{"version":"1.0","context":{"System":{"application":{"applicationId":%q}}},"request":{"type":"LaunchRequest","timestamp":%q}}`,
+		skillID, at.UTC().Format(time.RFC3339))
+}
+
+func signAlexaRequest(t *testing.T, key *rsa.PrivateKey, body string) string {
+	t.Helper()
+	digest := sha256.Sum256([]byte(body))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(signature)
+}
+
+func TestAlexaSignature(t *testing.T) {
+	t.Setenv("ALEXA_SKILL_ID", testSkillID)
+	s, handler := newTestServer(t)
+	key, cert := selfSignedCert(t)
+	// The certificate stands in for one already fetched and verified from
+	// Amazon; any other chain URL is fetched, and gets the same certificate,
+	// which doesn't chain to a trusted root
+	s.alexa.certs.byURL[testChainURL] = cert
+	chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	s.alexa.httpClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(string(chain))), Request: req}, nil
+	})}
+
+	post := func(body string, chainURL string, signature string) int {
+		req := httptest.NewRequest(http.MethodPost, "/alexa", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if chainURL != "" {
+			req.Header.Set("SignatureCertChainUrl", chainURL)
+		}
+		if signature != "" {
+			req.Header.Set("Signature-256", signature)
+		}
+		return serve(handler, req).Code
+	}
+
+	body := alexaRequest(testSkillID, time.Now())
+	if code := post(body, testChainURL, signAlexaRequest(t, key, body)); code != http.StatusOK {
+		t.Fatalf("a signed request: got %d, want 200", code)
+	}
+	otherKey, _ := selfSignedCert(t)
+	stale := alexaRequest(testSkillID, time.Now().Add(-10*time.Minute))
+	otherSkill := alexaRequest("amzn1.ask.skill.other", time.Now())
+	for _, test := range []struct {
+		name      string
+		body      string
+		chainURL  string
+		signature string
+		want      int
+	}{
+		{"unsigned", body, testChainURL, "", http.StatusBadRequest},
+		{"no certificate", body, "", signAlexaRequest(t, key, body), http.StatusBadRequest},
+		{"signed by another key", body, testChainURL, signAlexaRequest(t, otherKey, body), http.StatusBadRequest},
+		{"body changed after signing", strings.Replace(body, "LaunchRequest", "SessionEndedRequest", 1), testChainURL, signAlexaRequest(t, key, body), http.StatusBadRequest},
+		{"certificate outside Amazon's bucket", body, "https://attacker.example.com/echo.api/cert.pem", signAlexaRequest(t, key, body), http.StatusBadRequest},
+		{"untrusted certificate", body, otherChainURL, signAlexaRequest(t, key, body), http.StatusBadRequest},
+		{"replayed", stale, testChainURL, signAlexaRequest(t, key, stale), http.StatusBadRequest},
+		{"another skill", otherSkill, testChainURL, signAlexaRequest(t, key, otherSkill), http.StatusForbidden},
+	} {
+		if code := post(test.body, test.chainURL, test.signature); code != test.want {
+			t.Errorf("%s: got %d, want %d", test.name, code, test.want)
+		}
+	}
+}
+
+func TestCheckAlexaCertURL(t *testing.T) {
+	for chainURL, ok := range map[string]bool{
+		"https://s3.amazonaws.com/echo.api/echo-api-cert.pem":         true,
+		"https://s3.amazonaws.com:443/echo.api/echo-api-cert.pem":     true,
+		"https://S3.AMAZONAWS.COM/echo.api/echo-api-cert.pem":         true,
+		"https://s3.amazonaws.com/echo.api/../echo.api/cert.pem":      true,
+		"http://s3.amazonaws.com/echo.api/echo-api-cert.pem":          false,
+		"https://s3.amazonaws.com:563/echo.api/echo-api-cert.pem":     false,
+		"https://s3.amazonaws.com/EcHo.aPi/echo-api-cert.pem":         false,
+		"https://s3.amazonaws.com/echo.api/../invalid.path/cert.pem":  false,
+		"https://notamazon.com/echo.api/echo-api-cert.pem":            false,
+		"https://s3.amazonaws.com.attacker.example/echo.api/cert.pem": false,
+	} {
+		if err := checkAlexaCertURL(chainURL); (err == nil) != ok {
+			t.Errorf("checkAlexaCertURL(%s) = %v, want ok %t", chainURL, err, ok)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultAnalyticsDays  = 30
+	defaultFrequencyLimit = 50
+	maxFrequencyLimit     = 500
+)
+
+// analyticsWindow reads ?start= and ?end=, defaulting to the last 30 days up
+// to today.
+func (s *Server) analyticsWindow(c *gin.Context) (time.Time, time.Time, bool) {
+	start, ok := s.queryDate(c, "start")
+	if !ok {
+		return start, start, false
+	}
+	end, ok := s.queryDate(c, "end")
+	if !ok {
+		return start, end, false
+	}
+	today, _ := time.Parse(huds.ServeDateLayout, s.today())
+	if end.IsZero() {
+		end = today
+	}
+	if start.IsZero() {
+		start = today.AddDate(0, 0, -defaultAnalyticsDays+1)
+	}
+	if end.Before(start) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "end must not be before start")
+		return start, end, false
+	}
+	return start, end, true
+}
+
+// analyticsStore returns the menu store's analytics queries, answering 501 if
+// the configured store has none.
+func (s *Server) analyticsStore(c *gin.Context) (store.AnalyticsStore, bool) {
+	analytics, ok := s.store.(store.AnalyticsStore)
+	if !ok {
+		respondError(c, http.StatusNotImplemented, CodeNotImplemented, "analytics are not supported by this storage backend")
+	}
+	return analytics, ok
+}
+
+// handleFoodFrequency counts how often each food was served in the window,
+// overall and per meal, most frequent first.
+func (s *Server) handleFoodFrequency(c *gin.Context) {
+	start, end, ok := s.analyticsWindow(c)
+	if !ok {
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultFrequencyLimit)))
+	if err != nil || limit < 1 || limit > maxFrequencyLimit {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "limit must be between 1 and 500")
+		return
+	}
+
+	analytics, ok := s.analyticsStore(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	foods, err := analytics.FoodFrequency(ctx, start, end, limit+1)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	foods, page := paginate(foods, limit)
+	respond(c, http.StatusOK, gin.H{
+		"start": start.Format(huds.ServeDateLayout),
+		"end":   end.Format(huds.ServeDateLayout),
+		"foods": foods,
+	}, ResponseMeta{Source: SourceDB, Pagination: page})
+}
+
+// handleAnalyticsSummary computes dataset-style statistics over the window.
+// With ?category= it also reports how many days it has been since that menu
+// category last appeared.
+func (s *Server) handleAnalyticsSummary(c *gin.Context) {
+	start, end, ok := s.analyticsWindow(c)
+	if !ok {
+		return
+	}
+
+	analytics, ok := s.analyticsStore(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	summary, err := analytics.Summary(ctx, start, end)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	summary.Start, summary.End = start.Format(huds.ServeDateLayout), end.Format(huds.ServeDateLayout)
+	for i := range summary.MealSizes {
+		summary.MealSizes[i].AverageItems = float64(int(summary.MealSizes[i].AverageItems*10+0.5)) / 10
+	}
+
+	if category := c.Query("category"); category != "" {
+		summary.Category = category
+		todayStart, _ := time.Parse(huds.ServeDateLayout, s.today())
+		last, err := analytics.CategoryLastServed(ctx, category, todayStart)
+		if err != nil && err != store.ErrMenuNotFound {
+			respondStoreError(c, err)
+			return
+		}
+		if err == nil {
+			lastDate, _ := time.Parse(huds.ServeDateLayout, last)
+			days := int(todayStart.Sub(lastDate).Hours() / 24)
+			summary.CategoryLast, summary.DaysSinceLast = &last, &days
+		}
+	}
+
+	respond(c, http.StatusOK, summary, ResponseMeta{})
+}
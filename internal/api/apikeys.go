@@ -0,0 +1,588 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"hudsgry-api/internal/scheduler"
+	"hudsgry-api/internal/store"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// apiKeyHeader is how third-party apps send their key
+	apiKeyHeader = "X-API-Key"
+	// apiKeyPrefix starts every key, so leaked keys are easy to search for
+	apiKeyPrefix            = "hk_"
+	defaultAPIKeyDailyQuota = 10000
+	maxAPIKeysPerUser       = 10
+)
+
+// APIKey identifies a third-party app. Only a hash of the key is stored; the
+// key itself is shown once, when it is created.
+type APIKey struct {
+	ID     primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Name   string             `json:"name" bson:"name"`
+	// Prefix is the start of the key, to tell keys apart by
+	Prefix  string `json:"prefix" bson:"prefix"`
+	KeyHash string `json:"-" bson:"key_hash"`
+	// Tier is the quota tier the key is on. Keys without one, made before
+	// tiers or given a quota of their own, have DailyQuota instead.
+	Tier string `json:"tier,omitempty" bson:"tier,omitempty"`
+	// DailyQuota is how many requests the key may make a day, counted in
+	// the service's timezone. 0 is unlimited. In responses it is the quota
+	// the key gets, from its tier if it has one.
+	DailyQuota int       `json:"daily_quota" bson:"daily_quota"`
+	CreatedAt  time.Time `json:"created_at" bson:"created_at"`
+	// Key is only set in the response to creating the key
+	Key string `json:"key,omitempty" bson:"-"`
+}
+
+// keyUsage holds the per-key counters not yet flushed to Mongo, and each
+// key's requests so far today for quotas.
+type keyUsage struct {
+	sync.Mutex
+	defaultQuota int
+	pending      map[string]*keyUsageCounter
+	day          string
+	today        map[primitive.ObjectID]int
+}
+
+type keyUsageCounter struct {
+	keyID    primitive.ObjectID
+	day      string
+	route    string
+	requests int
+	rejected int
+	bytes    int64
+	status   map[string]int
+}
+
+type KeyUsageDocument struct {
+	KeyID    primitive.ObjectID `bson:"key_id"`
+	Day      string             `bson:"day"`
+	Route    string             `bson:"route"`
+	Requests int                `bson:"requests"`
+	Rejected int                `bson:"rejected"`
+	Bytes    int64              `bson:"bytes"`
+	Status   map[string]int     `bson:"status"`
+}
+
+// KeyUsage is a key's traffic over the last Days days. Requests turned away
+// for being over quota are counted in Rejected rather than Requests.
+type KeyUsage struct {
+	KeyID      string          `json:"key_id"`
+	Name       string          `json:"name"`
+	Days       int             `json:"days"`
+	DailyQuota int             `json:"daily_quota"`
+	Today      int             `json:"today"`
+	Requests   int             `json:"requests"`
+	Rejected   int             `json:"rejected"`
+	Bytes      int64           `json:"bytes"`
+	Routes     []KeyRouteUsage `json:"routes"`
+	Daily      []KeyDailyUsage `json:"daily"`
+}
+
+type KeyRouteUsage struct {
+	Route    string         `json:"route"`
+	Requests int            `json:"requests"`
+	Bytes    int64          `json:"bytes"`
+	Status   map[string]int `json:"status"`
+}
+
+type KeyDailyUsage struct {
+	Day      string `json:"day"`
+	Requests int    `json:"requests"`
+	Rejected int    `json:"rejected"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// setupAPIKeys creates the key and usage collections' indexes and starts
+// flushing usage counters once a minute. Keys on the registered tier, which
+// new keys start on, get API_KEY_DAILY_QUOTA requests a day, 10000 by
+// default, until an admin changes the tier.
+func (s *Server) setupAPIKeys(jobs scheduler.Scheduler) error {
+	s.keyUsage.defaultQuota = defaultAPIKeyDailyQuota
+	if v := os.Getenv("API_KEY_DAILY_QUOTA"); v != "" {
+		quota, err := strconv.Atoi(v)
+		if err != nil || quota < 0 {
+			return fmt.Errorf("API_KEY_DAILY_QUOTA must be a non-negative integer, got %q", v)
+		}
+		s.keyUsage.defaultQuota = quota
+	}
+	s.keyUsage.pending = make(map[string]*keyUsageCounter)
+	s.keyUsage.today = make(map[primitive.ObjectID]int)
+	s.apiKeys = s.db.Collection("api_keys")
+	s.apiKeyUsage = s.db.Collection("api_key_usage")
+
+	s.ensureIndexes("API key",
+		store.IndexSpec{Collection: "api_keys", Model: mongo.IndexModel{
+			Keys:    bson.D{{Key: "key_hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}},
+		store.IndexSpec{Collection: "api_keys", Model: mongo.IndexModel{
+			Keys: bson.D{{Key: "user_id", Value: 1}},
+		}},
+		store.IndexSpec{Collection: "api_key_usage", Model: mongo.IndexModel{
+			Keys: bson.D{{Key: "key_id", Value: 1}, {Key: "day", Value: 1}},
+		}},
+	)
+	if _, err := jobs.AddFunc("* * * * *", s.recoverJob("API key usage", s.flushKeyUsage)); err != nil {
+		log.Printf("Failed to schedule API key usage flush: %v\n", err)
+	}
+	return nil
+}
+
+func (s *Server) apiKeyRoutes(r gin.IRouter) {
+	me := r.Group("/me", s.requireUser)
+	me.GET("/keys", s.handleListMyKeys)
+	me.POST("/keys", s.handleCreateKey)
+	me.DELETE("/keys/:id", s.handleDeleteKey)
+	me.GET("/usage", s.handleMyUsage)
+
+	s.quotaRoutes(r)
+	if s.adminToken != "" {
+		r.GET("/admin/keys", s.requireAdmin, s.handleAdminListKeys)
+		r.GET("/admin/keys/:id/usage", s.requireAdmin, s.handleAdminKeyUsage)
+		r.PUT("/admin/keys/:id/quota", s.requireAdmin, s.handleAdminSetQuota)
+	}
+}
+
+// trackAPIKey checks the X-API-Key header, when one is sent, turns the
+// request away if the key is over its daily quota, and counts the request
+// and the bytes sent back against the key. Requests without a key are held
+// to the anonymous quota instead.
+func (s *Server) trackAPIKey(c *gin.Context) {
+	raw := c.GetHeader(apiKeyHeader)
+	if raw == "" {
+		s.limitAnonymous(c)
+		return
+	}
+
+	var key APIKey
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	err := s.apiKeys.FindOne(ctx, bson.M{"key_hash": hashToken(raw)}).Decode(&key)
+	if err == mongo.ErrNoDocuments {
+		respondError(c, http.StatusUnauthorized, CodeUnauthorized, "invalid API key")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to look up API key: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to authenticate")
+		return
+	}
+
+	c.Set(apiKeyIDKey, key.ID)
+
+	now := s.localNow()
+	day := now.Format("2006-01-02")
+	used, err := s.keyRequestsToday(ctx, key.ID, day)
+	if err != nil {
+		log.Printf("Failed to count API key usage: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to authenticate")
+		return
+	}
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	route = c.Request.Method + " " + route
+
+	if quota := s.keyQuota(key); quota > 0 {
+		if resets, ok := setRateLimitHeaders(c, quota, used, now); !ok {
+			details := gin.H{"daily_quota": quota, "resets_at": resets}
+			if key.Tier != "" {
+				details["tier"] = key.Tier
+			}
+			respondErrorDetails(c, http.StatusTooManyRequests, CodeQuotaExceeded, "this API key has used its daily quota", details)
+			s.countKeyRequest(key.ID, day, route, c.Writer.Status(), c.Writer.Size(), false)
+			return
+		}
+	}
+	c.Next()
+	s.countKeyRequest(key.ID, day, route, c.Writer.Status(), c.Writer.Size(), true)
+}
+
+// keyRequestsToday returns how many requests a key has made on day, loading
+// the flushed count from Mongo the first time the key is seen that day.
+func (s *Server) keyRequestsToday(ctx context.Context, keyID primitive.ObjectID, day string) (int, error) {
+	s.keyUsage.Lock()
+	if s.keyUsage.day == day {
+		if used, ok := s.keyUsage.today[keyID]; ok {
+			s.keyUsage.Unlock()
+			return used, nil
+		}
+	}
+	s.keyUsage.Unlock()
+
+	cursor, err := s.apiKeyUsage.Find(ctx, bson.M{"key_id": keyID, "day": day})
+	if err != nil {
+		return 0, err
+	}
+	var docs []KeyUsageDocument
+	if err := cursor.All(ctx, &docs); err != nil {
+		return 0, err
+	}
+	flushed := 0
+	for _, doc := range docs {
+		flushed += doc.Requests
+	}
+
+	s.keyUsage.Lock()
+	defer s.keyUsage.Unlock()
+	if s.keyUsage.day != day {
+		s.keyUsage.day = day
+		s.keyUsage.today = make(map[primitive.ObjectID]int)
+	}
+	used, ok := s.keyUsage.today[keyID]
+	if !ok {
+		// Pending counters haven't been flushed, so aren't in flushed
+		for _, counter := range s.keyUsage.pending {
+			if counter.keyID == keyID && counter.day == day {
+				flushed += counter.requests
+			}
+		}
+		used = flushed
+		s.keyUsage.today[keyID] = used
+	}
+	return used, nil
+}
+
+func (s *Server) countKeyRequest(keyID primitive.ObjectID, day string, route string, status int, size int, accepted bool) {
+	s.keyUsage.Lock()
+	defer s.keyUsage.Unlock()
+	id := keyID.Hex() + "|" + day + "|" + route
+	counter, ok := s.keyUsage.pending[id]
+	if !ok {
+		counter = &keyUsageCounter{keyID: keyID, day: day, route: route, status: map[string]int{}}
+		s.keyUsage.pending[id] = counter
+	}
+	if !accepted {
+		counter.rejected++
+		return
+	}
+	counter.requests++
+	counter.status[strconv.Itoa(status)]++
+	if size > 0 {
+		counter.bytes += int64(size)
+	}
+	if s.keyUsage.day == day {
+		s.keyUsage.today[keyID]++
+	}
+}
+
+func (s *Server) flushKeyUsage() {
+	s.keyUsage.Lock()
+	pending := s.keyUsage.pending
+	s.keyUsage.pending = make(map[string]*keyUsageCounter)
+	s.keyUsage.Unlock()
+
+	ctx, cancel := s.jobContext()
+	defer cancel()
+	for id, counter := range pending {
+		inc := bson.M{"requests": counter.requests, "rejected": counter.rejected, "bytes": counter.bytes}
+		for status, n := range counter.status {
+			inc["status."+status] = n
+		}
+		_, err := s.apiKeyUsage.UpdateOne(ctx, bson.M{"_id": id},
+			bson.M{"$inc": inc, "$set": bson.M{"key_id": counter.keyID, "day": counter.day, "route": counter.route}},
+			options.Update().SetUpsert(true))
+		if err != nil {
+			log.Printf("Failed to flush API key usage for %s: %v\n", id, err)
+		}
+	}
+}
+
+// usageOf sums a key's counters over the last days days, unflushed ones
+// included.
+func (s *Server) usageOf(ctx context.Context, key APIKey, days int) (KeyUsage, error) {
+	since := s.localNow().AddDate(0, 0, -days+1).Format("2006-01-02")
+	cursor, err := s.apiKeyUsage.Find(ctx, bson.M{"key_id": key.ID, "day": bson.M{"$gte": since}})
+	if err != nil {
+		return KeyUsage{}, err
+	}
+	var docs []KeyUsageDocument
+	if err := cursor.All(ctx, &docs); err != nil {
+		return KeyUsage{}, err
+	}
+	s.keyUsage.Lock()
+	for _, counter := range s.keyUsage.pending {
+		if counter.keyID == key.ID && counter.day >= since {
+			docs = append(docs, KeyUsageDocument{KeyID: counter.keyID, Day: counter.day, Route: counter.route,
+				Requests: counter.requests, Rejected: counter.rejected, Bytes: counter.bytes, Status: counter.status})
+		}
+	}
+	s.keyUsage.Unlock()
+
+	today := s.localNow().Format("2006-01-02")
+	usage := KeyUsage{KeyID: key.ID.Hex(), Name: key.Name, Days: days, DailyQuota: key.DailyQuota, Routes: []KeyRouteUsage{}, Daily: []KeyDailyUsage{}}
+	routes := make(map[string]*KeyRouteUsage)
+	daily := make(map[string]*KeyDailyUsage)
+	for _, doc := range docs {
+		usage.Requests += doc.Requests
+		usage.Rejected += doc.Rejected
+		usage.Bytes += doc.Bytes
+		if doc.Day == today {
+			usage.Today += doc.Requests
+		}
+		if doc.Requests > 0 {
+			route, ok := routes[doc.Route]
+			if !ok {
+				route = &KeyRouteUsage{Route: doc.Route, Status: map[string]int{}}
+				routes[doc.Route] = route
+			}
+			route.Requests += doc.Requests
+			route.Bytes += doc.Bytes
+			for status, n := range doc.Status {
+				route.Status[status] += n
+			}
+		}
+		day, ok := daily[doc.Day]
+		if !ok {
+			day = &KeyDailyUsage{Day: doc.Day}
+			daily[doc.Day] = day
+		}
+		day.Requests += doc.Requests
+		day.Rejected += doc.Rejected
+		day.Bytes += doc.Bytes
+	}
+	for _, route := range routes {
+		usage.Routes = append(usage.Routes, *route)
+	}
+	sort.Slice(usage.Routes, func(i, j int) bool { return usage.Routes[i].Requests > usage.Routes[j].Requests })
+	for _, day := range daily {
+		usage.Daily = append(usage.Daily, *day)
+	}
+	sort.Slice(usage.Daily, func(i, j int) bool { return usage.Daily[i].Day < usage.Daily[j].Day })
+	return usage, nil
+}
+
+// usageDays reads ?days=, defaulting to 30 as the usage report does.
+func usageDays(c *gin.Context) (int, bool) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 1 || days > maxReportDays {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "days must be between 1 and 365")
+		return 0, false
+	}
+	return days, true
+}
+
+func (s *Server) handleListMyKeys(c *gin.Context) {
+	keys, ok := s.findKeys(c, bson.M{"user_id": currentUser(c).ID})
+	if !ok {
+		return
+	}
+	respond(c, http.StatusOK, gin.H{"keys": keys}, ResponseMeta{})
+}
+
+func (s *Server) handleCreateKey(c *gin.Context) {
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "name is required")
+		return
+	}
+
+	user := currentUser(c)
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	count, err := s.apiKeys.CountDocuments(ctx, bson.M{"user_id": user.ID})
+	if err != nil {
+		log.Printf("Failed to count API keys: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to create API key")
+		return
+	}
+	if count >= maxAPIKeysPerUser {
+		respondError(c, http.StatusConflict, CodeConflict, fmt.Sprintf("at most %d API keys are allowed per account", maxAPIKeysPerUser))
+		return
+	}
+
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Failed to generate API key: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to create API key")
+		return
+	}
+	raw := apiKeyPrefix + hex.EncodeToString(b)
+	key := APIKey{
+		UserID:     user.ID,
+		Name:       strings.TrimSpace(req.Name),
+		Prefix:     raw[:len(apiKeyPrefix)+8],
+		KeyHash:    hashToken(raw),
+		Tier:       registeredTier,
+		DailyQuota: s.tierQuota(registeredTier),
+		CreatedAt:  s.clock.Now(),
+	}
+	result, err := s.apiKeys.InsertOne(ctx, key)
+	if err != nil {
+		log.Printf("Failed to create API key: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to create API key")
+		return
+	}
+	key.ID = result.InsertedID.(primitive.ObjectID)
+	key.Key = raw
+	respond(c, http.StatusCreated, key, ResponseMeta{})
+}
+
+// handleDeleteKey revokes a key. Its usage is kept for the admin endpoints.
+func (s *Server) handleDeleteKey(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid key id")
+		return
+	}
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	result, err := s.apiKeys.DeleteOne(ctx, bson.M{"_id": id, "user_id": currentUser(c).ID})
+	if err != nil {
+		log.Printf("Failed to delete API key: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to delete API key")
+		return
+	}
+	if result.DeletedCount == 0 {
+		respondError(c, http.StatusNotFound, CodeNotFound, "API key not found")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// handleMyUsage reports the usage of each of the user's keys over the last
+// ?days= days.
+func (s *Server) handleMyUsage(c *gin.Context) {
+	days, ok := usageDays(c)
+	if !ok {
+		return
+	}
+	keys, ok := s.findKeys(c, bson.M{"user_id": currentUser(c).ID})
+	if !ok {
+		return
+	}
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	usages := make([]KeyUsage, 0, len(keys))
+	for _, key := range keys {
+		usage, err := s.usageOf(ctx, key, days)
+		if err != nil {
+			log.Printf("Failed to load API key usage: %v\n", err)
+			respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load usage")
+			return
+		}
+		usages = append(usages, usage)
+	}
+	respond(c, http.StatusOK, gin.H{"keys": usages}, ResponseMeta{})
+}
+
+func (s *Server) handleAdminListKeys(c *gin.Context) {
+	keys, ok := s.findKeys(c, bson.M{})
+	if !ok {
+		return
+	}
+	respond(c, http.StatusOK, gin.H{"keys": keys}, ResponseMeta{})
+}
+
+func (s *Server) handleAdminKeyUsage(c *gin.Context) {
+	days, ok := usageDays(c)
+	if !ok {
+		return
+	}
+	key, ok := s.adminKey(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	usage, err := s.usageOf(ctx, key, days)
+	if err != nil {
+		log.Printf("Failed to load API key usage: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load usage")
+		return
+	}
+	respond(c, http.StatusOK, usage, ResponseMeta{})
+}
+
+// handleAdminSetQuota gives a key a daily quota of its own, taking it off
+// its tier; 0 lifts it.
+func (s *Server) handleAdminSetQuota(c *gin.Context) {
+	var req struct {
+		DailyQuota *int `json:"daily_quota" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || *req.DailyQuota < 0 {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "daily_quota must be a non-negative integer")
+		return
+	}
+	key, ok := s.adminKey(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	if _, err := s.apiKeys.UpdateOne(ctx, bson.M{"_id": key.ID}, bson.M{"$set": bson.M{"daily_quota": *req.DailyQuota}, "$unset": bson.M{"tier": ""}}); err != nil {
+		log.Printf("Failed to set API key quota: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to set quota")
+		return
+	}
+	key.Tier = ""
+	key.DailyQuota = *req.DailyQuota
+	respond(c, http.StatusOK, key, ResponseMeta{})
+}
+
+// adminKey loads the key named by the :id parameter.
+func (s *Server) adminKey(c *gin.Context) (APIKey, bool) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid key id")
+		return APIKey{}, false
+	}
+	var key APIKey
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	err = s.apiKeys.FindOne(ctx, bson.M{"_id": id}).Decode(&key)
+	if err == mongo.ErrNoDocuments {
+		respondError(c, http.StatusNotFound, CodeNotFound, "API key not found")
+		return APIKey{}, false
+	}
+	if err != nil {
+		log.Printf("Failed to look up API key: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load API key")
+		return APIKey{}, false
+	}
+	key.DailyQuota = s.keyQuota(key)
+	return key, true
+}
+
+func (s *Server) findKeys(c *gin.Context, filter bson.M) ([]APIKey, bool) {
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	cursor, err := s.apiKeys.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		log.Printf("Failed to list API keys: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load API keys")
+		return nil, false
+	}
+	keys := []APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		log.Printf("Failed to decode API keys: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load API keys")
+		return nil, false
+	}
+	for i := range keys {
+		keys[i].DailyQuota = s.keyQuota(keys[i])
+	}
+	return keys, true
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"log"
+	"net/http"
+)
+
+const (
+	// restoreBatchDays is how many days are written to the store at a time
+	restoreBatchDays = 100
+	// maxBackupBytes bounds an uploaded backup, gzipped or not
+	maxBackupBytes = 512 << 20
+)
+
+// derivedDataHooks are the refresh hooks that only rebuild what is derived
+// from the stored menus, without notifying anyone, so they also run after a
+// restore.
+func (s *Server) derivedDataHooks() []func(ctx context.Context, data map[string]map[int][]huds.CondensedMenuItem) {
+	hooks := []func(ctx context.Context, data map[string]map[int][]huds.CondensedMenuItem){s.resetMenuCatalog, s.warmUp, s.detectMenuCycle}
+	if _, ok := s.store.(store.WeekStore); ok {
+		hooks = append(hooks, s.materializeWeeks)
+	}
+	if s.menuCache != nil {
+		hooks = append(hooks, s.saveMenuCache)
+	}
+	return hooks
+}
+
+// Restore writes every day in a backup to the store, replacing what is stored
+// for those days, and rebuilds what is derived from them. Favorite alerts,
+// pushes and other notifications aren't sent for restored menus.
+func (s *Server) Restore(ctx context.Context, backup *store.Backup) (int, error) {
+	menus, locations := backup.Menus()
+	for start := 0; start < len(menus); start += restoreBatchDays {
+		end := start + restoreBatchDays
+		if end > len(menus) {
+			end = len(menus)
+		}
+		if err := s.upsertMenus(ctx, menus[start:end]); err != nil {
+			return start, err
+		}
+	}
+	if err := s.upsertLocations(ctx, locations); err != nil {
+		return len(menus), err
+	}
+
+	today := s.today()
+	data := make(map[string]map[int][]huds.CondensedMenuItem, len(menus))
+	for _, menu := range menus {
+		if menu.ServeDate == today {
+			s.setCachedMenu(menu)
+		}
+		data[menu.ServeDate] = huds.MealsFromMenu(menu)
+	}
+	if err := s.refreshRecordRange(ctx); err != nil {
+		log.Printf("Failed to get earliest and latest records: %v\n", err)
+	}
+	for _, hook := range s.derivedDataHooks() {
+		hook(ctx, data)
+	}
+	return len(menus), nil
+}
+
+// handleAdminBackup streams every stored day as a gzipped backup.
+func (s *Server) handleAdminBackup(c *gin.Context) {
+	ctx, cancel := s.jobContext()
+	defer cancel()
+	now := s.clock.Now()
+	c.Header("Content-Type", "application/gzip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="hudsgry-backup-%s.json.gz"`, now.Format("2006-01-02")))
+	c.Status(http.StatusOK)
+	gz := gzip.NewWriter(c.Writer)
+	days, err := store.WriteBackup(ctx, s.store, gz, now)
+	if err == nil {
+		err = gz.Close()
+	}
+	// The status is already sent, so a failure can only cut the body short,
+	// which the gzip trailer being missing gives away
+	if err != nil {
+		log.Printf("Failed to write backup after %d days: %v\n", days, err)
+	}
+}
+
+// handleAdminRestore restores a backup from the request body, gzipped or
+// not.
+func (s *Server) handleAdminRestore(c *gin.Context) {
+	backup, err := store.ReadBackup(http.MaxBytesReader(c.Writer, c.Request.Body, maxBackupBytes))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	ctx, cancel := s.jobContext()
+	defer cancel()
+	days, err := s.Restore(ctx, backup)
+	if err != nil {
+		log.Printf("Failed to restore backup after %d days: %v\n", days, err)
+		respondErrorDetails(c, http.StatusInternalServerError, CodeInternal, "failed to restore backup", gin.H{"restored": days})
+		return
+	}
+	log.Printf("Restored %d days from a backup exported at %s\n", days, backup.ExportedAt.Format("2006-01-02 15:04:05"))
+	respond(c, http.StatusOK, gin.H{"restored": days, "exported_at": backup.ExportedAt}, ResponseMeta{})
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// CachedDay describes one day's menu held in a cache. Bytes is the size of
+// the menu as JSON, and AgeSeconds how long ago it was stored from HUDS.
+type CachedDay struct {
+	ServeDate  string     `json:"Serve_Date"`
+	Items      int        `json:"items"`
+	Bytes      int        `json:"bytes"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+	AgeSeconds float64    `json:"age_seconds,omitempty"`
+}
+
+// CacheLayer is one of the caches in front of the store, with how often it
+// answered.
+type CacheLayer struct {
+	Days     []CachedDay `json:"days"`
+	Bytes    int         `json:"bytes"`
+	Hits     int         `json:"hits"`
+	Misses   int         `json:"misses"`
+	HitRatio float64     `json:"hit_ratio"`
+	// SavedAt is when the file cache was last written
+	SavedAt *time.Time `json:"saved_at,omitempty"`
+}
+
+// CacheContents is what /admin/cache reports: today's menu, the days held in
+// memory around today, the local file cache if one is configured, and
+// whether the catalog is built.
+type CacheContents struct {
+	Today         CacheLayer  `json:"today"`
+	Warm          CacheLayer  `json:"warm"`
+	File          *CacheLayer `json:"file,omitempty"`
+	CatalogLoaded bool        `json:"catalog_loaded"`
+	// Responses is the stale-if-error response cache, if it is on
+	Responses *ResponseCacheStats `json:"responses,omitempty"`
+}
+
+// CacheInvalidation is what a DELETE of /admin/cache removed.
+type CacheInvalidation struct {
+	ServeDate string   `json:"Serve_Date,omitempty"`
+	Caches    []string `json:"caches"`
+}
+
+func (s *Server) describeCachedDay(menu huds.CondensedMenu, dateFormat string) CachedDay {
+	day := CachedDay{
+		ServeDate: formatServeDate(menu.ServeDate, dateFormat),
+		Items:     len(menu.Breakfast) + len(menu.Lunch) + len(menu.Dinner),
+	}
+	if data, err := json.Marshal(menu); err == nil {
+		day.Bytes = len(data)
+	}
+	if !menu.UpdatedAt.IsZero() {
+		updatedAt := menu.UpdatedAt
+		day.UpdatedAt = &updatedAt
+		day.AgeSeconds = s.clock.Now().Sub(updatedAt).Seconds()
+	}
+	return day
+}
+
+func (s *Server) describeCacheLayer(menus []huds.CondensedMenu, hits int, misses int, dateFormat string) CacheLayer {
+	layer := CacheLayer{Days: []CachedDay{}, Hits: hits, Misses: misses}
+	for _, menu := range menus {
+		day := s.describeCachedDay(menu, dateFormat)
+		layer.Days = append(layer.Days, day)
+		layer.Bytes += day.Bytes
+	}
+	if hits+misses > 0 {
+		layer.HitRatio = float64(hits) / float64(hits+misses)
+	}
+	return layer
+}
+
+// handleAdminCache reports what each cache holds and how often it answered.
+func (s *Server) handleAdminCache(c *gin.Context) {
+	dateFormat := c.DefaultQuery("date_format", s.dateFormat)
+	if !validDateFormat(dateFormat) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "date_format must be 'us' or 'iso'")
+		return
+	}
+
+	var today []huds.CondensedMenu
+	if cached := s.cachedMenu(); cached.ServeDate != "" {
+		today = append(today, cached)
+	}
+	s.warm.RLock()
+	warm := make([]huds.CondensedMenu, 0, len(s.warm.menus))
+	for _, menu := range s.warm.menus {
+		warm = append(warm, menu)
+	}
+	s.warm.RUnlock()
+	sort.Slice(warm, func(i, j int) bool {
+		a, _ := time.Parse(huds.ServeDateLayout, warm[i].ServeDate)
+		b, _ := time.Parse(huds.ServeDateLayout, warm[j].ServeDate)
+		return a.Before(b)
+	})
+	s.catalog.Lock()
+	catalogLoaded := s.catalog.menus != nil
+	s.catalog.Unlock()
+
+	s.stats.Lock()
+	contents := CacheContents{
+		Today:         s.describeCacheLayer(today, s.stats.cacheHits, s.stats.cacheMisses, dateFormat),
+		Warm:          s.describeCacheLayer(warm, s.stats.warmHits, s.stats.warmMisses, dateFormat),
+		CatalogLoaded: catalogLoaded,
+	}
+	fileHits, fileMisses := s.stats.fileCacheHits, s.stats.fileCacheMisses
+	s.stats.Unlock()
+	if s.menuCache != nil {
+		file := s.describeCacheLayer(s.menuCache.Menus(), fileHits, fileMisses, dateFormat)
+		if savedAt := s.menuCache.SavedAt(); !savedAt.IsZero() {
+			file.SavedAt = &savedAt
+		}
+		contents.File = &file
+	}
+	if s.responses != nil {
+		responses := s.responses.stats()
+		contents.Responses = &responses
+	}
+	respond(c, http.StatusOK, contents, ResponseMeta{})
+}
+
+// handleAdminFlushCache empties every cache, so the next requests read from
+// the store.
+func (s *Server) handleAdminFlushCache(c *gin.Context) {
+	invalidation := CacheInvalidation{Caches: []string{"today", "warm", "catalog"}}
+	s.setCachedMenu(huds.CondensedMenu{})
+	s.warm.Lock()
+	s.warm.menus = make(map[string]huds.CondensedMenu)
+	s.warm.Unlock()
+	s.resetMenuCatalog(c.Request.Context(), nil)
+	if s.menuCache != nil {
+		if err := s.menuCache.Clear(); err != nil {
+			log.Printf("Failed to clear the local menu cache: %v\n", err)
+			respondError(c, http.StatusInternalServerError, CodeInternal, "failed to clear the local menu cache")
+			return
+		}
+		invalidation.Caches = append(invalidation.Caches, "file")
+	}
+	if s.responses != nil {
+		s.responses.clear()
+		invalidation.Caches = append(invalidation.Caches, "responses")
+	}
+	log.Println("Flushed every menu cache")
+	respond(c, http.StatusOK, invalidation, ResponseMeta{})
+}
+
+// handleAdminInvalidateCache drops one day from every cache that holds it.
+// The date is YYYY-MM-DD, as MM/DD/YYYY can't be a path segment.
+func (s *Server) handleAdminInvalidateCache(c *gin.Context) {
+	date, err := s.parseDate(c.Param("date"))
+	if err != nil {
+		respondErrorDetails(c, http.StatusBadRequest, CodeDateInvalid, "date must be YYYY-MM-DD, today or tomorrow", gin.H{"date": c.Param("date")})
+		return
+	}
+	serveDate := date.Format(huds.ServeDateLayout)
+	invalidation := CacheInvalidation{ServeDate: formatServeDate(serveDate, c.DefaultQuery("date_format", s.dateFormat)), Caches: []string{}}
+
+	s.mu.Lock()
+	if s.localCache.ServeDate == serveDate {
+		s.localCache = huds.CondensedMenu{}
+		invalidation.Caches = append(invalidation.Caches, "today")
+	}
+	s.mu.Unlock()
+	s.warm.Lock()
+	if _, ok := s.warm.menus[serveDate]; ok {
+		delete(s.warm.menus, serveDate)
+		invalidation.Caches = append(invalidation.Caches, "warm")
+	}
+	s.warm.Unlock()
+	if s.menuCache != nil {
+		deleted, err := s.menuCache.Delete(serveDate)
+		if err != nil {
+			log.Printf("Failed to drop %s from the local menu cache: %v\n", serveDate, err)
+			respondError(c, http.StatusInternalServerError, CodeInternal, "failed to update the local menu cache")
+			return
+		}
+		if deleted {
+			invalidation.Caches = append(invalidation.Caches, "file")
+		}
+	}
+	log.Printf("Invalidated %s in the menu caches: %v\n", serveDate, invalidation.Caches)
+	respond(c, http.StatusOK, invalidation, ResponseMeta{})
+}
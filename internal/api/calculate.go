@@ -0,0 +1,161 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"net/http"
+	"strings"
+)
+
+const maxCalculateItems = 50
+
+// dailyValues are the FDA reference daily values for a 2,000 calorie diet,
+// used to express totals as a percentage of a day. Trans fat has none.
+var dailyValues = Nutrients{
+	Calories:     2000,
+	TotalFat:     78,
+	SatFat:       20,
+	Cholesterol:  300,
+	Sodium:       2300,
+	TotalCarb:    275,
+	DietaryFiber: 28,
+	Sugars:       50,
+	Protein:      50,
+}
+
+type CalculateItem struct {
+	ID        int     `json:"id"`
+	FoodName  string  `json:"food_name"`
+	ServeDate string  `json:"serve_date"`
+	Meal      string  `json:"meal"`
+	Servings  float64 `json:"servings"`
+}
+
+type CalculatedItem struct {
+	ID        int       `json:"ID,omitempty"`
+	FoodName  string    `json:"Food_Name"`
+	ServeDate string    `json:"Serve_Date"`
+	Meal      string    `json:"meal"`
+	Servings  float64   `json:"servings"`
+	Nutrients Nutrients `json:"nutrients"`
+}
+
+// handleCalculate sums the nutrition of a plate. Each item is given either by
+// upstream ID or by food_name and serve_date (plus meal to disambiguate),
+// with an optional servings multiplier.
+func (s *Server) handleCalculate(c *gin.Context) {
+	var req struct {
+		Items []CalculateItem `json:"items" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Items) == 0 {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "items is required")
+		return
+	}
+	if len(req.Items) > maxCalculateItems {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("at most %d items can be calculated at once", maxCalculateItems))
+		return
+	}
+
+	var totals Nutrients
+	calculated := make([]CalculatedItem, 0, len(req.Items))
+	for i, requested := range req.Items {
+		if requested.Servings == 0 {
+			requested.Servings = 1
+		}
+		if requested.Servings < 0 || requested.Servings > maxServings {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("items[%d]: servings must be between 0 and 20", i))
+			return
+		}
+
+		item, date, meal, err := s.resolveCalculateItem(c.Request.Context(), requested)
+		if err == store.ErrMenuNotFound {
+			respondError(c, http.StatusNotFound, CodeNotFound, fmt.Sprintf("items[%d]: item not found", i))
+			return
+		}
+		if err != nil {
+			if _, invalid := err.(invalidItemError); invalid {
+				respondError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("items[%d]: %v", i, err))
+				return
+			}
+			respondStoreError(c, err)
+			return
+		}
+
+		nutrients := itemNutrients(item).Scale(requested.Servings)
+		totals = totals.Add(nutrients)
+		calculated = append(calculated, CalculatedItem{
+			ID:        item.ID,
+			FoodName:  item.FoodName,
+			ServeDate: date,
+			Meal:      meal,
+			Servings:  requested.Servings,
+			Nutrients: nutrients,
+		})
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"items":               calculated,
+		"totals":              totals,
+		"percent_daily_value": percentDailyValue(totals),
+	}, ResponseMeta{})
+}
+
+type invalidItemError string
+
+func (e invalidItemError) Error() string {
+	return string(e)
+}
+
+func (s *Server) resolveCalculateItem(ctx context.Context, requested CalculateItem) (huds.CondensedMenuItem, string, string, error) {
+	ctx, cancel := s.dbContext(ctx)
+	defer cancel()
+	if requested.ID != 0 {
+		return s.store.ItemByID(ctx, requested.ID)
+	}
+	if requested.FoodName == "" || requested.ServeDate == "" {
+		return huds.CondensedMenuItem{}, "", "", invalidItemError("either id or food_name and serve_date are required")
+	}
+	date, err := s.parseDate(requested.ServeDate)
+	if err != nil {
+		return huds.CondensedMenuItem{}, "", "", invalidItemError("serve_date must be MM/DD/YYYY, YYYY-MM-DD, today or tomorrow")
+	}
+	serveDate := date.Format(huds.ServeDateLayout)
+
+	menu, err := s.menuByDate(ctx, serveDate)
+	if err != nil {
+		return huds.CondensedMenuItem{}, "", "", err
+	}
+	meals := menu.Meals()
+	if requested.Meal != "" {
+		meals = []string{strings.ToLower(requested.Meal)}
+	}
+	for _, meal := range meals {
+		for _, item := range huds.MealItems(menu, meal) {
+			if strings.EqualFold(item.FoodName, requested.FoodName) {
+				return item, serveDate, meal, nil
+			}
+		}
+	}
+	return huds.CondensedMenuItem{}, "", "", store.ErrMenuNotFound
+}
+
+// percentDailyValue expresses totals as whole percentages of the daily values.
+func percentDailyValue(totals Nutrients) map[string]float64 {
+	percent := func(value float64, daily float64) float64 {
+		return float64(int(value/daily*100 + 0.5))
+	}
+	return map[string]float64{
+		"calories":      percent(totals.Calories, dailyValues.Calories),
+		"total_fat":     percent(totals.TotalFat, dailyValues.TotalFat),
+		"sat_fat":       percent(totals.SatFat, dailyValues.SatFat),
+		"cholesterol":   percent(totals.Cholesterol, dailyValues.Cholesterol),
+		"sodium":        percent(totals.Sodium, dailyValues.Sodium),
+		"total_carb":    percent(totals.TotalCarb, dailyValues.TotalCarb),
+		"dietary_fiber": percent(totals.DietaryFiber, dailyValues.DietaryFiber),
+		"sugars":        percent(totals.Sugars, dailyValues.Sugars),
+		"protein":       percent(totals.Protein, dailyValues.Protein),
+	}
+}
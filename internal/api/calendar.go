@@ -0,0 +1,600 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	googleAuthURL     = "https://accounts.google.com/o/oauth2/v2/auth"
+	googleTokenURL    = "https://oauth2.googleapis.com/token"
+	googleRevokeURL   = "https://oauth2.googleapis.com/revoke"
+	googleCalendarURL = "https://www.googleapis.com/calendar/v3/calendars/"
+	googleEventsScope = "https://www.googleapis.com/auth/calendar.events"
+
+	// calendarSyncDays is how many days from today are kept in calendars
+	calendarSyncDays = 7
+	// calendarStateTTL is how long a user has to finish authorizing
+	calendarStateTTL = 10 * time.Minute
+)
+
+// calendarMealStarts is when each meal's event starts, as a time of day in
+// the dining hall's time zone. Events end when the meal does; see mealEnds.
+var calendarMealStarts = map[string]time.Duration{
+	"breakfast": 7*time.Hour + 30*time.Minute,
+	"lunch":     11*time.Hour + 30*time.Minute,
+	"dinner":    17 * time.Hour,
+}
+
+// errCalendarRevoked is returned when Google no longer accepts a user's
+// refresh token, usually because they removed the app's access.
+var errCalendarRevoked = errors.New("google calendar access was revoked; connect again")
+
+// CalendarIntegration is a user's connection to Google Calendar. Each of the
+// chosen meals of the coming week is kept as an event in the calendar, and
+// updated when the menu changes.
+type CalendarIntegration struct {
+	UserID       primitive.ObjectID `json:"-" bson:"_id"`
+	CalendarID   string             `json:"calendar_id" bson:"calendar_id"`
+	Meals        []string           `json:"meals" bson:"meals"`
+	AccessToken  string             `json:"-" bson:"access_token"`
+	RefreshToken string             `json:"-" bson:"refresh_token"`
+	TokenExpiry  time.Time          `json:"-" bson:"token_expiry"`
+	ConnectedAt  time.Time          `json:"connected_at" bson:"connected_at"`
+	LastSyncedAt *time.Time         `json:"last_synced_at,omitempty" bson:"last_synced_at,omitempty"`
+	// LastError is why the last sync failed, cleared by the next that
+	// succeeds
+	LastError string `json:"last_error,omitempty" bson:"last_error,omitempty"`
+}
+
+type CalendarSettingsRequest struct {
+	CalendarID string   `json:"calendar_id"`
+	Meals      []string `json:"meals"`
+}
+
+// googleOAuthSettings are the OAuth client the integration authorizes as.
+// RedirectURL must be registered with the client, and route to
+// /integrations/google-calendar/callback.
+type googleOAuthSettings struct {
+	clientID     string
+	clientSecret string
+	redirectURL  string
+}
+
+func loadGoogleOAuthSettings() googleOAuthSettings {
+	return googleOAuthSettings{
+		clientID:     os.Getenv("GOOGLE_CLIENT_ID"),
+		clientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
+		redirectURL:  os.Getenv("GOOGLE_REDIRECT_URL"),
+	}
+}
+
+type CalendarService struct {
+	server       *Server
+	settings     googleOAuthSettings
+	httpClient   *http.Client
+	integrations *mongo.Collection
+}
+
+// startGoogleCalendar configures the Google Calendar integration, syncing
+// connected calendars after every refresh.
+func (s *Server) startGoogleCalendar() {
+	settings := loadGoogleOAuthSettings()
+	if settings.clientSecret == "" || settings.redirectURL == "" {
+		log.Println("GOOGLE_CLIENT_SECRET and GOOGLE_REDIRECT_URL must be set with GOOGLE_CLIENT_ID; Google Calendar sync is disabled")
+		return
+	}
+	s.calendar = &CalendarService{
+		server:       s,
+		settings:     settings,
+		httpClient:   &http.Client{Timeout: 15 * time.Second},
+		integrations: s.db.Collection("calendar_integrations"),
+	}
+	s.afterRefreshHooks = append(s.afterRefreshHooks, s.calendar.syncAfterRefresh)
+}
+
+func (g *CalendarService) routes(r gin.IRouter) {
+	r.GET("/integrations/google-calendar", g.server.requireUser, g.handleStatus)
+	r.PUT("/integrations/google-calendar", g.server.requireUser, g.handleUpdate)
+	r.DELETE("/integrations/google-calendar", g.server.requireUser, g.handleDisconnect)
+	r.POST("/integrations/google-calendar/authorize", g.server.requireUser, g.handleAuthorize)
+	r.GET("/integrations/google-calendar/callback", g.handleCallback)
+	r.POST("/integrations/google-calendar/sync", g.server.requireUser, g.handleSync)
+}
+
+// handleAuthorize returns the Google consent page to send the user to. Its
+// state names the user, signed so the callback can trust it.
+func (g *CalendarService) handleAuthorize(c *gin.Context) {
+	params := url.Values{
+		"client_id":     {g.settings.clientID},
+		"redirect_uri":  {g.settings.redirectURL},
+		"response_type": {"code"},
+		"scope":         {googleEventsScope},
+		// A refresh token is only issued with offline access, and only on
+		// consent, so ask again even if the user has connected before
+		"access_type": {"offline"},
+		"prompt":      {"consent"},
+		"state":       {g.signState(currentUser(c).ID, g.server.clock.Now().Add(calendarStateTTL))},
+	}
+	respond(c, http.StatusOK, gin.H{"auth_url": googleAuthURL + "?" + params.Encode()}, ResponseMeta{})
+}
+
+// handleCallback is where Google sends the user back to. It exchanges the
+// code for tokens, saves the connection and syncs the coming week.
+func (g *CalendarService) handleCallback(c *gin.Context) {
+	if reason := c.Query("error"); reason != "" {
+		respondErrorDetails(c, http.StatusBadRequest, CodeInvalidRequest, "google calendar access was not granted", gin.H{"reason": reason})
+		return
+	}
+	userID, ok := g.verifyState(c.Query("state"))
+	if !ok {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid or expired state; start connecting again")
+		return
+	}
+	code := c.Query("code")
+	if code == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "code is required")
+		return
+	}
+	if g.server.inMaintenance() {
+		respondError(c, http.StatusServiceUnavailable, CodeMaintenance, "the service is read-only for maintenance; try connecting again later")
+		return
+	}
+
+	ctx, cancel := g.server.dbContext(c.Request.Context())
+	defer cancel()
+	tokens, err := g.requestTokens(ctx, url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {g.settings.redirectURL},
+	})
+	if err != nil {
+		log.Printf("Failed to exchange a Google authorization code: %v\n", err)
+		respondError(c, http.StatusBadGateway, CodeUpstreamUnavailable, "failed to connect to Google")
+		return
+	}
+	if tokens.RefreshToken == "" {
+		respondError(c, http.StatusBadGateway, CodeUpstreamUnavailable, "google didn't grant offline access; start connecting again")
+		return
+	}
+
+	now := g.server.clock.Now()
+	integration := CalendarIntegration{
+		UserID:       userID,
+		CalendarID:   "primary",
+		Meals:        []string{"breakfast", "lunch", "dinner"},
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		TokenExpiry:  now.Add(time.Duration(tokens.ExpiresIn) * time.Second),
+		ConnectedAt:  now,
+	}
+	// Reconnecting keeps the calendar and meals chosen before
+	var existing CalendarIntegration
+	if err := g.integrations.FindOne(ctx, bson.M{"_id": userID}).Decode(&existing); err == nil {
+		integration.CalendarID, integration.Meals = existing.CalendarID, existing.Meals
+	} else if err != mongo.ErrNoDocuments {
+		log.Printf("Failed to look up a calendar integration: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to save the connection")
+		return
+	}
+	if _, err := g.integrations.ReplaceOne(ctx, bson.M{"_id": userID}, integration, options.Replace().SetUpsert(true)); err != nil {
+		log.Printf("Failed to save a calendar integration: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to save the connection")
+		return
+	}
+
+	go g.server.recoverJob("calendar-sync", func() {
+		ctx, cancel := g.server.jobContext()
+		defer cancel()
+		g.syncAndRecord(ctx, &integration, g.syncDates())
+	})()
+	respond(c, http.StatusOK, integration, ResponseMeta{})
+}
+
+func (g *CalendarService) handleStatus(c *gin.Context) {
+	integration, ok := g.findIntegration(c)
+	if !ok {
+		return
+	}
+	respond(c, http.StatusOK, integration, ResponseMeta{})
+}
+
+// handleUpdate changes the calendar and meals synced to, then resyncs.
+// Events already written to a previous calendar are left there.
+func (g *CalendarService) handleUpdate(c *gin.Context) {
+	var req CalendarSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+	integration, ok := g.findIntegration(c)
+	if !ok {
+		return
+	}
+	if req.CalendarID = strings.TrimSpace(req.CalendarID); req.CalendarID != "" {
+		integration.CalendarID = req.CalendarID
+	}
+	if req.Meals != nil {
+		meals := []string{}
+		for _, meal := range req.Meals {
+			meal = strings.ToLower(strings.TrimSpace(meal))
+			if _, ok := calendarMealStarts[meal]; !ok {
+				respondErrorDetails(c, http.StatusBadRequest, CodeInvalidRequest, "meals must be breakfast, lunch or dinner", gin.H{"meal": meal})
+				return
+			}
+			if !containsString(meals, meal) {
+				meals = append(meals, meal)
+			}
+		}
+		huds.SortMeals(meals)
+		integration.Meals = meals
+	}
+
+	ctx, cancel := g.server.dbContext(c.Request.Context())
+	defer cancel()
+	_, err := g.integrations.UpdateOne(ctx, bson.M{"_id": integration.UserID}, bson.M{"$set": bson.M{"calendar_id": integration.CalendarID, "meals": integration.Meals}})
+	if err != nil {
+		log.Printf("Failed to update a calendar integration: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to save the connection")
+		return
+	}
+	g.syncAndRecord(ctx, &integration, g.syncDates())
+	respond(c, http.StatusOK, integration, ResponseMeta{})
+}
+
+// handleSync syncs the coming week now, rather than after the next refresh.
+func (g *CalendarService) handleSync(c *gin.Context) {
+	integration, ok := g.findIntegration(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := g.server.jobContext()
+	defer cancel()
+	if err := g.syncAndRecord(ctx, &integration, g.syncDates()); err == errCalendarRevoked {
+		respondError(c, http.StatusConflict, CodeConflict, err.Error())
+		return
+	} else if err != nil {
+		respondError(c, http.StatusBadGateway, CodeUpstreamUnavailable, "failed to sync with Google Calendar")
+		return
+	}
+	respond(c, http.StatusOK, integration, ResponseMeta{})
+}
+
+// handleDisconnect revokes the app's access and forgets the connection.
+// Events already written are left in the calendar.
+func (g *CalendarService) handleDisconnect(c *gin.Context) {
+	integration, ok := g.findIntegration(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := g.server.dbContext(c.Request.Context())
+	defer cancel()
+	if err := g.revoke(ctx, integration.RefreshToken); err != nil {
+		// The user can still remove access from their Google account
+		log.Printf("Failed to revoke a Google token: %v\n", err)
+	}
+	if _, err := g.integrations.DeleteOne(ctx, bson.M{"_id": integration.UserID}); err != nil {
+		log.Printf("Failed to delete a calendar integration: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to disconnect")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// findIntegration loads the current user's connection, answering 404 if
+// they haven't connected.
+func (g *CalendarService) findIntegration(c *gin.Context) (CalendarIntegration, bool) {
+	var integration CalendarIntegration
+	ctx, cancel := g.server.dbContext(c.Request.Context())
+	defer cancel()
+	err := g.integrations.FindOne(ctx, bson.M{"_id": currentUser(c).ID}).Decode(&integration)
+	if err == mongo.ErrNoDocuments {
+		respondError(c, http.StatusNotFound, CodeNotFound, "google calendar is not connected")
+		return integration, false
+	}
+	if err != nil {
+		respondStoreError(c, err)
+		return integration, false
+	}
+	return integration, true
+}
+
+// syncDates are the serve dates kept in calendars, from today.
+func (g *CalendarService) syncDates() []string {
+	today, _ := time.Parse(huds.ServeDateLayout, g.server.today())
+	dates := make([]string, calendarSyncDays)
+	for i := range dates {
+		dates[i] = today.AddDate(0, 0, i).Format(huds.ServeDateLayout)
+	}
+	return dates
+}
+
+// syncAfterRefresh updates every connected calendar with the refreshed days
+// of the coming week.
+func (g *CalendarService) syncAfterRefresh(ctx context.Context, data map[string]map[int][]huds.CondensedMenuItem) {
+	var dates []string
+	for _, date := range g.syncDates() {
+		if _, ok := data[date]; ok {
+			dates = append(dates, date)
+		}
+	}
+	if len(dates) == 0 {
+		return
+	}
+	cursor, err := g.integrations.Find(ctx, bson.M{})
+	if err != nil {
+		log.Printf("Failed to find calendar integrations: %v\n", err)
+		return
+	}
+	var integrations []CalendarIntegration
+	if err := cursor.All(ctx, &integrations); err != nil {
+		log.Printf("Failed to decode calendar integrations: %v\n", err)
+		return
+	}
+	for i := range integrations {
+		g.syncAndRecord(ctx, &integrations[i], dates)
+	}
+}
+
+// syncAndRecord syncs dates to the integration's calendar, recording when
+// it did or why it failed.
+func (g *CalendarService) syncAndRecord(ctx context.Context, integration *CalendarIntegration, dates []string) error {
+	err := g.sync(ctx, integration, dates)
+	update := bson.M{}
+	if err != nil {
+		log.Printf("Failed to sync the calendar of user %s: %v\n", integration.UserID.Hex(), err)
+		integration.LastError = err.Error()
+		update["$set"] = bson.M{"last_error": integration.LastError}
+	} else {
+		now := g.server.clock.Now()
+		integration.LastSyncedAt, integration.LastError = &now, ""
+		update["$set"] = bson.M{"last_synced_at": now}
+		update["$unset"] = bson.M{"last_error": ""}
+	}
+	if _, updateErr := g.integrations.UpdateOne(ctx, bson.M{"_id": integration.UserID}, update); updateErr != nil {
+		log.Printf("Failed to record a calendar sync: %v\n", updateErr)
+	}
+	return err
+}
+
+// sync writes an event for each chosen meal on each date with a stored
+// menu. Event IDs are derived from the date and meal, so a day synced again
+// updates its events instead of adding more.
+func (g *CalendarService) sync(ctx context.Context, integration *CalendarIntegration, dates []string) error {
+	token, err := g.accessToken(ctx, integration)
+	if err != nil {
+		return err
+	}
+	for _, date := range dates {
+		menu, err := g.server.menuByDate(ctx, date)
+		if err == store.ErrMenuNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, meal := range integration.Meals {
+			items := huds.MealItems(menu, meal)
+			if len(items) == 0 {
+				continue
+			}
+			event, err := calendarEvent(date, meal, items)
+			if err != nil {
+				return err
+			}
+			if err := g.putEvent(ctx, token, integration.CalendarID, event); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// calendarEvent is a meal as a Google Calendar event, listing its items by
+// category.
+func calendarEvent(date string, meal string, items []huds.CondensedMenuItem) (gin.H, error) {
+	day, err := time.ParseInLocation(huds.ServeDateLayout, date, huds.DiningZone)
+	if err != nil {
+		return nil, err
+	}
+	var end time.Duration
+	for _, m := range mealEnds {
+		if m.meal == meal {
+			end = m.end
+		}
+	}
+
+	var description strings.Builder
+	categories, byCategory := groupByCategory(items)
+	for _, category := range categories {
+		var names []string
+		for _, item := range byCategory[category] {
+			if !item.Cancelled {
+				names = append(names, item.FoodName)
+			}
+		}
+		if len(names) > 0 {
+			fmt.Fprintf(&description, "%s: %s\n", category, strings.Join(names, ", "))
+		}
+	}
+	return gin.H{
+		// Google only allows a-v and digits in event IDs, which the meal
+		// keys happen to fit
+		"id":           "huds" + day.Format("20060102") + meal,
+		"summary":      "HUDS " + mealTitle(meal),
+		"description":  strings.TrimSpace(description.String()),
+		"start":        gin.H{"dateTime": day.Add(calendarMealStarts[meal]).Format(time.RFC3339), "timeZone": huds.DiningZone.String()},
+		"end":          gin.H{"dateTime": day.Add(end).Format(time.RFC3339), "timeZone": huds.DiningZone.String()},
+		"transparency": "transparent",
+	}, nil
+}
+
+// putEvent updates an event, inserting it if it doesn't exist yet.
+func (g *CalendarService) putEvent(ctx context.Context, token string, calendarID string, event gin.H) error {
+	events := googleCalendarURL + url.PathEscape(calendarID) + "/events"
+	status, err := g.calendarRequest(ctx, http.MethodPut, events+"/"+event["id"].(string), token, event)
+	if err == nil && status == http.StatusNotFound {
+		status, err = g.calendarRequest(ctx, http.MethodPost, events, token, event)
+	}
+	if err != nil {
+		return err
+	}
+	if status >= 300 {
+		return fmt.Errorf("google calendar returned %d", status)
+	}
+	return nil
+}
+
+func (g *CalendarService) calendarRequest(ctx context.Context, method string, endpoint string, token string, body interface{}) (int, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+type googleTokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	Error        string `json:"error"`
+}
+
+// accessToken returns a current access token for the integration, using the
+// refresh token to get a new one once it has expired.
+func (g *CalendarService) accessToken(ctx context.Context, integration *CalendarIntegration) (string, error) {
+	now := g.server.clock.Now()
+	if now.Add(time.Minute).Before(integration.TokenExpiry) {
+		return integration.AccessToken, nil
+	}
+	tokens, err := g.requestTokens(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {integration.RefreshToken},
+	})
+	if err != nil {
+		return "", err
+	}
+	integration.AccessToken = tokens.AccessToken
+	integration.TokenExpiry = now.Add(time.Duration(tokens.ExpiresIn) * time.Second)
+	_, err = g.integrations.UpdateOne(ctx, bson.M{"_id": integration.UserID}, bson.M{"$set": bson.M{"access_token": integration.AccessToken, "token_expiry": integration.TokenExpiry}})
+	if err != nil {
+		log.Printf("Failed to save a refreshed Google token: %v\n", err)
+	}
+	return integration.AccessToken, nil
+}
+
+func (g *CalendarService) requestTokens(ctx context.Context, form url.Values) (googleTokens, error) {
+	form.Set("client_id", g.settings.clientID)
+	form.Set("client_secret", g.settings.clientSecret)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, googleTokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return googleTokens{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return googleTokens{}, err
+	}
+	defer resp.Body.Close()
+
+	var tokens googleTokens
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return googleTokens{}, fmt.Errorf("google returned %d with an unreadable body: %v", resp.StatusCode, err)
+	}
+	if tokens.Error == "invalid_grant" {
+		return googleTokens{}, errCalendarRevoked
+	}
+	if resp.StatusCode >= 300 || tokens.AccessToken == "" {
+		return googleTokens{}, fmt.Errorf("google returned %d: %s", resp.StatusCode, tokens.Error)
+	}
+	return tokens, nil
+}
+
+func (g *CalendarService) revoke(ctx context.Context, token string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, googleRevokeURL, strings.NewReader(url.Values{"token": {token}}.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// 400 means the token was already revoked or expired
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("google returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// signState encodes a user and an expiry as OAuth state, signed with the
+// client secret.
+func (g *CalendarService) signState(userID primitive.ObjectID, expires time.Time) string {
+	payload := userID.Hex() + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + g.stateSignature(payload)
+}
+
+// verifyState returns the user a state was signed for, if it is intact and
+// hasn't expired.
+func (g *CalendarService) verifyState(state string) (primitive.ObjectID, bool) {
+	payload, signature, ok := cutLast(state, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(g.stateSignature(payload))) {
+		return primitive.ObjectID{}, false
+	}
+	user, expiry, ok := strings.Cut(payload, ".")
+	expires, err := strconv.ParseInt(expiry, 10, 64)
+	if !ok || err != nil || g.server.clock.Now().After(time.Unix(expires, 0)) {
+		return primitive.ObjectID{}, false
+	}
+	userID, err := primitive.ObjectIDFromHex(user)
+	return userID, err == nil
+}
+
+func (g *CalendarService) stateSignature(payload string) string {
+	mac := hmac.New(sha256.New, []byte(g.settings.clientSecret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// cutLast is strings.Cut around the last sep.
+func cutLast(s string, sep string) (string, string, bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
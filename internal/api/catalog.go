@@ -0,0 +1,258 @@
+package api
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// menuCatalog is what the catalog endpoints derive from every stored menu.
+// Building it reads the whole dataset, so it is kept until the next refresh.
+type menuCatalog struct {
+	Allergens  []AllergenCount
+	Categories []CategoryMeals
+	// Dates are the stored serve dates in order
+	Dates []time.Time
+	// Recipes are keyed by upstream recipe number
+	Recipes map[string]*recipeHistory
+	// Words counts every word of the food names, categories and
+	// ingredients served, for correcting misspelt searches
+	Words map[string]int
+	// Names are the distinct food names served, for suggestions
+	Names []*foodName
+}
+
+// recipeHistory is a recipe as it was last served, and every day it was.
+type recipeHistory struct {
+	Item   huds.CondensedMenuItem
+	Served []store.Occurrence
+	// Nutrition has a snapshot for every change to the recipe's nutrition
+	// facts, oldest first
+	Nutrition []nutritionSnapshot
+}
+
+type AllergenCount struct {
+	Allergen string `json:"allergen"`
+	// Foods is how many distinct foods list the allergen
+	Foods int `json:"foods"`
+	// Served is how many times such a food was on a menu, counting each meal
+	// of each day
+	Served int `json:"served"`
+}
+
+type CategoryMeals struct {
+	Category string `json:"category"`
+	// Meals are the meals the category has appeared at, in serving order
+	Meals []string `json:"meals"`
+	// Foods is how many distinct foods have been served in the category
+	Foods int `json:"foods"`
+}
+
+// loadCatalog returns the catalog, building it if a refresh has invalidated
+// it.
+func (s *Server) loadCatalog() (*menuCatalog, error) {
+	s.catalog.Lock()
+	defer s.catalog.Unlock()
+	if s.catalog.menus != nil {
+		return s.catalog.menus, nil
+	}
+
+	// The catalog is shared, so building it isn't bound to one request
+	ctx, cancel := s.jobContext()
+	defer cancel()
+	earliest, latest, err := s.store.EarliestLatest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	catalog := &menuCatalog{Allergens: []AllergenCount{}, Categories: []CategoryMeals{}, Recipes: make(map[string]*recipeHistory), Words: make(map[string]int)}
+	if earliest == "" {
+		s.catalog.menus = catalog
+		return catalog, nil
+	}
+	menus, err := s.store.GetRange(ctx, earliest, latest)
+	if err != nil {
+		return nil, err
+	}
+
+	allergens := make(map[string]*AllergenCount)
+	foods := make(map[string]map[string]bool)
+	categoryMeals := make(map[string]map[string]bool)
+	categoryFoods := make(map[string]map[string]bool)
+	names := make(map[string]*foodName)
+	for _, menu := range menus {
+		date, err := time.Parse(huds.ServeDateLayout, menu.ServeDate)
+		if err == nil {
+			catalog.Dates = append(catalog.Dates, date)
+		}
+		for _, meal := range menu.Meals() {
+			for _, item := range huds.MealItems(menu, meal) {
+				if err == nil {
+					catalog.indexFoodName(names, item, date)
+				}
+				if item.RecipeNumber != "" {
+					recipe, ok := catalog.Recipes[item.RecipeNumber]
+					if !ok {
+						recipe = &recipeHistory{}
+						catalog.Recipes[item.RecipeNumber] = recipe
+					}
+					// Menus are in order, so the last one seen is the latest
+					recipe.Item = item
+					recipe.recordNutrition(menu.ServeDate, item)
+					if last := len(recipe.Served) - 1; last >= 0 && recipe.Served[last].ServeDate == menu.ServeDate {
+						recipe.Served[last].Meals = append(recipe.Served[last].Meals, meal)
+					} else {
+						recipe.Served = append(recipe.Served, store.Occurrence{ServeDate: menu.ServeDate, Meals: []string{meal}})
+					}
+				}
+				if category := strings.TrimSpace(item.MenuCategory); category != "" {
+					if _, ok := categoryMeals[category]; !ok {
+						categoryMeals[category] = make(map[string]bool)
+						categoryFoods[category] = make(map[string]bool)
+					}
+					categoryMeals[category][meal] = true
+					categoryFoods[category][strings.ToLower(item.FoodName)] = true
+				}
+				for _, text := range []string{item.FoodName, item.MenuCategory, item.Ingredients} {
+					for _, word := range searchWords(text) {
+						catalog.Words[word]++
+					}
+				}
+				for _, allergen := range huds.Allergens(item.Allergens) {
+					count, ok := allergens[allergen]
+					if !ok {
+						count = &AllergenCount{Allergen: allergen}
+						allergens[allergen] = count
+						foods[allergen] = make(map[string]bool)
+					}
+					count.Served++
+					foods[allergen][strings.ToLower(item.FoodName)] = true
+				}
+			}
+		}
+	}
+	for allergen, count := range allergens {
+		count.Foods = len(foods[allergen])
+		catalog.Allergens = append(catalog.Allergens, *count)
+	}
+	sort.Slice(catalog.Allergens, func(i, j int) bool { return catalog.Allergens[i].Allergen < catalog.Allergens[j].Allergen })
+	for category, meals := range categoryMeals {
+		entry := CategoryMeals{Category: category, Meals: []string{}, Foods: len(categoryFoods[category])}
+		for meal := range meals {
+			entry.Meals = append(entry.Meals, meal)
+		}
+		huds.SortMeals(entry.Meals)
+		catalog.Categories = append(catalog.Categories, entry)
+	}
+	sort.Slice(catalog.Categories, func(i, j int) bool { return catalog.Categories[i].Category < catalog.Categories[j].Category })
+
+	s.catalog.menus = catalog
+	return catalog, nil
+}
+
+// resetMenuCatalog runs after every refresh, since new menus may bring new
+// dates, allergens, categories, recipes and food names.
+func (s *Server) resetMenuCatalog(context.Context, map[string]map[int][]huds.CondensedMenuItem) {
+	s.catalog.Lock()
+	defer s.catalog.Unlock()
+	s.catalog.menus = nil
+}
+
+// handleAllergens lists every allergen seen on a stored menu, so clients can
+// offer the ones that actually occur.
+func (s *Server) handleAllergens(c *gin.Context) {
+	catalog, err := s.loadCatalog()
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	respond(c, http.StatusOK, gin.H{"allergens": catalog.Allergens}, ResponseMeta{Source: SourceDB})
+}
+
+// handleCategories lists every menu category seen on a stored menu, with the
+// meals it is served at.
+func (s *Server) handleCategories(c *gin.Context) {
+	catalog, err := s.loadCatalog()
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	respond(c, http.StatusOK, gin.H{"categories": catalog.Categories}, ResponseMeta{Source: SourceDB})
+}
+
+// withCategories narrows a menu to the categories in ?category=, which may
+// list several separated by commas. Categories match case-insensitively.
+func withCategories(c *gin.Context, menu huds.CondensedMenu) huds.CondensedMenu {
+	var categories []string
+	for _, category := range strings.Split(c.Query("category"), ",") {
+		if category = strings.TrimSpace(category); category != "" {
+			categories = append(categories, category)
+		}
+	}
+	if len(categories) == 0 {
+		return menu
+	}
+	return menu.MapMeals(func(items []huds.CondensedMenuItem) []huds.CondensedMenuItem {
+		filtered := []huds.CondensedMenuItem{}
+		for _, item := range items {
+			for _, category := range categories {
+				if strings.EqualFold(category, strings.TrimSpace(item.MenuCategory)) {
+					filtered = append(filtered, item)
+					break
+				}
+			}
+		}
+		return filtered
+	})
+}
+
+type DateRange struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+type AvailableDates struct {
+	Earliest string `json:"earliest,omitempty"`
+	Latest   string `json:"latest,omitempty"`
+	// Days is how many days between them have a stored menu
+	Days int `json:"days"`
+	// Gaps are the runs of days between Earliest and Latest without one
+	Gaps []DateRange `json:"gaps"`
+}
+
+// handleDates describes which serve dates have menus, so date pickers can
+// disable the rest.
+func (s *Server) handleDates(c *gin.Context) {
+	dateFormat := c.DefaultQuery("date_format", s.dateFormat)
+	if !validDateFormat(dateFormat) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "date_format must be 'us' or 'iso'")
+		return
+	}
+	catalog, err := s.loadCatalog()
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	format := func(t time.Time) string {
+		return formatServeDate(t.Format(huds.ServeDateLayout), dateFormat)
+	}
+	dates := AvailableDates{Days: len(catalog.Dates), Gaps: []DateRange{}}
+	for i, date := range catalog.Dates {
+		if i == 0 {
+			dates.Earliest = format(date)
+			continue
+		}
+		if previous := catalog.Dates[i-1]; date.After(previous.AddDate(0, 0, 1)) {
+			dates.Gaps = append(dates.Gaps, DateRange{Start: format(previous.AddDate(0, 0, 1)), End: format(date.AddDate(0, 0, -1))})
+		}
+	}
+	if len(catalog.Dates) > 0 {
+		dates.Latest = format(catalog.Dates[len(catalog.Dates)-1])
+	}
+	respond(c, http.StatusOK, dates, ResponseMeta{Source: SourceDB})
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/provider"
+	"hudsgry-api/internal/store"
+	"io"
+	"log"
+	"time"
+)
+
+const (
+	ChangeMenuPublished = "menu.published"
+	ChangeMenuUpdated   = "menu.updated"
+	ChangeMenuRemoved   = "menu.removed"
+
+	streamKeepAlive = 30 * time.Second
+)
+
+// MenuChange describes how one meal on one day differs from what was stored.
+type MenuChange struct {
+	Type       string    `json:"type"`
+	ServeDate  string    `json:"Serve_Date"`
+	Meal       string    `json:"meal"`
+	Added      []string  `json:"added"`
+	Removed    []string  `json:"removed"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// checkForMenuChanges re-fetches the feed during the day and compares today's
+// and upcoming menus against what is stored. Only days that changed are
+// written, and each changed meal is published as an event.
+func (s *Server) checkForMenuChanges() {
+	if s.inMaintenance() {
+		return
+	}
+	ctx, cancel := s.jobContext()
+	defer cancel()
+	fetchCtx, cancelFetch := context.WithTimeout(ctx, s.fetchTimeout)
+	defer cancelFetch()
+	var items []huds.MenuItem
+	err := s.breaker.Call(func() error {
+		var err error
+		items, err = s.provider.FetchMenus(fetchCtx, provider.DateRange{})
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to fetch HUDS data for change detection: %v\n", err)
+		return
+	}
+
+	// The stored menus have the corrections applied, so the feed must too,
+	// or every corrected day would look changed
+	condensed, locations, err := s.condense(ctx, items)
+	if err != nil {
+		log.Printf("Failed to load overrides for change detection: %v\n", err)
+		return
+	}
+
+	startOfToday, _ := time.Parse(huds.ServeDateLayout, s.today())
+	now := s.clock.Now()
+	changed := make(map[string]map[int][]huds.CondensedMenuItem)
+	var changes []MenuChange
+	for date, meals := range condensed {
+		if served, err := time.Parse(huds.ServeDateLayout, date); err != nil || served.Before(startOfToday) {
+			continue
+		}
+		stored, err := s.store.GetByDate(ctx, date)
+		if err != nil && err != store.ErrMenuNotFound {
+			log.Printf("Failed to load %s for change detection: %v\n", date, err)
+			continue
+		}
+		changeType := ChangeMenuUpdated
+		if err == store.ErrMenuNotFound {
+			changeType = ChangeMenuPublished
+		}
+		storedMeals := huds.MealsFromMenu(stored)
+
+		numbers := make(map[int]bool)
+		for number := range meals {
+			numbers[number] = true
+		}
+		for number := range storedMeals {
+			numbers[number] = true
+		}
+		for number := range numbers {
+			added := missingItems(meals[number], storedMeals[number])
+			removed := missingItems(storedMeals[number], meals[number])
+			if len(added) == 0 && len(removed) == 0 {
+				continue
+			}
+			changed[date] = meals
+			changes = append(changes, MenuChange{
+				Type:       changeType,
+				ServeDate:  date,
+				Meal:       huds.MealPeriodKey(number),
+				Added:      foodNames(added),
+				Removed:    foodNames(removed),
+				DetectedAt: now,
+			})
+		}
+	}
+	if len(changed) == 0 {
+		log.Println("No intraday menu changes")
+		return
+	}
+
+	var changedItems []huds.MenuItem
+	for _, item := range items {
+		if _, ok := changed[item.ServeDate]; ok {
+			changedItems = append(changedItems, item)
+		}
+	}
+	s.archiveRaw(ctx, changedItems)
+
+	for date := range locations {
+		if _, ok := changed[date]; !ok {
+			delete(locations, date)
+		}
+	}
+	// Also refreshes the local cache if today changed
+	written, err := s.processDataAndStore(ctx, changed, locations)
+	if err != nil {
+		log.Printf("Failed to store changed menus: %v\n", err)
+		return
+	}
+	if err := s.upsertLocations(ctx, locations); err != nil {
+		log.Printf("Failed to store changed location menus: %v\n", err)
+	}
+	s.runRefreshHooks(ctx, changed, written)
+
+	log.Printf("Detected %d menu changes across %d days\n", len(changes), len(changed))
+	s.publishMenuChanges(ctx, changes)
+}
+
+func foodNames(items []huds.CondensedMenuItem) []string {
+	names := make([]string, 0, len(items))
+	for _, item := range items {
+		names = append(names, item.FoodName)
+	}
+	return names
+}
+
+func (s *Server) publishMenuChanges(ctx context.Context, changes []MenuChange) {
+	s.changeStreams.Lock()
+	for subscriber := range s.changeStreams.subscribers {
+		for _, change := range changes {
+			// A stream that can't keep up misses events rather than blocking everyone
+			select {
+			case subscriber <- change:
+			default:
+			}
+		}
+	}
+	s.changeStreams.Unlock()
+
+	for _, listener := range s.changeListeners {
+		listener(ctx, changes)
+	}
+}
+
+// handleChangeStream streams menu changes as server-sent events until the
+// client disconnects.
+func (s *Server) handleChangeStream(c *gin.Context) {
+	events := make(chan MenuChange, 64)
+	s.changeStreams.Lock()
+	s.changeStreams.subscribers[events] = struct{}{}
+	s.changeStreams.Unlock()
+	defer func() {
+		s.changeStreams.Lock()
+		delete(s.changeStreams.subscribers, events)
+		s.changeStreams.Unlock()
+	}()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case change := <-events:
+			c.SSEvent(change.Type, change)
+			return true
+		case <-keepAlive.C:
+			c.SSEvent("ping", s.clock.Now())
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
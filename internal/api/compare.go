@@ -0,0 +1,110 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const maxCompareItems = 10
+
+type ComparedItem struct {
+	ID                int                `json:"ID"`
+	FoodName          string             `json:"Food_Name"`
+	ServeDate         string             `json:"Serve_Date"`
+	Meal              string             `json:"meal"`
+	Nutrients         Nutrients          `json:"nutrients"`
+	PercentDailyValue map[string]float64 `json:"percent_daily_value"`
+	ServingSize       string             `json:"Serving_Size"`
+	Serving           *huds.Serving      `json:"serving,omitempty"`
+	// Per100g and Per100ml are Nutrients for 100g or 100ml of the item, so
+	// items served in different portions compare fairly; each is left out
+	// when the serving size doesn't give its weight or volume
+	Per100g  *Nutrients `json:"per_100g,omitempty"`
+	Per100ml *Nutrients `json:"per_100ml,omitempty"`
+	// Delta is this item minus the first one compared, left out for the
+	// first
+	Delta *NutrientDelta `json:"delta,omitempty"`
+}
+
+type NutrientDelta struct {
+	Nutrients         Nutrients          `json:"nutrients"`
+	PercentDailyValue map[string]float64 `json:"percent_daily_value"`
+}
+
+type Comparison struct {
+	Items []ComparedItem `json:"items"`
+}
+
+// handleCompare puts the nutrition of two or more items side by side, each
+// with its difference from the first, e.g. ?items=123,456 for grilled
+// chicken against chicken parm.
+func (s *Server) handleCompare(c *gin.Context) {
+	dateFormat := c.DefaultQuery("date_format", s.dateFormat)
+	if !validDateFormat(dateFormat) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "date_format must be 'us' or 'iso'")
+		return
+	}
+	var ids []int
+	for _, value := range strings.Split(c.Query("items"), ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		id, err := strconv.Atoi(value)
+		if err != nil || id < 1 {
+			respondErrorDetails(c, http.StatusBadRequest, CodeInvalidRequest, "invalid item id", gin.H{"id": value})
+			return
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) < 2 {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "items must list at least two item ids")
+		return
+	}
+	if len(ids) > maxCompareItems {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("at most %d items can be compared at once", maxCompareItems))
+		return
+	}
+
+	comparison := Comparison{Items: make([]ComparedItem, 0, len(ids))}
+	for _, id := range ids {
+		ctx, cancel := s.dbContext(c.Request.Context())
+		item, date, meal, err := s.store.ItemByID(ctx, id)
+		cancel()
+		if err == store.ErrMenuNotFound {
+			respondErrorDetails(c, http.StatusNotFound, CodeNotFound, "item not found", gin.H{"id": id})
+			return
+		}
+		if err != nil {
+			respondStoreError(c, err)
+			return
+		}
+		nutrients := itemNutrients(item)
+		serving := itemServing(item)
+		compared := ComparedItem{
+			ID:                item.ID,
+			FoodName:          item.FoodName,
+			ServeDate:         formatServeDate(date, dateFormat),
+			Meal:              meal,
+			Nutrients:         nutrients,
+			PercentDailyValue: percentDailyValue(nutrients),
+			ServingSize:       item.ServingSize,
+			Serving:           serving,
+		}
+		compared.Per100g, compared.Per100ml = per100(nutrients, serving)
+		if len(comparison.Items) > 0 {
+			first := comparison.Items[0]
+			delta := NutrientDelta{Nutrients: nutrients.Add(first.Nutrients.Scale(-1)), PercentDailyValue: make(map[string]float64)}
+			for nutrient, percent := range compared.PercentDailyValue {
+				delta.PercentDailyValue[nutrient] = percent - first.PercentDailyValue[nutrient]
+			}
+			compared.Delta = &delta
+		}
+		comparison.Items = append(comparison.Items, compared)
+	}
+	respond(c, http.StatusOK, comparison, ResponseMeta{Source: SourceDB})
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// mostExpensiveItems is how many of a meal's costliest items a cost report
+// lists.
+const mostExpensiveItems = 5
+
+// MenuCost is what a day's menu costs HUDS to make, per meal and for the
+// whole day.
+type MenuCost struct {
+	ServeDate string                 `json:"Serve_Date"`
+	Meals     map[string]CostSummary `json:"meals"`
+	Day       CostSummary            `json:"day"`
+}
+
+// CostSummary totals and averages the portion costs and selling prices of a
+// meal's items. Only the items HUDS has costed count towards them: Costed
+// have a portion cost and Priced a selling price, of Items in all.
+type CostSummary struct {
+	Items               int      `json:"items"`
+	Costed              int      `json:"costed"`
+	Priced              int      `json:"priced"`
+	TotalPortionCost    float64  `json:"total_portion_cost"`
+	AveragePortionCost  *float64 `json:"average_portion_cost,omitempty"`
+	TotalSellingPrice   float64  `json:"total_selling_price"`
+	AverageSellingPrice *float64 `json:"average_selling_price,omitempty"`
+	// Categories average the portion cost within each menu category, most
+	// expensive first, so a plate of one from each can be priced
+	Categories []CategoryCost `json:"categories"`
+	// MostExpensive are the items with the highest portion cost
+	MostExpensive []CostedItem `json:"most_expensive"`
+}
+
+type CategoryCost struct {
+	Category           string  `json:"category"`
+	Costed             int     `json:"costed"`
+	AveragePortionCost float64 `json:"average_portion_cost"`
+}
+
+type CostedItem struct {
+	ID           int      `json:"ID,omitempty"`
+	RecipeNumber string   `json:"Recipe_Number,omitempty"`
+	FoodName     string   `json:"Food_Name"`
+	MenuCategory string   `json:"Menu_Category_Name"`
+	PortionCost  *float64 `json:"Portion_Cost,omitempty"`
+	SellingPrice *float64 `json:"Selling_Price,omitempty"`
+}
+
+func roundCents(dollars float64) float64 {
+	return math.Round(dollars*100) / 100
+}
+
+// menuCost summarizes each meal's costs and the day's. Cancelled items and
+// repeats of a dish within a meal are left out; a dish served at two meals is
+// made, and counted, twice.
+func menuCost(menu huds.CondensedMenu) MenuCost {
+	cost := MenuCost{ServeDate: menu.ServeDate, Meals: map[string]CostSummary{}}
+	var all []huds.CondensedMenuItem
+	for _, meal := range menu.Meals() {
+		var items []huds.CondensedMenuItem
+		seen := make(map[string]bool)
+		for _, item := range huds.MealItems(menu, meal) {
+			key := strings.ToLower(strings.TrimSpace(item.FoodName))
+			if item.Cancelled || seen[key] {
+				continue
+			}
+			seen[key] = true
+			items = append(items, item)
+		}
+		cost.Meals[meal] = summarizeCost(items)
+		all = append(all, items...)
+	}
+	cost.Day = summarizeCost(all)
+	return cost
+}
+
+func summarizeCost(items []huds.CondensedMenuItem) CostSummary {
+	summary := CostSummary{Items: len(items), Categories: []CategoryCost{}, MostExpensive: []CostedItem{}}
+	type category struct {
+		costed int
+		total  float64
+	}
+	categories := make(map[string]*category)
+	var costed []huds.CondensedMenuItem
+	for _, item := range items {
+		if item.SellingPrice != nil {
+			summary.Priced++
+			summary.TotalSellingPrice += *item.SellingPrice
+		}
+		if item.PortionCost == nil {
+			continue
+		}
+		summary.Costed++
+		summary.TotalPortionCost += *item.PortionCost
+		costed = append(costed, item)
+		c, ok := categories[item.MenuCategory]
+		if !ok {
+			c = &category{}
+			categories[item.MenuCategory] = c
+		}
+		c.costed++
+		c.total += *item.PortionCost
+	}
+	if summary.Costed > 0 {
+		average := roundCents(summary.TotalPortionCost / float64(summary.Costed))
+		summary.AveragePortionCost = &average
+	}
+	if summary.Priced > 0 {
+		average := roundCents(summary.TotalSellingPrice / float64(summary.Priced))
+		summary.AverageSellingPrice = &average
+	}
+	summary.TotalPortionCost = roundCents(summary.TotalPortionCost)
+	summary.TotalSellingPrice = roundCents(summary.TotalSellingPrice)
+
+	for name, c := range categories {
+		summary.Categories = append(summary.Categories, CategoryCost{Category: name, Costed: c.costed, AveragePortionCost: roundCents(c.total / float64(c.costed))})
+	}
+	sort.Slice(summary.Categories, func(i, j int) bool {
+		if summary.Categories[i].AveragePortionCost != summary.Categories[j].AveragePortionCost {
+			return summary.Categories[i].AveragePortionCost > summary.Categories[j].AveragePortionCost
+		}
+		return summary.Categories[i].Category < summary.Categories[j].Category
+	})
+
+	sort.SliceStable(costed, func(i, j int) bool { return *costed[i].PortionCost > *costed[j].PortionCost })
+	if len(costed) > mostExpensiveItems {
+		costed = costed[:mostExpensiveItems]
+	}
+	for _, item := range costed {
+		summary.MostExpensive = append(summary.MostExpensive, CostedItem{
+			ID:           item.ID,
+			RecipeNumber: item.RecipeNumber,
+			FoodName:     strings.TrimSpace(item.FoodName),
+			MenuCategory: item.MenuCategory,
+			PortionCost:  item.PortionCost,
+			SellingPrice: item.SellingPrice,
+		})
+	}
+	return summary
+}
+
+// handleMenuCost reports what a day's menu costs, per meal and for the whole
+// day, from the portion costs and selling prices HUDS sends. Days stored
+// before prices were kept have none costed.
+func (s *Server) handleMenuCost(c *gin.Context) {
+	serveDate := serveDateParam(c).Format(huds.ServeDateLayout)
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	menu, err := s.menuByDate(ctx, serveDate)
+	if err == store.ErrMenuNotFound {
+		respondError(c, http.StatusNotFound, CodeNotFound, "no menu for this date")
+		return
+	}
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, menuCost(menu), ResponseMeta{ServeDate: serveDate, Source: SourceDB, LastUpdated: menu.UpdatedAt, Stale: s.revalidateStale(menu)})
+}
@@ -0,0 +1,236 @@
+package api
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"log"
+	"math"
+	"net/http"
+	"time"
+)
+
+const (
+	// cycleLookback is how far back from the latest stored menu cycles are
+	// detected from, so that a cycle replaced between semesters fades out
+	cycleLookback = 365 * 24 * time.Hour
+	// maxCycleWeeks is the longest rotation looked for
+	maxCycleWeeks = 8
+	// minCyclePairs is how many days a candidate length must be able to
+	// compare with the day a cycle before to be considered
+	minCyclePairs = 14
+	// minCycleSimilarity is the similarity below which the menus aren't
+	// taken to repeat at all
+	minCycleSimilarity = 0.25
+	// cycleTolerance lets a shorter length win over a longer one scoring
+	// slightly better, since a three-week cycle also repeats every six
+	cycleTolerance = 0.05
+)
+
+// MenuCycle is the rotation HUDS menus were detected to repeat on.
+type MenuCycle struct {
+	LengthDays  int `json:"length_days"`
+	LengthWeeks int `json:"length_weeks"`
+	// Similarity is how alike days a cycle apart are on average, from 0 to
+	// 1, as the share of recipes they have in common
+	Similarity float64 `json:"similarity"`
+	// Days is how many stored days the cycle was detected from
+	Days int `json:"days"`
+	// Position is where the requested date, today by default, falls in the
+	// cycle
+	Position CyclePosition `json:"position"`
+	// Candidates are every length that was tried, shortest first
+	Candidates []CycleCandidate `json:"candidates"`
+	DetectedAt time.Time        `json:"detected_at"`
+
+	// anchor is a Monday that starts a cycle
+	anchor time.Time
+}
+
+type CyclePosition struct {
+	ServeDate string `json:"serve_date"`
+	// Day and Week count from 1 at the start of the cycle
+	Day  int `json:"day"`
+	Week int `json:"week"`
+	// Start is the serve date the cycle containing ServeDate began on
+	Start string `json:"start"`
+}
+
+type CycleCandidate struct {
+	Weeks      int     `json:"weeks"`
+	Similarity float64 `json:"similarity"`
+	// Pairs is how many days could be compared with the day a cycle before
+	Pairs int `json:"pairs"`
+}
+
+// position places date in the cycle.
+func (cycle *MenuCycle) position(date time.Time, format string) CyclePosition {
+	offset := int(date.Sub(cycle.anchor).Hours()/24) % cycle.LengthDays
+	if offset < 0 {
+		offset += cycle.LengthDays
+	}
+	return CyclePosition{
+		ServeDate: formatServeDate(date.Format(huds.ServeDateLayout), format),
+		Day:       offset + 1,
+		Week:      offset/7 + 1,
+		Start:     formatServeDate(date.AddDate(0, 0, -offset).Format(huds.ServeDateLayout), format),
+	}
+}
+
+// detectMenuCycle re-runs cycle detection after every refresh.
+func (s *Server) detectMenuCycle(ctx context.Context, _ map[string]map[int][]huds.CondensedMenuItem) {
+	cycle, err := s.findMenuCycle(ctx)
+	if err != nil {
+		log.Printf("Failed to detect the menu cycle: %v\n", err)
+		return
+	}
+	s.cycle.Lock()
+	defer s.cycle.Unlock()
+	s.cycle.detected, s.cycle.detectedOnce = cycle, true
+}
+
+// loadMenuCycle returns the detected cycle, detecting it first if no
+// refresh has since startup. It is nil if the menus don't repeat.
+func (s *Server) loadMenuCycle() (*MenuCycle, error) {
+	s.cycle.Lock()
+	defer s.cycle.Unlock()
+	if s.cycle.detectedOnce {
+		return s.cycle.detected, nil
+	}
+	ctx, cancel := s.jobContext()
+	defer cancel()
+	cycle, err := s.findMenuCycle(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.cycle.detected, s.cycle.detectedOnce = cycle, true
+	return cycle, nil
+}
+
+// findMenuCycle compares each stored day with the day a whole number of
+// weeks before, and picks the shortest number of weeks after which the
+// menus most repeat.
+func (s *Server) findMenuCycle(ctx context.Context) (*MenuCycle, error) {
+	days, err := s.servedRecipesByDay(ctx)
+	if err != nil || len(days) == 0 {
+		return nil, err
+	}
+
+	cycle := &MenuCycle{Days: len(days), Candidates: []CycleCandidate{}, DetectedAt: s.clock.Now().UTC()}
+	best := -1.0
+	for weeks := 1; weeks <= maxCycleWeeks; weeks++ {
+		candidate := CycleCandidate{Weeks: weeks}
+		var total float64
+		for date, recipes := range days {
+			before, ok := days[date.AddDate(0, 0, -7*weeks)]
+			if !ok {
+				continue
+			}
+			total += jaccard(recipes, before)
+			candidate.Pairs++
+		}
+		if candidate.Pairs < minCyclePairs {
+			continue
+		}
+		candidate.Similarity = math.Round(total/float64(candidate.Pairs)*1000) / 1000
+		cycle.Candidates = append(cycle.Candidates, candidate)
+		best = math.Max(best, candidate.Similarity)
+	}
+	if best < minCycleSimilarity {
+		return nil, nil
+	}
+	for _, candidate := range cycle.Candidates {
+		if candidate.Similarity >= best-cycleTolerance {
+			cycle.LengthWeeks, cycle.LengthDays, cycle.Similarity = candidate.Weeks, 7*candidate.Weeks, candidate.Similarity
+			break
+		}
+	}
+
+	var earliest time.Time
+	for date := range days {
+		if earliest.IsZero() || date.Before(earliest) {
+			earliest = date
+		}
+	}
+	cycle.anchor = store.WeekStart(earliest)
+	return cycle, nil
+}
+
+// servedRecipesByDay returns the recipes served on each stored day within
+// cycleLookback of the latest, keyed as for ?dedupe=.
+func (s *Server) servedRecipesByDay(ctx context.Context) (map[time.Time]map[string]bool, error) {
+	_, latest, err := s.store.EarliestLatest(ctx)
+	if err != nil || latest == "" {
+		return nil, err
+	}
+	end, err := time.Parse(huds.ServeDateLayout, latest)
+	if err != nil {
+		return nil, err
+	}
+	menus, err := s.store.GetRange(ctx, end.Add(-cycleLookback).Format(huds.ServeDateLayout), latest)
+	if err != nil {
+		return nil, err
+	}
+	days := make(map[time.Time]map[string]bool)
+	for _, menu := range menus {
+		date, err := time.Parse(huds.ServeDateLayout, menu.ServeDate)
+		if err != nil {
+			continue
+		}
+		recipes := make(map[string]bool)
+		for _, meal := range menu.Meals() {
+			for _, item := range huds.MealItems(menu, meal) {
+				recipes[dedupeKeyOf(item)] = true
+			}
+		}
+		if len(recipes) > 0 {
+			days[date] = recipes
+		}
+	}
+	return days, nil
+}
+
+// jaccard is the share of the recipes on either day that are on both.
+func jaccard(a map[string]bool, b map[string]bool) float64 {
+	shared := 0
+	for recipe := range a {
+		if b[recipe] {
+			shared++
+		}
+	}
+	if union := len(a) + len(b) - shared; union > 0 {
+		return float64(shared) / float64(union)
+	}
+	return 0
+}
+
+// handleMenuCycle serves /cycle: the rotation the menus repeat on, and where
+// ?serve_date=, today by default, falls in it.
+func (s *Server) handleMenuCycle(c *gin.Context) {
+	date, ok := s.queryDate(c, "serve_date")
+	if !ok {
+		return
+	}
+	if date.IsZero() {
+		date, _ = time.Parse(huds.ServeDateLayout, s.today())
+	}
+	dateFormat := c.DefaultQuery("date_format", s.dateFormat)
+	if !validDateFormat(dateFormat) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "date_format must be 'us' or 'iso'")
+		return
+	}
+	detected, err := s.loadMenuCycle()
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	if detected == nil {
+		respondError(c, http.StatusNotFound, CodeNotFound, "no repeating menu cycle found in the stored menus")
+		return
+	}
+
+	cycle := *detected
+	cycle.Position = cycle.position(date, dateFormat)
+	respond(c, http.StatusOK, cycle, ResponseMeta{Source: SourceDB})
+}
@@ -0,0 +1,291 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"hudsgry-api/internal/alerting"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/reporting"
+	"hudsgry-api/internal/store"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The kinds of anomaly a data quality check records. Missing dates and meals
+// mean users will find nothing to eat; the item kinds are usually HUDS
+// leaving a field blank.
+const (
+	AnomalyMissingDate    = "missing_date"
+	AnomalyMissingMeal    = "missing_meal"
+	AnomalyZeroCalories   = "zero_calories"
+	AnomalyEmptyAllergens = "empty_allergens"
+)
+
+const (
+	// dataQualityRetention is how long reports are kept in MongoDB
+	dataQualityRetention = 90 * 24 * time.Hour
+	// maxDataQualityReports is the most reports listed at once
+	maxDataQualityReports = 50
+)
+
+// DataQualityReport is what a check of a refresh's data found.
+type DataQualityReport struct {
+	ID        string    `json:"id" bson:"_id"`
+	CheckedAt time.Time `json:"checked_at" bson:"checked_at"`
+	// Start and End are the first and last serve dates refreshed; every day
+	// between them is expected to have a menu
+	Start string `json:"start" bson:"start"`
+	End   string `json:"end" bson:"end"`
+	Days  int    `json:"days" bson:"days"`
+	Items int    `json:"items" bson:"items"`
+	// Counts are the number of anomalies of each kind
+	Counts    map[string]int `json:"counts" bson:"counts"`
+	Anomalies []Anomaly      `json:"anomalies" bson:"anomalies"`
+	ExpiresAt time.Time      `json:"-" bson:"expires_at"`
+}
+
+// Anomaly is one problem with a day's data. Meal is empty for a missing date,
+// and the food fields are only set for item anomalies.
+type Anomaly struct {
+	ServeDate    string `json:"serve_date" bson:"serve_date"`
+	Kind         string `json:"kind" bson:"kind"`
+	Meal         string `json:"meal,omitempty" bson:"meal,omitempty"`
+	FoodName     string `json:"food_name,omitempty" bson:"food_name,omitempty"`
+	RecipeNumber string `json:"recipe_number,omitempty" bson:"recipe_number,omitempty"`
+}
+
+// missing reports whether the anomaly leaves users without a meal.
+func (a Anomaly) missing() bool {
+	return a.Kind == AnomalyMissingDate || a.Kind == AnomalyMissingMeal
+}
+
+// setupDataQuality prepares the collection reports are kept in.
+func (s *Server) setupDataQuality() {
+	s.dataQualityReports = s.db.Collection("data_quality_reports")
+	s.ensureIndexes("data quality",
+		store.IndexSpec{Collection: "data_quality_reports", Model: mongo.IndexModel{
+			Keys: bson.D{{Key: "checked_at", Value: -1}},
+		}},
+		store.IndexSpec{Collection: "data_quality_reports", Model: mongo.IndexModel{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		}},
+	)
+}
+
+// checkDataQuality validates the data of every refresh, keeps the report and,
+// when DATA_QUALITY_ALERTS is set, reports dates or meals that have newly
+// gone missing and alerts the configured channels.
+func (s *Server) checkDataQuality(ctx context.Context, data map[string]map[int][]huds.CondensedMenuItem) {
+	report := s.validateData(data)
+	if report == nil {
+		return
+	}
+
+	s.dataQuality.Lock()
+	previous := s.dataQuality.latest
+	s.dataQuality.latest = report
+	s.dataQuality.Unlock()
+
+	if len(report.Anomalies) > 0 {
+		log.Printf("Data quality check of %s to %s found %d anomalies: %v\n", report.Start, report.End, len(report.Anomalies), report.Counts)
+	}
+	if s.dataQualityReports != nil {
+		if _, err := s.dataQualityReports.InsertOne(ctx, report); err != nil {
+			log.Printf("Failed to save data quality report: %v\n", err)
+		}
+	}
+	if os.Getenv("DATA_QUALITY_ALERTS") == "true" {
+		s.alertMissingData(report, previous)
+	}
+}
+
+// validateData checks that every day from the first to the last refreshed
+// has breakfast, lunch and dinner, and that every item has calories and
+// allergens. It is nil when there is nothing to check.
+func (s *Server) validateData(data map[string]map[int][]huds.CondensedMenuItem) *DataQualityReport {
+	dates := make([]time.Time, 0, len(data))
+	for date := range data {
+		if t, err := time.Parse(huds.ServeDateLayout, date); err == nil {
+			dates = append(dates, t)
+		}
+	}
+	if len(dates) == 0 {
+		return nil
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+
+	now := s.clock.Now().UTC()
+	report := &DataQualityReport{
+		ID:        strconv.FormatInt(now.UnixNano(), 36),
+		CheckedAt: now,
+		Start:     dates[0].Format(huds.ServeDateLayout),
+		End:       dates[len(dates)-1].Format(huds.ServeDateLayout),
+		Counts:    map[string]int{},
+		Anomalies: []Anomaly{},
+		ExpiresAt: now.Add(dataQualityRetention),
+	}
+	add := func(anomaly Anomaly) {
+		report.Counts[anomaly.Kind]++
+		report.Anomalies = append(report.Anomalies, anomaly)
+	}
+	for day := dates[0]; !day.After(dates[len(dates)-1]); day = day.AddDate(0, 0, 1) {
+		report.Days++
+		date := day.Format(huds.ServeDateLayout)
+		meals, ok := data[date]
+		if !ok {
+			add(Anomaly{ServeDate: date, Kind: AnomalyMissingDate})
+			continue
+		}
+		for number := 1; number <= 3; number++ {
+			if len(meals[number]) == 0 {
+				add(Anomaly{ServeDate: date, Kind: AnomalyMissingMeal, Meal: huds.MealPeriodKey(number)})
+			}
+		}
+
+		numbers := make([]int, 0, len(meals))
+		for number := range meals {
+			numbers = append(numbers, number)
+		}
+		sort.Ints(numbers)
+		for _, number := range numbers {
+			meal := huds.MealPeriodKey(number)
+			// The same dish is often listed under several categories
+			seen := make(map[string]bool)
+			for _, item := range meals[number] {
+				report.Items++
+				key := item.RecipeNumber
+				if key == "" {
+					key = item.FoodName
+				}
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				anomaly := Anomaly{ServeDate: date, Meal: meal, FoodName: item.FoodName, RecipeNumber: item.RecipeNumber}
+				if calories, err := strconv.ParseFloat(strings.TrimSpace(item.Calories), 64); err != nil || calories == 0 {
+					anomaly.Kind = AnomalyZeroCalories
+					add(anomaly)
+				}
+				if strings.TrimSpace(item.Allergens) == "" {
+					anomaly.Kind = AnomalyEmptyAllergens
+					add(anomaly)
+				}
+			}
+		}
+	}
+	return report
+}
+
+// alertMissingData reports the dates and meals missing from report that
+// weren't already missing from the previous one, so each gap is only
+// alerted once.
+func (s *Server) alertMissingData(report *DataQualityReport, previous *DataQualityReport) {
+	known := make(map[Anomaly]bool)
+	if previous != nil {
+		for _, anomaly := range previous.Anomalies {
+			if anomaly.missing() {
+				known[anomaly] = true
+			}
+		}
+	}
+	var gaps []string
+	for _, anomaly := range report.Anomalies {
+		if !anomaly.missing() || known[anomaly] {
+			continue
+		}
+		if anomaly.Kind == AnomalyMissingDate {
+			gaps = append(gaps, anomaly.ServeDate)
+		} else {
+			gaps = append(gaps, anomaly.ServeDate+" "+anomaly.Meal)
+		}
+	}
+	if len(gaps) == 0 {
+		return
+	}
+	message := fmt.Sprintf("Menus missing after refresh: %s", strings.Join(gaps, ", "))
+	s.reporter.Report(reporting.Event{
+		Level:   "warning",
+		Message: message,
+		Tags:    map[string]string{"check": "data_quality", "report": report.ID},
+		Time:    time.Now(),
+	})
+	s.sendAlert(alerting.Alert{
+		Key:     "data-quality-" + report.ID,
+		Summary: message,
+		Details: "See /admin/data-quality for the full report.",
+	})
+}
+
+// handleDataQuality serves the latest data quality report, or with
+// ?history=true the most recent reports kept in MongoDB, newest first.
+func (s *Server) handleDataQuality(c *gin.Context) {
+	dateFormat := c.DefaultQuery("date_format", s.dateFormat)
+	if c.Query("history") != "true" {
+		s.dataQuality.Lock()
+		report := s.dataQuality.latest
+		s.dataQuality.Unlock()
+		if report == nil && s.dataQualityReports != nil {
+			ctx, cancel := s.dbContext(c.Request.Context())
+			defer cancel()
+			var stored DataQualityReport
+			err := s.dataQualityReports.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.M{"checked_at": -1})).Decode(&stored)
+			if err != nil && err != mongo.ErrNoDocuments {
+				respondStoreError(c, err)
+				return
+			}
+			if err == nil {
+				report = &stored
+			}
+		}
+		if report == nil {
+			respondError(c, http.StatusNotFound, CodeNotFound, "no refresh has been checked yet")
+			return
+		}
+		respond(c, http.StatusOK, report.formatted(dateFormat), ResponseMeta{})
+		return
+	}
+
+	if s.dataQualityReports == nil {
+		respondError(c, http.StatusNotImplemented, CodeNotImplemented, "report history needs MongoDB")
+		return
+	}
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	cursor, err := s.dataQualityReports.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"checked_at": -1}).SetLimit(maxDataQualityReports))
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	reports := []DataQualityReport{}
+	if err := cursor.All(ctx, &reports); err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	for i := range reports {
+		reports[i] = reports[i].formatted(dateFormat)
+	}
+	respond(c, http.StatusOK, reports, ResponseMeta{})
+}
+
+// formatted is a copy of the report with its dates in format.
+func (report *DataQualityReport) formatted(format string) DataQualityReport {
+	copied := *report
+	copied.Start = formatServeDate(report.Start, format)
+	copied.End = formatServeDate(report.End, format)
+	copied.Anomalies = make([]Anomaly, len(report.Anomalies))
+	for i, anomaly := range report.Anomalies {
+		anomaly.ServeDate = formatServeDate(anomaly.ServeDate, format)
+		copied.Anomalies[i] = anomaly
+	}
+	return copied
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"hudsgry-api/internal/huds"
+	"os"
+	"time"
+)
+
+const (
+	DateFormatUS  = "us"
+	DateFormatISO = "iso"
+)
+
+// LoadDateFormat returns the format used when a request doesn't ask for one
+// explicitly. It can be changed for the whole service with the DATE_FORMAT
+// variable.
+func LoadDateFormat() (string, error) {
+	format := os.Getenv("DATE_FORMAT")
+	if format == "" {
+		return DateFormatUS, nil
+	}
+	if !validDateFormat(format) {
+		return "", fmt.Errorf("DATE_FORMAT must be %q or %q, got %q", DateFormatUS, DateFormatISO, format)
+	}
+	return format, nil
+}
+
+func validDateFormat(format string) bool {
+	return format == DateFormatUS || format == DateFormatISO
+}
+
+// formatServeDate converts a stored MM/DD/YYYY serve date into the given format.
+// Dates that don't parse are passed through untouched.
+func formatServeDate(date string, format string) string {
+	if format != DateFormatISO {
+		return date
+	}
+	t, err := time.Parse(huds.ServeDateLayout, date)
+	if err != nil {
+		return date
+	}
+	return t.Format("2006-01-02")
+}
+
+// DatedMenu serializes a CondensedMenu with its serve dates in DateFormat,
+// each item narrowed to Fields if any are given, each meal grouped by
+// GroupBy if set, and each meal split into HallViews if SplitHalls is set.
+type DatedMenu struct {
+	huds.CondensedMenu
+	DateFormat string
+	Fields     []string
+	GroupBy    string
+	SplitHalls bool
+}
+
+func (m DatedMenu) MarshalJSON() ([]byte, error) {
+	out := formatMenuDates(m.CondensedMenu, m.DateFormat)
+	data, err := json.Marshal(out)
+	if err != nil || (len(m.Fields) == 0 && m.GroupBy == "" && !m.SplitHalls) {
+		return data, err
+	}
+
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+	encode := selectFields
+	if m.GroupBy == GroupByCategory {
+		encode = encodeByCategory
+	}
+	meals := out.Meals()
+	var annenberg huds.CondensedMenu
+	if m.SplitHalls && m.Annenberg != nil {
+		annenberg = formatMenuDates(m.Annenberg.Menu(m.ServeDate), m.DateFormat)
+		for _, meal := range annenberg.Meals() {
+			if !containsString(meals, meal) {
+				meals = append(meals, meal)
+			}
+		}
+	}
+	for _, meal := range meals {
+		items, err := encode(huds.MealItems(out, meal), m.Fields)
+		if err != nil {
+			return nil, err
+		}
+		if m.SplitHalls {
+			views := HallViews{Annenberg: json.RawMessage("null"), Houses: items}
+			if m.Annenberg != nil {
+				if views.Annenberg, err = encode(huds.MealItems(annenberg, meal), m.Fields); err != nil {
+					return nil, err
+				}
+			}
+			if items, err = json.Marshal(views); err != nil {
+				return nil, err
+			}
+		}
+		keys[huds.MealResponseKey(meal)] = items
+	}
+	return json.Marshal(keys)
+}
+
+// formatMenuDates returns a copy of the menu with its serve dates in format.
+func formatMenuDates(menu huds.CondensedMenu, format string) huds.CondensedMenu {
+	out := menu
+	out.ServeDate = formatServeDate(out.ServeDate, format)
+	out.Breakfast = formatItemDates(out.Breakfast, format)
+	out.Lunch = formatItemDates(out.Lunch, format)
+	out.Dinner = formatItemDates(out.Dinner, format)
+	out.Extra = make([]huds.ExtraMeal, len(menu.Extra))
+	for i, extra := range menu.Extra {
+		extra.Items = formatItemDates(extra.Items, format)
+		out.Extra[i] = extra
+	}
+	return out
+}
+
+func formatItemDates(items []huds.CondensedMenuItem, format string) []huds.CondensedMenuItem {
+	if format != DateFormatISO {
+		return items
+	}
+	formatted := make([]huds.CondensedMenuItem, len(items))
+	for i, item := range items {
+		if item.ServeDate != nil {
+			date := formatServeDate(*item.ServeDate, format)
+			item.ServeDate = &date
+		}
+		formatted[i] = item
+	}
+	return formatted
+}
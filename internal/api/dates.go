@@ -0,0 +1,76 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// firstServeDate is the first day HUDS has menus for.
+var firstServeDate = time.Date(2023, time.May, 5, 0, 0, 0, 0, time.UTC)
+
+// Dates are accepted in either date format, and in the US format without
+// leading zeros.
+var dateLayouts = []string{huds.ServeDateLayout, "1/2/2006", "2006-01-02"}
+
+const serveDateKey = "serve_date"
+
+// parseDate parses a date in any of dateLayouts, as midnight UTC like the
+// dates parsed from the store. "today" and "tomorrow" are resolved in the
+// dining hall's time zone, so shortcuts and voice assistants don't have to
+// format dates themselves.
+func (s *Server) parseDate(value string) (time.Time, error) {
+	today, _ := time.Parse(huds.ServeDateLayout, s.today())
+	switch strings.ToLower(value) {
+	case "today":
+		return today, nil
+	case "tomorrow":
+		return today.AddDate(0, 0, 1), nil
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date", value)
+}
+
+// bindServeDate parses the required ?serve_date= for the handler after it,
+// which reads it with serveDateParam. Missing and malformed dates are
+// answered with 400 here.
+func (s *Server) bindServeDate(c *gin.Context) {
+	value := c.Query("serve_date")
+	if value == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "serve_date query parameter is required")
+		return
+	}
+	date, err := s.parseDate(value)
+	if err != nil {
+		respondErrorDetails(c, http.StatusBadRequest, CodeDateInvalid, "serve_date must be MM/DD/YYYY, YYYY-MM-DD, today or tomorrow", gin.H{"serve_date": value})
+		return
+	}
+	c.Set(serveDateKey, date)
+}
+
+// serveDateParam returns the date bound by bindServeDate.
+func serveDateParam(c *gin.Context) time.Time {
+	return c.MustGet(serveDateKey).(time.Time)
+}
+
+// queryDate parses an optional date parameter, answering 400 if it is
+// malformed. Absent parameters give the zero time.
+func (s *Server) queryDate(c *gin.Context, param string) (time.Time, bool) {
+	value := c.Query(param)
+	if value == "" {
+		return time.Time{}, true
+	}
+	date, err := s.parseDate(value)
+	if err != nil {
+		respondErrorDetails(c, http.StatusBadRequest, CodeDateInvalid, param+" must be MM/DD/YYYY, YYYY-MM-DD, today or tomorrow", gin.H{param: value})
+		return time.Time{}, false
+	}
+	return date, true
+}
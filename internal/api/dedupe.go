@@ -0,0 +1,94 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const dedupeKey = "dedupe"
+
+// bindDedupe parses ?dedupe= for withDedupe. Malformed values are answered
+// with 400.
+func bindDedupe(c *gin.Context) {
+	value := c.Query("dedupe")
+	if value == "" {
+		return
+	}
+	dedupe, err := strconv.ParseBool(value)
+	if err != nil {
+		respondErrorDetails(c, http.StatusBadRequest, CodeInvalidRequest, "dedupe must be true or false", gin.H{"dedupe": value})
+		return
+	}
+	c.Set(dedupeKey, dedupe)
+}
+
+// dedupeKeyOf identifies an item across meals and categories: its recipe
+// number, or its name for items HUDS gives none.
+func dedupeKeyOf(item huds.CondensedMenuItem) string {
+	if item.RecipeNumber != "" {
+		return "recipe:" + item.RecipeNumber
+	}
+	return "name:" + strings.ToLower(strings.TrimSpace(item.FoodName))
+}
+
+// withDedupe keeps only the first appearance of each recipe on a menu when
+// ?dedupe=true, e.g. a dish on both the lunch and dinner menus, annotating
+// every item with each meal and category it appears under.
+func withDedupe(c *gin.Context, menu huds.CondensedMenu) huds.CondensedMenu {
+	if dedupe, _ := c.Value(dedupeKey).(bool); !dedupe {
+		return menu
+	}
+	type appearances struct {
+		meals      []string
+		categories []string
+	}
+	seen := make(map[string]*appearances)
+	for _, meal := range menu.Meals() {
+		for _, item := range huds.MealItems(menu, meal) {
+			key := dedupeKeyOf(item)
+			found, ok := seen[key]
+			if !ok {
+				found = &appearances{meals: []string{}, categories: []string{}}
+				seen[key] = found
+			}
+			if !containsString(found.meals, meal) {
+				found.meals = append(found.meals, meal)
+			}
+			if category := strings.TrimSpace(item.MenuCategory); category != "" && !containsString(found.categories, category) {
+				found.categories = append(found.categories, category)
+			}
+		}
+	}
+
+	// MapMeals visits the meals in serving order, so the first appearance
+	// is the one kept
+	kept := make(map[string]bool)
+	return menu.MapMeals(func(items []huds.CondensedMenuItem) []huds.CondensedMenuItem {
+		if items == nil {
+			return nil
+		}
+		deduped := []huds.CondensedMenuItem{}
+		for _, item := range items {
+			key := dedupeKeyOf(item)
+			if kept[key] {
+				continue
+			}
+			kept[key] = true
+			item.Meals, item.Categories = seen[key].meals, seen[key].categories
+			deduped = append(deduped, item)
+		}
+		return deduped
+	})
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"net/http"
+	"strings"
+)
+
+type MealDiff struct {
+	Added   []huds.CondensedMenuItem `json:"added"`
+	Removed []huds.CondensedMenuItem `json:"removed"`
+}
+
+// handleMenuDiff compares the menus of ?from= and ?to= meal by meal, listing
+// the items served on "to" but not "from" and vice versa.
+func (s *Server) handleMenuDiff(c *gin.Context) {
+	if c.Query("from") == "" || c.Query("to") == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "from and to are required")
+		return
+	}
+
+	menus := make([]huds.CondensedMenu, 2)
+	dates := make([]string, 2)
+	for i, param := range []string{"from", "to"} {
+		t, ok := s.queryDate(c, param)
+		if !ok {
+			return
+		}
+		date := t.Format(huds.ServeDateLayout)
+		dates[i] = date
+		ctx, cancel := s.dbContext(c.Request.Context())
+		menu, err := s.menuByDate(ctx, date)
+		cancel()
+		if err == store.ErrMenuNotFound {
+			respondError(c, http.StatusNotFound, CodeNotFound, "no menu for "+date)
+			return
+		}
+		if err != nil {
+			respondStoreError(c, err)
+			return
+		}
+		menus[i] = menu
+	}
+
+	diff := gin.H{"from": dates[0], "to": dates[1]}
+	meals := menus[0].Meals()
+	for _, meal := range menus[1].Meals() {
+		if len(huds.MealItems(menus[0], meal)) == 0 {
+			meals = append(meals, meal)
+		}
+	}
+	for _, meal := range meals {
+		before, after := huds.MealItems(menus[0], meal), huds.MealItems(menus[1], meal)
+		diff[meal] = MealDiff{Added: missingItems(after, before), Removed: missingItems(before, after)}
+	}
+	respond(c, http.StatusOK, diff, ResponseMeta{Source: SourceDB})
+}
+
+// missingItems returns the items in a whose names don't appear in b.
+func missingItems(a []huds.CondensedMenuItem, b []huds.CondensedMenuItem) []huds.CondensedMenuItem {
+	names := make(map[string]bool, len(b))
+	for _, item := range b {
+		names[strings.ToLower(item.FoodName)] = true
+	}
+	missing := []huds.CondensedMenuItem{}
+	for _, item := range a {
+		if !names[strings.ToLower(item.FoodName)] {
+			missing = append(missing, item)
+		}
+	}
+	return missing
+}
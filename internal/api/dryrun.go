@@ -0,0 +1,77 @@
+package api
+
+import (
+	"fmt"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/provider"
+	"hudsgry-api/internal/store"
+	"sort"
+	"time"
+)
+
+const (
+	DryRunNew       = "new"
+	DryRunChanged   = "changed"
+	DryRunUnchanged = "unchanged"
+)
+
+type MealCount struct {
+	Meal  string `json:"meal"`
+	Items int    `json:"items"`
+}
+
+// DryRunDay is what a fetch would do to one serve date.
+type DryRunDay struct {
+	ServeDate string       `json:"Serve_Date"`
+	Status    string       `json:"status"`
+	Meals     []MealCount  `json:"meals"`
+	Changes   []MealChange `json:"changes"`
+}
+
+type DryRunReport struct {
+	// Fetched is how many upstream items came back
+	Fetched int         `json:"fetched"`
+	Days    []DryRunDay `json:"days"`
+}
+
+// DryRun fetches and converts the dates in range like a refresh would, and
+// reports each serve date it would write and how it differs from what is
+// stored, without writing anything.
+func (s *Server) DryRun(dates provider.DateRange) (DryRunReport, error) {
+	data, err := s.fetchWithRetry(dates)
+	if err != nil {
+		return DryRunReport{}, err
+	}
+	ctx, cancel := s.jobContext()
+	defer cancel()
+	condensed, _, err := s.condense(ctx, data)
+	if err != nil {
+		return DryRunReport{}, fmt.Errorf("failed to load overrides: %v", err)
+	}
+	report := DryRunReport{Fetched: len(data), Days: []DryRunDay{}}
+	for date, meals := range condensed {
+		menu := huds.MenuFromMeals(date, meals)
+		stored, err := s.store.GetByDate(ctx, date)
+		if err != nil && err != store.ErrMenuNotFound {
+			return DryRunReport{}, fmt.Errorf("failed to load %s: %v", date, err)
+		}
+
+		day := DryRunDay{ServeDate: date, Status: DryRunUnchanged, Changes: mealChanges(stored, menu)}
+		if err == store.ErrMenuNotFound {
+			day.Status = DryRunNew
+		} else if len(day.Changes) > 0 {
+			day.Status = DryRunChanged
+		}
+		for _, meal := range menu.Meals() {
+			day.Meals = append(day.Meals, MealCount{Meal: meal, Items: len(huds.MealItems(menu, meal))})
+		}
+		report.Days = append(report.Days, day)
+	}
+
+	sort.Slice(report.Days, func(i, j int) bool {
+		a, _ := time.Parse(huds.ServeDateLayout, report.Days[i].ServeDate)
+		b, _ := time.Parse(huds.ServeDateLayout, report.Days[j].ServeDate)
+		return a.Before(b)
+	})
+	return report, nil
+}
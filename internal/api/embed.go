@@ -0,0 +1,88 @@
+package api
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"hudsgry-api/internal/alerting"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/provider"
+	"hudsgry-api/internal/reporting"
+	"hudsgry-api/internal/scheduler"
+	"hudsgry-api/internal/store"
+	"net/http"
+	"time"
+)
+
+// Option configures a server built by NewServer.
+type Option func(*Options)
+
+// WithClock makes the server tell time, and schedule, by clock, e.g. a
+// simulated one in tests.
+func WithClock(clock scheduler.Clock) Option {
+	return func(o *Options) { o.Clock = clock }
+}
+
+// WithRefresh schedules refreshes; without it nothing is fetched unless
+// asked for.
+func WithRefresh(refresh scheduler.RefreshConfig) Option {
+	return func(o *Options) { o.Refresh = refresh }
+}
+
+// WithMongo enables the features that need MongoDB, such as accounts and
+// webhooks.
+func WithMongo(db *mongo.Database) Option {
+	return func(o *Options) { o.Mongo = db }
+}
+
+// WithProvider fetches menus from p instead of the HUDS client.
+func WithProvider(p provider.Provider) Option {
+	return func(o *Options) { o.Provider = p }
+}
+
+func WithRetry(retry huds.RetryPolicy) Option {
+	return func(o *Options) { o.Retry = retry }
+}
+
+func WithFetchTimeout(timeout time.Duration) Option {
+	return func(o *Options) { o.FetchTimeout = timeout }
+}
+
+func WithDateFormat(format string) Option {
+	return func(o *Options) { o.DateFormat = format }
+}
+
+func WithTimeouts(timeouts Timeouts) Option {
+	return func(o *Options) { o.Timeouts = timeouts }
+}
+
+func WithReporter(reporter reporting.Reporter) Option {
+	return func(o *Options) { o.Reporter = reporter }
+}
+
+func WithAlerter(alerter alerting.Alerter) Option {
+	return func(o *Options) { o.Alerter = alerter }
+}
+
+// WithRetention deletes history the policy no longer keeps, on its schedule.
+func WithRetention(policy store.RetentionPolicy) Option {
+	return func(o *Options) { o.Retention = policy }
+}
+
+// NewServer builds the whole API over menus, fetching from client, for
+// mounting in httptest or inside another program. Nothing is fetched until a
+// refresh is scheduled with WithRefresh, so tests can fill menus themselves.
+// Features configured through the environment, such as ADMIN_TOKEN, are
+// read as they are by the service.
+func NewServer(menus store.MenuStore, client huds.Client, opts ...Option) (http.Handler, error) {
+	options := Options{Store: menus}
+	if client != nil {
+		options.Provider = provider.NewHUDS(client)
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if menus == nil || options.Provider == nil {
+		return nil, errors.New("NewServer needs a menu store and a HUDS client or provider")
+	}
+	return New(options).Handler()
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/url"
+	"time"
+)
+
+// Where a response's data came from.
+const (
+	SourceCache    = "cache"
+	SourceDB       = "db"
+	SourceUpstream = "upstream"
+	// SourcePrediction is a menu guessed from past cycles, not published
+	SourcePrediction = "prediction"
+)
+
+// ResponseMeta is what a handler knows about the data it is returning. Zero
+// fields are left out of the envelope.
+type ResponseMeta struct {
+	// ServeDate is the serve date the request resolved to, in the requested
+	// date format
+	ServeDate   string
+	Source      string
+	LastUpdated time.Time
+	// Stale is set when the data is served while a refresh that should have
+	// replaced it is still outstanding
+	Stale      bool
+	Pagination *Pagination
+	// Service is when each meal of a menu's day is served
+	Service []MealService
+}
+
+// Pagination describes a list that was cut off at a limit.
+type Pagination struct {
+	Limit   int  `json:"limit"`
+	Count   int  `json:"count"`
+	HasMore bool `json:"has_more"`
+	// Next are the query parameters that fetch the next page, for lists
+	// that can be paged through; JSON:API responses link to it
+	Next url.Values `json:"-"`
+}
+
+// Envelope is how v2 and later wrap every successful response.
+type Envelope struct {
+	Data        interface{} `json:"data"`
+	ServeDate   string      `json:"serve_date,omitempty"`
+	Source      string      `json:"source,omitempty"`
+	LastUpdated *time.Time  `json:"last_updated,omitempty"`
+	Stale       bool        `json:"stale,omitempty"`
+	Pagination  *Pagination `json:"pagination,omitempty"`
+	// Service is when each meal of the day is served, and whether it is
+	// being served now, on menus
+	Service []MealService `json:"service,omitempty"`
+}
+
+// respond writes data as JSON, or the format negotiated. v1 responses are the bare data, as they have
+// always been; later versions wrap it in an Envelope with meta. JSON:API
+// responses are a JSONAPIDocument in every version.
+func respond(c *gin.Context, status int, data interface{}, meta ResponseMeta) {
+	// v1 has no envelope, so staleness is also told in a header
+	if meta.Stale {
+		c.Header("Warning", `110 - "Response is Stale"`)
+	}
+	for _, meal := range meta.Service {
+		if meal.Serving {
+			c.Header(nowServingHeader, meal.Meal)
+		}
+	}
+	if c.GetString(responseFormatKey) == FormatJSONAPI {
+		writeJSONAPI(c, status, data, meta)
+		return
+	}
+	if apiVersion(c) < 2 {
+		writeData(c, status, data)
+		return
+	}
+	envelope := Envelope{Data: data, ServeDate: meta.ServeDate, Source: meta.Source, Stale: meta.Stale, Pagination: meta.Pagination, Service: meta.Service}
+	if !meta.LastUpdated.IsZero() {
+		envelope.LastUpdated = &meta.LastUpdated
+	}
+	writeData(c, status, envelope)
+}
+
+// paginate cuts items, which were fetched with one more than limit to find
+// out whether there are more, down to limit.
+func paginate[T any](items []T, limit int) ([]T, *Pagination) {
+	page := &Pagination{Limit: limit, HasMore: len(items) > limit}
+	if page.HasMore {
+		items = items[:limit]
+	}
+	page.Count = len(items)
+	return items, page
+}
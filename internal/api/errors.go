@@ -0,0 +1,115 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"regexp"
+)
+
+// Error codes. Clients should branch on these rather than on messages, which
+// are for people and may change. The catalog is documented with the Error
+// schema in openapi.json.
+const (
+	// CodeInvalidRequest is a missing or malformed parameter or body
+	CodeInvalidRequest = "INVALID_REQUEST"
+	// CodeDateInvalid is a date that isn't in the expected format
+	CodeDateInvalid = "DATE_INVALID"
+	// CodeDateOutOfRange is a well-formed date there are no menus for
+	CodeDateOutOfRange = "DATE_OUT_OF_RANGE"
+	CodeNotFound       = "NOT_FOUND"
+	CodeUnauthorized   = "UNAUTHORIZED"
+	CodeForbidden      = "FORBIDDEN"
+	// CodeConflict is a request that clashes with existing state, e.g. an
+	// email that already has an account
+	CodeConflict = "CONFLICT"
+	// CodeUnsupportedVersion is an API version outside 1..LatestAPIVersion
+	CodeUnsupportedVersion = "UNSUPPORTED_API_VERSION"
+	// CodeNotImplemented is a feature the configured backend doesn't have
+	CodeNotImplemented = "NOT_IMPLEMENTED"
+	// CodeUpstreamUnavailable is HUDS, or another service we depend on,
+	// failing or being cut off by the circuit breaker
+	CodeUpstreamUnavailable = "UPSTREAM_UNAVAILABLE"
+	// CodeMaintenance is a write turned away while the service is read-only
+	// for maintenance
+	CodeMaintenance = "MAINTENANCE"
+	// CodeQuotaExceeded is an API key that has used its daily quota
+	CodeQuotaExceeded = "QUOTA_EXCEEDED"
+	// CodeRateLimited is an action repeated too often in a short time, e.g.
+	// asking for confirmation texts
+	CodeRateLimited = "RATE_LIMITED"
+	CodeInternal    = "INTERNAL_ERROR"
+)
+
+// APIError is the body of every error response from v2 on, under "error".
+type APIError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Details   gin.H  `json:"details,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+const requestIDHeader = "X-Request-ID"
+
+const requestIDKey = "request_id"
+
+// Request IDs from clients and proxies are kept if they look like IDs
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestID tags every request with an ID, echoed in X-Request-ID and in
+// error bodies, so a report from a client can be matched to the logs.
+func requestID(c *gin.Context) {
+	id := c.GetHeader(requestIDHeader)
+	if !requestIDPattern.MatchString(id) {
+		b := make([]byte, 8)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+	c.Set(requestIDKey, id)
+	c.Header(requestIDHeader, id)
+}
+
+// respondError ends the request with an error. v1 keeps its flat shape, where
+// "error" is the message, with the code, request ID and any details alongside
+// it; later versions nest an APIError under "error".
+// respondStoreError answers a request whose stored data couldn't be read or
+// written, logging err with the route. The message doesn't name the backend,
+// which may be any of the stores.
+func respondStoreError(c *gin.Context, err error) {
+	log.Printf("Store error on %s %s: %v\n", c.Request.Method, c.FullPath(), err)
+	respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load stored data")
+}
+
+func respondError(c *gin.Context, status int, code string, message string) {
+	respondErrorDetails(c, status, code, message, nil)
+}
+
+func respondErrorDetails(c *gin.Context, status int, code string, message string, details gin.H) {
+	// Picked up by the recovery middleware and reported
+	if status >= http.StatusInternalServerError {
+		c.Error(errors.New(code + ": " + message))
+	}
+	apiErr := APIError{Code: code, Message: message, Details: details, RequestID: c.GetString(requestIDKey)}
+	c.Abort()
+	if c.GetString(responseFormatKey) == FormatJSONAPI {
+		writeJSONAPIErrors(c, status, apiErr)
+		return
+	}
+	if apiVersion(c) >= 2 {
+		writeData(c, status, gin.H{"error": apiErr})
+		return
+	}
+	body := gin.H{}
+	for key, value := range details {
+		body[key] = value
+	}
+	body["error"] = apiErr.Message
+	body["code"] = apiErr.Code
+	if apiErr.RequestID != "" {
+		body["request_id"] = apiErr.RequestID
+	}
+	writeData(c, status, body)
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/store"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	defaultEventLimit = 100
+	maxEventLimit     = 1000
+)
+
+// handleEvents lists menu events after the ?after= cursor, oldest first.
+// Pollers pass the cursor from the last page to get only what changed since.
+func (s *Server) handleEvents(c *gin.Context) {
+	after, err := strconv.ParseInt(c.DefaultQuery("after", "0"), 10, 64)
+	if err != nil || after < 0 {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "after must be an event ID")
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultEventLimit)))
+	if err != nil || limit < 1 || limit > maxEventLimit {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "limit must be between 1 and 1000")
+		return
+	}
+	dateFormat := c.DefaultQuery("date_format", s.dateFormat)
+	if !validDateFormat(dateFormat) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "date_format must be 'us' or 'iso'")
+		return
+	}
+	eventLog, ok := s.store.(store.EventLog)
+	if !ok {
+		respondError(c, http.StatusNotImplemented, CodeNotImplemented, "the event log isn't kept by this storage backend")
+		return
+	}
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	events, err := eventLog.EventsAfter(ctx, after, limit+1)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	events, page := paginate(events, limit)
+	cursor := after
+	for i := range events {
+		events[i].ServeDate = formatServeDate(events[i].ServeDate, dateFormat)
+		cursor = events[i].ID
+	}
+	page.Next = url.Values{"after": {strconv.FormatInt(cursor, 10)}}
+	respond(c, http.StatusOK, gin.H{"events": events, "cursor": cursor, "has_more": page.HasMore}, ResponseMeta{Source: SourceDB, Pagination: page})
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nutritionCSVHeader are the columns of a nutrition export, named as
+// MyFitnessPal and Cronometer's importers expect. Nutrients are per serving.
+var nutritionCSVHeader = []string{
+	"Date", "Meal", "Food Name", "Servings", "Serving Size",
+	"Calories", "Fat (g)", "Saturated Fat (g)", "Trans Fat (g)", "Cholesterol (mg)", "Sodium (mg)",
+	"Carbohydrates (g)", "Fiber (g)", "Sugars (g)", "Protein (g)",
+}
+
+// NutritionExportRow is one eaten item in a nutrition export. Dates are
+// always YYYY-MM-DD, which is what nutrition trackers import.
+type NutritionExportRow struct {
+	Date        string    `json:"date"`
+	Meal        string    `json:"meal"`
+	FoodName    string    `json:"food_name"`
+	Servings    float64   `json:"servings"`
+	ServingSize string    `json:"serving_size"`
+	PerServing  Nutrients `json:"per_serving"`
+	Totals      Nutrients `json:"totals"`
+}
+
+func exportRow(serveDate string, meal string, foodName string, servings float64, servingSize string, perServing Nutrients) NutritionExportRow {
+	return NutritionExportRow{
+		Date:        formatServeDate(serveDate, DateFormatISO),
+		Meal:        mealTitle(meal),
+		FoodName:    foodName,
+		Servings:    servings,
+		ServingSize: servingSize,
+		PerServing:  perServing,
+		Totals:      perServing.Scale(servings),
+	}
+}
+
+// handleExportItems serves POST /nutrition/export and /nutrition/export.csv,
+// exporting a plate given as for /calculate.
+func (s *Server) handleExportItems(c *gin.Context) {
+	var req struct {
+		Items []CalculateItem `json:"items" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Items) == 0 {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "items is required")
+		return
+	}
+	if len(req.Items) > maxCalculateItems {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("at most %d items can be exported at once", maxCalculateItems))
+		return
+	}
+
+	rows := make([]NutritionExportRow, 0, len(req.Items))
+	for i, requested := range req.Items {
+		if requested.Servings == 0 {
+			requested.Servings = 1
+		}
+		if requested.Servings < 0 || requested.Servings > maxServings {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("items[%d]: servings must be between 0 and 20", i))
+			return
+		}
+		item, date, meal, err := s.resolveCalculateItem(c.Request.Context(), requested)
+		if err == store.ErrMenuNotFound {
+			respondError(c, http.StatusNotFound, CodeNotFound, fmt.Sprintf("items[%d]: item not found", i))
+			return
+		}
+		if err != nil {
+			if _, invalid := err.(invalidItemError); invalid {
+				respondError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("items[%d]: %v", i, err))
+				return
+			}
+			respondStoreError(c, err)
+			return
+		}
+		rows = append(rows, exportRow(date, meal, item.FoodName, requested.Servings, item.ServingSize, itemNutrients(item)))
+	}
+	writeNutritionExport(c, rows)
+}
+
+// handleExportMealLog serves /me/log/export and /me/log/export.csv, exporting
+// the meal log between ?start= and ?end= (default the last week). Entries
+// are exported with the nutrition their item is served with now, or the
+// macros logged with them if it is no longer on the menu.
+func (s *Server) handleExportMealLog(c *gin.Context) {
+	start, ok := s.queryDate(c, "start")
+	if !ok {
+		return
+	}
+	end, ok := s.queryDate(c, "end")
+	if !ok {
+		return
+	}
+	today, _ := time.Parse(huds.ServeDateLayout, s.today())
+	if end.IsZero() {
+		end = today
+	}
+	if start.IsZero() {
+		start = today.AddDate(0, 0, -defaultHistoryDays+1)
+	}
+	if end.Before(start) || end.Sub(start) > maxHistoryDays*24*time.Hour {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "end must be after start and within a year of it")
+		return
+	}
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	match := bson.M{"user_id": currentUser(c).ID, "date": bson.M{"$gte": start, "$lte": end}}
+	cursor, err := s.mealLogs.Find(ctx, match, options.Find().SetSort(bson.D{{Key: "date", Value: 1}, {Key: "logged_at", Value: 1}}))
+	if err != nil {
+		log.Printf("Failed to load meal log: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load meal log")
+		return
+	}
+	var entries []MealLogEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		log.Printf("Failed to decode meal log: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load meal log")
+		return
+	}
+
+	rows := make([]NutritionExportRow, 0, len(entries))
+	for _, entry := range entries {
+		item, _, _, err := s.resolveCalculateItem(c.Request.Context(), CalculateItem{FoodName: entry.FoodName, ServeDate: entry.ServeDate, Meal: entry.Meal})
+		if err == store.ErrMenuNotFound {
+			logged := Nutrients{Calories: entry.PerServing.Calories, Protein: entry.PerServing.Protein, TotalCarb: entry.PerServing.Carbs, TotalFat: entry.PerServing.Fat}
+			rows = append(rows, exportRow(entry.ServeDate, entry.Meal, entry.FoodName, entry.Servings, "", logged))
+			continue
+		}
+		if err != nil {
+			respondStoreError(c, err)
+			return
+		}
+		rows = append(rows, exportRow(entry.ServeDate, entry.Meal, item.FoodName, entry.Servings, item.ServingSize, itemNutrients(item)))
+	}
+	writeNutritionExport(c, rows)
+}
+
+// writeNutritionExport writes rows as CSV for the .csv routes, and otherwise
+// as a response like any other.
+func writeNutritionExport(c *gin.Context, rows []NutritionExportRow) {
+	if !strings.HasSuffix(c.FullPath(), ".csv") {
+		respond(c, http.StatusOK, gin.H{"items": rows}, ResponseMeta{})
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="nutrition.csv"`)
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Status(http.StatusOK)
+	w := csv.NewWriter(c.Writer)
+	w.Write(nutritionCSVHeader)
+	for _, row := range rows {
+		n := row.PerServing
+		record := []string{row.Date, row.Meal, row.FoodName, csvNumber(row.Servings), row.ServingSize}
+		for _, value := range []float64{n.Calories, n.TotalFat, n.SatFat, n.TransFat, n.Cholesterol, n.Sodium, n.TotalCarb, n.DietaryFiber, n.Sugars, n.Protein} {
+			record = append(record, csvNumber(value))
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Printf("Failed to write nutrition export: %v\n", err)
+	}
+}
+
+// csvNumber writes a number to two decimal places at most.
+func csvNumber(value float64) string {
+	return strconv.FormatFloat(math.Round(value*100)/100, 'f', -1, 64)
+}
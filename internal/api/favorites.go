@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"hudsgry-api/internal/huds"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// How far ahead /me/favorites/upcoming looks when no end date is given.
+const upcomingWindowDays = 30
+
+type FavoriteMatch struct {
+	ServeDate string `json:"Serve_Date,omitempty"`
+	Meal      string `json:"meal"`
+	FoodName  string `json:"Food_Name"`
+	Favorite  string `json:"favorite"`
+}
+
+func (m FavoriteMatch) String() string {
+	return fmt.Sprintf("%s (%s)", m.FoodName, m.Meal)
+}
+
+// NotificationChannel is one place a user wants alerts delivered: a phone
+// number for "sms", a device token for "fcm"/"apns", a chat ID for "telegram".
+type NotificationChannel struct {
+	Channel string `json:"channel" bson:"channel" binding:"required"`
+	Address string `json:"address" bson:"address" binding:"required"`
+}
+
+// favoriteMatches returns every menu item whose name contains one of the
+// favorites, case-insensitively.
+func favoriteMatches(menu huds.CondensedMenu, favorites []string) []FavoriteMatch {
+	var matches []FavoriteMatch
+	for _, meal := range menu.Meals() {
+		for _, item := range huds.MealItems(menu, meal) {
+			name := strings.ToLower(item.FoodName)
+			for _, favorite := range favorites {
+				if favorite != "" && strings.Contains(name, strings.ToLower(favorite)) {
+					matches = append(matches, FavoriteMatch{ServeDate: menu.ServeDate, Meal: meal, FoodName: item.FoodName, Favorite: favorite})
+					break
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// setupFavoriteAlerts registers the alert preferences and upcoming-matches
+// endpoints and runs the matcher after every data refresh.
+func (s *Server) setupFavoriteAlerts() {
+	s.favoriteAlerts = s.db.Collection("favorite_alerts")
+	s.afterRefreshHooks = append(s.afterRefreshHooks, s.alertFavoriteMatches)
+}
+
+func (s *Server) favoriteAlertRoutes(r gin.IRouter) {
+	me := r.Group("/me", s.requireUser)
+	me.GET("/favorites/upcoming", s.handleUpcomingFavorites)
+	me.GET("/notifications", handleGetNotifications)
+	me.PUT("/notifications", s.handleSetNotifications)
+}
+
+func (s *Server) handleUpcomingFavorites(c *gin.Context) {
+	start := s.localNow()
+	end := start.AddDate(0, 0, upcomingWindowDays)
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	menus, err := s.store.GetRange(ctx, start.Format(huds.ServeDateLayout), end.Format(huds.ServeDateLayout))
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	favorites := currentUser(c).Favorites
+	matches := []FavoriteMatch{}
+	for _, menu := range menus {
+		matches = append(matches, favoriteMatches(menu, favorites)...)
+	}
+	respond(c, http.StatusOK, gin.H{"matches": matches}, ResponseMeta{})
+}
+
+func handleGetNotifications(c *gin.Context) {
+	channels := currentUser(c).Notifications
+	if channels == nil {
+		channels = []NotificationChannel{}
+	}
+	respond(c, http.StatusOK, gin.H{"notifications": channels}, ResponseMeta{})
+}
+
+// handleSetNotifications replaces the user's alert channels. Only channels
+// configured on this server are accepted.
+func (s *Server) handleSetNotifications(c *gin.Context) {
+	var req struct {
+		Notifications []NotificationChannel `json:"notifications" binding:"dive"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "each notification needs a channel and an address")
+		return
+	}
+	for _, channel := range req.Notifications {
+		if _, ok := s.notifiers[channel.Channel]; !ok {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("notification channel %q is not available", channel.Channel))
+			return
+		}
+	}
+	if req.Notifications == nil {
+		req.Notifications = []NotificationChannel{}
+	}
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	_, err := s.users.UpdateOne(ctx, bson.M{"_id": currentUser(c).ID}, bson.M{"$set": bson.M{"notifications": req.Notifications}})
+	if err != nil {
+		log.Printf("Failed to save notification channels: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to save notification channels")
+		return
+	}
+	respond(c, http.StatusOK, gin.H{"notifications": req.Notifications}, ResponseMeta{})
+}
+
+// alertFavoriteMatches notifies every user with alert channels about favorites
+// on today's or later menus in the refreshed data. Each user hears about a
+// given food on a given day only once, however many refreshes include it.
+func (s *Server) alertFavoriteMatches(ctx context.Context, data map[string]map[int][]huds.CondensedMenuItem) {
+	filter := bson.M{"favorites.0": bson.M{"$exists": true}, "notifications.0": bson.M{"$exists": true}}
+	cursor, err := s.users.Find(ctx, filter)
+	if err != nil {
+		log.Printf("Failed to load users for favorite alerts: %v\n", err)
+		return
+	}
+	var alertUsers []User
+	if err := cursor.All(ctx, &alertUsers); err != nil {
+		log.Printf("Failed to decode users for favorite alerts: %v\n", err)
+		return
+	}
+
+	startOfToday, _ := time.Parse(huds.ServeDateLayout, s.today())
+	for date, meals := range data {
+		if served, err := time.Parse(huds.ServeDateLayout, date); err != nil || served.Before(startOfToday) {
+			continue
+		}
+		menu := huds.MenuFromMeals(date, meals)
+		for _, user := range alertUsers {
+			for _, match := range favoriteMatches(menu, user.Favorites) {
+				alertID := user.ID.Hex() + "|" + date + "|" + match.String()
+				_, err := s.favoriteAlerts.InsertOne(ctx, bson.M{"_id": alertID, "sent_at": s.clock.Now()})
+				if err != nil {
+					// Already alerted (duplicate key) or the write failed; skip either way
+					continue
+				}
+				s.sendUserAlert(user, fmt.Sprintf("%s is on the HUDS menu for %s on %s.", match.FoodName, match.Meal, date))
+			}
+		}
+	}
+}
+
+func (s *Server) sendUserAlert(user User, message string) {
+	for _, channel := range user.Notifications {
+		notifier, ok := s.notifiers[channel.Channel]
+		if !ok {
+			continue
+		}
+		if err := notifier.Notify(channel.Address, message); err != nil {
+			log.Printf("Failed to alert user %s via %s: %v\n", user.ID.Hex(), channel.Channel, err)
+		}
+	}
+}
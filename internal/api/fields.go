@@ -0,0 +1,91 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const itemFieldsKey = "item_fields"
+
+// itemFieldNames maps each lowercased item JSON key to its canonical
+// spelling, for matching ?fields= case-insensitively.
+var itemFieldNames = func() map[string]string {
+	names := make(map[string]string)
+	t := reflect.TypeOf(huds.CondensedMenuItem{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names[strings.ToLower(name)] = name
+		}
+	}
+	return names
+}()
+
+// bindItemFields parses ?fields=Food_Name,Calories,Vegan for selectFields.
+// Unknown fields are answered with 400 listing the valid ones.
+func bindItemFields(c *gin.Context) {
+	var fields []string
+	for _, field := range strings.Split(c.Query("fields"), ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		name, ok := itemFieldNames[strings.ToLower(field)]
+		if !ok {
+			valid := make([]string, 0, len(itemFieldNames))
+			for _, name := range itemFieldNames {
+				valid = append(valid, name)
+			}
+			sort.Strings(valid)
+			respondErrorDetails(c, http.StatusBadRequest, CodeInvalidRequest, "unknown field "+field, gin.H{"fields": valid})
+			return
+		}
+		fields = append(fields, name)
+	}
+	c.Set(itemFieldsKey, fields)
+}
+
+// itemFields returns the fields bound by bindItemFields, nil for all of them.
+func itemFields(c *gin.Context) []string {
+	fields, _ := c.Value(itemFieldsKey).([]string)
+	return fields
+}
+
+// fieldSelected reports whether ?fields= names one of names.
+func fieldSelected(c *gin.Context, names ...string) bool {
+	for _, field := range itemFields(c) {
+		for _, name := range names {
+			if field == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// selectFields encodes items with only the given keys, or whole if fields is
+// empty. Keys an item leaves out, such as empty optional values, stay out.
+func selectFields(items []huds.CondensedMenuItem, fields []string) (json.RawMessage, error) {
+	data, err := json.Marshal(items)
+	if err != nil || len(fields) == 0 || items == nil {
+		return data, err
+	}
+	var decoded []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+	selected := make([]map[string]json.RawMessage, len(decoded))
+	for i, item := range decoded {
+		selected[i] = make(map[string]json.RawMessage, len(fields))
+		for _, field := range fields {
+			if value, ok := item[field]; ok {
+				selected[i][field] = value
+			}
+		}
+	}
+	return json.Marshal(selected)
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"net/http"
+	"strconv"
+)
+
+const dietaryFlagsKey = "dietary_flags"
+
+type flagFilter struct {
+	flag huds.DietaryFlag
+	want bool
+}
+
+// bindDietaryFlags parses the dietary flag filters, e.g. ?halal=true or
+// ?vegan=false, for withDietaryFlags. Malformed values are answered with 400.
+func bindDietaryFlags(c *gin.Context) {
+	var filters []flagFilter
+	for _, flag := range huds.DietaryFlags {
+		value := c.Query(flag.Name)
+		if value == "" {
+			continue
+		}
+		want, err := strconv.ParseBool(value)
+		if err != nil {
+			respondErrorDetails(c, http.StatusBadRequest, CodeInvalidRequest, flag.Name+" must be true or false", gin.H{flag.Name: value})
+			return
+		}
+		filters = append(filters, flagFilter{flag, want})
+	}
+	c.Set(dietaryFlagsKey, filters)
+}
+
+// withDietaryFlags narrows a menu to the items matching every flag filter
+// bound by bindDietaryFlags.
+func withDietaryFlags(c *gin.Context, menu huds.CondensedMenu) huds.CondensedMenu {
+	filters, _ := c.Value(dietaryFlagsKey).([]flagFilter)
+	if len(filters) == 0 {
+		return menu
+	}
+	return menu.MapMeals(func(items []huds.CondensedMenuItem) []huds.CondensedMenuItem {
+		filtered := []huds.CondensedMenuItem{}
+		for _, item := range items {
+			matches := true
+			for _, filter := range filters {
+				if filter.flag.Has(item) != filter.want {
+					matches = false
+					break
+				}
+			}
+			if matches {
+				filtered = append(filtered, item)
+			}
+		}
+		return filtered
+	})
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxLastServed = 10
+
+// handleLastServed answers "when was this last served?" for an exact food
+// name (case-insensitive): the most recent ?count= days it was on the menu up
+// to today, and the next published day it appears, if any.
+func (s *Server) handleLastServed(c *gin.Context) {
+	name := strings.TrimSpace(c.Param("name"))
+	count, err := strconv.Atoi(c.DefaultQuery("count", "1"))
+	if err != nil || count < 1 || count > maxLastServed {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "count must be between 1 and 10")
+		return
+	}
+	todayStart, _ := time.Parse(huds.ServeDateLayout, s.today())
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	last, err := s.store.FoodOccurrences(ctx, name, todayStart, false, count)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	next, err := s.store.FoodOccurrences(ctx, name, todayStart, true, 1)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	if len(last) == 0 && len(next) == 0 {
+		respondError(c, http.StatusNotFound, CodeNotFound, "this food has never been served")
+		return
+	}
+
+	response := gin.H{"Food_Name": name, "last_served": last, "next_served": nil}
+	if len(next) > 0 {
+		response["next_served"] = next[0]
+	}
+	respond(c, http.StatusOK, response, ResponseMeta{})
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"strings"
+	"unicode"
+)
+
+// searchWords splits text into the lowercase words search terms are matched
+// against.
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// maxTypos is how many edits a search term may be from a word it is corrected
+// to. Short terms are left alone, as too many words are one edit away.
+func maxTypos(term string) int {
+	switch n := len([]rune(term)); {
+	case n <= 3:
+		return 0
+	case n <= 6:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// editDistance counts the insertions, deletions, substitutions and swaps of
+// adjacent letters that turn a into b, giving up once it exceeds limit.
+func editDistance(a string, b string, limit int) int {
+	s, t := []rune(a), []rune(b)
+	if d := len(s) - len(t); d > limit || -d > limit {
+		return limit + 1
+	}
+	// rows[i%3] is row i of the usual dynamic programming table
+	rows := [3][]int{make([]int, len(t)+1), make([]int, len(t)+1), make([]int, len(t)+1)}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		row, prev, prev2 := rows[i%3], rows[(i-1)%3], rows[(i+1)%3]
+		row[0] = i
+		best := row[0]
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			row[j] = minInt(prev[j]+1, row[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				row[j] = minInt(row[j], prev2[j-2]+1)
+			}
+			best = minInt(best, row[j])
+		}
+		if best > limit {
+			return limit + 1
+		}
+	}
+	return rows[len(s)%3][len(t)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+// correctQuery replaces each misspelt term of a search with the closest word
+// on any stored menu, so "chiken parmesean" searches for "chicken parmesan".
+// Terms that are, or are part of, a known word are kept. It returns the
+// corrected query and how similar it is to the original, from 0 to 1, which
+// scales the relevance of what the correction finds.
+func (catalog *menuCatalog) correctQuery(query string) (string, float64, bool) {
+	terms := searchWords(query)
+	corrected := make([]string, len(terms))
+	changed := false
+	similarity := 1.0
+	for i, term := range terms {
+		corrected[i] = term
+		if catalog.Words[term] > 0 || catalog.containsWord(term) {
+			continue
+		}
+		limit := maxTypos(term)
+		bestWord, bestDistance := "", limit+1
+		for word, count := range catalog.Words {
+			d := editDistance(term, word, limit)
+			if d < bestDistance || d == bestDistance && d <= limit && count > catalog.Words[bestWord] {
+				bestWord, bestDistance = word, d
+			}
+		}
+		if bestWord == "" || bestDistance > limit {
+			continue
+		}
+		corrected[i] = bestWord
+		changed = true
+		length := len([]rune(term))
+		if n := len([]rune(bestWord)); n > length {
+			length = n
+		}
+		similarity *= 1 - float64(bestDistance)/float64(length)
+	}
+	return strings.Join(corrected, " "), similarity, changed
+}
+
+// containsWord reports whether term is part of a known word, as searches
+// match substrings, e.g. "parm" in "parmesan".
+func (catalog *menuCatalog) containsWord(term string) bool {
+	for word := range catalog.Words {
+		if strings.Contains(word, term) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// How each goal is met: a calorie target within calorieTolerance either way,
+// a protein minimum, or a sodium ceiling.
+const (
+	GoalTarget  = "target"
+	GoalMinimum = "minimum"
+	GoalCeiling = "ceiling"
+
+	calorieTolerance = 0.1
+)
+
+// NutritionGoals are a user's daily targets. Unset goals aren't tracked.
+type NutritionGoals struct {
+	// Calories is a target, kcal
+	Calories *float64 `json:"calories,omitempty" bson:"calories,omitempty"`
+	// Protein is a minimum, grams
+	Protein *float64 `json:"protein,omitempty" bson:"protein,omitempty"`
+	// Sodium is a ceiling, mg
+	Sodium *float64 `json:"sodium,omitempty" bson:"sodium,omitempty"`
+}
+
+// GoalProgress compares what was eaten against one goal. Remaining is the
+// goal less what was eaten, so negative once it is exceeded.
+type GoalProgress struct {
+	Nutrient  string  `json:"nutrient"`
+	Kind      string  `json:"kind"`
+	Goal      float64 `json:"goal"`
+	Actual    float64 `json:"actual"`
+	Remaining float64 `json:"remaining"`
+	Percent   float64 `json:"percent"`
+	Met       bool    `json:"met"`
+}
+
+type DayProgress struct {
+	ServeDate string         `json:"Serve_Date"`
+	Entries   int            `json:"entries"`
+	Actual    Nutrients      `json:"actual"`
+	Goals     []GoalProgress `json:"goals"`
+}
+
+// WeekProgress rolls up the days of the week up to the requested date.
+// Average is over the days with anything logged, and DaysMet counts, for
+// each nutrient, the days its goal was met.
+type WeekProgress struct {
+	Start      string         `json:"start"`
+	End        string         `json:"end"`
+	Days       []DayProgress  `json:"days"`
+	LoggedDays int            `json:"logged_days"`
+	Totals     Nutrients      `json:"totals"`
+	Average    Nutrients      `json:"average"`
+	Goals      []GoalProgress `json:"goals"`
+	DaysMet    map[string]int `json:"days_met"`
+}
+
+type Progress struct {
+	Goals NutritionGoals `json:"targets"`
+	Day   DayProgress    `json:"day"`
+	Week  WeekProgress   `json:"week"`
+}
+
+// compare measures actual against each goal that is set.
+func (g NutritionGoals) compare(actual Nutrients) []GoalProgress {
+	progress := []GoalProgress{}
+	add := func(nutrient string, kind string, goal *float64, value float64) {
+		if goal == nil {
+			return
+		}
+		p := GoalProgress{Nutrient: nutrient, Kind: kind, Goal: *goal, Actual: round1(value), Remaining: round1(*goal - value)}
+		if *goal > 0 {
+			p.Percent = round1(value / *goal * 100)
+		}
+		switch kind {
+		case GoalTarget:
+			p.Met = math.Abs(value-*goal) <= *goal*calorieTolerance
+		case GoalMinimum:
+			p.Met = value >= *goal
+		case GoalCeiling:
+			p.Met = value <= *goal
+		}
+		progress = append(progress, p)
+	}
+	add("calories", GoalTarget, g.Calories, actual.Calories)
+	add("protein", GoalMinimum, g.Protein, actual.Protein)
+	add("sodium", GoalCeiling, g.Sodium, actual.Sodium)
+	return progress
+}
+
+func round1(value float64) float64 {
+	return math.Round(value*10) / 10
+}
+
+func (s *Server) goalRoutes(r gin.IRouter) {
+	me := r.Group("/me", s.requireUser)
+	me.GET("/goals", handleGetGoals)
+	me.PUT("/goals", s.handleSetGoals)
+	me.GET("/progress", s.handleProgress)
+}
+
+func handleGetGoals(c *gin.Context) {
+	respond(c, http.StatusOK, currentUser(c).Goals, ResponseMeta{})
+}
+
+func (s *Server) handleSetGoals(c *gin.Context) {
+	var goals NutritionGoals
+	if err := c.ShouldBindJSON(&goals); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid goals")
+		return
+	}
+	for _, goal := range []*float64{goals.Calories, goals.Protein, goals.Sodium} {
+		if goal != nil && (*goal <= 0 || *goal > 100000) {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "goals must be positive")
+			return
+		}
+	}
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	_, err := s.users.UpdateOne(ctx, bson.M{"_id": currentUser(c).ID}, bson.M{"$set": bson.M{"goals": goals}})
+	if err != nil {
+		log.Printf("Failed to save goals: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to save goals")
+		return
+	}
+	respond(c, http.StatusOK, goals, ResponseMeta{})
+}
+
+// handleProgress compares what the user logged on ?date= (default today), and
+// in its week up to then, against their goals.
+func (s *Server) handleProgress(c *gin.Context) {
+	date, ok := s.queryDate(c, "date")
+	if !ok {
+		return
+	}
+	if date.IsZero() {
+		date, _ = time.Parse(huds.ServeDateLayout, s.today())
+	}
+	dateFormat := c.DefaultQuery("date_format", s.dateFormat)
+	if !validDateFormat(dateFormat) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "date_format must be 'us' or 'iso'")
+		return
+	}
+	start := store.WeekStart(date)
+
+	user := currentUser(c)
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	match := bson.M{"user_id": user.ID, "date": bson.M{"$gte": start, "$lte": date}}
+	cursor, err := s.mealLogs.Find(ctx, match, options.Find().SetSort(bson.D{{Key: "date", Value: 1}}))
+	if err != nil {
+		log.Printf("Failed to load meal log: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load meal log")
+		return
+	}
+	var entries []MealLogEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		log.Printf("Failed to decode meal log: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load meal log")
+		return
+	}
+
+	days := make(map[string]*DayProgress)
+	menus := make(map[string]*huds.CondensedMenu)
+	for _, entry := range entries {
+		day, ok := days[entry.ServeDate]
+		if !ok {
+			day = &DayProgress{ServeDate: entry.ServeDate}
+			days[entry.ServeDate] = day
+		}
+		day.Entries++
+		day.Actual = day.Actual.Add(s.entryNutrients(ctx, entry, menus))
+	}
+
+	week := WeekProgress{
+		Start:   formatServeDate(start.Format(huds.ServeDateLayout), dateFormat),
+		End:     formatServeDate(date.Format(huds.ServeDateLayout), dateFormat),
+		Days:    []DayProgress{},
+		DaysMet: map[string]int{},
+	}
+	var today DayProgress
+	for d := start; !d.After(date); d = d.AddDate(0, 0, 1) {
+		serveDate := d.Format(huds.ServeDateLayout)
+		day := DayProgress{ServeDate: serveDate}
+		if logged, ok := days[serveDate]; ok {
+			day = *logged
+			week.LoggedDays++
+		}
+		day.Goals = user.Goals.compare(day.Actual)
+		if day.Entries > 0 {
+			for _, goal := range day.Goals {
+				if goal.Met {
+					week.DaysMet[goal.Nutrient]++
+				}
+			}
+		}
+		week.Totals = week.Totals.Add(day.Actual)
+		day.ServeDate = formatServeDate(serveDate, dateFormat)
+		week.Days = append(week.Days, day)
+		today = day
+	}
+	if week.LoggedDays > 0 {
+		week.Average = week.Totals.Scale(1 / float64(week.LoggedDays))
+	}
+	week.Goals = user.Goals.compare(week.Average)
+
+	respond(c, http.StatusOK, Progress{Goals: user.Goals, Day: today, Week: week}, ResponseMeta{})
+}
+
+// entryNutrients is what a meal log entry adds up to. Entries logged before
+// the full nutrition facts were copied in only have macros, so those are
+// looked up on the day's menu, falling back to the macros if the item is
+// gone. menus caches the menus read.
+func (s *Server) entryNutrients(ctx context.Context, entry MealLogEntry, menus map[string]*huds.CondensedMenu) Nutrients {
+	if entry.Nutrients != (Nutrients{}) {
+		return entry.Nutrients
+	}
+	fallback := Nutrients{
+		Calories:  entry.Totals.Calories,
+		Protein:   entry.Totals.Protein,
+		TotalCarb: entry.Totals.Carbs,
+		TotalFat:  entry.Totals.Fat,
+	}
+	menu, ok := menus[entry.ServeDate]
+	if !ok {
+		if stored, err := s.readMenu(ctx, entry.ServeDate); err == nil {
+			menu = &stored
+		} else if err != store.ErrMenuNotFound {
+			log.Printf("Failed to look up the menu for a meal log entry: %v\n", err)
+		}
+		menus[entry.ServeDate] = menu
+	}
+	if menu == nil {
+		return fallback
+	}
+	for _, item := range huds.MealItems(*menu, entry.Meal) {
+		if strings.EqualFold(item.FoodName, entry.FoodName) {
+			return itemNutrients(item).Scale(entry.Servings)
+		}
+	}
+	return fallback
+}
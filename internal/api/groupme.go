@@ -0,0 +1,270 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const groupMeApiUrl = "https://api.groupme.com/v3/bots/post"
+
+// GroupMe rejects bot posts longer than this, so menus go out in several.
+const groupMeMaxLength = 1000
+
+const groupMeHelp = `Commands:
+!menu - today's full menu
+!menu dinner - one meal from today's menu
+!menu tomorrow, !menu tomorrow lunch - the same for tomorrow`
+
+// GroupMeMessage is what GroupMe sends a bot's callback URL for every message
+// in its group, the bot's own posts included.
+type GroupMeMessage struct {
+	GroupID    string `json:"group_id"`
+	Name       string `json:"name"`
+	SenderType string `json:"sender_type"`
+	Text       string `json:"text"`
+}
+
+type GroupMeBot struct {
+	server *Server
+	// mu guards bots, which can change on a reload
+	mu sync.RWMutex
+	// bots maps each group ID to the bot that posts in it
+	bots       map[string]string
+	posts      *mongo.Collection
+	httpClient *http.Client
+}
+
+// loadGroupMeBots reads GROUPME_BOTS, a comma separated list of group
+// ID:bot ID pairs. A GroupMe bot belongs to a single group, so each group
+// the menu is posted to needs a bot of its own.
+func loadGroupMeBots() (map[string]string, error) {
+	bots := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv("GROUPME_BOTS"), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		group, bot, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || strings.TrimSpace(group) == "" || strings.TrimSpace(bot) == "" {
+			return nil, fmt.Errorf("GROUPME_BOTS must look like group_id:bot_id,group_id:bot_id, got %q", pair)
+		}
+		bots[strings.TrimSpace(group)] = strings.TrimSpace(bot)
+	}
+	return bots, nil
+}
+
+// startGroupMeBot answers "!menu" messages posted to the configured groups,
+// which reach the callback every bot's callback URL points at, and posts
+// today's menu to every group once a refresh has stored it.
+func (s *Server) startGroupMeBot() error {
+	bots, err := loadGroupMeBots()
+	if err != nil {
+		return err
+	}
+	s.groupMe = &GroupMeBot{
+		server:     s,
+		bots:       bots,
+		posts:      s.db.Collection("groupme_posts"),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+	s.afterRefreshHooks = append(s.afterRefreshHooks, s.groupMe.postAfterRefresh)
+	log.Printf("GroupMe bot posting to %d groups\n", len(bots))
+	return nil
+}
+
+func (bot *GroupMeBot) routes(r gin.IRouter) {
+	r.POST("/groupme/callback", bot.handleCallback)
+}
+
+// reload switches to a new set of groups and bots, reporting whether it
+// changed.
+func (bot *GroupMeBot) reload(bots map[string]string) bool {
+	bot.mu.Lock()
+	defer bot.mu.Unlock()
+	changed := len(bots) != len(bot.bots)
+	for group, id := range bots {
+		if bot.bots[group] != id {
+			changed = true
+		}
+	}
+	bot.bots = bots
+	return changed
+}
+
+// botFor returns the bot that posts in a group, if the group is configured.
+func (bot *GroupMeBot) botFor(group string) (string, bool) {
+	bot.mu.RLock()
+	defer bot.mu.RUnlock()
+	id, ok := bot.bots[group]
+	return id, ok
+}
+
+// groups lists the configured groups in order.
+func (bot *GroupMeBot) groups() []string {
+	bot.mu.RLock()
+	defer bot.mu.RUnlock()
+	groups := make([]string, 0, len(bot.bots))
+	for group := range bot.bots {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// handleCallback answers a message posted to a group. GroupMe doesn't sign
+// its callbacks, so messages from groups that aren't configured are ignored,
+// as are the bots' own posts.
+func (bot *GroupMeBot) handleCallback(c *gin.Context) {
+	var message GroupMeMessage
+	if err := c.ShouldBindJSON(&message); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid message")
+		return
+	}
+	id, ok := bot.botFor(message.GroupID)
+	if !ok || message.SenderType == "bot" {
+		c.Status(http.StatusOK)
+		return
+	}
+	if reply := bot.reply(c.Request.Context(), message.Text); reply != "" {
+		if err := bot.post(id, reply); err != nil {
+			log.Printf("Failed to reply to GroupMe group %s: %v\n", message.GroupID, err)
+		}
+	}
+	c.Status(http.StatusOK)
+}
+
+// reply answers "!menu", optionally followed by tomorrow and a meal. Anything
+// else said in the group goes unanswered.
+func (bot *GroupMeBot) reply(ctx context.Context, text string) string {
+	fields := strings.Fields(strings.ToLower(text))
+	if len(fields) == 0 || fields[0] != "!menu" {
+		return ""
+	}
+	date, _ := time.Parse(huds.ServeDateLayout, bot.server.today())
+	meal := ""
+	for _, arg := range fields[1:] {
+		switch arg {
+		case "today":
+		case "tomorrow":
+			date = date.AddDate(0, 0, 1)
+		case "help":
+			return groupMeHelp
+		default:
+			meal = arg
+		}
+	}
+	return bot.server.menuTextReply(ctx, date, meal)
+}
+
+// postAfterRefresh posts today's menu to every group the first time a
+// refresh stores it, which is usually the early morning refresh. Each group
+// gets a day's menu only once, however many refreshes include it.
+func (bot *GroupMeBot) postAfterRefresh(ctx context.Context, data map[string]map[int][]huds.CondensedMenuItem) {
+	today := bot.server.today()
+	if _, published := data[today]; !published {
+		return
+	}
+	date, _ := time.Parse(huds.ServeDateLayout, today)
+	text := bot.server.menuTextReply(ctx, date, "")
+	for _, group := range bot.groups() {
+		id, ok := bot.botFor(group)
+		if !ok {
+			continue
+		}
+		_, err := bot.posts.InsertOne(ctx, bson.M{"_id": group + "|" + today, "posted_at": bot.server.clock.Now()})
+		if err != nil {
+			// Already posted (duplicate key) or the write failed; skip either way
+			continue
+		}
+		if err := bot.post(id, text); err != nil {
+			log.Printf("Failed to post the menu to GroupMe group %s: %v\n", group, err)
+		}
+	}
+}
+
+// post sends text as a bot, split into as many posts as it takes.
+func (bot *GroupMeBot) post(id string, text string) error {
+	for _, part := range splitMessage(text, groupMeMaxLength) {
+		body, err := json.Marshal(map[string]string{"bot_id": id, "text": part})
+		if err != nil {
+			return err
+		}
+		resp, err := bot.httpClient.Post(groupMeApiUrl, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("groupme post failed with status %d", resp.StatusCode)
+		}
+	}
+	return nil
+}
+
+// splitMessage breaks text into parts of at most max bytes, between lines
+// where it can and between words where a line is too long by itself.
+func splitMessage(text string, max int) []string {
+	var parts []string
+	var b strings.Builder
+	flush := func() {
+		if part := strings.TrimSpace(b.String()); part != "" {
+			parts = append(parts, part)
+		}
+		b.Reset()
+	}
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		for len(line) > max {
+			cut := strings.LastIndex(line[:max], " ")
+			if cut <= 0 {
+				cut = max
+			}
+			flush()
+			b.WriteString(line[:cut])
+			flush()
+			line = strings.TrimSpace(line[cut:])
+		}
+		if b.Len() > 0 && b.Len()+1+len(line) > max {
+			flush()
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(line)
+	}
+	flush()
+	return parts
+}
+
+// menuTextReply renders a day's menu as /huds-data.txt does, for the bots,
+// limited to a single meal when one is given.
+func (s *Server) menuTextReply(ctx context.Context, date time.Time, meal string) string {
+	serveDate := date.Format(huds.ServeDateLayout)
+	dbCtx, cancel := s.dbContext(ctx)
+	defer cancel()
+	menu, err := s.menuByDate(dbCtx, serveDate)
+	if err != nil {
+		if err == store.ErrMenuNotFound {
+			return fmt.Sprintf("No menu has been published for %s yet.", serveDate)
+		}
+		log.Printf("Failed to fetch menu for %s: %v\n", serveDate, err)
+		return "Sorry, I couldn't load the menu right now."
+	}
+	if meal != "" && !containsString(menu.Meals(), meal) {
+		return fmt.Sprintf("There's no %s on the menu for %s.", meal, serveDate)
+	}
+	schedule, service := s.dayHours(ctx, date)
+	return menuText(menu, date, meal, schedule, service)
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// GroupMe doesn't sign its callbacks, so all that keeps others from using
+// the bots is that only the configured groups are answered.
+func TestGroupMeCallback(t *testing.T) {
+	s, _ := newTestServer(t)
+	sent := &outbox{}
+	bot := &GroupMeBot{server: s, bots: map[string]string{"1001": "bot-a"}, httpClient: &http.Client{Transport: sent}}
+	router := gin.New()
+	bot.routes(router)
+
+	callback := func(message string) int {
+		req := httptest.NewRequest(http.MethodPost, "/groupme/callback", strings.NewReader(message))
+		req.Header.Set("Content-Type", "application/json")
+		return serve(router, req).Code
+	}
+	for name, message := range map[string]string{
+		"another group":   `{"group_id":"2002","sender_type":"user","text":"!menu dinner"}`,
+		"the bot itself":  `{"group_id":"1001","sender_type":"bot","text":"!menu dinner"}`,
+		"not a command":   `{"group_id":"1001","sender_type":"user","text":"what's for dinner?"}`,
+		"no group at all": `{"sender_type":"user","text":"!menu dinner"}`,
+	} {
+		if code := callback(message); code != http.StatusOK {
+			t.Errorf("%s: got %d, want 200", name, code)
+		}
+	}
+	if len(sent.sent) > 0 {
+		t.Fatalf("posted %q for messages that should be ignored", sent.sent)
+	}
+	if code := callback(`{"group_id":"1001","sender_type":"user","text":"!menu dinner"}`); code != http.StatusOK {
+		t.Fatalf("from the configured group: got %d, want 200", code)
+	}
+	if len(sent.sent) == 0 || !strings.Contains(sent.sent[0], `"bot_id":"bot-a"`) || !strings.Contains(strings.Join(sent.sent, ""), "Baked Haddock") {
+		t.Errorf("got posts %q, want today's dinner from bot-a", sent.sent)
+	}
+	if code := callback(`not json`); code != http.StatusBadRequest {
+		t.Errorf("a malformed callback: got %d, want 400", code)
+	}
+}
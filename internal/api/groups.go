@@ -0,0 +1,62 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"net/http"
+)
+
+const groupByKey = "group_by"
+
+// GroupByCategory is the ?group_by= value that groups each meal's items by
+// menu category.
+const GroupByCategory = "category"
+
+// bindGroupBy parses ?group_by= for DatedMenu. Only "category" is supported;
+// anything else is answered with 400.
+func bindGroupBy(c *gin.Context) {
+	value := c.Query("group_by")
+	if value == "" {
+		return
+	}
+	if value != GroupByCategory {
+		respondErrorDetails(c, http.StatusBadRequest, CodeInvalidRequest, "group_by must be "+GroupByCategory, gin.H{"group_by": value})
+		return
+	}
+	c.Set(groupByKey, value)
+}
+
+// itemGroupBy returns the grouping bound by bindGroupBy, "" for none.
+func itemGroupBy(c *gin.Context) string {
+	groupBy, _ := c.Value(groupByKey).(string)
+	return groupBy
+}
+
+// encodeByCategory encodes items as an object of category name to items,
+// narrowed to fields like selectFields. Categories keep the order HUDS lists
+// them in, which is how the dining hall presents them.
+func encodeByCategory(items []huds.CondensedMenuItem, fields []string) (json.RawMessage, error) {
+	categories, byCategory := groupByCategory(items)
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, category := range categories {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(category)
+		if err != nil {
+			return nil, err
+		}
+		value, err := selectFields(byCategory[category], fields)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
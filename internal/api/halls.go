@@ -0,0 +1,34 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+)
+
+// HallViews is how v3 serves each meal: Annenberg Hall's first-year menu
+// next to the houses' menu. Annenberg is null for days stored before its
+// menu was kept.
+type HallViews struct {
+	Annenberg json.RawMessage `json:"annenberg"`
+	Houses    json.RawMessage `json:"houses"`
+}
+
+// splitHalls reports whether a request is served separate Annenberg and
+// house views of each meal, as it is from v3 on.
+func splitHalls(c *gin.Context) bool {
+	return apiVersion(c) >= 3
+}
+
+// mapHalls applies f to a menu and, on its own, to the menu's Annenberg view,
+// so that options like ?dedupe= treat each hall's menu separately.
+func mapHalls(menu huds.CondensedMenu, f func(huds.CondensedMenu) huds.CondensedMenu) huds.CondensedMenu {
+	out := f(menu)
+	if menu.Annenberg != nil {
+		annenberg := huds.LocationMenuOf(menu.Annenberg.Name, f(menu.Annenberg.Menu(menu.ServeDate)))
+		out.Annenberg = &annenberg
+	} else {
+		out.Annenberg = nil
+	}
+	return out
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultHealthyPicksLimit = 3
+	maxHealthyPicksLimit     = 20
+)
+
+type HealthyPicks struct {
+	ServeDate string              `json:"Serve_Date"`
+	Weights   huds.DensityWeights `json:"weights"`
+	// Meals lists each meal's best scored items, best first
+	Meals map[string][]huds.CondensedMenuItem `json:"meals"`
+}
+
+// healthyPicks ranks each meal's items by nutrient density and keeps the top
+// limit. Items stored before scores were kept are scored now; cancelled
+// items, items without calories and repeats of a dish are left out.
+func healthyPicks(menu huds.CondensedMenu, weights huds.DensityWeights, limit int) HealthyPicks {
+	picks := HealthyPicks{ServeDate: menu.ServeDate, Weights: weights, Meals: map[string][]huds.CondensedMenuItem{}}
+	for _, meal := range menu.Meals() {
+		scored := []huds.CondensedMenuItem{}
+		seen := make(map[string]bool)
+		for _, item := range huds.MealItems(menu, meal) {
+			key := strings.ToLower(strings.TrimSpace(item.FoodName))
+			if item.Cancelled || seen[key] {
+				continue
+			}
+			if item.NutrientDensity == nil {
+				facts := item.Nutrition
+				if facts == nil {
+					facts = huds.ParseNutrition(item)
+				}
+				item.NutrientDensity = huds.DensityScore(facts, weights)
+			}
+			if item.NutrientDensity == nil {
+				continue
+			}
+			seen[key] = true
+			scored = append(scored, item)
+		}
+		sort.SliceStable(scored, func(i, j int) bool {
+			return *scored[i].NutrientDensity > *scored[j].NutrientDensity
+		})
+		if len(scored) > limit {
+			scored = scored[:limit]
+		}
+		picks.Meals[meal] = scored
+	}
+	return picks
+}
+
+// handleHealthyPicks returns the most nutrient-dense items of each meal on a
+// day.
+func (s *Server) handleHealthyPicks(c *gin.Context) {
+	serveDate := serveDateParam(c).Format(huds.ServeDateLayout)
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultHealthyPicksLimit)))
+	if err != nil || limit < 1 || limit > maxHealthyPicksLimit {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "limit must be between 1 and "+strconv.Itoa(maxHealthyPicksLimit))
+		return
+	}
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	menu, err := s.menuByDate(ctx, serveDate)
+	if err == store.ErrMenuNotFound {
+		respondError(c, http.StatusNotFound, CodeNotFound, "no menu for this date")
+		return
+	}
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	picks := healthyPicks(menu, huds.CurrentDensityWeights(), limit)
+	respond(c, http.StatusOK, picks, ResponseMeta{ServeDate: serveDate, Source: SourceDB, LastUpdated: menu.UpdatedAt, Stale: s.revalidateStale(menu)})
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"log"
+	"net/http"
+	"time"
+)
+
+// MealChange is how one meal differs from the revision before it.
+type MealChange struct {
+	Meal    string   `json:"meal"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+type Revision struct {
+	Revision   int          `json:"revision"`
+	RecordedAt time.Time    `json:"recorded_at"`
+	Changes    []MealChange `json:"changes"`
+}
+
+type MenuHistory struct {
+	ServeDate string     `json:"Serve_Date"`
+	Revisions []Revision `json:"revisions"`
+}
+
+// mealChanges lists the meals whose items differ between two versions of a
+// day's menu, by food name.
+func mealChanges(before huds.CondensedMenu, after huds.CondensedMenu) []MealChange {
+	meals := before.Meals()
+	for _, meal := range after.Meals() {
+		if len(huds.MealItems(before, meal)) == 0 {
+			meals = append(meals, meal)
+		}
+	}
+	changes := []MealChange{}
+	seen := make(map[string]bool)
+	for _, meal := range meals {
+		if seen[meal] {
+			continue
+		}
+		seen[meal] = true
+		was, now := huds.MealItems(before, meal), huds.MealItems(after, meal)
+		added, removed := missingItems(now, was), missingItems(was, now)
+		if len(added) == 0 && len(removed) == 0 {
+			continue
+		}
+		changes = append(changes, MealChange{Meal: meal, Added: foodNames(added), Removed: foodNames(removed)})
+	}
+	return changes
+}
+
+// recordHistory compares each menu about to be stored with storedMenus, what
+// is stored now. Menus whose items differ are snapshotted as a new revision, and each
+// changed meal is logged as an event. A date stored before revisions were
+// kept gets its stored menu as the first revision.
+func (s *Server) recordHistory(ctx context.Context, menus []huds.CondensedMenu, storedMenus map[string]huds.CondensedMenu, recordedAt time.Time) {
+	revisions, keepsRevisions := s.store.(store.RevisionStore)
+	eventLog, keepsEvents := s.store.(store.EventLog)
+	if !keepsRevisions && !keepsEvents {
+		return
+	}
+	var events []store.MenuEvent
+	for _, menu := range menus {
+		stored, wasStored := storedMenus[menu.ServeDate]
+		changes := mealChanges(stored, menu)
+		if len(changes) == 0 {
+			continue
+		}
+		for _, change := range changes {
+			events = append(events, store.MenuEvent{
+				Type:      changeType(stored, menu, change.Meal),
+				ServeDate: menu.ServeDate,
+				Meal:      change.Meal,
+				Added:     change.Added,
+				Removed:   change.Removed,
+				CreatedAt: recordedAt,
+			})
+		}
+		if keepsRevisions {
+			addRevision(ctx, revisions, stored, wasStored, menu, recordedAt)
+		}
+	}
+	if keepsEvents && len(events) > 0 {
+		if err := eventLog.AppendEvents(ctx, events); err != nil {
+			log.Printf("Failed to log menu events: %v\n", err)
+		}
+	}
+}
+
+// storedMenus returns what is stored for the dates of menus, keyed by serve
+// date, reading the whole span at once rather than a date at a time.
+func (s *Server) storedMenus(ctx context.Context, menus []huds.CondensedMenu) (map[string]huds.CondensedMenu, error) {
+	var first, last time.Time
+	for _, menu := range menus {
+		date, err := time.Parse(huds.ServeDateLayout, menu.ServeDate)
+		if err != nil {
+			continue
+		}
+		if first.IsZero() || date.Before(first) {
+			first = date
+		}
+		if date.After(last) {
+			last = date
+		}
+	}
+	stored := make(map[string]huds.CondensedMenu)
+	if first.IsZero() {
+		return stored, nil
+	}
+	found, err := s.store.GetRange(ctx, first.Format(huds.ServeDateLayout), last.Format(huds.ServeDateLayout))
+	if err != nil {
+		return nil, err
+	}
+	for _, menu := range found {
+		stored[menu.ServeDate] = menu
+	}
+	return stored, nil
+}
+
+func addRevision(ctx context.Context, revisions store.RevisionStore, stored huds.CondensedMenu, wasStored bool, menu huds.CondensedMenu, recordedAt time.Time) {
+	if wasStored {
+		previous, err := revisions.Revisions(ctx, menu.ServeDate)
+		if err != nil {
+			log.Printf("Failed to load revisions of %s: %v\n", menu.ServeDate, err)
+			return
+		}
+		if len(previous) == 0 {
+			if err := revisions.AddRevision(ctx, menu.ServeDate, stored.UpdatedAt, stored); err != nil {
+				log.Printf("Failed to store a revision of %s: %v\n", menu.ServeDate, err)
+				return
+			}
+		}
+	}
+	if err := revisions.AddRevision(ctx, menu.ServeDate, recordedAt, menu); err != nil {
+		log.Printf("Failed to store a revision of %s: %v\n", menu.ServeDate, err)
+	}
+}
+
+// changeType says whether a changed meal was published, updated or removed.
+func changeType(before huds.CondensedMenu, after huds.CondensedMenu, meal string) string {
+	switch {
+	case len(huds.MealItems(before, meal)) == 0:
+		return ChangeMenuPublished
+	case len(huds.MealItems(after, meal)) == 0:
+		return ChangeMenuRemoved
+	}
+	return ChangeMenuUpdated
+}
+
+// handleMenuHistory serves every revision of a day's menu with what changed in
+// each, for tracking down upstream data issues.
+func (s *Server) handleMenuHistory(c *gin.Context) {
+	date := serveDateParam(c)
+	serveDate := date.Format(huds.ServeDateLayout)
+	dateFormat := c.DefaultQuery("date_format", s.dateFormat)
+	if !validDateFormat(dateFormat) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "date_format must be 'us' or 'iso'")
+		return
+	}
+	revisions, ok := s.store.(store.RevisionStore)
+	if !ok {
+		respondError(c, http.StatusNotImplemented, CodeNotImplemented, "menu history isn't kept by this storage backend")
+		return
+	}
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	stored, err := revisions.Revisions(ctx, serveDate)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	if len(stored) == 0 {
+		respondError(c, http.StatusNotFound, CodeNotFound, "no history for this date")
+		return
+	}
+
+	history := MenuHistory{ServeDate: formatServeDate(serveDate, dateFormat), Revisions: make([]Revision, len(stored))}
+	var previous huds.CondensedMenu
+	for i, revision := range stored {
+		history.Revisions[i] = Revision{
+			Revision:   revision.Revision,
+			RecordedAt: revision.RecordedAt,
+			Changes:    mealChanges(previous, revision.Menu),
+		}
+		previous = revision.Menu
+	}
+	respond(c, http.StatusOK, history, ResponseMeta{ServeDate: history.ServeDate, Source: SourceDB, LastUpdated: stored[len(stored)-1].RecordedAt})
+}
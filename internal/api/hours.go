@@ -0,0 +1,343 @@
+package api
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// hoursCacheTTL is how long the hours are kept in memory between
+	// reloads, so replicas pick up edits made through another one
+	hoursCacheTTL  = time.Minute
+	hoursTimeOfDay = "15:04"
+	// nowServingHeader names the meal being served, in v1 responses that
+	// have no envelope to say so
+	nowServingHeader = "X-Now-Serving"
+)
+
+// regularHours apply when no schedule does. They end when mealEnds does.
+var regularHours = store.HoursSchedule{
+	ID:   "regular",
+	Name: "Regular hours",
+	Windows: []store.ServiceWindow{
+		{Meal: "breakfast", Opens: "07:30", Closes: "10:30"},
+		{Meal: "lunch", Opens: "11:30", Closes: "14:00"},
+		{Meal: "dinner", Opens: "16:30", Closes: "20:00"},
+	},
+}
+
+var weekdays = map[string]string{
+	"sun": "sun", "sunday": "sun",
+	"mon": "mon", "monday": "mon",
+	"tue": "tue", "tuesday": "tue",
+	"wed": "wed", "wednesday": "wed",
+	"thu": "thu", "thursday": "thu",
+	"fri": "fri", "friday": "fri",
+	"sat": "sat", "saturday": "sat",
+}
+
+// MealService is when a meal is served on a day, and whether it is now.
+type MealService struct {
+	Meal    string    `json:"meal"`
+	Opens   time.Time `json:"opens"`
+	Closes  time.Time `json:"closes"`
+	Serving bool      `json:"serving"`
+}
+
+type DayHours struct {
+	Date string `json:"date"`
+	// Schedule names the schedule that applies, e.g. "Regular hours"
+	Schedule string        `json:"schedule"`
+	Closed   bool          `json:"closed"`
+	Note     string        `json:"note,omitempty"`
+	Meals    []MealService `json:"meals"`
+}
+
+type HoursRequest struct {
+	Name     string                `json:"name" binding:"required"`
+	Weekdays []string              `json:"weekdays"`
+	From     string                `json:"from"`
+	Until    string                `json:"until"`
+	Closed   bool                  `json:"closed"`
+	Windows  []store.ServiceWindow `json:"windows"`
+	Note     string                `json:"note"`
+}
+
+// hoursSchedules returns the admin-maintained hours, reloading them from the
+// store once they are older than hoursCacheTTL. Stores that keep no hours
+// have none. A failed reload isn't retried until the TTL is up again, as
+// every menu response asks, and the last hours loaded are kept meanwhile.
+func (s *Server) hoursSchedules(ctx context.Context) ([]store.HoursSchedule, error) {
+	hoursStore, ok := s.store.(store.HoursStore)
+	if !ok {
+		return nil, nil
+	}
+	s.hours.Lock()
+	defer s.hours.Unlock()
+	if !s.hours.loadedAt.IsZero() && time.Since(s.hours.loadedAt) < hoursCacheTTL {
+		return s.hours.schedules, nil
+	}
+	ctx, cancel := s.dbContext(ctx)
+	defer cancel()
+	schedules, err := hoursStore.ListHours(ctx)
+	s.hours.loadedAt = time.Now()
+	if err != nil {
+		return s.hours.schedules, err
+	}
+	s.hours.schedules = schedules
+	return schedules, nil
+}
+
+// resetHours drops the cached hours after an edit.
+func (s *Server) resetHours() {
+	s.hours.Lock()
+	defer s.hours.Unlock()
+	s.hours.loadedAt = time.Time{}
+}
+
+// hoursApply reports whether a schedule covers date.
+func hoursApply(schedule store.HoursSchedule, date time.Time) bool {
+	if from, err := time.Parse(huds.ServeDateLayout, schedule.From); err == nil && date.Before(from) {
+		return false
+	}
+	if until, err := time.Parse(huds.ServeDateLayout, schedule.Until); err == nil && date.After(until) {
+		return false
+	}
+	if len(schedule.Weekdays) == 0 {
+		return true
+	}
+	weekday := strings.ToLower(date.Weekday().String()[:3])
+	return containsString(schedule.Weekdays, weekday)
+}
+
+// hoursOn picks the schedule for date: of those covering it, one with dates
+// beats one without, then one with weekdays, then the latest edit.
+func hoursOn(schedules []store.HoursSchedule, date time.Time) store.HoursSchedule {
+	rank := func(schedule store.HoursSchedule) int {
+		rank := 0
+		if schedule.From != "" || schedule.Until != "" {
+			rank += 2
+		}
+		if len(schedule.Weekdays) > 0 {
+			rank++
+		}
+		return rank
+	}
+	best, found := regularHours, false
+	for _, schedule := range schedules {
+		if !hoursApply(schedule, date) {
+			continue
+		}
+		if !found || rank(schedule) > rank(best) || rank(schedule) == rank(best) && schedule.UpdatedAt.After(best.UpdatedAt) {
+			best, found = schedule, true
+		}
+	}
+	return best
+}
+
+// dayHours is when each meal is served on date. If the hours can't be read,
+// the ones last loaded are used, as the menu matters more than its hours.
+func (s *Server) dayHours(ctx context.Context, date time.Time) (store.HoursSchedule, []MealService) {
+	schedules, err := s.hoursSchedules(ctx)
+	if err != nil {
+		log.Printf("Failed to load dining hours: %v\n", err)
+	}
+	schedule := hoursOn(schedules, date)
+	now := s.localNow()
+	meals := []MealService{}
+	if schedule.Closed {
+		return schedule, meals
+	}
+	for _, window := range schedule.Windows {
+		opens, openErr := time.Parse(hoursTimeOfDay, window.Opens)
+		closes, closeErr := time.Parse(hoursTimeOfDay, window.Closes)
+		if openErr != nil || closeErr != nil {
+			continue
+		}
+		service := MealService{
+			Meal:   window.Meal,
+			Opens:  time.Date(date.Year(), date.Month(), date.Day(), opens.Hour(), opens.Minute(), 0, 0, huds.DiningZone),
+			Closes: time.Date(date.Year(), date.Month(), date.Day(), closes.Hour(), closes.Minute(), 0, 0, huds.DiningZone),
+		}
+		service.Serving = !now.Before(service.Opens) && now.Before(service.Closes)
+		meals = append(meals, service)
+	}
+	return schedule, meals
+}
+
+// mealService is when each meal of a menu's day is served, for its response
+// meta.
+func (s *Server) mealService(serveDate string) []MealService {
+	date, err := time.Parse(huds.ServeDateLayout, serveDate)
+	if err != nil {
+		return nil
+	}
+	_, meals := s.dayHours(context.Background(), date)
+	return meals
+}
+
+// handleHours serves the meal service windows on ?date=, today by default,
+// and whether each meal is being served now.
+func (s *Server) handleHours(c *gin.Context) {
+	date, ok := s.queryDate(c, "date")
+	if !ok {
+		return
+	}
+	if date.IsZero() {
+		date, _ = time.Parse(huds.ServeDateLayout, s.today())
+	}
+	dateFormat := c.DefaultQuery("date_format", s.dateFormat)
+	if !validDateFormat(dateFormat) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "date_format must be 'us' or 'iso'")
+		return
+	}
+
+	serveDate := date.Format(huds.ServeDateLayout)
+	schedule, meals := s.dayHours(c.Request.Context(), date)
+	respond(c, http.StatusOK, DayHours{
+		Date:     formatServeDate(serveDate, dateFormat),
+		Schedule: schedule.Name,
+		Closed:   schedule.Closed,
+		Note:     schedule.Note,
+		Meals:    meals,
+	}, ResponseMeta{ServeDate: formatServeDate(serveDate, dateFormat)})
+}
+
+// hoursStore returns the menu store's hours, answering 501 if the configured
+// store keeps none.
+func (s *Server) hoursStore(c *gin.Context) (store.HoursStore, bool) {
+	hoursStore, ok := s.store.(store.HoursStore)
+	if !ok {
+		respondError(c, http.StatusNotImplemented, CodeNotImplemented, "dining hours are not supported by this storage backend")
+	}
+	return hoursStore, ok
+}
+
+func (s *Server) handleAdminListHours(c *gin.Context) {
+	hoursStore, ok := s.hoursStore(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	schedules, err := hoursStore.ListHours(ctx)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	respond(c, http.StatusOK, schedules, ResponseMeta{Source: SourceDB})
+}
+
+// handleAdminPutHours replaces a schedule, e.g. term hours on weekdays, or
+// {"name": "Thanksgiving", "from": "11/26/2026", "until": "11/29/2026",
+// "closed": true}.
+func (s *Server) handleAdminPutHours(c *gin.Context) {
+	hoursStore, ok := s.hoursStore(c)
+	if !ok {
+		return
+	}
+	var req HoursRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+	schedule := store.HoursSchedule{
+		ID:        strings.TrimSpace(c.Param("id")),
+		Name:      strings.TrimSpace(req.Name),
+		Weekdays:  []string{},
+		Closed:    req.Closed,
+		Windows:   []store.ServiceWindow{},
+		Note:      req.Note,
+		UpdatedAt: time.Now().UTC(),
+	}
+	for _, day := range req.Weekdays {
+		weekday, ok := weekdays[strings.ToLower(strings.TrimSpace(day))]
+		if !ok {
+			respondErrorDetails(c, http.StatusBadRequest, CodeInvalidRequest, "weekdays must be days of the week, e.g. mon or monday", gin.H{"weekday": day})
+			return
+		}
+		if !containsString(schedule.Weekdays, weekday) {
+			schedule.Weekdays = append(schedule.Weekdays, weekday)
+		}
+	}
+	for _, bound := range []struct {
+		name  string
+		value string
+		date  *string
+	}{{"from", req.From, &schedule.From}, {"until", req.Until, &schedule.Until}} {
+		if bound.value == "" {
+			continue
+		}
+		date, err := s.parseDate(bound.value)
+		if err != nil {
+			respondErrorDetails(c, http.StatusBadRequest, CodeDateInvalid, bound.name+" must be MM/DD/YYYY, YYYY-MM-DD, today or tomorrow", gin.H{bound.name: bound.value})
+			return
+		}
+		*bound.date = date.Format(huds.ServeDateLayout)
+	}
+	if from, until := schedule.From, schedule.Until; from != "" && until != "" {
+		fromDate, _ := time.Parse(huds.ServeDateLayout, from)
+		untilDate, _ := time.Parse(huds.ServeDateLayout, until)
+		if untilDate.Before(fromDate) {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "until can't be before from")
+			return
+		}
+	}
+	if !req.Closed {
+		if len(req.Windows) == 0 {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "windows are required unless the halls are closed")
+			return
+		}
+		for _, window := range req.Windows {
+			window.Meal = strings.ToLower(strings.TrimSpace(window.Meal))
+			opens, openErr := time.Parse(hoursTimeOfDay, window.Opens)
+			closes, closeErr := time.Parse(hoursTimeOfDay, window.Closes)
+			if window.Meal == "" || openErr != nil || closeErr != nil || !opens.Before(closes) {
+				respondErrorDetails(c, http.StatusBadRequest, CodeInvalidRequest, "each window needs a meal and opens before closes, as HH:MM", gin.H{"window": window})
+				return
+			}
+			window.Opens, window.Closes = opens.Format(hoursTimeOfDay), closes.Format(hoursTimeOfDay)
+			schedule.Windows = append(schedule.Windows, window)
+		}
+		sort.SliceStable(schedule.Windows, func(i, j int) bool { return schedule.Windows[i].Opens < schedule.Windows[j].Opens })
+	}
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	if err := hoursStore.PutHours(ctx, schedule); err != nil {
+		log.Printf("Failed to store dining hours %s: %v\n", schedule.ID, err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to store dining hours")
+		return
+	}
+	s.resetHours()
+	respond(c, http.StatusOK, schedule, ResponseMeta{})
+}
+
+func (s *Server) handleAdminDeleteHours(c *gin.Context) {
+	hoursStore, ok := s.hoursStore(c)
+	if !ok {
+		return
+	}
+	id := strings.TrimSpace(c.Param("id"))
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	err := hoursStore.DeleteHours(ctx, id)
+	if err == store.ErrMenuNotFound {
+		respondError(c, http.StatusNotFound, CodeNotFound, "no dining hours with this id")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete dining hours %s: %v\n", id, err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to delete dining hours")
+		return
+	}
+	s.resetHours()
+	c.Status(http.StatusNoContent)
+}
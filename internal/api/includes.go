@@ -0,0 +1,64 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"strings"
+)
+
+// included reports whether ?include= lists name, e.g. ?include=ingredients,nutrition.
+// Asking for the details by name in ?fields= includes them too.
+func included(c *gin.Context, name string) bool {
+	for _, include := range strings.Split(c.Query("include"), ",") {
+		if strings.TrimSpace(include) == name {
+			return true
+		}
+	}
+	switch name {
+	case "ingredients":
+		return fieldSelected(c, "Ingredient_List", "Recipe_Product_Information")
+	case "nutrition":
+		return fieldSelected(c, "nutrition", "serving")
+	case "prices":
+		return fieldSelected(c, "Portion_Cost", "Selling_Price")
+	}
+	return false
+}
+
+// withIncludes leaves the item details that ?include= asks for out of a menu
+// unless they were asked for. v1 has always included Ingredient_List, so it
+// keeps it either way.
+func withIncludes(c *gin.Context, menu huds.CondensedMenu) huds.CondensedMenu {
+	ingredients, nutrition, prices := included(c, "ingredients"), included(c, "nutrition"), included(c, "prices")
+	if ingredients && nutrition && prices {
+		return menu
+	}
+	keepList := ingredients || apiVersion(c) < 2
+	return menu.MapMeals(func(items []huds.CondensedMenuItem) []huds.CondensedMenuItem {
+		if items == nil {
+			return nil
+		}
+		trimmed := make([]huds.CondensedMenuItem, len(items))
+		for i, item := range items {
+			if !keepList {
+				item.Ingredients = ""
+			}
+			if !ingredients {
+				item.ProductInformation = ""
+			}
+			if !nutrition {
+				item.Nutrition, item.Serving = nil, nil
+			} else {
+				if item.Nutrition == nil {
+					item.Nutrition = huds.ParseNutrition(item)
+				}
+				item.Serving = itemServing(item)
+			}
+			if !prices {
+				item.PortionCost, item.SellingPrice = nil, nil
+			}
+			trimmed[i] = item
+		}
+		return trimmed
+	})
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type ItemIngredients struct {
+	ID                 int                  `json:"ID"`
+	FoodName           string               `json:"Food_Name"`
+	ServeDate          string               `json:"Serve_Date"`
+	Meal               string               `json:"meal"`
+	Allergens          string               `json:"Allergens"`
+	IngredientList     string               `json:"Ingredient_List"`
+	ProductInformation string               `json:"Recipe_Product_Information"`
+	Ingredients        []string             `json:"ingredients"`
+	Nutrition          *huds.NutritionFacts `json:"nutrition"`
+	// DerivedAllergens are allergens found in the ingredients that Allergens
+	// misses
+	DerivedAllergens []huds.DerivedAllergen `json:"derived_allergens"`
+}
+
+// handleItemIngredients serves everything HUDS says about what is in an item,
+// by its upstream ID, from the most recent day it was served.
+func (s *Server) handleItemIngredients(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id < 1 {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid item id")
+		return
+	}
+	dateFormat := c.DefaultQuery("date_format", s.dateFormat)
+	if !validDateFormat(dateFormat) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "date_format must be 'us' or 'iso'")
+		return
+	}
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	item, date, meal, err := s.store.ItemByID(ctx, id)
+	if err == store.ErrMenuNotFound {
+		respondError(c, http.StatusNotFound, CodeNotFound, "item not found")
+		return
+	}
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	nutrition := item.Nutrition
+	if nutrition == nil {
+		nutrition = huds.ParseNutrition(item)
+	}
+	derivedAllergens := huds.ItemDerivedAllergens(item)
+	if derivedAllergens == nil {
+		derivedAllergens = []huds.DerivedAllergen{}
+	}
+	respond(c, http.StatusOK, ItemIngredients{
+		ID:                 item.ID,
+		FoodName:           item.FoodName,
+		ServeDate:          formatServeDate(date, dateFormat),
+		Meal:               meal,
+		Allergens:          item.Allergens,
+		IngredientList:     item.Ingredients,
+		ProductInformation: item.ProductInformation,
+		Ingredients:        splitIngredients(item.Ingredients),
+		Nutrition:          nutrition,
+		DerivedAllergens:   derivedAllergens,
+	}, ResponseMeta{ServeDate: formatServeDate(date, dateFormat), Source: SourceDB})
+}
+
+// splitIngredients splits an ingredient list on the commas between
+// ingredients, keeping sub-ingredients such as "Bread (Flour, Yeast)" whole.
+func splitIngredients(list string) []string {
+	ingredients := []string{}
+	depth, start := 0, 0
+	for i, r := range list {
+		switch r {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			if depth > 0 {
+				depth--
+			}
+		case ',':
+			if depth == 0 {
+				if ingredient := strings.TrimSpace(list[start:i]); ingredient != "" {
+					ingredients = append(ingredients, ingredient)
+				}
+				start = i + 1
+			}
+		}
+	}
+	if ingredient := strings.TrimSpace(list[start:]); ingredient != "" {
+		ingredients = append(ingredients, ingredient)
+	}
+	return ingredients
+}
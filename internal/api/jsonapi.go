@@ -0,0 +1,230 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// mimeJSONAPI is the JSON:API media type, https://jsonapi.org/format/1.1/.
+const mimeJSONAPI = "application/vnd.api+json"
+
+// JSON:API resource types.
+const (
+	ResourceMenus     = "menus"
+	ResourceMenuItems = "menu-items"
+	ResourceRecipes   = "recipes"
+)
+
+// JSONAPIDocument is the top level of every JSON:API response. Data is a
+// resource or a list of them; responses that aren't modelled as resources
+// leave it out and carry their body under meta.data, as do errors.
+type JSONAPIDocument struct {
+	Data     interface{}            `json:"data,omitempty"`
+	Errors   []JSONAPIError         `json:"errors,omitempty"`
+	Included []JSONAPIResource      `json:"included,omitempty"`
+	Meta     map[string]interface{} `json:"meta,omitempty"`
+	Links    map[string]string      `json:"links,omitempty"`
+	JSONAPI  map[string]string      `json:"jsonapi"`
+}
+
+type JSONAPIResource struct {
+	Type          string                         `json:"type"`
+	ID            string                         `json:"id"`
+	Attributes    interface{}                    `json:"attributes,omitempty"`
+	Relationships map[string]JSONAPIRelationship `json:"relationships,omitempty"`
+	Links         map[string]string              `json:"links,omitempty"`
+}
+
+// JSONAPIIdentifier points at a resource. Meta says how it is related, e.g.
+// the meals a recipe was served at on a date.
+type JSONAPIIdentifier struct {
+	Type string      `json:"type"`
+	ID   string      `json:"id"`
+	Meta interface{} `json:"meta,omitempty"`
+}
+
+// JSONAPIRelationship links a resource to others. Data is one identifier or
+// a list.
+type JSONAPIRelationship struct {
+	Data  interface{}       `json:"data"`
+	Links map[string]string `json:"links,omitempty"`
+}
+
+// JSONAPIError is an APIError as a JSON:API error object; its ID is the
+// request ID.
+type JSONAPIError struct {
+	ID     string `json:"id,omitempty"`
+	Status string `json:"status"`
+	Code   string `json:"code"`
+	Title  string `json:"title"`
+	Meta   gin.H  `json:"meta,omitempty"`
+}
+
+// jsonAPIResponse is implemented by responses that are typed resources. The
+// primary data is one JSONAPIResource or a list of them.
+type jsonAPIResponse interface {
+	jsonAPI() (primary interface{}, included []JSONAPIResource, err error)
+}
+
+// writeJSONAPI writes data as a JSON:API document, with meta as the
+// document's meta and its pagination as links.
+func writeJSONAPI(c *gin.Context, status int, data interface{}, meta ResponseMeta) {
+	document := JSONAPIDocument{Meta: map[string]interface{}{}, Links: map[string]string{"self": c.Request.URL.RequestURI()}}
+	if resources, ok := data.(jsonAPIResponse); ok {
+		primary, included, err := resources.jsonAPI()
+		if err != nil {
+			log.Printf("Failed to encode response as JSON:API: %v\n", err)
+			c.Error(err)
+			writeJSONAPIErrors(c, http.StatusInternalServerError, APIError{Code: CodeInternal, Message: "failed to encode response as JSON:API", RequestID: c.GetString(requestIDKey)})
+			return
+		}
+		document.Data, document.Included = primary, included
+	} else {
+		document.Meta["data"] = data
+	}
+
+	if meta.ServeDate != "" {
+		document.Meta["serve_date"] = meta.ServeDate
+	}
+	if meta.Source != "" {
+		document.Meta["source"] = meta.Source
+	}
+	if !meta.LastUpdated.IsZero() {
+		document.Meta["last_updated"] = meta.LastUpdated.Format(time.RFC3339)
+	}
+	if meta.Stale {
+		document.Meta["stale"] = true
+	}
+	if meta.Service != nil {
+		document.Meta["service"] = meta.Service
+	}
+	if page := meta.Pagination; page != nil {
+		document.Meta["pagination"] = page
+		if page.HasMore && page.Next != nil {
+			next := c.Request.URL.Query()
+			for key, values := range page.Next {
+				next[key] = values
+			}
+			document.Links["next"] = c.Request.URL.Path + "?" + next.Encode()
+		}
+	}
+	if len(document.Meta) == 0 {
+		document.Meta = nil
+	}
+	writeJSONAPIDocument(c, status, document)
+}
+
+func writeJSONAPIErrors(c *gin.Context, status int, errs ...APIError) {
+	document := JSONAPIDocument{}
+	for _, apiErr := range errs {
+		document.Errors = append(document.Errors, JSONAPIError{
+			ID:     apiErr.RequestID,
+			Status: strconv.Itoa(status),
+			Code:   apiErr.Code,
+			Title:  apiErr.Message,
+			Meta:   apiErr.Details,
+		})
+	}
+	writeJSONAPIDocument(c, status, document)
+}
+
+func writeJSONAPIDocument(c *gin.Context, status int, document JSONAPIDocument) {
+	document.JSONAPI = map[string]string{"version": "1.1"}
+	body, err := json.Marshal(document)
+	if err != nil {
+		log.Printf("Failed to encode JSON:API document: %v\n", err)
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encode response as JSON:API", "code": CodeInternal})
+		return
+	}
+	c.Data(status, mimeJSONAPI, body)
+}
+
+// resourceDate is a serve date as a resource ID, always YYYY-MM-DD whatever
+// the requested date format.
+func resourceDate(date string) string {
+	if t, err := time.Parse(huds.ServeDateLayout, date); err == nil {
+		return t.Format("2006-01-02")
+	}
+	return date
+}
+
+func recipeLinkage(number string) JSONAPIRelationship {
+	return JSONAPIRelationship{
+		Data:  JSONAPIIdentifier{Type: ResourceRecipes, ID: number},
+		Links: map[string]string{"related": "/recipes/" + url.PathEscape(number)},
+	}
+}
+
+// jsonAPI makes the menu a menus resource with a relationship to its items
+// for each meal, which are included. Items are narrowed to Fields; grouping
+// and split halls don't apply, as the relationships carry the structure.
+func (m DatedMenu) jsonAPI() (interface{}, []JSONAPIResource, error) {
+	out := formatMenuDates(m.CondensedMenu, m.DateFormat)
+	id := resourceDate(m.ServeDate)
+	menu := JSONAPIResource{
+		Type:          ResourceMenus,
+		ID:            id,
+		Attributes:    gin.H{"Serve_Date": out.ServeDate},
+		Relationships: map[string]JSONAPIRelationship{},
+		Links:         map[string]string{"self": "/huds-data?serve_date=" + id},
+	}
+	included := []JSONAPIResource{}
+	for _, meal := range out.Meals() {
+		items := huds.MealItems(out, meal)
+		encoded, err := selectFields(items, m.Fields)
+		if err != nil {
+			return nil, nil, err
+		}
+		var attributes []map[string]json.RawMessage
+		if err := json.Unmarshal(encoded, &attributes); err != nil {
+			return nil, nil, err
+		}
+		linkage := []JSONAPIIdentifier{}
+		for i, item := range items {
+			itemID := id + "." + meal + "." + strconv.Itoa(i)
+			if item.ID != 0 {
+				itemID = strconv.Itoa(item.ID)
+			}
+			resource := JSONAPIResource{
+				Type:          ResourceMenuItems,
+				ID:            itemID,
+				Attributes:    attributes[i],
+				Relationships: map[string]JSONAPIRelationship{"menu": {Data: JSONAPIIdentifier{Type: ResourceMenus, ID: id}}},
+			}
+			if item.RecipeNumber != "" {
+				resource.Relationships["recipe"] = recipeLinkage(item.RecipeNumber)
+			}
+			included = append(included, resource)
+			linkage = append(linkage, JSONAPIIdentifier{Type: ResourceMenuItems, ID: itemID})
+		}
+		menu.Relationships[huds.MealResponseKey(meal)] = JSONAPIRelationship{Data: linkage}
+	}
+	return menu, included, nil
+}
+
+// jsonAPI makes the recipe a recipes resource related to the menus of the
+// days it was served, with the meals it was served at.
+func (r Recipe) jsonAPI() (interface{}, []JSONAPIResource, error) {
+	served := []JSONAPIIdentifier{}
+	for _, occurrence := range r.Served {
+		served = append(served, JSONAPIIdentifier{
+			Type: ResourceMenus,
+			ID:   resourceDate(occurrence.ServeDate),
+			Meta: gin.H{"Serve_Date": occurrence.ServeDate, "meals": occurrence.Meals},
+		})
+	}
+	return JSONAPIResource{
+		Type:          ResourceRecipes,
+		ID:            r.RecipeNumber,
+		Attributes:    gin.H{"item": r.Item, "nutrients": r.Nutrients},
+		Relationships: map[string]JSONAPIRelationship{"menus": {Data: served}},
+		Links:         map[string]string{"self": "/recipes/" + url.PathEscape(r.RecipeNumber)},
+	}, nil, nil
+}
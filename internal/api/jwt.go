@@ -0,0 +1,548 @@
+package api
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"hudsgry-api/internal/store"
+	"log"
+	"math"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+	defaultJWTIssuer       = "hudsgry-api"
+	// minJWTSecretLength is the shortest HS256 secret accepted, in bytes
+	minJWTSecretLength = 32
+
+	tokenTypeAccess  = "access"
+	tokenTypeRefresh = "refresh"
+)
+
+// errInvalidToken is any JWT that doesn't verify, has expired or has been
+// revoked; callers aren't told which.
+var errInvalidToken = errors.New("invalid or expired token")
+
+// jwtKey signs or verifies tokens. secret is set for HS256 keys, private for
+// RS256 and ES256 keys.
+type jwtKey struct {
+	id      string
+	alg     string
+	secret  []byte
+	private crypto.Signer
+}
+
+// jwtAuth issues and checks JWT access and refresh tokens. Access tokens are
+// short-lived and carry everything needed to check them but a revocation
+// check; refresh tokens are recorded so they can be rotated and revoked.
+type jwtAuth struct {
+	// keys[0] signs new tokens; every key verifies, so a replaced key keeps
+	// working until the tokens it signed expire
+	keys       []jwtKey
+	issuer     string
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	// refreshTokens holds every refresh token issued, by ID, until it
+	// expires; revokedTokens the IDs of access tokens revoked before expiry
+	refreshTokens *mongo.Collection
+	revokedTokens *mongo.Collection
+}
+
+// jwtClaims are the registered claims every token carries, plus its type.
+type jwtClaims struct {
+	Issuer  string `json:"iss"`
+	Subject string `json:"sub"`
+	// IssuedAt has millisecond precision, as a token issued in the same
+	// second as its user's tokens were revoked may come before or after
+	IssuedAt  float64 `json:"iat"`
+	ExpiresAt int64   `json:"exp"`
+	ID        string  `json:"jti"`
+	Type      string  `json:"typ"`
+}
+
+// numericDate is t as a JWT NumericDate to the millisecond.
+func numericDate(t time.Time) float64 {
+	return float64(t.UnixMilli()) / 1000
+}
+
+// issuedBy reports whether the token was issued no later than t, to the
+// millisecond that t is stored with.
+func (claims jwtClaims) issuedBy(t time.Time) bool {
+	return int64(math.Round(claims.IssuedAt*1000)) <= t.UnixMilli()
+}
+
+type RefreshToken struct {
+	ID        string             `bson:"_id"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	IssuedAt  time.Time          `bson:"issued_at"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+	// RevokedAt is set once the token has been used or revoked
+	RevokedAt *time.Time `bson:"revoked_at,omitempty"`
+}
+
+type TokenPair struct {
+	AccessToken      string    `json:"access_token"`
+	TokenType        string    `json:"token_type"`
+	ExpiresIn        int       `json:"expires_in"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type RevokeRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// loadJWTAuth reads the signing keys and lifetimes, or returns nil if no key
+// is configured:
+//
+//   - JWT_SIGNING_KEYS is a comma-separated list of id=secret HS256 keys of
+//     at least 32 bytes each; the first signs
+//   - JWT_PRIVATE_KEY_FILE is a PEM RSA or P-256 key that signs instead,
+//     with JWT_KEY_ID as its key ID
+//   - JWT_ISSUER, JWT_ACCESS_TTL and JWT_REFRESH_TTL default to
+//     hudsgry-api, 15 minutes and 30 days
+func loadJWTAuth() (*jwtAuth, error) {
+	auth := &jwtAuth{issuer: defaultJWTIssuer, accessTTL: defaultAccessTokenTTL, refreshTTL: defaultRefreshTokenTTL}
+	if file := os.Getenv("JWT_PRIVATE_KEY_FILE"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse JWT_PRIVATE_KEY_FILE: %v", err)
+		}
+		id := os.Getenv("JWT_KEY_ID")
+		if id == "" {
+			id = "default"
+		}
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			auth.keys = append(auth.keys, jwtKey{id: id, alg: "RS256", private: k})
+		case *ecdsa.PrivateKey:
+			if k.Curve.Params().BitSize != 256 {
+				return nil, fmt.Errorf("JWT_PRIVATE_KEY_FILE must be an RSA or P-256 key")
+			}
+			auth.keys = append(auth.keys, jwtKey{id: id, alg: "ES256", private: k})
+		default:
+			return nil, fmt.Errorf("JWT_PRIVATE_KEY_FILE must be an RSA or P-256 key, got %T", key)
+		}
+	}
+	if v := os.Getenv("JWT_SIGNING_KEYS"); v != "" {
+		for _, entry := range strings.Split(v, ",") {
+			id, secret, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if !ok || id == "" {
+				return nil, fmt.Errorf("JWT_SIGNING_KEYS must be a list of id=secret, got %q", entry)
+			}
+			if len(secret) < minJWTSecretLength {
+				return nil, fmt.Errorf("JWT signing key %q must be at least %d bytes", id, minJWTSecretLength)
+			}
+			auth.keys = append(auth.keys, jwtKey{id: id, alg: "HS256", secret: []byte(secret)})
+		}
+	}
+	if len(auth.keys) == 0 {
+		return nil, nil
+	}
+	seen := make(map[string]bool)
+	for _, key := range auth.keys {
+		if seen[key.id] {
+			return nil, fmt.Errorf("JWT key ID %q is used twice", key.id)
+		}
+		seen[key.id] = true
+	}
+
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		auth.issuer = issuer
+	}
+	for name, ttl := range map[string]*time.Duration{"JWT_ACCESS_TTL": &auth.accessTTL, "JWT_REFRESH_TTL": &auth.refreshTTL} {
+		s := os.Getenv(name)
+		if s == "" {
+			continue
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("%s must be a positive duration, got %q", name, s)
+		}
+		*ttl = d
+	}
+	return auth, nil
+}
+
+// setupJWT turns on JWT authentication when a signing key is configured.
+func (s *Server) setupJWT() error {
+	auth, err := loadJWTAuth()
+	if err != nil || auth == nil {
+		return err
+	}
+	auth.refreshTokens = s.db.Collection("refresh_tokens")
+	auth.revokedTokens = s.db.Collection("revoked_tokens")
+	s.jwt = auth
+
+	s.ensureIndexes("JWT",
+		store.IndexSpec{Collection: "refresh_tokens", Model: mongo.IndexModel{
+			Keys: bson.D{{Key: "user_id", Value: 1}},
+		}},
+		store.IndexSpec{Collection: "refresh_tokens", Model: mongo.IndexModel{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		}},
+		store.IndexSpec{Collection: "revoked_tokens", Model: mongo.IndexModel{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		}},
+	)
+	return nil
+}
+
+func (s *Server) jwtRoutes(r gin.IRouter) {
+	r.POST("/auth/token", s.handleIssueTokens)
+	r.POST("/auth/refresh", s.handleRefreshTokens)
+	r.POST("/auth/revoke", s.handleRevokeToken)
+	r.DELETE("/me/tokens", s.requireUser, s.handleRevokeAllTokens)
+}
+
+// sign encodes claims as a token signed with the current key.
+func (a *jwtAuth) sign(claims jwtClaims) (string, error) {
+	key := a.keys[0]
+	var signer crypto.PrivateKey = key.private
+	if key.secret != nil {
+		signer = key.secret
+	}
+	return signJWT(map[string]string{"alg": key.alg, "typ": "JWT", "kid": key.id}, map[string]interface{}{
+		"iss": claims.Issuer,
+		"sub": claims.Subject,
+		"iat": claims.IssuedAt,
+		"exp": claims.ExpiresAt,
+		"jti": claims.ID,
+		"typ": claims.Type,
+	}, signer)
+}
+
+// parse verifies a token's signature, issuer, expiry and type, and returns
+// its claims.
+func (a *jwtAuth) parse(token string, tokenType string, now time.Time) (jwtClaims, error) {
+	var claims jwtClaims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, errInvalidToken
+	}
+	headerJson, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return claims, errInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if json.Unmarshal(headerJson, &header) != nil {
+		return claims, errInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, errInvalidToken
+	}
+	// The key decides the algorithm, never the token, so an RS256 public
+	// key can't be passed off as an HS256 secret
+	var key *jwtKey
+	for i := range a.keys {
+		if a.keys[i].id == header.Kid {
+			key = &a.keys[i]
+		}
+	}
+	if key == nil || key.alg != header.Alg || !key.verify(parts[0]+"."+parts[1], signature) {
+		return claims, errInvalidToken
+	}
+
+	claimsJson, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(claimsJson, &claims) != nil {
+		return claims, errInvalidToken
+	}
+	if claims.Issuer != a.issuer || claims.Type != tokenType || claims.ID == "" || now.Unix() >= claims.ExpiresAt {
+		return claims, errInvalidToken
+	}
+	return claims, nil
+}
+
+// verify checks a signature over signingInput.
+func (k *jwtKey) verify(signingInput string, signature []byte) bool {
+	if k.secret != nil {
+		return verifyJWS(k.alg, k.secret, signingInput, signature)
+	}
+	return verifyJWS(k.alg, k.private.Public(), signingInput, signature)
+}
+
+// verifyJWS checks an HS256, RS256 or ES256 signature over signingInput with
+// key: a shared secret, or an RSA or ECDSA public key.
+func verifyJWS(alg string, key interface{}, signingInput string, signature []byte) bool {
+	digest := sha256.Sum256([]byte(signingInput))
+	switch alg {
+	case "HS256":
+		secret, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signingInput))
+		return hmac.Equal(signature, mac.Sum(nil))
+	case "RS256":
+		public, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(public, crypto.SHA256, digest[:], signature) == nil
+	case "ES256":
+		public, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(public, digest[:], r, s)
+	}
+	return false
+}
+
+// looksLikeJWT tells JWTs apart from the opaque hex session tokens.
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// issueTokens signs a new access token and records a new refresh token for
+// user.
+func (s *Server) issueTokens(c *gin.Context, userID primitive.ObjectID) (TokenPair, error) {
+	now := s.clock.Now()
+	accessID, err := newSessionToken()
+	if err != nil {
+		return TokenPair{}, err
+	}
+	refreshID, err := newSessionToken()
+	if err != nil {
+		return TokenPair{}, err
+	}
+	access, err := s.jwt.sign(jwtClaims{
+		Issuer:    s.jwt.issuer,
+		Subject:   userID.Hex(),
+		IssuedAt:  numericDate(now),
+		ExpiresAt: now.Add(s.jwt.accessTTL).Unix(),
+		ID:        accessID,
+		Type:      tokenTypeAccess,
+	})
+	if err != nil {
+		return TokenPair{}, err
+	}
+	refreshExpiresAt := now.Add(s.jwt.refreshTTL)
+	refresh, err := s.jwt.sign(jwtClaims{
+		Issuer:    s.jwt.issuer,
+		Subject:   userID.Hex(),
+		IssuedAt:  numericDate(now),
+		ExpiresAt: refreshExpiresAt.Unix(),
+		ID:        refreshID,
+		Type:      tokenTypeRefresh,
+	})
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	_, err = s.jwt.refreshTokens.InsertOne(ctx, RefreshToken{ID: refreshID, UserID: userID, IssuedAt: now, ExpiresAt: refreshExpiresAt})
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return TokenPair{
+		AccessToken:      access,
+		TokenType:        "Bearer",
+		ExpiresIn:        int(s.jwt.accessTTL.Seconds()),
+		RefreshToken:     refresh,
+		RefreshExpiresAt: refreshExpiresAt,
+	}, nil
+}
+
+// authenticateJWT resolves an access token to its user, rejecting it if it
+// was revoked, alone or with every token the user held.
+func (s *Server) authenticateJWT(c *gin.Context, token string) (User, error) {
+	var user User
+	claims, err := s.jwt.parse(token, tokenTypeAccess, s.clock.Now())
+	if err != nil {
+		return user, err
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		return user, errInvalidToken
+	}
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	err = s.jwt.revokedTokens.FindOne(ctx, bson.M{"_id": claims.ID}).Err()
+	if err == nil {
+		return user, errInvalidToken
+	}
+	if err != mongo.ErrNoDocuments {
+		return user, err
+	}
+	err = s.users.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return user, errInvalidToken
+	}
+	if err != nil {
+		return user, err
+	}
+	if user.TokensRevokedAt != nil && claims.issuedBy(*user.TokensRevokedAt) {
+		return user, errInvalidToken
+	}
+	c.Set("token_id", claims.ID)
+	c.Set("token_expires_at", time.Unix(claims.ExpiresAt, 0))
+	return user, nil
+}
+
+func (s *Server) handleIssueTokens(c *gin.Context) {
+	var creds Credentials
+	if err := c.ShouldBindJSON(&creds); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "email and password are required")
+		return
+	}
+	user, err := s.checkCredentials(c, creds)
+	if err == errInvalidCredentials {
+		respondError(c, http.StatusUnauthorized, CodeUnauthorized, "invalid email or password")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to look up user: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to log in")
+		return
+	}
+	tokens, err := s.issueTokens(c, user.ID)
+	if err != nil {
+		log.Printf("Failed to issue tokens: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to log in")
+		return
+	}
+	respond(c, http.StatusOK, tokens, ResponseMeta{})
+}
+
+// handleRefreshTokens trades a refresh token for a new pair. Each refresh
+// token works once; one presented again was likely stolen, so every token
+// the user holds is revoked.
+func (s *Server) handleRefreshTokens(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "refresh_token is required")
+		return
+	}
+	now := s.clock.Now()
+	claims, err := s.jwt.parse(req.RefreshToken, tokenTypeRefresh, now)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, CodeUnauthorized, "invalid or expired refresh token")
+		return
+	}
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	var previous RefreshToken
+	err = s.jwt.refreshTokens.FindOneAndUpdate(ctx,
+		bson.M{"_id": claims.ID},
+		bson.M{"$set": bson.M{"revoked_at": now}},
+	).Decode(&previous)
+	if err == mongo.ErrNoDocuments {
+		respondError(c, http.StatusUnauthorized, CodeUnauthorized, "invalid or expired refresh token")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to look up refresh token: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to refresh tokens")
+		return
+	}
+	if previous.RevokedAt != nil {
+		log.Printf("Refresh token for user %s was reused; revoking all of their tokens\n", previous.UserID.Hex())
+		if err := s.revokeAllTokens(ctx, previous.UserID); err != nil {
+			log.Printf("Failed to revoke tokens: %v\n", err)
+		}
+		respondError(c, http.StatusUnauthorized, CodeUnauthorized, "invalid or expired refresh token")
+		return
+	}
+
+	tokens, err := s.issueTokens(c, previous.UserID)
+	if err != nil {
+		log.Printf("Failed to issue tokens: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to refresh tokens")
+		return
+	}
+	respond(c, http.StatusOK, tokens, ResponseMeta{})
+}
+
+// handleRevokeToken revokes an access or refresh token. As in RFC 7009, a
+// token that is already invalid isn't an error.
+func (s *Server) handleRevokeToken(c *gin.Context) {
+	var req RevokeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "token is required")
+		return
+	}
+	now := s.clock.Now()
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	var err error
+	if claims, parseErr := s.jwt.parse(req.Token, tokenTypeRefresh, now); parseErr == nil {
+		_, err = s.jwt.refreshTokens.UpdateOne(ctx, bson.M{"_id": claims.ID, "revoked_at": nil}, bson.M{"$set": bson.M{"revoked_at": now}})
+	} else if claims, parseErr := s.jwt.parse(req.Token, tokenTypeAccess, now); parseErr == nil {
+		err = s.revokeAccessToken(ctx, claims.ID, time.Unix(claims.ExpiresAt, 0))
+	}
+	if err != nil {
+		log.Printf("Failed to revoke token: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to revoke token")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// handleRevokeAllTokens signs the user out everywhere they used a JWT.
+func (s *Server) handleRevokeAllTokens(c *gin.Context) {
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	if err := s.revokeAllTokens(ctx, currentUser(c).ID); err != nil {
+		log.Printf("Failed to revoke tokens: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to revoke tokens")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// revokeAccessToken records an access token as revoked until it would have
+// expired anyway.
+func (s *Server) revokeAccessToken(ctx context.Context, id string, expiresAt time.Time) error {
+	_, err := s.jwt.revokedTokens.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"expires_at": expiresAt}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// revokeAllTokens invalidates every access token issued to the user so far
+// and every refresh token they hold.
+func (s *Server) revokeAllTokens(ctx context.Context, userID primitive.ObjectID) error {
+	now := s.clock.Now()
+	if _, err := s.users.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"tokens_revoked_at": now}}); err != nil {
+		return err
+	}
+	_, err := s.jwt.refreshTokens.UpdateMany(ctx, bson.M{"user_id": userID, "revoked_at": nil}, bson.M{"$set": bson.M{"revoked_at": now}})
+	return err
+}
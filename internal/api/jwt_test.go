@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testJWTAuth signs with "current" and still accepts tokens signed with
+// "previous", as after a key rotation.
+func testJWTAuth(t *testing.T) *jwtAuth {
+	t.Helper()
+	t.Setenv("JWT_PRIVATE_KEY_FILE", "")
+	t.Setenv("JWT_SIGNING_KEYS", "current=0123456789abcdef0123456789abcdef,previous=fedcba9876543210fedcba9876543210")
+	auth, err := loadJWTAuth()
+	if err != nil || auth == nil {
+		t.Fatalf("failed to load JWT keys: %v", err)
+	}
+	return auth
+}
+
+func testClaims(auth *jwtAuth, issued time.Time, tokenType string) jwtClaims {
+	return jwtClaims{
+		Issuer:    auth.issuer,
+		Subject:   "652f1c2e8b3e4a0001a1b2c3",
+		IssuedAt:  numericDate(issued),
+		ExpiresAt: issued.Add(auth.accessTTL).Unix(),
+		ID:        "a1b2c3",
+		Type:      tokenType,
+	}
+}
+
+func TestJWTIssueAndVerify(t *testing.T) {
+	auth := testJWTAuth(t)
+	now := time.Date(2026, time.October, 16, 12, 0, 0, 0, time.UTC)
+	token, err := auth.sign(testClaims(auth, now, tokenTypeAccess))
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := auth.parse(token, tokenTypeAccess, now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("a fresh access token was rejected: %v", err)
+	}
+	if claims.Subject != "652f1c2e8b3e4a0001a1b2c3" || claims.ID != "a1b2c3" {
+		t.Errorf("got claims %+v", claims)
+	}
+
+	// Tokens signed with a key that has since been replaced still verify
+	rotated := *auth
+	rotated.keys = []jwtKey{auth.keys[1], auth.keys[0]}
+	old, err := rotated.sign(testClaims(auth, now, tokenTypeAccess))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.parse(old, tokenTypeAccess, now); err != nil {
+		t.Errorf("a token signed with the previous key was rejected: %v", err)
+	}
+
+	parts := strings.Split(token, ".")
+	forged := testClaims(auth, now, tokenTypeAccess)
+	forged.Subject = "652f1c2e8b3e4a0001ffffff"
+	forgedToken, err := auth.sign(forged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, bad := range map[string]string{
+		"tampered claims": parts[0] + "." + strings.Split(forgedToken, ".")[1] + "." + parts[2],
+		"no signature":    parts[0] + "." + parts[1] + ".",
+		"alg none":        base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"current"}`)) + "." + parts[1] + ".",
+		"unknown key":     base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","kid":"other"}`)) + "." + parts[1] + "." + parts[2],
+		"not a JWT":       "0123456789abcdef",
+	} {
+		if _, err := auth.parse(bad, tokenTypeAccess, now); err != errInvalidToken {
+			t.Errorf("%s: got %v, want errInvalidToken", name, err)
+		}
+	}
+	if _, err := auth.parse(token, tokenTypeRefresh, now); err != errInvalidToken {
+		t.Errorf("an access token was accepted as a refresh token")
+	}
+	if _, err := auth.parse(token, tokenTypeAccess, now.Add(auth.accessTTL)); err != errInvalidToken {
+		t.Errorf("an expired access token was accepted")
+	}
+}
+
+func TestJWTRevocation(t *testing.T) {
+	auth := testJWTAuth(t)
+	revokedAt := time.Date(2026, time.October, 16, 12, 0, 0, 400*int(time.Millisecond), time.UTC)
+	for _, test := range []struct {
+		issued  time.Time
+		revoked bool
+	}{
+		{revokedAt.Add(-time.Hour), true},
+		// Earlier and later in the same second as the revocation
+		{revokedAt.Add(-300 * time.Millisecond), true},
+		{revokedAt, true},
+		{revokedAt.Add(300 * time.Millisecond), false},
+		{revokedAt.Add(time.Minute), false},
+	} {
+		token, err := auth.sign(testClaims(auth, test.issued, tokenTypeAccess))
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims, err := auth.parse(token, tokenTypeAccess, test.issued)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revoked := claims.issuedBy(revokedAt); revoked != test.revoked {
+			t.Errorf("token issued at %s: revoked %t, want %t", test.issued.Format(time.StampMilli), revoked, test.revoked)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"html"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	labelWidth   = 280
+	labelPadding = 8
+)
+
+// labelRow is one nutrient line on the label. Rows without a daily value
+// have no percentage, as on FDA labels for sugars and protein.
+type labelRow struct {
+	name       string
+	amount     *huds.Amount
+	dailyValue float64
+	indented   bool
+}
+
+// labelSVG lays out an FDA-style nutrition facts panel. Nutrients HUDS didn't
+// send are left off rather than shown as zero.
+func labelSVG(item huds.CondensedMenuItem, facts *huds.NutritionFacts) string {
+	rows := []labelRow{
+		{name: "Total Fat", amount: facts.TotalFat, dailyValue: dailyValues.TotalFat},
+		{name: "Saturated Fat", amount: facts.SatFat, dailyValue: dailyValues.SatFat, indented: true},
+		{name: "Trans Fat", amount: facts.TransFat, indented: true},
+		{name: "Cholesterol", amount: facts.Cholesterol, dailyValue: dailyValues.Cholesterol},
+		{name: "Sodium", amount: facts.Sodium, dailyValue: dailyValues.Sodium},
+		{name: "Total Carbohydrate", amount: facts.TotalCarb, dailyValue: dailyValues.TotalCarb},
+		{name: "Dietary Fiber", amount: facts.DietaryFiber, dailyValue: dailyValues.DietaryFiber, indented: true},
+		{name: "Total Sugars", amount: facts.Sugars, indented: true},
+		{name: "Protein", amount: facts.Protein},
+	}
+
+	var body strings.Builder
+	y := labelPadding
+	right := labelWidth - labelPadding
+	text := func(x int, size int, weight string, anchor string, s string) {
+		fmt.Fprintf(&body, `<text x="%d" y="%d" font-size="%d" font-weight="%s" text-anchor="%s">%s</text>`+"\n",
+			x, y, size, weight, anchor, html.EscapeString(s))
+	}
+	bar := func(height int) {
+		fmt.Fprintf(&body, `<rect x="%d" y="%d" width="%d" height="%d"/>`+"\n", labelPadding, y, labelWidth-2*labelPadding, height)
+		y += height
+	}
+
+	y += 28
+	text(labelPadding, 30, "900", "start", "Nutrition Facts")
+	y += 6
+	bar(1)
+	if item.FoodName != "" {
+		y += 16
+		text(labelPadding, 13, "normal", "start", item.FoodName)
+	}
+	if item.ServingSize != "" {
+		y += 18
+		text(labelPadding, 14, "bold", "start", "Serving size")
+		text(right, 14, "bold", "end", item.ServingSize)
+	}
+	y += 6
+	bar(10)
+
+	y += 14
+	text(labelPadding, 11, "bold", "start", "Amount per serving")
+	calories := "–"
+	if facts.Calories != nil {
+		calories = formatLabelAmount(facts.Calories.Value)
+	}
+	y += 26
+	text(labelPadding, 24, "900", "start", "Calories")
+	text(right, 30, "900", "end", calories)
+	y += 6
+	bar(5)
+
+	y += 14
+	text(right, 11, "bold", "end", "% Daily Value*")
+	y += 4
+	for _, row := range rows {
+		if row.amount == nil {
+			continue
+		}
+		bar(1)
+		y += 16
+		x, weight := labelPadding, "bold"
+		if row.indented {
+			x, weight = labelPadding+14, "normal"
+		}
+		fmt.Fprintf(&body, `<text x="%d" y="%d" font-size="13"><tspan font-weight="%s">%s</tspan> %s%s</text>`+"\n",
+			x, y, weight, html.EscapeString(row.name), formatLabelAmount(row.amount.Value), html.EscapeString(row.amount.Unit))
+		if row.dailyValue > 0 {
+			text(right, 13, "bold", "end", fmt.Sprintf("%d%%", int(math.Round(row.amount.Value/row.dailyValue*100))))
+		}
+		y += 5
+	}
+	bar(10)
+
+	for _, line := range []string{
+		"* The % Daily Value tells you how much a nutrient in",
+		"a serving of food contributes to a daily diet. 2,000",
+		"calories a day is used for general nutrition advice.",
+	} {
+		y += 12
+		text(labelPadding, 10, "normal", "start", line)
+	}
+	y += labelPadding
+
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="Helvetica, Arial, sans-serif">
+<rect width="100%%" height="100%%" fill="white" stroke="black" stroke-width="2"/>
+%s</svg>
+`, labelWidth, y, labelWidth, y, body.String())
+}
+
+func formatLabelAmount(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// handleItemLabel renders a nutrition facts label for an item as SVG, from
+// the most recent day it was served, for apps and signage to embed.
+func (s *Server) handleItemLabel(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id < 1 {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid item id")
+		return
+	}
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	item, _, _, err := s.store.ItemByID(ctx, id)
+	if err == store.ErrMenuNotFound {
+		respondError(c, http.StatusNotFound, CodeNotFound, "item not found")
+		return
+	}
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	facts := item.Nutrition
+	if facts == nil {
+		facts = huds.ParseNutrition(item)
+	}
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.Data(http.StatusOK, "image/svg+xml; charset=utf-8", []byte(labelSVG(item, facts)))
+}
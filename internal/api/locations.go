@@ -0,0 +1,61 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// handleLocationMenu serves /huds-data?location=, in the same shape as the
+// house default menu. The location matches by name prefix, so "Annenberg" and
+// "currier" both work.
+func (s *Server) handleLocationMenu(c *gin.Context, serveDate string, location string, dateFormat string) {
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	locations, err := s.store.GetLocations(ctx, serveDate)
+	if err == store.ErrMenuNotFound {
+		respondError(c, http.StatusNotFound, CodeNotFound, "no location menus for this date")
+		return
+	}
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	wanted := huds.LocationKey(location)
+	var matches []string
+	for key := range locations {
+		if key == wanted {
+			matches = []string{key}
+			break
+		}
+		if strings.HasPrefix(key, wanted) {
+			matches = append(matches, key)
+		}
+	}
+	if len(matches) != 1 {
+		var names []string
+		for _, menu := range locations {
+			names = append(names, menu.Name)
+		}
+		sort.Strings(names)
+		status, code, message := http.StatusNotFound, CodeNotFound, "unknown location"
+		if len(matches) > 1 {
+			status, code, message = http.StatusBadRequest, CodeInvalidRequest, "ambiguous location"
+		}
+		respondErrorDetails(c, status, code, message, gin.H{"locations": names})
+		return
+	}
+
+	menu := locations[matches[0]]
+	respond(c, http.StatusOK, DatedMenu{withItemOptions(c, huds.CondensedMenu{
+		ServeDate: serveDate,
+		Breakfast: menu.Breakfast,
+		Lunch:     menu.Lunch,
+		Dinner:    menu.Dinner,
+		Extra:     menu.Extra,
+	}), dateFormat, itemFields(c), itemGroupBy(c), false}, ResponseMeta{ServeDate: formatServeDate(serveDate, dateFormat), Source: SourceDB})
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"hudsgry-api/internal/store"
+	"log"
+	"os"
+	"time"
+)
+
+// jobLockTTL is how long a claim on one firing of a job is kept. It only has
+// to outlast the clock skew between replicas.
+const jobLockTTL = time.Hour
+
+// instanceName identifies this replica as the owner of the job locks it takes.
+func instanceName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// exclusive wraps a scheduled job so that, when several replicas share a store
+// that supports locks, only the first to claim each firing runs it. Firings
+// are told apart by the minute they were scheduled for. If the lock can't be
+// checked the job runs anyway, since a duplicate run is better than none.
+func (s *Server) exclusive(job string, run func()) func() {
+	locker, ok := s.store.(store.JobLocker)
+	if !ok {
+		return run
+	}
+	return func() {
+		firing := job + "@" + s.clock.Now().UTC().Truncate(time.Minute).Format(time.RFC3339)
+		ctx, cancel := s.dbContext(context.Background())
+		acquired, err := locker.AcquireLock(ctx, firing, s.instance, jobLockTTL)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to acquire lock for %s, running it anyway: %v\n", firing, err)
+		} else if !acquired {
+			log.Printf("Skipping %s, another instance is running it\n", firing)
+			return
+		}
+		run()
+	}
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultMaintenanceRetryAfter is what Retry-After says when maintenance
+// mode was turned on without an estimate.
+const defaultMaintenanceRetryAfter = 5 * time.Minute
+
+// errMaintenance is returned by refreshes attempted in maintenance mode.
+var errMaintenance = errors.New("the service is in read-only maintenance mode")
+
+// maintenanceMode is the read-only switch. While it is on, reads are served
+// from the cache and the database as usual, but refreshes and anything that
+// writes on a user's behalf are turned away, e.g. while Mongo is migrated or
+// the upstream API key is rotated.
+type maintenanceMode struct {
+	sync.RWMutex
+	enabled    bool
+	since      time.Time
+	retryAfter time.Duration
+	message    string
+}
+
+type MaintenanceStatus struct {
+	Enabled bool       `json:"enabled"`
+	Since   *time.Time `json:"since,omitempty"`
+	// RetryAfterSeconds is what rejected writes are told to wait
+	RetryAfterSeconds int    `json:"retry_after_seconds,omitempty"`
+	Message           string `json:"message,omitempty"`
+}
+
+// readOnlyRoutes are the routes that take a body but don't write, so they
+// are served in maintenance mode. The bots' webhooks answer menu questions;
+// their subscription commands check for maintenance themselves.
+var readOnlyRoutes = map[string]bool{
+	"POST /now":              true,
+	"POST /alexa":            true,
+	"POST /plan/week":        true,
+	"POST /calculate":        true,
+	"POST /telegram/webhook": true,
+	"POST /sms/inbound":      true,
+	"POST /admin/reload":     true,
+	"PUT /admin/maintenance": true,
+}
+
+var versionPrefix = regexp.MustCompile(`^/v\d+`)
+
+// loadMaintenance starts in maintenance mode when MAINTENANCE_MODE is true,
+// so a replica restarted mid-migration doesn't start writing.
+func (s *Server) loadMaintenance() {
+	if os.Getenv("MAINTENANCE_MODE") == "true" {
+		s.setMaintenance(true, 0, os.Getenv("MAINTENANCE_MESSAGE"))
+	}
+}
+
+func (s *Server) setMaintenance(enabled bool, retryAfter time.Duration, message string) {
+	s.maintenance.Lock()
+	defer s.maintenance.Unlock()
+	if retryAfter <= 0 {
+		retryAfter = defaultMaintenanceRetryAfter
+	}
+	if enabled && !s.maintenance.enabled {
+		s.maintenance.since = time.Now()
+	}
+	s.maintenance.enabled = enabled
+	s.maintenance.retryAfter = retryAfter
+	s.maintenance.message = message
+	if enabled {
+		log.Printf("Maintenance mode on, rejecting writes for about %s\n", retryAfter)
+	} else {
+		log.Println("Maintenance mode off")
+	}
+}
+
+func (s *Server) inMaintenance() bool {
+	s.maintenance.RLock()
+	defer s.maintenance.RUnlock()
+	return s.maintenance.enabled
+}
+
+func (s *Server) maintenanceStatus() MaintenanceStatus {
+	s.maintenance.RLock()
+	defer s.maintenance.RUnlock()
+	if !s.maintenance.enabled {
+		return MaintenanceStatus{}
+	}
+	since := s.maintenance.since
+	return MaintenanceStatus{
+		Enabled:           true,
+		Since:             &since,
+		RetryAfterSeconds: int(s.maintenance.retryAfter.Seconds()),
+		Message:           s.maintenance.message,
+	}
+}
+
+// rejectWritesInMaintenance turns away every request that may write while
+// maintenance mode is on, with 503 and a Retry-After.
+func (s *Server) rejectWritesInMaintenance(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		c.Next()
+		return
+	}
+	if readOnlyRoutes[c.Request.Method+" "+versionPrefix.ReplaceAllString(c.FullPath(), "")] {
+		c.Next()
+		return
+	}
+	status := s.maintenanceStatus()
+	if !status.Enabled {
+		c.Next()
+		return
+	}
+	message := status.Message
+	if message == "" {
+		message = "the service is read-only for maintenance; try again later"
+	}
+	c.Header("Retry-After", strconv.Itoa(status.RetryAfterSeconds))
+	respondErrorDetails(c, http.StatusServiceUnavailable, CodeMaintenance, message, gin.H{"retry_after_seconds": status.RetryAfterSeconds})
+}
+
+// maintenanceReply is what the bots say to a subscription command in
+// maintenance mode.
+func (s *Server) maintenanceReply() string {
+	status := s.maintenanceStatus()
+	return fmt.Sprintf("Subscriptions are paused for maintenance. Try again in %d minutes.", (status.RetryAfterSeconds+59)/60)
+}
+
+func (s *Server) handleGetMaintenance(c *gin.Context) {
+	respond(c, http.StatusOK, s.maintenanceStatus(), ResponseMeta{})
+}
+
+// handleSetMaintenance turns maintenance mode on or off.
+func (s *Server) handleSetMaintenance(c *gin.Context) {
+	var req struct {
+		Enabled           *bool  `json:"enabled"`
+		RetryAfterSeconds int    `json:"retry_after_seconds"`
+		Message           string `json:"message"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Enabled == nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "enabled is required")
+		return
+	}
+	if req.RetryAfterSeconds < 0 {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "retry_after_seconds can't be negative")
+		return
+	}
+	s.setMaintenance(*req.Enabled, time.Duration(req.RetryAfterSeconds)*time.Second, req.Message)
+	respond(c, http.StatusOK, s.maintenanceStatus(), ResponseMeta{})
+}
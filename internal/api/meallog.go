@@ -0,0 +1,250 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	maxServings        = 20
+	defaultHistoryDays = 7
+	maxHistoryDays     = 366
+)
+
+// MealLogEntry records that a user ate some servings of a menu item. The
+// item's nutrition is copied in when logged, so history stays accurate even
+// if HUDS later edits the recipe.
+type MealLogEntry struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID     primitive.ObjectID `json:"-" bson:"user_id"`
+	ServeDate  string             `json:"Serve_Date" bson:"serve_date"`
+	Date       time.Time          `json:"-" bson:"date"`
+	Meal       string             `json:"meal" bson:"meal"`
+	FoodName   string             `json:"Food_Name" bson:"food_name"`
+	Servings   float64            `json:"servings" bson:"servings"`
+	PerServing Macros             `json:"per_serving" bson:"per_serving"`
+	Totals     Macros             `json:"totals" bson:"totals"`
+	// Nutrients are the full nutrition facts of the servings eaten
+	Nutrients Nutrients `json:"nutrients" bson:"nutrients"`
+	LoggedAt  time.Time `json:"logged_at" bson:"logged_at"`
+}
+
+type MealLogRequest struct {
+	ServeDate string  `json:"serve_date" binding:"required"`
+	Meal      string  `json:"meal" binding:"required"`
+	FoodName  string  `json:"food_name" binding:"required"`
+	Servings  float64 `json:"servings"`
+}
+
+type NutritionPeriod struct {
+	Period  string `json:"period" bson:"_id"`
+	Entries int    `json:"entries" bson:"entries"`
+	Totals  Macros `json:"totals" bson:"totals"`
+}
+
+func (s *Server) setupMealLog() {
+	s.mealLogs = s.db.Collection("meal_logs")
+	s.ensureIndexes("meal log", store.IndexSpec{Collection: "meal_logs", Model: mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "date", Value: 1}},
+	}})
+}
+
+func (s *Server) mealLogRoutes(r gin.IRouter) {
+	me := r.Group("/me", s.requireUser)
+	me.POST("/log", s.handleLogMeal)
+	me.GET("/log", s.handleMealHistory)
+	me.DELETE("/log/:id", s.handleDeleteLogEntry)
+	me.GET("/log/export", s.handleExportMealLog)
+	me.GET("/log/export.csv", s.handleExportMealLog)
+}
+
+func (s *Server) handleLogMeal(c *gin.Context) {
+	var req MealLogRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "serve_date, meal and food_name are required")
+		return
+	}
+	if req.Servings == 0 {
+		req.Servings = 1
+	}
+	if req.Servings < 0 || req.Servings > maxServings {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "servings must be between 0 and 20")
+		return
+	}
+	req.Meal = strings.ToLower(req.Meal)
+	if req.Meal != "breakfast" && req.Meal != "lunch" && req.Meal != "dinner" {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "meal must be breakfast, lunch or dinner")
+		return
+	}
+	date, err := s.parseDate(req.ServeDate)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeDateInvalid, "serve_date must be MM/DD/YYYY, YYYY-MM-DD, today or tomorrow")
+		return
+	}
+	req.ServeDate = date.Format(huds.ServeDateLayout)
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	menu, err := s.readMenu(ctx, req.ServeDate)
+	if err == store.ErrMenuNotFound {
+		respondError(c, http.StatusNotFound, CodeNotFound, "no menu for this date")
+		return
+	}
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	var item *huds.CondensedMenuItem
+	for _, candidate := range huds.MealItems(menu, req.Meal) {
+		if strings.EqualFold(candidate.FoodName, req.FoodName) {
+			candidate := candidate
+			item = &candidate
+			break
+		}
+	}
+	if item == nil {
+		respondError(c, http.StatusNotFound, CodeNotFound, "that item wasn't served at this meal")
+		return
+	}
+
+	perServing := itemMacros(*item)
+	entry := MealLogEntry{
+		UserID:     currentUser(c).ID,
+		ServeDate:  req.ServeDate,
+		Date:       date,
+		Meal:       req.Meal,
+		FoodName:   item.FoodName,
+		Servings:   req.Servings,
+		PerServing: perServing,
+		Totals:     perServing.Scale(req.Servings),
+		Nutrients:  itemNutrients(*item).Scale(req.Servings),
+		LoggedAt:   s.clock.Now(),
+	}
+	result, err := s.mealLogs.InsertOne(ctx, entry)
+	if err != nil {
+		log.Printf("Failed to log meal: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to log meal")
+		return
+	}
+	entry.ID = result.InsertedID.(primitive.ObjectID)
+
+	respond(c, http.StatusCreated, entry, ResponseMeta{})
+}
+
+// handleMealHistory lists entries between ?start= and ?end= (default the last
+// week) with nutrition totals per ?group_by=day or week.
+func (s *Server) handleMealHistory(c *gin.Context) {
+	start, ok := s.queryDate(c, "start")
+	if !ok {
+		return
+	}
+	end, ok := s.queryDate(c, "end")
+	if !ok {
+		return
+	}
+	// Whole days, regardless of the clock's time of day
+	today, _ := time.Parse(huds.ServeDateLayout, s.today())
+	if end.IsZero() {
+		end = today
+	}
+	if start.IsZero() {
+		start = today.AddDate(0, 0, -defaultHistoryDays+1)
+	}
+	if end.Before(start) || end.Sub(start) > maxHistoryDays*24*time.Hour {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "end must be after start and within a year of it")
+		return
+	}
+
+	groupFormat := "%Y-%m-%d"
+	switch c.DefaultQuery("group_by", "day") {
+	case "day":
+	case "week":
+		groupFormat = "%G-W%V"
+	default:
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "group_by must be day or week")
+		return
+	}
+
+	match := bson.M{"user_id": currentUser(c).ID, "date": bson.M{"$gte": start, "$lte": end}}
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	cursor, err := s.mealLogs.Find(ctx, match, options.Find().SetSort(bson.D{{Key: "date", Value: 1}, {Key: "logged_at", Value: 1}}))
+	if err != nil {
+		log.Printf("Failed to load meal log: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load meal log")
+		return
+	}
+	entries := []MealLogEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		log.Printf("Failed to decode meal log: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load meal log")
+		return
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: bson.M{"$dateToString": bson.M{"format": groupFormat, "date": "$date"}}},
+			{Key: "entries", Value: bson.M{"$sum": 1}},
+			{Key: "calories", Value: bson.M{"$sum": "$totals.calories"}},
+			{Key: "protein", Value: bson.M{"$sum": "$totals.protein"}},
+			{Key: "carbs", Value: bson.M{"$sum": "$totals.carbs"}},
+			{Key: "fat", Value: bson.M{"$sum": "$totals.fat"}},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"entries": 1,
+			"totals":  bson.M{"calories": "$calories", "protein": "$protein", "carbs": "$carbs", "fat": "$fat"},
+		}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}
+	cursor, err = s.mealLogs.Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Printf("Failed to aggregate meal log: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load meal log")
+		return
+	}
+	periods := []NutritionPeriod{}
+	if err := cursor.All(ctx, &periods); err != nil {
+		log.Printf("Failed to decode meal log totals: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load meal log")
+		return
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"start":   start.Format(huds.ServeDateLayout),
+		"end":     end.Format(huds.ServeDateLayout),
+		"entries": entries,
+		"totals":  periods,
+	}, ResponseMeta{})
+}
+
+func (s *Server) handleDeleteLogEntry(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid entry id")
+		return
+	}
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	result, err := s.mealLogs.DeleteOne(ctx, bson.M{"_id": id, "user_id": currentUser(c).ID})
+	if err != nil {
+		log.Printf("Failed to delete meal log entry: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to delete entry")
+		return
+	}
+	if result.DeletedCount == 0 {
+		respondError(c, http.StatusNotFound, CodeNotFound, "entry not found")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"log"
+	"time"
+)
+
+// menuByDate reads a day's menu from memory if the day is around today, or
+// else from the store, falling back to the local file cache, if there is
+// one, when the store fails rather than has no menu.
+func (s *Server) menuByDate(ctx context.Context, date string) (huds.CondensedMenu, error) {
+	if menu, ok := s.warmMenu(date); ok {
+		s.recordWarmLookup(true)
+		return menu, nil
+	}
+	s.recordWarmLookup(false)
+	menu, err := s.readMenu(ctx, date)
+	if err == nil || err == store.ErrMenuNotFound || s.menuCache == nil {
+		return menu, err
+	}
+	cached, cacheErr := s.menuCache.GetByDate(date)
+	s.recordFileCacheLookup(cacheErr == nil)
+	if cacheErr != nil {
+		return menu, err
+	}
+	log.Printf("Failed to read %s from storage, serving the local copy saved %s: %v\n", date, s.menuCache.SavedAt().Format(time.RFC3339), err)
+	return cached, nil
+}
+
+// readMenu reads a day's menu from the store. Concurrent reads of the same
+// day share one query, so a rush on today's menu makes one round trip rather
+// than hundreds; each caller still gives up when its own context ends.
+func (s *Server) readMenu(ctx context.Context, date string) (huds.CondensedMenu, error) {
+	result := s.reads.DoChan(date, func() (interface{}, error) {
+		// The read isn't tied to the request that started it, as others may
+		// be waiting on it
+		ctx, cancel := s.dbContext(context.Background())
+		defer cancel()
+		menu, err := s.store.GetByDate(ctx, date)
+		if err == nil {
+			s.compareWithShadow(menu)
+		}
+		return menu, err
+	})
+	select {
+	case r := <-result:
+		menu, _ := r.Val.(huds.CondensedMenu)
+		return menu, r.Err
+	case <-ctx.Done():
+		return huds.CondensedMenu{}, ctx.Err()
+	}
+}
+
+// menuRange is GetRange with the same fallback as menuByDate. The cache only
+// has the latest days, so the fallback may return fewer menus.
+func (s *Server) menuRange(ctx context.Context, start string, end string) ([]huds.CondensedMenu, error) {
+	menus, err := s.store.GetRange(ctx, start, end)
+	if err == nil || s.menuCache == nil {
+		return menus, err
+	}
+	cached, cacheErr := s.menuCache.GetRange(start, end)
+	if cacheErr != nil || len(cached) == 0 {
+		return menus, err
+	}
+	log.Printf("Failed to read %s to %s from storage, serving the local copies saved %s: %v\n", start, end, s.menuCache.SavedAt().Format(time.RFC3339), err)
+	return cached, nil
+}
+
+// saveMenuCache writes the latest stored days to the local file cache. It
+// runs after every refresh.
+func (s *Server) saveMenuCache(ctx context.Context, _ map[string]map[int][]huds.CondensedMenuItem) {
+	_, latest, err := s.store.EarliestLatest(ctx)
+	if err != nil {
+		log.Printf("Failed to update the local menu cache: %v\n", err)
+		return
+	}
+	if latest == "" {
+		return
+	}
+	end, err := time.Parse(huds.ServeDateLayout, latest)
+	if err != nil {
+		log.Printf("Failed to update the local menu cache: %v\n", err)
+		return
+	}
+	start := end.AddDate(0, 0, 1-s.menuCache.Days())
+	menus, err := s.store.GetRange(ctx, start.Format(huds.ServeDateLayout), latest)
+	if err != nil {
+		log.Printf("Failed to update the local menu cache: %v\n", err)
+		return
+	}
+	if err := s.menuCache.Save(menus); err != nil {
+		log.Printf("Failed to update the local menu cache: %v\n", err)
+	}
+}
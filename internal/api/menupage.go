@@ -0,0 +1,192 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"html/template"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var menuTemplate = template.Must(template.ParseFS(webFiles, "web/menu.html"))
+
+type menuPageBadge struct {
+	Code  string
+	Name  string
+	Color string
+}
+
+type menuPageItem struct {
+	Name   string
+	Label  string
+	Badges []menuPageBadge
+}
+
+type menuPageCategory struct {
+	Name  string
+	Items []menuPageItem
+}
+
+type menuPageMeal struct {
+	Title      string
+	Categories []menuPageCategory
+}
+
+// MenuPageLinks are where a menu page's navigation points. Empty links are
+// left off the page.
+type MenuPageLinks struct {
+	Previous string
+	Next     string
+	Print    string
+	// Label gives the link for an item's nutrition label, if any
+	Label func(item huds.CondensedMenuItem) string
+}
+
+// menuPage is what web/menu.html renders. Message explains an empty page.
+type menuPage struct {
+	Date    string
+	Links   MenuPageLinks
+	Message string
+	Meals   []menuPageMeal
+	Legend  []menuPageBadge
+}
+
+// mealTitle capitalizes a meal's response key for display.
+func mealTitle(meal string) string {
+	if meal == "" {
+		return meal
+	}
+	return strings.ToUpper(meal[:1]) + meal[1:]
+}
+
+// groupByCategory splits items by menu category, keeping the order HUDS
+// lists them in.
+func groupByCategory(items []huds.CondensedMenuItem) ([]string, map[string][]huds.CondensedMenuItem) {
+	var categories []string
+	byCategory := make(map[string][]huds.CondensedMenuItem)
+	for _, item := range items {
+		category := item.MenuCategory
+		if category == "" {
+			category = "Other"
+		}
+		if _, ok := byCategory[category]; !ok {
+			categories = append(categories, category)
+		}
+		byCategory[category] = append(byCategory[category], item)
+	}
+	return categories, byCategory
+}
+
+func (b badge) css() menuPageBadge {
+	return menuPageBadge{
+		Code:  b.code,
+		Name:  b.name,
+		Color: fmt.Sprintf("#%02x%02x%02x", int(b.color[0]*255), int(b.color[1]*255), int(b.color[2]*255)),
+	}
+}
+
+// WriteMenuPage renders a day's menu as HTML. A nil menu gives a page
+// saying there is no menu for the day.
+func WriteMenuPage(w io.Writer, date time.Time, menu *huds.CondensedMenu, links MenuPageLinks) error {
+	page := menuPage{Date: date.Format("Monday, January 2, 2006"), Links: links}
+	if menu != nil {
+		page.Meals, page.Legend = menuPageMeals(*menu, links.Label)
+	}
+	if len(page.Meals) == 0 {
+		page.Message = "There's no menu for this day."
+	}
+	return menuTemplate.Execute(w, page)
+}
+
+// handleMenuPage renders a day's menu as a plain HTML page, today's by
+// default, for browsers and iframes. Errors are shown on the page rather
+// than as JSON.
+func (s *Server) handleMenuPage(c *gin.Context) {
+	date, ok := s.queryDate(c, "serve_date")
+	if !ok {
+		return
+	}
+	if date.IsZero() {
+		date, _ = time.Parse(huds.ServeDateLayout, s.today())
+	}
+	links := MenuPageLinks{
+		Previous: "?serve_date=" + date.AddDate(0, 0, -1).Format("2006-01-02"),
+		Next:     "?serve_date=" + date.AddDate(0, 0, 1).Format("2006-01-02"),
+	}
+
+	var buf bytes.Buffer
+	status := http.StatusOK
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	menu, err := s.menuByDate(ctx, date.Format(huds.ServeDateLayout))
+	switch {
+	case err == store.ErrMenuNotFound:
+		status = http.StatusNotFound
+		err = WriteMenuPage(&buf, date, nil, links)
+	case err != nil:
+		log.Printf("Failed to load the menu page: %v\n", err)
+		status = http.StatusInternalServerError
+		err = menuTemplate.Execute(&buf, menuPage{
+			Date:    date.Format("Monday, January 2, 2006"),
+			Links:   links,
+			Message: "The menu couldn't be loaded. Please try again later.",
+		})
+	default:
+		links.Print = "huds-data/export.pdf?serve_date=" + date.Format("2006-01-02")
+		links.Label = func(item huds.CondensedMenuItem) string {
+			if item.ID == 0 {
+				return ""
+			}
+			return fmt.Sprintf("items/%d/label.svg", item.ID)
+		}
+		err = WriteMenuPage(&buf, date, &menu, links)
+	}
+	if err != nil {
+		log.Printf("Failed to render menu page: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to render menu page")
+		return
+	}
+	c.Data(status, "text/html; charset=utf-8", buf.Bytes())
+}
+
+// menuPageMeals lays out each meal that has items, and the badges used
+// anywhere on the page for the legend.
+func menuPageMeals(menu huds.CondensedMenu, label func(huds.CondensedMenuItem) string) ([]menuPageMeal, []menuPageBadge) {
+	var meals []menuPageMeal
+	var legend []menuPageBadge
+	seen := make(map[string]bool)
+	for _, meal := range menu.Meals() {
+		items := huds.MealItems(menu, meal)
+		if len(items) == 0 {
+			continue
+		}
+		view := menuPageMeal{Title: mealTitle(meal)}
+		categories, byCategory := groupByCategory(items)
+		for _, category := range categories {
+			group := menuPageCategory{Name: category}
+			for _, item := range byCategory[category] {
+				entry := menuPageItem{Name: item.FoodName}
+				if label != nil {
+					entry.Label = label(item)
+				}
+				for _, b := range itemBadges(item) {
+					entry.Badges = append(entry.Badges, b.css())
+					if !seen[b.name] {
+						seen[b.name] = true
+						legend = append(legend, b.css())
+					}
+				}
+				group.Items = append(group.Items, entry)
+			}
+			view.Categories = append(view.Categories, group)
+		}
+		meals = append(meals, view)
+	}
+	return meals, legend
+}
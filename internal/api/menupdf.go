@@ -0,0 +1,271 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/pdf"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// badge is the colored icon printed next to an item for an allergen or a
+// dietary marker.
+type badge struct {
+	code  string
+	name  string
+	color [3]float64
+}
+
+// allergenBadges are keyed by lowercased allergen, with the spellings HUDS
+// has used for each.
+var allergenBadges = map[string]badge{}
+
+func init() {
+	for _, b := range []struct {
+		badge
+		aliases []string
+	}{
+		{badge{"M", "Milk", [3]float64{0.20, 0.45, 0.80}}, []string{"milk", "dairy"}},
+		{badge{"E", "Eggs", [3]float64{0.90, 0.60, 0.10}}, []string{"eggs", "egg"}},
+		{badge{"F", "Fish", [3]float64{0.10, 0.55, 0.60}}, []string{"fish"}},
+		{badge{"SF", "Shellfish", [3]float64{0.85, 0.35, 0.30}}, []string{"shellfish", "crustacean shellfish"}},
+		{badge{"TN", "Tree Nuts", [3]float64{0.50, 0.32, 0.18}}, []string{"tree nuts", "tree nut"}},
+		{badge{"P", "Peanuts", [3]float64{0.72, 0.52, 0.30}}, []string{"peanuts", "peanut"}},
+		{badge{"W", "Wheat", [3]float64{0.80, 0.65, 0.15}}, []string{"wheat"}},
+		{badge{"G", "Gluten", [3]float64{0.65, 0.50, 0.10}}, []string{"gluten"}},
+		{badge{"S", "Soy", [3]float64{0.35, 0.55, 0.20}}, []string{"soy", "soybeans"}},
+		{badge{"SE", "Sesame", [3]float64{0.55, 0.50, 0.35}}, []string{"sesame"}},
+	} {
+		for _, alias := range b.aliases {
+			allergenBadges[alias] = b.badge
+		}
+	}
+}
+
+var (
+	veganBadge      = badge{"VG", "Vegan", [3]float64{0.10, 0.50, 0.25}}
+	vegetarianBadge = badge{"V", "Vegetarian", [3]float64{0.40, 0.70, 0.30}}
+)
+
+// itemBadges are an item's dietary marker followed by its allergens.
+// Allergens without a badge of their own get one from their initials, or
+// their first two letters if they are one word.
+func itemBadges(item huds.CondensedMenuItem) []badge {
+	var badges []badge
+	if item.Vegan {
+		badges = append(badges, veganBadge)
+	} else if item.Vegetarian {
+		badges = append(badges, vegetarianBadge)
+	}
+	for _, allergen := range huds.Allergens(item.Allergens) {
+		b, ok := allergenBadges[strings.ToLower(allergen)]
+		if !ok {
+			code := ""
+			words := strings.Fields(allergen)
+			for _, word := range words {
+				code += strings.ToUpper(word[:1])
+			}
+			// A lone initial would clash with the named badges, as Mustard
+			// would with Milk
+			if len(words) == 1 && len(allergen) > 1 {
+				code = strings.ToUpper(allergen[:2])
+			}
+			b = badge{code, allergen, [3]float64{0.45, 0.45, 0.45}}
+		}
+		badges = append(badges, b)
+	}
+	return badges
+}
+
+const (
+	pdfMargin     = 54
+	pdfLineHeight = 14
+	pdfBadgeSize  = 11
+	// pdfFooter is kept clear at the bottom of each page for the legend
+	pdfFooter = 90
+)
+
+// menuPDF lays out the printable menu: a page or more per day, each meal
+// grouped by category, with a legend of the badges used on each page.
+type menuPDF struct {
+	doc    *pdf.Document
+	page   *pdf.Page
+	y      float64
+	used   []badge
+	seen   map[string]bool
+	header string
+}
+
+func (m *menuPDF) newPage(header string, continued bool) {
+	m.finishPage()
+	m.page = m.doc.AddPage()
+	m.header = header
+	m.used, m.seen = nil, make(map[string]bool)
+
+	m.y = pdf.LetterHeight - pdfMargin - 20
+	m.page.SetColor(0, 0, 0)
+	m.page.Text(pdfMargin, m.y, pdf.HelveticaBold, 20, "Harvard University Dining Services")
+	m.y -= 20
+	if continued {
+		header += " (continued)"
+	}
+	m.page.Text(pdfMargin, m.y, pdf.Helvetica, 13, header)
+	m.y -= 12
+	m.page.Line(pdfMargin, m.y, pdf.LetterWidth-pdfMargin, m.y, 1.5)
+	m.y -= 10
+}
+
+// need starts a new page if fewer than height points are left above the
+// footer.
+func (m *menuPDF) need(height float64) {
+	if m.y-height < pdfFooter {
+		m.newPage(m.header, true)
+	}
+}
+
+// width is a badge's width: a circle for one letter, stretched into a pill
+// for more.
+func (b badge) width() float64 {
+	if len(b.code) > 1 {
+		return pdf.TextWidth(pdf.HelveticaBold, 6.5, b.code) + 5
+	}
+	return pdfBadgeSize
+}
+
+// drawBadge draws b with its bottom left corner at x, y and returns its width.
+func (m *menuPDF) drawBadge(x float64, y float64, b badge) float64 {
+	m.page.SetColor(b.color[0], b.color[1], b.color[2])
+	width := b.width()
+	radius := float64(pdfBadgeSize) / 2
+	m.page.Circle(x+radius, y+radius, radius)
+	if width > pdfBadgeSize {
+		m.page.Rect(x+radius, y, width-pdfBadgeSize, pdfBadgeSize)
+		m.page.Circle(x+width-radius, y+radius, radius)
+	}
+	m.page.SetColor(1, 1, 1)
+	codeWidth := pdf.TextWidth(pdf.HelveticaBold, 6.5, b.code)
+	m.page.Text(x+(width-codeWidth)/2, y+3.3, pdf.HelveticaBold, 6.5, b.code)
+	return width
+}
+
+func (m *menuPDF) item(item huds.CondensedMenuItem) {
+	m.need(pdfLineHeight)
+	badges := itemBadges(item)
+	badgesWidth := 0.0
+	for _, b := range badges {
+		badgesWidth += b.width() + 3
+	}
+	name := pdf.Truncate(pdf.Helvetica, 10, item.FoodName, pdf.LetterWidth-2*pdfMargin-16-badgesWidth)
+	m.page.SetColor(0, 0, 0)
+	m.page.Text(pdfMargin+8, m.y, pdf.Helvetica, 10, name)
+
+	x := pdfMargin + 8 + pdf.TextWidth(pdf.Helvetica, 10, name) + 6
+	for _, b := range badges {
+		x += m.drawBadge(x, m.y-2, b) + 3
+		if !m.seen[b.name] {
+			m.seen[b.name] = true
+			m.used = append(m.used, b)
+		}
+	}
+	m.y -= pdfLineHeight
+}
+
+func (m *menuPDF) meal(meal string, items []huds.CondensedMenuItem) {
+	if len(items) == 0 {
+		return
+	}
+	m.need(18 + 14 + pdfLineHeight)
+	m.y -= 8
+	m.page.SetColor(0.55, 0.08, 0.12)
+	m.page.Text(pdfMargin, m.y, pdf.HelveticaBold, 15, mealTitle(meal))
+	m.y -= 16
+
+	categories, byCategory := groupByCategory(items)
+	for _, category := range categories {
+		// Keep a category heading with at least its first item
+		m.need(12 + pdfLineHeight)
+		m.page.SetColor(0.35, 0.35, 0.35)
+		m.page.Text(pdfMargin+4, m.y, pdf.HelveticaBold, 8.5, strings.ToUpper(category))
+		m.y -= 13
+		for _, item := range byCategory[category] {
+			m.item(item)
+		}
+		m.y -= 3
+	}
+}
+
+// finishPage prints the legend for the badges used on the current page.
+func (m *menuPDF) finishPage() {
+	if m.page == nil {
+		return
+	}
+	y := float64(pdfMargin)
+	m.page.SetColor(0.6, 0.6, 0.6)
+	m.page.Line(pdfMargin, y+22, pdf.LetterWidth-pdfMargin, y+22, 0.5)
+	x := float64(pdfMargin)
+	for _, b := range m.used {
+		nameWidth := pdf.TextWidth(pdf.Helvetica, 8, b.name)
+		if x+b.width()+3+nameWidth > pdf.LetterWidth-pdfMargin {
+			x = pdfMargin
+			y -= 14
+		}
+		x += m.drawBadge(x, y, b) + 3
+		m.page.SetColor(0.2, 0.2, 0.2)
+		m.page.Text(x, y+2.5, pdf.Helvetica, 8, b.name)
+		x += nameWidth + 14
+	}
+}
+
+// handleMenuPDF renders the menu for serve_date, or the week starting on it
+// with period=week, as a printable PDF.
+func (s *Server) handleMenuPDF(c *gin.Context) {
+	start := serveDateParam(c)
+	days := 1
+	switch c.DefaultQuery("period", "day") {
+	case "day":
+	case "week":
+		days = 7
+	default:
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "period must be day or week")
+		return
+	}
+	end := start.AddDate(0, 0, days-1)
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	menus, err := s.menuRange(ctx, start.Format(huds.ServeDateLayout), end.Format(huds.ServeDateLayout))
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	if len(menus) == 0 {
+		respondError(c, http.StatusNotFound, CodeNotFound, "no menu for this date")
+		return
+	}
+
+	doc := &menuPDF{doc: pdf.New(pdf.LetterWidth, pdf.LetterHeight)}
+	for _, menu := range menus {
+		header := menu.ServeDate
+		if date, err := time.Parse(huds.ServeDateLayout, menu.ServeDate); err == nil {
+			header = date.Format("Monday, January 2, 2006")
+		}
+		doc.newPage(header, false)
+		for _, meal := range menu.Meals() {
+			doc.meal(meal, huds.MealItems(menu, meal))
+		}
+	}
+	doc.finishPage()
+
+	var out bytes.Buffer
+	if _, err := doc.doc.WriteTo(&out); err != nil {
+		log.Printf("Failed to render menu PDF: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to render menu PDF")
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="huds-menu-%s.pdf"`, start.Format("2006-01-02")))
+	c.Data(http.StatusOK, "application/pdf", out.Bytes())
+}
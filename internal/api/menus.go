@@ -0,0 +1,335 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/provider"
+	"hudsgry-api/internal/store"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+func (s *Server) handleHudsData(c *gin.Context) {
+	date := serveDateParam(c)
+	serveDate := date.Format(huds.ServeDateLayout)
+	dateFormat := c.DefaultQuery("date_format", s.dateFormat)
+	if !validDateFormat(dateFormat) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "date_format must be 'us' or 'iso'")
+		return
+	}
+	if location := c.Query("location"); location != "" {
+		s.handleLocationMenu(c, serveDate, location, dateFormat)
+		return
+	}
+	currentDate := s.today()
+
+	if cached := s.cachedMenu(); currentDate == serveDate && cached.ServeDate == serveDate && cached.HasItems() {
+		s.recordCacheLookup(true)
+		respond(c, http.StatusOK, DatedMenu{withItemOptions(c, cached), dateFormat, itemFields(c), itemGroupBy(c), splitHalls(c)}, s.menuMeta(cached, SourceCache, dateFormat))
+		log.Println("Served from local cache")
+		return
+	} else {
+		if currentDate == serveDate {
+			s.recordCacheLookup(false)
+		}
+		// Will set the local cache, so return here
+		ctx, cancel := s.dbContext(c.Request.Context())
+		defer cancel()
+		dbData, err := s.menuByDate(ctx, serveDate)
+		source := SourceDB
+		if err == store.ErrMenuNotFound && s.onDemandEligible(date) && !s.inMaintenance() {
+			dbData, err = s.fetchMissingDate(serveDate)
+			source = SourceUpstream
+		}
+		if err == errUpstreamUnavailable {
+			respondError(c, http.StatusServiceUnavailable, CodeUpstreamUnavailable, "this date hasn't been stored yet and HUDS is unavailable")
+			return
+		}
+		if err != nil || !dbData.HasItems() {
+			earliestRecord, latestRecord := s.recordRange()
+			if err == store.ErrMenuNotFound && (date.Before(earliestRecord) || date.After(latestRecord)) {
+				details := gin.H{
+					"earliest": formatServeDate(earliestRecord.Format(huds.ServeDateLayout), dateFormat),
+					"latest":   formatServeDate(latestRecord.Format(huds.ServeDateLayout), dateFormat),
+				}
+				if date.Before(firstServeDate) {
+					respondErrorDetails(c, http.StatusNotFound, CodeDateOutOfRange, "records don't exist before 05/05/2023 :(", details)
+				} else {
+					respondErrorDetails(c, http.StatusNotFound, CodeDateOutOfRange, "date out of range", details)
+				}
+				return
+			}
+			respondStoreError(c, err)
+			return
+		}
+
+		if currentDate == serveDate {
+			log.Println("Served from local cache")
+			s.setCachedMenu(dbData)
+		}
+
+		respond(c, http.StatusOK, DatedMenu{withItemOptions(c, dbData), dateFormat, itemFields(c), itemGroupBy(c), splitHalls(c)}, s.menuMeta(dbData, source, dateFormat))
+		return
+	}
+}
+
+// withItemOptions applies the query options that shape a menu's items, each
+// bound by its middleware: what details to include, the category, tag,
+// staple and dietary filters, the joined tags and photos, deduplication, and
+// the order. Annenberg's view of the menu gets them on its own.
+func withItemOptions(c *gin.Context, menu huds.CondensedMenu) huds.CondensedMenu {
+	return mapHalls(menu, func(menu huds.CondensedMenu) huds.CondensedMenu {
+		return withSort(c, withDedupe(c, withDietaryFlags(c, withStaples(c, withPhotos(c, withTags(c, withCategories(c, withIncludes(c, menu))))))))
+	})
+}
+
+// menuMeta describes a day's menu for the response envelope, with when its
+// meals are served. A stale menu is flagged, and starts a refresh in the
+// background.
+func (s *Server) menuMeta(menu huds.CondensedMenu, source string, dateFormat string) ResponseMeta {
+	return ResponseMeta{ServeDate: formatServeDate(menu.ServeDate, dateFormat), Source: source, LastUpdated: menu.UpdatedAt, Stale: s.revalidateStale(menu), Service: s.mealService(menu.ServeDate)}
+}
+
+// cachedMenu returns today's menu if it has been cached.
+func (s *Server) cachedMenu() huds.CondensedMenu {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.localCache
+}
+
+func (s *Server) setCachedMenu(menu huds.CondensedMenu) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.localCache = menu
+}
+
+// recordRange returns the first and last serve dates known to be stored.
+func (s *Server) recordRange() (time.Time, time.Time) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.earliestRecord, s.latestRecord
+}
+
+// refreshRecordRange reloads the first and last stored serve dates.
+func (s *Server) refreshRecordRange(ctx context.Context) error {
+	earliest, latest, err := s.getEarliestAndLatestRecords(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.earliestRecord, s.latestRecord = earliest, latest
+	return nil
+}
+
+func (s *Server) getEarliestAndLatestRecords(ctx context.Context) (time.Time, time.Time, error) {
+	// Get the earliest and latest records from the database
+	// If there are no records, return the earliest and latest dates that HUDS has data for
+	earliestDate := firstServeDate
+	latestDate, _ := time.Parse(huds.ServeDateLayout, s.today())
+
+	earliest, latest, err := s.store.EarliestLatest(ctx)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if earliest != "" {
+		if earliestDate, err = time.Parse(huds.ServeDateLayout, earliest); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		if latestDate, err = time.Parse(huds.ServeDateLayout, latest); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+
+	log.Println("earliestRecord: ", earliestDate.Format(huds.ServeDateLayout))
+	log.Println("latestRecord: ", latestDate.Format(huds.ServeDateLayout))
+
+	return earliestDate, latestDate, nil
+}
+
+// fetchAndProcessData fetches the dates in range, or the whole feed for the
+// zero range, and stores them.
+func (s *Server) fetchAndProcessData(dates provider.DateRange) error {
+	if s.inMaintenance() {
+		return errMaintenance
+	}
+	// Only full refreshes alert; a single day may well have no menu
+	full := dates == provider.DateRange{}
+	started := time.Now()
+	data, err := s.fetchWithRetry(dates)
+	if err == nil && full && len(data) == 0 {
+		err = errEmptyFeed
+	}
+	if err != nil {
+		log.Printf("Failed to fetch HUDS data: %v\n", err)
+		s.recordFetch(started, err)
+		if full {
+			s.alertFetchOutcome(err)
+		}
+		return err
+	}
+	log.Println("Fetched HUDS data successfully")
+
+	ctx, cancel := s.jobContext()
+	defer cancel()
+	err = s.storeHUDSData(ctx, data)
+	s.recordFetch(started, err)
+	if full {
+		s.alertFetchOutcome(err)
+	}
+	if full && err == nil {
+		s.mu.Lock()
+		s.syncedAt = s.clock.Now()
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// fetchWithRetry fetches the dates in range through the circuit breaker,
+// retrying as the retry policy allows.
+func (s *Server) fetchWithRetry(dates provider.DateRange) ([]huds.MenuItem, error) {
+	// Nothing should keep the fetch going past the retry window
+	ctx, cancel := context.WithTimeout(context.Background(), s.retry.Window+s.fetchTimeout)
+	defer cancel()
+
+	var data []huds.MenuItem
+	err := s.retry.Do("HUDS fetch", func() error {
+		return s.breaker.Call(func() error {
+			var err error
+			data, err = s.provider.FetchMenus(ctx, dates)
+			return err
+		})
+	})
+	return data, err
+}
+
+// storeHUDSData converts and stores a fetched feed, then runs the refresh
+// hooks.
+func (s *Server) storeHUDSData(ctx context.Context, data []huds.MenuItem) error {
+	s.archiveRaw(ctx, data)
+	return s.condenseAndStore(ctx, data)
+}
+
+// condenseAndStore is storeHUDSData without archiving the feed, for rebuilding
+// menus from the archive.
+func (s *Server) condenseAndStore(ctx context.Context, data []huds.MenuItem) error {
+	// Storing without the corrections would clobber them until the next
+	// refresh, so don't store at all
+	condensedData, locations, err := s.condense(ctx, data)
+	if err != nil {
+		log.Printf("Failed to load overrides: %v\n", err)
+		return err
+	}
+	changed, err := s.processDataAndStore(ctx, condensedData, locations)
+	if err != nil {
+		log.Printf("Failed to process and store data: %v\n", err)
+		return err
+	}
+	if err := s.upsertLocations(ctx, locations); err != nil {
+		log.Printf("Failed to store location menus: %v\n", err)
+		return err
+	}
+
+	s.runRefreshHooks(ctx, condensedData, changed)
+	return nil
+}
+
+// condense converts a fetched feed into house and location menus with the
+// data corrections applied, as they are stored, so that what is compared with
+// the stored menus is what would be written.
+func (s *Server) condense(ctx context.Context, data []huds.MenuItem) (map[string]map[int][]huds.CondensedMenuItem, map[string]map[string]huds.LocationMenu, error) {
+	condensed := huds.ConvertMenuItemsToCondensedMenuItems(data)
+	locations := huds.ConvertMenuItemsByLocation(data)
+	if err := s.applyOverrides(ctx, condensed, locations); err != nil {
+		return nil, nil, err
+	}
+	return condensed, locations, nil
+}
+
+// runRefreshHooks rebuilds what is derived from the days that changed, if
+// any did, then runs the refresh hooks with every day fetched, as
+// notifications keep track of what they have already sent.
+func (s *Server) runRefreshHooks(ctx context.Context, data map[string]map[int][]huds.CondensedMenuItem, changed map[string]map[int][]huds.CondensedMenuItem) {
+	if len(changed) > 0 {
+		for _, hook := range s.derivedDataHooks() {
+			hook(ctx, changed)
+		}
+	}
+	for _, hook := range s.afterRefreshHooks {
+		hook(ctx, data)
+	}
+}
+
+// processDataAndStore stores each day's house default menu with Annenberg
+// Hall's menu for the day from locations, if it has one. Days whose content
+// hash matches the stored one are left alone, so an unchanged feed writes
+// nothing; the days that were written are returned, and dropped from
+// locations, whose remaining days are left to store.
+func (s *Server) processDataAndStore(ctx context.Context, data map[string]map[int][]huds.CondensedMenuItem, locations map[string]map[string]huds.LocationMenu) (map[string]map[int][]huds.CondensedMenuItem, error) {
+	currentDate := s.today()
+	updatedAt := s.clock.Now().UTC()
+
+	menus := make([]huds.CondensedMenu, 0, len(data))
+	for date, meals := range data {
+		menu := huds.MenuFromMeals(date, meals)
+		menu.UpdatedAt = updatedAt
+		menu.Provider = s.provider.Name()
+		if annenberg, ok := locations[date][huds.LocationKey(huds.AnnenbergHall)]; ok {
+			menu.Annenberg = &annenberg
+		}
+		menu.ContentHash = huds.ContentHash(menu, locations[date])
+		menus = append(menus, menu)
+	}
+	stored, err := s.storedMenus(ctx, menus)
+	if err != nil {
+		log.Printf("Failed to load stored menus, storing every day without its history: %v\n", err)
+	}
+
+	changed := make(map[string]map[int][]huds.CondensedMenuItem)
+	changedMenus := make([]huds.CondensedMenu, 0, len(menus))
+	for _, menu := range menus {
+		if previous, ok := stored[menu.ServeDate]; ok && previous.ContentHash == menu.ContentHash {
+			if menu.ServeDate == currentDate {
+				s.setCachedMenu(previous)
+			}
+			delete(locations, menu.ServeDate)
+			continue
+		}
+		if menu.ServeDate == currentDate {
+			s.setCachedMenu(menu)
+		}
+		changed[menu.ServeDate] = data[menu.ServeDate]
+		changedMenus = append(changedMenus, menu)
+	}
+	if len(changedMenus) < len(menus) {
+		log.Printf("%d of %d days are unchanged and weren't stored\n", len(menus)-len(changedMenus), len(menus))
+	}
+	if len(changedMenus) == 0 {
+		return changed, nil
+	}
+	if stored != nil {
+		s.recordHistory(ctx, changedMenus, stored, updatedAt)
+	}
+	return changed, s.upsertMenus(ctx, changedMenus)
+}
+
+// SeedFromFixture stores a saved HUDS API response, in the same way as a
+// fetch from the API would be.
+func (s *Server) SeedFromFixture(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var items []huds.MenuItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		return fmt.Errorf("failed to parse fixture %s: %v", path, err)
+	}
+	ctx, cancel := s.jobContext()
+	defer cancel()
+	return s.storeHUDSData(ctx, items)
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// menuText renders a day's menu compactly for a terminal or a small screen:
+// a heading for each meal with its hours, then a line per category listing
+// its dishes. Only meal is rendered when one is given, and cancelled dishes
+// are left out.
+func menuText(menu huds.CondensedMenu, date time.Time, meal string, schedule store.HoursSchedule, service []MealService) string {
+	var b strings.Builder
+	b.WriteString(date.Format("Monday, January 2, 2006") + "\n")
+	if schedule.Closed {
+		b.WriteString("Dining halls closed")
+		if schedule.Note != "" {
+			b.WriteString(": " + schedule.Note)
+		}
+		b.WriteString("\n")
+	}
+	hours := make(map[string]MealService)
+	for _, window := range service {
+		hours[window.Meal] = window
+	}
+	for _, key := range menu.Meals() {
+		if meal != "" && key != meal {
+			continue
+		}
+		items := huds.MealItems(menu, key)
+		fmt.Fprintf(&b, "\n%s", strings.ToUpper(strings.ReplaceAll(key, "_", " ")))
+		if window, ok := hours[key]; ok {
+			fmt.Fprintf(&b, " %s-%s", window.Opens.Format(time.Kitchen), window.Closes.Format(time.Kitchen))
+			if window.Serving {
+				b.WriteString(" (serving now)")
+			}
+		}
+		b.WriteString("\n")
+		categories, byCategory := groupByCategory(items)
+		listed := false
+		for _, category := range categories {
+			var names []string
+			for _, item := range byCategory[category] {
+				if !item.Cancelled {
+					names = append(names, strings.TrimSpace(item.FoodName))
+				}
+			}
+			if len(names) > 0 {
+				fmt.Fprintf(&b, "%s: %s\n", category, strings.Join(names, ", "))
+				listed = true
+			}
+		}
+		if !listed {
+			b.WriteString("Nothing listed\n")
+		}
+	}
+	return b.String()
+}
+
+// handleMenuText serves a day's menu as plain text, today's by default, for
+// iOS Shortcuts, curl and e-ink displays. ?meal= narrows it to one meal, and
+// the dietary filters apply as they do to /huds-data.
+func (s *Server) handleMenuText(c *gin.Context) {
+	date, ok := s.queryDate(c, "serve_date")
+	if !ok {
+		return
+	}
+	if date.IsZero() {
+		date, _ = time.Parse(huds.ServeDateLayout, s.today())
+	}
+	serveDate := date.Format(huds.ServeDateLayout)
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	menu, err := s.menuByDate(ctx, serveDate)
+	if err == store.ErrMenuNotFound {
+		respondError(c, http.StatusNotFound, CodeNotFound, "no menu for this date")
+		return
+	}
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	meal := strings.ToLower(c.Query("meal"))
+	if meal != "" && !containsString(menu.Meals(), meal) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "meal must be breakfast, lunch, dinner or another meal served that day")
+		return
+	}
+
+	schedule, service := s.dayHours(c.Request.Context(), date)
+	if s.revalidateStale(menu) {
+		c.Header("Warning", `110 - "Response is Stale"`)
+	}
+	c.String(http.StatusOK, menuText(withItemOptions(c, menu), date, meal, schedule, service))
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MenuVersion identifies what is stored for a day, for clients that poll for
+// changes and only fetch the menu when its hash moves.
+type MenuVersion struct {
+	ServeDate   string    `json:"Serve_Date"`
+	Hash        string    `json:"hash"`
+	LastUpdated time.Time `json:"last_updated"`
+}
+
+// handleMenuVersion reports a day's content hash and when it was last stored.
+// The hash is also the ETag, so a poller sending it back as If-None-Match
+// gets an empty 304 until the menu changes.
+func (s *Server) handleMenuVersion(c *gin.Context) {
+	serveDate := serveDateParam(c).Format(huds.ServeDateLayout)
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	menu, err := s.menuByDate(ctx, serveDate)
+	if err == store.ErrMenuNotFound {
+		respondError(c, http.StatusNotFound, CodeNotFound, "no menu for this date")
+		return
+	}
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	// Days stored before hashes were kept are hashed on read; a location's
+	// menus don't count, but the hash still changes with the day's menu
+	hash := menu.ContentHash
+	if hash == "" {
+		hash = huds.ContentHash(menu, nil)
+	}
+	etag := `"` + hash + `"`
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	version := MenuVersion{ServeDate: formatServeDate(serveDate, s.dateFormat), Hash: hash, LastUpdated: menu.UpdatedAt}
+	respond(c, http.StatusOK, version, ResponseMeta{})
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, weakly.
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
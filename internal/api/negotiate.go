@@ -0,0 +1,136 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/ugorji/go/codec"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const responseFormatKey = "response_format"
+
+// Response formats. MessagePack and Protobuf carry exactly what the JSON
+// response would, transcoded from it, so every endpoint supports them without
+// a second model. Protobuf responses are a google.protobuf.Value.
+const (
+	FormatJSON     = "json"
+	FormatMsgPack  = "msgpack"
+	FormatProtobuf = "protobuf"
+	// FormatJSONAPI wraps responses in JSON:API documents instead, so it is
+	// written by respond rather than transcoded
+	FormatJSONAPI = "jsonapi"
+)
+
+const (
+	mimeMsgPack  = "application/msgpack"
+	mimeProtobuf = "application/x-protobuf"
+)
+
+var formatMIMEs = map[string]string{
+	"application/json":                FormatJSON,
+	mimeMsgPack:                       FormatMsgPack,
+	"application/x-msgpack":           FormatMsgPack,
+	mimeProtobuf:                      FormatProtobuf,
+	"application/protobuf":            FormatProtobuf,
+	"application/vnd.google.protobuf": FormatProtobuf,
+	mimeJSONAPI:                       FormatJSONAPI,
+}
+
+var msgpackHandle = &codec.MsgpackHandle{WriteExt: true}
+
+// negotiateFormat picks the response format from ?format=, or else the Accept
+// header. Anything else, including no preference, gets JSON.
+func negotiateFormat(c *gin.Context) {
+	format := strings.ToLower(c.Query("format"))
+	switch format {
+	case "":
+		c.Header("Vary", "Accept")
+		offered := []string{"application/json", mimeMsgPack, "application/x-msgpack", mimeProtobuf, "application/protobuf", "application/vnd.google.protobuf", mimeJSONAPI}
+		format = formatMIMEs[c.NegotiateFormat(offered...)]
+		if format == "" {
+			format = FormatJSON
+		}
+	case FormatJSON, FormatMsgPack, FormatProtobuf, FormatJSONAPI:
+	default:
+		respondErrorDetails(c, http.StatusBadRequest, CodeInvalidRequest, "format must be json, jsonapi, msgpack or protobuf", gin.H{"format": c.Query("format")})
+		return
+	}
+	c.Set(responseFormatKey, format)
+}
+
+// writeData writes a response body in the negotiated format.
+func writeData(c *gin.Context, status int, data interface{}) {
+	format := c.GetString(responseFormatKey)
+	if format == "" || format == FormatJSON {
+		c.JSON(status, data)
+		return
+	}
+	body, contentType, err := transcode(data, format)
+	if err != nil {
+		log.Printf("Failed to encode response as %s: %v\n", format, err)
+		// The error goes out as JSON, which can always be encoded
+		c.Set(responseFormatKey, FormatJSON)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to encode response as "+format)
+		return
+	}
+	c.Data(status, contentType, body)
+}
+
+// transcode encodes data as JSON would, then re-encodes that in format.
+func transcode(data interface{}, format string) ([]byte, string, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, "", err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, "", err
+	}
+	value = plainNumbers(value)
+
+	if format == FormatMsgPack {
+		var out []byte
+		if err := codec.NewEncoderBytes(&out, msgpackHandle).Encode(value); err != nil {
+			return nil, "", err
+		}
+		return out, mimeMsgPack, nil
+	}
+	message, err := structpb.NewValue(value)
+	if err != nil {
+		return nil, "", err
+	}
+	out, err := proto.Marshal(message)
+	if err != nil {
+		return nil, "", err
+	}
+	return out, mimeProtobuf + `; messageType="google.protobuf.Value"`, nil
+}
+
+// plainNumbers replaces the json.Numbers in a decoded value with integers
+// where they are whole, and floats otherwise.
+func plainNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = plainNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = plainNumbers(item)
+		}
+	}
+	return value
+}
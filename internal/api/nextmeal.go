@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"net/http"
+	"time"
+)
+
+// mealEnds is when service ends for each meal, as a time of day in the dining
+// hall's time zone. A meal stays "next" until it ends, so at 1pm it is lunch.
+var mealEnds = []struct {
+	meal string
+	end  time.Duration
+}{
+	{"breakfast", 10*time.Hour + 30*time.Minute},
+	{"lunch", 14 * time.Hour},
+	{"dinner", 20 * time.Hour},
+}
+
+type NextMeal struct {
+	Meal      string                   `json:"meal"`
+	ServeDate string                   `json:"Serve_Date"`
+	EndsAt    time.Time                `json:"ends_at"`
+	Items     []huds.CondensedMenuItem `json:"items"`
+	// Fields narrows each item to these keys, if any are given
+	Fields []string `json:"-"`
+}
+
+func (m NextMeal) MarshalJSON() ([]byte, error) {
+	// plain drops the MarshalJSON method so the default encoding is used
+	type plain NextMeal
+	items, err := selectFields(m.Items, m.Fields)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		plain
+		Items json.RawMessage `json:"items"`
+	}{plain(m), items})
+}
+
+// nextMeal returns the meal being served now, or the next one if none is,
+// with the day it is served and when it ends.
+func (s *Server) nextMeal() (string, time.Time, time.Time) {
+	now := s.localNow()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, huds.DiningZone)
+	for _, meal := range mealEnds {
+		if end := midnight.Add(meal.end); now.Before(end) {
+			return meal.meal, midnight, end
+		}
+	}
+	tomorrow := midnight.AddDate(0, 0, 1)
+	return mealEnds[0].meal, tomorrow, tomorrow.Add(mealEnds[0].end)
+}
+
+// handleNextMeal serves the meal being served now or next, e.g. tonight's
+// dinner after 2pm and tomorrow's breakfast after 8pm.
+func (s *Server) handleNextMeal(c *gin.Context) {
+	dateFormat := c.DefaultQuery("date_format", s.dateFormat)
+	if !validDateFormat(dateFormat) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "date_format must be 'us' or 'iso'")
+		return
+	}
+
+	meal, day, endsAt := s.nextMeal()
+	serveDate := day.Format(huds.ServeDateLayout)
+	menu, source := s.cachedMenu(), SourceCache
+	if serveDate != s.today() || menu.ServeDate != serveDate || !menu.HasItems() {
+		ctx, cancel := s.dbContext(c.Request.Context())
+		defer cancel()
+		var err error
+		menu, err = s.menuByDate(ctx, serveDate)
+		source = SourceDB
+		if err == store.ErrMenuNotFound {
+			respondErrorDetails(c, http.StatusNotFound, CodeNotFound, "the next meal's menu hasn't been published yet", gin.H{"meal": meal, "Serve_Date": formatServeDate(serveDate, dateFormat)})
+			return
+		}
+		if err != nil {
+			respondStoreError(c, err)
+			return
+		}
+	}
+
+	items := huds.MealItems(withItemOptions(c, menu), meal)
+	if items == nil {
+		items = []huds.CondensedMenuItem{}
+	}
+	respond(c, http.StatusOK, NextMeal{
+		Meal:      meal,
+		ServeDate: formatServeDate(serveDate, dateFormat),
+		EndsAt:    endsAt,
+		Items:     formatItemDates(items, dateFormat),
+		Fields:    itemFields(c),
+	}, s.menuMeta(menu, source, dateFormat))
+}
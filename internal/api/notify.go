@@ -0,0 +1,12 @@
+package api
+
+// Notifier delivers a short text message to a single recipient on one channel
+// (a phone number for SMS, a device token for push, ...).
+type Notifier interface {
+	Channel() string
+	Notify(recipient string, message string) error
+}
+
+func (s *Server) registerNotifier(notifier Notifier) {
+	s.notifiers[notifier.Channel()] = notifier
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/scheduler"
+	"net/http"
+	"time"
+)
+
+// localNow returns the service clock's time in the dining halls' zone.
+func (s *Server) localNow() time.Time {
+	return s.clock.Now().In(huds.DiningZone)
+}
+
+// today returns the current serve date according to the service clock.
+func (s *Server) today() string {
+	return s.localNow().Format(huds.ServeDateLayout)
+}
+
+func (s *Server) handleGetNow(c *gin.Context) {
+	now := s.clock.Now()
+	_, simulated := s.clock.(*scheduler.SimulatedClock)
+	respond(c, http.StatusOK, gin.H{"now": now.Format(time.RFC3339), "today": s.today(), "simulated": simulated}, ResponseMeta{})
+}
+
+// handleSetNow moves a simulated clock, either to an absolute "time" or
+// forward by an "advance" duration such as "24h". It is only served to admins
+// when SIMULATED_TIME is set.
+func (s *Server) handleSetNow(c *gin.Context) {
+	simulated, ok := s.clock.(*scheduler.SimulatedClock)
+	if !ok {
+		respondError(c, http.StatusConflict, CodeConflict, "the clock can only be changed in simulated time mode")
+		return
+	}
+
+	var req struct {
+		Time    string `json:"time"`
+		Advance string `json:"advance"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+
+	switch {
+	case req.Time != "":
+		t, err := time.Parse(time.RFC3339, req.Time)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "time must be RFC 3339")
+			return
+		}
+		simulated.Set(t)
+	case req.Advance != "":
+		d, err := time.ParseDuration(req.Advance)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "advance must be a duration such as 90m or 24h")
+			return
+		}
+		simulated.Set(simulated.Now().Add(d))
+	default:
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "time or advance is required")
+		return
+	}
+
+	s.handleGetNow(c)
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"net/http"
+)
+
+// parseAmount reads the number at the start of an upstream nutrition string
+// such as "230", "4g" or "120mg". Missing or malformed values count as zero.
+func parseAmount(s string) float64 {
+	amount := huds.ParseAmount(s, "")
+	if amount == nil {
+		return 0
+	}
+	return amount.Value
+}
+
+type Macros struct {
+	Calories float64 `json:"calories" bson:"calories"`
+	Protein  float64 `json:"protein" bson:"protein"`
+	Carbs    float64 `json:"carbs" bson:"carbs"`
+	Fat      float64 `json:"fat" bson:"fat"`
+}
+
+func itemMacros(item huds.CondensedMenuItem) Macros {
+	return Macros{
+		Calories: parseAmount(item.Calories),
+		Protein:  parseAmount(item.Protein),
+		Carbs:    parseAmount(item.TotalCarb),
+		Fat:      parseAmount(item.TotalFat),
+	}
+}
+
+func (m Macros) Add(other Macros) Macros {
+	return Macros{
+		Calories: m.Calories + other.Calories,
+		Protein:  m.Protein + other.Protein,
+		Carbs:    m.Carbs + other.Carbs,
+		Fat:      m.Fat + other.Fat,
+	}
+}
+
+func (m Macros) Scale(factor float64) Macros {
+	return Macros{
+		Calories: m.Calories * factor,
+		Protein:  m.Protein * factor,
+		Carbs:    m.Carbs * factor,
+		Fat:      m.Fat * factor,
+	}
+}
+
+// Nutrients are the numeric nutrition facts of an item, in the upstream units:
+// kcal for calories, mg for cholesterol and sodium, grams for the rest.
+type Nutrients struct {
+	Calories     float64 `json:"calories" bson:"calories"`
+	TotalFat     float64 `json:"total_fat" bson:"total_fat"`
+	SatFat       float64 `json:"sat_fat" bson:"sat_fat"`
+	TransFat     float64 `json:"trans_fat" bson:"trans_fat"`
+	Cholesterol  float64 `json:"cholesterol" bson:"cholesterol"`
+	Sodium       float64 `json:"sodium" bson:"sodium"`
+	TotalCarb    float64 `json:"total_carb" bson:"total_carb"`
+	DietaryFiber float64 `json:"dietary_fiber" bson:"dietary_fiber"`
+	Sugars       float64 `json:"sugars" bson:"sugars"`
+	Protein      float64 `json:"protein" bson:"protein"`
+}
+
+func itemNutrients(item huds.CondensedMenuItem) Nutrients {
+	return Nutrients{
+		Calories:     parseAmount(item.Calories),
+		TotalFat:     parseAmount(item.TotalFat),
+		SatFat:       parseAmount(item.SatFat),
+		TransFat:     parseAmount(item.TransFat),
+		Cholesterol:  parseAmount(item.Cholesterol),
+		Sodium:       parseAmount(item.Sodium),
+		TotalCarb:    parseAmount(item.TotalCarb),
+		DietaryFiber: parseAmount(item.DietaryFiber),
+		Sugars:       parseAmount(item.Sugars),
+		Protein:      parseAmount(item.Protein),
+	}
+}
+
+func (n Nutrients) Add(other Nutrients) Nutrients {
+	return Nutrients{
+		Calories:     n.Calories + other.Calories,
+		TotalFat:     n.TotalFat + other.TotalFat,
+		SatFat:       n.SatFat + other.SatFat,
+		TransFat:     n.TransFat + other.TransFat,
+		Cholesterol:  n.Cholesterol + other.Cholesterol,
+		Sodium:       n.Sodium + other.Sodium,
+		TotalCarb:    n.TotalCarb + other.TotalCarb,
+		DietaryFiber: n.DietaryFiber + other.DietaryFiber,
+		Sugars:       n.Sugars + other.Sugars,
+		Protein:      n.Protein + other.Protein,
+	}
+}
+
+func (n Nutrients) Scale(factor float64) Nutrients {
+	return Nutrients{
+		Calories:     n.Calories * factor,
+		TotalFat:     n.TotalFat * factor,
+		SatFat:       n.SatFat * factor,
+		TransFat:     n.TransFat * factor,
+		Cholesterol:  n.Cholesterol * factor,
+		Sodium:       n.Sodium * factor,
+		TotalCarb:    n.TotalCarb * factor,
+		DietaryFiber: n.DietaryFiber * factor,
+		Sugars:       n.Sugars * factor,
+		Protein:      n.Protein * factor,
+	}
+}
+
+// rounded rounds each nutrient to a tenth.
+func (n Nutrients) rounded() Nutrients {
+	return Nutrients{
+		Calories:     round1(n.Calories),
+		TotalFat:     round1(n.TotalFat),
+		SatFat:       round1(n.SatFat),
+		TransFat:     round1(n.TransFat),
+		Cholesterol:  round1(n.Cholesterol),
+		Sodium:       round1(n.Sodium),
+		TotalCarb:    round1(n.TotalCarb),
+		DietaryFiber: round1(n.DietaryFiber),
+		Sugars:       round1(n.Sugars),
+		Protein:      round1(n.Protein),
+	}
+}
+
+// itemServing is an item's parsed serving size, parsed now for items stored
+// before sizes were.
+func itemServing(item huds.CondensedMenuItem) *huds.Serving {
+	if item.Serving != nil {
+		return item.Serving
+	}
+	return huds.ParseServing(item.ServingSize)
+}
+
+// per100 scales the nutrients in a serving to 100g and to 100ml of the item,
+// so items served in different portions can be compared. Each is nil when the
+// serving's weight or volume isn't known.
+func per100(nutrients Nutrients, serving *huds.Serving) (*Nutrients, *Nutrients) {
+	if serving == nil {
+		return nil, nil
+	}
+	var per100g, per100ml *Nutrients
+	if serving.Grams != nil && *serving.Grams > 0 {
+		scaled := nutrients.Scale(100 / *serving.Grams).rounded()
+		per100g = &scaled
+	}
+	if serving.Milliliters != nil && *serving.Milliliters > 0 {
+		scaled := nutrients.Scale(100 / *serving.Milliliters).rounded()
+		per100ml = &scaled
+	}
+	return per100g, per100ml
+}
+
+type NutritionSummary struct {
+	Items    int       `json:"items"`
+	Totals   Nutrients `json:"totals"`
+	Averages Nutrients `json:"averages"`
+}
+
+func summarizeNutrition(items []huds.CondensedMenuItem) NutritionSummary {
+	summary := NutritionSummary{Items: len(items)}
+	for _, item := range items {
+		summary.Totals = summary.Totals.Add(itemNutrients(item))
+	}
+	if len(items) > 0 {
+		summary.Averages = summary.Totals.Scale(1 / float64(len(items)))
+	}
+	return summary
+}
+
+type DailyNutrition struct {
+	ServeDate string                      `json:"Serve_Date"`
+	Meals     map[string]NutritionSummary `json:"meals"`
+	Day       NutritionSummary            `json:"day"`
+}
+
+// dailyNutrition totals and averages a day's menu per meal and for the day.
+func dailyNutrition(menu huds.CondensedMenu) DailyNutrition {
+	var all []huds.CondensedMenuItem
+	daily := DailyNutrition{ServeDate: menu.ServeDate, Meals: map[string]NutritionSummary{}}
+	for _, meal := range []string{"breakfast", "lunch", "dinner"} {
+		items := huds.MealItems(menu, meal)
+		daily.Meals[meal] = summarizeNutrition(items)
+		all = append(all, items...)
+	}
+	daily.Day = summarizeNutrition(all)
+	return daily
+}
+
+// handleDailyNutrition totals and averages the nutrition facts of every item
+// on a day's menu, per meal and for the whole day.
+func (s *Server) handleDailyNutrition(c *gin.Context) {
+	serveDate := serveDateParam(c).Format(huds.ServeDateLayout)
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	menu, err := s.menuByDate(ctx, serveDate)
+	if err == store.ErrMenuNotFound {
+		respondError(c, http.StatusNotFound, CodeNotFound, "no menu for this date")
+		return
+	}
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, dailyNutrition(menu), ResponseMeta{ServeDate: serveDate, Source: SourceDB, LastUpdated: menu.UpdatedAt, Stale: s.revalidateStale(menu)})
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// bucket is an S3-compatible object storage bucket: S3 itself, or GCS or R2
+// through their interoperability endpoints and HMAC keys. Requests are signed
+// with AWS Signature Version 4 and address objects path-style, as
+// endpoint/bucket/key.
+type bucket struct {
+	endpoint  string
+	name      string
+	region    string
+	accessKey string
+	secretKey string
+	// publicURL is where stored objects are served from
+	publicURL  string
+	httpClient *http.Client
+}
+
+// loadBucket configures the photo bucket from PHOTO_BUCKET and friends, or
+// returns nil when none is set. PHOTO_STORAGE_ENDPOINT defaults to AWS S3 in
+// PHOTO_REGION; use https://storage.googleapis.com for GCS.
+func loadBucket() (*bucket, error) {
+	name := os.Getenv("PHOTO_BUCKET")
+	if name == "" {
+		return nil, nil
+	}
+	b := &bucket{
+		endpoint:   strings.TrimSuffix(os.Getenv("PHOTO_STORAGE_ENDPOINT"), "/"),
+		name:       name,
+		region:     os.Getenv("PHOTO_REGION"),
+		accessKey:  os.Getenv("PHOTO_ACCESS_KEY_ID"),
+		secretKey:  os.Getenv("PHOTO_SECRET_ACCESS_KEY"),
+		publicURL:  strings.TrimSuffix(os.Getenv("PHOTO_PUBLIC_URL"), "/"),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+	if b.accessKey == "" || b.secretKey == "" {
+		return nil, fmt.Errorf("PHOTO_ACCESS_KEY_ID and PHOTO_SECRET_ACCESS_KEY are required with PHOTO_BUCKET")
+	}
+	if b.region == "" {
+		b.region = "us-east-1"
+	}
+	if b.endpoint == "" {
+		b.endpoint = "https://s3." + b.region + ".amazonaws.com"
+	}
+	if _, err := url.Parse(b.endpoint); err != nil {
+		return nil, fmt.Errorf("invalid PHOTO_STORAGE_ENDPOINT %q: %v", b.endpoint, err)
+	}
+	if b.publicURL == "" {
+		b.publicURL = b.endpoint + "/" + b.name
+	}
+	return b, nil
+}
+
+// URL is where the object stored under key can be fetched by clients.
+func (b *bucket) URL(key string) string {
+	return b.publicURL + "/" + key
+}
+
+func (b *bucket) Put(ctx context.Context, key string, contentType string, body []byte) error {
+	return b.do(ctx, http.MethodPut, key, contentType, body)
+}
+
+func (b *bucket) Delete(ctx context.Context, key string) error {
+	return b.do(ctx, http.MethodDelete, key, "", nil)
+}
+
+func (b *bucket) do(ctx context.Context, method string, key string, contentType string, body []byte) error {
+	path := "/" + b.name + "/" + key
+	req, err := http.NewRequestWithContext(ctx, method, b.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	b.sign(req, path, body, time.Now().UTC())
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("object storage returned %d for %s %s: %s", resp.StatusCode, method, key, detail)
+	}
+	return nil
+}
+
+// sign adds a Signature Version 4 Authorization header to req. Keys are
+// generated by us and only use characters that need no escaping in path.
+func (b *bucket) sign(req *http.Request, path string, body []byte, now time.Time) {
+	payloadHash := sha256Hex(body)
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := []string{"host"}
+	values := map[string]string{"host": req.URL.Host}
+	if contentType := req.Header.Get("Content-Type"); contentType != "" {
+		headers = append([]string{"content-type"}, headers...)
+		values["content-type"] = contentType
+	}
+	headers = append(headers, "x-amz-content-sha256", "x-amz-date")
+	values["x-amz-content-sha256"] = payloadHash
+	values["x-amz-date"] = amzDate
+	var canonicalHeaders strings.Builder
+	for _, header := range headers {
+		canonicalHeaders.WriteString(header + ":" + values[header] + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	canonicalRequest := strings.Join([]string{req.Method, path, "", canonicalHeaders.String(), signedHeaders, payloadHash}, "\n")
+	scope := day + "/" + b.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+b.secretKey), day)
+	for _, part := range []string{b.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+b.accessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,586 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"hudsgry-api/internal/store"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// oidcStateTTL is how long a user has to finish logging in
+	oidcStateTTL = 10 * time.Minute
+	// oidcKeysRefreshInterval is the least time between fetches of the
+	// provider's signing keys when a token names a key that isn't known
+	oidcKeysRefreshInterval = 5 * time.Minute
+	// oidcClockSkew is how far the provider's clock may be ahead or behind
+	oidcClockSkew = time.Minute
+	// oidcNonceCookie ties a login to the browser that started it, so that
+	// another can't be sent to the callback with someone else's state
+	oidcNonceCookie = "oidc_nonce"
+)
+
+// OIDCIdentity is the account at an OpenID Connect provider a user logs in
+// with.
+type OIDCIdentity struct {
+	Issuer  string `json:"issuer" bson:"issuer"`
+	Subject string `json:"subject" bson:"subject"`
+}
+
+// oidcSettings are the OpenID Connect client the service logs users in as.
+// RedirectURL must be registered with the provider, and route to
+// /auth/oidc/callback.
+type oidcSettings struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       string
+	// allowedDomains, if any, are the only email domains let in, e.g.
+	// harvard.edu
+	allowedDomains []string
+	// successURL, if set, is where the browser is sent after logging in,
+	// with the token in the fragment
+	successURL string
+	name       string
+}
+
+// loadOIDCSettings reads OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET and
+// OIDC_REDIRECT_URL, and the optional OIDC_SCOPES, OIDC_ALLOWED_DOMAINS,
+// OIDC_SUCCESS_URL and OIDC_PROVIDER_NAME.
+func loadOIDCSettings() oidcSettings {
+	settings := oidcSettings{
+		issuer:       strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/"),
+		clientID:     os.Getenv("OIDC_CLIENT_ID"),
+		clientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		redirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		scopes:       os.Getenv("OIDC_SCOPES"),
+		successURL:   os.Getenv("OIDC_SUCCESS_URL"),
+		name:         os.Getenv("OIDC_PROVIDER_NAME"),
+	}
+	if settings.scopes == "" {
+		settings.scopes = "openid email profile"
+	}
+	if settings.name == "" {
+		settings.name = "HarvardKey"
+	}
+	for _, domain := range strings.Split(os.Getenv("OIDC_ALLOWED_DOMAINS"), ",") {
+		if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+			settings.allowedDomains = append(settings.allowedDomains, domain)
+		}
+	}
+	return settings
+}
+
+// oidcDiscovery is the part of the provider's metadata the login flow needs.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcTokens struct {
+	IDToken string `json:"id_token"`
+	Error   string `json:"error"`
+}
+
+// oidcClaims are the ID token claims used to find or create the user.
+type oidcClaims struct {
+	Issuer        string          `json:"iss"`
+	Subject       string          `json:"sub"`
+	Audience      json.RawMessage `json:"aud"`
+	ExpiresAt     int64           `json:"exp"`
+	IssuedAt      int64           `json:"iat"`
+	Nonce         string          `json:"nonce"`
+	Email         string          `json:"email"`
+	EmailVerified json.RawMessage `json:"email_verified"`
+	HostedDomain  string          `json:"hd"`
+}
+
+// audiences is the aud claim, which may be a string or a list.
+func (claims oidcClaims) audiences() []string {
+	var one string
+	if json.Unmarshal(claims.Audience, &one) == nil {
+		return []string{one}
+	}
+	var many []string
+	json.Unmarshal(claims.Audience, &many)
+	return many
+}
+
+// emailVerified is the email_verified claim, which some providers send as a
+// string.
+func (claims oidcClaims) emailVerified() bool {
+	value := strings.Trim(string(claims.EmailVerified), `"`)
+	return value == "true"
+}
+
+type OIDCService struct {
+	server     *Server
+	settings   oidcSettings
+	httpClient *http.Client
+
+	// metadata is discovered on first use and kept; keys are refetched when
+	// a token is signed with one that isn't known, as after a rotation
+	metadata struct {
+		sync.Mutex
+		discovery     *oidcDiscovery
+		keys          map[string]interface{}
+		keysFetchedAt time.Time
+	}
+}
+
+// startOIDC configures logging in through an OpenID Connect provider such as
+// HarvardKey or Google Workspace.
+func (s *Server) startOIDC() {
+	settings := loadOIDCSettings()
+	if settings.clientID == "" || settings.clientSecret == "" || settings.redirectURL == "" {
+		log.Println("OIDC_CLIENT_ID, OIDC_CLIENT_SECRET and OIDC_REDIRECT_URL must be set with OIDC_ISSUER; OIDC login is disabled")
+		return
+	}
+	s.oidc = &OIDCService{
+		server:     s,
+		settings:   settings,
+		httpClient: &http.Client{Timeout: 15 * time.Second},
+	}
+	s.ensureIndexes("OIDC",
+		store.IndexSpec{Collection: "users", Model: mongo.IndexModel{
+			Keys:    bson.D{{Key: "oidc.issuer", Value: 1}, {Key: "oidc.subject", Value: 1}},
+			Options: options.Index().SetUnique(true).SetSparse(true),
+		}},
+	)
+}
+
+func (o *OIDCService) routes(r gin.IRouter) {
+	r.GET("/auth/oidc", o.handleInfo)
+	r.GET("/auth/oidc/login", o.handleLogin)
+	r.GET("/auth/oidc/callback", o.handleCallback)
+}
+
+// handleInfo tells clients which provider they can log in with, to label the
+// button.
+func (o *OIDCService) handleInfo(c *gin.Context) {
+	respond(c, http.StatusOK, gin.H{"provider": o.settings.name, "issuer": o.settings.issuer, "login_url": c.FullPath() + "/login"}, ResponseMeta{})
+}
+
+// handleLogin sends the browser to the provider's login page. The state
+// carries a nonce and an expiry, signed so the callback can trust it, and
+// the PKCE verifier is derived from it, so nothing is stored until the user
+// comes back. The nonce is also set in a cookie, which the callback checks
+// against the state.
+func (o *OIDCService) handleLogin(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), o.httpClient.Timeout)
+	defer cancel()
+	discovery, err := o.discover(ctx)
+	if err != nil {
+		log.Printf("Failed to discover the OIDC provider: %v\n", err)
+		respondError(c, http.StatusBadGateway, CodeUpstreamUnavailable, "failed to reach "+o.settings.name)
+		return
+	}
+	nonce, err := newSessionToken()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to start logging in")
+		return
+	}
+	state := o.signState(nonce, o.server.clock.Now().Add(oidcStateTTL))
+	// Lax, as the provider sends the browser back with a top-level GET
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcNonceCookie, nonce, int(oidcStateTTL.Seconds()), "/", "", o.secureCookie(), true)
+	challenge := sha256.Sum256([]byte(o.codeVerifier(state)))
+	params := url.Values{
+		"client_id":             {o.settings.clientID},
+		"redirect_uri":          {o.settings.redirectURL},
+		"response_type":         {"code"},
+		"scope":                 {o.settings.scopes},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	// Google Workspace narrows the account chooser to one domain
+	if len(o.settings.allowedDomains) == 1 {
+		params.Set("hd", o.settings.allowedDomains[0])
+	}
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	c.Redirect(http.StatusFound, discovery.AuthorizationEndpoint+separator+params.Encode())
+}
+
+// handleCallback is where the provider sends the user back to. It exchanges
+// the code for an ID token, checks it, finds or creates the user and logs
+// them in.
+func (o *OIDCService) handleCallback(c *gin.Context) {
+	if reason := c.Query("error"); reason != "" {
+		respondErrorDetails(c, http.StatusBadRequest, CodeInvalidRequest, "login was not completed", gin.H{"reason": reason})
+		return
+	}
+	state := c.Query("state")
+	nonce, ok := o.verifyState(state)
+	if !ok {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid or expired state; start logging in again")
+		return
+	}
+	cookie, err := c.Cookie(oidcNonceCookie)
+	if err != nil || !hmac.Equal([]byte(cookie), []byte(nonce)) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "this login was started in another browser; start logging in again")
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcNonceCookie, "", -1, "/", "", o.secureCookie(), true)
+	code := c.Query("code")
+	if code == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "code is required")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), o.httpClient.Timeout)
+	defer cancel()
+	idToken, err := o.exchangeCode(ctx, code, o.codeVerifier(state))
+	if err != nil {
+		log.Printf("Failed to exchange an OIDC authorization code: %v\n", err)
+		respondError(c, http.StatusBadGateway, CodeUpstreamUnavailable, "failed to log in with "+o.settings.name)
+		return
+	}
+	claims, err := o.verifyIDToken(ctx, idToken, nonce)
+	if err != nil {
+		log.Printf("Rejected an OIDC ID token: %v\n", err)
+		respondError(c, http.StatusUnauthorized, CodeUnauthorized, "failed to log in with "+o.settings.name)
+		return
+	}
+	if !o.domainAllowed(claims) {
+		respondError(c, http.StatusForbidden, CodeForbidden, "this account isn't allowed to log in here")
+		return
+	}
+
+	user, err := o.findOrCreateUser(c, claims)
+	if err == errOIDCNoEmail {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	if err == errOIDCEmailTaken {
+		respondError(c, http.StatusConflict, CodeConflict, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to find or create an OIDC user: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to log in")
+		return
+	}
+	o.server.signIn(c, user, o.settings.successURL)
+}
+
+var (
+	errOIDCNoEmail    = fmt.Errorf("the provider didn't share an email address; allow the email scope and try again")
+	errOIDCEmailTaken = fmt.Errorf("an account with this email already exists; log in to it with your password")
+)
+
+// findOrCreateUser maps the provider's subject to a user, giving a user
+// logging in for the first time a new account. Existing accounts with the
+// same email are never linked: addresses given when registering with a
+// password aren't checked, so anyone could have registered one ahead of its
+// owner.
+func (o *OIDCService) findOrCreateUser(c *gin.Context, claims oidcClaims) (User, error) {
+	s := o.server
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	identity := OIDCIdentity{Issuer: claims.Issuer, Subject: claims.Subject}
+
+	var user User
+	err := s.users.FindOne(ctx, bson.M{"oidc.issuer": identity.Issuer, "oidc.subject": identity.Subject}).Decode(&user)
+	if err != mongo.ErrNoDocuments {
+		return user, err
+	}
+
+	email := strings.ToLower(strings.TrimSpace(claims.Email))
+	if email == "" {
+		return user, errOIDCNoEmail
+	}
+	user = User{Email: email, Favorites: []string{}, OIDC: &identity, EmailVerified: claims.emailVerified(), CreatedAt: s.clock.Now()}
+	result, err := s.users.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		return user, errOIDCEmailTaken
+	}
+	if err != nil {
+		return user, err
+	}
+	user.ID = result.InsertedID.(primitive.ObjectID)
+	return user, nil
+}
+
+// secureCookie is whether the nonce cookie is only sent over HTTPS, as it is
+// when the callback is.
+func (o *OIDCService) secureCookie() bool {
+	return strings.HasPrefix(o.settings.redirectURL, "https://")
+}
+
+// domainAllowed checks the account's email domain, or Google's hosted
+// domain claim, against OIDC_ALLOWED_DOMAINS.
+func (o *OIDCService) domainAllowed(claims oidcClaims) bool {
+	if len(o.settings.allowedDomains) == 0 {
+		return true
+	}
+	domain := strings.ToLower(claims.HostedDomain)
+	if domain == "" && claims.emailVerified() {
+		if _, after, ok := strings.Cut(claims.Email, "@"); ok {
+			domain = strings.ToLower(after)
+		}
+	}
+	for _, allowed := range o.settings.allowedDomains {
+		if domain == allowed || strings.HasSuffix(domain, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// discover fetches the provider's metadata once.
+func (o *OIDCService) discover(ctx context.Context) (*oidcDiscovery, error) {
+	o.metadata.Lock()
+	defer o.metadata.Unlock()
+	if o.metadata.discovery != nil {
+		return o.metadata.discovery, nil
+	}
+	var discovery oidcDiscovery
+	if err := o.getJSON(ctx, o.settings.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != o.settings.issuer {
+		return nil, fmt.Errorf("provider says its issuer is %q, not %q", discovery.Issuer, o.settings.issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("provider metadata is missing an endpoint")
+	}
+	o.metadata.discovery = &discovery
+	return &discovery, nil
+}
+
+// signingKey returns the provider's key with the ID, fetching the keys again
+// if it isn't known and they weren't just fetched.
+func (o *OIDCService) signingKey(ctx context.Context, id string) (interface{}, error) {
+	discovery, err := o.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	o.metadata.Lock()
+	defer o.metadata.Unlock()
+	if key, ok := o.metadata.keys[id]; ok {
+		return key, nil
+	}
+	if time.Since(o.metadata.keysFetchedAt) < oidcKeysRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", id)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := o.getJSON(ctx, discovery.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]interface{})
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		decode := func(s string) *big.Int {
+			b, err := base64.RawURLEncoding.DecodeString(s)
+			if err != nil || len(b) == 0 {
+				return nil
+			}
+			return new(big.Int).SetBytes(b)
+		}
+		switch jwk.Kty {
+		case "RSA":
+			n, e := decode(jwk.N), decode(jwk.E)
+			if n != nil && e != nil && e.IsInt64() {
+				keys[jwk.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+			}
+		case "EC":
+			x, y := decode(jwk.X), decode(jwk.Y)
+			if jwk.Crv == "P-256" && x != nil && y != nil && elliptic.P256().IsOnCurve(x, y) {
+				keys[jwk.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+			}
+		}
+	}
+	o.metadata.keys, o.metadata.keysFetchedAt = keys, time.Now()
+	key, ok := keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", id)
+	}
+	return key, nil
+}
+
+// exchangeCode trades an authorization code for an ID token.
+func (o *OIDCService) exchangeCode(ctx context.Context, code string, verifier string) (string, error) {
+	discovery, err := o.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.settings.redirectURL},
+		"client_id":     {o.settings.clientID},
+		"client_secret": {o.settings.clientSecret},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var tokens oidcTokens
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return "", fmt.Errorf("provider returned %d with an unreadable body: %v", resp.StatusCode, err)
+	}
+	if resp.StatusCode >= 300 || tokens.IDToken == "" {
+		return "", fmt.Errorf("provider returned %d: %s", resp.StatusCode, tokens.Error)
+	}
+	return tokens.IDToken, nil
+}
+
+// verifyIDToken checks the ID token's signature against the provider's keys,
+// and that it was issued by the provider, to this client, for this login.
+func (o *OIDCService) verifyIDToken(ctx context.Context, token string, nonce string) (oidcClaims, error) {
+	var claims oidcClaims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, fmt.Errorf("malformed token")
+	}
+	headerJson, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return claims, fmt.Errorf("malformed header")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJson, &header); err != nil {
+		return claims, fmt.Errorf("malformed header")
+	}
+	// Only asymmetric algorithms: the key set never holds a shared secret
+	if header.Alg != "RS256" && header.Alg != "ES256" {
+		return claims, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+	key, err := o.signingKey(ctx, header.Kid)
+	if err != nil {
+		return claims, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !verifyJWS(header.Alg, key, parts[0]+"."+parts[1], signature) {
+		return claims, fmt.Errorf("bad signature")
+	}
+
+	claimsJson, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, fmt.Errorf("malformed claims")
+	}
+	if err := json.Unmarshal(claimsJson, &claims); err != nil {
+		return claims, fmt.Errorf("malformed claims")
+	}
+	now := o.server.clock.Now()
+	switch {
+	case strings.TrimSuffix(claims.Issuer, "/") != o.settings.issuer:
+		return claims, fmt.Errorf("issued by %q", claims.Issuer)
+	case !containsString(claims.audiences(), o.settings.clientID):
+		return claims, fmt.Errorf("issued to another client")
+	case now.Add(-oidcClockSkew).Unix() >= claims.ExpiresAt:
+		return claims, fmt.Errorf("expired")
+	case claims.IssuedAt > now.Add(oidcClockSkew).Unix():
+		return claims, fmt.Errorf("issued in the future")
+	case !hmac.Equal([]byte(claims.Nonce), []byte(nonce)):
+		return claims, fmt.Errorf("nonce doesn't match")
+	case claims.Subject == "":
+		return claims, fmt.Errorf("no subject")
+	}
+	return claims, nil
+}
+
+func (o *OIDCService) getJSON(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %d", endpoint, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// signState encodes a nonce and an expiry as OAuth state, signed with the
+// client secret.
+func (o *OIDCService) signState(nonce string, expires time.Time) string {
+	payload := nonce + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + o.mac("state", payload)
+}
+
+// verifyState returns the nonce a state was signed with, if it is intact and
+// hasn't expired.
+func (o *OIDCService) verifyState(state string) (string, bool) {
+	payload, signature, ok := cutLast(state, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(o.mac("state", payload))) {
+		return "", false
+	}
+	nonce, expiry, ok := strings.Cut(payload, ".")
+	expires, err := strconv.ParseInt(expiry, 10, 64)
+	if !ok || err != nil || o.server.clock.Now().After(time.Unix(expires, 0)) {
+		return "", false
+	}
+	return nonce, true
+}
+
+// codeVerifier is the PKCE verifier for a login, derived from its state so
+// that only this service can produce it.
+func (o *OIDCService) codeVerifier(state string) string {
+	return o.mac("pkce", state)
+}
+
+func (o *OIDCService) mac(purpose string, payload string) string {
+	mac := hmac.New(sha256.New, []byte(o.settings.clientSecret))
+	mac.Write([]byte(purpose + "." + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/provider"
+	"hudsgry-api/internal/store"
+	"log"
+	"time"
+)
+
+const (
+	// HUDS only publishes a little way ahead, and anything older than a week
+	// should have been stored by an earlier nightly fetch
+	onDemandPastDays   = 7
+	onDemandFutureDays = 30
+	// onDemandInterval keeps a burst of requests for an unpublished date from
+	// turning into a burst of upstream fetches
+	onDemandInterval = 5 * time.Minute
+)
+
+// errUpstreamUnavailable is returned when a date had to be fetched on demand
+// and HUDS couldn't be reached.
+var errUpstreamUnavailable = errors.New("HUDS is unavailable")
+
+// onDemandEligible reports whether a missing date is recent enough that it
+// may simply have been missed, e.g. because last night's fetch failed.
+func (s *Server) onDemandEligible(date time.Time) bool {
+	start, _ := time.Parse(huds.ServeDateLayout, s.today())
+	return !date.Before(start.AddDate(0, 0, -onDemandPastDays)) && !date.After(start.AddDate(0, 0, onDemandFutureDays))
+}
+
+// fetchMissingDate fetches just the requested date from HUDS, stores it, and
+// returns it if it turned out to be published. Concurrent callers for the
+// same date share one fetch instead of starting their own. If HUDS can't be
+// reached it returns errUpstreamUnavailable.
+func (s *Server) fetchMissingDate(date string) (huds.CondensedMenu, error) {
+	menu, err, _ := s.onDemand.fetches.Do(date, func() (interface{}, error) {
+		return s.fetchDate(date)
+	})
+	return menu.(huds.CondensedMenu), err
+}
+
+func (s *Server) fetchDate(date string) (huds.CondensedMenu, error) {
+	// The fetch isn't tied to the request that started it, as others may be
+	// waiting on it
+	ctx, cancel := s.jobContext()
+	defer cancel()
+
+	// Someone else may have fetched it since the caller looked
+	if menu, err := s.store.GetByDate(ctx, date); err != store.ErrMenuNotFound {
+		return menu, err
+	}
+	if !s.claimOnDemandAttempt(date) {
+		return huds.CondensedMenu{}, store.ErrMenuNotFound
+	}
+
+	log.Printf("%s is missing, fetching HUDS data on demand\n", date)
+	fetchCtx, cancelFetch := context.WithTimeout(ctx, s.fetchTimeout)
+	defer cancelFetch()
+	day, _ := time.Parse(huds.ServeDateLayout, date)
+	var data []huds.MenuItem
+	err := s.breaker.Call(func() error {
+		var err error
+		data, err = s.provider.FetchMenus(fetchCtx, provider.Day(day))
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to fetch HUDS data on demand: %v\n", err)
+		return huds.CondensedMenu{}, errUpstreamUnavailable
+	}
+	if err := s.storeHUDSData(ctx, data); err != nil {
+		return huds.CondensedMenu{}, err
+	}
+	s.refreshRecordRange(ctx)
+
+	return s.store.GetByDate(ctx, date)
+}
+
+// claimOnDemandAttempt records an attempt to fetch date, unless one was made
+// within onDemandInterval.
+func (s *Server) claimOnDemandAttempt(date string) bool {
+	s.onDemand.Lock()
+	defer s.onDemand.Unlock()
+	if time.Since(s.onDemand.lastAttempt[date]) < onDemandInterval {
+		return false
+	}
+	s.onDemand.lastAttempt[date] = time.Now()
+	for attempted, at := range s.onDemand.lastAttempt {
+		if time.Since(at) >= onDemandInterval {
+			delete(s.onDemand.lastAttempt, attempted)
+		}
+	}
+	return true
+}
@@ -0,0 +1,258 @@
+package api
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type OverrideRequest struct {
+	FoodName    *string `json:"Food_Name"`
+	Allergens   *string `json:"Allergens"`
+	Ingredients *string `json:"Ingredient_List"`
+	Cancelled   bool    `json:"Cancelled"`
+	Note        string  `json:"note"`
+}
+
+type OverrideResult struct {
+	Override *store.Override `json:"override,omitempty"`
+	// RebuiltDays is how many stored days were rebuilt from the raw archive
+	// to apply the change; without an archive it applies from the next
+	// refresh
+	RebuiltDays int `json:"rebuilt_days"`
+}
+
+// menuOverrides indexes overrides by recipe number, for the ones applying to
+// every day, and by ID for the ones applying to one.
+type menuOverrides struct {
+	everyDay map[string]store.Override
+	oneDay   map[string]store.Override
+}
+
+// loadOverrides reads the data corrections, if the store keeps any.
+func (s *Server) loadOverrides(ctx context.Context) (menuOverrides, error) {
+	overrides := menuOverrides{everyDay: make(map[string]store.Override), oneDay: make(map[string]store.Override)}
+	overrideStore, ok := s.store.(store.OverrideStore)
+	if !ok {
+		return overrides, nil
+	}
+	list, err := overrideStore.ListOverrides(ctx)
+	if err != nil {
+		return overrides, err
+	}
+	for _, override := range list {
+		if override.ServeDate == "" {
+			overrides.everyDay[override.RecipeNumber] = override
+		} else {
+			overrides.oneDay[override.ID] = override
+		}
+	}
+	return overrides, nil
+}
+
+// apply corrects a day's items in place, a day's override taking precedence
+// over an every-day one.
+func (o menuOverrides) apply(serveDate string, items []huds.CondensedMenuItem) {
+	for i := range items {
+		if items[i].RecipeNumber == "" {
+			continue
+		}
+		if override, ok := o.everyDay[items[i].RecipeNumber]; ok {
+			overrideItem(&items[i], override)
+		}
+		if override, ok := o.oneDay[store.OverrideID(items[i].RecipeNumber, serveDate)]; ok {
+			overrideItem(&items[i], override)
+		}
+	}
+}
+
+func overrideItem(item *huds.CondensedMenuItem, override store.Override) {
+	if override.FoodName != nil {
+		item.FoodName = *override.FoodName
+	}
+	if override.Allergens != nil {
+		item.Allergens = *override.Allergens
+	}
+	if override.Ingredients != nil {
+		item.Ingredients = *override.Ingredients
+	}
+	if override.Cancelled {
+		item.Cancelled = true
+	}
+}
+
+// applyOverrides layers the data corrections over freshly condensed house
+// and location menus.
+func (s *Server) applyOverrides(ctx context.Context, condensed map[string]map[int][]huds.CondensedMenuItem, locations map[string]map[string]huds.LocationMenu) error {
+	overrides, err := s.loadOverrides(ctx)
+	if err != nil {
+		return err
+	}
+	if len(overrides.everyDay) == 0 && len(overrides.oneDay) == 0 {
+		return nil
+	}
+	for serveDate, meals := range condensed {
+		for _, items := range meals {
+			overrides.apply(serveDate, items)
+		}
+	}
+	for serveDate, byLocation := range locations {
+		for _, menu := range byLocation {
+			overrides.apply(serveDate, menu.Breakfast)
+			overrides.apply(serveDate, menu.Lunch)
+			overrides.apply(serveDate, menu.Dinner)
+			for _, extra := range menu.Extra {
+				overrides.apply(serveDate, extra.Items)
+			}
+		}
+	}
+	return nil
+}
+
+// overrideStore returns the menu store's overrides, answering 501 if the
+// configured store keeps none.
+func (s *Server) overrideStore(c *gin.Context) (store.OverrideStore, bool) {
+	overrideStore, ok := s.store.(store.OverrideStore)
+	if !ok {
+		respondError(c, http.StatusNotImplemented, CodeNotImplemented, "overrides are not supported by this storage backend")
+	}
+	return overrideStore, ok
+}
+
+// overrideServeDate parses the optional ?serve_date= that narrows an override
+// to one day.
+func (s *Server) overrideServeDate(c *gin.Context) (string, bool) {
+	date, ok := s.queryDate(c, "serve_date")
+	if !ok || date.IsZero() {
+		return "", ok
+	}
+	return date.Format(huds.ServeDateLayout), true
+}
+
+func (s *Server) handleAdminListOverrides(c *gin.Context) {
+	overrideStore, ok := s.overrideStore(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	overrides, err := overrideStore.ListOverrides(ctx)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	respond(c, http.StatusOK, overrides, ResponseMeta{Source: SourceDB})
+}
+
+// handleAdminPutOverride corrects a recipe's name, allergens or ingredients,
+// or marks it cancelled, on every day or just ?serve_date=, then rebuilds the
+// affected days so the correction shows straight away.
+func (s *Server) handleAdminPutOverride(c *gin.Context) {
+	overrideStore, ok := s.overrideStore(c)
+	if !ok {
+		return
+	}
+	serveDate, ok := s.overrideServeDate(c)
+	if !ok {
+		return
+	}
+	var req OverrideRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+	if req.FoodName == nil && req.Allergens == nil && req.Ingredients == nil && !req.Cancelled {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Food_Name, Allergens, Ingredient_List or Cancelled is required")
+		return
+	}
+	if req.FoodName != nil && strings.TrimSpace(*req.FoodName) == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Food_Name can't be empty")
+		return
+	}
+
+	number := strings.TrimSpace(c.Param("recipe"))
+	override := store.Override{
+		ID:           store.OverrideID(number, serveDate),
+		RecipeNumber: number,
+		ServeDate:    serveDate,
+		FoodName:     req.FoodName,
+		Allergens:    req.Allergens,
+		Ingredients:  req.Ingredients,
+		Cancelled:    req.Cancelled,
+		Note:         req.Note,
+		UpdatedAt:    time.Now().UTC(),
+	}
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	if err := overrideStore.PutOverride(ctx, override); err != nil {
+		log.Printf("Failed to store override %s: %v\n", override.ID, err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to store override")
+		return
+	}
+	respond(c, http.StatusOK, OverrideResult{Override: &override, RebuiltDays: s.rebuildOverridden(number, serveDate)}, ResponseMeta{})
+}
+
+// handleAdminDeleteOverride removes a correction, restoring HUDS's data on the
+// affected days.
+func (s *Server) handleAdminDeleteOverride(c *gin.Context) {
+	overrideStore, ok := s.overrideStore(c)
+	if !ok {
+		return
+	}
+	serveDate, ok := s.overrideServeDate(c)
+	if !ok {
+		return
+	}
+	number := strings.TrimSpace(c.Param("recipe"))
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	err := overrideStore.DeleteOverride(ctx, store.OverrideID(number, serveDate))
+	if err == store.ErrMenuNotFound {
+		respondError(c, http.StatusNotFound, CodeNotFound, "override not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to remove override: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to store override")
+		return
+	}
+	respond(c, http.StatusOK, OverrideResult{RebuiltDays: s.rebuildOverridden(number, serveDate)}, ResponseMeta{})
+}
+
+// rebuildOverridden rebuilds the stored days a recipe's override touches from
+// the raw archive, returning how many were. Failures are logged, since the
+// override is kept either way and the next refresh applies it.
+func (s *Server) rebuildOverridden(number string, serveDate string) int {
+	archive, ok := s.store.(store.RawArchive)
+	if !ok {
+		return 0
+	}
+	dates := []string{serveDate}
+	if serveDate == "" {
+		catalog, err := s.loadCatalog()
+		if err != nil {
+			log.Printf("Failed to build the menu catalog: %v\n", err)
+			return 0
+		}
+		history, ok := catalog.Recipes[number]
+		if !ok {
+			return 0
+		}
+		dates = dates[:0]
+		for _, occurrence := range history.Served {
+			dates = append(dates, occurrence.ServeDate)
+		}
+	}
+	ctx, cancel := s.jobContext()
+	defer cancel()
+	rebuilt, err := s.rebuildFromRaw(ctx, archive, dates)
+	if err != nil {
+		log.Printf("Failed to apply override to stored menus: %v\n", err)
+	}
+	return rebuilt
+}
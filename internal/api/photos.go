@@ -0,0 +1,321 @@
+package api
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	PhotoPending  = "pending"
+	PhotoApproved = "approved"
+	PhotoRejected = "rejected"
+
+	maxPhotoBytes = 5 << 20
+	// maxItemPhotos is how many photos are shown on a menu item
+	maxItemPhotos = 3
+	photosKey     = "photos"
+	// photoCacheTTL is how long approved photos are kept in memory between
+	// reloads
+	photoCacheTTL = time.Minute
+)
+
+// photoExtensions are the image types accepted, by sniffed content type.
+var photoExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// Photo is a user's photo of a dish, stored in the photo bucket and kept by
+// recipe number. It is only shown once approved.
+type Photo struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id"`
+	RecipeNumber string             `json:"Recipe_Number" bson:"recipe_number"`
+	UserID       primitive.ObjectID `json:"-" bson:"user_id"`
+	Key          string             `json:"-" bson:"key"`
+	URL          string             `json:"url" bson:"url"`
+	ContentType  string             `json:"content_type" bson:"content_type"`
+	Size         int                `json:"size" bson:"size"`
+	Status       string             `json:"status" bson:"status"`
+	// Flags are the moderator's reasons, e.g. "not food" or "blurry"
+	Flags       []string   `json:"flags,omitempty" bson:"flags,omitempty"`
+	CreatedAt   time.Time  `json:"created_at" bson:"created_at"`
+	ModeratedAt *time.Time `json:"moderated_at,omitempty" bson:"moderated_at,omitempty"`
+}
+
+type ModerationRequest struct {
+	Status string   `json:"status" binding:"required"`
+	Flags  []string `json:"flags"`
+}
+
+type PhotoService struct {
+	server *Server
+	bucket *bucket
+	photos *mongo.Collection
+	// moderated holds new photos for approval; without it they are shown
+	// straight away
+	moderated bool
+	approved  struct {
+		sync.Mutex
+		byRecipe map[string][]string
+		loadedAt time.Time
+	}
+}
+
+// startPhotos configures photo uploads when a bucket is. Photos wait for an
+// admin's approval unless PHOTO_MODERATION is off.
+func (s *Server) startPhotos() {
+	b, err := loadBucket()
+	if err != nil {
+		log.Printf("Failed to configure photo storage: %v\n", err)
+		return
+	}
+	if b == nil {
+		return
+	}
+	s.photos = &PhotoService{
+		server:    s,
+		bucket:    b,
+		photos:    s.db.Collection("photos"),
+		moderated: os.Getenv("PHOTO_MODERATION") != "off",
+	}
+	s.ensureIndexes("photo", store.IndexSpec{Collection: "photos", Model: mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "recipe_number", Value: 1}, {Key: "created_at", Value: -1}},
+	}})
+}
+
+func (p *PhotoService) routes(r gin.IRouter) {
+	r.GET("/recipes/:number/photos", p.handleListPhotos)
+	r.POST("/recipes/:number/photos", p.server.requireUser, p.handleUpload)
+	if p.server.adminToken != "" {
+		r.GET("/admin/photos", p.server.requireAdmin, p.handleAdminListPhotos)
+		r.PUT("/admin/photos/:id", p.server.requireAdmin, p.handleModerate)
+	}
+}
+
+// handleUpload stores a photo of a recipe sent as the multipart field
+// "photo". JPEG, PNG and WebP images up to 5 MB are accepted.
+func (p *PhotoService) handleUpload(c *gin.Context) {
+	number := strings.TrimSpace(c.Param("number"))
+	catalog, err := p.server.loadCatalog()
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	if _, ok := catalog.Recipes[number]; !ok {
+		respondError(c, http.StatusNotFound, CodeNotFound, "recipe not found")
+		return
+	}
+
+	header, err := c.FormFile("photo")
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "photo is required as a multipart file")
+		return
+	}
+	if header.Size > maxPhotoBytes {
+		respondErrorDetails(c, http.StatusRequestEntityTooLarge, CodeInvalidRequest, "photos can be at most 5 MB", gin.H{"size": header.Size})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "photo couldn't be read")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxPhotoBytes+1))
+	if err != nil || len(data) > maxPhotoBytes {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "photo couldn't be read")
+		return
+	}
+	contentType := http.DetectContentType(data)
+	extension, ok := photoExtensions[contentType]
+	if !ok {
+		respondErrorDetails(c, http.StatusUnsupportedMediaType, CodeInvalidRequest, "photos must be JPEG, PNG or WebP images", gin.H{"content_type": contentType})
+		return
+	}
+
+	id := primitive.NewObjectID()
+	key := "photos/" + id.Hex() + extension
+	photo := Photo{
+		ID:           id,
+		RecipeNumber: number,
+		UserID:       currentUser(c).ID,
+		Key:          key,
+		URL:          p.bucket.URL(key),
+		ContentType:  contentType,
+		Size:         len(data),
+		Status:       PhotoPending,
+		CreatedAt:    p.server.clock.Now(),
+	}
+	if !p.moderated {
+		photo.Status = PhotoApproved
+	}
+	if err := p.bucket.Put(c.Request.Context(), key, contentType, data); err != nil {
+		log.Printf("Failed to upload photo: %v\n", err)
+		respondError(c, http.StatusBadGateway, CodeInternal, "failed to store photo")
+		return
+	}
+	ctx, cancel := p.server.dbContext(c.Request.Context())
+	defer cancel()
+	if _, err := p.photos.InsertOne(ctx, photo); err != nil {
+		log.Printf("Failed to save photo: %v\n", err)
+		if err := p.bucket.Delete(c.Request.Context(), key); err != nil {
+			log.Printf("Failed to remove unsaved photo %s: %v\n", key, err)
+		}
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to store photo")
+		return
+	}
+	if photo.Status == PhotoApproved {
+		p.resetApproved()
+	}
+	respond(c, http.StatusCreated, photo, ResponseMeta{})
+}
+
+// handleListPhotos lists a recipe's approved photos, newest first.
+func (p *PhotoService) handleListPhotos(c *gin.Context) {
+	p.listPhotos(c, bson.M{"recipe_number": strings.TrimSpace(c.Param("number")), "status": PhotoApproved})
+}
+
+// handleAdminListPhotos lists photos by ?status=, pending by default, for
+// moderation.
+func (p *PhotoService) handleAdminListPhotos(c *gin.Context) {
+	status := c.DefaultQuery("status", PhotoPending)
+	if status != PhotoPending && status != PhotoApproved && status != PhotoRejected {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "status must be pending, approved or rejected")
+		return
+	}
+	p.listPhotos(c, bson.M{"status": status})
+}
+
+func (p *PhotoService) listPhotos(c *gin.Context, filter bson.M) {
+	ctx, cancel := p.server.dbContext(c.Request.Context())
+	defer cancel()
+	cursor, err := p.photos.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(100))
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	photos := []Photo{}
+	if err := cursor.All(ctx, &photos); err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	respond(c, http.StatusOK, photos, ResponseMeta{})
+}
+
+// handleModerate approves or rejects a photo, with optional flags saying
+// why. A rejected photo is removed from the bucket.
+func (p *PhotoService) handleModerate(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid photo id")
+		return
+	}
+	var req ModerationRequest
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Status != PhotoApproved && req.Status != PhotoRejected) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "status must be approved or rejected")
+		return
+	}
+
+	ctx, cancel := p.server.dbContext(c.Request.Context())
+	defer cancel()
+	now := p.server.clock.Now()
+	var photo Photo
+	err = p.photos.FindOneAndUpdate(ctx, bson.M{"_id": id},
+		bson.M{"$set": bson.M{"status": req.Status, "flags": req.Flags, "moderated_at": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&photo)
+	if err == mongo.ErrNoDocuments {
+		respondError(c, http.StatusNotFound, CodeNotFound, "photo not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to moderate photo: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to moderate photo")
+		return
+	}
+	if photo.Status == PhotoRejected {
+		if err := p.bucket.Delete(c.Request.Context(), photo.Key); err != nil {
+			log.Printf("Failed to remove rejected photo %s: %v\n", photo.Key, err)
+		}
+	}
+	p.resetApproved()
+	respond(c, http.StatusOK, photo, ResponseMeta{})
+}
+
+// approvedPhotos returns the URLs of each recipe's newest approved photos,
+// keyed by recipe number.
+func (p *PhotoService) approvedPhotos(ctx context.Context) (map[string][]string, error) {
+	p.approved.Lock()
+	defer p.approved.Unlock()
+	if p.approved.byRecipe != nil && time.Since(p.approved.loadedAt) < photoCacheTTL {
+		return p.approved.byRecipe, nil
+	}
+	cursor, err := p.photos.Find(ctx, bson.M{"status": PhotoApproved},
+		options.Find().SetSort(bson.M{"created_at": -1}).SetProjection(bson.M{"recipe_number": 1, "url": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var photos []Photo
+	if err := cursor.All(ctx, &photos); err != nil {
+		return nil, err
+	}
+	byRecipe := make(map[string][]string)
+	for _, photo := range photos {
+		if len(byRecipe[photo.RecipeNumber]) < maxItemPhotos {
+			byRecipe[photo.RecipeNumber] = append(byRecipe[photo.RecipeNumber], photo.URL)
+		}
+	}
+	p.approved.byRecipe, p.approved.loadedAt = byRecipe, time.Now()
+	return byRecipe, nil
+}
+
+func (p *PhotoService) resetApproved() {
+	p.approved.Lock()
+	defer p.approved.Unlock()
+	p.approved.byRecipe = nil
+}
+
+// bindPhotos loads the approved photos for withPhotos. Menus are still
+// served without them if they can't be loaded.
+func (s *Server) bindPhotos(c *gin.Context) {
+	if s.photos == nil {
+		return
+	}
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	byRecipe, err := s.photos.approvedPhotos(ctx)
+	if err != nil {
+		log.Printf("Failed to load photos: %v\n", err)
+		return
+	}
+	c.Set(photosKey, byRecipe)
+}
+
+// withPhotos adds the URLs of each item's approved photos to a menu.
+func withPhotos(c *gin.Context, menu huds.CondensedMenu) huds.CondensedMenu {
+	byRecipe, _ := c.Value(photosKey).(map[string][]string)
+	if len(byRecipe) == 0 {
+		return menu
+	}
+	return menu.MapMeals(func(items []huds.CondensedMenuItem) []huds.CondensedMenuItem {
+		withURLs := make([]huds.CondensedMenuItem, len(items))
+		for i, item := range items {
+			item.Photos = byRecipe[item.RecipeNumber]
+			withURLs[i] = item
+		}
+		return withURLs
+	})
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"math"
+	"net/http"
+)
+
+const (
+	planDays               = 7
+	defaultMaxItemsPerMeal = 4
+)
+
+type WeekPlanRequest struct {
+	Vegan            bool     `json:"vegan"`
+	Vegetarian       bool     `json:"vegetarian"`
+	ExcludeAllergens []string `json:"exclude_allergens"`
+	Targets          Macros   `json:"targets" binding:"required"`
+	MaxItemsPerMeal  int      `json:"max_items_per_meal"`
+	Meals            []string `json:"meals"`
+}
+
+type PlannedItem struct {
+	FoodName     string `json:"Food_Name"`
+	MenuCategory string `json:"Menu_Category_Name"`
+	Macros       Macros `json:"macros"`
+}
+
+type PlannedMeal struct {
+	Meal   string        `json:"meal"`
+	Items  []PlannedItem `json:"items"`
+	Totals Macros        `json:"totals"`
+}
+
+type PlannedDay struct {
+	ServeDate string        `json:"Serve_Date"`
+	Published bool          `json:"published"`
+	Meals     []PlannedMeal `json:"meals"`
+	Totals    Macros        `json:"totals"`
+}
+
+type WeekPlan struct {
+	Targets Macros       `json:"targets"`
+	Days    []PlannedDay `json:"days"`
+}
+
+// handlePlanWeek proposes a plate for each meal over the next seven days of
+// published menus, aiming each plate at an even share of the daily targets.
+func (s *Server) handlePlanWeek(c *gin.Context) {
+	var req WeekPlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "targets are required")
+		return
+	}
+	if req.Targets.Calories <= 0 {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "targets.calories must be positive")
+		return
+	}
+	if req.MaxItemsPerMeal <= 0 {
+		req.MaxItemsPerMeal = defaultMaxItemsPerMeal
+	}
+	if len(req.Meals) == 0 {
+		req.Meals = []string{"breakfast", "lunch", "dinner"}
+	}
+	for _, meal := range req.Meals {
+		if meal != "breakfast" && meal != "lunch" && meal != "dinner" {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "meals may only contain breakfast, lunch and dinner")
+			return
+		}
+	}
+
+	mealTarget := req.Targets.Scale(1 / float64(len(req.Meals)))
+	plan := WeekPlan{Targets: req.Targets}
+	start := s.localNow()
+	for i := 0; i < planDays; i++ {
+		date := start.AddDate(0, 0, i).Format(huds.ServeDateLayout)
+		day := PlannedDay{ServeDate: date, Meals: []PlannedMeal{}}
+
+		ctx, cancel := s.dbContext(c.Request.Context())
+		menu, err := s.menuByDate(ctx, date)
+		cancel()
+		if err != nil && err != store.ErrMenuNotFound {
+			respondStoreError(c, err)
+			return
+		}
+		if err == nil {
+			day.Published = true
+			for _, meal := range req.Meals {
+				planned := planMeal(meal, huds.MealItems(menu, meal), mealTarget, req)
+				day.Meals = append(day.Meals, planned)
+				day.Totals = day.Totals.Add(planned.Totals)
+			}
+		}
+		plan.Days = append(plan.Days, day)
+	}
+
+	respond(c, http.StatusOK, plan, ResponseMeta{})
+}
+
+// planMeal greedily adds whichever allowed item brings the plate closest to
+// the target, stopping when no item improves it or the plate is full.
+func planMeal(meal string, items []huds.CondensedMenuItem, target Macros, req WeekPlanRequest) PlannedMeal {
+	profile := DietaryProfile{Vegan: req.Vegan, Vegetarian: req.Vegetarian, AvoidAllergens: req.ExcludeAllergens}
+	var candidates []huds.CondensedMenuItem
+	for _, item := range items {
+		if profile.Allows(item) && parseAmount(item.Calories) > 0 {
+			candidates = append(candidates, item)
+		}
+	}
+
+	planned := PlannedMeal{Meal: meal, Items: []PlannedItem{}}
+	used := make([]bool, len(candidates))
+	for len(planned.Items) < req.MaxItemsPerMeal {
+		best, bestDistance := -1, macroDistance(planned.Totals, target)
+		for i, item := range candidates {
+			if used[i] {
+				continue
+			}
+			if d := macroDistance(planned.Totals.Add(itemMacros(item)), target); d < bestDistance {
+				best, bestDistance = i, d
+			}
+		}
+		if best < 0 {
+			break
+		}
+		used[best] = true
+		macros := itemMacros(candidates[best])
+		planned.Items = append(planned.Items, PlannedItem{
+			FoodName:     candidates[best].FoodName,
+			MenuCategory: candidates[best].MenuCategory,
+			Macros:       macros,
+		})
+		planned.Totals = planned.Totals.Add(macros)
+	}
+	return planned
+}
+
+// macroDistance is the root-mean-square relative error against each target
+// that was actually set.
+func macroDistance(actual Macros, target Macros) float64 {
+	var sum float64
+	var n int
+	for _, pair := range [][2]float64{
+		{actual.Calories, target.Calories},
+		{actual.Protein, target.Protein},
+		{actual.Carbs, target.Carbs},
+		{actual.Fat, target.Fat},
+	} {
+		if pair[1] <= 0 {
+			continue
+		}
+		relative := (pair[0] - pair[1]) / pair[1]
+		sum += relative * relative
+		n++
+	}
+	return math.Sqrt(sum / float64(n))
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"math"
+	"net/http"
+	"time"
+)
+
+const (
+	// predictionCycles is how many past cycles a prediction is based on
+	predictionCycles = 4
+	// minPredictionShare is the share of those cycles an item must have been
+	// served on, at the same point, to be predicted
+	minPredictionShare = 0.5
+)
+
+// PredictedMenu is a best guess at a menu HUDS hasn't published yet, from
+// the days at the same point of past cycles. It is served like a menu, with
+// Predicted set and each item's confidence.
+type PredictedMenu struct {
+	Menu DatedMenu
+	// Confidence is the detected cycle's similarity: how much of a day's
+	// menu is usually served again a cycle later
+	Confidence float64
+	// BasedOn are the serve dates the prediction was made from, latest first
+	BasedOn []string
+}
+
+func (m PredictedMenu) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(m.Menu)
+	if err != nil {
+		return nil, err
+	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+	keys["Predicted"] = json.RawMessage("true")
+	if keys["Confidence"], err = json.Marshal(m.Confidence); err != nil {
+		return nil, err
+	}
+	if keys["Based_On"], err = json.Marshal(m.BasedOn); err != nil {
+		return nil, err
+	}
+	return json.Marshal(keys)
+}
+
+// handlePredictedMenu serves /huds-data/predicted, guessing the menu for a
+// date after the last published one from the detected cycle.
+func (s *Server) handlePredictedMenu(c *gin.Context) {
+	date := serveDateParam(c)
+	dateFormat := c.DefaultQuery("date_format", s.dateFormat)
+	if !validDateFormat(dateFormat) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "date_format must be 'us' or 'iso'")
+		return
+	}
+	if _, latest := s.recordRange(); !date.After(latest) {
+		details := gin.H{"latest": formatServeDate(latest.Format(huds.ServeDateLayout), dateFormat)}
+		respondErrorDetails(c, http.StatusBadRequest, CodeInvalidRequest, "serve_date is within the published menus; use /huds-data", details)
+		return
+	}
+	cycle, err := s.loadMenuCycle()
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	if cycle == nil {
+		respondError(c, http.StatusNotFound, CodeNotFound, "no repeating menu cycle found to predict from")
+		return
+	}
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	basis, err := s.cycleBasis(ctx, cycle, date)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	if len(basis) == 0 {
+		respondError(c, http.StatusNotFound, CodeNotFound, "no stored menus at this point of the cycle")
+		return
+	}
+
+	serveDate := date.Format(huds.ServeDateLayout)
+	predicted := predictMenu(serveDate, basis)
+	var annenbergs []huds.CondensedMenu
+	for _, menu := range basis {
+		if menu.Annenberg != nil {
+			annenbergs = append(annenbergs, menu.Annenberg.Menu(menu.ServeDate))
+		}
+	}
+	if len(annenbergs) > 0 {
+		annenberg := huds.LocationMenuOf(basis[0].Annenberg.Name, predictMenu(serveDate, annenbergs))
+		predicted.Annenberg = &annenberg
+	}
+	basedOn := make([]string, len(basis))
+	for i, menu := range basis {
+		basedOn[i] = formatServeDate(menu.ServeDate, dateFormat)
+	}
+
+	menu := DatedMenu{withItemOptions(c, predicted), dateFormat, itemFields(c), itemGroupBy(c), splitHalls(c)}
+	respond(c, http.StatusOK, PredictedMenu{menu, cycle.Similarity, basedOn}, ResponseMeta{ServeDate: formatServeDate(serveDate, dateFormat), Source: SourcePrediction})
+}
+
+// cycleBasis returns the stored menus at the same point of the cycle as date
+// in up to predictionCycles past cycles, latest first.
+func (s *Server) cycleBasis(ctx context.Context, cycle *MenuCycle, date time.Time) ([]huds.CondensedMenu, error) {
+	_, latest := s.recordRange()
+	day := date
+	for day.After(latest) {
+		day = day.AddDate(0, 0, -cycle.LengthDays)
+	}
+	var basis []huds.CondensedMenu
+	for i := 0; i < predictionCycles; i++ {
+		menu, err := s.menuByDate(ctx, day.Format(huds.ServeDateLayout))
+		if err == nil && len(huds.MealItems(menu, "dinner")) > 0 {
+			basis = append(basis, menu)
+		} else if err != nil && err != store.ErrMenuNotFound {
+			return nil, err
+		}
+		day = day.AddDate(0, 0, -cycle.LengthDays)
+	}
+	return basis, nil
+}
+
+// predictMenu keeps, at each meal, the items served on at least
+// minPredictionShare of the basis menus, as they were last served and in the
+// order they were, with the share as their confidence.
+func predictMenu(date string, basis []huds.CondensedMenu) huds.CondensedMenu {
+	type candidate struct {
+		item  huds.CondensedMenuItem
+		count int
+	}
+	predicted := make(map[int][]huds.CondensedMenuItem)
+	numbers := make(map[int]bool)
+	byMeal := make([]map[int][]huds.CondensedMenuItem, len(basis))
+	for i, menu := range basis {
+		byMeal[i] = huds.MealsFromMenu(menu)
+		for number := range byMeal[i] {
+			numbers[number] = true
+		}
+	}
+	for number := range numbers {
+		var order []string
+		candidates := make(map[string]*candidate)
+		for _, meals := range byMeal {
+			counted := make(map[string]bool)
+			for _, item := range meals[number] {
+				key := dedupeKeyOf(item)
+				if counted[key] {
+					continue
+				}
+				counted[key] = true
+				if found, ok := candidates[key]; ok {
+					found.count++
+					continue
+				}
+				order = append(order, key)
+				candidates[key] = &candidate{item: item, count: 1}
+			}
+		}
+		items := []huds.CondensedMenuItem{}
+		for _, key := range order {
+			share := float64(candidates[key].count) / float64(len(basis))
+			if share < minPredictionShare {
+				continue
+			}
+			item := candidates[key].item
+			item.Confidence = math.Round(share*1000) / 1000
+			items = append(items, item)
+		}
+		predicted[number] = items
+	}
+	return huds.MenuFromMeals(date, predicted)
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// DietaryProfile describes what a user can or wants to eat. Allergens and
+// categories are matched case-insensitively.
+type DietaryProfile struct {
+	Vegan              bool     `json:"vegan" bson:"vegan"`
+	Vegetarian         bool     `json:"vegetarian" bson:"vegetarian"`
+	AvoidAllergens     []string `json:"avoid_allergens" bson:"avoid_allergens"`
+	DislikedCategories []string `json:"disliked_categories" bson:"disliked_categories"`
+}
+
+// Allows reports whether an item fits the profile.
+func (p DietaryProfile) Allows(item huds.CondensedMenuItem) bool {
+	if len(p.Unsafe(item)) > 0 {
+		return false
+	}
+	for _, category := range p.DislikedCategories {
+		if strings.EqualFold(strings.TrimSpace(category), strings.TrimSpace(item.MenuCategory)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Unsafe returns why an item isn't safe for the profile: a diet it doesn't
+// follow, or each allergen it lists or its ingredients suggest that the
+// profile avoids. An avoided allergen matches any allergen containing it, so
+// "nut" rules out Tree Nuts.
+func (p DietaryProfile) Unsafe(item huds.CondensedMenuItem) []string {
+	var reasons []string
+	if p.Vegan && !item.Vegan {
+		reasons = append(reasons, "not vegan")
+	}
+	if p.Vegetarian && !item.Vegetarian && !item.Vegan {
+		reasons = append(reasons, "not vegetarian")
+	}
+	for _, listed := range huds.Allergens(item.Allergens) {
+		if p.avoids(listed) {
+			reasons = append(reasons, "contains "+listed)
+		}
+	}
+	for _, derived := range huds.ItemDerivedAllergens(item) {
+		if !p.avoids(derived.Allergen) {
+			continue
+		}
+		if derived.Confidence == huds.ConfidenceHigh {
+			reasons = append(reasons, "contains "+derived.Allergen+" ("+derived.Ingredient+")")
+		} else {
+			reasons = append(reasons, "may contain "+derived.Allergen+" ("+derived.Ingredient+")")
+		}
+	}
+	return reasons
+}
+
+func (p DietaryProfile) avoids(allergen string) bool {
+	for _, avoided := range p.AvoidAllergens {
+		avoided = strings.ToLower(strings.TrimSpace(avoided))
+		if avoided != "" && strings.Contains(strings.ToLower(allergen), avoided) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter returns a copy of the menu holding only the items the profile allows.
+func (p DietaryProfile) Filter(menu huds.CondensedMenu) huds.CondensedMenu {
+	return huds.CondensedMenu{
+		ServeDate: menu.ServeDate,
+		Breakfast: p.filterItems(menu.Breakfast),
+		Lunch:     p.filterItems(menu.Lunch),
+		Dinner:    p.filterItems(menu.Dinner),
+	}
+}
+
+func (p DietaryProfile) filterItems(items []huds.CondensedMenuItem) []huds.CondensedMenuItem {
+	filtered := []huds.CondensedMenuItem{}
+	for _, item := range items {
+		if p.Allows(item) {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered
+}
+
+func (s *Server) profileRoutes(r gin.IRouter) {
+	me := r.Group("/me", s.requireUser)
+	me.GET("/profile", handleGetProfile)
+	me.PUT("/profile", s.handleSetProfile)
+	me.GET("/menu", s.bindServeDate, bindItemFields, s.handleMyMenu)
+	me.GET("/safe-menu", s.bindServeDate, bindItemFields, s.handleSafeMenu)
+}
+
+func handleGetProfile(c *gin.Context) {
+	respond(c, http.StatusOK, currentUser(c).Profile, ResponseMeta{})
+}
+
+func (s *Server) handleSetProfile(c *gin.Context) {
+	var profile DietaryProfile
+	if err := c.ShouldBindJSON(&profile); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid profile")
+		return
+	}
+	if profile.AvoidAllergens == nil {
+		profile.AvoidAllergens = []string{}
+	}
+	if profile.DislikedCategories == nil {
+		profile.DislikedCategories = []string{}
+	}
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	_, err := s.users.UpdateOne(ctx, bson.M{"_id": currentUser(c).ID}, bson.M{"$set": bson.M{"profile": profile}})
+	if err != nil {
+		log.Printf("Failed to save profile: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to save profile")
+		return
+	}
+	respond(c, http.StatusOK, profile, ResponseMeta{})
+}
+
+// handleMyMenu returns the day's menu with everything the user's profile rules
+// out already removed.
+func (s *Server) handleMyMenu(c *gin.Context) {
+	serveDate := serveDateParam(c).Format(huds.ServeDateLayout)
+	dateFormat := c.DefaultQuery("date_format", s.dateFormat)
+	if !validDateFormat(dateFormat) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "date_format must be 'us' or 'iso'")
+		return
+	}
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	menu, err := s.readMenu(ctx, serveDate)
+	if err == store.ErrMenuNotFound {
+		respondError(c, http.StatusNotFound, CodeNotFound, "no menu for this date")
+		return
+	}
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	filtered := mapHalls(menu, func(menu huds.CondensedMenu) huds.CondensedMenu {
+		return withIncludes(c, currentUser(c).Profile.Filter(menu))
+	})
+	respond(c, http.StatusOK, DatedMenu{filtered, dateFormat, itemFields(c), "", splitHalls(c)}, s.menuMeta(menu, SourceDB, dateFormat))
+}
+
+// SafeMenu is a day's menu with only the items safe for a user's profile,
+// and every item left out with why.
+type SafeMenu struct {
+	Menu     DatedMenu      `json:"menu"`
+	Excluded []ExcludedItem `json:"excluded"`
+}
+
+type ExcludedItem struct {
+	Meal     string   `json:"meal"`
+	FoodName string   `json:"food_name"`
+	Reasons  []string `json:"reasons"`
+}
+
+// handleSafeMenu serves /me/safe-menu: the day's menu without the items the
+// user's diet or allergens rule out. Unlike /me/menu, disliked categories are
+// kept, since they are a preference rather than a safety concern.
+func (s *Server) handleSafeMenu(c *gin.Context) {
+	serveDate := serveDateParam(c).Format(huds.ServeDateLayout)
+	dateFormat := c.DefaultQuery("date_format", s.dateFormat)
+	if !validDateFormat(dateFormat) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "date_format must be 'us' or 'iso'")
+		return
+	}
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	menu, err := s.readMenu(ctx, serveDate)
+	if err == store.ErrMenuNotFound {
+		respondError(c, http.StatusNotFound, CodeNotFound, "no menu for this date")
+		return
+	}
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	profile := currentUser(c).Profile
+	excluded := []ExcludedItem{}
+	seen := make(map[string]bool)
+	safe := mapHalls(menu, func(menu huds.CondensedMenu) huds.CondensedMenu {
+		for _, meal := range menu.Meals() {
+			for _, item := range huds.MealItems(menu, meal) {
+				reasons := profile.Unsafe(item)
+				if len(reasons) == 0 || seen[meal+"\x00"+item.FoodName] {
+					continue
+				}
+				seen[meal+"\x00"+item.FoodName] = true
+				excluded = append(excluded, ExcludedItem{Meal: meal, FoodName: item.FoodName, Reasons: reasons})
+			}
+		}
+		menu = menu.MapMeals(func(items []huds.CondensedMenuItem) []huds.CondensedMenuItem {
+			if items == nil {
+				return nil
+			}
+			kept := []huds.CondensedMenuItem{}
+			for _, item := range items {
+				if len(profile.Unsafe(item)) == 0 {
+					kept = append(kept, item)
+				}
+			}
+			return kept
+		})
+		return withIncludes(c, menu)
+	})
+	body := SafeMenu{DatedMenu{safe, dateFormat, itemFields(c), "", splitHalls(c)}, excluded}
+	respond(c, http.StatusOK, body, s.menuMeta(menu, SourceDB, dateFormat))
+}
@@ -0,0 +1,503 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"hudsgry-api/internal/huds"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	PlatformFCM  = "fcm"
+	PlatformAPNs = "apns"
+)
+
+type Device struct {
+	Token      string    `json:"token" bson:"_id"`
+	Platform   string    `json:"platform" bson:"platform"`
+	MenuAlerts bool      `json:"menu_alerts" bson:"menu_alerts"`
+	Favorites  []string  `json:"favorites" bson:"favorites"`
+	CreatedAt  time.Time `json:"created_at" bson:"created_at"`
+}
+
+type DeviceRegistration struct {
+	Token      string   `json:"token" binding:"required"`
+	Platform   string   `json:"platform" binding:"required"`
+	MenuAlerts *bool    `json:"menu_alerts"`
+	Favorites  []string `json:"favorites"`
+}
+
+type PushService struct {
+	server  *Server
+	devices *mongo.Collection
+	alerts  *mongo.Collection
+	state   *mongo.Collection
+}
+
+// startPushNotifications configures whichever of FCM and APNs have
+// credentials, registers the device endpoints, and hooks into data refreshes.
+func (s *Server) startPushNotifications() {
+	if file := os.Getenv("FCM_CREDENTIALS_FILE"); file != "" {
+		fcm, err := newFCMNotifier(file)
+		if err != nil {
+			log.Printf("Failed to configure FCM: %v\n", err)
+		} else {
+			s.registerNotifier(fcm)
+		}
+	}
+	if file := os.Getenv("APNS_KEY_FILE"); file != "" {
+		apns, err := newAPNsNotifier(file)
+		if err != nil {
+			log.Printf("Failed to configure APNs: %v\n", err)
+		} else {
+			s.registerNotifier(apns)
+		}
+	}
+
+	s.push = &PushService{
+		server:  s,
+		devices: s.db.Collection("push_devices"),
+		alerts:  s.db.Collection("push_alerts"),
+		state:   s.db.Collection("push_state"),
+	}
+	s.afterRefreshHooks = append(s.afterRefreshHooks, s.push.notifyAfterRefresh)
+}
+
+func (p *PushService) routes(r gin.IRouter) {
+	r.POST("/devices", p.handleRegister)
+	r.DELETE("/devices/:token", p.handleUnregister)
+}
+
+func (p *PushService) handleRegister(c *gin.Context) {
+	var req DeviceRegistration
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "token and platform are required")
+		return
+	}
+	if _, ok := p.server.notifiers[req.Platform]; !ok || (req.Platform != PlatformFCM && req.Platform != PlatformAPNs) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "platform must be a configured push platform (fcm or apns)")
+		return
+	}
+
+	menuAlerts := true
+	if req.MenuAlerts != nil {
+		menuAlerts = *req.MenuAlerts
+	}
+	update := bson.M{
+		"$set":         bson.M{"platform": req.Platform, "menu_alerts": menuAlerts, "favorites": req.Favorites},
+		"$setOnInsert": bson.M{"created_at": p.server.clock.Now()},
+	}
+	ctx, cancel := p.server.dbContext(c.Request.Context())
+	defer cancel()
+	_, err := p.devices.UpdateOne(ctx, bson.M{"_id": req.Token}, update, options.Update().SetUpsert(true))
+	if err != nil {
+		log.Printf("Failed to register device: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to register device")
+		return
+	}
+
+	respond(c, http.StatusOK, Device{Token: req.Token, Platform: req.Platform, MenuAlerts: menuAlerts, Favorites: req.Favorites}, ResponseMeta{})
+}
+
+func (p *PushService) handleUnregister(c *gin.Context) {
+	ctx, cancel := p.server.dbContext(c.Request.Context())
+	defer cancel()
+	if _, err := p.devices.DeleteOne(ctx, bson.M{"_id": c.Param("token")}); err != nil {
+		log.Printf("Failed to unregister device: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to unregister device")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// notifyAfterRefresh pushes "today's menu is up" once per day and alerts each
+// device about tracked foods on any newly stored menu, never twice for the
+// same food on the same day.
+func (p *PushService) notifyAfterRefresh(ctx context.Context, data map[string]map[int][]huds.CondensedMenuItem) {
+	cursor, err := p.devices.Find(ctx, bson.D{})
+	if err != nil {
+		log.Printf("Failed to load push devices: %v\n", err)
+		return
+	}
+	var devices []Device
+	if err := cursor.All(ctx, &devices); err != nil {
+		log.Printf("Failed to decode push devices: %v\n", err)
+		return
+	}
+
+	currentDate := p.server.today()
+	if _, published := data[currentDate]; published && p.markPublished(ctx, currentDate) {
+		for _, device := range devices {
+			if device.MenuAlerts {
+				p.push(ctx, device, "Today's HUDS menu is up. Tap to see what's for lunch and dinner.")
+			}
+		}
+	}
+
+	startOfToday, _ := time.Parse(huds.ServeDateLayout, currentDate)
+	for date, meals := range data {
+		// Nobody needs an alert about a meal that has already been served
+		if served, err := time.Parse(huds.ServeDateLayout, date); err != nil || served.Before(startOfToday) {
+			continue
+		}
+		menu := huds.MenuFromMeals(date, meals)
+		for _, device := range devices {
+			for _, match := range favoriteMatches(menu, device.Favorites) {
+				if p.markAlerted(ctx, device.Token, date, match.String()) {
+					p.push(ctx, device, fmt.Sprintf("%s is on the menu %s.", match, date))
+				}
+			}
+		}
+	}
+}
+
+// markPublished records that the published notification went out for date and
+// reports whether this call was the first to do so.
+func (p *PushService) markPublished(ctx context.Context, date string) bool {
+	result, err := p.state.UpdateOne(ctx,
+		bson.M{"_id": "published", "date": bson.M{"$ne": date}},
+		bson.M{"$set": bson.M{"date": date}})
+	if err != nil {
+		log.Printf("Failed to record published notification: %v\n", err)
+		return false
+	}
+	if result.MatchedCount == 1 {
+		return true
+	}
+	// First run ever: there is no state document yet
+	_, err = p.state.InsertOne(ctx, bson.M{"_id": "published", "date": date})
+	return err == nil
+}
+
+func (p *PushService) markAlerted(ctx context.Context, token string, date string, match string) bool {
+	_, err := p.alerts.InsertOne(ctx, bson.M{"_id": token + "|" + date + "|" + match, "sent_at": p.server.clock.Now()})
+	return err == nil
+}
+
+func (p *PushService) push(ctx context.Context, device Device, message string) {
+	notifier, ok := p.server.notifiers[device.Platform]
+	if !ok {
+		return
+	}
+	err := notifier.Notify(device.Token, message)
+	if errors.Is(err, errDeviceUnregistered) {
+		// The app was uninstalled or the token rotated; stop pushing to it
+		_, _ = p.devices.DeleteOne(ctx, bson.M{"_id": device.Token})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to push to %s device: %v\n", device.Platform, err)
+	}
+}
+
+var errDeviceUnregistered = errors.New("device token is no longer registered")
+
+const pushTitle = "HUDS"
+
+// FCMNotifier sends through the Firebase Cloud Messaging HTTP v1 API using a
+// service account's OAuth2 credentials.
+type FCMNotifier struct {
+	projectID   string
+	clientEmail string
+	tokenUri    string
+	key         *rsa.PrivateKey
+	httpClient  *http.Client
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+func newFCMNotifier(credentialsFile string) (*FCMNotifier, error) {
+	data, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	var creds struct {
+		ProjectID   string `json:"project_id"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenUri    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKey([]byte(creds.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("FCM private key is not an RSA key")
+	}
+	if creds.TokenUri == "" {
+		creds.TokenUri = "https://oauth2.googleapis.com/token"
+	}
+	return &FCMNotifier{
+		projectID:   creds.ProjectID,
+		clientEmail: creds.ClientEmail,
+		tokenUri:    creds.TokenUri,
+		key:         rsaKey,
+		httpClient:  &http.Client{Timeout: 15 * time.Second},
+	}, nil
+}
+
+func (f *FCMNotifier) Channel() string {
+	return PlatformFCM
+}
+
+func (f *FCMNotifier) Notify(token string, message string) error {
+	accessToken, err := f.token()
+	if err != nil {
+		return err
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"message": map[string]interface{}{
+			"token":        token,
+			"notification": map[string]string{"title": pushTitle, "body": message},
+		},
+	})
+	req, err := http.NewRequest("POST", "https://fcm.googleapis.com/v1/projects/"+f.projectID+"/messages:send", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errDeviceUnregistered
+	}
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("fcm returned %d: %s", resp.StatusCode, detail)
+	}
+	return nil
+}
+
+// token exchanges a signed service-account assertion for an access token,
+// reusing it until shortly before it expires.
+func (f *FCMNotifier) token() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.accessToken != "" && time.Now().Before(f.expiresAt.Add(-time.Minute)) {
+		return f.accessToken, nil
+	}
+
+	now := time.Now()
+	assertion, err := signJWT(map[string]string{"alg": "RS256", "typ": "JWT"}, map[string]interface{}{
+		"iss":   f.clientEmail,
+		"scope": "https://www.googleapis.com/auth/firebase.messaging",
+		"aud":   f.tokenUri,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}, f.key)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := f.httpClient.PostForm(f.tokenUri, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if result.AccessToken == "" {
+		return "", fmt.Errorf("google token endpoint returned %d without an access token", resp.StatusCode)
+	}
+	f.accessToken = result.AccessToken
+	f.expiresAt = now.Add(time.Duration(result.ExpiresIn) * time.Second)
+	return f.accessToken, nil
+}
+
+// APNsNotifier sends through Apple's token-based provider API.
+type APNsNotifier struct {
+	keyID      string
+	teamID     string
+	topic      string
+	host       string
+	key        *ecdsa.PrivateKey
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	jwt      string
+	issuedAt time.Time
+}
+
+func newAPNsNotifier(keyFile string) (*APNsNotifier, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("APNs key is not an ECDSA key")
+	}
+	host := "https://api.push.apple.com"
+	if os.Getenv("APNS_SANDBOX") == "true" {
+		host = "https://api.sandbox.push.apple.com"
+	}
+	return &APNsNotifier{
+		keyID:      os.Getenv("APNS_KEY_ID"),
+		teamID:     os.Getenv("APNS_TEAM_ID"),
+		topic:      os.Getenv("APNS_TOPIC"),
+		host:       host,
+		key:        ecKey,
+		httpClient: &http.Client{Timeout: 15 * time.Second},
+	}, nil
+}
+
+func (a *APNsNotifier) Channel() string {
+	return PlatformAPNs
+}
+
+func (a *APNsNotifier) Notify(token string, message string) error {
+	bearer, err := a.token()
+	if err != nil {
+		return err
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"aps": map[string]interface{}{
+			"alert": map[string]string{"title": pushTitle, "body": message},
+			"sound": "default",
+		},
+	})
+	req, err := http.NewRequest("POST", a.host+"/3/device/"+token, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "bearer "+bearer)
+	req.Header.Set("apns-topic", a.topic)
+	req.Header.Set("apns-push-type", "alert")
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone {
+		return errDeviceUnregistered
+	}
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("apns returned %d: %s", resp.StatusCode, detail)
+	}
+	return nil
+}
+
+// token returns the provider JWT. Apple rejects tokens older than an hour and
+// throttles ones refreshed more often than every 20 minutes.
+func (a *APNsNotifier) token() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.jwt != "" && time.Since(a.issuedAt) < 30*time.Minute {
+		return a.jwt, nil
+	}
+	now := time.Now()
+	signed, err := signJWT(map[string]string{"alg": "ES256", "kid": a.keyID},
+		map[string]interface{}{"iss": a.teamID, "iat": now.Unix()}, a.key)
+	if err != nil {
+		return "", err
+	}
+	a.jwt, a.issuedAt = signed, now
+	return a.jwt, nil
+}
+
+func parsePrivateKey(data []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found in private key")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+// signJWT builds a compact JWS with an RS256 or ES256 signature, or HS256
+// when the key is a shared secret.
+func signJWT(header map[string]string, claims map[string]interface{}, key crypto.PrivateKey) (string, error) {
+	headerJson, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJson, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJson) + "." + base64.RawURLEncoding.EncodeToString(claimsJson)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		if err != nil {
+			return "", err
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			return "", err
+		}
+		// JWS wants the fixed-width r || s form, not ASN.1
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	default:
+		return "", fmt.Errorf("unsupported signing key type %T", key)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,473 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"hudsgry-api/internal/scheduler"
+	"hudsgry-api/internal/store"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// anonymousTier limits requests sent without an API key, per IP address
+	anonymousTier = "anonymous"
+	// registeredTier is the tier new API keys start on
+	registeredTier             = "registered"
+	defaultAnonymousDailyQuota = 1000
+	// anonymousUsageDays is how long anonymous usage, which records IP
+	// addresses, is kept
+	anonymousUsageDays = 30
+)
+
+var tierNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// quotaExemptRoutes are served without counting against the anonymous quota:
+// the readiness check load balancers poll, the menu app and playground pages,
+// whose own requests are counted, and the callbacks providers make from a few
+// shared addresses without a key, which would otherwise run out the quota and
+// break the integrations. They are matched under any version prefix.
+var quotaExemptRoutes = map[string]bool{
+	"/":                                      true,
+	"/ready":                                 true,
+	"/openapi.json":                          true,
+	"/playground":                            true,
+	"/alexa":                                 true,
+	"/telegram/webhook":                      true,
+	"/groupme/callback":                      true,
+	"/sms/inbound":                           true,
+	"/auth/oidc/callback":                    true,
+	"/integrations/google-calendar/callback": true,
+}
+
+// QuotaTier is a named daily quota. Requests without an API key are held to
+// the anonymous tier by IP address, new keys start on the registered tier,
+// and admins can add tiers, e.g. for partner apps, and move keys onto them.
+type QuotaTier struct {
+	Name string `json:"name" bson:"_id"`
+	// DailyQuota is how many requests a day, counted in the service's
+	// timezone, each key or anonymous IP address on the tier may make. 0 is
+	// unlimited.
+	DailyQuota  int        `json:"daily_quota" bson:"daily_quota"`
+	Description string     `json:"description,omitempty" bson:"description,omitempty"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	// Builtin tiers, anonymous and registered, can't be deleted
+	Builtin bool `json:"builtin" bson:"-"`
+	// Keys counts the API keys on the tier, in the admin listing
+	Keys int `json:"keys" bson:"-"`
+}
+
+// quotas holds the tiers and the anonymous request counters not yet flushed
+// to Mongo, with each IP address's requests so far today.
+type quotas struct {
+	sync.Mutex
+	// defaults are the builtin tiers' configured quotas, used until an admin
+	// sets their own
+	defaults  map[string]int
+	tiers     map[string]QuotaTier
+	pending   map[string]*anonymousCounter
+	day       string
+	anonymous map[string]int
+}
+
+type anonymousCounter struct {
+	ip       string
+	day      string
+	requests int
+	rejected int
+}
+
+type AnonymousUsageDocument struct {
+	IP        string    `bson:"ip"`
+	Day       string    `bson:"day"`
+	Requests  int       `bson:"requests"`
+	Rejected  int       `bson:"rejected"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// setupQuotas loads the quota tiers and starts flushing anonymous usage once
+// a minute, when the tiers are also reloaded to pick up other replicas'
+// changes. Anonymous requests get ANONYMOUS_DAILY_QUOTA a day per IP address,
+// 1000 by default, and the registered tier API_KEY_DAILY_QUOTA, until an
+// admin changes them. Quota state lives in Mongo, so it survives restarts and
+// is shared between replicas to within the minute between flushes.
+func (s *Server) setupQuotas(jobs scheduler.Scheduler) error {
+	anonymous := defaultAnonymousDailyQuota
+	if v := os.Getenv("ANONYMOUS_DAILY_QUOTA"); v != "" {
+		quota, err := strconv.Atoi(v)
+		if err != nil || quota < 0 {
+			return fmt.Errorf("ANONYMOUS_DAILY_QUOTA must be a non-negative integer, got %q", v)
+		}
+		anonymous = quota
+	}
+	s.quotas.defaults = map[string]int{anonymousTier: anonymous, registeredTier: s.keyUsage.defaultQuota}
+	s.quotas.pending = make(map[string]*anonymousCounter)
+	s.quotas.anonymous = make(map[string]int)
+	s.quotaTiers = s.db.Collection("quota_tiers")
+	s.anonymousUsage = s.db.Collection("anonymous_usage")
+
+	s.ensureIndexes("quota",
+		store.IndexSpec{Collection: "anonymous_usage", Model: mongo.IndexModel{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		}},
+		store.IndexSpec{Collection: "api_keys", Model: mongo.IndexModel{
+			Keys: bson.D{{Key: "tier", Value: 1}},
+		}},
+	)
+	ctx, cancel := s.jobContext()
+	defer cancel()
+	if err := s.loadQuotaTiers(ctx); err != nil {
+		// The configured defaults apply until the next reload succeeds
+		log.Printf("Failed to load quota tiers: %v\n", err)
+	}
+	if _, err := jobs.AddFunc("* * * * *", s.recoverJob("quotas", s.flushQuotas)); err != nil {
+		log.Printf("Failed to schedule quota flush: %v\n", err)
+	}
+	return nil
+}
+
+// loadQuotaTiers reads the tiers admins have set over the builtin defaults.
+func (s *Server) loadQuotaTiers(ctx context.Context) error {
+	tiers := make(map[string]QuotaTier, len(s.quotas.defaults))
+	for name, quota := range s.quotas.defaults {
+		tiers[name] = QuotaTier{Name: name, DailyQuota: quota, Builtin: true}
+	}
+	var stored []QuotaTier
+	cursor, err := s.quotaTiers.Find(ctx, bson.M{})
+	if err == nil {
+		err = cursor.All(ctx, &stored)
+	}
+	for _, tier := range stored {
+		_, tier.Builtin = s.quotas.defaults[tier.Name]
+		tiers[tier.Name] = tier
+	}
+	s.quotas.Lock()
+	defer s.quotas.Unlock()
+	// Keep what was loaded last rather than falling back to the defaults
+	if err != nil && s.quotas.tiers != nil {
+		return err
+	}
+	s.quotas.tiers = tiers
+	return err
+}
+
+// tierQuota returns a tier's daily quota. Keys on a tier that no longer
+// exists get the registered tier's.
+func (s *Server) tierQuota(name string) int {
+	s.quotas.Lock()
+	defer s.quotas.Unlock()
+	tier, ok := s.quotas.tiers[name]
+	if !ok {
+		tier = s.quotas.tiers[registeredTier]
+	}
+	return tier.DailyQuota
+}
+
+// keyQuota returns a key's daily quota: its tier's, or its own if it has
+// none, as keys made before tiers don't.
+func (s *Server) keyQuota(key APIKey) int {
+	if key.Tier == "" {
+		return key.DailyQuota
+	}
+	return s.tierQuota(key.Tier)
+}
+
+// setRateLimitHeaders tells the client its daily quota, what is left of it
+// after this request and when it resets, as a Unix time, reporting whether
+// the request is within the quota.
+func setRateLimitHeaders(c *gin.Context, quota int, used int, now time.Time) (time.Time, bool) {
+	year, month, date := now.Date()
+	resets := time.Date(year, month, date+1, 0, 0, 0, 0, now.Location())
+	c.Header("X-RateLimit-Limit", strconv.Itoa(quota))
+	c.Header("X-RateLimit-Reset", strconv.FormatInt(resets.Unix(), 10))
+	if used >= quota {
+		c.Header("X-RateLimit-Remaining", "0")
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(resets.Sub(now).Seconds()))))
+		return resets, false
+	}
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(quota-used-1))
+	return resets, true
+}
+
+// limitAnonymous holds requests without an API key to the anonymous tier's
+// quota per IP address. Admin requests aren't counted. If the count can't be
+// loaded the request is served, as the menus are served while the store is
+// down. Requests that aren't counted get no X-RateLimit headers, as
+// documented in openapi.json.
+func (s *Server) limitAnonymous(c *gin.Context) {
+	quota := s.tierQuota(anonymousTier)
+	if quota == 0 || quotaExemptRoutes[versionPrefix.ReplaceAllString(c.FullPath(), "")] || s.isAdminRequest(c) {
+		c.Next()
+		return
+	}
+	ip := c.ClientIP()
+	now := s.localNow()
+	day := now.Format("2006-01-02")
+	ctx, cancel := s.dbContext(c.Request.Context())
+	used, err := s.anonymousRequestsToday(ctx, ip, day)
+	cancel()
+	if err != nil {
+		log.Printf("Failed to count anonymous usage: %v\n", err)
+		c.Next()
+		return
+	}
+	resets, ok := setRateLimitHeaders(c, quota, used, now)
+	if !ok {
+		respondErrorDetails(c, http.StatusTooManyRequests, CodeQuotaExceeded, "requests without an API key have used this address's daily quota; send an API key in the X-API-Key header for a larger one", gin.H{
+			"tier":        anonymousTier,
+			"daily_quota": quota,
+			"resets_at":   resets,
+		})
+		s.countAnonymousRequest(ip, day, false)
+		return
+	}
+	c.Next()
+	s.countAnonymousRequest(ip, day, true)
+}
+
+// isAdminRequest reports whether the request carries the admin token.
+func (s *Server) isAdminRequest(c *gin.Context) bool {
+	token := bearerToken(c)
+	return s.adminToken != "" && token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) == 1
+}
+
+// anonymousRequestsToday returns how many requests an IP address has made
+// without a key on day, loading the flushed count from Mongo the first time
+// the address is seen that day.
+func (s *Server) anonymousRequestsToday(ctx context.Context, ip string, day string) (int, error) {
+	s.quotas.Lock()
+	if s.quotas.day == day {
+		if used, ok := s.quotas.anonymous[ip]; ok {
+			s.quotas.Unlock()
+			return used, nil
+		}
+	}
+	s.quotas.Unlock()
+
+	var doc AnonymousUsageDocument
+	err := s.anonymousUsage.FindOne(ctx, bson.M{"_id": ip + "|" + day}).Decode(&doc)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return 0, err
+	}
+
+	s.quotas.Lock()
+	defer s.quotas.Unlock()
+	if s.quotas.day != day {
+		s.quotas.day = day
+		s.quotas.anonymous = make(map[string]int)
+	}
+	used, ok := s.quotas.anonymous[ip]
+	if !ok {
+		// Pending counters haven't been flushed, so aren't in doc
+		used = doc.Requests
+		if counter, ok := s.quotas.pending[ip+"|"+day]; ok {
+			used += counter.requests
+		}
+		s.quotas.anonymous[ip] = used
+	}
+	return used, nil
+}
+
+func (s *Server) countAnonymousRequest(ip string, day string, accepted bool) {
+	s.quotas.Lock()
+	defer s.quotas.Unlock()
+	id := ip + "|" + day
+	counter, ok := s.quotas.pending[id]
+	if !ok {
+		counter = &anonymousCounter{ip: ip, day: day}
+		s.quotas.pending[id] = counter
+	}
+	if !accepted {
+		counter.rejected++
+		return
+	}
+	counter.requests++
+	if s.quotas.day == day {
+		s.quotas.anonymous[ip]++
+	}
+}
+
+// flushQuotas writes the anonymous counters and reloads the tiers.
+func (s *Server) flushQuotas() {
+	s.quotas.Lock()
+	pending := s.quotas.pending
+	s.quotas.pending = make(map[string]*anonymousCounter)
+	s.quotas.Unlock()
+
+	ctx, cancel := s.jobContext()
+	defer cancel()
+	for id, counter := range pending {
+		expires := s.localNow().AddDate(0, 0, anonymousUsageDays)
+		_, err := s.anonymousUsage.UpdateOne(ctx, bson.M{"_id": id},
+			bson.M{"$inc": bson.M{"requests": counter.requests, "rejected": counter.rejected},
+				"$set": bson.M{"ip": counter.ip, "day": counter.day, "expires_at": expires}},
+			options.Update().SetUpsert(true))
+		if err != nil {
+			log.Printf("Failed to flush anonymous usage for %s: %v\n", id, err)
+		}
+	}
+	if err := s.loadQuotaTiers(ctx); err != nil {
+		log.Printf("Failed to reload quota tiers: %v\n", err)
+	}
+}
+
+func (s *Server) quotaRoutes(r gin.IRouter) {
+	if s.adminToken == "" {
+		return
+	}
+	r.GET("/admin/tiers", s.requireAdmin, s.handleAdminListTiers)
+	r.PUT("/admin/tiers/:name", s.requireAdmin, s.handleAdminPutTier)
+	r.DELETE("/admin/tiers/:name", s.requireAdmin, s.handleAdminDeleteTier)
+	r.PUT("/admin/keys/:id/tier", s.requireAdmin, s.handleAdminSetKeyTier)
+}
+
+// handleAdminListTiers lists the tiers with how many keys are on each.
+func (s *Server) handleAdminListTiers(c *gin.Context) {
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	cursor, err := s.apiKeys.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"tier": bson.M{"$exists": true}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$tier", "keys": bson.M{"$sum": 1}}}},
+	})
+	var counts []struct {
+		Tier string `bson:"_id"`
+		Keys int    `bson:"keys"`
+	}
+	if err == nil {
+		err = cursor.All(ctx, &counts)
+	}
+	if err != nil {
+		log.Printf("Failed to count API keys by tier: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load tiers")
+		return
+	}
+	keys := make(map[string]int, len(counts))
+	for _, count := range counts {
+		keys[count.Tier] = count.Keys
+	}
+
+	s.quotas.Lock()
+	tiers := make([]QuotaTier, 0, len(s.quotas.tiers))
+	for _, tier := range s.quotas.tiers {
+		tier.Keys = keys[tier.Name]
+		tiers = append(tiers, tier)
+	}
+	s.quotas.Unlock()
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].Name < tiers[j].Name })
+	respond(c, http.StatusOK, gin.H{"tiers": tiers}, ResponseMeta{})
+}
+
+// handleAdminPutTier creates a tier or changes its quota, builtin tiers
+// included.
+func (s *Server) handleAdminPutTier(c *gin.Context) {
+	name := c.Param("name")
+	if !tierNamePattern.MatchString(name) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "tier names are 1 to 32 lowercase letters, digits, dashes or underscores")
+		return
+	}
+	var req struct {
+		DailyQuota  *int   `json:"daily_quota" binding:"required"`
+		Description string `json:"description"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || *req.DailyQuota < 0 {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "daily_quota must be a non-negative integer")
+		return
+	}
+	now := s.clock.Now()
+	tier := QuotaTier{Name: name, DailyQuota: *req.DailyQuota, Description: strings.TrimSpace(req.Description), UpdatedAt: &now}
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	if _, err := s.quotaTiers.ReplaceOne(ctx, bson.M{"_id": name}, tier, options.Replace().SetUpsert(true)); err != nil {
+		log.Printf("Failed to save quota tier: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to save tier")
+		return
+	}
+	if err := s.loadQuotaTiers(ctx); err != nil {
+		log.Printf("Failed to reload quota tiers: %v\n", err)
+	}
+	_, tier.Builtin = s.quotas.defaults[name]
+	respond(c, http.StatusOK, tier, ResponseMeta{})
+}
+
+// handleAdminDeleteTier deletes a tier no key is on. The builtin tiers can't
+// be deleted.
+func (s *Server) handleAdminDeleteTier(c *gin.Context) {
+	name := c.Param("name")
+	if _, builtin := s.quotas.defaults[name]; builtin {
+		respondError(c, http.StatusConflict, CodeConflict, "builtin tiers can't be deleted; set their quota instead")
+		return
+	}
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	keys, err := s.apiKeys.CountDocuments(ctx, bson.M{"tier": name})
+	if err != nil {
+		log.Printf("Failed to count API keys on tier: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to delete tier")
+		return
+	}
+	if keys > 0 {
+		respondErrorDetails(c, http.StatusConflict, CodeConflict, "API keys are still on this tier; move them first", gin.H{"keys": keys})
+		return
+	}
+	result, err := s.quotaTiers.DeleteOne(ctx, bson.M{"_id": name})
+	if err != nil {
+		log.Printf("Failed to delete quota tier: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to delete tier")
+		return
+	}
+	if result.DeletedCount == 0 {
+		respondError(c, http.StatusNotFound, CodeNotFound, "tier not found")
+		return
+	}
+	if err := s.loadQuotaTiers(ctx); err != nil {
+		log.Printf("Failed to reload quota tiers: %v\n", err)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// handleAdminSetKeyTier moves a key onto a tier, replacing any quota of its
+// own.
+func (s *Server) handleAdminSetKeyTier(c *gin.Context) {
+	var req struct {
+		Tier string `json:"tier" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "tier is required")
+		return
+	}
+	s.quotas.Lock()
+	_, exists := s.quotas.tiers[req.Tier]
+	s.quotas.Unlock()
+	if !exists || req.Tier == anonymousTier {
+		respondErrorDetails(c, http.StatusBadRequest, CodeInvalidRequest, "no such tier for API keys", gin.H{"tier": req.Tier})
+		return
+	}
+	key, ok := s.adminKey(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	if _, err := s.apiKeys.UpdateOne(ctx, bson.M{"_id": key.ID}, bson.M{"$set": bson.M{"tier": req.Tier}}); err != nil {
+		log.Printf("Failed to set API key tier: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to set tier")
+		return
+	}
+	key.Tier = req.Tier
+	key.DailyQuota = s.keyQuota(key)
+	respond(c, http.StatusOK, key, ResponseMeta{})
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"log"
+	"time"
+)
+
+// archiveRaw keeps the feed as fetched when the store can, so menus can be
+// rebuilt later. Failing to archive doesn't fail the refresh.
+func (s *Server) archiveRaw(ctx context.Context, items []huds.MenuItem) {
+	archive, ok := s.store.(store.RawArchive)
+	if !ok {
+		return
+	}
+	if err := archive.ArchiveRaw(ctx, s.clock.Now().UTC(), items); err != nil {
+		log.Printf("Failed to archive raw HUDS data: %v\n", err)
+	}
+}
+
+// Reprocess rebuilds the stored menus from start to end inclusive from the
+// raw archive, e.g. after the condensing logic changes.
+func (s *Server) Reprocess(start time.Time, end time.Time) error {
+	archive, ok := s.store.(store.RawArchive)
+	if !ok {
+		return fmt.Errorf("this storage backend doesn't archive raw HUDS data")
+	}
+	ctx, cancel := s.jobContext()
+	defer cancel()
+	dates, err := archive.RawDates(ctx, start, end)
+	if err != nil {
+		return err
+	}
+	_, err = s.rebuildFromRaw(ctx, archive, dates)
+	return err
+}
+
+// rebuildFromRaw condenses and stores the archived feed for each serve date,
+// skipping dates that were never archived, and returns how many were rebuilt.
+func (s *Server) rebuildFromRaw(ctx context.Context, archive store.RawArchive, dates []string) (int, error) {
+	var items []huds.MenuItem
+	rebuilt := 0
+	for _, date := range dates {
+		day, err := archive.GetRaw(ctx, date)
+		if err == store.ErrMenuNotFound {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read raw HUDS data for %s: %v", date, err)
+		}
+		items = append(items, day...)
+		rebuilt++
+	}
+	if len(items) == 0 {
+		return 0, nil
+	}
+	log.Printf("Reprocessing raw HUDS data for %d serve dates\n", rebuilt)
+	return rebuilt, s.condenseAndStore(ctx, items)
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"net/http"
+	"strings"
+)
+
+type Recipe struct {
+	RecipeNumber string                 `json:"Recipe_Number"`
+	Item         huds.CondensedMenuItem `json:"item"`
+	Nutrients    Nutrients              `json:"nutrients"`
+	// Per100g and Per100ml are Nutrients for 100g or 100ml of the recipe,
+	// left out when its serving size doesn't say how much a serving is
+	Per100g  *Nutrients `json:"per_100g,omitempty"`
+	Per100ml *Nutrients `json:"per_100ml,omitempty"`
+	// Served is every day the recipe was on a menu, oldest first
+	Served []store.Occurrence `json:"served"`
+}
+
+// nutritionSnapshot is a recipe's nutrition facts as served From one serve
+// date Until another.
+type nutritionSnapshot struct {
+	From        string
+	Until       string
+	ServingSize string
+	Nutrients   Nutrients
+}
+
+type RecipeNutritionHistory struct {
+	RecipeNumber string `json:"Recipe_Number"`
+	FoodName     string `json:"Food_Name"`
+	// Versions are the recipe's nutrition facts each time they changed,
+	// oldest first
+	Versions []NutritionVersion `json:"versions"`
+}
+
+type NutritionVersion struct {
+	// From and Until are the first and last serve dates with these facts
+	From        string    `json:"from"`
+	Until       string    `json:"until"`
+	ServingSize string    `json:"serving_size"`
+	Nutrients   Nutrients `json:"nutrients"`
+	// Changes are what differs from the version before, by nutrient or
+	// serving_size; empty for the first
+	Changes map[string]NutritionChange `json:"changes"`
+}
+
+type NutritionChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// recordNutrition notes the recipe's nutrition as served on serveDate, taking
+// a snapshot if it changed. Items without any nutrition facts are skipped, so
+// a day HUDS left them out doesn't count as a change.
+func (recipe *recipeHistory) recordNutrition(serveDate string, item huds.CondensedMenuItem) {
+	nutrients := itemNutrients(item)
+	if nutrients == (Nutrients{}) {
+		return
+	}
+	if last := len(recipe.Nutrition) - 1; last >= 0 && recipe.Nutrition[last].Nutrients == nutrients && recipe.Nutrition[last].ServingSize == item.ServingSize {
+		recipe.Nutrition[last].Until = serveDate
+		return
+	}
+	recipe.Nutrition = append(recipe.Nutrition, nutritionSnapshot{From: serveDate, Until: serveDate, ServingSize: item.ServingSize, Nutrients: nutrients})
+}
+
+// byName keys the nutrients by their JSON names.
+func (n Nutrients) byName() map[string]float64 {
+	return map[string]float64{
+		"calories":      n.Calories,
+		"total_fat":     n.TotalFat,
+		"sat_fat":       n.SatFat,
+		"trans_fat":     n.TransFat,
+		"cholesterol":   n.Cholesterol,
+		"sodium":        n.Sodium,
+		"total_carb":    n.TotalCarb,
+		"dietary_fiber": n.DietaryFiber,
+		"sugars":        n.Sugars,
+		"protein":       n.Protein,
+	}
+}
+
+// handleRecipe serves a recipe by its upstream recipe number, which unlike the
+// display name stays the same when HUDS renames a dish.
+func (s *Server) handleRecipe(c *gin.Context) {
+	number := strings.TrimSpace(c.Param("number"))
+	dateFormat := c.DefaultQuery("date_format", s.dateFormat)
+	if !validDateFormat(dateFormat) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "date_format must be 'us' or 'iso'")
+		return
+	}
+	catalog, err := s.loadCatalog()
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	history, ok := catalog.Recipes[number]
+	if !ok {
+		respondError(c, http.StatusNotFound, CodeNotFound, "recipe not found")
+		return
+	}
+
+	item := history.Item
+	if item.Nutrition == nil {
+		item.Nutrition = huds.ParseNutrition(item)
+	}
+	item.Serving = itemServing(item)
+	nutrients := itemNutrients(item)
+	per100g, per100ml := per100(nutrients, item.Serving)
+	served := make([]store.Occurrence, len(history.Served))
+	for i, occurrence := range history.Served {
+		served[i] = store.Occurrence{ServeDate: formatServeDate(occurrence.ServeDate, dateFormat), Meals: occurrence.Meals}
+	}
+	respond(c, http.StatusOK, Recipe{
+		RecipeNumber: number,
+		Item:         item,
+		Nutrients:    nutrients,
+		Per100g:      per100g,
+		Per100ml:     per100ml,
+		Served:       served,
+	}, ResponseMeta{Source: SourceDB})
+}
+
+// handleRecipeNutritionHistory serves /recipes/:number/nutrition-history:
+// every version of a recipe's nutrition facts, for seeing when HUDS
+// reformulated it.
+func (s *Server) handleRecipeNutritionHistory(c *gin.Context) {
+	number := strings.TrimSpace(c.Param("number"))
+	dateFormat := c.DefaultQuery("date_format", s.dateFormat)
+	if !validDateFormat(dateFormat) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "date_format must be 'us' or 'iso'")
+		return
+	}
+	catalog, err := s.loadCatalog()
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	history, ok := catalog.Recipes[number]
+	if !ok {
+		respondError(c, http.StatusNotFound, CodeNotFound, "recipe not found")
+		return
+	}
+
+	versions := make([]NutritionVersion, len(history.Nutrition))
+	for i, snapshot := range history.Nutrition {
+		version := NutritionVersion{
+			From:        formatServeDate(snapshot.From, dateFormat),
+			Until:       formatServeDate(snapshot.Until, dateFormat),
+			ServingSize: snapshot.ServingSize,
+			Nutrients:   snapshot.Nutrients,
+			Changes:     map[string]NutritionChange{},
+		}
+		if i > 0 {
+			previous := history.Nutrition[i-1]
+			before := previous.Nutrients.byName()
+			for name, after := range snapshot.Nutrients.byName() {
+				if before[name] != after {
+					version.Changes[name] = NutritionChange{Before: before[name], After: after}
+				}
+			}
+			if previous.ServingSize != snapshot.ServingSize {
+				version.Changes["serving_size"] = NutritionChange{Before: previous.ServingSize, After: snapshot.ServingSize}
+			}
+		}
+		versions[i] = version
+	}
+	respond(c, http.StatusOK, RecipeNutritionHistory{
+		RecipeNumber: number,
+		FoodName:     history.Item.FoodName,
+		Versions:     versions,
+	}, ResponseMeta{Source: SourceDB})
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/reporting"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// requestInfo is the request context attached to reported errors.
+func requestInfo(c *gin.Context) *reporting.Request {
+	return &reporting.Request{
+		Method:    c.Request.Method,
+		URL:       c.Request.URL.String(),
+		Route:     c.FullPath(),
+		RequestID: c.GetString(requestIDKey),
+		ClientIP:  c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+}
+
+// recovery turns a panicking handler into a 500 instead of a dead connection,
+// and reports it. Requests that end in a server error are reported too, with
+// the message they were answered with.
+func (s *Server) recovery(c *gin.Context) {
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+		// The handler gave up on the response on purpose
+		if recovered == http.ErrAbortHandler {
+			panic(recovered)
+		}
+		s.reporter.Report(reporting.Event{
+			Level:   "fatal",
+			Message: fmt.Sprintf("panic: %v", recovered),
+			Stack:   string(debug.Stack()),
+			Request: requestInfo(c),
+			Time:    time.Now(),
+		})
+		if !c.Writer.Written() {
+			respondError(c, http.StatusInternalServerError, CodeInternal, "internal server error")
+		}
+		c.Abort()
+	}()
+	c.Next()
+
+	if c.Writer.Status() >= http.StatusInternalServerError && len(c.Errors) > 0 {
+		s.reporter.Report(reporting.Event{
+			Level:   "error",
+			Message: strings.Join(c.Errors.Errors(), "; "),
+			Request: requestInfo(c),
+			Time:    time.Now(),
+		})
+	}
+}
+
+// recoverJob keeps a panicking background job from taking the process down
+// with it, and reports the panic.
+func (s *Server) recoverJob(job string, run func()) func() {
+	return func() {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				s.reporter.Report(reporting.Event{
+					Level:   "fatal",
+					Message: fmt.Sprintf("panic in %s: %v", job, recovered),
+					Stack:   string(debug.Stack()),
+					Tags:    map[string]string{"job": job},
+					Time:    time.Now(),
+				})
+			}
+		}()
+		run()
+	}
+}
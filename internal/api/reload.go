@@ -0,0 +1,137 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/robfig/cron/v3"
+	"hudsgry-api/internal/provider"
+	"hudsgry-api/internal/scheduler"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ReloadResult says what a reload changed, and which changed settings only
+// take effect after a restart.
+type ReloadResult struct {
+	Changed       []string `json:"changed"`
+	RestartNeeded []string `json:"restart_needed"`
+}
+
+// Reload rereads the configuration and applies what can change while the
+// server runs: the refresh and intraday schedules and their time zone, the
+// staples, and the Twilio, Telegram and GroupMe settings of the notifiers
+// that are running. The cached menus are kept. If the new configuration is
+// invalid, nothing changes.
+func (s *Server) Reload() (ReloadResult, error) {
+	s.reload.Lock()
+	defer s.reload.Unlock()
+
+	if s.reloadConfig != nil {
+		if err := s.reloadConfig(); err != nil {
+			return ReloadResult{}, err
+		}
+	}
+	refresh, err := scheduler.LoadRefreshConfig()
+	if err != nil {
+		return ReloadResult{}, err
+	}
+	groupMeBots, err := loadGroupMeBots()
+	if err != nil {
+		return ReloadResult{}, err
+	}
+
+	result := ReloadResult{Changed: []string{}, RestartNeeded: []string{}}
+	if refreshChanged(s.refresh, refresh) && s.jobs != nil {
+		if err := s.scheduleRefreshJobs(refresh); err != nil {
+			return ReloadResult{}, err
+		}
+		result.Changed = append(result.Changed, "refresh schedules")
+	}
+	s.refresh = refresh
+
+	if staples := loadStaples(); strings.Join(staples, ",") != strings.Join(s.currentStaples(), ",") {
+		s.setStaples(staples)
+		result.Changed = append(result.Changed, "staples")
+	}
+
+	sms := loadTwilioSettings()
+	switch {
+	case s.sms == nil && sms.accountSid != "", s.sms != nil && sms.accountSid == "":
+		result.RestartNeeded = append(result.RestartNeeded, "sms")
+	case s.sms != nil && s.sms.twilio.update(sms):
+		result.Changed = append(result.Changed, "sms")
+	}
+	token := os.Getenv("TELEGRAM_BOT_TOKEN")
+	switch {
+	case s.telegram == nil && token != "", s.telegram != nil && token == "":
+		result.RestartNeeded = append(result.RestartNeeded, "telegram")
+	case s.telegram != nil && s.telegram.reload(token, os.Getenv("TELEGRAM_WEBHOOK_URL")):
+		result.Changed = append(result.Changed, "telegram")
+	}
+	switch {
+	case s.groupMe == nil && len(groupMeBots) > 0, s.groupMe != nil && len(groupMeBots) == 0:
+		result.RestartNeeded = append(result.RestartNeeded, "groupme")
+	case s.groupMe != nil && s.groupMe.reload(groupMeBots):
+		result.Changed = append(result.Changed, "groupme")
+	}
+
+	log.Printf("Reloaded configuration, changed: [%s], needing a restart: [%s]\n", strings.Join(result.Changed, ", "), strings.Join(result.RestartNeeded, ", "))
+	return result, nil
+}
+
+func refreshChanged(old scheduler.RefreshConfig, new scheduler.RefreshConfig) bool {
+	return strings.Join(old.Schedules, ";") != strings.Join(new.Schedules, ";") ||
+		strings.Join(old.IntradaySchedules, ";") != strings.Join(new.IntradaySchedules, ";") ||
+		old.Location.String() != new.Location.String()
+}
+
+// scheduleRefreshJobs replaces the refresh and intraday jobs with the ones
+// refresh asks for. Every schedule is checked first, so an invalid one leaves
+// the current jobs running.
+func (s *Server) scheduleRefreshJobs(refresh scheduler.RefreshConfig) error {
+	for _, spec := range refresh.Schedules {
+		if _, err := cron.ParseStandard(refresh.Spec(spec)); err != nil {
+			return fmt.Errorf("failed to schedule data fetching and processing at %q: %v", spec, err)
+		}
+	}
+	for _, spec := range refresh.IntradaySchedules {
+		if _, err := cron.ParseStandard(refresh.Spec(spec)); err != nil {
+			return fmt.Errorf("failed to schedule intraday change detection at %q: %v", spec, err)
+		}
+	}
+
+	for _, id := range s.reload.refreshJobs {
+		s.jobs.Remove(id)
+	}
+	s.reload.refreshJobs = nil
+	for _, spec := range refresh.Schedules {
+		id, _ := s.jobs.AddFunc(refresh.Spec(spec), s.recoverJob("refresh", s.exclusive("refresh", func() {
+			log.Println("Fetching and processing data...")
+			err := s.fetchAndProcessData(provider.DateRange{})
+			if err != nil {
+				log.Printf("Failed to fetch HUDS data: %v\n", err)
+				return
+			}
+			log.Println("Fetched HUDS data successfully (in cron job)")
+		})))
+		s.reload.refreshJobs = append(s.reload.refreshJobs, id)
+	}
+	for _, spec := range refresh.IntradaySchedules {
+		id, _ := s.jobs.AddFunc(refresh.Spec(spec), s.recoverJob("intraday", s.exclusive("intraday", s.checkForMenuChanges)))
+		s.reload.refreshJobs = append(s.reload.refreshJobs, id)
+	}
+	return nil
+}
+
+// handleAdminReload reloads the configuration, as SIGHUP does.
+func (s *Server) handleAdminReload(c *gin.Context) {
+	result, err := s.Reload()
+	if err != nil {
+		log.Printf("Failed to reload configuration: %v\n", err)
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "failed to reload configuration: "+err.Error())
+		return
+	}
+	respond(c, http.StatusOK, result, ResponseMeta{})
+}
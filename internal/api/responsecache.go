@@ -0,0 +1,265 @@
+package api
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultResponseCacheMaxStale = 24 * time.Hour
+	defaultResponseCacheEntries  = 2000
+	// maxCachedResponseBytes keeps the odd huge response, such as a long
+	// search, from crowding out the rest
+	maxCachedResponseBytes = 1 << 20
+)
+
+// responseCache keeps the last good response to each cached route and query,
+// to answer with when the route later fails. It holds at most maxEntries,
+// dropping the least recently stored first.
+type responseCache struct {
+	maxStale   time.Duration
+	maxEntries int
+
+	sync.Mutex
+	entries map[string]*list.Element
+	// order has the most recently stored response at the front
+	order *list.List
+	// served counts the errors answered with a stale response, and missed
+	// those that had none young enough
+	served int
+	missed int
+}
+
+type cachedResponse struct {
+	key      string
+	status   int
+	header   http.Header
+	body     []byte
+	storedAt time.Time
+}
+
+// ResponseCacheStats is what /admin/cache reports about the response cache.
+type ResponseCacheStats struct {
+	Entries         int     `json:"entries"`
+	Bytes           int     `json:"bytes"`
+	MaxEntries      int     `json:"max_entries"`
+	MaxStaleSeconds float64 `json:"max_stale_seconds"`
+	ServedStale     int     `json:"served_stale"`
+	Missed          int     `json:"missed"`
+}
+
+// loadResponseCache reads RESPONSE_CACHE_MAX_STALE, how old a response may be
+// served in place of an error, 24h by default, and RESPONSE_CACHE_ENTRIES,
+// how many responses are kept, 2000 by default. A max staleness of 0 turns
+// the cache off.
+func loadResponseCache() (*responseCache, error) {
+	maxStale := defaultResponseCacheMaxStale
+	if v := os.Getenv("RESPONSE_CACHE_MAX_STALE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("RESPONSE_CACHE_MAX_STALE must be a non-negative duration, got %q", v)
+		}
+		maxStale = d
+	}
+	if maxStale == 0 {
+		return nil, nil
+	}
+	entries := defaultResponseCacheEntries
+	if v := os.Getenv("RESPONSE_CACHE_ENTRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("RESPONSE_CACHE_ENTRIES must be a positive integer, got %q", v)
+		}
+		entries = n
+	}
+	return &responseCache{maxStale: maxStale, maxEntries: entries, entries: make(map[string]*list.Element), order: list.New()}, nil
+}
+
+func (rc *responseCache) store(response *cachedResponse) {
+	rc.Lock()
+	defer rc.Unlock()
+	if element, ok := rc.entries[response.key]; ok {
+		rc.order.Remove(element)
+	}
+	rc.entries[response.key] = rc.order.PushFront(response)
+	for rc.order.Len() > rc.maxEntries {
+		oldest := rc.order.Back()
+		rc.order.Remove(oldest)
+		delete(rc.entries, oldest.Value.(*cachedResponse).key)
+	}
+}
+
+// lookup returns the response stored for key if it is no older than
+// maxStale, counting whether there was one.
+func (rc *responseCache) lookup(key string, maxStale time.Duration) (*cachedResponse, bool) {
+	rc.Lock()
+	defer rc.Unlock()
+	element, ok := rc.entries[key]
+	if !ok || time.Since(element.Value.(*cachedResponse).storedAt) > maxStale {
+		rc.missed++
+		return nil, false
+	}
+	rc.served++
+	return element.Value.(*cachedResponse), true
+}
+
+func (rc *responseCache) clear() {
+	rc.Lock()
+	defer rc.Unlock()
+	rc.entries = make(map[string]*list.Element)
+	rc.order.Init()
+}
+
+func (rc *responseCache) stats() ResponseCacheStats {
+	rc.Lock()
+	defer rc.Unlock()
+	stats := ResponseCacheStats{
+		Entries:         rc.order.Len(),
+		MaxEntries:      rc.maxEntries,
+		MaxStaleSeconds: rc.maxStale.Seconds(),
+		ServedStale:     rc.served,
+		Missed:          rc.missed,
+	}
+	for element := rc.order.Front(); element != nil; element = element.Next() {
+		stats.Bytes += len(element.Value.(*cachedResponse).body)
+	}
+	return stats
+}
+
+// bufferedWriter holds a response back until staleIfError has seen how it
+// went.
+type bufferedWriter struct {
+	gin.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	if code > 0 {
+		w.status = code
+	}
+}
+
+func (w *bufferedWriter) WriteHeaderNow() {}
+
+func (w *bufferedWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *bufferedWriter) Status() int {
+	return w.status
+}
+
+func (w *bufferedWriter) Size() int {
+	return w.body.Len()
+}
+
+func (w *bufferedWriter) Written() bool {
+	return w.body.Len() > 0
+}
+
+func (w *bufferedWriter) Flush() {}
+
+// staleIfError keeps the last good response to a route for each query,
+// format, API version and date asked for, and answers with it when the route
+// fails, whether from the store or HUDS, rather than with the error, as long
+// as it is no older than RESPONSE_CACHE_MAX_STALE. A client can ask for fresher with a Cache-Control
+// stale-if-error of fewer seconds. Responses served stale carry Warning and
+// Age headers. Requests made as a signed-in user aren't cached, as their
+// responses may be the user's own.
+func (s *Server) staleIfError(c *gin.Context) {
+	if s.responses == nil || c.GetHeader("Authorization") != "" {
+		c.Next()
+		return
+	}
+	key := fmt.Sprintf("%s %s?%s %s v%d %s", c.Request.Method, c.Request.URL.Path, c.Request.URL.Query().Encode(), c.GetString(responseFormatKey), apiVersion(c), s.cachedDate(c))
+	original := c.Writer
+	before := original.Header().Clone()
+	writer := &bufferedWriter{ResponseWriter: original, status: http.StatusOK}
+	c.Writer = writer
+	c.Next()
+	c.Writer = original
+
+	status := writer.status
+	switch {
+	case status == http.StatusOK && writer.body.Len() <= maxCachedResponseBytes:
+		s.responses.store(&cachedResponse{
+			key:      key,
+			status:   status,
+			header:   handlerHeader(before, original.Header()),
+			body:     append([]byte(nil), writer.body.Bytes()...),
+			storedAt: time.Now(),
+		})
+	// 501 is a feature that's off rather than a failure
+	case status >= http.StatusInternalServerError && status != http.StatusNotImplemented:
+		if cached, ok := s.responses.lookup(key, requestMaxStale(c, s.responses.maxStale)); ok {
+			for name, values := range cached.header {
+				original.Header()[name] = values
+			}
+			original.Header().Set("Age", strconv.Itoa(int(time.Since(cached.storedAt).Seconds())))
+			original.Header().Add("Warning", `110 - "Response is Stale"`)
+			original.Header().Add("Warning", `111 - "Revalidation Failed"`)
+			original.WriteHeader(cached.status)
+			original.Write(cached.body)
+			return
+		}
+	}
+	original.WriteHeader(status)
+	original.Write(writer.body.Bytes())
+}
+
+// cachedDate is the day a request asks about, which is part of its cache key
+// so that after midnight "today", or a route that defaults to today, isn't
+// answered with yesterday's response: ?serve_date= or ?date= if they parse,
+// and today otherwise.
+func (s *Server) cachedDate(c *gin.Context) string {
+	for _, param := range []string{"serve_date", "date"} {
+		if value := c.Query(param); value != "" {
+			if date, err := s.parseDate(value); err == nil {
+				return date.Format(huds.ServeDateLayout)
+			}
+		}
+	}
+	return s.today()
+}
+
+// handlerHeader returns the headers a handler set, which are what is replayed
+// with a stale response; those set before it, such as the request ID and
+// rate limits, belong to the request at hand.
+func handlerHeader(before http.Header, after http.Header) http.Header {
+	header := make(http.Header)
+	for name, values := range after {
+		if strings.Join(before[name], ",") != strings.Join(values, ",") {
+			header[name] = append([]string(nil), values...)
+		}
+	}
+	return header
+}
+
+// requestMaxStale lowers maxStale to the request's Cache-Control
+// stale-if-error, if it asks for fresher.
+func requestMaxStale(c *gin.Context, maxStale time.Duration) time.Duration {
+	for _, directive := range strings.Split(c.GetHeader("Cache-Control"), ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(directive), "=")
+		if !ok || !strings.EqualFold(name, "stale-if-error") {
+			continue
+		}
+		if seconds, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil && seconds >= 0 && time.Duration(seconds)*time.Second < maxStale {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return maxStale
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"log"
+	"sync"
+	"time"
+)
+
+// RetentionStatus is the retention policy and how its last cleanup went, as
+// /admin/stats reports it.
+type RetentionStatus struct {
+	// Keep is the policy, e.g. 2y
+	Keep     string `json:"keep"`
+	Schedule string `json:"schedule"`
+	// Cutoff is the earliest serve date kept as of today
+	Cutoff              string           `json:"cutoff"`
+	LastRun             *time.Time       `json:"last_run,omitempty"`
+	LastDurationSeconds float64          `json:"last_duration_seconds,omitempty"`
+	Deleted             map[string]int64 `json:"deleted,omitempty"`
+	LastError           string           `json:"last_error,omitempty"`
+}
+
+type retention struct {
+	sync.Mutex
+	policy store.RetentionPolicy
+	status RetentionStatus
+}
+
+// scheduleRetention runs the cleanup on the policy's schedule, if the policy
+// deletes anything and the store can.
+func (s *Server) scheduleRetention() error {
+	if !s.retention.policy.Enabled() {
+		return nil
+	}
+	if _, ok := s.store.(store.Pruner); !ok {
+		log.Println("The menu store can't delete old history; RETENTION is ignored")
+		return nil
+	}
+	s.retention.status = RetentionStatus{Keep: s.retention.policy.Keep, Schedule: s.retention.policy.Schedule}
+	_, err := s.jobs.AddFunc(s.retention.policy.Schedule, s.recoverJob("retention", s.exclusive("retention", s.pruneHistory)))
+	return err
+}
+
+// retentionCutoff is the earliest serve date kept as of today.
+func (s *Server) retentionCutoff() time.Time {
+	today, _ := time.Parse(huds.ServeDateLayout, s.today())
+	return s.retention.policy.Cutoff(today)
+}
+
+// pruneHistory deletes what the retention policy no longer keeps, then
+// forgets what the caches derived from it.
+func (s *Server) pruneHistory() {
+	cutoff := s.retentionCutoff()
+	ctx, cancel := s.jobContext()
+	defer cancel()
+	started := time.Now()
+	deleted, err := s.store.(store.Pruner).PruneBefore(ctx, cutoff)
+
+	s.retention.Lock()
+	s.retention.status.LastRun = &started
+	s.retention.status.LastDurationSeconds = time.Since(started).Seconds()
+	s.retention.status.Deleted = deleted
+	s.retention.status.LastError = ""
+	if err != nil {
+		s.retention.status.LastError = err.Error()
+	}
+	s.retention.Unlock()
+	if err != nil {
+		log.Printf("Failed to delete history before %s: %v\n", cutoff.Format(huds.ServeDateLayout), err)
+		return
+	}
+	log.Printf("Deleted history before %s: %v\n", cutoff.Format(huds.ServeDateLayout), deleted)
+	if s.shadow != nil {
+		s.shadow.PruneBefore(ctx, cutoff)
+	}
+
+	if err := s.refreshRecordRange(ctx); err != nil {
+		log.Printf("Failed to get earliest and latest records: %v\n", err)
+	}
+	s.resetMenuCatalog(ctx, nil)
+	if s.menuCache != nil {
+		for _, menu := range s.menuCache.Menus() {
+			if date, err := time.Parse(huds.ServeDateLayout, menu.ServeDate); err == nil && date.Before(cutoff) {
+				s.menuCache.Delete(menu.ServeDate)
+			}
+		}
+	}
+}
+
+// retentionStatus is nil when nothing is ever deleted.
+func (s *Server) retentionStatus() *RetentionStatus {
+	if !s.retention.policy.Enabled() {
+		return nil
+	}
+	s.retention.Lock()
+	defer s.retention.Unlock()
+	status := s.retention.status
+	status.Cutoff = formatServeDate(s.retentionCutoff().Format(huds.ServeDateLayout), s.dateFormat)
+	return &status
+}
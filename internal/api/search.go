@@ -0,0 +1,81 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/store"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// handleSearch ranks served items against ?q= by text relevance, matching
+// food names first, then categories, then ingredients. Results can be narrowed
+// with ?start=, ?end= and ?meal=, and ordered with ?sort= and ?order= instead.
+// Misspelt terms are corrected to words on stored menus unless ?fuzzy=false,
+// with relevance scaled down by how far the correction went.
+func (s *Server) handleSearch(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "q is required")
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultSearchLimit)))
+	if err != nil || limit < 1 || limit > maxSearchLimit {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "limit must be between 1 and 100")
+		return
+	}
+
+	// One extra result tells us whether there are more
+	spec, _ := c.Value(itemSortKey).(itemSort)
+	query := store.SearchQuery{Text: q, Limit: limit + 1, Sort: spec.field, Descending: spec.descending}
+	var ok bool
+	if query.Start, ok = s.queryDate(c, "start"); !ok {
+		return
+	}
+	if query.End, ok = s.queryDate(c, "end"); !ok {
+		return
+	}
+	if meal := strings.ToLower(c.Query("meal")); meal != "" {
+		if meal != "breakfast" && meal != "lunch" && meal != "dinner" {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "meal must be breakfast, lunch or dinner")
+			return
+		}
+		query.Meal = meal
+	}
+
+	similarity, corrected := 1.0, false
+	if c.DefaultQuery("fuzzy", "true") != "false" {
+		if catalog, err := s.loadCatalog(); err != nil {
+			log.Printf("Failed to build the menu catalog, searching without corrections: %v\n", err)
+		} else {
+			if text, sim, ok := catalog.correctQuery(q); ok {
+				query.Text, similarity, corrected = text, sim, true
+			}
+		}
+	}
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	results, err := s.store.Search(ctx, query)
+	if err != nil {
+		log.Printf("Failed to search served items: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "search failed")
+		return
+	}
+	for i := range results {
+		results[i].Score *= similarity
+	}
+
+	results, page := paginate(results, limit)
+	body := gin.H{"query": q, "results": results}
+	if corrected {
+		body["corrected_query"] = query.Text
+	}
+	respond(c, http.StatusOK, body, ResponseMeta{Source: SourceDB, Pagination: page})
+}
@@ -0,0 +1,558 @@
+// Package api serves the menus and everything built on them over HTTP.
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/robfig/cron/v3"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/sync/singleflight"
+	"hudsgry-api/internal/alerting"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/provider"
+	"hudsgry-api/internal/reporting"
+	"hudsgry-api/internal/scheduler"
+	"hudsgry-api/internal/store"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options are the dependencies a Server is built from. Mongo may be nil, in
+// which case only the menu endpoints are served.
+type Options struct {
+	Store        store.MenuStore
+	Provider     provider.Provider
+	Breaker      *huds.CircuitBreaker
+	Retry        huds.RetryPolicy
+	FetchTimeout time.Duration
+	Clock        scheduler.Clock
+	Refresh      scheduler.RefreshConfig
+	DateFormat   string
+	Timeouts     Timeouts
+	Mongo        *mongo.Database
+	// MenuCache, if set, keeps the latest menus in a local file to serve
+	// from while the store is unreachable
+	MenuCache *store.FileCache
+	// SkipIndexes only checks that the Mongo collections' indexes exist
+	SkipIndexes bool
+	// Reporter receives panics and server errors; they are logged if nil
+	Reporter reporting.Reporter
+	// Alerter is told when refreshes fail or leave menus missing, if set
+	Alerter alerting.Alerter
+	// ReloadConfig rereads the configuration file, if any, into the
+	// environment before a reload
+	ReloadConfig func() error
+	// Retention is how much history to keep; the zero policy keeps it all
+	Retention store.RetentionPolicy
+	// Shadow, if set, is written alongside Store while migrating to another
+	// backend
+	Shadow *store.Shadow
+}
+
+// Server holds everything the handlers, scheduled jobs and bots share.
+type Server struct {
+	store        store.MenuStore
+	provider     provider.Provider
+	breaker      *huds.CircuitBreaker
+	retry        huds.RetryPolicy
+	fetchTimeout time.Duration
+	clock        scheduler.Clock
+	refresh      scheduler.RefreshConfig
+	dateFormat   string
+	timeouts     Timeouts
+	db           *mongo.Database
+	skipIndexes  bool
+	menuCache    *store.FileCache
+	shadow       *store.Shadow
+	// responses answers for failing routes with their last good response;
+	// nil when off
+	responses    *responseCache
+	reporter     reporting.Reporter
+	alerter      alerting.Alerter
+	reloadConfig func() error
+	// instance names this replica when it claims a scheduled job
+	instance string
+
+	mu             sync.RWMutex
+	localCache     huds.CondensedMenu
+	earliestRecord time.Time
+	latestRecord   time.Time
+	// syncedAt is when a full refresh last stored the feed, or found it
+	// unchanged
+	syncedAt time.Time
+
+	// afterRefreshHooks run with the freshly converted data after every
+	// successful fetch-and-store.
+	afterRefreshHooks []func(ctx context.Context, data map[string]map[int][]huds.CondensedMenuItem)
+	// changeListeners are called with every batch of detected changes, e.g.
+	// to deliver webhooks.
+	changeListeners []func(ctx context.Context, changes []MenuChange)
+	changeStreams   struct {
+		sync.Mutex
+		subscribers map[chan MenuChange]struct{}
+	}
+	// warm holds the days around today in memory
+	warm struct {
+		sync.RWMutex
+		menus    map[string]huds.CondensedMenu
+		progress WarmUp
+	}
+	revalidation struct {
+		sync.Mutex
+		running     bool
+		lastAttempt time.Time
+	}
+	// reads coalesces concurrent store reads of the same day
+	reads    singleflight.Group
+	onDemand struct {
+		sync.Mutex
+		lastAttempt map[string]time.Time
+		// fetches coalesces concurrent on-demand fetches of the same day
+		fetches singleflight.Group
+	}
+	catalog struct {
+		sync.Mutex
+		menus *menuCatalog
+	}
+	// cycle is the rotation last detected, nil if the menus don't repeat
+	cycle struct {
+		sync.Mutex
+		detected     *MenuCycle
+		detectedOnce bool
+	}
+	staples struct {
+		sync.RWMutex
+		names []string
+	}
+	tags struct {
+		sync.Mutex
+		byRecipe map[string][]string
+		loadedAt time.Time
+	}
+	hours struct {
+		sync.Mutex
+		schedules []store.HoursSchedule
+		loadedAt  time.Time
+	}
+	// dataQuality is the report on the latest refresh's data
+	dataQuality struct {
+		sync.Mutex
+		latest *DataQualityReport
+	}
+	fetchAlert  fetchAlert
+	stats       serviceStats
+	keyUsage    keyUsage
+	quotas      quotas
+	maintenance maintenanceMode
+	retention   retention
+	// jobs is the scheduler every job runs on. The refresh jobs' entries are
+	// kept so a reload can replace them.
+	jobs   scheduler.Scheduler
+	reload struct {
+		sync.Mutex
+		refreshJobs []cron.EntryID
+	}
+	// adminToken guards the /admin routes, which are only served when it
+	// is set
+	adminToken string
+	// notifiers holds every channel that has been configured at startup,
+	// keyed by channel name.
+	notifiers map[string]Notifier
+
+	// jwt issues and checks JWTs, when a signing key is configured
+	jwt       *jwtAuth
+	telemetry *Telemetry
+	accessLog *AccessLog
+	telegram  *TelegramBot
+	groupMe   *GroupMeBot
+	sms       *SMSService
+	push      *PushService
+	photos    *PhotoService
+	calendar  *CalendarService
+	oidc      *OIDCService
+	alexa     *AlexaSkill
+
+	users          *mongo.Collection
+	sessions       *mongo.Collection
+	favoriteAlerts *mongo.Collection
+	mealLogs       *mongo.Collection
+	webhooks       *mongo.Collection
+	// webhookDeliveries holds pending and recent deliveries;
+	// webhookDeadLetters those that ran out of attempts
+	webhookDeliveries  *mongo.Collection
+	webhookDeadLetters *mongo.Collection
+	webhookClient      *http.Client
+	apiKeys            *mongo.Collection
+	apiKeyUsage        *mongo.Collection
+	quotaTiers         *mongo.Collection
+	anonymousUsage     *mongo.Collection
+	dataQualityReports *mongo.Collection
+}
+
+func New(opts Options) *Server {
+	s := &Server{
+		store:         opts.Store,
+		provider:      opts.Provider,
+		breaker:       opts.Breaker,
+		retry:         opts.Retry,
+		fetchTimeout:  opts.FetchTimeout,
+		clock:         opts.Clock,
+		refresh:       opts.Refresh,
+		dateFormat:    opts.DateFormat,
+		timeouts:      opts.Timeouts,
+		db:            opts.Mongo,
+		skipIndexes:   opts.SkipIndexes,
+		menuCache:     opts.MenuCache,
+		shadow:        opts.Shadow,
+		reporter:      opts.Reporter,
+		alerter:       opts.Alerter,
+		reloadConfig:  opts.ReloadConfig,
+		instance:      instanceName(),
+		notifiers:     make(map[string]Notifier),
+		webhookClient: newWebhookClient(),
+	}
+	s.retention.policy = opts.Retention
+	if s.breaker == nil {
+		s.breaker = huds.NewCircuitBreaker(5, 5*time.Minute)
+	}
+	if s.reporter == nil {
+		s.reporter = reporting.LogReporter{}
+	}
+	if s.clock == nil {
+		s.clock = scheduler.SystemClock{}
+	}
+	if s.refresh.Location == nil {
+		s.refresh.Location = huds.DiningZone
+	}
+	if s.dateFormat == "" {
+		s.dateFormat = DateFormatUS
+	}
+	if s.timeouts.DB == 0 {
+		s.timeouts.DB = defaultDBTimeout
+	}
+	if s.timeouts.Job == 0 {
+		s.timeouts.Job = defaultJobTimeout
+	}
+	s.stats.startedAt = time.Now()
+	s.stats.requests = make(map[string]int)
+	s.changeStreams.subscribers = make(map[chan MenuChange]struct{})
+	s.onDemand.lastAttempt = make(map[string]time.Time)
+	s.afterRefreshHooks = append(s.afterRefreshHooks, s.checkDataQuality)
+	s.warm.progress = WarmUp{Days: 2*warmDays + 1}
+	s.loadMaintenance()
+	s.setStaples(loadStaples())
+	return s
+}
+
+// Refresh fetches the whole feed from HUDS and stores it.
+func (s *Server) Refresh() error {
+	return s.fetchAndProcessData(provider.DateRange{})
+}
+
+// Fetch fetches and stores just the dates in range.
+func (s *Server) Fetch(dates provider.DateRange) error {
+	return s.fetchAndProcessData(dates)
+}
+
+// Backfill fetches and stores every date from start to end inclusive, one
+// day at a time, since HUDS only serves past dates when asked for them one by
+// one. A day that fails is logged and skipped, and the error says how many
+// did.
+func (s *Server) Backfill(start time.Time, end time.Time) error {
+	days, failed := 0, 0
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		days++
+		if err := s.fetchAndProcessData(provider.Day(day)); err != nil {
+			log.Printf("Failed to backfill %s: %v\n", day.Format(huds.ServeDateLayout), err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to backfill %d of %d days", failed, days)
+	}
+	return nil
+}
+
+// Handler schedules the refresh jobs and registers every route, starting the
+// bots and notifiers that are configured.
+func (s *Server) Handler() (http.Handler, error) {
+	responses, err := loadResponseCache()
+	if err != nil {
+		return nil, err
+	}
+	s.responses = responses
+
+	// Get earliest and latest records
+	ctx, cancel := s.jobContext()
+	defer cancel()
+	if err := s.refreshRecordRange(ctx); err != nil {
+		log.Printf("Failed to get earliest and latest records: %v\n", err)
+	}
+	// Requests are served while the cache warms up, but /ready waits for it
+	go func() {
+		ctx, cancel := s.jobContext()
+		defer cancel()
+		s.warmUp(ctx, nil)
+		log.Println("Warmed up the menu cache")
+	}()
+
+	// Fill the local cache without waiting for the first refresh
+	if s.menuCache != nil && s.menuCache.SavedAt().IsZero() {
+		s.saveMenuCache(ctx, nil)
+	}
+
+	// Schedule data fetching and processing
+	jobs := scheduler.New(s.clock, s.refresh.Location)
+	s.jobs = jobs
+	if err := s.scheduleRefreshJobs(s.refresh); err != nil {
+		return nil, err
+	}
+	if err := s.scheduleRetention(); err != nil {
+		return nil, fmt.Errorf("invalid RETENTION_SCHEDULE: %v", err)
+	}
+	jobs.Start()
+
+	if os.Getenv("TELEMETRY_ENABLED") == "true" && s.db != nil {
+		s.startTelemetry(jobs)
+	}
+	if os.Getenv("ACCESS_LOG_ENABLED") == "true" && s.db != nil {
+		if err := s.startAccessLog(jobs); err != nil {
+			return nil, err
+		}
+	}
+	if s.db != nil {
+		if token := os.Getenv("TELEGRAM_BOT_TOKEN"); token != "" {
+			s.startTelegramBot(token, jobs)
+		}
+		if os.Getenv("GROUPME_BOTS") != "" {
+			if err := s.startGroupMeBot(); err != nil {
+				return nil, err
+			}
+		}
+		if os.Getenv("TWILIO_ACCOUNT_SID") != "" {
+			s.startSMSNotifications(jobs)
+		}
+		if os.Getenv("FCM_CREDENTIALS_FILE") != "" || os.Getenv("APNS_KEY_FILE") != "" {
+			s.startPushNotifications()
+		}
+		s.setupUsers()
+		if err := s.setupJWT(); err != nil {
+			return nil, err
+		}
+		if os.Getenv("OIDC_ISSUER") != "" {
+			s.startOIDC()
+		}
+		s.setupFavoriteAlerts()
+		s.setupMealLog()
+		s.setupWebhooks(jobs)
+		s.setupDataQuality()
+		if err := s.setupAPIKeys(jobs); err != nil {
+			return nil, err
+		}
+		if err := s.setupQuotas(jobs); err != nil {
+			return nil, err
+		}
+		s.startPhotos()
+		if os.Getenv("GOOGLE_CLIENT_ID") != "" {
+			s.startGoogleCalendar()
+		}
+	} else {
+		log.Println("MONGODB_URI is not set; accounts, API keys, alerts, meal logs, webhooks, photos, calendar sync, bots, telemetry and access logs are disabled")
+	}
+
+	s.adminToken = os.Getenv("ADMIN_TOKEN")
+	s.setupAlexa()
+
+	router := gin.New()
+	if err := trustClientIPHeaders(router); err != nil {
+		return nil, err
+	}
+	router.Use(gin.Logger(), requestID, s.recovery, negotiateFormat, s.countRequests)
+	router.NoRoute(func(c *gin.Context) {
+		respondError(c, http.StatusNotFound, CodeNotFound, "no such endpoint")
+	})
+
+	// Telemetry is opt-in and must be registered before any route
+	if s.telemetry != nil {
+		router.Use(s.telemetry.middleware)
+	}
+	// Likewise access logging, which is opt-in as it records who asked
+	if s.accessLog != nil {
+		router.Use(s.accessLog.middleware)
+	}
+	// Likewise API key checks and the anonymous quota, which must also see
+	// every route
+	if s.apiKeys != nil {
+		router.Use(s.trackAPIKey)
+	}
+
+	registerWebRoutes(router)
+	router.GET("/ready", s.handleReady)
+	router.GET("/menu", s.handleMenuPage)
+	router.GET("/widget.js", s.handleWidgetScript)
+	router.GET("/widget.json", s.handleWidgetJSON)
+	for version := 1; version <= LatestAPIVersion; version++ {
+		s.registerRoutes(router.Group(fmt.Sprintf("/v%d", version), pinAPIVersion(version)))
+	}
+	// Everything was served unversioned before /v1, and still is
+	s.registerRoutes(router.Group("", negotiateAPIVersion))
+
+	return router, nil
+}
+
+// registerRoutes mounts the API on r. It runs once for each API version and
+// once more for the deprecated unversioned paths.
+func (s *Server) registerRoutes(r gin.IRouter) {
+	r.Use(s.rejectWritesInMaintenance)
+	r.GET("/now", s.handleGetNow)
+	// Moving the clock moves "today" for every user and the scheduler, so it
+	// is only for admins, and only on simulated time
+	if _, simulated := s.clock.(*scheduler.SimulatedClock); simulated && s.adminToken != "" {
+		r.POST("/now", s.requireAdmin, s.handleSetNow)
+	}
+	r.POST("/plan/week", s.handlePlanWeek)
+
+	if s.telemetry != nil {
+		r.GET("/analytics/usage", s.telemetry.handleReport)
+	}
+	if s.adminToken != "" {
+		r.GET("/admin/stats", s.requireAdmin, s.handleAdminStats)
+		r.GET("/admin/access-logs", s.requireAdmin, s.handleAdminAccessLogs)
+		r.GET("/admin/shadow", s.requireAdmin, s.handleAdminShadow)
+		r.POST("/admin/shadow/verify", s.requireAdmin, s.handleAdminVerifyShadow)
+		r.POST("/admin/reload", s.requireAdmin, s.handleAdminReload)
+		r.GET("/admin/maintenance", s.requireAdmin, s.handleGetMaintenance)
+		r.PUT("/admin/maintenance", s.requireAdmin, s.handleSetMaintenance)
+		r.GET("/admin/tags", s.requireAdmin, s.handleAdminListTags)
+		r.PUT("/admin/tags/:recipe", s.requireAdmin, s.handleAdminSetTags)
+		r.DELETE("/admin/tags/:recipe", s.requireAdmin, s.handleAdminDeleteTags)
+		r.GET("/admin/overrides", s.requireAdmin, s.handleAdminListOverrides)
+		r.PUT("/admin/overrides/:recipe", s.requireAdmin, s.handleAdminPutOverride)
+		r.DELETE("/admin/overrides/:recipe", s.requireAdmin, s.handleAdminDeleteOverride)
+		r.GET("/admin/hours", s.requireAdmin, s.handleAdminListHours)
+		r.PUT("/admin/hours/:id", s.requireAdmin, s.handleAdminPutHours)
+		r.DELETE("/admin/hours/:id", s.requireAdmin, s.handleAdminDeleteHours)
+		r.GET("/admin/data-quality", s.requireAdmin, s.handleDataQuality)
+		r.GET("/admin/backup", s.requireAdmin, s.handleAdminBackup)
+		r.POST("/admin/backup", s.requireAdmin, s.handleAdminRestore)
+		r.GET("/admin/cache", s.requireAdmin, s.handleAdminCache)
+		r.DELETE("/admin/cache", s.requireAdmin, s.handleAdminFlushCache)
+		r.DELETE("/admin/cache/:date", s.requireAdmin, s.handleAdminInvalidateCache)
+	}
+	if s.alexa != nil {
+		r.POST("/alexa", s.handleAlexa)
+	}
+	if s.telegram != nil {
+		s.telegram.routes(r)
+	}
+	if s.groupMe != nil {
+		s.groupMe.routes(r)
+	}
+	if s.sms != nil {
+		s.sms.routes(r)
+	}
+	if s.push != nil {
+		s.push.routes(r)
+	}
+	if s.photos != nil {
+		s.photos.routes(r)
+	}
+	if s.calendar != nil {
+		s.calendar.routes(r)
+	}
+	if s.db != nil {
+		s.userRoutes(r)
+		if s.jwt != nil {
+			s.jwtRoutes(r)
+		}
+		if s.oidc != nil {
+			s.oidc.routes(r)
+		}
+		s.favoriteAlertRoutes(r)
+		s.profileRoutes(r)
+		s.mealLogRoutes(r)
+		s.goalRoutes(r)
+		s.webhookRoutes(r)
+		s.apiKeyRoutes(r)
+	}
+
+	r.GET("/huds-data", s.staleIfError, s.bindServeDate, bindDietaryFlags, s.bindStaples, s.bindTags, s.bindPhotos, bindDedupe, bindItemSort, bindItemFields, bindGroupBy, s.handleHudsData)
+	r.GET("/huds-data/predicted", s.staleIfError, s.bindServeDate, bindDietaryFlags, s.bindStaples, s.bindTags, s.bindPhotos, bindDedupe, bindItemSort, bindItemFields, bindGroupBy, s.handlePredictedMenu)
+	r.GET("/huds-data/version", s.bindServeDate, s.handleMenuVersion)
+	r.GET("/huds-data/nutrition", s.staleIfError, s.bindServeDate, s.handleDailyNutrition)
+	r.GET("/huds-data/healthy-picks", s.staleIfError, s.bindServeDate, s.handleHealthyPicks)
+	r.GET("/huds-data/week", s.staleIfError, s.bindServeDate, bindItemFields, s.handleWeek)
+	r.GET("/huds-data/diff", s.handleMenuDiff)
+	r.GET("/huds-data/history", s.bindServeDate, s.handleMenuHistory)
+	r.GET("/huds-data/export.pdf", s.bindServeDate, s.handleMenuPDF)
+	r.GET("/huds-data.txt", s.staleIfError, bindDietaryFlags, s.bindStaples, bindDedupe, s.handleMenuText)
+	r.GET("/hours", s.staleIfError, s.handleHours)
+	r.GET("/next-meal", s.staleIfError, bindDietaryFlags, s.bindStaples, s.bindTags, s.bindPhotos, bindItemFields, s.handleNextMeal)
+	r.POST("/calculate", s.handleCalculate)
+	r.POST("/nutrition/export", s.handleExportItems)
+	r.POST("/nutrition/export.csv", s.handleExportItems)
+	r.GET("/compare", s.staleIfError, s.handleCompare)
+	r.GET("/search", s.staleIfError, bindItemSort, s.handleSearch)
+	r.GET("/search/suggest", s.staleIfError, s.handleSuggest)
+	r.GET("/foods/:name/last-served", s.staleIfError, s.handleLastServed)
+	r.GET("/items/:id/ingredients", s.staleIfError, s.handleItemIngredients)
+	r.GET("/items/:id/label.svg", s.handleItemLabel)
+	r.GET("/recipes/:number", s.staleIfError, s.handleRecipe)
+	r.GET("/recipes/:number/nutrition-history", s.staleIfError, s.handleRecipeNutritionHistory)
+	r.GET("/allergens", s.staleIfError, s.handleAllergens)
+	r.GET("/categories", s.staleIfError, s.handleCategories)
+	r.GET("/cycle", s.staleIfError, s.handleMenuCycle)
+	r.GET("/tags", s.staleIfError, s.handleTags)
+	r.GET("/dates", s.staleIfError, s.handleDates)
+	r.GET("/analytics/frequency", s.handleFoodFrequency)
+	r.GET("/analytics/summary", s.handleAnalyticsSummary)
+	r.GET("/analytics/cost", s.staleIfError, s.bindServeDate, s.handleMenuCost)
+	r.GET("/metrics/upstream", s.handleUpstreamMetrics)
+	r.GET("/events", s.handleEvents)
+	r.GET("/events/stream", s.handleChangeStream)
+}
+
+// trustClientIPHeaders sets where the client's address, which the anonymous
+// quota and access log go by, is read from. TRUSTED_PLATFORM names a header
+// the platform's proxy sets and clients can't, such as Fly-Client-IP, and
+// TRUSTED_PROXIES lists the CIDRs of proxies whose X-Forwarded-For is
+// believed. By default no header is, as any client could forge one, and the
+// connection's address is used.
+func trustClientIPHeaders(router *gin.Engine) error {
+	router.TrustedPlatform = os.Getenv("TRUSTED_PLATFORM")
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	if err := router.SetTrustedProxies(proxies); err != nil {
+		return fmt.Errorf("invalid TRUSTED_PROXIES: %v", err)
+	}
+	return nil
+}
+
+// Run serves on addr until the listener fails.
+func (s *Server) Run(addr string) error {
+	handler, err := s.Handler()
+	if err != nil {
+		return err
+	}
+	return http.ListenAndServe(addr, handler)
+}
+
+// ensureIndexes creates and checks the indexes a feature's collections need,
+// or only checks them when index creation is skipped. Failures are logged,
+// as the rest of the service can run without them.
+func (s *Server) ensureIndexes(feature string, specs ...store.IndexSpec) {
+	ctx, cancel := s.jobContext()
+	defer cancel()
+	if err := store.EnsureIndexes(ctx, s.db, specs, !s.skipIndexes); err != nil {
+		log.Printf("Failed to prepare %s indexes: %v\n", feature, err)
+	}
+}
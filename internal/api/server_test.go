@@ -0,0 +1,126 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/provider"
+	"hudsgry-api/internal/scheduler"
+	"hudsgry-api/internal/store"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testToday is the serve date the test server's clock is set to; the
+// fixture in testdata/huds has menus for it and the day before.
+const testToday = "10/16/2026"
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newTestServer serves the menus in testdata/huds from the memory store,
+// fetched through the fixture client, with the clock at noon on testToday.
+// Without MongoDB only the menu endpoints and what needs no database are
+// served.
+func newTestServer(t *testing.T) (*Server, http.Handler) {
+	t.Helper()
+	today, err := time.ParseInLocation(huds.ServeDateLayout, testToday, huds.DiningZone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(Options{
+		Store:    store.NewMemoryMenuStore(),
+		Provider: provider.NewHUDS(huds.FixtureClient{Dir: "testdata/huds"}),
+		Retry:    huds.RetryPolicy{Attempts: 1},
+		Clock:    scheduler.NewSimulatedClock(today.Add(12*time.Hour), 1),
+	})
+	if err := s.Refresh(); err != nil {
+		t.Fatalf("failed to load testdata/huds: %v", err)
+	}
+	handler, err := s.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, handler
+}
+
+// serve sends req to handler and returns the recorded response.
+func serve(handler http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestHudsData(t *testing.T) {
+	_, handler := newTestServer(t)
+
+	for _, date := range []string{"?serve_date=today", "?serve_date=10/15/2026", "?serve_date=2026-10-16"} {
+		w := serve(handler, httptest.NewRequest(http.MethodGet, "/v1/huds-data"+date, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /v1/huds-data%s: got %d, want 200: %s", date, w.Code, w.Body)
+		}
+		var menu struct {
+			ServeDate string                   `json:"Serve_Date"`
+			Breakfast []huds.CondensedMenuItem `json:"breakfast"`
+			Lunch     []huds.CondensedMenuItem `json:"lunch"`
+			Dinner    []huds.CondensedMenuItem `json:"dinner"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &menu); err != nil {
+			t.Fatalf("GET /v1/huds-data%s: %v", date, err)
+		}
+		if len(menu.Breakfast) != 3 || len(menu.Lunch) != 3 || len(menu.Dinner) != 3 {
+			t.Errorf("GET /v1/huds-data%s: got %d, %d and %d items, want 3 at each meal", date, len(menu.Breakfast), len(menu.Lunch), len(menu.Dinner))
+		}
+	}
+
+	w := serve(handler, httptest.NewRequest(http.MethodGet, "/v1/huds-data?serve_date=01/01/2020", nil))
+	if w.Code != http.StatusNotFound || errorCode(t, w) != CodeDateOutOfRange {
+		t.Errorf("GET a date before the records: got %d %s, want 404 %s", w.Code, w.Body, CodeDateOutOfRange)
+	}
+	w = serve(handler, httptest.NewRequest(http.MethodGet, "/v1/huds-data?serve_date=today&date_format=julian", nil))
+	if w.Code != http.StatusBadRequest || errorCode(t, w) != CodeInvalidRequest {
+		t.Errorf("GET with an unknown date_format: got %d %s, want 400 %s", w.Code, w.Body, CodeInvalidRequest)
+	}
+}
+
+// errorCode is the code of the error response w recorded.
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body APIError
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("not an error response: %s", w.Body)
+	}
+	return body.Code
+}
+
+// outbox stands in for the APIs the bots post to, keeping what they sent
+// and answering as Telegram does on success.
+type outbox struct {
+	sent []string
+}
+
+func (o *outbox) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	o.sent = append(o.sent, string(body))
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(`{"ok":true,"result":{}}`)),
+		Request:    req,
+	}, nil
+}
+
+// roundTripFunc answers a client's requests in place of the network.
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// ShadowVerification is how the days in a range compared between the primary
+// and shadow stores.
+type ShadowVerification struct {
+	Start     string   `json:"start"`
+	End       string   `json:"end"`
+	Compared  int      `json:"compared"`
+	Matching  int      `json:"matching"`
+	Divergent []string `json:"divergent"`
+	Missing   []string `json:"missing"`
+	// Repaired is set when the days that differed were copied to the shadow
+	// store
+	Repaired bool `json:"repaired"`
+}
+
+// upsertMenus stores menus in the primary store and, if one is configured,
+// the shadow store. Only the primary's errors are returned.
+func (s *Server) upsertMenus(ctx context.Context, menus []huds.CondensedMenu) error {
+	if err := s.store.Upsert(ctx, menus); err != nil {
+		return err
+	}
+	if s.shadow != nil {
+		s.shadow.Upsert(ctx, menus)
+	}
+	return nil
+}
+
+// upsertLocations is upsertMenus for location menus.
+func (s *Server) upsertLocations(ctx context.Context, data map[string]map[string]huds.LocationMenu) error {
+	if err := s.store.UpsertLocations(ctx, data); err != nil {
+		return err
+	}
+	if s.shadow != nil {
+		s.shadow.UpsertLocations(ctx, data)
+	}
+	return nil
+}
+
+// compareWithShadow checks a day read from the primary against the shadow
+// store in the background, when reads are compared, so the read doesn't wait
+// on the shadow store.
+func (s *Server) compareWithShadow(menu huds.CondensedMenu) {
+	if s.shadow == nil || !s.shadow.CompareReads() {
+		return
+	}
+	go func() {
+		ctx, cancel := s.dbContext(context.Background())
+		defer cancel()
+		result, err := s.shadow.Compare(ctx, menu)
+		if err != nil {
+			log.Printf("Failed to read %s from the shadow store: %v\n", menu.ServeDate, err)
+			return
+		}
+		if result != store.ShadowMatching {
+			log.Printf("Shadow store is %s for %s\n", result, menu.ServeDate)
+		}
+	}()
+}
+
+// shadowStore answers 501 when no shadow store is configured.
+func (s *Server) shadowStore(c *gin.Context) (*store.Shadow, bool) {
+	if s.shadow == nil {
+		respondError(c, http.StatusNotImplemented, CodeNotImplemented, "no shadow store is configured; set SHADOW_STORE")
+		return nil, false
+	}
+	return s.shadow, true
+}
+
+// handleAdminShadow reports how the shadow store has kept up with the
+// primary.
+func (s *Server) handleAdminShadow(c *gin.Context) {
+	shadow, ok := s.shadowStore(c)
+	if !ok {
+		return
+	}
+	respond(c, http.StatusOK, shadow.Stats(), ResponseMeta{})
+}
+
+// handleAdminVerifyShadow compares the days from ?start= to ?end=, every day
+// stored by default, between the primary and shadow stores, as a last check
+// before cutting over. ?repair=true copies the days that differ to the shadow
+// store, which is also how to seed it with the days stored before it was
+// added.
+func (s *Server) handleAdminVerifyShadow(c *gin.Context) {
+	shadow, ok := s.shadowStore(c)
+	if !ok {
+		return
+	}
+	start, ok := s.queryDate(c, "start")
+	if !ok {
+		return
+	}
+	end, ok := s.queryDate(c, "end")
+	if !ok {
+		return
+	}
+	earliest, latest := s.recordRange()
+	if start.IsZero() {
+		start = earliest
+	}
+	if end.IsZero() {
+		end = latest
+	}
+	if end.Before(start) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "end must not be before start")
+		return
+	}
+	repair := c.Query("repair") == "true"
+
+	// Verifying every stored day takes longer than a request usually may
+	ctx, cancel := s.jobContext()
+	defer cancel()
+	results, err := shadow.Verify(ctx, s.store, start.Format(huds.ServeDateLayout), end.Format(huds.ServeDateLayout), repair)
+	if err != nil && results == nil {
+		log.Printf("Failed to verify the shadow store: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to compare the stores")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to repair the shadow store: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to copy the differing days to the shadow store")
+		return
+	}
+
+	verification := ShadowVerification{
+		Start:     start.Format(huds.ServeDateLayout),
+		End:       end.Format(huds.ServeDateLayout),
+		Compared:  len(results),
+		Divergent: []string{},
+		Missing:   []string{},
+		Repaired:  repair,
+	}
+	for date, result := range results {
+		switch result {
+		case store.ShadowMatching:
+			verification.Matching++
+		case store.ShadowDivergent:
+			verification.Divergent = append(verification.Divergent, date)
+		case store.ShadowMissing:
+			verification.Missing = append(verification.Missing, date)
+		}
+	}
+	byDate := func(dates []string) {
+		sort.Slice(dates, func(i, j int) bool {
+			a, _ := time.Parse(huds.ServeDateLayout, dates[i])
+			b, _ := time.Parse(huds.ServeDateLayout, dates[j])
+			return a.Before(b)
+		})
+	}
+	byDate(verification.Divergent)
+	byDate(verification.Missing)
+	respond(c, http.StatusOK, verification, ResponseMeta{})
+}
@@ -0,0 +1,419 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/scheduler"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const twilioApiUrl = "https://api.twilio.com/2010-04-01/Accounts/"
+
+// Long SMS bodies get split into several billed segments, so summaries are
+// kept to roughly two segments.
+const smsMaxLength = 300
+
+const (
+	SMSStatusPending  = "pending"
+	SMSStatusActive   = "active"
+	SMSStatusOptedOut = "opted_out"
+)
+
+const (
+	smsConfirmKeyword = "YES"
+	smsHelpMessage    = "HUDS menu texts. Reply STOP to unsubscribe, START to resubscribe."
+	smsConfirmMessage = "Reply YES to get a daily HUDS menu text at %s. Reply STOP to opt out. Msg & data rates may apply."
+	smsActiveMessage  = "You're subscribed to daily HUDS menu texts. Reply STOP at any time to opt out."
+)
+
+const (
+	// smsConfirmInterval is the least time between confirmation texts to a
+	// number that hasn't replied, so the endpoint can't be used to flood a
+	// phone with texts
+	smsConfirmInterval = 15 * time.Minute
+	// smsConfirmationsPerIP is how many confirmation texts one client
+	// address may set off in smsConfirmWindow
+	smsConfirmationsPerIP = 5
+	smsConfirmWindow      = time.Hour
+)
+
+var phonePattern = regexp.MustCompile(`^\+[1-9]\d{7,14}$`)
+
+type SMSSubscriber struct {
+	Phone     string    `json:"phone" bson:"_id"`
+	SendTime  string    `json:"send_time" bson:"send_time"`
+	Favorites []string  `json:"favorites" bson:"favorites"`
+	Status    string    `json:"status" bson:"status"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	// ConfirmationSentAt is when the number was last texted to confirm
+	ConfirmationSentAt *time.Time `json:"-" bson:"confirmation_sent_at,omitempty"`
+}
+
+type SMSSubscribeRequest struct {
+	Phone     string   `json:"phone" binding:"required"`
+	SendTime  string   `json:"send_time" binding:"required"`
+	Favorites []string `json:"favorites"`
+}
+
+// twilioSettings are what the Twilio notifier is configured with. They can be
+// swapped on a reload, so they are only read through current.
+type twilioSettings struct {
+	accountSid string
+	authToken  string
+	from       string
+	webhookUrl string
+}
+
+func loadTwilioSettings() twilioSettings {
+	return twilioSettings{
+		accountSid: os.Getenv("TWILIO_ACCOUNT_SID"),
+		authToken:  os.Getenv("TWILIO_AUTH_TOKEN"),
+		from:       os.Getenv("TWILIO_FROM_NUMBER"),
+		webhookUrl: os.Getenv("TWILIO_WEBHOOK_URL"),
+	}
+}
+
+type TwilioNotifier struct {
+	mu         sync.RWMutex
+	settings   twilioSettings
+	httpClient *http.Client
+}
+
+func (t *TwilioNotifier) current() twilioSettings {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.settings
+}
+
+// update replaces the settings, reporting whether they changed.
+func (t *TwilioNotifier) update(settings twilioSettings) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	changed := t.settings != settings
+	t.settings = settings
+	return changed
+}
+
+func (t *TwilioNotifier) Channel() string {
+	return "sms"
+}
+
+func (t *TwilioNotifier) Notify(phone string, message string) error {
+	settings := t.current()
+	form := url.Values{"To": {phone}, "From": {settings.from}, "Body": {message}}
+	req, err := http.NewRequest("POST", twilioApiUrl+settings.accountSid+"/Messages.json", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(settings.accountSid, settings.authToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var twilioErr struct {
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&twilioErr)
+		return fmt.Errorf("twilio returned %d: %s", resp.StatusCode, twilioErr.Message)
+	}
+	return nil
+}
+
+// validSignature checks the X-Twilio-Signature header of an inbound webhook,
+// which is an HMAC-SHA1 of the public webhook URL followed by every POST
+// parameter name and value in sorted order.
+func (t *TwilioNotifier) validSignature(webhookUrl string, params url.Values, signature string) bool {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	payload := webhookUrl
+	for _, key := range keys {
+		for _, value := range params[key] {
+			payload += key + value
+		}
+	}
+
+	mac := hmac.New(sha1.New, []byte(t.current().authToken))
+	mac.Write([]byte(payload))
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+type SMSService struct {
+	server      *Server
+	twilio      *TwilioNotifier
+	subscribers *mongo.Collection
+	// confirmations counts the confirmation texts each client address has
+	// set off in the current window
+	confirmations struct {
+		sync.Mutex
+		windowStart time.Time
+		byIP        map[string]int
+	}
+}
+
+// startSMSNotifications registers the Twilio notifier, the subscription and
+// inbound-message endpoints, and the per-minute delivery job. Inbound
+// messages can only be trusted with their Twilio signature, which is computed
+// over TWILIO_WEBHOOK_URL, so SMS isn't started without it.
+func (s *Server) startSMSNotifications(scheduler scheduler.Scheduler) {
+	settings := loadTwilioSettings()
+	if settings.webhookUrl == "" {
+		log.Println("TWILIO_WEBHOOK_URL must be set with TWILIO_ACCOUNT_SID to check inbound messages; SMS notifications are disabled")
+		return
+	}
+	s.sms = &SMSService{
+		server: s,
+		twilio: &TwilioNotifier{
+			settings:   settings,
+			httpClient: &http.Client{Timeout: 15 * time.Second},
+		},
+		subscribers: s.db.Collection("sms_subscribers"),
+	}
+	s.registerNotifier(s.sms.twilio)
+
+	_, err := scheduler.AddFunc("* * * * *", s.recoverJob("sms-deliveries", s.exclusive("sms-deliveries", s.sms.deliverDueSummaries)))
+	if err != nil {
+		log.Printf("Failed to schedule SMS deliveries: %v\n", err)
+	}
+}
+
+func (s *SMSService) routes(r gin.IRouter) {
+	r.POST("/sms/subscriptions", s.handleSubscribe)
+	r.POST("/sms/inbound", s.handleInbound)
+}
+
+// handleSubscribe creates a pending subscription and texts the number asking
+// for confirmation, so nobody can sign up someone else's phone. Confirmations
+// are throttled per number and per client address, as anyone can ask for
+// them.
+func (s *SMSService) handleSubscribe(c *gin.Context) {
+	var req SMSSubscribeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "phone and send_time are required")
+		return
+	}
+	if !phonePattern.MatchString(req.Phone) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "phone must be in E.164 format, e.g. +16175551234")
+		return
+	}
+	if !deliveryTimePattern.MatchString(req.SendTime) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "send_time must be HH:MM (24-hour)")
+		return
+	}
+
+	var existing SMSSubscriber
+	ctx, cancel := s.server.dbContext(c.Request.Context())
+	defer cancel()
+	err := s.subscribers.FindOne(ctx, bson.M{"_id": req.Phone}).Decode(&existing)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Printf("Failed to look up SMS subscriber: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to save subscription")
+		return
+	}
+	// Numbers that texted STOP must text START themselves; carriers require it
+	if existing.Status == SMSStatusOptedOut {
+		respondError(c, http.StatusConflict, CodeConflict, "this number has opted out; text START to resubscribe")
+		return
+	}
+
+	status := SMSStatusPending
+	if existing.Status == SMSStatusActive {
+		status = SMSStatusActive
+	}
+	now := s.server.clock.Now()
+	set := bson.M{"send_time": req.SendTime, "favorites": req.Favorites, "status": status}
+	if status == SMSStatusPending {
+		if existing.ConfirmationSentAt != nil && now.Sub(*existing.ConfirmationSentAt) < smsConfirmInterval {
+			retry := existing.ConfirmationSentAt.Add(smsConfirmInterval).Sub(now)
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+			respondError(c, http.StatusTooManyRequests, CodeRateLimited, "a confirmation was just texted to this number; reply YES to it")
+			return
+		}
+		if !s.allowConfirmation(c.ClientIP(), now) {
+			c.Header("Retry-After", strconv.Itoa(int(smsConfirmWindow.Seconds())))
+			respondError(c, http.StatusTooManyRequests, CodeRateLimited, "too many confirmation texts from this address; try again later")
+			return
+		}
+		set["confirmation_sent_at"] = now
+	}
+	update := bson.M{
+		"$set":         set,
+		"$setOnInsert": bson.M{"created_at": now},
+	}
+	_, err = s.subscribers.UpdateOne(ctx, bson.M{"_id": req.Phone}, update, options.Update().SetUpsert(true))
+	if err != nil {
+		log.Printf("Failed to save SMS subscriber: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to save subscription")
+		return
+	}
+
+	if status == SMSStatusPending {
+		if err := s.twilio.Notify(req.Phone, fmt.Sprintf(smsConfirmMessage, req.SendTime)); err != nil {
+			log.Printf("Failed to send SMS confirmation: %v\n", err)
+			respondError(c, http.StatusBadGateway, CodeUpstreamUnavailable, "failed to text confirmation")
+			return
+		}
+	}
+
+	respond(c, http.StatusAccepted, gin.H{"phone": req.Phone, "send_time": req.SendTime, "status": status}, ResponseMeta{})
+}
+
+// allowConfirmation counts a confirmation text set off from ip, reporting
+// whether it is within smsConfirmationsPerIP for the window.
+func (s *SMSService) allowConfirmation(ip string, now time.Time) bool {
+	s.confirmations.Lock()
+	defer s.confirmations.Unlock()
+	if s.confirmations.byIP == nil || now.Sub(s.confirmations.windowStart) >= smsConfirmWindow {
+		s.confirmations.windowStart = now
+		s.confirmations.byIP = make(map[string]int)
+	}
+	if s.confirmations.byIP[ip] >= smsConfirmationsPerIP {
+		return false
+	}
+	s.confirmations.byIP[ip]++
+	return true
+}
+
+// handleInbound is the Twilio messaging webhook. It handles the confirmation
+// reply and the standard STOP/START/HELP keywords.
+func (s *SMSService) handleInbound(c *gin.Context) {
+	if err := c.Request.ParseForm(); err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	// A reload may have cleared the URL; nothing can be verified without it
+	if webhookUrl := s.twilio.current().webhookUrl; webhookUrl == "" || !s.twilio.validSignature(webhookUrl, c.Request.PostForm, c.GetHeader("X-Twilio-Signature")) {
+		c.Status(http.StatusForbidden)
+		return
+	}
+
+	phone := c.Request.PostForm.Get("From")
+	keyword := strings.ToUpper(strings.TrimSpace(c.Request.PostForm.Get("Body")))
+
+	var status, reply string
+	switch keyword {
+	case "STOP", "STOPALL", "UNSUBSCRIBE", "CANCEL", "END", "QUIT":
+		// Twilio sends its own opt-out confirmation for these keywords
+		status = SMSStatusOptedOut
+	case "START", "UNSTOP", smsConfirmKeyword:
+		status, reply = SMSStatusActive, smsActiveMessage
+	default:
+		reply = smsHelpMessage
+	}
+
+	if status != "" && s.server.inMaintenance() {
+		// Twilio itself still blocks texts to numbers that opted out
+		log.Printf("Not updating SMS subscriber status to %s in maintenance mode\n", status)
+		if reply != "" {
+			reply = s.server.maintenanceReply()
+		}
+	} else if status != "" {
+		ctx, cancel := s.server.dbContext(c.Request.Context())
+		defer cancel()
+		_, err := s.subscribers.UpdateOne(ctx, bson.M{"_id": phone}, bson.M{"$set": bson.M{"status": status}})
+		if err != nil {
+			log.Printf("Failed to update SMS subscriber status: %v\n", err)
+		}
+	}
+
+	c.Header("Content-Type", "text/xml")
+	if reply == "" {
+		c.String(http.StatusOK, "<Response></Response>")
+		return
+	}
+	c.String(http.StatusOK, "<Response><Message>%s</Message></Response>", xmlEscape(reply))
+}
+
+func (s *SMSService) deliverDueSummaries() {
+	ctx, cancel := s.server.jobContext()
+	defer cancel()
+	now := s.server.localNow().Format("15:04")
+	cursor, err := s.subscribers.Find(ctx, bson.M{"status": SMSStatusActive, "send_time": now})
+	if err != nil {
+		log.Printf("Failed to find SMS subscribers: %v\n", err)
+		return
+	}
+	var subscribers []SMSSubscriber
+	if err := cursor.All(ctx, &subscribers); err != nil {
+		log.Printf("Failed to decode SMS subscribers: %v\n", err)
+		return
+	}
+	if len(subscribers) == 0 {
+		return
+	}
+
+	menu, err := s.server.menuByDate(ctx, s.server.today())
+	if err != nil {
+		log.Printf("Skipping SMS deliveries, no menu for today: %v\n", err)
+		return
+	}
+	for _, subscriber := range subscribers {
+		if err := s.twilio.Notify(subscriber.Phone, smsMenuSummary(menu, subscriber.Favorites)); err != nil {
+			log.Printf("Failed to text %s: %v\n", subscriber.Phone, err)
+		}
+	}
+}
+
+// smsMenuSummary condenses lunch and dinner into a single short text, leading
+// with any of the subscriber's favorite foods that are on today's menu.
+func smsMenuSummary(menu huds.CondensedMenu, favorites []string) string {
+	var b strings.Builder
+	if matches := favoriteMatches(menu, favorites); len(matches) > 0 {
+		names := make([]string, 0, len(matches))
+		for _, match := range matches {
+			names = append(names, match.String())
+		}
+		fmt.Fprintf(&b, "Favorites today: %s\n", strings.Join(names, ", "))
+	}
+	fmt.Fprintf(&b, "Lunch: %s\nDinner: %s", itemNames(menu.Lunch), itemNames(menu.Dinner))
+
+	summary := []rune(b.String())
+	if len(summary) > smsMaxLength {
+		return string(summary[:smsMaxLength-3]) + "..."
+	}
+	return string(summary)
+}
+
+func itemNames(items []huds.CondensedMenuItem) string {
+	if len(items) == 0 {
+		return "nothing listed"
+	}
+	names := make([]string, 0, len(items))
+	for _, item := range items {
+		names = append(names, item.FoodName)
+	}
+	return strings.Join(names, ", ")
+}
+
+var xmlReplacer = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\"", "&quot;", "'", "&apos;")
+
+func xmlEscape(s string) string {
+	return xmlReplacer.Replace(s)
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// twilioSignature signs an inbound webhook as Twilio documents it: the URL,
+// then each parameter name and value in name order.
+func twilioSignature(authToken string, webhookUrl string, form url.Values) string {
+	payload := webhookUrl
+	for _, key := range []string{"Body", "From", "To"} {
+		payload += key + form.Get(key)
+	}
+	mac := hmac.New(sha1.New, []byte(authToken))
+	mac.Write([]byte(payload))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestTwilioInboundSignature(t *testing.T) {
+	s, _ := newTestServer(t)
+	const webhookUrl = "https://hudsgry.example.com/sms/inbound"
+	sms := &SMSService{server: s, twilio: &TwilioNotifier{settings: twilioSettings{authToken: "twilio-auth-token", webhookUrl: webhookUrl}}}
+	router := gin.New()
+	sms.routes(router)
+
+	form := url.Values{"From": {"+16175551234"}, "To": {"+16175550000"}, "Body": {"help"}}
+	inbound := func(signature string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/sms/inbound", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if signature != "" {
+			req.Header.Set("X-Twilio-Signature", signature)
+		}
+		return serve(router, req)
+	}
+
+	w := inbound(twilioSignature("twilio-auth-token", webhookUrl, form))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<Message>") {
+		t.Errorf("a signed message: got %d %s, want a TwiML reply", w.Code, w.Body)
+	}
+	for name, signature := range map[string]string{
+		"unsigned":          "",
+		"another token":     twilioSignature("another-token", webhookUrl, form),
+		"another URL":       twilioSignature("twilio-auth-token", "https://attacker.example.com/sms/inbound", form),
+		"another recipient": twilioSignature("twilio-auth-token", webhookUrl, url.Values{"From": {"+16175559999"}, "To": {"+16175550000"}, "Body": {"help"}}),
+	} {
+		if w := inbound(signature); w.Code != http.StatusForbidden {
+			t.Errorf("%s: got %d, want 403", name, w.Code)
+		}
+	}
+
+	// Nothing can be verified once a reload clears the URL
+	sms.twilio.update(twilioSettings{authToken: "twilio-auth-token"})
+	if w := inbound(twilioSignature("twilio-auth-token", "", form)); w.Code != http.StatusForbidden {
+		t.Errorf("without TWILIO_WEBHOOK_URL: got %d, want 403", w.Code)
+	}
+}
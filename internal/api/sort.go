@@ -0,0 +1,99 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const itemSortKey = "item_sort"
+
+type itemSort struct {
+	field      store.ItemSort
+	descending bool
+}
+
+// parseItemSort reads ?sort=name|calories|category and ?order=asc|desc,
+// answering 400 if either is malformed.
+func parseItemSort(c *gin.Context) (itemSort, bool) {
+	var spec itemSort
+	switch field := store.ItemSort(strings.ToLower(c.Query("sort"))); field {
+	case store.SortRelevance, store.SortName, store.SortCalories, store.SortCategory:
+		spec.field = field
+	default:
+		respondErrorDetails(c, http.StatusBadRequest, CodeInvalidRequest, "sort must be name, calories or category", gin.H{"sort": c.Query("sort")})
+		return spec, false
+	}
+	switch order := strings.ToLower(c.Query("order")); order {
+	case "", "asc":
+	case "desc":
+		spec.descending = true
+	default:
+		respondErrorDetails(c, http.StatusBadRequest, CodeInvalidRequest, "order must be asc or desc", gin.H{"order": c.Query("order")})
+		return spec, false
+	}
+	return spec, true
+}
+
+// bindItemSort parses the sort order for withSort.
+func bindItemSort(c *gin.Context) {
+	spec, ok := parseItemSort(c)
+	if !ok {
+		return
+	}
+	c.Set(itemSortKey, spec)
+}
+
+// withSort orders each meal's items as bound by bindItemSort, comparing
+// calories as numbers rather than the strings HUDS sends. Items without
+// calories come last either way, and ties keep HUDS's order.
+func withSort(c *gin.Context, menu huds.CondensedMenu) huds.CondensedMenu {
+	spec, _ := c.Value(itemSortKey).(itemSort)
+	if spec.field == store.SortRelevance {
+		return menu
+	}
+	return menu.MapMeals(func(items []huds.CondensedMenuItem) []huds.CondensedMenuItem {
+		if items == nil {
+			return nil
+		}
+		sorted := append([]huds.CondensedMenuItem{}, items...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return compareItems(sorted[i], sorted[j], spec) < 0
+		})
+		return sorted
+	})
+}
+
+func compareItems(a huds.CondensedMenuItem, b huds.CondensedMenuItem, spec itemSort) int {
+	var c int
+	switch spec.field {
+	case store.SortName:
+		c = strings.Compare(strings.ToLower(a.FoodName), strings.ToLower(b.FoodName))
+	case store.SortCategory:
+		c = strings.Compare(strings.ToLower(a.MenuCategory), strings.ToLower(b.MenuCategory))
+		if c == 0 {
+			c = strings.Compare(strings.ToLower(a.FoodName), strings.ToLower(b.FoodName))
+		}
+	case store.SortCalories:
+		ca, cb := huds.ParseAmount(a.Calories, ""), huds.ParseAmount(b.Calories, "")
+		switch {
+		case ca == nil && cb == nil:
+			return 0
+		case ca == nil:
+			return 1
+		case cb == nil:
+			return -1
+		case ca.Value < cb.Value:
+			c = -1
+		case ca.Value > cb.Value:
+			c = 1
+		}
+	}
+	if spec.descending {
+		return -c
+	}
+	return c
+}
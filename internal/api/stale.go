@@ -0,0 +1,58 @@
+package api
+
+import (
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/provider"
+	"log"
+	"time"
+)
+
+// revalidateInterval is the least time between background refreshes started
+// by requests for a stale menu.
+const revalidateInterval = 5 * time.Minute
+
+// revalidateStale reports whether a menu is stale: it is for today or later,
+// and it was stored before today, and no full refresh has found it unchanged
+// since, so today's refresh hasn't completed. A
+// stale menu is still served, and a refresh is started in the background
+// unless one already is or was just tried.
+func (s *Server) revalidateStale(menu huds.CondensedMenu) bool {
+	if menu.UpdatedAt.IsZero() {
+		return false
+	}
+	now := s.localNow()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, huds.DiningZone)
+	date, err := time.ParseInLocation(huds.ServeDateLayout, menu.ServeDate, huds.DiningZone)
+	if err != nil || date.Before(today) || !menu.UpdatedAt.Before(today) {
+		return false
+	}
+	s.mu.RLock()
+	synced := !s.syncedAt.Before(today)
+	s.mu.RUnlock()
+	if synced {
+		return false
+	}
+
+	if s.inMaintenance() {
+		return true
+	}
+	s.revalidation.Lock()
+	defer s.revalidation.Unlock()
+	if s.revalidation.running || time.Since(s.revalidation.lastAttempt) < revalidateInterval {
+		return true
+	}
+	s.revalidation.running = true
+	s.revalidation.lastAttempt = time.Now()
+	go s.recoverJob("revalidate", func() {
+		defer func() {
+			s.revalidation.Lock()
+			s.revalidation.running = false
+			s.revalidation.Unlock()
+		}()
+		log.Printf("Menu for %s was stored %s, refreshing in the background\n", menu.ServeDate, menu.UpdatedAt.Format(time.RFC3339))
+		if err := s.fetchAndProcessData(provider.DateRange{}); err != nil {
+			log.Printf("Failed to revalidate stale menus: %v\n", err)
+		}
+	})()
+	return true
+}
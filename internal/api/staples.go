@@ -0,0 +1,106 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const staplesKey = "staples"
+
+// defaultStaples are what HUDS lists every day whatever else is on, used
+// unless STAPLES is set.
+var defaultStaples = []string{
+	"peanut butter", "jelly", "bagel", "cream cheese", "english muffin",
+	"cereal", "granola", "plain yogurt", "fresh fruit", "whole fruit",
+	"salad bar", "mixed greens", "romaine lettuce", "croutons", "hummus",
+	"ketchup", "yellow mustard", "mayonnaise", "sliced bread", "white rice",
+	"brown rice",
+}
+
+// loadStaples reads STAPLES, a comma-separated list of food names or parts
+// of them that are served every day, e.g. "peanut butter,bagel". "none"
+// turns staple detection off.
+func loadStaples() []string {
+	value := os.Getenv("STAPLES")
+	if value == "" {
+		return defaultStaples
+	}
+	if value == "none" {
+		return nil
+	}
+	var staples []string
+	for _, staple := range strings.Split(value, ",") {
+		if staple = strings.Join(searchWords(staple), " "); staple != "" {
+			staples = append(staples, staple)
+		}
+	}
+	return staples
+}
+
+func (s *Server) setStaples(staples []string) {
+	s.staples.Lock()
+	defer s.staples.Unlock()
+	s.staples.names = staples
+}
+
+func (s *Server) currentStaples() []string {
+	s.staples.RLock()
+	defer s.staples.RUnlock()
+	return s.staples.names
+}
+
+// isStaple reports whether a food name contains one of the staples as whole
+// words, allowing a plural, so "bagel" matches "Plain Bagels" but not
+// "Bagelwich".
+func isStaple(staples []string, foodName string) bool {
+	name := " " + strings.Join(searchWords(foodName), " ") + " "
+	for _, staple := range staples {
+		if strings.Contains(name, " "+staple+" ") || strings.Contains(name, " "+staple+"s ") || strings.Contains(name, " "+staple+"es ") {
+			return true
+		}
+	}
+	return false
+}
+
+type stapleFilter struct {
+	staples []string
+	hide    bool
+}
+
+// bindStaples parses ?hide_staples= for withStaples. Malformed values are
+// answered with 400.
+func (s *Server) bindStaples(c *gin.Context) {
+	filter := stapleFilter{staples: s.currentStaples()}
+	if value := c.Query("hide_staples"); value != "" {
+		hide, err := strconv.ParseBool(value)
+		if err != nil {
+			respondErrorDetails(c, http.StatusBadRequest, CodeInvalidRequest, "hide_staples must be true or false", gin.H{"hide_staples": value})
+			return
+		}
+		filter.hide = hide
+	}
+	c.Set(staplesKey, filter)
+}
+
+// withStaples marks the staples on a menu, or leaves them out when
+// ?hide_staples=true, so clients can show just the rotating dishes.
+func withStaples(c *gin.Context, menu huds.CondensedMenu) huds.CondensedMenu {
+	filter, _ := c.Value(staplesKey).(stapleFilter)
+	if len(filter.staples) == 0 {
+		return menu
+	}
+	return menu.MapMeals(func(items []huds.CondensedMenuItem) []huds.CondensedMenuItem {
+		marked := []huds.CondensedMenuItem{}
+		for _, item := range items {
+			item.Staple = isStaple(filter.staples, item.FoodName)
+			if !item.Staple || !filter.hide {
+				marked = append(marked, item)
+			}
+		}
+		return marked
+	})
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSuggestLimit = 10
+	maxSuggestLimit     = 25
+	// suggestHalfLife is how long it takes a food's weight in suggestions to
+	// halve after it was last served
+	suggestHalfLife = 30 * 24 * time.Hour
+)
+
+// foodName is a distinct food name in the catalog's name index.
+type foodName struct {
+	Name string
+	// Key is the name as lowercase words, which suggestions are matched on
+	Key string
+	// Served counts each meal of each day the food was on a menu
+	Served int
+	Last   time.Time
+}
+
+type Suggestion struct {
+	FoodName   string `json:"Food_Name"`
+	Served     int    `json:"served"`
+	LastServed string `json:"last_served"`
+}
+
+// indexFoodName adds a serving of an item to the name index.
+func (catalog *menuCatalog) indexFoodName(names map[string]*foodName, item huds.CondensedMenuItem, date time.Time) {
+	key := strings.Join(searchWords(item.FoodName), " ")
+	if key == "" {
+		return
+	}
+	name, ok := names[key]
+	if !ok {
+		name = &foodName{Key: key}
+		names[key] = name
+		catalog.Names = append(catalog.Names, name)
+	}
+	// Menus are in order, so the last spelling seen is the latest
+	name.Name = strings.TrimSpace(item.FoodName)
+	name.Served++
+	name.Last = date
+}
+
+// suggest returns up to limit food names starting with prefix, or with a word
+// starting with it. Names starting with it come first; within each, foods
+// served often and lately rank highest.
+func (catalog *menuCatalog) suggest(prefix string, now time.Time, limit int) []*foodName {
+	type match struct {
+		name  *foodName
+		start bool
+		score float64
+	}
+	var matches []match
+	for _, name := range catalog.Names {
+		start := strings.HasPrefix(name.Key, prefix)
+		if !start && !strings.Contains(name.Key, " "+prefix) {
+			continue
+		}
+		age := now.Sub(name.Last)
+		if age < 0 {
+			age = 0
+		}
+		score := float64(name.Served) * math.Pow(0.5, float64(age)/float64(suggestHalfLife))
+		matches = append(matches, match{name, start, score})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].start != matches[j].start {
+			return matches[i].start
+		}
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].name.Key < matches[j].name.Key
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	names := make([]*foodName, len(matches))
+	for i, m := range matches {
+		names[i] = m.name
+	}
+	return names
+}
+
+// handleSuggest completes what has been typed into a search box so far,
+// e.g. ?q=chi, from the names of every food on a stored menu.
+func (s *Server) handleSuggest(c *gin.Context) {
+	q := c.Query("q")
+	prefix := strings.Join(searchWords(q), " ")
+	if prefix == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "q is required")
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultSuggestLimit)))
+	if err != nil || limit < 1 || limit > maxSuggestLimit {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "limit must be between 1 and "+strconv.Itoa(maxSuggestLimit))
+		return
+	}
+	dateFormat := c.DefaultQuery("date_format", s.dateFormat)
+	if !validDateFormat(dateFormat) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "date_format must be 'us' or 'iso'")
+		return
+	}
+	catalog, err := s.loadCatalog()
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	suggestions := []Suggestion{}
+	for _, name := range catalog.suggest(prefix, s.localNow(), limit) {
+		suggestions = append(suggestions, Suggestion{
+			FoodName:   name.Name,
+			Served:     name.Served,
+			LastServed: formatServeDate(name.Last.Format(huds.ServeDateLayout), dateFormat),
+		})
+	}
+	respond(c, http.StatusOK, gin.H{"query": q, "suggestions": suggestions}, ResponseMeta{Source: SourceDB})
+}
@@ -0,0 +1,245 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	tagFilterKey = "tag_filter"
+	// tagCacheTTL is how long tags are kept in memory between reloads, so
+	// replicas pick up edits made through another one
+	tagCacheTTL   = time.Minute
+	maxRecipeTags = 20
+	maxTagLength  = 40
+)
+
+type TagCount struct {
+	Tag string `json:"tag"`
+	// Recipes is how many recipes carry the tag
+	Recipes int `json:"recipes"`
+}
+
+// normalizeTag lowercases a tag and collapses its spacing, so "Comfort  Food"
+// and "comfort food" are the same tag.
+func normalizeTag(tag string) string {
+	return strings.Join(strings.Fields(strings.ToLower(tag)), " ")
+}
+
+// recipeTags returns the tags on every tagged recipe, keyed by recipe number,
+// reloading them from the store once they are older than tagCacheTTL. Stores
+// that keep no tags have none.
+func (s *Server) recipeTags(c *gin.Context) (map[string][]string, error) {
+	tagStore, ok := s.store.(store.TagStore)
+	if !ok {
+		return nil, nil
+	}
+	s.tags.Lock()
+	defer s.tags.Unlock()
+	if s.tags.byRecipe != nil && time.Since(s.tags.loadedAt) < tagCacheTTL {
+		return s.tags.byRecipe, nil
+	}
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	recipes, err := tagStore.ListTags(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byRecipe := make(map[string][]string, len(recipes))
+	for _, recipe := range recipes {
+		byRecipe[recipe.RecipeNumber] = recipe.Tags
+	}
+	s.tags.byRecipe, s.tags.loadedAt = byRecipe, time.Now()
+	return byRecipe, nil
+}
+
+// resetRecipeTags drops the cached tags after an edit.
+func (s *Server) resetRecipeTags() {
+	s.tags.Lock()
+	defer s.tags.Unlock()
+	s.tags.byRecipe = nil
+}
+
+// tagFilter is what bindTags leaves for withTags: the tags on each recipe and
+// the ones asked for with ?tag=.
+type tagFilter struct {
+	byRecipe map[string][]string
+	want     []string
+}
+
+// bindTags loads the recipe tags for withTags and parses ?tag=, which may be
+// repeated or comma-separated, e.g. ?tag=spicy,soup.
+func (s *Server) bindTags(c *gin.Context) {
+	var filter tagFilter
+	for _, values := range c.QueryArray("tag") {
+		for _, tag := range strings.Split(values, ",") {
+			if tag = normalizeTag(tag); tag != "" {
+				filter.want = append(filter.want, tag)
+			}
+		}
+	}
+	if _, ok := s.store.(store.TagStore); !ok && len(filter.want) > 0 {
+		respondError(c, http.StatusNotImplemented, CodeNotImplemented, "tags are not supported by this storage backend")
+		return
+	}
+	byRecipe, err := s.recipeTags(c)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	filter.byRecipe = byRecipe
+	c.Set(tagFilterKey, filter)
+}
+
+// withTags joins each item with its recipe's tags and, if ?tag= was given,
+// narrows the menu to items carrying every tag asked for.
+func withTags(c *gin.Context, menu huds.CondensedMenu) huds.CondensedMenu {
+	filter, _ := c.Value(tagFilterKey).(tagFilter)
+	if len(filter.byRecipe) == 0 && len(filter.want) == 0 {
+		return menu
+	}
+	return menu.MapMeals(func(items []huds.CondensedMenuItem) []huds.CondensedMenuItem {
+		tagged := []huds.CondensedMenuItem{}
+		for _, item := range items {
+			item.Tags = filter.byRecipe[item.RecipeNumber]
+			if hasTags(item.Tags, filter.want) {
+				tagged = append(tagged, item)
+			}
+		}
+		return tagged
+	})
+}
+
+func hasTags(tags []string, want []string) bool {
+	for _, tag := range want {
+		found := false
+		for _, have := range tags {
+			if have == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// tagStore returns the menu store's tags, answering 501 if the configured
+// store keeps none.
+func (s *Server) tagStore(c *gin.Context) (store.TagStore, bool) {
+	tagStore, ok := s.store.(store.TagStore)
+	if !ok {
+		respondError(c, http.StatusNotImplemented, CodeNotImplemented, "tags are not supported by this storage backend")
+	}
+	return tagStore, ok
+}
+
+// handleTags lists every tag in use, for offering as ?tag= filters.
+func (s *Server) handleTags(c *gin.Context) {
+	if _, ok := s.tagStore(c); !ok {
+		return
+	}
+	byRecipe, err := s.recipeTags(c)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	recipes := make(map[string]int)
+	for _, tags := range byRecipe {
+		for _, tag := range tags {
+			recipes[tag]++
+		}
+	}
+	counts := make([]TagCount, 0, len(recipes))
+	for tag, count := range recipes {
+		counts = append(counts, TagCount{Tag: tag, Recipes: count})
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].Tag < counts[j].Tag })
+	respond(c, http.StatusOK, counts, ResponseMeta{Source: SourceDB})
+}
+
+// handleAdminListTags lists every tagged recipe.
+func (s *Server) handleAdminListTags(c *gin.Context) {
+	tagStore, ok := s.tagStore(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	recipes, err := tagStore.ListTags(ctx)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	respond(c, http.StatusOK, recipes, ResponseMeta{Source: SourceDB})
+}
+
+// handleAdminSetTags replaces the tags on a recipe, e.g. {"tags": ["spicy",
+// "soup"]}. Tags are lowercased and deduplicated.
+func (s *Server) handleAdminSetTags(c *gin.Context) {
+	tagStore, ok := s.tagStore(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Tags []string `json:"tags" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+	recipe := store.RecipeTags{RecipeNumber: strings.TrimSpace(c.Param("recipe")), Tags: []string{}, UpdatedAt: time.Now().UTC()}
+	seen := make(map[string]bool)
+	for _, tag := range req.Tags {
+		tag = normalizeTag(tag)
+		if tag == "" || len(tag) > maxTagLength {
+			respondErrorDetails(c, http.StatusBadRequest, CodeInvalidRequest, "tags must be between 1 and 40 characters", gin.H{"tag": tag})
+			return
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			recipe.Tags = append(recipe.Tags, tag)
+		}
+	}
+	if len(recipe.Tags) == 0 || len(recipe.Tags) > maxRecipeTags {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "a recipe takes between 1 and 20 tags; DELETE removes them all")
+		return
+	}
+	sort.Strings(recipe.Tags)
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	if err := tagStore.SetTags(ctx, recipe); err != nil {
+		log.Printf("Failed to store tags of recipe %s: %v\n", recipe.RecipeNumber, err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to store tags")
+		return
+	}
+	s.resetRecipeTags()
+	respond(c, http.StatusOK, recipe, ResponseMeta{})
+}
+
+// handleAdminDeleteTags removes every tag from a recipe.
+func (s *Server) handleAdminDeleteTags(c *gin.Context) {
+	tagStore, ok := s.tagStore(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	recipe := strings.TrimSpace(c.Param("recipe"))
+	if err := tagStore.SetTags(ctx, store.RecipeTags{RecipeNumber: recipe}); err != nil {
+		log.Printf("Failed to remove tags of recipe %s: %v\n", recipe, err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to store tags")
+		return
+	}
+	s.resetRecipeTags()
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,359 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/scheduler"
+	"hudsgry-api/internal/store"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const telegramApiUrl = "https://api.telegram.org/bot"
+
+type TelegramChat struct {
+	ID int64 `json:"id"`
+}
+
+type TelegramMessage struct {
+	Chat TelegramChat `json:"chat"`
+	Text string       `json:"text"`
+}
+
+type TelegramUpdate struct {
+	UpdateID int64            `json:"update_id"`
+	Message  *TelegramMessage `json:"message"`
+}
+
+type TelegramSubscriber struct {
+	ChatID       int64  `bson:"_id"`
+	DeliveryTime string `bson:"delivery_time"`
+}
+
+type TelegramBot struct {
+	server *Server
+	// mu guards token and webhookUrl, which can change on a reload
+	mu          sync.RWMutex
+	token       string
+	webhookUrl  string
+	subscribers *mongo.Collection
+	httpClient  *http.Client
+	// webhook is set when updates are pushed to /telegram/webhook rather
+	// than polled
+	webhook bool
+}
+
+const telegramHelp = `Commands:
+/today - today's full menu
+/tomorrow - tomorrow's full menu
+/breakfast, /lunch, /dinner - one meal from today's menu
+/deliver HH:MM - send me today's menu every day at this time
+/stop - stop daily deliveries`
+
+var deliveryTimePattern = regexp.MustCompile(`^([01]\d|2[0-3]):[0-5]\d$`)
+
+// startTelegramBot runs the bot in webhook mode when TELEGRAM_WEBHOOK_URL is set
+// (the webhook is served by the API router), otherwise it long-polls Telegram.
+// Daily deliveries are checked once a minute on the shared scheduler.
+func (s *Server) startTelegramBot(token string, scheduler scheduler.Scheduler) {
+	bot := &TelegramBot{
+		server:      s,
+		token:       token,
+		subscribers: s.db.Collection("telegram_subscribers"),
+		httpClient:  &http.Client{Timeout: 60 * time.Second},
+	}
+
+	if webhookUrl := os.Getenv("TELEGRAM_WEBHOOK_URL"); webhookUrl != "" {
+		bot.webhook = true
+		bot.webhookUrl = webhookUrl
+		if err := bot.setWebhook(webhookUrl); err != nil {
+			log.Printf("Failed to set Telegram webhook: %v\n", err)
+		}
+		log.Println("Telegram bot running in webhook mode")
+	} else {
+		if err := bot.call("deleteWebhook", map[string]string{}, nil); err != nil {
+			log.Printf("Failed to delete Telegram webhook: %v\n", err)
+		}
+		go s.recoverJob("telegram-polling", bot.poll)()
+		log.Println("Telegram bot running in long-polling mode")
+	}
+
+	s.registerNotifier(bot)
+	s.telegram = bot
+
+	_, err := scheduler.AddFunc("* * * * *", s.recoverJob("telegram-deliveries", s.exclusive("telegram-deliveries", bot.deliverDueMenus)))
+	if err != nil {
+		log.Printf("Failed to schedule Telegram deliveries: %v\n", err)
+	}
+}
+
+func (bot *TelegramBot) routes(r gin.IRouter) {
+	if bot.webhook {
+		r.POST("/telegram/webhook", bot.handleWebhook)
+	}
+}
+
+// reload switches to a new token and, in webhook mode, webhook URL, pointing
+// Telegram at the webhook again if either changed. Switching between webhook
+// and polling mode needs a restart. It reports whether anything changed.
+func (bot *TelegramBot) reload(token string, webhookUrl string) bool {
+	bot.mu.Lock()
+	if !bot.webhook {
+		webhookUrl = ""
+	}
+	changed := bot.token != token || bot.webhookUrl != webhookUrl
+	bot.token, bot.webhookUrl = token, webhookUrl
+	bot.mu.Unlock()
+
+	if changed && webhookUrl != "" {
+		if err := bot.setWebhook(webhookUrl); err != nil {
+			log.Printf("Failed to set Telegram webhook: %v\n", err)
+		}
+	}
+	return changed
+}
+
+// setWebhook points Telegram at the webhook, with the secret it must send
+// back with every update.
+func (bot *TelegramBot) setWebhook(webhookUrl string) error {
+	return bot.call("setWebhook", map[string]string{"url": webhookUrl, "secret_token": bot.webhookSecret()}, nil)
+}
+
+// webhookSecret is the secret_token Telegram sends updates with, in the
+// X-Telegram-Bot-Api-Secret-Token header. It is derived from the bot token so
+// every replica agrees on it without storing it, and changes with the token.
+func (bot *TelegramBot) webhookSecret() string {
+	bot.mu.RLock()
+	token := bot.token
+	bot.mu.RUnlock()
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte("telegram-webhook"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// call invokes a Telegram Bot API method and decodes its result into out, if given.
+func (bot *TelegramBot) call(method string, payload interface{}, out interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	bot.mu.RLock()
+	token := bot.token
+	bot.mu.RUnlock()
+	resp, err := bot.httpClient.Post(telegramApiUrl+token+"/"+method, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Ok          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if !result.Ok {
+		return fmt.Errorf("telegram %s failed: %s", method, result.Description)
+	}
+	if out != nil {
+		return json.Unmarshal(result.Result, out)
+	}
+	return nil
+}
+
+func (bot *TelegramBot) poll() {
+	var offset int64
+	for {
+		var updates []TelegramUpdate
+		err := bot.call("getUpdates", map[string]int64{"offset": offset, "timeout": 50}, &updates)
+		if err != nil {
+			log.Printf("Failed to get Telegram updates: %v\n", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		for _, update := range updates {
+			offset = update.UpdateID + 1
+			bot.handleUpdate(context.Background(), update)
+		}
+	}
+}
+
+func (bot *TelegramBot) handleWebhook(c *gin.Context) {
+	secret := c.GetHeader("X-Telegram-Bot-Api-Secret-Token")
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(bot.webhookSecret())) != 1 {
+		respondError(c, http.StatusUnauthorized, CodeUnauthorized, "invalid secret token")
+		return
+	}
+	var update TelegramUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid update")
+		return
+	}
+	bot.handleUpdate(c.Request.Context(), update)
+	c.Status(http.StatusOK)
+}
+
+func (bot *TelegramBot) handleUpdate(ctx context.Context, update TelegramUpdate) {
+	if update.Message == nil || update.Message.Text == "" {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	reply := bot.reply(ctx, chatID, update.Message.Text)
+	if err := bot.send(chatID, reply); err != nil {
+		log.Printf("Failed to reply to Telegram chat %d: %v\n", chatID, err)
+	}
+}
+
+func (bot *TelegramBot) Channel() string {
+	return "telegram"
+}
+
+// Notify sends a message to a chat; the recipient is the chat ID.
+func (bot *TelegramBot) Notify(recipient string, message string) error {
+	chatID, err := strconv.ParseInt(recipient, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid telegram chat id %q", recipient)
+	}
+	return bot.send(chatID, message)
+}
+
+func (bot *TelegramBot) send(chatID int64, text string) error {
+	return bot.call("sendMessage", map[string]interface{}{"chat_id": chatID, "text": text}, nil)
+}
+
+func (bot *TelegramBot) reply(ctx context.Context, chatID int64, text string) string {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return telegramHelp
+	}
+	// Commands in groups arrive as /today@botname
+	command := strings.SplitN(fields[0], "@", 2)[0]
+	now := bot.server.localNow()
+
+	switch command {
+	case "/today":
+		return bot.server.menuReply(ctx, bot.server.today(), "")
+	case "/tomorrow":
+		return bot.server.menuReply(ctx, now.AddDate(0, 0, 1).Format(huds.ServeDateLayout), "")
+	case "/breakfast", "/lunch", "/dinner":
+		return bot.server.menuReply(ctx, bot.server.today(), strings.TrimPrefix(command, "/"))
+	case "/deliver":
+		if len(fields) < 2 || !deliveryTimePattern.MatchString(fields[1]) {
+			return "Usage: /deliver HH:MM (24-hour time)"
+		}
+		if bot.server.inMaintenance() {
+			return bot.server.maintenanceReply()
+		}
+		ctx, cancel := bot.server.dbContext(ctx)
+		defer cancel()
+		_, err := bot.subscribers.UpdateOne(ctx, bson.M{"_id": chatID},
+			bson.M{"$set": bson.M{"delivery_time": fields[1]}}, options.Update().SetUpsert(true))
+		if err != nil {
+			log.Printf("Failed to save Telegram subscriber: %v\n", err)
+			return "Sorry, I couldn't save that. Try again later."
+		}
+		return fmt.Sprintf("I'll send you the menu every day at %s.", fields[1])
+	case "/stop":
+		if bot.server.inMaintenance() {
+			return bot.server.maintenanceReply()
+		}
+		ctx, cancel := bot.server.dbContext(ctx)
+		defer cancel()
+		if _, err := bot.subscribers.DeleteOne(ctx, bson.M{"_id": chatID}); err != nil {
+			log.Printf("Failed to remove Telegram subscriber: %v\n", err)
+			return "Sorry, I couldn't do that. Try again later."
+		}
+		return "Daily deliveries stopped."
+	default:
+		return telegramHelp
+	}
+}
+
+func (bot *TelegramBot) deliverDueMenus() {
+	ctx, cancel := bot.server.jobContext()
+	defer cancel()
+	now := bot.server.localNow().Format("15:04")
+	cursor, err := bot.subscribers.Find(ctx, bson.M{"delivery_time": now})
+	if err != nil {
+		log.Printf("Failed to find Telegram subscribers: %v\n", err)
+		return
+	}
+	var subscribers []TelegramSubscriber
+	if err := cursor.All(ctx, &subscribers); err != nil {
+		log.Printf("Failed to decode Telegram subscribers: %v\n", err)
+		return
+	}
+	if len(subscribers) == 0 {
+		return
+	}
+
+	text := bot.server.menuReply(ctx, bot.server.today(), "")
+	for _, subscriber := range subscribers {
+		if err := bot.send(subscriber.ChatID, text); err != nil {
+			log.Printf("Failed to deliver menu to Telegram chat %d: %v\n", subscriber.ChatID, err)
+		}
+	}
+}
+
+// menuReply renders the menu for a date as plain text, limited to a single meal
+// ("breakfast", "lunch" or "dinner") when one is given.
+func (s *Server) menuReply(ctx context.Context, date string, meal string) string {
+	ctx, cancel := s.dbContext(ctx)
+	defer cancel()
+	menu, err := s.menuByDate(ctx, date)
+	if err != nil {
+		if err == store.ErrMenuNotFound {
+			return fmt.Sprintf("No menu has been published for %s yet.", date)
+		}
+		log.Printf("Failed to fetch menu for %s: %v\n", date, err)
+		return "Sorry, I couldn't load the menu right now."
+	}
+	return formatMenuText(menu, date, meal)
+}
+
+func formatMenuText(menu huds.CondensedMenu, date string, meal string) string {
+	meals := []struct {
+		name  string
+		items []huds.CondensedMenuItem
+	}{
+		{"breakfast", menu.Breakfast},
+		{"lunch", menu.Lunch},
+		{"dinner", menu.Dinner},
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Menu for %s\n", date)
+	for _, m := range meals {
+		if meal != "" && meal != m.name {
+			continue
+		}
+		fmt.Fprintf(&b, "\n%s\n", strings.ToUpper(m.name))
+		if len(m.items) == 0 {
+			b.WriteString("  (nothing listed)\n")
+			continue
+		}
+		for _, item := range m.items {
+			fmt.Fprintf(&b, "- %s\n", item.FoodName)
+		}
+	}
+	return b.String()
+}
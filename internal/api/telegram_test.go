@@ -0,0 +1,44 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTelegramWebhookSecret(t *testing.T) {
+	s, _ := newTestServer(t)
+	sent := &outbox{}
+	bot := &TelegramBot{server: s, token: "123456:bot-token", webhook: true, httpClient: &http.Client{Transport: sent}}
+	router := gin.New()
+	bot.routes(router)
+
+	update := `{"update_id":1,"message":{"chat":{"id":42},"text":"/dinner"}}`
+	webhook := func(secret string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/telegram/webhook", strings.NewReader(update))
+		req.Header.Set("Content-Type", "application/json")
+		if secret != "" {
+			req.Header.Set("X-Telegram-Bot-Api-Secret-Token", secret)
+		}
+		return serve(router, req)
+	}
+
+	other := &TelegramBot{token: "654321:other-token"}
+	for name, secret := range map[string]string{"no secret": "", "another bot's secret": other.webhookSecret(), "the bot token": bot.token} {
+		if w := webhook(secret); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: got %d, want 401", name, w.Code)
+		}
+	}
+	if len(sent.sent) > 0 {
+		t.Fatalf("replied to unauthenticated updates: %q", sent.sent)
+	}
+
+	if w := webhook(bot.webhookSecret()); w.Code != http.StatusOK {
+		t.Fatalf("with the secret: got %d %s, want 200", w.Code, w.Body)
+	}
+	if len(sent.sent) != 1 || !strings.Contains(sent.sent[0], "Baked Haddock") {
+		t.Errorf("got replies %q, want today's dinner", sent.sent)
+	}
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"hudsgry-api/internal/scheduler"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Telemetry only ever stores aggregate counters per day and route: no IPs,
+// keys, user agents or individual requests. Query parameters are recorded by
+// name only, except for serve dates, which say nothing about the requester.
+type Telemetry struct {
+	server     *Server
+	collection *mongo.Collection
+
+	mu      sync.Mutex
+	pending map[string]*usageCounter
+}
+
+type usageCounter struct {
+	day    string
+	route  string
+	count  int
+	status map[string]int
+	params map[string]int
+	dates  map[string]int
+}
+
+type UsageDocument struct {
+	Day    string         `bson:"day"`
+	Route  string         `bson:"route"`
+	Count  int            `bson:"count"`
+	Status map[string]int `bson:"status"`
+	Params map[string]int `bson:"params"`
+	Dates  map[string]int `bson:"dates"`
+}
+
+type CountEntry struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type RouteUsage struct {
+	Route  string         `json:"route"`
+	Count  int            `json:"count"`
+	Status map[string]int `json:"status"`
+}
+
+type UsageReport struct {
+	Days           int          `json:"days"`
+	TotalRequests  int          `json:"total_requests"`
+	Routes         []RouteUsage `json:"routes"`
+	Filters        []CountEntry `json:"filters"`
+	RequestedDates []CountEntry `json:"requested_dates"`
+}
+
+// Only simple parameter names are kept so they are safe to use as field names
+var paramNamePattern = regexp.MustCompile(`^[a-z_]{1,32}$`)
+
+const maxReportDays = 365
+
+// startTelemetry turns on usage recording. Counters are batched in memory and
+// flushed once a minute rather than written on every request.
+func (s *Server) startTelemetry(scheduler scheduler.Scheduler) {
+	s.telemetry = &Telemetry{
+		server:     s,
+		collection: s.db.Collection("analytics"),
+		pending:    make(map[string]*usageCounter),
+	}
+	_, err := scheduler.AddFunc("* * * * *", s.recoverJob("telemetry", s.telemetry.flush))
+	if err != nil {
+		log.Printf("Failed to schedule telemetry flush: %v\n", err)
+	}
+	log.Println("Usage telemetry enabled")
+}
+
+func (t *Telemetry) middleware(c *gin.Context) {
+	c.Next()
+
+	route := c.FullPath()
+	// Unknown routes and clients that ask not to be tracked are skipped
+	if route == "" || c.GetHeader("DNT") == "1" {
+		return
+	}
+	route = c.Request.Method + " " + route
+	day := t.server.localNow().Format("2006-01-02")
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := day + "|" + route
+	counter, ok := t.pending[key]
+	if !ok {
+		counter = &usageCounter{day: day, route: route, status: map[string]int{}, params: map[string]int{}, dates: map[string]int{}}
+		t.pending[key] = counter
+	}
+	counter.count++
+	counter.status[strconv.Itoa(c.Writer.Status())]++
+	for name := range c.Request.URL.Query() {
+		if paramNamePattern.MatchString(name) {
+			counter.params[name]++
+		}
+	}
+	// Only dates bindServeDate accepted are counted, in one format whatever
+	// the client sent
+	if date, ok := c.Get(serveDateKey); ok {
+		counter.dates[date.(time.Time).Format("01-02-2006")]++
+	}
+}
+
+func (t *Telemetry) flush() {
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = make(map[string]*usageCounter)
+	t.mu.Unlock()
+
+	ctx, cancel := t.server.jobContext()
+	defer cancel()
+	for key, counter := range pending {
+		inc := bson.M{"count": counter.count}
+		for status, n := range counter.status {
+			inc["status."+status] = n
+		}
+		for name, n := range counter.params {
+			inc["params."+name] = n
+		}
+		for date, n := range counter.dates {
+			inc["dates."+date] = n
+		}
+		_, err := t.collection.UpdateOne(ctx, bson.M{"_id": key},
+			bson.M{"$inc": inc, "$set": bson.M{"day": counter.day, "route": counter.route}},
+			options.Update().SetUpsert(true))
+		if err != nil {
+			log.Printf("Failed to flush telemetry for %s: %v\n", key, err)
+		}
+	}
+}
+
+// handleReport sums the daily counters over the last ?days= days (default 30).
+func (t *Telemetry) handleReport(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 1 || days > maxReportDays {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "days must be between 1 and 365")
+		return
+	}
+	since := t.server.localNow().AddDate(0, 0, -days+1).Format("2006-01-02")
+
+	ctx, cancel := t.server.dbContext(c.Request.Context())
+	defer cancel()
+	cursor, err := t.collection.Find(ctx, bson.M{"day": bson.M{"$gte": since}})
+	if err != nil {
+		log.Printf("Failed to query telemetry: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load usage")
+		return
+	}
+	var docs []UsageDocument
+	if err := cursor.All(ctx, &docs); err != nil {
+		log.Printf("Failed to decode telemetry: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load usage")
+		return
+	}
+
+	report := UsageReport{Days: days, Routes: []RouteUsage{}}
+	routes := make(map[string]*RouteUsage)
+	filters := make(map[string]int)
+	dates := make(map[string]int)
+	for _, doc := range docs {
+		report.TotalRequests += doc.Count
+		usage, ok := routes[doc.Route]
+		if !ok {
+			usage = &RouteUsage{Route: doc.Route, Status: map[string]int{}}
+			routes[doc.Route] = usage
+		}
+		usage.Count += doc.Count
+		for status, n := range doc.Status {
+			usage.Status[status] += n
+		}
+		for name, n := range doc.Params {
+			filters[name] += n
+		}
+		for date, n := range doc.Dates {
+			dates[strings.ReplaceAll(date, "-", "/")] += n
+		}
+	}
+	for _, usage := range routes {
+		report.Routes = append(report.Routes, *usage)
+	}
+	sort.Slice(report.Routes, func(i, j int) bool { return report.Routes[i].Count > report.Routes[j].Count })
+	report.Filters = topCounts(filters, 20)
+	report.RequestedDates = topCounts(dates, 20)
+
+	respond(c, http.StatusOK, report, ResponseMeta{})
+}
+
+func topCounts(counts map[string]int, limit int) []CountEntry {
+	entries := make([]CountEntry, 0, len(counts))
+	for name, count := range counts {
+		entries = append(entries, CountEntry{Name: name, Count: count})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	defaultDBTimeout  = 5 * time.Second
+	defaultJobTimeout = 5 * time.Minute
+)
+
+// Timeouts bound how long storage work may take, so that a slow database node
+// fails requests and jobs instead of hanging them.
+type Timeouts struct {
+	// DB bounds each storage operation
+	DB time.Duration
+	// Job bounds all the storage work of one run of a scheduled job
+	Job time.Duration
+}
+
+// LoadTimeouts reads DB_TIMEOUT and JOB_TIMEOUT, which default to 5 seconds
+// and 5 minutes.
+func LoadTimeouts() (Timeouts, error) {
+	timeouts := Timeouts{DB: defaultDBTimeout, Job: defaultJobTimeout}
+	for name, timeout := range map[string]*time.Duration{"DB_TIMEOUT": &timeouts.DB, "JOB_TIMEOUT": &timeouts.Job} {
+		s := os.Getenv(name)
+		if s == "" {
+			continue
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return timeouts, fmt.Errorf("%s must be a positive duration, got %q", name, s)
+		}
+		*timeout = d
+	}
+	return timeouts, nil
+}
+
+// dbContext bounds one storage operation made on behalf of parent: a request's
+// context, so the operation also stops if the client goes away, or a job's.
+func (s *Server) dbContext(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, s.timeouts.DB)
+}
+
+// jobContext bounds work that isn't tied to a single request: a run of a
+// scheduled job, a startup task, or an on-demand fetch that several requests
+// may be waiting on. The job's storage operations share its deadline.
+func (s *Server) jobContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), s.timeouts.Job)
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"crypto/tls"
+	"fmt"
+	"golang.org/x/crypto/acme/autocert"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// TLSConfig is read from the environment (or .env):
+//
+//	TLS_CERT_FILE, TLS_KEY_FILE  serve HTTPS with this certificate and key
+//	TLS_DOMAINS                  or get certificates from Let's Encrypt for
+//	                             these comma-separated domains
+//	TLS_CACHE_DIR                where Let's Encrypt certificates are kept,
+//	                             default certs
+//	TLS_ADDR                     where HTTPS is served, default :443
+//
+// With neither, only plain HTTP is served. With either, plain HTTP redirects
+// to HTTPS.
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	Domains  []string
+	CacheDir string
+	Addr     string
+}
+
+func LoadTLSConfig() (TLSConfig, error) {
+	config := TLSConfig{
+		CertFile: os.Getenv("TLS_CERT_FILE"),
+		KeyFile:  os.Getenv("TLS_KEY_FILE"),
+		CacheDir: "certs",
+		Addr:     ":443",
+	}
+	for _, domain := range strings.Split(os.Getenv("TLS_DOMAINS"), ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			config.Domains = append(config.Domains, domain)
+		}
+	}
+	if dir := os.Getenv("TLS_CACHE_DIR"); dir != "" {
+		config.CacheDir = dir
+	}
+	if addr := os.Getenv("TLS_ADDR"); addr != "" {
+		config.Addr = addr
+	}
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return config, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if config.CertFile != "" && len(config.Domains) > 0 {
+		return config, fmt.Errorf("set either TLS_CERT_FILE and TLS_KEY_FILE or TLS_DOMAINS, not both")
+	}
+	return config, nil
+}
+
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || len(c.Domains) > 0
+}
+
+// RunTLS serves HTTPS as configured, with plain HTTP on addr redirecting to
+// it and answering Let's Encrypt's challenges. Without TLS configured it is
+// the same as Run.
+func (s *Server) RunTLS(addr string, config TLSConfig) error {
+	if !config.Enabled() {
+		return s.Run(addr)
+	}
+	handler, err := s.Handler()
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{Addr: config.Addr, Handler: handler}
+	redirect := redirectToHTTPS(config.Addr)
+	if len(config.Domains) > 0 {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(config.Domains...),
+			Cache:      autocert.DirCache(config.CacheDir),
+		}
+		server.TLSConfig = manager.TLSConfig()
+		redirect = manager.HTTPHandler(redirect)
+	} else {
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	go func() {
+		log.Printf("Redirecting HTTP on %s to HTTPS\n", addr)
+		if err := http.ListenAndServe(addr, redirect); err != nil {
+			log.Printf("Failed to serve the HTTP redirect: %v\n", err)
+		}
+	}()
+	log.Printf("Serving HTTPS on %s\n", config.Addr)
+	return server.ListenAndServeTLS(config.CertFile, config.KeyFile)
+}
+
+// redirectToHTTPS sends every request to the same URL over HTTPS on the port
+// of tlsAddr.
+func redirectToHTTPS(tlsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,13 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+func (s *Server) handleUpstreamMetrics(c *gin.Context) {
+	respond(c, http.StatusOK, gin.H{
+		"timeout_seconds": s.fetchTimeout.Seconds(),
+		"breaker":         s.breaker.Stats(),
+	}, ResponseMeta{})
+}
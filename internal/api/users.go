@@ -0,0 +1,380 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+	"hudsgry-api/internal/store"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sessionDuration   = 30 * 24 * time.Hour
+	minPasswordLength = 8
+	maxFavorites      = 100
+)
+
+type User struct {
+	ID            primitive.ObjectID    `json:"id" bson:"_id,omitempty"`
+	Email         string                `json:"email" bson:"email"`
+	PasswordHash  []byte                `json:"-" bson:"password_hash"`
+	Favorites     []string              `json:"favorites" bson:"favorites"`
+	Notifications []NotificationChannel `json:"notifications" bson:"notifications"`
+	Profile       DietaryProfile        `json:"profile" bson:"profile"`
+	Goals         NutritionGoals        `json:"goals" bson:"goals"`
+	CreatedAt     time.Time             `json:"created_at" bson:"created_at"`
+	// TokensRevokedAt invalidates every JWT issued to the user up to then
+	TokensRevokedAt *time.Time `json:"-" bson:"tokens_revoked_at,omitempty"`
+	// OIDC is the identity provider account the user logs in with, if any;
+	// such users may have no password
+	OIDC *OIDCIdentity `json:"oidc,omitempty" bson:"oidc,omitempty"`
+	// EmailVerified is set when an identity provider vouched for the email
+	// address; those given when registering aren't checked
+	EmailVerified bool `json:"email_verified" bson:"email_verified,omitempty"`
+}
+
+type Session struct {
+	TokenHash string             `bson:"_id"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+// errInvalidCredentials is an unknown email or a wrong password; callers
+// aren't told which.
+var errInvalidCredentials = errors.New("invalid email or password")
+
+type Credentials struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type FavoriteRequest struct {
+	FoodName string `json:"food_name" binding:"required"`
+}
+
+// setupUsers creates the user and session collections' indexes and registers
+// the account and favorites endpoints.
+func (s *Server) setupUsers() {
+	s.users = s.db.Collection("users")
+	s.sessions = s.db.Collection("sessions")
+
+	s.ensureIndexes("users",
+		store.IndexSpec{Collection: "users", Model: mongo.IndexModel{
+			Keys:    bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}},
+		// Mongo removes expired sessions on its own
+		store.IndexSpec{Collection: "sessions", Model: mongo.IndexModel{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		}},
+	)
+}
+
+func (s *Server) userRoutes(r gin.IRouter) {
+	r.POST("/users", s.handleRegister)
+	r.POST("/sessions", s.handleLogin)
+	r.DELETE("/sessions", s.requireUser, s.handleLogout)
+
+	me := r.Group("/me", s.requireUser)
+	me.GET("", handleGetMe)
+	me.GET("/favorites", handleGetFavorites)
+	me.POST("/favorites", s.handleAddFavorite)
+	me.DELETE("/favorites/:food_name", s.handleRemoveFavorite)
+}
+
+func (s *Server) handleRegister(c *gin.Context) {
+	var creds Credentials
+	if err := c.ShouldBindJSON(&creds); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "email and password are required")
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(creds.Email))
+	if _, err := mail.ParseAddress(email); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid email address")
+		return
+	}
+	if len(creds.Password) < minPasswordLength {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "password must be at least 8 characters")
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(creds.Password), bcrypt.DefaultCost)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid password")
+		return
+	}
+	user := User{Email: email, PasswordHash: hash, Favorites: []string{}, CreatedAt: s.clock.Now()}
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	result, err := s.users.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		respondError(c, http.StatusConflict, CodeConflict, "an account with this email already exists")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create user: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to create account")
+		return
+	}
+	user.ID = result.InsertedID.(primitive.ObjectID)
+
+	respond(c, http.StatusCreated, user, ResponseMeta{})
+}
+
+func (s *Server) handleLogin(c *gin.Context) {
+	var creds Credentials
+	if err := c.ShouldBindJSON(&creds); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "email and password are required")
+		return
+	}
+
+	user, err := s.checkCredentials(c, creds)
+	if err == errInvalidCredentials {
+		respondError(c, http.StatusUnauthorized, CodeUnauthorized, "invalid email or password")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to look up user: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to log in")
+		return
+	}
+
+	token, expiresAt, err := s.createSession(c, user.ID)
+	if err != nil {
+		log.Printf("Failed to create session: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to log in")
+		return
+	}
+
+	respond(c, http.StatusOK, gin.H{"token": token, "expires_at": expiresAt}, ResponseMeta{})
+}
+
+// createSession starts a session for the user, returning its token.
+func (s *Server) createSession(c *gin.Context, userID primitive.ObjectID) (string, time.Time, error) {
+	token, err := newSessionToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	expiresAt := s.clock.Now().Add(sessionDuration)
+	_, err = s.sessions.InsertOne(ctx, Session{TokenHash: hashToken(token), UserID: userID, ExpiresAt: expiresAt})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// signIn logs in a user who was authenticated some other way than a password,
+// issuing JWTs when they are configured and a session token otherwise. With
+// a redirect URL the browser is sent there with the tokens in the fragment,
+// where they stay out of server logs.
+func (s *Server) signIn(c *gin.Context, user User, redirectURL string) {
+	fragment := url.Values{}
+	var body interface{}
+	if s.jwt != nil {
+		tokens, err := s.issueTokens(c, user.ID)
+		if err != nil {
+			log.Printf("Failed to issue tokens: %v\n", err)
+			respondError(c, http.StatusInternalServerError, CodeInternal, "failed to log in")
+			return
+		}
+		fragment.Set("access_token", tokens.AccessToken)
+		fragment.Set("refresh_token", tokens.RefreshToken)
+		fragment.Set("expires_in", strconv.Itoa(tokens.ExpiresIn))
+		body = tokens
+	} else {
+		token, expiresAt, err := s.createSession(c, user.ID)
+		if err != nil {
+			log.Printf("Failed to create session: %v\n", err)
+			respondError(c, http.StatusInternalServerError, CodeInternal, "failed to log in")
+			return
+		}
+		fragment.Set("token", token)
+		fragment.Set("expires_at", expiresAt.UTC().Format(time.RFC3339))
+		body = gin.H{"token": token, "expires_at": expiresAt}
+	}
+	if redirectURL != "" {
+		c.Redirect(http.StatusFound, redirectURL+"#"+fragment.Encode())
+		return
+	}
+	respond(c, http.StatusOK, body, ResponseMeta{})
+}
+
+// checkCredentials finds the user with the email and password given.
+func (s *Server) checkCredentials(c *gin.Context, creds Credentials) (User, error) {
+	var user User
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	err := s.users.FindOne(ctx, bson.M{"email": strings.ToLower(strings.TrimSpace(creds.Email))}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return user, errInvalidCredentials
+	}
+	if err != nil {
+		return user, err
+	}
+	if bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(creds.Password)) != nil {
+		return user, errInvalidCredentials
+	}
+	return user, nil
+}
+
+// handleLogout ends the session, or revokes the access token when the
+// request was made with a JWT.
+func (s *Server) handleLogout(c *gin.Context) {
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	var err error
+	if id, ok := c.Get("token_id"); ok {
+		err = s.revokeAccessToken(ctx, id.(string), c.MustGet("token_expires_at").(time.Time))
+	} else {
+		_, err = s.sessions.DeleteOne(ctx, bson.M{"_id": hashToken(bearerToken(c))})
+	}
+	if err != nil {
+		log.Printf("Failed to delete session: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to log out")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// requireUser resolves the bearer token, a session token or a JWT access
+// token, to a user and stores it on the context under "user", rejecting the
+// request otherwise.
+func (s *Server) requireUser(c *gin.Context) {
+	token := bearerToken(c)
+	if token == "" {
+		respondError(c, http.StatusUnauthorized, CodeUnauthorized, "missing bearer token")
+		return
+	}
+	if s.jwt != nil && looksLikeJWT(token) {
+		user, err := s.authenticateJWT(c, token)
+		if err == errInvalidToken {
+			respondError(c, http.StatusUnauthorized, CodeUnauthorized, "invalid or expired token")
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to check access token: %v\n", err)
+			respondError(c, http.StatusInternalServerError, CodeInternal, "failed to authenticate")
+			return
+		}
+		c.Set("user", user)
+		c.Next()
+		return
+	}
+
+	var session Session
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	err := s.sessions.FindOne(ctx, bson.M{"_id": hashToken(token)}).Decode(&session)
+	// The TTL monitor only runs once a minute, so check expiry ourselves too
+	if err == mongo.ErrNoDocuments || (err == nil && s.clock.Now().After(session.ExpiresAt)) {
+		respondError(c, http.StatusUnauthorized, CodeUnauthorized, "invalid or expired token")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to look up session: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to authenticate")
+		return
+	}
+
+	var user User
+	if err := s.users.FindOne(ctx, bson.M{"_id": session.UserID}).Decode(&user); err != nil {
+		respondError(c, http.StatusUnauthorized, CodeUnauthorized, "invalid or expired token")
+		return
+	}
+	c.Set("user", user)
+	c.Next()
+}
+
+func currentUser(c *gin.Context) User {
+	return c.MustGet("user").(User)
+}
+
+func handleGetMe(c *gin.Context) {
+	respond(c, http.StatusOK, currentUser(c), ResponseMeta{})
+}
+
+func handleGetFavorites(c *gin.Context) {
+	respond(c, http.StatusOK, gin.H{"favorites": currentUser(c).Favorites}, ResponseMeta{})
+}
+
+func (s *Server) handleAddFavorite(c *gin.Context) {
+	var req FavoriteRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.FoodName) == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "food_name is required")
+		return
+	}
+	user := currentUser(c)
+	if len(user.Favorites) >= maxFavorites {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "too many favorites")
+		return
+	}
+
+	favorites, err := s.updateFavorites(c.Request.Context(), user.ID, bson.M{"$addToSet": bson.M{"favorites": strings.TrimSpace(req.FoodName)}})
+	if err != nil {
+		log.Printf("Failed to add favorite: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to update favorites")
+		return
+	}
+	respond(c, http.StatusOK, gin.H{"favorites": favorites}, ResponseMeta{})
+}
+
+func (s *Server) handleRemoveFavorite(c *gin.Context) {
+	favorites, err := s.updateFavorites(c.Request.Context(), currentUser(c).ID, bson.M{"$pull": bson.M{"favorites": c.Param("food_name")}})
+	if err != nil {
+		log.Printf("Failed to remove favorite: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to update favorites")
+		return
+	}
+	respond(c, http.StatusOK, gin.H{"favorites": favorites}, ResponseMeta{})
+}
+
+func (s *Server) updateFavorites(ctx context.Context, userID primitive.ObjectID, update bson.M) ([]string, error) {
+	ctx, cancel := s.dbContext(ctx)
+	defer cancel()
+	var user User
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := s.users.FindOneAndUpdate(ctx, bson.M{"_id": userID}, update, opts).Decode(&user)
+	if err != nil {
+		return nil, err
+	}
+	return user.Favorites, nil
+}
+
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+}
+
+func newSessionToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Only token hashes are stored, so a database leak doesn't leak sessions
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// LatestAPIVersion is the newest version mounted under /v<n>. Response shapes
+// only change in a new version; every version's routes are registered from
+// the same handlers, which check apiVersion when they need to differ.
+//
+// v2 wraps every successful response in an Envelope (see respond), and v3
+// serves each meal as separate Annenberg and house views (see HallViews).
+const LatestAPIVersion = 3
+
+// legacyAPIVersion is what the unversioned paths serve unless a client asks
+// for something else.
+const legacyAPIVersion = 1
+
+const apiVersionKey = "api_version"
+
+var vendorMediaType = regexp.MustCompile(`application/vnd\.hudsgry\.v(\d+)\+json`)
+
+// pinAPIVersion serves every route in a /v<n> group as that version.
+func pinAPIVersion(version int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(apiVersionKey, version)
+		c.Header("API-Version", strconv.Itoa(version))
+	}
+}
+
+// negotiateAPIVersion serves the deprecated unversioned paths. Clients can
+// pick a version with an API-Version header or an Accept of
+// application/vnd.hudsgry.v<n>+json; otherwise they get v1, which is what
+// these paths have always returned.
+func negotiateAPIVersion(c *gin.Context) {
+	version := legacyAPIVersion
+	requested := strings.TrimPrefix(strings.ToLower(c.GetHeader("API-Version")), "v")
+	if requested == "" {
+		if m := vendorMediaType.FindStringSubmatch(c.GetHeader("Accept")); m != nil {
+			requested = m[1]
+		}
+	}
+	if requested != "" {
+		v, err := strconv.Atoi(requested)
+		if err != nil || v < 1 || v > LatestAPIVersion {
+			respondError(c, http.StatusNotAcceptable, CodeUnsupportedVersion, fmt.Sprintf("API version must be between 1 and %d", LatestAPIVersion))
+			return
+		}
+		version = v
+	}
+
+	c.Set(apiVersionKey, version)
+	c.Header("API-Version", strconv.Itoa(version))
+	c.Header("Deprecation", "true")
+	c.Header("Link", fmt.Sprintf("</v%d%s>; rel=\"successor-version\"", version, c.Request.URL.Path))
+}
+
+// apiVersion returns the version a request is being served as.
+func apiVersion(c *gin.Context) int {
+	if version := c.GetInt(apiVersionKey); version > 0 {
+		return version
+	}
+	return legacyAPIVersion
+}
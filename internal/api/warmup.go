@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"log"
+	"net/http"
+	"time"
+)
+
+// warmDays is how many days either side of today are kept in memory, so the
+// morning rush is served without going to the store.
+const warmDays = 7
+
+// WarmUp is how far loading the days around today into memory has got.
+type WarmUp struct {
+	Done bool `json:"done"`
+	// Days is how many days the warm-up covers, and Loaded how many of them
+	// had a stored menu and are now in memory
+	Days   int    `json:"days"`
+	Loaded int    `json:"loaded"`
+	Error  string `json:"error,omitempty"`
+}
+
+type Readiness struct {
+	Ready  bool   `json:"ready"`
+	WarmUp WarmUp `json:"warm_up"`
+}
+
+// warmMenu returns a day's menu if it is held in memory.
+func (s *Server) warmMenu(date string) (huds.CondensedMenu, bool) {
+	s.warm.RLock()
+	defer s.warm.RUnlock()
+	menu, ok := s.warm.menus[date]
+	return menu, ok
+}
+
+// warmUp loads today and the days either side of it into memory, and today
+// into the local cache. It runs on startup and again after every refresh, so
+// that the days held follow the calendar and the stored menus.
+func (s *Server) warmUp(ctx context.Context, _ map[string]map[int][]huds.CondensedMenuItem) {
+	now := s.localNow()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, huds.DiningZone)
+	start, end := today.AddDate(0, 0, -warmDays), today.AddDate(0, 0, warmDays)
+	menus, err := s.store.GetRange(ctx, start.Format(huds.ServeDateLayout), end.Format(huds.ServeDateLayout))
+
+	s.warm.Lock()
+	defer s.warm.Unlock()
+	s.warm.progress = WarmUp{Done: true, Days: 2*warmDays + 1}
+	if err != nil {
+		log.Printf("Failed to warm up the menu cache: %v\n", err)
+		s.warm.progress.Error = err.Error()
+		return
+	}
+	s.warm.menus = make(map[string]huds.CondensedMenu, len(menus))
+	for _, menu := range menus {
+		s.warm.menus[menu.ServeDate] = menu
+		if menu.ServeDate == today.Format(huds.ServeDateLayout) {
+			s.setCachedMenu(menu)
+		}
+	}
+	s.warm.progress.Loaded = len(menus)
+}
+
+// handleReady is the readiness probe. It fails until the startup warm-up has
+// finished, so a load balancer only sends traffic once the cache is warm. A
+// warm-up that failed still counts as finished, since the store can serve.
+func (s *Server) handleReady(c *gin.Context) {
+	s.warm.RLock()
+	readiness := Readiness{Ready: s.warm.progress.Done, WarmUp: s.warm.progress}
+	s.warm.RUnlock()
+	status := http.StatusOK
+	if !readiness.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	respond(c, status, readiness, ResponseMeta{})
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"embed"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+)
+
+//go:embed web
+var webFiles embed.FS
+
+// registerWebRoutes serves the menu app at /, which is built on the JSON
+// endpoints, the OpenAPI spec and the interactive playground built on top of
+// it.
+func registerWebRoutes(router *gin.Engine) {
+	router.GET("/", serveWebFile("web/app.html", "text/html; charset=utf-8"))
+	router.GET("/openapi.json", serveWebFile("web/openapi.json", "application/json"))
+	router.GET("/playground", serveWebFile("web/playground.html", "text/html; charset=utf-8"))
+}
+
+func serveWebFile(name string, contentType string) gin.HandlerFunc {
+	data, err := webFiles.ReadFile(name)
+	if err != nil {
+		log.Fatalf("Missing embedded file %s: %v", name, err)
+	}
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, contentType, data)
+	}
+}
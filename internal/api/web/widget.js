@@ -0,0 +1,54 @@
+(function () {
+  var config = __WIDGET_CONFIG__;
+  var script = document.currentScript;
+
+  function el(tag, className, text) {
+    var node = document.createElement(tag);
+    if (className) node.className = className;
+    if (text) node.textContent = text;
+    return node;
+  }
+
+  if (!document.getElementById("hudsgry-widget-style")) {
+    var style = el("style");
+    style.id = "hudsgry-widget-style";
+    style.textContent =
+      ".hudsgry-widget { font-family: system-ui, sans-serif; font-size: 14px; color: #222; border: 1px solid #ddd; border-radius: 6px; padding: .75em 1em; max-width: 360px; }" +
+      ".hudsgry-widget h4 { color: #a51c30; margin: 0 0 .5em; font-size: 1.05em; }" +
+      ".hudsgry-widget h5 { color: #666; font-size: .75em; letter-spacing: .05em; text-transform: uppercase; margin: .6em 0 .2em; }" +
+      ".hudsgry-widget ul { list-style: none; margin: 0; padding: 0; }" +
+      ".hudsgry-widget li { padding: .1em 0; }" +
+      ".hudsgry-widget .tag { color: #1a7f40; font-size: .75em; font-weight: bold; margin-left: .4em; }" +
+      ".hudsgry-widget .empty { color: #666; margin: 0; }";
+    document.head.appendChild(style);
+  }
+
+  var root = el("div", "hudsgry-widget");
+  var menu = config.menu;
+  root.appendChild(el("h4", "", config.title));
+  if (!menu || menu.items.length === 0) {
+    root.appendChild(el("p", "empty", config.message));
+  } else {
+    var list = null, category = null;
+    menu.items.forEach(function (item) {
+      if (!list || item.category !== category) {
+        category = item.category;
+        if (category) root.appendChild(el("h5", "", category));
+        list = root.appendChild(el("ul"));
+      }
+      var row = el("li", "", item.name);
+      if (item.allergens) row.title = "Contains: " + item.allergens.join(", ");
+      if (item.vegan) row.appendChild(el("span", "tag", "VEGAN"));
+      else if (item.vegetarian) row.appendChild(el("span", "tag", "VEGETARIAN"));
+      list.appendChild(row);
+    });
+  }
+
+  var target = config.target && document.getElementById(config.target);
+  if (target) {
+    target.innerHTML = "";
+    target.appendChild(root);
+  } else if (script && script.parentNode) {
+    script.parentNode.insertBefore(root, script.nextSibling);
+  }
+})();
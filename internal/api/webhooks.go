@@ -0,0 +1,667 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"hudsgry-api/internal/scheduler"
+	"hudsgry-api/internal/store"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	maxWebhooksPerUser = 10
+	// maxWebhookAttempts is how many times a delivery is tried before it is
+	// parked in the dead-letter queue
+	maxWebhookAttempts = 8
+	// webhookBaseDelay doubles after every failed attempt, up to
+	// webhookMaxDelay, so a delivery is given up on after about an hour
+	webhookBaseDelay = 30 * time.Second
+	webhookMaxDelay  = time.Hour
+	// webhookLease is how long a retry has to finish before another run
+	// may pick the delivery up again
+	webhookLease = 5 * time.Minute
+	// deliveredRetention is how long successful deliveries are kept for
+	// /me/webhooks/:id/deliveries
+	deliveredRetention  = 7 * 24 * time.Hour
+	maxDeliveriesListed = 50
+	// webhookSecretGrace is how long a rotated-out secret keeps signing
+	// deliveries alongside the new one, so receivers can switch over
+	webhookSecretGrace = 24 * time.Hour
+	// signatureHeader carries the delivery's timestamp and signatures, e.g.
+	// t=1683295200,v1=5257a869...
+	signatureHeader = "X-Signature"
+)
+
+// Delivery statuses. Failed deliveries are moved to the dead-letter
+// collection rather than kept with this status.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is a URL that receives a POST with every batch of menu changes,
+// signed with its secret.
+type Webhook struct {
+	ID     primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID primitive.ObjectID `json:"-" bson:"user_id"`
+	URL    string             `json:"url" bson:"url"`
+	// Secret is only shown when the webhook is created and when it is
+	// rotated. Webhooks registered before signing have none until rotated.
+	Secret string `json:"secret,omitempty" bson:"secret,omitempty"`
+	// PreviousSecret also signs deliveries until PreviousSecretExpiresAt
+	PreviousSecret          string     `json:"-" bson:"previous_secret,omitempty"`
+	PreviousSecretExpiresAt *time.Time `json:"previous_secret_expires_at,omitempty" bson:"previous_secret_expires_at,omitempty"`
+	CreatedAt               time.Time  `json:"created_at" bson:"created_at"`
+}
+
+type WebhookRequest struct {
+	URL string `json:"url" binding:"required"`
+}
+
+// WebhookDelivery is one batch of changes sent, or being sent, to a webhook,
+// with every attempt made so far. Deliveries that run out of attempts are
+// moved to the dead-letter collection as they are, with FailedAt set.
+type WebhookDelivery struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	WebhookID primitive.ObjectID `json:"webhook_id" bson:"webhook_id"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	URL       string             `json:"url" bson:"url"`
+	Payload   json.RawMessage    `json:"payload,omitempty" bson:"payload"`
+	Status    string             `json:"status" bson:"status"`
+	Attempts  []DeliveryAttempt  `json:"attempts" bson:"attempts"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	// NextAttemptAt is when a pending delivery is next tried
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty" bson:"next_attempt_at,omitempty"`
+	FailedAt      *time.Time `json:"failed_at,omitempty" bson:"failed_at,omitempty"`
+	// ExpiresAt is when Mongo removes a successful delivery
+	ExpiresAt *time.Time `json:"-" bson:"expires_at,omitempty"`
+}
+
+type DeliveryAttempt struct {
+	At time.Time `json:"at" bson:"at"`
+	// StatusCode is the webhook's response, 0 if it couldn't be reached
+	StatusCode int    `json:"status_code,omitempty" bson:"status_code,omitempty"`
+	Error      string `json:"error,omitempty" bson:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms" bson:"duration_ms"`
+}
+
+func (s *Server) setupWebhooks(jobs scheduler.Scheduler) {
+	s.webhooks = s.db.Collection("webhooks")
+	s.webhookDeliveries = s.db.Collection("webhook_deliveries")
+	s.webhookDeadLetters = s.db.Collection("webhook_dead_letters")
+	s.ensureIndexes("webhook",
+		store.IndexSpec{Collection: "webhooks", Model: mongo.IndexModel{
+			Keys: bson.D{{Key: "user_id", Value: 1}},
+		}},
+		store.IndexSpec{Collection: "webhook_deliveries", Model: mongo.IndexModel{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}},
+		}},
+		store.IndexSpec{Collection: "webhook_deliveries", Model: mongo.IndexModel{
+			Keys: bson.D{{Key: "webhook_id", Value: 1}, {Key: "created_at", Value: -1}},
+		}},
+		// Only successful deliveries have expires_at, so Mongo removes them
+		// and nothing else
+		store.IndexSpec{Collection: "webhook_deliveries", Model: mongo.IndexModel{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		}},
+	)
+	s.changeListeners = append(s.changeListeners, s.deliverWebhooks)
+	if _, err := jobs.AddFunc("* * * * *", s.recoverJob("webhook retries", s.retryWebhooks)); err != nil {
+		log.Printf("Failed to schedule webhook retries: %v\n", err)
+	}
+}
+
+func (s *Server) webhookRoutes(r gin.IRouter) {
+	me := r.Group("/me", s.requireUser)
+	me.GET("/webhooks", s.handleListWebhooks)
+	me.POST("/webhooks", s.handleCreateWebhook)
+	me.DELETE("/webhooks/:id", s.handleDeleteWebhook)
+	me.POST("/webhooks/:id/secret", s.handleRotateWebhookSecret)
+	me.GET("/webhooks/:id/deliveries", s.handleListDeliveries)
+
+	if s.adminToken != "" {
+		r.GET("/admin/webhooks/dead-letters", s.requireAdmin, s.handleAdminDeadLetters)
+		r.POST("/admin/webhooks/dead-letters/:id/retry", s.requireAdmin, s.handleAdminRetryDeadLetter)
+	}
+}
+
+func (s *Server) handleListWebhooks(c *gin.Context) {
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	cursor, err := s.webhooks.Find(ctx, bson.M{"user_id": currentUser(c).ID})
+	if err != nil {
+		log.Printf("Failed to list webhooks: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load webhooks")
+		return
+	}
+	hooks := []Webhook{}
+	if err := cursor.All(ctx, &hooks); err != nil {
+		log.Printf("Failed to decode webhooks: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load webhooks")
+		return
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	respond(c, http.StatusOK, gin.H{"webhooks": hooks}, ResponseMeta{})
+}
+
+func (s *Server) handleCreateWebhook(c *gin.Context) {
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "url is required")
+		return
+	}
+	target, err := url.Parse(req.URL)
+	if err != nil || (target.Scheme != "https" && target.Scheme != "http") || target.Host == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "url must be an absolute http(s) URL")
+		return
+	}
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	if err := checkWebhookHost(ctx, target.Hostname()); err != nil {
+		respondErrorDetails(c, http.StatusBadRequest, CodeInvalidRequest, "url must be reachable on the public internet", gin.H{"reason": err.Error()})
+		return
+	}
+	user := currentUser(c)
+	count, err := s.webhooks.CountDocuments(ctx, bson.M{"user_id": user.ID})
+	if err != nil {
+		log.Printf("Failed to count webhooks: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to create webhook")
+		return
+	}
+	if count >= maxWebhooksPerUser {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "too many webhooks")
+		return
+	}
+
+	secret, err := newWebhookSecret()
+	if err != nil {
+		log.Printf("Failed to generate webhook secret: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to create webhook")
+		return
+	}
+	hook := Webhook{UserID: user.ID, URL: target.String(), Secret: secret, CreatedAt: s.clock.Now()}
+	result, err := s.webhooks.InsertOne(ctx, hook)
+	if err != nil {
+		log.Printf("Failed to create webhook: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to create webhook")
+		return
+	}
+	hook.ID = result.InsertedID.(primitive.ObjectID)
+	respond(c, http.StatusCreated, hook, ResponseMeta{})
+}
+
+func (s *Server) handleDeleteWebhook(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid webhook id")
+		return
+	}
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	result, err := s.webhooks.DeleteOne(ctx, bson.M{"_id": id, "user_id": currentUser(c).ID})
+	if err != nil {
+		log.Printf("Failed to delete webhook: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to delete webhook")
+		return
+	}
+	if result.DeletedCount == 0 {
+		respondError(c, http.StatusNotFound, CodeNotFound, "webhook not found")
+		return
+	}
+	// Nothing is left to deliver to; dead letters stay for the admins
+	if _, err := s.webhookDeliveries.DeleteMany(ctx, bson.M{"webhook_id": id}); err != nil {
+		log.Printf("Failed to delete webhook deliveries: %v\n", err)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// handleRotateWebhookSecret replaces a webhook's secret. Deliveries are
+// signed with the old secret as well for webhookSecretGrace, so receivers
+// can switch over without dropping any.
+func (s *Server) handleRotateWebhookSecret(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid webhook id")
+		return
+	}
+	secret, err := newWebhookSecret()
+	if err != nil {
+		log.Printf("Failed to generate webhook secret: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to rotate secret")
+		return
+	}
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	filter := bson.M{"_id": id, "user_id": currentUser(c).ID}
+	var hook Webhook
+	err = s.webhooks.FindOne(ctx, filter).Decode(&hook)
+	if err == mongo.ErrNoDocuments {
+		respondError(c, http.StatusNotFound, CodeNotFound, "webhook not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load webhook: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to rotate secret")
+		return
+	}
+
+	update := bson.M{"$set": bson.M{"secret": secret}, "$unset": bson.M{"previous_secret": "", "previous_secret_expires_at": ""}}
+	hook.PreviousSecretExpiresAt = nil
+	if hook.Secret != "" {
+		expires := s.clock.Now().Add(webhookSecretGrace)
+		update = bson.M{"$set": bson.M{"secret": secret, "previous_secret": hook.Secret, "previous_secret_expires_at": expires}}
+		hook.PreviousSecretExpiresAt = &expires
+	}
+	if _, err := s.webhooks.UpdateOne(ctx, filter, update); err != nil {
+		log.Printf("Failed to rotate webhook secret: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to rotate secret")
+		return
+	}
+	hook.Secret = secret
+	respond(c, http.StatusOK, hook, ResponseMeta{})
+}
+
+// handleListDeliveries lists a webhook's latest deliveries, pending and
+// delivered, with every attempt. Payloads are left out.
+func (s *Server) handleListDeliveries(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid webhook id")
+		return
+	}
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	filter := bson.M{"webhook_id": id, "user_id": currentUser(c).ID}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(maxDeliveriesListed).SetProjection(bson.M{"payload": 0})
+	deliveries, err := findDeliveries(ctx, s.webhookDeliveries, filter, opts)
+	if err != nil {
+		log.Printf("Failed to list webhook deliveries: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load deliveries")
+		return
+	}
+	failed, err := findDeliveries(ctx, s.webhookDeadLetters, filter, opts)
+	if err != nil {
+		log.Printf("Failed to list webhook dead letters: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load deliveries")
+		return
+	}
+	respond(c, http.StatusOK, gin.H{"deliveries": deliveries, "failed": failed}, ResponseMeta{})
+}
+
+// handleAdminDeadLetters lists the deliveries that ran out of attempts,
+// latest first, payloads included.
+func (s *Server) handleAdminDeadLetters(c *gin.Context) {
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	opts := options.Find().SetSort(bson.D{{Key: "failed_at", Value: -1}}).SetLimit(maxDeliveriesListed)
+	letters, err := findDeliveries(ctx, s.webhookDeadLetters, bson.M{}, opts)
+	if err != nil {
+		log.Printf("Failed to list webhook dead letters: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load dead letters")
+		return
+	}
+	total, err := s.webhookDeadLetters.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		log.Printf("Failed to count webhook dead letters: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load dead letters")
+		return
+	}
+	respond(c, http.StatusOK, gin.H{"dead_letters": letters, "total": total}, ResponseMeta{})
+}
+
+// handleAdminRetryDeadLetter queues a dead letter for delivery again, with a
+// fresh set of attempts, if its webhook still exists.
+func (s *Server) handleAdminRetryDeadLetter(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid dead letter id")
+		return
+	}
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	var letter WebhookDelivery
+	err = s.webhookDeadLetters.FindOne(ctx, bson.M{"_id": id}).Decode(&letter)
+	if err == mongo.ErrNoDocuments {
+		respondError(c, http.StatusNotFound, CodeNotFound, "dead letter not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load webhook dead letter: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to retry dead letter")
+		return
+	}
+	var hook Webhook
+	err = s.webhooks.FindOne(ctx, bson.M{"_id": letter.WebhookID}).Decode(&hook)
+	if err == mongo.ErrNoDocuments {
+		respondError(c, http.StatusConflict, CodeConflict, "the webhook has since been deleted")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load webhook: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to retry dead letter")
+		return
+	}
+
+	now := s.clock.Now()
+	delivery := letter
+	delivery.URL = hook.URL
+	delivery.Status = DeliveryPending
+	delivery.Attempts = []DeliveryAttempt{}
+	delivery.NextAttemptAt = &now
+	delivery.FailedAt = nil
+	if _, err := s.webhookDeliveries.InsertOne(ctx, delivery); err != nil {
+		log.Printf("Failed to requeue webhook delivery: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to retry dead letter")
+		return
+	}
+	if _, err := s.webhookDeadLetters.DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		log.Printf("Failed to delete webhook dead letter: %v\n", err)
+	}
+	delivery.Payload = nil
+	respond(c, http.StatusAccepted, delivery, ResponseMeta{})
+}
+
+func findDeliveries(ctx context.Context, collection *mongo.Collection, filter bson.M, opts *options.FindOptions) ([]WebhookDelivery, error) {
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	deliveries := []WebhookDelivery{}
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// deliverWebhooks posts a batch of changes to every registered webhook. Each
+// delivery is first tried in the background; failures are retried by
+// retryWebhooks.
+func (s *Server) deliverWebhooks(ctx context.Context, changes []MenuChange) {
+	cursor, err := s.webhooks.Find(ctx, bson.M{})
+	if err != nil {
+		log.Printf("Failed to load webhooks: %v\n", err)
+		return
+	}
+	var hooks []Webhook
+	if err := cursor.All(ctx, &hooks); err != nil {
+		log.Printf("Failed to decode webhooks: %v\n", err)
+		return
+	}
+	body, err := json.Marshal(gin.H{"events": changes})
+	if err != nil {
+		log.Printf("Failed to encode webhook payload: %v\n", err)
+		return
+	}
+
+	for _, hook := range hooks {
+		go func(hook Webhook) {
+			ctx, cancel := s.jobContext()
+			defer cancel()
+			delivery := WebhookDelivery{
+				ID:        primitive.NewObjectID(),
+				WebhookID: hook.ID,
+				UserID:    hook.UserID,
+				URL:       hook.URL,
+				Payload:   body,
+				CreatedAt: s.clock.Now(),
+			}
+			s.attemptDelivery(&delivery, hook)
+			if _, err := s.webhookDeliveries.InsertOne(ctx, delivery); err != nil {
+				log.Printf("Failed to record webhook delivery to %s: %v\n", hook.ID.Hex(), err)
+				return
+			}
+			if delivery.Status == DeliveryFailed {
+				s.deadLetter(ctx, delivery)
+			}
+		}(hook)
+	}
+}
+
+// retryWebhooks tries every pending delivery that is due again. Each is
+// leased first, so a slow run and the next don't both send it.
+func (s *Server) retryWebhooks() {
+	ctx, cancel := s.jobContext()
+	defer cancel()
+	for {
+		now := s.clock.Now()
+		lease := now.Add(webhookLease)
+		var delivery WebhookDelivery
+		err := s.webhookDeliveries.FindOneAndUpdate(ctx,
+			bson.M{"status": DeliveryPending, "next_attempt_at": bson.M{"$lte": now}},
+			bson.M{"$set": bson.M{"next_attempt_at": lease}},
+			options.FindOneAndUpdate().SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}),
+		).Decode(&delivery)
+		if err == mongo.ErrNoDocuments {
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to load due webhook deliveries: %v\n", err)
+			return
+		}
+
+		var hook Webhook
+		err = s.webhooks.FindOne(ctx, bson.M{"_id": delivery.WebhookID}).Decode(&hook)
+		if err == mongo.ErrNoDocuments {
+			// Deleted since; there is no one left to deliver to
+			if _, err := s.webhookDeliveries.DeleteOne(ctx, bson.M{"_id": delivery.ID}); err != nil {
+				log.Printf("Failed to delete webhook delivery %s: %v\n", delivery.ID.Hex(), err)
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to load webhook %s: %v\n", delivery.WebhookID.Hex(), err)
+			return
+		}
+
+		s.attemptDelivery(&delivery, hook)
+		if delivery.Status == DeliveryFailed {
+			s.deadLetter(ctx, delivery)
+			continue
+		}
+		_, err = s.webhookDeliveries.UpdateOne(ctx, bson.M{"_id": delivery.ID}, bson.M{"$set": bson.M{
+			"status":          delivery.Status,
+			"attempts":        delivery.Attempts,
+			"next_attempt_at": delivery.NextAttemptAt,
+			"expires_at":      delivery.ExpiresAt,
+		}})
+		if err != nil {
+			log.Printf("Failed to record webhook delivery %s: %v\n", delivery.ID.Hex(), err)
+		}
+	}
+}
+
+// attemptDelivery posts the delivery's payload once, records the attempt and
+// sets what happens next: nothing once delivered, another attempt after an
+// exponential backoff, or the dead-letter queue once attempts run out. Only
+// a 2xx response counts as delivered.
+func (s *Server) attemptDelivery(delivery *WebhookDelivery, hook Webhook) {
+	started := s.clock.Now()
+	attempt := DeliveryAttempt{At: started}
+	delivery.URL = hook.URL
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	var resp *http.Response
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Webhook-Id", hook.ID.Hex())
+		// Receivers can drop a delivery they've already processed by its ID
+		req.Header.Set("X-Delivery-Id", delivery.ID.Hex())
+		if signature := hook.signature(delivery.Payload, started); signature != "" {
+			req.Header.Set(signatureHeader, signature)
+		}
+		resp, err = s.webhookClient.Do(req)
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	} else {
+		resp.Body.Close()
+		attempt.StatusCode = resp.StatusCode
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			attempt.Error = fmt.Sprintf("webhook returned %d", resp.StatusCode)
+		}
+	}
+	now := s.clock.Now()
+	attempt.DurationMS = now.Sub(started).Milliseconds()
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	delivery.NextAttemptAt = nil
+
+	switch {
+	case attempt.Error == "":
+		delivery.Status = DeliveryDelivered
+		expires := now.Add(deliveredRetention)
+		delivery.ExpiresAt = &expires
+	case len(delivery.Attempts) >= maxWebhookAttempts:
+		log.Printf("Giving up on webhook delivery %s after %d attempts: %s\n", delivery.ID.Hex(), len(delivery.Attempts), attempt.Error)
+		delivery.Status = DeliveryFailed
+		delivery.FailedAt = &now
+	default:
+		delivery.Status = DeliveryPending
+		next := now.Add(webhookBackoff(len(delivery.Attempts)))
+		delivery.NextAttemptAt = &next
+	}
+}
+
+// webhookBackoff is the wait after the given number of failed attempts.
+func webhookBackoff(attempts int) time.Duration {
+	delay := webhookBaseDelay
+	for i := 1; i < attempts && delay < webhookMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > webhookMaxDelay {
+		delay = webhookMaxDelay
+	}
+	return delay
+}
+
+// deadLetter moves a delivery that ran out of attempts to the dead-letter
+// collection.
+func (s *Server) deadLetter(ctx context.Context, delivery WebhookDelivery) {
+	if _, err := s.webhookDeadLetters.InsertOne(ctx, delivery); err != nil {
+		log.Printf("Failed to dead-letter webhook delivery %s: %v\n", delivery.ID.Hex(), err)
+		return
+	}
+	if _, err := s.webhookDeliveries.DeleteOne(ctx, bson.M{"_id": delivery.ID}); err != nil {
+		log.Printf("Failed to delete dead-lettered webhook delivery %s: %v\n", delivery.ID.Hex(), err)
+	}
+}
+
+// errWebhookAddress is a webhook host that resolves to an address the
+// service won't deliver to.
+var errWebhookAddress = errors.New("webhooks can't be delivered to private, loopback or link-local addresses")
+
+// newWebhookClient delivers webhooks only to public addresses. The address is
+// checked as each connection is made, after the host is resolved, so a host
+// that passed when the webhook was registered can't be pointed inside the
+// network later, and neither can a redirect. Proxies from the environment
+// aren't used, as the check would then be of the proxy.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicAddress(ip) {
+				return errWebhookAddress
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	return &http.Client{Timeout: 10 * time.Second, Transport: transport}
+}
+
+// checkWebhookHost refuses a webhook whose host resolves to an address it
+// couldn't be delivered to, so the mistake is reported when the webhook is
+// registered rather than on every delivery.
+func checkWebhookHost(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if !publicAddress(ip) {
+			return errWebhookAddress
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("%s doesn't resolve", host)
+	}
+	for _, addr := range addrs {
+		if !publicAddress(addr.IP) {
+			return errWebhookAddress
+		}
+	}
+	return nil
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, which is no more public
+// than the private ranges.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicAddress reports whether ip is routable on the public internet, and
+// so not the service's own host, its network or the cloud metadata service
+// at 169.254.169.254.
+func publicAddress(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip))
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// signature signs a payload sent at the given time, as the X-Signature
+// header: t=<unix seconds>, then v1=<hex HMAC-SHA256 of "<t>.<payload>"> for
+// each of the webhook's secrets. Receivers should check one v1 matches and
+// reject timestamps more than a few minutes old, so a captured delivery
+// can't be replayed. It is empty for webhooks without a secret.
+func (hook Webhook) signature(payload []byte, at time.Time) string {
+	if hook.Secret == "" {
+		return ""
+	}
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	parts := []string{"t=" + timestamp, "v1=" + signPayload(hook.Secret, timestamp, payload)}
+	if hook.PreviousSecret != "" && hook.PreviousSecretExpiresAt != nil && at.Before(*hook.PreviousSecretExpiresAt) {
+		parts = append(parts, "v1="+signPayload(hook.PreviousSecret, timestamp, payload))
+	}
+	return strings.Join(parts, ",")
+}
+
+func signPayload(secret string, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
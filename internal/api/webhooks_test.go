@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// verifyWebhookSignature checks an X-Signature header as a receiver would,
+// following the webhook documentation rather than signPayload.
+func verifyWebhookSignature(header string, secret string, payload []byte, now time.Time) bool {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || now.Sub(time.Unix(sent, 0)).Abs() > 5*time.Minute {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + string(payload)))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), []byte(expected)) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestWebhookSignature(t *testing.T) {
+	payload := []byte(`{"events":[{"type":"menu.updated","Serve_Date":"10/16/2026","meal":"dinner"}]}`)
+	at := time.Date(2026, time.October, 16, 12, 0, 0, 0, time.UTC)
+	hook := Webhook{Secret: "whsec_current"}
+
+	header := hook.signature(payload, at)
+	if !strings.HasPrefix(header, "t=1792152000,v1=") {
+		t.Fatalf("got X-Signature %q", header)
+	}
+	if !verifyWebhookSignature(header, "whsec_current", payload, at) {
+		t.Errorf("the signature doesn't verify with the secret")
+	}
+	if verifyWebhookSignature(header, "whsec_other", payload, at) {
+		t.Errorf("the signature verifies with another secret")
+	}
+	if verifyWebhookSignature(header, "whsec_current", append(payload, ' '), at) {
+		t.Errorf("the signature verifies for another payload")
+	}
+
+	// For a day after rotating, deliveries are signed with both secrets
+	expires := at.Add(24 * time.Hour)
+	hook.PreviousSecret, hook.PreviousSecretExpiresAt = "whsec_previous", &expires
+	header = hook.signature(payload, at)
+	if strings.Count(header, "v1=") != 2 || !verifyWebhookSignature(header, "whsec_previous", payload, at) {
+		t.Errorf("got X-Signature %q during rotation, want it signed with the previous secret too", header)
+	}
+	if header := hook.signature(payload, expires); strings.Count(header, "v1=") != 1 || verifyWebhookSignature(header, "whsec_previous", payload, expires) {
+		t.Errorf("got X-Signature %q after rotation, want only the current secret", header)
+	}
+
+	if header := (Webhook{}).signature(payload, at); header != "" {
+		t.Errorf("got X-Signature %q for a webhook without a secret", header)
+	}
+}
+
+func TestPublicAddress(t *testing.T) {
+	for address, public := range map[string]bool{
+		"93.184.216.34":   true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"::1":             false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"fe80::1":         false,
+		"fd00::1":         false,
+		"100.64.0.1":      false,
+		"224.0.0.1":       false,
+		"0.0.0.0":         false,
+	} {
+		if got := publicAddress(net.ParseIP(address)); got != public {
+			t.Errorf("publicAddress(%s) = %t, want %t", address, got, public)
+		}
+	}
+}
+
+func TestWebhookAddressGuard(t *testing.T) {
+	ctx := context.Background()
+	for _, host := range []string{"127.0.0.1", "169.254.169.254", "::1", "localhost"} {
+		if err := checkWebhookHost(ctx, host); err != errWebhookAddress {
+			t.Errorf("registering a webhook at %s: got %v, want errWebhookAddress", host, err)
+		}
+	}
+
+	// A host that passed registration is checked again as each delivery
+	// connects, so it can't be pointed inside the network later
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("a delivery reached %s", r.URL)
+	}))
+	defer internal.Close()
+	resp, err := newWebhookClient().Post(internal.URL, "application/json", strings.NewReader("{}"))
+	if err == nil {
+		resp.Body.Close()
+	}
+	if !errors.Is(err, errWebhookAddress) {
+		t.Errorf("delivering to %s: got %v, want errWebhookAddress", internal.URL, err)
+	}
+}
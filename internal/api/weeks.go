@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"log"
+	"net/http"
+	"time"
+)
+
+// WeekNutrition aggregates a week's nutrition facts.
+type WeekNutrition struct {
+	Days []DailyNutrition `json:"days"`
+	// Week totals and averages over every item of the week
+	Week NutritionSummary `json:"week"`
+	// DailyAverage averages the day totals over the days with a menu
+	DailyAverage Nutrients `json:"daily_average"`
+}
+
+type WeekMenus struct {
+	Start     string        `json:"start"`
+	End       string        `json:"end"`
+	Days      []DatedMenu   `json:"days"`
+	Nutrition WeekNutrition `json:"nutrition"`
+}
+
+// buildWeek reads the week starting on start from the stored menus and
+// aggregates it.
+func (s *Server) buildWeek(ctx context.Context, start time.Time) (store.Week, error) {
+	end := start.AddDate(0, 0, 6)
+	menus, err := s.store.GetRange(ctx, start.Format(huds.ServeDateLayout), end.Format(huds.ServeDateLayout))
+	if err != nil {
+		return store.Week{}, err
+	}
+
+	nutrition := WeekNutrition{Days: []DailyNutrition{}}
+	var all []huds.CondensedMenuItem
+	for _, menu := range menus {
+		nutrition.Days = append(nutrition.Days, dailyNutrition(menu))
+		for _, meal := range []string{"breakfast", "lunch", "dinner"} {
+			all = append(all, huds.MealItems(menu, meal)...)
+		}
+	}
+	nutrition.Week = summarizeNutrition(all)
+	if len(menus) > 0 {
+		nutrition.DailyAverage = nutrition.Week.Totals.Scale(1 / float64(len(menus)))
+	}
+	summary, err := json.Marshal(nutrition)
+	if err != nil {
+		return store.Week{}, err
+	}
+	return store.Week{Start: start.Format(huds.ServeDateLayout), BuiltAt: s.clock.Now().UTC(), Days: menus, Summary: summary}, nil
+}
+
+// materializeWeeks rebuilds the stored weeks that a refresh touched. It runs
+// after every refresh when the store keeps weeks.
+func (s *Server) materializeWeeks(ctx context.Context, data map[string]map[int][]huds.CondensedMenuItem) {
+	weeks := s.store.(store.WeekStore)
+	starts := make(map[time.Time]bool)
+	for date := range data {
+		if day, err := time.Parse(huds.ServeDateLayout, date); err == nil {
+			starts[store.WeekStart(day)] = true
+		}
+	}
+	built := make([]store.Week, 0, len(starts))
+	for start := range starts {
+		week, err := s.buildWeek(ctx, start)
+		if err != nil {
+			log.Printf("Failed to build the week of %s: %v\n", start.Format(huds.ServeDateLayout), err)
+			continue
+		}
+		built = append(built, week)
+	}
+	if err := weeks.UpsertWeeks(ctx, built); err != nil {
+		log.Printf("Failed to store weekly summaries: %v\n", err)
+	}
+}
+
+// loadWeek returns the stored week starting on start, building it if it
+// predates weekly summaries, or on every request if the store can't keep
+// them.
+func (s *Server) loadWeek(ctx context.Context, start time.Time) (store.Week, error) {
+	weeks, ok := s.store.(store.WeekStore)
+	if ok {
+		week, err := weeks.GetWeek(ctx, start.Format(huds.ServeDateLayout))
+		if err != store.ErrMenuNotFound {
+			return week, err
+		}
+	}
+	week, err := s.buildWeek(ctx, start)
+	if err != nil || len(week.Days) == 0 {
+		return week, err
+	}
+	if ok && !s.inMaintenance() {
+		if err := weeks.UpsertWeeks(ctx, []store.Week{week}); err != nil {
+			log.Printf("Failed to store weekly summaries: %v\n", err)
+		}
+	}
+	return week, nil
+}
+
+// handleWeek serves the Monday-to-Sunday week that serve_date falls in, with
+// its nutrition aggregates.
+func (s *Server) handleWeek(c *gin.Context) {
+	dateFormat := c.DefaultQuery("date_format", s.dateFormat)
+	if !validDateFormat(dateFormat) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "date_format must be 'us' or 'iso'")
+		return
+	}
+	start := store.WeekStart(serveDateParam(c))
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	week, err := s.loadWeek(ctx, start)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	if len(week.Days) == 0 {
+		respondError(c, http.StatusNotFound, CodeNotFound, "no menus for this week")
+		return
+	}
+
+	menus := WeekMenus{
+		Start: formatServeDate(start.Format(huds.ServeDateLayout), dateFormat),
+		End:   formatServeDate(start.AddDate(0, 0, 6).Format(huds.ServeDateLayout), dateFormat),
+		Days:  make([]DatedMenu, len(week.Days)),
+	}
+	for i, menu := range week.Days {
+		menus.Days[i] = DatedMenu{menu, dateFormat, itemFields(c), "", splitHalls(c)}
+	}
+	if err := json.Unmarshal(week.Summary, &menus.Nutrition); err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	for i := range menus.Nutrition.Days {
+		menus.Nutrition.Days[i].ServeDate = formatServeDate(menus.Nutrition.Days[i].ServeDate, dateFormat)
+	}
+	respond(c, http.StatusOK, menus, ResponseMeta{ServeDate: menus.Start, Source: SourceDB, LastUpdated: week.BuiltAt})
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"hudsgry-api/internal/huds"
+	"hudsgry-api/internal/store"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// widgetMaxAge is how long browsers and CDNs may cache the widget. Menus can
+// still change on the day, so it is kept short.
+const widgetMaxAge = "public, max-age=300"
+
+var widgetScript = func() string {
+	data, err := webFiles.ReadFile("web/widget.js")
+	if err != nil {
+		log.Fatalf("Missing embedded file web/widget.js: %v", err)
+	}
+	return string(data)
+}()
+
+// jsonpCallback is what a JSONP callback name may look like, so it can't be
+// used to inject script.
+var jsonpCallback = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*(\.[A-Za-z_$][A-Za-z0-9_$]*)*$`)
+
+// WidgetItem is an item as the widget shows it, without nutrition.
+type WidgetItem struct {
+	Name       string   `json:"name"`
+	Category   string   `json:"category,omitempty"`
+	Allergens  []string `json:"allergens,omitempty"`
+	Vegan      bool     `json:"vegan,omitempty"`
+	Vegetarian bool     `json:"vegetarian,omitempty"`
+}
+
+// WidgetMenu is the compact menu behind the embeddable widget.
+type WidgetMenu struct {
+	ServeDate string       `json:"serve_date"`
+	Meal      string       `json:"meal"`
+	Items     []WidgetItem `json:"items"`
+}
+
+// widgetMenu loads one meal for the widget: the meal parameter on serve_date
+// (default today), or the next meal if no meal is given. A nil menu with no
+// error means the day hasn't been published. It answers 400 itself for bad
+// parameters and returns ok false.
+func (s *Server) widgetMenu(c *gin.Context) (*WidgetMenu, time.Time, bool) {
+	date, ok := s.queryDate(c, "serve_date")
+	if !ok {
+		return nil, date, false
+	}
+	dateFormat := c.DefaultQuery("date_format", s.dateFormat)
+	if !validDateFormat(dateFormat) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "date_format must be 'us' or 'iso'")
+		return nil, date, false
+	}
+	meal := strings.ToLower(c.Query("meal"))
+	if meal == "" {
+		next, day, _ := s.nextMeal()
+		meal = next
+		if date.IsZero() {
+			date = day
+		}
+	}
+	if date.IsZero() {
+		date, _ = time.Parse(huds.ServeDateLayout, s.today())
+	}
+	serveDate := date.Format(huds.ServeDateLayout)
+
+	ctx, cancel := s.dbContext(c.Request.Context())
+	defer cancel()
+	menu, err := s.menuByDate(ctx, serveDate)
+	if err == store.ErrMenuNotFound {
+		return nil, date, true
+	}
+	if err != nil {
+		respondStoreError(c, err)
+		return nil, date, false
+	}
+	known := false
+	for _, key := range menu.Meals() {
+		known = known || key == meal
+	}
+	if !known {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "meal must be breakfast, lunch, dinner or another meal served that day")
+		return nil, date, false
+	}
+
+	widget := &WidgetMenu{ServeDate: formatServeDate(serveDate, dateFormat), Meal: meal, Items: []WidgetItem{}}
+	// Items come grouped by category so the script can head each group
+	categories, byCategory := groupByCategory(huds.MealItems(menu, meal))
+	for _, category := range categories {
+		for _, item := range byCategory[category] {
+			widget.Items = append(widget.Items, WidgetItem{
+				Name:       item.FoodName,
+				Category:   category,
+				Allergens:  huds.Allergens(item.Allergens),
+				Vegan:      item.Vegan,
+				Vegetarian: item.Vegetarian,
+			})
+		}
+	}
+	return widget, date, true
+}
+
+// handleWidgetJSON serves the widget's menu as compact JSON, or as JSONP
+// with callback=name, for pages rendering the menu themselves.
+func (s *Server) handleWidgetJSON(c *gin.Context) {
+	callback := c.Query("callback")
+	if callback != "" && !jsonpCallback.MatchString(callback) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "callback must be a JavaScript identifier")
+		return
+	}
+	widget, _, ok := s.widgetMenu(c)
+	if !ok {
+		return
+	}
+	c.Header("Cache-Control", widgetMaxAge)
+	c.Header("Access-Control-Allow-Origin", "*")
+	if widget == nil {
+		respondError(c, http.StatusNotFound, CodeNotFound, "this day's menu hasn't been published yet")
+		return
+	}
+	if callback != "" {
+		c.JSONP(http.StatusOK, widget)
+		return
+	}
+	c.JSON(http.StatusOK, widget)
+}
+
+// handleWidgetScript serves a script that renders one meal where its script
+// tag is, or into the element named by target. The menu is inlined so the
+// embedding page makes a single request.
+func (s *Server) handleWidgetScript(c *gin.Context) {
+	widget, date, ok := s.widgetMenu(c)
+	if !ok {
+		return
+	}
+	title := date.Format("Monday, January 2")
+	if widget != nil {
+		title = mealTitle(widget.Meal) + " · " + title
+	}
+	config, err := json.Marshal(gin.H{
+		"menu":    widget,
+		"title":   title,
+		"message": "This day's menu hasn't been published yet.",
+		"target":  c.Query("target"),
+	})
+	if err != nil {
+		log.Printf("Failed to encode the widget menu: %v\n", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to render widget")
+		return
+	}
+	// Unpublished days still answer 200, since browsers don't run scripts
+	// served with an error status
+	c.Header("Cache-Control", widgetMaxAge)
+	c.Header("Access-Control-Allow-Origin", "*")
+	c.Data(http.StatusOK, "application/javascript; charset=utf-8", []byte(strings.Replace(widgetScript, "__WIDGET_CONFIG__", string(config), 1)))
+}
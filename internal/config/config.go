@@ -0,0 +1,468 @@
+// Package config reads the optional structured configuration file. Every
+// setting in it has an environment variable, and the file only fills in the
+// variables that aren't already set, so the environment (and .env) always
+// wins. Values are validated where they are used, when the service starts.
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// File is the layout of the configuration file, in YAML or TOML. Secrets such
+// as tokens are better kept in the environment, but are accepted here too.
+type File struct {
+	Server        Server        `yaml:"server" toml:"server"`
+	Storage       Storage       `yaml:"storage" toml:"storage"`
+	Refresh       Refresh       `yaml:"refresh" toml:"refresh"`
+	Provider      Provider      `yaml:"provider" toml:"provider"`
+	Notifications Notifications `yaml:"notifications" toml:"notifications"`
+	Photos        Photos        `yaml:"photos" toml:"photos"`
+	Integrations  Integrations  `yaml:"integrations" toml:"integrations"`
+	Telemetry     Telemetry     `yaml:"telemetry" toml:"telemetry"`
+	Reporting     Reporting     `yaml:"reporting" toml:"reporting"`
+	Clock         Clock         `yaml:"clock" toml:"clock"`
+}
+
+type Server struct {
+	Port       int    `yaml:"port" toml:"port"`
+	DateFormat string `yaml:"date_format" toml:"date_format"`
+	AdminToken string `yaml:"admin_token" toml:"admin_token"`
+	TLS        TLS    `yaml:"tls" toml:"tls"`
+	// APIKeyDailyQuota is the daily quota new API keys get; see
+	// API_KEY_DAILY_QUOTA
+	APIKeyDailyQuota int `yaml:"api_key_daily_quota" toml:"api_key_daily_quota"`
+	// AnonymousDailyQuota is the daily quota per IP address of requests
+	// without an API key; see ANONYMOUS_DAILY_QUOTA
+	AnonymousDailyQuota int `yaml:"anonymous_daily_quota" toml:"anonymous_daily_quota"`
+	// TrustedPlatform and TrustedProxies say where the client's address is
+	// read from; see TRUSTED_PLATFORM and TRUSTED_PROXIES
+	TrustedPlatform string        `yaml:"trusted_platform" toml:"trusted_platform"`
+	TrustedProxies  []string      `yaml:"trusted_proxies" toml:"trusted_proxies"`
+	JWT             JWT           `yaml:"jwt" toml:"jwt"`
+	OIDC            OIDC          `yaml:"oidc" toml:"oidc"`
+	AccessLog       AccessLog     `yaml:"access_log" toml:"access_log"`
+	ResponseCache   ResponseCache `yaml:"response_cache" toml:"response_cache"`
+}
+
+// ResponseCache answers for failing routes with their last good response; see
+// RESPONSE_CACHE_MAX_STALE and RESPONSE_CACHE_ENTRIES.
+type ResponseCache struct {
+	MaxStale string `yaml:"max_stale" toml:"max_stale"`
+	Entries  int    `yaml:"entries" toml:"entries"`
+}
+
+// AccessLog keeps a record of every request in MongoDB for /admin/access-logs;
+// see ACCESS_LOG_ENABLED and ACCESS_LOG_MAX_MB.
+type AccessLog struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	MaxMB   int  `yaml:"max_mb" toml:"max_mb"`
+}
+
+// JWT configures the JWTs issued for user features. SigningKeys are id=secret
+// pairs, the first of which signs.
+type JWT struct {
+	SigningKeys    []string `yaml:"signing_keys" toml:"signing_keys"`
+	PrivateKeyFile string   `yaml:"private_key_file" toml:"private_key_file"`
+	KeyID          string   `yaml:"key_id" toml:"key_id"`
+	Issuer         string   `yaml:"issuer" toml:"issuer"`
+	AccessTTL      string   `yaml:"access_ttl" toml:"access_ttl"`
+	RefreshTTL     string   `yaml:"refresh_ttl" toml:"refresh_ttl"`
+}
+
+// OIDC configures logging in through an OpenID Connect provider such as
+// HarvardKey or Google Workspace.
+type OIDC struct {
+	Issuer         string   `yaml:"issuer" toml:"issuer"`
+	ClientID       string   `yaml:"client_id" toml:"client_id"`
+	ClientSecret   string   `yaml:"client_secret" toml:"client_secret"`
+	RedirectURL    string   `yaml:"redirect_url" toml:"redirect_url"`
+	Scopes         string   `yaml:"scopes" toml:"scopes"`
+	AllowedDomains []string `yaml:"allowed_domains" toml:"allowed_domains"`
+	SuccessURL     string   `yaml:"success_url" toml:"success_url"`
+	ProviderName   string   `yaml:"provider_name" toml:"provider_name"`
+}
+
+type TLS struct {
+	CertFile string   `yaml:"cert_file" toml:"cert_file"`
+	KeyFile  string   `yaml:"key_file" toml:"key_file"`
+	Domains  []string `yaml:"domains" toml:"domains"`
+	CacheDir string   `yaml:"cache_dir" toml:"cache_dir"`
+	Addr     string   `yaml:"addr" toml:"addr"`
+}
+
+type Storage struct {
+	Backend         string `yaml:"backend" toml:"backend"`
+	MongoDBURI      string `yaml:"mongodb_uri" toml:"mongodb_uri"`
+	PostgresURL     string `yaml:"postgres_url" toml:"postgres_url"`
+	SQLitePath      string `yaml:"sqlite_path" toml:"sqlite_path"`
+	Fixture         string `yaml:"fixture" toml:"fixture"`
+	SkipIndexCreate bool   `yaml:"skip_index_create" toml:"skip_index_create"`
+	Timeout         string `yaml:"timeout" toml:"timeout"`
+	CacheFile       string `yaml:"cache_file" toml:"cache_file"`
+	CacheDays       int    `yaml:"cache_days" toml:"cache_days"`
+	// Retention is how much history to keep, e.g. 2y; see
+	// store.LoadRetentionPolicy
+	Retention         string `yaml:"retention" toml:"retention"`
+	RetentionSchedule string `yaml:"retention_schedule" toml:"retention_schedule"`
+	Mongo             Mongo  `yaml:"mongo" toml:"mongo"`
+	Shadow            Shadow `yaml:"shadow" toml:"shadow"`
+}
+
+// Shadow is a second store written alongside the first while migrating; see
+// store.OpenShadow.
+type Shadow struct {
+	Backend       string `yaml:"backend" toml:"backend"`
+	MongoDatabase string `yaml:"mongo_database" toml:"mongo_database"`
+	PostgresURL   string `yaml:"postgres_url" toml:"postgres_url"`
+	SQLitePath    string `yaml:"sqlite_path" toml:"sqlite_path"`
+	CompareReads  bool   `yaml:"compare_reads" toml:"compare_reads"`
+}
+
+// Mongo tunes the MongoDB client; see store.LoadMongoClientOptions.
+type Mongo struct {
+	MaxPoolSize            int    `yaml:"max_pool_size" toml:"max_pool_size"`
+	MinPoolSize            int    `yaml:"min_pool_size" toml:"min_pool_size"`
+	MaxConnecting          int    `yaml:"max_connecting" toml:"max_connecting"`
+	MaxConnIdleTime        string `yaml:"max_conn_idle_time" toml:"max_conn_idle_time"`
+	ConnectTimeout         string `yaml:"connect_timeout" toml:"connect_timeout"`
+	SocketTimeout          string `yaml:"socket_timeout" toml:"socket_timeout"`
+	ServerSelectionTimeout string `yaml:"server_selection_timeout" toml:"server_selection_timeout"`
+	ReadPreference         string `yaml:"read_preference" toml:"read_preference"`
+	// RetryWrites and RetryReads are pointers so the file can turn them off
+	RetryWrites *bool `yaml:"retry_writes" toml:"retry_writes"`
+	RetryReads  *bool `yaml:"retry_reads" toml:"retry_reads"`
+}
+
+type Refresh struct {
+	Schedules         []string `yaml:"schedules" toml:"schedules"`
+	IntradaySchedules []string `yaml:"intraday_schedules" toml:"intraday_schedules"`
+	Timezone          string   `yaml:"timezone" toml:"timezone"`
+	FetchOnStart      string   `yaml:"fetch_on_start" toml:"fetch_on_start"`
+	JobTimeout        string   `yaml:"job_timeout" toml:"job_timeout"`
+}
+
+type Provider struct {
+	Name string `yaml:"name" toml:"name"`
+	HUDS HUDS   `yaml:"huds" toml:"huds"`
+}
+
+type HUDS struct {
+	APIKey           string `yaml:"api_key" toml:"api_key"`
+	Client           string `yaml:"client" toml:"client"`
+	APIURL           string `yaml:"api_url" toml:"api_url"`
+	APIKeyHeader     string `yaml:"api_key_header" toml:"api_key_header"`
+	SandboxURL       string `yaml:"sandbox_url" toml:"sandbox_url"`
+	FixtureDir       string `yaml:"fixture_dir" toml:"fixture_dir"`
+	FetchTimeout     string `yaml:"fetch_timeout" toml:"fetch_timeout"`
+	FetchAttempts    int    `yaml:"fetch_attempts" toml:"fetch_attempts"`
+	FetchBaseDelay   string `yaml:"fetch_base_delay" toml:"fetch_base_delay"`
+	FetchMaxDelay    string `yaml:"fetch_max_delay" toml:"fetch_max_delay"`
+	FetchRetryWindow string `yaml:"fetch_retry_window" toml:"fetch_retry_window"`
+	Meals            string `yaml:"meals" toml:"meals"`
+	DefaultLocation  string `yaml:"default_location" toml:"default_location"`
+	// DensityWeights weigh nutrient density scores, e.g.
+	// "protein=1,sodium=0.5,sat_fat=1"; see DENSITY_WEIGHTS
+	DensityWeights string `yaml:"density_weights" toml:"density_weights"`
+	// Staples are served every day; see STAPLES
+	Staples []string `yaml:"staples" toml:"staples"`
+}
+
+type Notifications struct {
+	Telegram     Telegram `yaml:"telegram" toml:"telegram"`
+	GroupMe      GroupMe  `yaml:"groupme" toml:"groupme"`
+	Twilio       Twilio   `yaml:"twilio" toml:"twilio"`
+	Push         Push     `yaml:"push" toml:"push"`
+	AlexaSkillID string   `yaml:"alexa_skill_id" toml:"alexa_skill_id"`
+}
+
+type Telegram struct {
+	BotToken   string `yaml:"bot_token" toml:"bot_token"`
+	WebhookURL string `yaml:"webhook_url" toml:"webhook_url"`
+}
+
+type GroupMe struct {
+	// Bots pairs each group the menu is posted to with its bot, as
+	// "group_id:bot_id"; see GROUPME_BOTS
+	Bots []string `yaml:"bots" toml:"bots"`
+}
+
+type Twilio struct {
+	AccountSID string `yaml:"account_sid" toml:"account_sid"`
+	AuthToken  string `yaml:"auth_token" toml:"auth_token"`
+	FromNumber string `yaml:"from_number" toml:"from_number"`
+	WebhookURL string `yaml:"webhook_url" toml:"webhook_url"`
+}
+
+type Push struct {
+	FCMCredentialsFile string `yaml:"fcm_credentials_file" toml:"fcm_credentials_file"`
+	APNSKeyFile        string `yaml:"apns_key_file" toml:"apns_key_file"`
+	APNSKeyID          string `yaml:"apns_key_id" toml:"apns_key_id"`
+	APNSTeamID         string `yaml:"apns_team_id" toml:"apns_team_id"`
+	APNSTopic          string `yaml:"apns_topic" toml:"apns_topic"`
+	APNSSandbox        bool   `yaml:"apns_sandbox" toml:"apns_sandbox"`
+}
+
+type Photos struct {
+	Bucket          string `yaml:"bucket" toml:"bucket"`
+	Endpoint        string `yaml:"endpoint" toml:"endpoint"`
+	Region          string `yaml:"region" toml:"region"`
+	AccessKeyID     string `yaml:"access_key_id" toml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key" toml:"secret_access_key"`
+	PublicURL       string `yaml:"public_url" toml:"public_url"`
+	Moderation      string `yaml:"moderation" toml:"moderation"`
+}
+
+type Integrations struct {
+	GoogleCalendar GoogleCalendar `yaml:"google_calendar" toml:"google_calendar"`
+}
+
+type GoogleCalendar struct {
+	ClientID     string `yaml:"client_id" toml:"client_id"`
+	ClientSecret string `yaml:"client_secret" toml:"client_secret"`
+	RedirectURL  string `yaml:"redirect_url" toml:"redirect_url"`
+}
+
+type Telemetry struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+}
+
+type Reporting struct {
+	SentryDSN   string `yaml:"sentry_dsn" toml:"sentry_dsn"`
+	Environment string `yaml:"environment" toml:"environment"`
+	// DataQualityAlerts reports dates and meals missing after a refresh; see
+	// DATA_QUALITY_ALERTS
+	DataQualityAlerts bool   `yaml:"data_quality_alerts" toml:"data_quality_alerts"`
+	Alerts            Alerts `yaml:"alerts" toml:"alerts"`
+}
+
+// Alerts are the channels told when a refresh fails; see alerting.Load.
+type Alerts struct {
+	SlackWebhookURL     string   `yaml:"slack_webhook_url" toml:"slack_webhook_url"`
+	PagerDutyRoutingKey string   `yaml:"pagerduty_routing_key" toml:"pagerduty_routing_key"`
+	EmailTo             []string `yaml:"email_to" toml:"email_to"`
+	EmailFrom           string   `yaml:"email_from" toml:"email_from"`
+	SMTPHost            string   `yaml:"smtp_host" toml:"smtp_host"`
+	SMTPPort            int      `yaml:"smtp_port" toml:"smtp_port"`
+	SMTPUsername        string   `yaml:"smtp_username" toml:"smtp_username"`
+	SMTPPassword        string   `yaml:"smtp_password" toml:"smtp_password"`
+}
+
+type Clock struct {
+	SimulatedTime  string `yaml:"simulated_time" toml:"simulated_time"`
+	SimulatedSpeed string `yaml:"simulated_speed" toml:"simulated_speed"`
+}
+
+// fromFile records the environment variables Load has set, which a later Load
+// may change or clear again without overriding the real environment.
+var fromFile = map[string]bool{}
+
+// Load reads the configuration file at path and sets the environment
+// variables it covers that aren't already set. Unknown keys are an error so
+// that typos don't go unnoticed. Loading the file again, e.g. to reload the
+// configuration, updates the variables it set before and clears those it no
+// longer sets.
+func Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var file File
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&file)
+	case ".toml":
+		decoder := toml.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&file)
+	default:
+		return fmt.Errorf("config file %s must end in .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	if port := file.Server.Port; port < 0 || port > 65535 {
+		return fmt.Errorf("server.port must be between 1 and 65535, got %d", port)
+	}
+
+	for name, value := range file.env() {
+		if _, set := os.LookupEnv(name); set && !fromFile[name] {
+			continue
+		}
+		if value == "" {
+			if fromFile[name] {
+				os.Unsetenv(name)
+				delete(fromFile, name)
+			}
+			continue
+		}
+		if err := os.Setenv(name, value); err != nil {
+			return err
+		}
+		fromFile[name] = true
+	}
+	return nil
+}
+
+// env maps the file onto the environment variables it stands in for. Settings
+// left out of the file are empty.
+func (f File) env() map[string]string {
+	number := func(n int) string {
+		if n == 0 {
+			return ""
+		}
+		return strconv.Itoa(n)
+	}
+	flag := func(b bool) string {
+		if !b {
+			return ""
+		}
+		return "true"
+	}
+	// optionalFlag is for settings whose default is on, which the file must
+	// be able to turn off
+	optionalFlag := func(b *bool) string {
+		if b == nil {
+			return ""
+		}
+		return strconv.FormatBool(*b)
+	}
+	return map[string]string{
+		"PORT":                     number(f.Server.Port),
+		"DATE_FORMAT":              f.Server.DateFormat,
+		"ADMIN_TOKEN":              f.Server.AdminToken,
+		"API_KEY_DAILY_QUOTA":      number(f.Server.APIKeyDailyQuota),
+		"ANONYMOUS_DAILY_QUOTA":    number(f.Server.AnonymousDailyQuota),
+		"TRUSTED_PLATFORM":         f.Server.TrustedPlatform,
+		"TRUSTED_PROXIES":          strings.Join(f.Server.TrustedProxies, ","),
+		"ACCESS_LOG_ENABLED":       flag(f.Server.AccessLog.Enabled),
+		"ACCESS_LOG_MAX_MB":        number(f.Server.AccessLog.MaxMB),
+		"RESPONSE_CACHE_MAX_STALE": f.Server.ResponseCache.MaxStale,
+		"RESPONSE_CACHE_ENTRIES":   number(f.Server.ResponseCache.Entries),
+		"JWT_SIGNING_KEYS":         strings.Join(f.Server.JWT.SigningKeys, ","),
+		"JWT_PRIVATE_KEY_FILE":     f.Server.JWT.PrivateKeyFile,
+		"JWT_KEY_ID":               f.Server.JWT.KeyID,
+		"JWT_ISSUER":               f.Server.JWT.Issuer,
+		"JWT_ACCESS_TTL":           f.Server.JWT.AccessTTL,
+		"JWT_REFRESH_TTL":          f.Server.JWT.RefreshTTL,
+
+		"OIDC_ISSUER":          f.Server.OIDC.Issuer,
+		"OIDC_CLIENT_ID":       f.Server.OIDC.ClientID,
+		"OIDC_CLIENT_SECRET":   f.Server.OIDC.ClientSecret,
+		"OIDC_REDIRECT_URL":    f.Server.OIDC.RedirectURL,
+		"OIDC_SCOPES":          f.Server.OIDC.Scopes,
+		"OIDC_ALLOWED_DOMAINS": strings.Join(f.Server.OIDC.AllowedDomains, ","),
+		"OIDC_SUCCESS_URL":     f.Server.OIDC.SuccessURL,
+		"OIDC_PROVIDER_NAME":   f.Server.OIDC.ProviderName,
+		"TLS_CERT_FILE":        f.Server.TLS.CertFile,
+		"TLS_KEY_FILE":         f.Server.TLS.KeyFile,
+		"TLS_DOMAINS":          strings.Join(f.Server.TLS.Domains, ","),
+		"TLS_CACHE_DIR":        f.Server.TLS.CacheDir,
+		"TLS_ADDR":             f.Server.TLS.Addr,
+
+		"MENU_STORE":         f.Storage.Backend,
+		"MONGODB_URI":        f.Storage.MongoDBURI,
+		"POSTGRES_URL":       f.Storage.PostgresURL,
+		"SQLITE_PATH":        f.Storage.SQLitePath,
+		"MENU_FIXTURE":       f.Storage.Fixture,
+		"SKIP_INDEX_CREATE":  flag(f.Storage.SkipIndexCreate),
+		"DB_TIMEOUT":         f.Storage.Timeout,
+		"MENU_CACHE_FILE":    f.Storage.CacheFile,
+		"MENU_CACHE_DAYS":    number(f.Storage.CacheDays),
+		"RETENTION":          f.Storage.Retention,
+		"RETENTION_SCHEDULE": f.Storage.RetentionSchedule,
+
+		"MONGODB_MAX_POOL_SIZE":            number(f.Storage.Mongo.MaxPoolSize),
+		"MONGODB_MIN_POOL_SIZE":            number(f.Storage.Mongo.MinPoolSize),
+		"MONGODB_MAX_CONNECTING":           number(f.Storage.Mongo.MaxConnecting),
+		"MONGODB_MAX_CONN_IDLE_TIME":       f.Storage.Mongo.MaxConnIdleTime,
+		"MONGODB_CONNECT_TIMEOUT":          f.Storage.Mongo.ConnectTimeout,
+		"MONGODB_SOCKET_TIMEOUT":           f.Storage.Mongo.SocketTimeout,
+		"MONGODB_SERVER_SELECTION_TIMEOUT": f.Storage.Mongo.ServerSelectionTimeout,
+		"MONGODB_READ_PREFERENCE":          f.Storage.Mongo.ReadPreference,
+		"MONGODB_RETRY_WRITES":             optionalFlag(f.Storage.Mongo.RetryWrites),
+		"MONGODB_RETRY_READS":              optionalFlag(f.Storage.Mongo.RetryReads),
+
+		"SHADOW_STORE":            f.Storage.Shadow.Backend,
+		"SHADOW_MONGODB_DATABASE": f.Storage.Shadow.MongoDatabase,
+		"SHADOW_POSTGRES_URL":     f.Storage.Shadow.PostgresURL,
+		"SHADOW_SQLITE_PATH":      f.Storage.Shadow.SQLitePath,
+		"SHADOW_COMPARE_READS":    flag(f.Storage.Shadow.CompareReads),
+
+		"REFRESH_SCHEDULE":          strings.Join(f.Refresh.Schedules, ";"),
+		"INTRADAY_REFRESH_SCHEDULE": strings.Join(f.Refresh.IntradaySchedules, ";"),
+		"REFRESH_TIMEZONE":          f.Refresh.Timezone,
+		"FETCH_ON_START":            f.Refresh.FetchOnStart,
+		"JOB_TIMEOUT":               f.Refresh.JobTimeout,
+
+		"DINING_PROVIDER":         f.Provider.Name,
+		"API_KEY":                 f.Provider.HUDS.APIKey,
+		"HUDS_CLIENT":             f.Provider.HUDS.Client,
+		"HUDS_API_URL":            f.Provider.HUDS.APIURL,
+		"HUDS_API_KEY_HEADER":     f.Provider.HUDS.APIKeyHeader,
+		"HUDS_SANDBOX_URL":        f.Provider.HUDS.SandboxURL,
+		"HUDS_FIXTURE_DIR":        f.Provider.HUDS.FixtureDir,
+		"HUDS_FETCH_TIMEOUT":      f.Provider.HUDS.FetchTimeout,
+		"HUDS_FETCH_ATTEMPTS":     number(f.Provider.HUDS.FetchAttempts),
+		"HUDS_FETCH_BASE_DELAY":   f.Provider.HUDS.FetchBaseDelay,
+		"HUDS_FETCH_MAX_DELAY":    f.Provider.HUDS.FetchMaxDelay,
+		"HUDS_FETCH_RETRY_WINDOW": f.Provider.HUDS.FetchRetryWindow,
+		"HUDS_MEALS":              f.Provider.HUDS.Meals,
+		"HUDS_DEFAULT_LOCATION":   f.Provider.HUDS.DefaultLocation,
+		"DENSITY_WEIGHTS":         f.Provider.HUDS.DensityWeights,
+		"STAPLES":                 strings.Join(f.Provider.HUDS.Staples, ","),
+
+		"TELEGRAM_BOT_TOKEN":   f.Notifications.Telegram.BotToken,
+		"TELEGRAM_WEBHOOK_URL": f.Notifications.Telegram.WebhookURL,
+		"GROUPME_BOTS":         strings.Join(f.Notifications.GroupMe.Bots, ","),
+		"TWILIO_ACCOUNT_SID":   f.Notifications.Twilio.AccountSID,
+		"TWILIO_AUTH_TOKEN":    f.Notifications.Twilio.AuthToken,
+		"TWILIO_FROM_NUMBER":   f.Notifications.Twilio.FromNumber,
+		"TWILIO_WEBHOOK_URL":   f.Notifications.Twilio.WebhookURL,
+		"FCM_CREDENTIALS_FILE": f.Notifications.Push.FCMCredentialsFile,
+		"APNS_KEY_FILE":        f.Notifications.Push.APNSKeyFile,
+		"APNS_KEY_ID":          f.Notifications.Push.APNSKeyID,
+		"APNS_TEAM_ID":         f.Notifications.Push.APNSTeamID,
+		"APNS_TOPIC":           f.Notifications.Push.APNSTopic,
+		"APNS_SANDBOX":         flag(f.Notifications.Push.APNSSandbox),
+		"ALEXA_SKILL_ID":       f.Notifications.AlexaSkillID,
+
+		"PHOTO_BUCKET":            f.Photos.Bucket,
+		"PHOTO_STORAGE_ENDPOINT":  f.Photos.Endpoint,
+		"PHOTO_REGION":            f.Photos.Region,
+		"PHOTO_ACCESS_KEY_ID":     f.Photos.AccessKeyID,
+		"PHOTO_SECRET_ACCESS_KEY": f.Photos.SecretAccessKey,
+		"PHOTO_PUBLIC_URL":        f.Photos.PublicURL,
+		"PHOTO_MODERATION":        f.Photos.Moderation,
+
+		"GOOGLE_CLIENT_ID":     f.Integrations.GoogleCalendar.ClientID,
+		"GOOGLE_CLIENT_SECRET": f.Integrations.GoogleCalendar.ClientSecret,
+		"GOOGLE_REDIRECT_URL":  f.Integrations.GoogleCalendar.RedirectURL,
+
+		"TELEMETRY_ENABLED": flag(f.Telemetry.Enabled),
+
+		"SENTRY_DSN":          f.Reporting.SentryDSN,
+		"SENTRY_ENVIRONMENT":  f.Reporting.Environment,
+		"DATA_QUALITY_ALERTS": flag(f.Reporting.DataQualityAlerts),
+
+		"ALERT_SLACK_WEBHOOK_URL":     f.Reporting.Alerts.SlackWebhookURL,
+		"ALERT_PAGERDUTY_ROUTING_KEY": f.Reporting.Alerts.PagerDutyRoutingKey,
+		"ALERT_EMAIL_TO":              strings.Join(f.Reporting.Alerts.EmailTo, ","),
+		"ALERT_EMAIL_FROM":            f.Reporting.Alerts.EmailFrom,
+		"SMTP_HOST":                   f.Reporting.Alerts.SMTPHost,
+		"SMTP_PORT":                   number(f.Reporting.Alerts.SMTPPort),
+		"SMTP_USERNAME":               f.Reporting.Alerts.SMTPUsername,
+		"SMTP_PASSWORD":               f.Reporting.Alerts.SMTPPassword,
+
+		"SIMULATED_TIME":       f.Clock.SimulatedTime,
+		"SIMULATED_TIME_SPEED": f.Clock.SimulatedSpeed,
+	}
+}
@@ -0,0 +1,186 @@
+package huds
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Allergens splits an item's Allergens string into normalized tokens, e.g.
+// "milk,  TREE NUTS; Wheat" becomes Milk, Tree Nuts and Wheat.
+func Allergens(allergens string) []string {
+	var tokens []string
+	for _, part := range strings.FieldsFunc(allergens, func(r rune) bool { return r == ',' || r == ';' }) {
+		words := strings.Fields(strings.ToLower(part))
+		if len(words) == 0 {
+			continue
+		}
+		for i, word := range words {
+			words[i] = strings.ToUpper(word[:1]) + word[1:]
+		}
+		tokens = append(tokens, strings.Join(words, " "))
+	}
+	return tokens
+}
+
+// Confidence of a DerivedAllergen.
+const (
+	// ConfidenceHigh is an ingredient that names the allergen outright, e.g.
+	// wheat flour or soy lecithin
+	ConfidenceHigh = "high"
+	// ConfidenceLow is an ingredient that is often but not always made from
+	// it, e.g. flour or lecithin
+	ConfidenceLow = "low"
+)
+
+// DerivedAllergen is an allergen found in an item's ingredient list that its
+// Allergens don't list.
+type DerivedAllergen struct {
+	Allergen string `json:"allergen" bson:"allergen"`
+	// Ingredient is what it was found as, e.g. "soy lecithin"
+	Ingredient string `json:"ingredient" bson:"ingredient"`
+	Confidence string `json:"confidence" bson:"confidence"`
+}
+
+// allergenTerms are what each allergen goes by in ingredient lists. Terms
+// are whole words or phrases; the not phrases are blanked out first, so that
+// peanut butter isn't milk and rice flour isn't wheat.
+type allergenTerms struct {
+	allergen string
+	// listed are the spellings of the allergen in Allergens
+	listed []string
+	high   []string
+	low    []string
+	not    []string
+}
+
+var derivedAllergens = []allergenTerms{
+	{
+		allergen: "Milk",
+		listed:   []string{"milk", "dairy"},
+		high:     []string{"milk", "butter", "buttermilk", "cream", "cheese", "whey", "casein", "caseinate", "yogurt", "lactose", "ghee", "parmesan", "mozzarella", "cheddar", "ricotta"},
+		not:      []string{"peanut butter", "almond butter", "nut butter", "sunflower butter", "seed butter", "apple butter", "cocoa butter", "shea butter", "coconut milk", "coconut cream", "almond milk", "oat milk", "soy milk", "rice milk", "cream of tartar", "dairy free", "dairy-free", "vegan cheese"},
+	},
+	{
+		allergen: "Eggs",
+		listed:   []string{"eggs", "egg"},
+		high:     []string{"egg", "eggs", "egg white", "egg whites", "egg yolk", "egg yolks", "albumen", "albumin", "mayonnaise", "meringue"},
+		not:      []string{"egg free", "egg-free", "vegan mayonnaise"},
+	},
+	{
+		allergen: "Wheat",
+		listed:   []string{"wheat"},
+		high:     []string{"wheat", "wheat flour", "semolina", "durum", "farina", "spelt", "bulgur", "couscous", "seitan", "graham"},
+		low:      []string{"flour", "breadcrumbs", "bread crumbs", "panko"},
+		not:      []string{"rice flour", "corn flour", "almond flour", "chickpea flour", "coconut flour", "oat flour", "potato flour", "tapioca flour", "cassava flour", "gluten free flour", "gluten-free flour"},
+	},
+	{
+		allergen: "Gluten",
+		listed:   []string{"gluten"},
+		high:     []string{"barley", "rye", "malt", "malted", "malt vinegar", "brewer's yeast"},
+	},
+	{
+		allergen: "Soy",
+		listed:   []string{"soy", "soybeans", "soybean"},
+		high:     []string{"soy", "soya", "soybean", "soybeans", "soy lecithin", "soy sauce", "tofu", "edamame", "miso", "tamari", "tempeh"},
+		low:      []string{"lecithin"},
+		not:      []string{"sunflower lecithin", "soy free", "soy-free"},
+	},
+	{
+		allergen: "Peanuts",
+		listed:   []string{"peanuts", "peanut"},
+		high:     []string{"peanut", "peanuts", "groundnut", "groundnuts"},
+	},
+	{
+		allergen: "Tree Nuts",
+		listed:   []string{"tree nuts", "tree nut"},
+		high:     []string{"almond", "almonds", "cashew", "cashews", "walnut", "walnuts", "pecan", "pecans", "pistachio", "pistachios", "hazelnut", "hazelnuts", "macadamia", "brazil nut", "brazil nuts", "pine nut", "pine nuts", "praline", "marzipan"},
+		low:      []string{"nut", "nuts", "coconut"},
+	},
+	{
+		allergen: "Fish",
+		listed:   []string{"fish"},
+		high:     []string{"fish", "fish sauce", "anchovy", "anchovies", "cod", "salmon", "tuna", "tilapia", "pollock", "haddock", "halibut", "trout", "sardine", "sardines", "catfish", "swordfish", "mahi mahi", "worcestershire sauce"},
+	},
+	{
+		allergen: "Shellfish",
+		listed:   []string{"shellfish", "crustacean shellfish"},
+		high:     []string{"shrimp", "crab", "lobster", "prawn", "prawns", "crawfish", "crayfish", "clam", "clams", "mussel", "mussels", "oyster", "oysters", "oyster sauce", "scallop", "scallops"},
+	},
+	{
+		allergen: "Sesame",
+		listed:   []string{"sesame"},
+		high:     []string{"sesame", "sesame oil", "sesame seeds", "tahini", "benne"},
+	},
+}
+
+// termPatterns match each term as whole words, compiled once.
+var termPatterns = map[string]*regexp.Regexp{}
+
+func init() {
+	for _, terms := range derivedAllergens {
+		for _, group := range [][]string{terms.high, terms.low, terms.not} {
+			for _, term := range group {
+				termPatterns[term] = regexp.MustCompile(`\b` + regexp.QuoteMeta(term) + `\b`)
+			}
+		}
+	}
+}
+
+// DeriveAllergens finds the allergens in an ingredient list that allergens,
+// an item's Allergens, doesn't list. Each allergen is reported once, as the
+// longest term it was found as at its highest confidence.
+func DeriveAllergens(ingredients string, allergens string) []DerivedAllergen {
+	text := strings.ToLower(ingredients)
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	listed := make(map[string]bool)
+	for _, allergen := range Allergens(allergens) {
+		listed[strings.ToLower(allergen)] = true
+	}
+
+	var derived []DerivedAllergen
+	for _, terms := range derivedAllergens {
+		if listedAs(listed, terms.listed) {
+			continue
+		}
+		searched := text
+		for _, phrase := range terms.not {
+			searched = termPatterns[phrase].ReplaceAllString(searched, " ")
+		}
+		if term := longestTerm(searched, terms.high); term != "" {
+			derived = append(derived, DerivedAllergen{Allergen: terms.allergen, Ingredient: term, Confidence: ConfidenceHigh})
+		} else if term := longestTerm(searched, terms.low); term != "" {
+			derived = append(derived, DerivedAllergen{Allergen: terms.allergen, Ingredient: term, Confidence: ConfidenceLow})
+		}
+	}
+	return derived
+}
+
+// ItemDerivedAllergens are the allergens derived from an item's ingredients,
+// derived now for items stored before they were kept.
+func ItemDerivedAllergens(item CondensedMenuItem) []DerivedAllergen {
+	if item.DerivedAllergens != nil {
+		return item.DerivedAllergens
+	}
+	return DeriveAllergens(item.Ingredients, item.Allergens)
+}
+
+func listedAs(listed map[string]bool, spellings []string) bool {
+	for _, spelling := range spellings {
+		if listed[spelling] {
+			return true
+		}
+	}
+	return false
+}
+
+func longestTerm(text string, terms []string) string {
+	found := ""
+	for _, term := range terms {
+		if len(term) > len(found) && termPatterns[term].MatchString(text) {
+			found = term
+		}
+	}
+	return found
+}
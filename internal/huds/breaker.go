@@ -0,0 +1,102 @@
+package huds
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+var ErrCircuitOpen = errors.New("HUDS API circuit breaker is open")
+
+// CircuitBreaker stops calling the upstream after Threshold consecutive
+// failures. Once Cooldown has passed a single trial request is let through;
+// its result decides whether the breaker closes again or stays open.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu          sync.Mutex
+	state       string
+	failures    int
+	openedAt    time.Time
+	trialActive bool
+	stats       BreakerStats
+}
+
+type BreakerStats struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	Requests            int        `json:"requests"`
+	Failures            int        `json:"failures"`
+	Rejected            int        `json:"rejected"`
+	Opened              int        `json:"times_opened"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	LastFailure         *time.Time `json:"last_failure,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+}
+
+// NewCircuitBreaker returns a closed breaker.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{Threshold: threshold, Cooldown: cooldown, state: BreakerClosed}
+}
+
+// Call runs fn unless the breaker is open, recording the outcome.
+func (b *CircuitBreaker) Call(fn func() error) error {
+	b.mu.Lock()
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.Cooldown {
+		b.state = BreakerHalfOpen
+	}
+	if b.state == BreakerOpen || (b.state == BreakerHalfOpen && b.trialActive) {
+		b.stats.Rejected++
+		b.mu.Unlock()
+		return ErrCircuitOpen
+	}
+	if b.state == BreakerHalfOpen {
+		b.trialActive = true
+	}
+	b.stats.Requests++
+	b.mu.Unlock()
+
+	err := fn()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trialActive = false
+	now := time.Now()
+	if err == nil {
+		b.state, b.failures = BreakerClosed, 0
+		b.stats.LastSuccess = &now
+		return nil
+	}
+	b.failures++
+	b.stats.Failures++
+	b.stats.LastFailure = &now
+	b.stats.LastError = err.Error()
+	if b.state == BreakerHalfOpen || b.failures >= b.Threshold {
+		if b.state != BreakerOpen {
+			b.stats.Opened++
+		}
+		b.state, b.openedAt = BreakerOpen, now
+	}
+	return err
+}
+
+func (b *CircuitBreaker) Stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := b.stats
+	stats.State = b.state
+	stats.ConsecutiveFailures = b.failures
+	if b.state != BreakerClosed {
+		openedAt := b.openedAt
+		stats.OpenedAt = &openedAt
+	}
+	return stats
+}
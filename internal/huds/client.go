@@ -0,0 +1,241 @@
+package huds
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Client fetches menu items from the HUDS API or something standing in for
+// it.
+type Client interface {
+	Fetch(ctx context.Context, query Query) ([]MenuItem, error)
+}
+
+const (
+	ClientAPI     = "api"
+	ClientFixture = "fixture"
+	ClientRecord  = "record"
+	ClientSandbox = "sandbox"
+)
+
+const (
+	defaultFixtureDir = "testdata/huds"
+	defaultKeyHeader  = "x-api-key"
+	// DefaultSandboxURL is where "hudsgry-api sandbox" serves fixtures
+	DefaultSandboxURL = "http://localhost:8089/recipes"
+)
+
+// ClientConfig is read from the environment (or .env):
+//
+//	HUDS_CLIENT          api (the default), fixture to replay saved responses,
+//	                     record to call the API and save its responses, or
+//	                     sandbox to call a local fixture server instead of HUDS
+//	HUDS_API_URL         the HUDS recipes endpoint, default the production API
+//	HUDS_API_KEY_HEADER  the header API_KEY is sent in, default x-api-key
+//	HUDS_SANDBOX_URL     the fixture server sandbox mode calls, default
+//	                     http://localhost:8089/recipes
+//	HUDS_FIXTURE_DIR     where fixtures are kept, default testdata/huds
+//	HUDS_FETCH_TIMEOUT   per-request timeout, default 60s; the whole feed is large
+type ClientConfig struct {
+	Mode       string
+	URL        string
+	KeyHeader  string
+	SandboxURL string
+	FixtureDir string
+	Timeout    time.Duration
+}
+
+func LoadClientConfig() (ClientConfig, error) {
+	config := ClientConfig{
+		Mode:       ClientAPI,
+		URL:        APIURL,
+		KeyHeader:  defaultKeyHeader,
+		SandboxURL: DefaultSandboxURL,
+		FixtureDir: defaultFixtureDir,
+		Timeout:    60 * time.Second,
+	}
+	for _, endpoint := range []struct {
+		name string
+		dest *string
+	}{{"HUDS_API_URL", &config.URL}, {"HUDS_SANDBOX_URL", &config.SandboxURL}} {
+		s := os.Getenv(endpoint.name)
+		if s == "" {
+			continue
+		}
+		u, err := url.Parse(s)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return config, fmt.Errorf("%s must be an http or https URL, got %q", endpoint.name, s)
+		}
+		*endpoint.dest = s
+	}
+	if s := os.Getenv("HUDS_API_KEY_HEADER"); s != "" {
+		if strings.ContainsAny(s, " :\r\n") {
+			return config, fmt.Errorf("HUDS_API_KEY_HEADER must be a header name, got %q", s)
+		}
+		config.KeyHeader = s
+	}
+	if s := os.Getenv("HUDS_FETCH_TIMEOUT"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return config, fmt.Errorf("HUDS_FETCH_TIMEOUT must be a positive duration, got %q", s)
+		}
+		config.Timeout = d
+	}
+	if dir := os.Getenv("HUDS_FIXTURE_DIR"); dir != "" {
+		config.FixtureDir = dir
+	}
+	switch mode := os.Getenv("HUDS_CLIENT"); mode {
+	case "":
+	case ClientAPI, ClientFixture, ClientRecord, ClientSandbox:
+		config.Mode = mode
+	default:
+		return config, fmt.Errorf("HUDS_CLIENT must be api, fixture, record or sandbox, got %q", mode)
+	}
+	// Staging runs in sandbox mode so that it can never reach HUDS
+	if config.Mode == ClientSandbox && sameHost(config.SandboxURL, APIURL) {
+		return config, fmt.Errorf("HUDS_SANDBOX_URL must not be the production HUDS API")
+	}
+	return config, nil
+}
+
+func sameHost(a string, b string) bool {
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	return errA == nil && errB == nil && strings.EqualFold(ua.Hostname(), ub.Hostname())
+}
+
+// NewClient builds the client the configuration asks for.
+func (c ClientConfig) NewClient() Client {
+	httpClient := &http.Client{Timeout: c.Timeout}
+	api := APIClient{URL: c.URL, Key: os.Getenv("API_KEY"), KeyHeader: c.KeyHeader, HTTP: httpClient}
+	switch c.Mode {
+	case ClientFixture:
+		return FixtureClient{Dir: c.FixtureDir}
+	case ClientRecord:
+		return RecordingClient{Client: api, Dir: c.FixtureDir}
+	case ClientSandbox:
+		// The production key stays out of sandboxes
+		return APIClient{URL: c.SandboxURL, HTTP: httpClient}
+	}
+	return api
+}
+
+// APIClient calls the HUDS API, or a sandbox standing in for it. Key is sent
+// in KeyHeader, x-api-key if empty, when set.
+type APIClient struct {
+	URL       string
+	Key       string
+	KeyHeader string
+	HTTP      *http.Client
+}
+
+func (a APIClient) Fetch(ctx context.Context, query Query) ([]MenuItem, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", a.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.URL.RawQuery = query.encode()
+
+	if a.Key != "" {
+		header := a.KeyHeader
+		if header == "" {
+			header = defaultKeyHeader
+		}
+		req.Header.Set(header, a.Key)
+	}
+	resp, err := a.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, UpstreamStatusError{StatusCode: resp.StatusCode}
+	}
+
+	var data []MenuItem
+
+	// Unmarshal the data response into the data struct
+	err = json.NewDecoder(resp.Body).Decode(&data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode HUDS response: %v", err)
+	}
+
+	return data, err
+}
+
+var nonFixturePattern = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// fixtureName is the file a query's response is saved under, e.g. all.json
+// for everything or date-10-16-2026.json for a single day.
+func fixtureName(query Query) string {
+	var parts []string
+	if query.Date != "" {
+		parts = append(parts, "date", query.Date)
+	}
+	if query.LocationID != "" {
+		parts = append(parts, "location", query.LocationID)
+	}
+	if len(parts) == 0 {
+		return "all.json"
+	}
+	return nonFixturePattern.ReplaceAllString(strings.Join(parts, "-"), "-") + ".json"
+}
+
+// FixtureClient answers from responses saved by RecordingClient, so the
+// fetch pipeline can run without the API key or network.
+type FixtureClient struct {
+	Dir string
+}
+
+func (f FixtureClient) Fetch(ctx context.Context, query Query) ([]MenuItem, error) {
+	path := filepath.Join(f.Dir, fixtureName(query))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("no HUDS fixture for this query: %v", err)
+	}
+	var items []MenuItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("failed to parse HUDS fixture %s: %v", path, err)
+	}
+	return items, nil
+}
+
+// RecordingClient passes fetches through to Client and saves each successful
+// response in Dir for FixtureClient to replay.
+type RecordingClient struct {
+	Client Client
+	Dir    string
+}
+
+func (r RecordingClient) Fetch(ctx context.Context, query Query) ([]MenuItem, error) {
+	items, err := r.Client.Fetch(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.save(query, items); err != nil {
+		log.Printf("Failed to record HUDS response: %v\n", err)
+	}
+	return items, nil
+}
+
+func (r RecordingClient) save(query Query, items []MenuItem) error {
+	if err := os.MkdirAll(r.Dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(r.Dir, fixtureName(query)), data, 0o644)
+}
@@ -0,0 +1,98 @@
+package huds
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DensityWeights weigh what makes up an item's nutrient density score.
+type DensityWeights struct {
+	// Protein is credited per gram per 100 kcal
+	Protein float64 `json:"protein"`
+	// Sodium is charged per 100mg per 100 kcal
+	Sodium float64 `json:"sodium"`
+	// SatFat is charged per gram per 100 kcal
+	SatFat float64 `json:"sat_fat"`
+}
+
+// DefaultDensityWeights count a gram of sat fat as much as a gram of protein,
+// and 200mg of sodium the same again.
+var DefaultDensityWeights = DensityWeights{Protein: 1, Sodium: 0.5, SatFat: 1}
+
+// densityCalorieFloor is the fewest calories a score is worked out per, so
+// that condiments with a gram of protein and next to no calories don't top
+// every meal.
+const densityCalorieFloor = 50
+
+var densityWeights = struct {
+	sync.RWMutex
+	weights DensityWeights
+}{weights: DefaultDensityWeights}
+
+// UseDensityWeights replaces the weights the converters score items with.
+func UseDensityWeights(weights DensityWeights) {
+	densityWeights.Lock()
+	defer densityWeights.Unlock()
+	densityWeights.weights = weights
+}
+
+// CurrentDensityWeights are the weights items are scored with.
+func CurrentDensityWeights() DensityWeights {
+	densityWeights.RLock()
+	defer densityWeights.RUnlock()
+	return densityWeights.weights
+}
+
+// LoadDensityWeights overrides the default weights with DENSITY_WEIGHTS, a
+// comma separated list such as "protein=1,sodium=0.25,sat_fat=2". Weights
+// left out keep their default.
+func LoadDensityWeights() (DensityWeights, error) {
+	weights := DefaultDensityWeights
+	s := os.Getenv("DENSITY_WEIGHTS")
+	if s == "" {
+		return weights, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		weight, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if !ok || err != nil || weight < 0 {
+			return weights, fmt.Errorf("DENSITY_WEIGHTS must look like protein=1,sodium=0.5,sat_fat=1, got %q", pair)
+		}
+		switch strings.TrimSpace(name) {
+		case "protein":
+			weights.Protein = weight
+		case "sodium":
+			weights.Sodium = weight
+		case "sat_fat":
+			weights.SatFat = weight
+		default:
+			return weights, fmt.Errorf("DENSITY_WEIGHTS can only weigh protein, sodium and sat_fat, got %q", name)
+		}
+	}
+	return weights, nil
+}
+
+// DensityScore rates how much protein an item gives for its calories, less
+// penalties for its sodium and sat fat, each per 100 kcal and weighted. It is
+// nil for items without calories, which can't be compared.
+func DensityScore(facts *NutritionFacts, weights DensityWeights) *float64 {
+	if facts == nil || facts.Calories == nil || facts.Calories.Value <= 0 {
+		return nil
+	}
+	value := func(amount *Amount) float64 {
+		if amount == nil {
+			return 0
+		}
+		return amount.Value
+	}
+	per100 := 100 / math.Max(facts.Calories.Value, densityCalorieFloor)
+	score := weights.Protein*value(facts.Protein)*per100 -
+		weights.Sodium*value(facts.Sodium)/100*per100 -
+		weights.SatFat*value(facts.SatFat)*per100
+	score = math.Round(score*100) / 100
+	return &score
+}
@@ -0,0 +1,57 @@
+package huds
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// hashedMeals is what a menu's content hash covers. Extra meals are hidden
+// from the API's JSON, so they are spelled out here.
+type hashedMeals struct {
+	Name      string              `json:"name,omitempty"`
+	Breakfast []CondensedMenuItem `json:"breakfast"`
+	Lunch     []CondensedMenuItem `json:"lunch"`
+	Dinner    []CondensedMenuItem `json:"dinner"`
+	Extra     []hashedExtraMeal   `json:"extra,omitempty"`
+}
+
+type hashedExtraMeal struct {
+	Number int                 `json:"number"`
+	Key    string              `json:"key"`
+	Items  []CondensedMenuItem `json:"items"`
+}
+
+func hashMeals(name string, breakfast []CondensedMenuItem, lunch []CondensedMenuItem, dinner []CondensedMenuItem, extra []ExtraMeal) hashedMeals {
+	meals := hashedMeals{Name: name, Breakfast: breakfast, Lunch: lunch, Dinner: dinner}
+	for _, meal := range extra {
+		meals.Extra = append(meals.Extra, hashedExtraMeal{Number: meal.Number, Key: meal.Key, Items: meal.Items})
+	}
+	return meals
+}
+
+// ContentHash fingerprints what was served on a day: the default menu,
+// Annenberg's and every location's. When and from where it was fetched
+// don't count, so a day HUDS sends again unchanged hashes the same.
+func ContentHash(menu CondensedMenu, locations map[string]LocationMenu) string {
+	content := struct {
+		Menu      hashedMeals            `json:"menu"`
+		Annenberg *hashedMeals           `json:"annenberg,omitempty"`
+		Locations map[string]hashedMeals `json:"locations,omitempty"`
+	}{
+		Menu:      hashMeals("", menu.Breakfast, menu.Lunch, menu.Dinner, menu.Extra),
+		Locations: make(map[string]hashedMeals, len(locations)),
+	}
+	if menu.Annenberg != nil {
+		annenberg := hashMeals(menu.Annenberg.Name, menu.Annenberg.Breakfast, menu.Annenberg.Lunch, menu.Annenberg.Dinner, menu.Annenberg.Extra)
+		content.Annenberg = &annenberg
+	}
+	// Maps are encoded with their keys sorted, so the order locations were
+	// converted in doesn't matter
+	for key, location := range locations {
+		content.Locations[key] = hashMeals(location.Name, location.Breakfast, location.Lunch, location.Dinner, location.Extra)
+	}
+	data, _ := json.Marshal(content)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
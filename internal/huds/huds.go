@@ -0,0 +1,307 @@
+// Package huds describes the HUDS recipes feed and fetches it.
+package huds
+
+import (
+	"net/url"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+	// Bundled so America/New_York resolves even on images without zoneinfo
+	_ "time/tzdata"
+)
+
+// MenuItem is one recipe row as returned by the HUDS API.
+type MenuItem struct {
+	Allergens                string `json:"Allergens"`
+	Calories                 string `json:"Calories"`
+	CaloriesFromFat          string `json:"Calories_From_Fat"`
+	CateringDepartment       string `json:"Catering_Department"`
+	Cholesterol              string `json:"Cholesterol"`
+	CholesterolDv            string `json:"Cholesterol_DV"`
+	DietaryFiber             string `json:"Dietary_Fiber"`
+	DietaryFiberDv           string `json:"Dietary_Fiber_DV"`
+	ID                       int    `json:"ID"`
+	IngredientList           string `json:"Ingredient_List"`
+	LocationName             string `json:"Location_Name"`
+	LocationNumber           string `json:"Location_Number"`
+	MealName                 string `json:"Meal_Name"`
+	MealNumber               int    `json:"Meal_Number"`
+	MenuCategoryName         string `json:"Menu_Category_Name"`
+	MenuCategoryNumber       string `json:"Menu_Category_Number"`
+	ProductionDepartment     string `json:"Production_Department"`
+	Protein                  string `json:"Protein"`
+	ProteinDv                string `json:"Protein_DV"`
+	RecipeName               string `json:"Recipe_Name"`
+	RecipeNumber             string `json:"Recipe_Number"`
+	RecipePrintAsCharacter   string `json:"Recipe_Print_As_Character"`
+	RecipePrintAsColor       string `json:"Recipe_Print_As_Color"`
+	RecipePrintAsName        string `json:"Recipe_Print_As_Name"`
+	RecipeProductInformation string `json:"Recipe_Product_Information"`
+	RecipeWebCodes           string `json:"Recipe_Web_Codes"`
+	SatFat                   string `json:"Sat_Fat"`
+	SatFatDv                 string `json:"Sat_Fat_DV"`
+	ServeDate                string `json:"Serve_Date"`
+	ServiceDepartment        string `json:"Service_Department"`
+	ServingSize              string `json:"Serving_Size"`
+	Sodium                   string `json:"Sodium"`
+	SodiumDv                 string `json:"Sodium_DV"`
+	Sugars                   string `json:"Sugars"`
+	SugarsDv                 string `json:"Sugars_DV"`
+	TotalCarb                string `json:"Total_Carb"`
+	TotalCarbDv              string `json:"Total_Carb_DV"`
+	TotalFat                 string `json:"Total_Fat"`
+	TotalFatDv               string `json:"Total_Fat_DV"`
+	TransFat                 string `json:"Trans_Fat"`
+	TransFatDv               string `json:"Trans_Fat_DV"`
+	UpdateDate               string `json:"Update_Date"`
+	PortionCost              string `json:"portion_cost"`
+	SellingPrice             string `json:"selling_price"`
+}
+
+type CondensedMenuItem struct {
+	Allergens          string          `json:"Allergens"`
+	Calories           string          `json:"Calories"`
+	Cholesterol        string          `json:"Cholesterol,omitempty"`
+	DietaryFiber       string          `json:"Dietary_Fiber,omitempty"`
+	ID                 int             `json:"ID,omitempty"`
+	Ingredients        string          `json:"Ingredient_List,omitempty"`
+	FoodName           string          `json:"Food_Name"`
+	HouseLocation      bool            `json:"House_Location"`
+	MealNumber         *int            `json:"Meal_Number,omitempty"`
+	MenuCategory       string          `json:"Menu_Category_Name"`
+	Nutrition          *NutritionFacts `json:"nutrition,omitempty"`
+	ProductInformation string          `json:"Recipe_Product_Information,omitempty"`
+	Protein            string          `json:"Protein,omitempty"`
+	RecipeNumber       string          `json:"Recipe_Number,omitempty"`
+	SatFat             string          `json:"Sat_Fat,omitempty"`
+	ServeDate          *string         `json:"Serve_Date,omitempty"`
+	ServingSize        string          `json:"Serving_Size,omitempty"`
+	Sodium             string          `json:"Sodium,omitempty"`
+	Sugars             string          `json:"Sugars,omitempty"`
+	TotalCarb          string          `json:"Total_Carb,omitempty"`
+	TotalFat           string          `json:"Total_Fat,omitempty"`
+	TransFat           string          `json:"Trans_Fat,omitempty"`
+	Vegan              bool            `json:"Vegan"`
+	Vegetarian         bool            `json:"Vegetarian"`
+	Halal              bool            `json:"Halal"`
+	GlutenFree         bool            `json:"Gluten_Free"`
+	WholeGrain         bool            `json:"Whole_Grain"`
+	Local              bool            `json:"Local"`
+	SustainableSeafood bool            `json:"Sustainable_Seafood"`
+	// Cancelled is set by an admin's override when HUDS still lists a dish
+	// that won't be served
+	Cancelled bool `json:"Cancelled,omitempty"`
+	// NutrientDensity is the item's DensityScore as of when it was stored,
+	// nil for items stored before scores were kept
+	NutrientDensity *float64 `json:"nutrient_density,omitempty" bson:"nutrient_density,omitempty"`
+	// DerivedAllergens are allergens found in Ingredients that Allergens
+	// misses, as of when the item was stored
+	DerivedAllergens []DerivedAllergen `json:"derived_allergens,omitempty" bson:"derived_allergens,omitempty"`
+	// Serving is ServingSize parsed, nil for items stored before sizes were
+	// parsed or with a size that has no quantity
+	Serving *Serving `json:"serving,omitempty" bson:"serving,omitempty"`
+	// PortionCost is what a serving costs HUDS to make and SellingPrice what
+	// it sells for, in dollars, nil when HUDS hasn't costed the recipe or the
+	// item was stored before prices were kept
+	PortionCost  *float64 `json:"Portion_Cost,omitempty" bson:"portion_cost,omitempty"`
+	SellingPrice *float64 `json:"Selling_Price,omitempty" bson:"selling_price,omitempty"`
+	// Staple marks what is served every day, such as peanut butter; it is
+	// worked out when served from the configured staples
+	Staple bool `json:"Staple,omitempty" bson:"-"`
+	// Meals and Categories are where a recipe appears on the day's menu,
+	// set when duplicates are collapsed
+	Meals      []string `json:"meals,omitempty" bson:"-"`
+	Categories []string `json:"categories,omitempty" bson:"-"`
+	// Tags are curated by admins and joined in when menus are served, never
+	// stored with the menu
+	Tags []string `json:"tags,omitempty" bson:"-"`
+	// Photos are URLs of users' approved photos of the dish, also joined in
+	// when served
+	Photos []string `json:"photos,omitempty" bson:"-"`
+	// Confidence is the share of past cycles a predicted item was served on
+	// at the same point, only set on predicted menus
+	Confidence float64 `json:"confidence,omitempty" bson:"-"`
+}
+
+type CondensedMenu struct {
+	ServeDate string              `json:"Serve_Date,omitempty" bson:"serve_date"`
+	Breakfast []CondensedMenuItem `json:"Breakfast" bson:"breakfast"`
+	Lunch     []CondensedMenuItem `json:"Lunch" bson:"lunch"`
+	Dinner    []CondensedMenuItem `json:"Dinner" bson:"dinner"`
+	Extra     []ExtraMeal         `json:"-" bson:"extra,omitempty"`
+	// UpdatedAt is when the day was last stored from a HUDS fetch, which is
+	// only when its content changed
+	UpdatedAt time.Time `json:"-" bson:"updated_at,omitempty"`
+	// ContentHash is the day's ContentHash as of UpdatedAt, empty for days
+	// stored before it was kept
+	ContentHash string `json:"-" bson:"content_hash,omitempty"`
+	// Provider names the dining provider the menu was fetched from
+	Provider string `json:"-" bson:"provider,omitempty"`
+	// Annenberg is Annenberg Hall's own menu for the day, which sometimes
+	// differs from the houses' beyond breakfast. It is nil for days stored
+	// before it was kept.
+	Annenberg *LocationMenu `json:"-" bson:"annenberg,omitempty"`
+}
+
+const APIURL = "https://go.apis.huit.harvard.edu/ats/dining/v3/recipes"
+
+// ServeDateLayout is the MM/DD/YYYY format HUDS uses for serve dates.
+const ServeDateLayout = "01/02/2006"
+
+// DiningZone is where the dining halls are. Serve dates, delivery times and
+// the refresh schedule all follow its wall clock, daylight saving included,
+// whatever zone the server itself runs in.
+var DiningZone = mustLoadLocation("America/New_York")
+
+func mustLoadLocation(name string) *time.Location {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return location
+}
+
+// inHouseDefault reports whether an item belongs in the default menu, coming
+// from the location the mapping has standing in for the houses at its meal.
+func inHouseDefault(mapping MealMapping, item MenuItem) bool {
+	return item.LocationName == mapping.location(item.MealNumber)
+}
+
+func ConvertToCondensedMenuItem(item MenuItem) CondensedMenuItem {
+	codes := webCodes(item.RecipeWebCodes)
+	condensed := CondensedMenuItem{
+		Allergens:          item.Allergens,
+		Calories:           item.Calories,
+		Cholesterol:        item.Cholesterol,
+		DietaryFiber:       item.DietaryFiber,
+		ID:                 item.ID,
+		Ingredients:        item.IngredientList,
+		FoodName:           item.RecipePrintAsName,
+		HouseLocation:      strings.HasSuffix(item.LocationName, " House"),
+		MealNumber:         &item.MealNumber,
+		MenuCategory:       item.MenuCategoryName,
+		ProductInformation: item.RecipeProductInformation,
+		Protein:            item.Protein,
+		RecipeNumber:       item.RecipeNumber,
+		SatFat:             item.SatFat,
+		ServeDate:          &item.ServeDate,
+		ServingSize:        item.ServingSize,
+		Sodium:             item.Sodium,
+		Sugars:             item.Sugars,
+		TotalCarb:          item.TotalCarb,
+		TotalFat:           item.TotalFat,
+		TransFat:           item.TransFat,
+		Vegan:              codes["VGN"],
+		Vegetarian:         codes["VGT"],
+		Halal:              codes["HAL"],
+		GlutenFree:         codes["GF"],
+		WholeGrain:         codes["WGRN"],
+		Local:              codes["LOC"],
+		SustainableSeafood: codes["SUS"],
+	}
+	condensed.Nutrition = ParseNutrition(condensed)
+	condensed.NutrientDensity = DensityScore(condensed.Nutrition, CurrentDensityWeights())
+	condensed.DerivedAllergens = DeriveAllergens(condensed.Ingredients, condensed.Allergens)
+	condensed.Serving = ParseServing(condensed.ServingSize)
+	condensed.PortionCost = ParsePrice(item.PortionCost)
+	condensed.SellingPrice = ParsePrice(item.SellingPrice)
+	return condensed
+}
+
+// convertBatchMin is the fewest items each conversion worker is given, as
+// below it starting goroutines costs more than it saves.
+const convertBatchMin = 2000
+
+// ConvertMenuItemsToCondensedMenuItems builds the house default menu for each
+// day, keyed by serve date and then meal number, converting on a worker per
+// CPU when there are enough items.
+func ConvertMenuItemsToCondensedMenuItems(items []MenuItem) map[string]map[int][]CondensedMenuItem {
+	return ConvertMenuItemsWithWorkers(items, runtime.GOMAXPROCS(0))
+}
+
+// ConvertMenuItemsWithWorkers is ConvertMenuItemsToCondensedMenuItems on at
+// most workers goroutines. The result doesn't depend on how many: items keep
+// their upstream order within each meal.
+func ConvertMenuItemsWithWorkers(items []MenuItem, workers int) map[string]map[int][]CondensedMenuItem {
+	mapping := currentMealMapping()
+
+	// Picking the items and their meals is cheap, and done in order so that
+	// meal periods are learned from the first item of each, as they always
+	// were
+	kept := make([]MenuItem, 0, len(items))
+	for _, item := range items {
+		if !inHouseDefault(mapping, item) || item.MealNumber < 1 {
+			continue
+		}
+		item.MealNumber = mapping.mealNumber(item.MealNumber, item.MealName)
+		kept = append(kept, item)
+	}
+
+	// Each worker converts its own contiguous batch into its own slots, so
+	// nothing is shared but the slice
+	condensed := make([]CondensedMenuItem, len(kept))
+	if most := len(kept) / convertBatchMin; workers > most {
+		workers = most
+	}
+	if workers <= 1 {
+		for i, item := range kept {
+			condensed[i] = ConvertToCondensedMenuItem(item)
+		}
+	} else {
+		batch := (len(kept) + workers - 1) / workers
+		var wg sync.WaitGroup
+		for start := 0; start < len(kept); start += batch {
+			end := start + batch
+			if end > len(kept) {
+				end = len(kept)
+			}
+			wg.Add(1)
+			go func(start int, end int) {
+				defer wg.Done()
+				for i := start; i < end; i++ {
+					condensed[i] = ConvertToCondensedMenuItem(kept[i])
+				}
+			}(start, end)
+		}
+		wg.Wait()
+	}
+
+	itemsByCategory := make(map[string]map[int][]CondensedMenuItem)
+	for _, condensedItem := range condensed {
+		key := *condensedItem.ServeDate
+		mealNumber := *condensedItem.MealNumber
+
+		if _, exists := itemsByCategory[key]; !exists {
+			itemsByCategory[key] = make(map[int][]CondensedMenuItem)
+		}
+
+		// No longer needed, so remove from struct to save space
+		condensedItem.ServeDate = nil
+		condensedItem.MealNumber = nil
+
+		itemsByCategory[key][mealNumber] = append(itemsByCategory[key][mealNumber], condensedItem)
+	}
+
+	return itemsByCategory
+}
+
+// Query narrows an upstream fetch. The zero value fetches everything HUDS
+// has published.
+type Query struct {
+	// Date is a serve date in MM/DD/YYYY format
+	Date string
+	// LocationID is the upstream Location_Number
+	LocationID string
+}
+
+func (q Query) encode() string {
+	params := url.Values{}
+	if q.Date != "" {
+		params.Set("date", q.Date)
+	}
+	if q.LocationID != "" {
+		params.Set("locationId", q.LocationID)
+	}
+	return params.Encode()
+}
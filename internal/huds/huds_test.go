@@ -0,0 +1,85 @@
+package huds
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// syntheticPayload is what HUDS sends for days of menus at the default
+// locations and two other houses, whose items are left out of the default
+// menu.
+func syntheticPayload(days int, perMeal int) []MenuItem {
+	meals := map[int]string{1: "Breakfast Menu", 2: "Lunch Menu", 3: "Dinner Menu"}
+	start := time.Date(2025, time.September, 1, 0, 0, 0, 0, time.UTC)
+	var items []MenuItem
+	for day := 0; day < days; day++ {
+		serveDate := start.AddDate(0, 0, day).Format(ServeDateLayout)
+		for number := 1; number <= 3; number++ {
+			locations := []string{DefaultMealMapping.DefaultLocation, "Adams House", "Lowell House"}
+			if number == 1 {
+				locations[0] = AnnenbergHall
+			}
+			for _, location := range locations {
+				for i := 0; i < perMeal; i++ {
+					items = append(items, MenuItem{
+						ID:                len(items) + 1,
+						ServeDate:         serveDate,
+						MealNumber:        number,
+						MealName:          meals[number],
+						LocationName:      location,
+						MenuCategoryName:  "Entrees",
+						RecipeNumber:      fmt.Sprintf("%06d", i),
+						RecipePrintAsName: fmt.Sprintf("Dish %d", i),
+						RecipeWebCodes:    "VGT GF LOC",
+						IngredientList:    "Water, Salt, Flour",
+						ServingSize:       "1 EACH",
+						Calories:          "250",
+						TotalFat:          "9g",
+						SatFat:            "2.5g",
+						TransFat:          "0g",
+						Cholesterol:       "15mg",
+						Sodium:            "480mg",
+						TotalCarb:         "31g",
+						DietaryFiber:      "4g",
+						Sugars:            "6g",
+						Protein:           "12g",
+					})
+				}
+			}
+		}
+	}
+	return items
+}
+
+func TestConvertMenuItemsWithWorkersMatchesSerial(t *testing.T) {
+	items := syntheticPayload(30, 20)
+	serial := ConvertMenuItemsWithWorkers(items, 1)
+	if len(serial) != 30 {
+		t.Fatalf("got %d days, want 30", len(serial))
+	}
+	for _, workers := range []int{2, 7, runtime.GOMAXPROCS(0)} {
+		if pooled := ConvertMenuItemsWithWorkers(items, workers); !reflect.DeepEqual(serial, pooled) {
+			t.Errorf("converting on %d workers gave different menus than converting serially", workers)
+		}
+	}
+}
+
+func BenchmarkConvertMenuItems(b *testing.B) {
+	items := syntheticPayload(365, 40)
+	for _, bench := range []struct {
+		name    string
+		workers int
+	}{
+		{"serial", 1},
+		{"pooled", runtime.GOMAXPROCS(0)},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				ConvertMenuItemsWithWorkers(items, bench.workers)
+			}
+		})
+	}
+}
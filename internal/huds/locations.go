@@ -0,0 +1,92 @@
+package huds
+
+import (
+	"regexp"
+	"strings"
+)
+
+// GrabAndGoMeal is the key of what a location offers without a meal period,
+// such as Fly-By's bagged lunches and the retail cafés' grab-and-go. HUDS
+// lists those items with no meal number.
+const GrabAndGoMeal = "grab_and_go"
+
+// LocationMenu is one dining location's menu for a day. Grab-and-go items
+// are kept as an extra meal keyed GrabAndGoMeal, after every other meal.
+type LocationMenu struct {
+	Name      string              `json:"Location_Name" bson:"name"`
+	Breakfast []CondensedMenuItem `json:"Breakfast" bson:"breakfast"`
+	Lunch     []CondensedMenuItem `json:"Lunch" bson:"lunch"`
+	Dinner    []CondensedMenuItem `json:"Dinner" bson:"dinner"`
+	Extra     []ExtraMeal         `json:"-" bson:"extra,omitempty"`
+}
+
+// AnnenbergHall is the upstream Location_Name of the first-year dining hall.
+const AnnenbergHall = "Annenberg Hall"
+
+// Menu returns the location's meals as a day's menu.
+func (l LocationMenu) Menu(date string) CondensedMenu {
+	return CondensedMenu{ServeDate: date, Breakfast: l.Breakfast, Lunch: l.Lunch, Dinner: l.Dinner, Extra: l.Extra}
+}
+
+// LocationMenuOf is the inverse of LocationMenu.Menu.
+func LocationMenuOf(name string, menu CondensedMenu) LocationMenu {
+	return LocationMenu{Name: name, Breakfast: menu.Breakfast, Lunch: menu.Lunch, Dinner: menu.Dinner, Extra: menu.Extra}
+}
+
+var nonSlugPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// LocationKey turns a location name into a key that is safe to use as a field
+// name, e.g. "Annenberg Hall" becomes "annenberg-hall".
+func LocationKey(name string) string {
+	return strings.Trim(nonSlugPattern.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// ConvertMenuItemsByLocation groups every item by serve date and location,
+// including the grab-and-go locations left out of the house default menu.
+func ConvertMenuItemsByLocation(items []MenuItem) map[string]map[string]LocationMenu {
+	names := make(map[string]string)
+	meals := make(map[string]map[string]map[int][]CondensedMenuItem)
+	mapping := currentMealMapping()
+	for _, item := range items {
+		key := LocationKey(item.LocationName)
+		if key == "" {
+			continue
+		}
+		if item.MealNumber < 1 {
+			item.MealNumber = 0
+		} else {
+			item.MealNumber = mapping.mealNumber(item.MealNumber, item.MealName)
+		}
+		condensedItem := ConvertToCondensedMenuItem(item)
+		condensedItem.ServeDate = nil
+		condensedItem.MealNumber = nil
+
+		if _, exists := meals[item.ServeDate]; !exists {
+			meals[item.ServeDate] = make(map[string]map[int][]CondensedMenuItem)
+		}
+		if _, exists := meals[item.ServeDate][key]; !exists {
+			meals[item.ServeDate][key] = make(map[int][]CondensedMenuItem)
+		}
+		names[key] = item.LocationName
+		meals[item.ServeDate][key][item.MealNumber] = append(meals[item.ServeDate][key][item.MealNumber], condensedItem)
+	}
+
+	byDate := make(map[string]map[string]LocationMenu)
+	for date, locations := range meals {
+		byDate[date] = make(map[string]LocationMenu)
+		for key, locationMeals := range locations {
+			menu := MenuFromMeals(date, locationMeals)
+			if grabAndGo := locationMeals[0]; len(grabAndGo) > 0 {
+				menu.Extra = append(menu.Extra, ExtraMeal{Number: 0, Key: GrabAndGoMeal, Items: grabAndGo})
+			}
+			byDate[date][key] = LocationMenu{
+				Name:      names[key],
+				Breakfast: menu.Breakfast,
+				Lunch:     menu.Lunch,
+				Dinner:    menu.Dinner,
+				Extra:     menu.Extra,
+			}
+		}
+	}
+	return byDate
+}
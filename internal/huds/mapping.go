@@ -0,0 +1,115 @@
+package huds
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MealRule says what an upstream meal number is served as, and which
+// location's items make up the default menu for it.
+type MealRule struct {
+	// Meal is the meal key, e.g. "breakfast" or "brain_break"
+	Meal string
+	// Location is the upstream Location_Name standing in for every house.
+	// Empty uses the mapping's DefaultLocation.
+	Location string
+}
+
+// MealMapping maps the upstream meal numbers and locations onto the default
+// menu. All of the houses serve the same food, so one location stands in for
+// them at each meal.
+type MealMapping struct {
+	Meals map[int]MealRule
+	// DefaultLocation stands in at meals without a location of their own
+	DefaultLocation string
+}
+
+// DefaultMealMapping takes breakfast from Annenberg and lunch, dinner and
+// everything else from Currier.
+var DefaultMealMapping = MealMapping{
+	Meals: map[int]MealRule{
+		1: {Meal: "breakfast", Location: AnnenbergHall},
+		2: {Meal: "lunch"},
+		3: {Meal: "dinner"},
+	},
+	DefaultLocation: "Currier House",
+}
+
+var mealMapping = struct {
+	sync.RWMutex
+	mapping MealMapping
+}{mapping: DefaultMealMapping}
+
+// UseMealMapping replaces the mapping the converters use.
+func UseMealMapping(mapping MealMapping) {
+	mealMapping.Lock()
+	defer mealMapping.Unlock()
+	mealMapping.mapping = mapping
+}
+
+func currentMealMapping() MealMapping {
+	mealMapping.RLock()
+	defer mealMapping.RUnlock()
+	return mealMapping.mapping
+}
+
+// LoadMealMapping overrides the default mapping with HUDS_MEALS, a comma
+// separated list of number=meal@location rules such as
+// "1=breakfast@Annenberg Hall,2=lunch", and HUDS_DEFAULT_LOCATION.
+func LoadMealMapping() (MealMapping, error) {
+	mapping := DefaultMealMapping
+	if location := os.Getenv("HUDS_DEFAULT_LOCATION"); location != "" {
+		mapping.DefaultLocation = location
+	}
+	s := os.Getenv("HUDS_MEALS")
+	if s == "" {
+		return mapping, nil
+	}
+	mapping.Meals = make(map[int]MealRule)
+	for _, rule := range strings.Split(s, ",") {
+		number, target, ok := strings.Cut(strings.TrimSpace(rule), "=")
+		n, err := strconv.Atoi(number)
+		if !ok || err != nil || n < 1 {
+			return mapping, fmt.Errorf("HUDS_MEALS rules must look like 1=breakfast@Annenberg Hall, got %q", rule)
+		}
+		meal, location, _ := strings.Cut(target, "@")
+		meal = mealKey(meal)
+		if meal == "" {
+			return mapping, fmt.Errorf("HUDS_MEALS rule %q has no meal", rule)
+		}
+		mapping.Meals[n] = MealRule{Meal: meal, Location: strings.TrimSpace(location)}
+	}
+	return mapping, nil
+}
+
+// location returns the location standing in at an upstream meal number.
+func (m MealMapping) location(number int) string {
+	if rule, ok := m.Meals[number]; ok && rule.Location != "" {
+		return rule.Location
+	}
+	return m.DefaultLocation
+}
+
+// mealNumber turns an upstream meal number into the one menus are keyed by,
+// where breakfast, lunch and dinner are always 1, 2 and 3, and learns the key
+// of any other meal.
+func (m MealMapping) mealNumber(number int, name string) int {
+	rule, ok := m.Meals[number]
+	if !ok {
+		LearnMealPeriod(number, name)
+		return number
+	}
+	switch rule.Meal {
+	case "breakfast":
+		return 1
+	case "lunch":
+		return 2
+	case "dinner":
+		return 3
+	}
+	LearnMealPeriod(number, rule.Meal)
+	return number
+}
@@ -0,0 +1,176 @@
+package huds
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ExtraMeal is a meal period beyond breakfast, lunch and dinner, such as brain
+// break or late night, stored under the key derived from its upstream name.
+type ExtraMeal struct {
+	Number int                 `json:"-" bson:"number"`
+	Key    string              `json:"-" bson:"key"`
+	Items  []CondensedMenuItem `json:"-" bson:"items"`
+}
+
+// mealPeriods maps upstream meal numbers to response keys. The first three
+// are fixed; any others are learned from the feed's meal names as they show up.
+var mealPeriods = struct {
+	sync.RWMutex
+	keys map[int]string
+}{keys: map[int]string{1: "breakfast", 2: "lunch", 3: "dinner"}}
+
+// LearnMealPeriod records the key for a meal number from its upstream name,
+// e.g. "Brain Break" becomes "brain_break".
+func LearnMealPeriod(number int, name string) {
+	mealPeriods.Lock()
+	defer mealPeriods.Unlock()
+	if _, known := mealPeriods.keys[number]; known {
+		return
+	}
+	key := mealKey(name)
+	if key == "" {
+		key = fmt.Sprintf("meal_%d", number)
+	}
+	mealPeriods.keys[number] = key
+}
+
+// mealKey turns a meal name into its key, e.g. "Brain Break" becomes
+// "brain_break".
+func mealKey(name string) string {
+	return strings.Trim(nonSlugPattern.ReplaceAllString(strings.ToLower(name), "_"), "_")
+}
+
+func MealPeriodKey(number int) string {
+	mealPeriods.RLock()
+	defer mealPeriods.RUnlock()
+	if key, known := mealPeriods.keys[number]; known {
+		return key
+	}
+	return fmt.Sprintf("meal_%d", number)
+}
+
+// MenuFromMeals builds a day's menu from items keyed by meal number.
+func MenuFromMeals(date string, meals map[int][]CondensedMenuItem) CondensedMenu {
+	menu := CondensedMenu{ServeDate: date, Breakfast: meals[1], Lunch: meals[2], Dinner: meals[3]}
+	for number, items := range meals {
+		if number > 3 {
+			menu.Extra = append(menu.Extra, ExtraMeal{Number: number, Key: MealPeriodKey(number), Items: items})
+		}
+	}
+	sortExtraMeals(menu.Extra)
+	return menu
+}
+
+// MealsFromMenu is the inverse of MenuFromMeals.
+func MealsFromMenu(menu CondensedMenu) map[int][]CondensedMenuItem {
+	meals := map[int][]CondensedMenuItem{1: menu.Breakfast, 2: menu.Lunch, 3: menu.Dinner}
+	for _, extra := range menu.Extra {
+		LearnMealPeriod(extra.Number, extra.Key)
+		meals[extra.Number] = extra.Items
+	}
+	return meals
+}
+
+func sortExtraMeals(extra []ExtraMeal) {
+	sort.Slice(extra, func(i, j int) bool { return extra[i].Number < extra[j].Number })
+}
+
+// Meals lists the keys of every meal on the menu, in serving order.
+func (m CondensedMenu) Meals() []string {
+	meals := []string{"breakfast", "lunch", "dinner"}
+	for _, extra := range m.Extra {
+		meals = append(meals, extra.Key)
+	}
+	return meals
+}
+
+// HasItems reports whether any meal on the menu has items, so a day with only
+// brunch or late night still counts as having a menu.
+func (m CondensedMenu) HasItems() bool {
+	if len(m.Breakfast) > 0 || len(m.Lunch) > 0 || len(m.Dinner) > 0 {
+		return true
+	}
+	for _, extra := range m.Extra {
+		if len(extra.Items) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// MapMeals returns a copy of the menu with f applied to every meal's items.
+func (m CondensedMenu) MapMeals(f func([]CondensedMenuItem) []CondensedMenuItem) CondensedMenu {
+	m.Breakfast = f(m.Breakfast)
+	m.Lunch = f(m.Lunch)
+	m.Dinner = f(m.Dinner)
+	extra := make([]ExtraMeal, len(m.Extra))
+	for i, meal := range m.Extra {
+		meal.Items = f(meal.Items)
+		extra[i] = meal
+	}
+	m.Extra = extra
+	return m
+}
+
+// MealResponseKey turns a meal key into the casing used in responses, e.g.
+// "brain_break" becomes "Brain_Break".
+func MealResponseKey(key string) string {
+	parts := strings.Split(key, "_")
+	for i, part := range parts {
+		if part != "" {
+			parts[i] = strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return strings.Join(parts, "_")
+}
+
+// MarshalJSON adds each extra meal under its own key next to Breakfast, Lunch
+// and Dinner.
+func (m CondensedMenu) MarshalJSON() ([]byte, error) {
+	// plain drops the MarshalJSON method so the default encoding is used
+	type plain CondensedMenu
+	data, err := json.Marshal(plain(m))
+	if err != nil || len(m.Extra) == 0 {
+		return data, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for _, extra := range m.Extra {
+		items, err := json.Marshal(extra.Items)
+		if err != nil {
+			return nil, err
+		}
+		fields[MealResponseKey(extra.Key)] = items
+	}
+	return json.Marshal(fields)
+}
+
+// MealItems returns the items served at a meal, by its key.
+func MealItems(menu CondensedMenu, meal string) []CondensedMenuItem {
+	switch meal {
+	case "breakfast":
+		return menu.Breakfast
+	case "lunch":
+		return menu.Lunch
+	case "dinner":
+		return menu.Dinner
+	}
+	for _, extra := range menu.Extra {
+		if extra.Key == meal {
+			return extra.Items
+		}
+	}
+	return nil
+}
+
+// SortMeals puts meal names in the order they are served.
+func SortMeals(meals []string) {
+	order := map[string]int{"breakfast": 1, "lunch": 2, "dinner": 3}
+	sort.Slice(meals, func(i, j int) bool { return order[meals[i]] < order[meals[j]] })
+}
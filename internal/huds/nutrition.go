@@ -0,0 +1,64 @@
+package huds
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Amount is a parsed nutrition value, e.g. "120mg" is 120 of mg.
+type Amount struct {
+	Value float64 `json:"value" bson:"value"`
+	Unit  string  `json:"unit" bson:"unit"`
+}
+
+// NutritionFacts are an item's nutrition strings parsed into numbers. Values
+// HUDS left blank or sent malformed are nil.
+type NutritionFacts struct {
+	Calories     *Amount `json:"calories,omitempty" bson:"calories,omitempty"`
+	TotalFat     *Amount `json:"total_fat,omitempty" bson:"total_fat,omitempty"`
+	SatFat       *Amount `json:"sat_fat,omitempty" bson:"sat_fat,omitempty"`
+	TransFat     *Amount `json:"trans_fat,omitempty" bson:"trans_fat,omitempty"`
+	Cholesterol  *Amount `json:"cholesterol,omitempty" bson:"cholesterol,omitempty"`
+	Sodium       *Amount `json:"sodium,omitempty" bson:"sodium,omitempty"`
+	TotalCarb    *Amount `json:"total_carb,omitempty" bson:"total_carb,omitempty"`
+	DietaryFiber *Amount `json:"dietary_fiber,omitempty" bson:"dietary_fiber,omitempty"`
+	Sugars       *Amount `json:"sugars,omitempty" bson:"sugars,omitempty"`
+	Protein      *Amount `json:"protein,omitempty" bson:"protein,omitempty"`
+}
+
+var amountPattern = regexp.MustCompile(`^\s*(\d+(?:\.\d+)?)\s*([A-Za-z%]*)`)
+
+// ParseAmount reads an upstream nutrition string such as "230", "4g" or
+// "120mg". HUDS leaves the unit off some values, so defaultUnit is used then.
+func ParseAmount(s string, defaultUnit string) *Amount {
+	match := amountPattern.FindStringSubmatch(s)
+	if match == nil {
+		return nil
+	}
+	value, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return nil
+	}
+	unit := strings.ToLower(match[2])
+	if unit == "" {
+		unit = defaultUnit
+	}
+	return &Amount{Value: value, Unit: unit}
+}
+
+// ParseNutrition parses an item's nutrition strings.
+func ParseNutrition(item CondensedMenuItem) *NutritionFacts {
+	return &NutritionFacts{
+		Calories:     ParseAmount(item.Calories, "kcal"),
+		TotalFat:     ParseAmount(item.TotalFat, "g"),
+		SatFat:       ParseAmount(item.SatFat, "g"),
+		TransFat:     ParseAmount(item.TransFat, "g"),
+		Cholesterol:  ParseAmount(item.Cholesterol, "mg"),
+		Sodium:       ParseAmount(item.Sodium, "mg"),
+		TotalCarb:    ParseAmount(item.TotalCarb, "g"),
+		DietaryFiber: ParseAmount(item.DietaryFiber, "g"),
+		Sugars:       ParseAmount(item.Sugars, "g"),
+		Protein:      ParseAmount(item.Protein, "g"),
+	}
+}
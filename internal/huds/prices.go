@@ -0,0 +1,25 @@
+package huds
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ParsePrice reads a portion_cost or selling_price, a dollar amount such as
+// "1.2500" or "$2.75". It is nil when there is none: HUDS sends an empty
+// string or zero for recipes it hasn't costed.
+func ParsePrice(s string) *float64 {
+	s = strings.NewReplacer("$", "", ",", "", " ", "").Replace(s)
+	if s == "" {
+		return nil
+	}
+	price, err := strconv.ParseFloat(s, 64)
+	if err != nil || price <= 0 || math.IsInf(price, 0) || math.IsNaN(price) {
+		return nil
+	}
+	// Costs are kept to a hundredth of a cent, as portions of cheap
+	// ingredients cost fractions of one
+	price = math.Round(price*10000) / 10000
+	return &price
+}
@@ -0,0 +1,111 @@
+package huds
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy retries a failing call with exponentially growing, fully
+// jittered delays, giving up after Attempts tries or once the next wait would
+// end past Window since the first try.
+type RetryPolicy struct {
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	Window    time.Duration
+}
+
+// DefaultRetryPolicy applies to the nightly HUDS fetch. It rides out a short
+// upstream outage while still finishing well before breakfast.
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:  6,
+	BaseDelay: 30 * time.Second,
+	MaxDelay:  15 * time.Minute,
+	Window:    2 * time.Hour,
+}
+
+// UpstreamStatusError is returned when the HUDS API answers with anything but
+// 200 OK.
+type UpstreamStatusError struct {
+	StatusCode int
+}
+
+func (e UpstreamStatusError) Error() string {
+	return fmt.Sprintf("HUDS API returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// LoadRetryPolicy overrides the default policy with HUDS_FETCH_ATTEMPTS and
+// the HUDS_FETCH_BASE_DELAY, HUDS_FETCH_MAX_DELAY and HUDS_FETCH_RETRY_WINDOW
+// durations (e.g. "30s").
+func LoadRetryPolicy() (RetryPolicy, error) {
+	policy := DefaultRetryPolicy
+	if s := os.Getenv("HUDS_FETCH_ATTEMPTS"); s != "" {
+		attempts, err := strconv.Atoi(s)
+		if err != nil || attempts < 1 {
+			return policy, fmt.Errorf("HUDS_FETCH_ATTEMPTS must be a positive integer, got %q", s)
+		}
+		policy.Attempts = attempts
+	}
+	for name, target := range map[string]*time.Duration{
+		"HUDS_FETCH_BASE_DELAY":   &policy.BaseDelay,
+		"HUDS_FETCH_MAX_DELAY":    &policy.MaxDelay,
+		"HUDS_FETCH_RETRY_WINDOW": &policy.Window,
+	} {
+		s := os.Getenv(name)
+		if s == "" {
+			continue
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return policy, fmt.Errorf("%s must be a positive duration, got %q", name, s)
+		}
+		*target = d
+	}
+	return policy, nil
+}
+
+// Do calls fn until it succeeds, returns an error that isn't worth retrying,
+// or the policy runs out.
+func (p RetryPolicy) Do(name string, fn func() error) error {
+	start := time.Now()
+	var err error
+	for attempt := 0; attempt < p.Attempts; attempt++ {
+		if err = fn(); err == nil || !retryable(err) {
+			return err
+		}
+		if attempt == p.Attempts-1 {
+			break
+		}
+		delay := p.backoff(attempt)
+		if time.Since(start)+delay > p.Window {
+			log.Printf("%s failed, retry window exhausted: %v\n", name, err)
+			return err
+		}
+		log.Printf("%s failed (attempt %d of %d), retrying in %s: %v\n", name, attempt+1, p.Attempts, delay.Round(time.Second), err)
+		time.Sleep(delay)
+	}
+	return err
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.MaxDelay
+	if attempt < 32 && p.BaseDelay<<attempt > 0 && p.BaseDelay<<attempt < ceiling {
+		ceiling = p.BaseDelay << attempt
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// Client errors other than rate limiting won't fix themselves
+func retryable(err error) bool {
+	var status UpstreamStatusError
+	if errors.As(err, &status) {
+		return status.StatusCode >= 500 || status.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
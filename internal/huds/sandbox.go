@@ -0,0 +1,34 @@
+package huds
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// SandboxHandler serves the fixtures in dir the way the HUDS API serves
+// recipes, for HUDS_CLIENT=sandbox to call. A query there is no fixture for
+// answers 404, as HUDS does for dates it has nothing for.
+func SandboxHandler(dir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		query := Query{Date: r.URL.Query().Get("date"), LocationID: r.URL.Query().Get("locationId")}
+		data, err := os.ReadFile(filepath.Join(dir, fixtureName(query)))
+		if os.IsNotExist(err) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "no fixture for this query"})
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+}
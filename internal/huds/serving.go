@@ -0,0 +1,148 @@
+package huds
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Serving is an item's Serving_Size parsed into its quantity and unit, with
+// how much it weighs or holds in metric when the unit says. Counted units
+// such as "each" or "slice" have neither.
+type Serving struct {
+	Quantity float64 `json:"quantity" bson:"quantity"`
+	// Unit is the upstream unit spelled one way, e.g. "oz" for "OZ" and
+	// "ounces", or "cup" for "Cups"
+	Unit        string   `json:"unit" bson:"unit"`
+	Grams       *float64 `json:"grams,omitempty" bson:"grams,omitempty"`
+	Milliliters *float64 `json:"ml,omitempty" bson:"ml,omitempty"`
+}
+
+type servingUnit struct {
+	name string
+	// grams or milliliters in one of the unit; both are zero for counts
+	grams       float64
+	milliliters float64
+}
+
+// servingUnits are the spellings of the units HUDS portions are given in.
+// Ounces are taken as weight, as portions are weighed on the line; liquids
+// are given in fluid ounces.
+var servingUnits = map[string]servingUnit{
+	"g":           {name: "g", grams: 1},
+	"gram":        {name: "g", grams: 1},
+	"grams":       {name: "g", grams: 1},
+	"kg":          {name: "kg", grams: 1000},
+	"oz":          {name: "oz", grams: 28.3495},
+	"ounce":       {name: "oz", grams: 28.3495},
+	"ounces":      {name: "oz", grams: 28.3495},
+	"lb":          {name: "lb", grams: 453.592},
+	"lbs":         {name: "lb", grams: 453.592},
+	"pound":       {name: "lb", grams: 453.592},
+	"pounds":      {name: "lb", grams: 453.592},
+	"ml":          {name: "ml", milliliters: 1},
+	"l":           {name: "l", milliliters: 1000},
+	"liter":       {name: "l", milliliters: 1000},
+	"fl oz":       {name: "fl oz", milliliters: 29.5735},
+	"floz":        {name: "fl oz", milliliters: 29.5735},
+	"fluid ounce": {name: "fl oz", milliliters: 29.5735},
+	"cup":         {name: "cup", milliliters: 236.588},
+	"cups":        {name: "cup", milliliters: 236.588},
+	"c":           {name: "cup", milliliters: 236.588},
+	"tbsp":        {name: "tbsp", milliliters: 14.7868},
+	"tablespoon":  {name: "tbsp", milliliters: 14.7868},
+	"tablespoons": {name: "tbsp", milliliters: 14.7868},
+	"tsp":         {name: "tsp", milliliters: 4.92892},
+	"teaspoon":    {name: "tsp", milliliters: 4.92892},
+	"teaspoons":   {name: "tsp", milliliters: 4.92892},
+	"pint":        {name: "pint", milliliters: 473.176},
+	"quart":       {name: "quart", milliliters: 946.353},
+	"each":        {name: "each"},
+	"ea":          {name: "each"},
+}
+
+// servingPattern matches a quantity, which may be a whole number, a decimal,
+// a fraction or a mixed number such as "1 1/2", followed by its unit.
+var servingPattern = regexp.MustCompile(`^\s*(\d+(?:\.\d+)?(?:\s+\d+/\d+)?|\d+/\d+|\.\d+)\s*([a-z][a-z .]*?)?\.?\s*$`)
+
+// servingWeightPattern splits off a size given in brackets after a count,
+// as in "1 each (4 oz)".
+var servingWeightPattern = regexp.MustCompile(`^(.*?)\s*\((.*)\)\s*$`)
+
+// ParseServing reads a Serving_Size such as "4 oz", "1 each" or "1/2 cup".
+// Units it doesn't know, like "slice" or "piece", are kept as counts, which
+// take their weight or volume from a size in brackets after them. It is nil
+// for sizes with no quantity to read.
+func ParseServing(s string) *Serving {
+	if match := servingWeightPattern.FindStringSubmatch(s); match != nil {
+		serving := parseServing(match[1])
+		size := parseServing(match[2])
+		if serving == nil {
+			return size
+		}
+		if size != nil && serving.Grams == nil && serving.Milliliters == nil {
+			serving.Grams, serving.Milliliters = size.Grams, size.Milliliters
+		}
+		return serving
+	}
+	return parseServing(s)
+}
+
+func parseServing(s string) *Serving {
+	match := servingPattern.FindStringSubmatch(strings.ToLower(s))
+	if match == nil {
+		return nil
+	}
+	quantity, ok := parseQuantity(match[1])
+	if !ok || quantity <= 0 {
+		return nil
+	}
+	unit := strings.Join(strings.Fields(strings.ReplaceAll(match[2], ".", " ")), " ")
+	known, ok := servingUnits[unit]
+	if !ok {
+		known, ok = servingUnits[strings.TrimSuffix(unit, "s")]
+	}
+	// Portioning tools go by what they hold, as in "6 oz ladle"
+	if words := strings.Fields(unit); !ok && len(words) > 1 {
+		known, ok = servingUnits[words[0]]
+	}
+	if !ok {
+		return &Serving{Quantity: quantity, Unit: unit}
+	}
+	serving := &Serving{Quantity: quantity, Unit: known.name}
+	if known.grams > 0 {
+		grams := math.Round(quantity*known.grams*10) / 10
+		serving.Grams = &grams
+	}
+	if known.milliliters > 0 {
+		milliliters := math.Round(quantity*known.milliliters*10) / 10
+		serving.Milliliters = &milliliters
+	}
+	return serving
+}
+
+// parseQuantity reads "2", "0.5", ".5", "1/2" or "1 1/2".
+func parseQuantity(s string) (float64, bool) {
+	var total float64
+	for _, part := range strings.Fields(s) {
+		if numerator, denominator, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.ParseFloat(numerator, 64)
+			if err != nil {
+				return 0, false
+			}
+			d, err := strconv.ParseFloat(denominator, 64)
+			if err != nil || d == 0 {
+				return 0, false
+			}
+			total += n / d
+			continue
+		}
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, false
+		}
+		total += value
+	}
+	return total, true
+}
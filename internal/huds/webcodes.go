@@ -0,0 +1,39 @@
+package huds
+
+import (
+	"strings"
+	"unicode"
+)
+
+// DietaryFlag is one of the HUDS web codes, by the name it's filtered by.
+type DietaryFlag struct {
+	Code string
+	Name string
+	has  func(item CondensedMenuItem) bool
+}
+
+// Has reports whether an item is marked with the flag. Vegan items count as
+// vegetarian whether or not HUDS marked them so.
+func (f DietaryFlag) Has(item CondensedMenuItem) bool {
+	return f.has(item)
+}
+
+// DietaryFlags are the web codes HUDS puts on recipes.
+var DietaryFlags = []DietaryFlag{
+	{"VGN", "vegan", func(item CondensedMenuItem) bool { return item.Vegan }},
+	{"VGT", "vegetarian", func(item CondensedMenuItem) bool { return item.Vegetarian || item.Vegan }},
+	{"HAL", "halal", func(item CondensedMenuItem) bool { return item.Halal }},
+	{"GF", "gluten_free", func(item CondensedMenuItem) bool { return item.GlutenFree }},
+	{"WGRN", "whole_grain", func(item CondensedMenuItem) bool { return item.WholeGrain }},
+	{"LOC", "local", func(item CondensedMenuItem) bool { return item.Local }},
+	{"SUS", "sustainable_seafood", func(item CondensedMenuItem) bool { return item.SustainableSeafood }},
+}
+
+// webCodes splits a Recipe_Web_Codes string, e.g. "VGT LOC", into its codes.
+func webCodes(s string) map[string]bool {
+	codes := make(map[string]bool)
+	for _, code := range strings.FieldsFunc(s, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		codes[strings.ToUpper(code)] = true
+	}
+	return codes
+}
@@ -0,0 +1,54 @@
+package provider
+
+import (
+	"context"
+	"hudsgry-api/internal/huds"
+	"time"
+)
+
+// HUDSName is Harvard University Dining Services' provider name.
+const HUDSName = "huds"
+
+func init() {
+	Register(HUDSName, func() (Provider, error) {
+		config, err := huds.LoadClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		return NewHUDS(config.NewClient()), nil
+	})
+}
+
+// HUDS fetches from the HUDS API, whose items are already in the normalized
+// shape.
+type HUDS struct {
+	Client huds.Client
+}
+
+func NewHUDS(client huds.Client) HUDS {
+	return HUDS{Client: client}
+}
+
+func (h HUDS) Name() string {
+	return HUDSName
+}
+
+// FetchMenus asks HUDS for a single date when the range is one day, and
+// otherwise fetches the whole feed and keeps the dates in range.
+func (h HUDS) FetchMenus(ctx context.Context, dates DateRange) ([]huds.MenuItem, error) {
+	query := huds.Query{}
+	if !dates.Start.IsZero() && dates.Start.Equal(dates.End) {
+		query.Date = dates.Start.Format(huds.ServeDateLayout)
+	}
+	items, err := h.Client.Fetch(ctx, query)
+	if err != nil || (dates.Start.IsZero() && dates.End.IsZero()) {
+		return items, err
+	}
+	var inRange []huds.MenuItem
+	for _, item := range items {
+		if date, err := time.Parse(huds.ServeDateLayout, item.ServeDate); err == nil && dates.Contains(date) {
+			inRange = append(inRange, item)
+		}
+	}
+	return inRange, nil
+}
@@ -0,0 +1,68 @@
+// Package provider puts each school's dining API behind one interface, so
+// menus from somewhere other than HUDS can be fetched and stored the same way.
+package provider
+
+import (
+	"context"
+	"fmt"
+	"hudsgry-api/internal/huds"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DateRange bounds the serve dates to fetch, inclusive. The zero value asks
+// for everything the provider has published.
+type DateRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Day is a range of a single serve date.
+func Day(date time.Time) DateRange {
+	return DateRange{Start: date, End: date}
+}
+
+// Contains reports whether a serve date falls in the range.
+func (r DateRange) Contains(date time.Time) bool {
+	return (r.Start.IsZero() || !date.Before(r.Start)) && (r.End.IsZero() || !date.After(r.End))
+}
+
+// Provider fetches one school's menus. Items are normalized to the HUDS item
+// shape, which is what the rest of the service converts and stores, with
+// Serve_Date as MM/DD/YYYY and Meal_Number 1, 2 and 3 for breakfast, lunch
+// and dinner.
+type Provider interface {
+	// Name tags everything stored from the provider, e.g. "huds".
+	Name() string
+	FetchMenus(ctx context.Context, dates DateRange) ([]huds.MenuItem, error)
+}
+
+// Factory builds a provider from the environment.
+type Factory func() (Provider, error)
+
+var factories = map[string]Factory{}
+
+// Register makes a provider selectable by name with DINING_PROVIDER.
+func Register(name string, factory Factory) {
+	factories[name] = factory
+}
+
+// Load builds the provider named by DINING_PROVIDER, HUDS by default.
+func Load() (Provider, error) {
+	name := os.Getenv("DINING_PROVIDER")
+	if name == "" {
+		name = HUDSName
+	}
+	factory, ok := factories[name]
+	if !ok {
+		names := make([]string, 0, len(factories))
+		for known := range factories {
+			names = append(names, known)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("DINING_PROVIDER must be one of %s, got %q", strings.Join(names, ", "), name)
+	}
+	return factory()
+}
@@ -0,0 +1,66 @@
+// Package reporting sends errors and panics somewhere people will see them:
+// Sentry when SENTRY_DSN is set, otherwise just the log.
+package reporting
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// Request is the HTTP request an event happened while serving.
+type Request struct {
+	Method    string
+	URL       string
+	Route     string
+	RequestID string
+	ClientIP  string
+	UserAgent string
+}
+
+// Event is one error or panic to report.
+type Event struct {
+	// Level is "error", or "fatal" for a panic
+	Level   string
+	Message string
+	// Stack is the goroutine's stack trace, for panics
+	Stack   string
+	Request *Request
+	// Tags are short searchable labels such as the job name
+	Tags map[string]string
+	Time time.Time
+}
+
+// Reporter delivers events. Report must not block the caller for long.
+type Reporter interface {
+	Report(event Event)
+}
+
+// LogReporter writes events to the log, and is used when nothing else is
+// configured.
+type LogReporter struct{}
+
+func (LogReporter) Report(event Event) {
+	context := ""
+	if event.Request != nil {
+		context = fmt.Sprintf(" (%s %s, request %s)", event.Request.Method, event.Request.URL, event.Request.RequestID)
+	}
+	for name, value := range event.Tags {
+		context += fmt.Sprintf(" %s=%s", name, value)
+	}
+	log.Printf("[%s] %s%s\n", event.Level, event.Message, context)
+	if event.Stack != "" {
+		log.Print(event.Stack)
+	}
+}
+
+// Load returns a Sentry reporter when SENTRY_DSN is set, or a LogReporter
+// otherwise. SENTRY_ENVIRONMENT tags every event, e.g. "production".
+func Load() (Reporter, error) {
+	dsn := os.Getenv("SENTRY_DSN")
+	if dsn == "" {
+		return LogReporter{}, nil
+	}
+	return NewSentry(dsn, os.Getenv("SENTRY_ENVIRONMENT"))
+}
@@ -0,0 +1,134 @@
+package reporting
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Sentry posts events to a Sentry project's store endpoint. Events are sent in
+// the background, so a slow or unreachable Sentry never holds up a request;
+// failures are logged and the event is dropped.
+type Sentry struct {
+	endpoint    string
+	auth        string
+	environment string
+	serverName  string
+	httpClient  *http.Client
+}
+
+// NewSentry parses a DSN of the form https://<key>@<host>/<project id>.
+func NewSentry(dsn string, environment string) (*Sentry, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil || u.User.Username() == "" || u.Host == "" {
+		return nil, fmt.Errorf("SENTRY_DSN must look like https://<key>@<host>/<project>, got %q", dsn)
+	}
+	project := strings.Trim(u.Path, "/")
+	if project == "" {
+		return nil, fmt.Errorf("SENTRY_DSN is missing the project ID: %q", dsn)
+	}
+	// Self-hosted Sentry may live under a path prefix
+	prefix := ""
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		prefix, project = "/"+project[:i], project[i+1:]
+	}
+	hostname, _ := os.Hostname()
+	return &Sentry{
+		endpoint:    fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, prefix, project),
+		auth:        fmt.Sprintf("Sentry sentry_version=7, sentry_client=hudsgry-api/1.0, sentry_key=%s", u.User.Username()),
+		environment: environment,
+		serverName:  hostname,
+		httpClient:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Logger      string            `json:"logger"`
+	Platform    string            `json:"platform"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Message     string            `json:"message"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Extra       map[string]string `json:"extra,omitempty"`
+	Request     *sentryRequest    `json:"request,omitempty"`
+}
+
+type sentryRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+}
+
+func (s *Sentry) Report(event Event) {
+	id := make([]byte, 16)
+	rand.Read(id)
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	payload := sentryEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   event.Time.UTC().Format("2006-01-02T15:04:05"),
+		Level:       event.Level,
+		Logger:      "hudsgry-api",
+		Platform:    "go",
+		ServerName:  s.serverName,
+		Environment: s.environment,
+		Message:     event.Message,
+		Tags:        map[string]string{},
+	}
+	for name, value := range event.Tags {
+		payload.Tags[name] = value
+	}
+	if event.Stack != "" {
+		payload.Extra = map[string]string{"stack": event.Stack}
+	}
+	if r := event.Request; r != nil {
+		payload.Request = &sentryRequest{
+			Method:  r.Method,
+			URL:     r.URL,
+			Headers: map[string]string{"User-Agent": r.UserAgent},
+			Env:     map[string]string{"REMOTE_ADDR": r.ClientIP},
+		}
+		payload.Tags["request_id"] = r.RequestID
+		if r.Route != "" {
+			payload.Tags["route"] = r.Route
+		}
+	}
+	go s.send(payload)
+}
+
+func (s *Sentry) send(event sentryEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode Sentry event: %v\n", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Failed to build Sentry request: %v\n", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", s.auth)
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		log.Printf("Failed to send event to Sentry: %v\n", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Sentry rejected event %s: %s\n", event.EventID, resp.Status)
+	}
+}
@@ -0,0 +1,96 @@
+package scheduler
+
+import (
+	"fmt"
+	"hudsgry-api/internal/huds"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	FetchOnStartEmpty  = "empty"
+	FetchOnStartAlways = "always"
+	FetchOnStartNever  = "never"
+)
+
+// RefreshConfig controls when menus are fetched from HUDS. It is read from
+// the environment (or .env):
+//
+//	REFRESH_SCHEDULE           cron expressions separated by ";", default "0 3 * * *"
+//	INTRADAY_REFRESH_SCHEDULE  change detection runs, default "0 12 * * *", "off" to disable
+//	REFRESH_TIMEZONE           IANA zone name the schedules run in
+//	FETCH_ON_START             empty (only if nothing is stored), always or never
+type RefreshConfig struct {
+	Schedules         []string
+	IntradaySchedules []string
+	Location          *time.Location
+	FetchOnStart      string
+}
+
+func LoadRefreshConfig() (RefreshConfig, error) {
+	config := RefreshConfig{
+		Schedules:         []string{"0 3 * * *"},
+		IntradaySchedules: []string{"0 12 * * *"},
+		Location:          huds.DiningZone,
+		FetchOnStart:      FetchOnStartEmpty,
+	}
+	if s := os.Getenv("REFRESH_SCHEDULE"); s != "" {
+		schedules := splitSchedules(s)
+		if len(schedules) == 0 {
+			return config, fmt.Errorf("REFRESH_SCHEDULE must contain at least one cron expression")
+		}
+		config.Schedules = schedules
+	}
+	if s := os.Getenv("INTRADAY_REFRESH_SCHEDULE"); s == "off" {
+		config.IntradaySchedules = nil
+	} else if s != "" {
+		config.IntradaySchedules = splitSchedules(s)
+	}
+	if s := os.Getenv("REFRESH_TIMEZONE"); s != "" {
+		location, err := time.LoadLocation(s)
+		if err != nil {
+			return config, fmt.Errorf("REFRESH_TIMEZONE is not a known time zone: %v", err)
+		}
+		config.Location = location
+	}
+	if s := os.Getenv("FETCH_ON_START"); s != "" {
+		if s != FetchOnStartEmpty && s != FetchOnStartAlways && s != FetchOnStartNever {
+			return config, fmt.Errorf("FETCH_ON_START must be %q, %q or %q, got %q", FetchOnStartEmpty, FetchOnStartAlways, FetchOnStartNever, s)
+		}
+		config.FetchOnStart = s
+	}
+	return config, nil
+}
+
+// Spec pins a refresh schedule to the refresh time zone, so that it keeps to
+// it on a scheduler created for another, e.g. after the zone is reloaded.
+// Schedules that name their own zone are left alone.
+func (r RefreshConfig) Spec(schedule string) string {
+	if strings.HasPrefix(schedule, "CRON_TZ=") || strings.HasPrefix(schedule, "TZ=") {
+		return schedule
+	}
+	return "CRON_TZ=" + r.Location.String() + " " + schedule
+}
+
+func splitSchedules(s string) []string {
+	var schedules []string
+	for _, spec := range strings.Split(s, ";") {
+		if spec = strings.TrimSpace(spec); spec != "" {
+			schedules = append(schedules, spec)
+		}
+	}
+	return schedules
+}
+
+// ShouldFetchOnStart applies the startup policy given whether anything is
+// already stored.
+func (r RefreshConfig) ShouldFetchOnStart(empty bool) bool {
+	switch r.FetchOnStart {
+	case FetchOnStartAlways:
+		return true
+	case FetchOnStartNever:
+		return false
+	}
+	return empty
+}
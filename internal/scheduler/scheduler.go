@@ -0,0 +1,149 @@
+// Package scheduler runs the refresh jobs off the service clock.
+package scheduler
+
+import (
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Clock is the single source of "now" for everything time-dependent: cache
+// keys, "today", scheduled jobs and the /now endpoint. Swapping it for a
+// simulated clock lets the whole daily lifecycle run on a fake timeline.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the real wall clock.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SimulatedClock starts at a chosen instant and advances Speed simulated
+// seconds per real second. It can also be moved manually.
+type SimulatedClock struct {
+	mu     sync.Mutex
+	origin time.Time
+	start  time.Time
+	speed  float64
+}
+
+func NewSimulatedClock(start time.Time, speed float64) *SimulatedClock {
+	return &SimulatedClock{origin: start, start: time.Now(), speed: speed}
+}
+
+func (s *SimulatedClock) Now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elapsed := time.Duration(float64(time.Since(s.start)) * s.speed)
+	return s.origin.Add(elapsed)
+}
+
+// Set jumps the clock to t; it keeps running at the same speed from there.
+func (s *SimulatedClock) Set(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.origin, s.start = t, time.Now()
+}
+
+// LoadClock returns a simulated clock when SIMULATED_TIME (RFC 3339) is set,
+// or the system clock otherwise. SIMULATED_TIME_SPEED controls how fast a
+// simulated clock runs (default real time).
+func LoadClock() (Clock, error) {
+	start := os.Getenv("SIMULATED_TIME")
+	if start == "" {
+		return SystemClock{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, start)
+	if err != nil {
+		return nil, fmt.Errorf("SIMULATED_TIME must be RFC 3339: %v", err)
+	}
+	speed := 1.0
+	if s := os.Getenv("SIMULATED_TIME_SPEED"); s != "" {
+		speed, err = strconv.ParseFloat(s, 64)
+		if err != nil || speed <= 0 {
+			return nil, fmt.Errorf("SIMULATED_TIME_SPEED must be a positive number, got %q", s)
+		}
+	}
+	log.Printf("Running on simulated time starting at %s (x%g)\n", t.Format(time.RFC3339), speed)
+	return NewSimulatedClock(t, speed), nil
+}
+
+// Scheduler is the subset of *cron.Cron the service uses, so jobs can run off
+// the service clock in simulated mode.
+type Scheduler interface {
+	AddFunc(spec string, cmd func()) (cron.EntryID, error)
+	Remove(id cron.EntryID)
+	Start()
+}
+
+// New returns a regular cron scheduler on the real clock, or a clock-driven
+// one when time is simulated.
+func New(clock Clock, location *time.Location) Scheduler {
+	if _, simulated := clock.(*SimulatedClock); simulated {
+		return &clockScheduler{clock: clock, location: location}
+	}
+	return cron.New(cron.WithLocation(location))
+}
+
+type clockJob struct {
+	schedule cron.Schedule
+	next     time.Time
+	cmd      func()
+}
+
+// clockScheduler polls the service clock and runs standard cron specs against
+// it. It is only precise to the polling interval, which is plenty for tests.
+type clockScheduler struct {
+	mu       sync.Mutex
+	clock    Clock
+	location *time.Location
+	jobs     []*clockJob
+}
+
+func (s *clockScheduler) AddFunc(spec string, cmd func()) (cron.EntryID, error) {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs = append(s.jobs, &clockJob{schedule: schedule, next: schedule.Next(s.clock.Now().In(s.location)), cmd: cmd})
+	return cron.EntryID(len(s.jobs)), nil
+}
+
+// Remove unschedules a job. IDs are never reused, so the slot is just emptied.
+func (s *clockScheduler) Remove(id cron.EntryID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i := int(id) - 1; i >= 0 && i < len(s.jobs) {
+		s.jobs[i] = nil
+	}
+}
+
+func (s *clockScheduler) Start() {
+	go func() {
+		for range time.Tick(100 * time.Millisecond) {
+			s.runDue()
+		}
+	}()
+}
+
+func (s *clockScheduler) runDue() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now().In(s.location)
+	for _, job := range s.jobs {
+		if job == nil || now.Before(job.next) {
+			continue
+		}
+		go job.cmd()
+		job.next = job.schedule.Next(now)
+	}
+}
@@ -0,0 +1,41 @@
+// Package site writes a static copy of the menu archive to a directory or an
+// S3 bucket, for hosting on a CDN or GitHub Pages.
+package site
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Target is where the files of a static site are written. Paths are
+// slash-separated and relative to the site root.
+type Target interface {
+	Write(ctx context.Context, path string, contentType string, data []byte) error
+}
+
+// Open picks a target from where: s3://bucket/prefix for S3, anything else
+// is a local directory.
+func Open(where string) (Target, error) {
+	if strings.HasPrefix(where, "s3://") {
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(where, "s3://"), "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("S3 targets must look like s3://<bucket>/<prefix>, got %q", where)
+		}
+		return LoadS3(bucket, prefix)
+	}
+	return Dir(where), nil
+}
+
+// Dir writes the site into a local directory, creating it as needed.
+type Dir string
+
+func (d Dir) Write(ctx context.Context, path string, contentType string, data []byte) error {
+	name := filepath.Join(string(d), filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(name, data, 0o644)
+}
@@ -0,0 +1,168 @@
+package store
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hudsgry-api/internal/huds"
+	"io"
+	"time"
+)
+
+// The backup format is a JSON object, gzipped or not:
+//
+//	{
+//	  "format": "hudsgry-backup",
+//	  "version": 1,
+//	  "exported_at": "2023-05-05T04:00:00Z",
+//	  "days": [
+//	    {
+//	      "serve_date": "05/05/2023",
+//	      "menu": {"breakfast": [...], "lunch": [...], "dinner": [...],
+//	               "extra": [{"number": 4, "key": "brain_break", "items": [...]}],
+//	               "updated_at": "...", "provider": "huds",
+//	               "annenberg": {"name": "Annenberg Hall", "breakfast": [...], ...}},
+//	      "locations": {"annenberg-hall": {"name": "Annenberg Hall", "breakfast": [...], ...}}
+//	    }
+//	  ]
+//	}
+//
+// Days are in chronological order, and items are encoded as the API serves
+// them. Only the menus are backed up, not the raw feed archive, tags,
+// overrides or hours.
+const (
+	BackupFormat  = "hudsgry-backup"
+	BackupVersion = 1
+)
+
+// Backup is every stored day's menus, for moving between storage backends and
+// restoring after a loss.
+type Backup struct {
+	Format     string      `json:"format"`
+	Version    int         `json:"version"`
+	ExportedAt time.Time   `json:"exported_at"`
+	Days       []BackupDay `json:"days"`
+}
+
+// BackupDay is a serve date's house default menu and every location's menu,
+// keyed by location key, encoded as the SQL stores encode them.
+type BackupDay struct {
+	ServeDate string               `json:"serve_date"`
+	Menu      *sqlMeals            `json:"menu"`
+	Locations map[string]*sqlMeals `json:"locations,omitempty"`
+}
+
+// WriteBackup writes every stored day to w, one day at a time, and returns
+// how many were written.
+func WriteBackup(ctx context.Context, s MenuStore, w io.Writer, exportedAt time.Time) (int, error) {
+	earliest, latest, err := s.EarliestLatest(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var menus []huds.CondensedMenu
+	if earliest != "" {
+		if menus, err = s.GetRange(ctx, earliest, latest); err != nil {
+			return 0, err
+		}
+	}
+
+	header, err := json.Marshal(struct {
+		Format     string    `json:"format"`
+		Version    int       `json:"version"`
+		ExportedAt time.Time `json:"exported_at"`
+	}{BackupFormat, BackupVersion, exportedAt.UTC()})
+	if err != nil {
+		return 0, err
+	}
+	// The header is written without its closing brace so days can follow
+	if _, err := fmt.Fprintf(w, "%s,\"days\":[\n", header[:len(header)-1]); err != nil {
+		return 0, err
+	}
+	for i, menu := range menus {
+		locations, err := s.GetLocations(ctx, menu.ServeDate)
+		if err != nil {
+			return i, err
+		}
+		day := BackupDay{ServeDate: menu.ServeDate, Menu: sqlMealsOf(menu)}
+		if len(locations) > 0 {
+			day.Locations = make(map[string]*sqlMeals, len(locations))
+			for key, location := range locations {
+				meals := sqlMealsOf(location.Menu(menu.ServeDate))
+				meals.Name = location.Name
+				day.Locations[key] = meals
+			}
+		}
+		data, err := json.Marshal(day)
+		if err != nil {
+			return i, err
+		}
+		if i > 0 {
+			if _, err := io.WriteString(w, ",\n"); err != nil {
+				return i, err
+			}
+		}
+		if _, err := w.Write(data); err != nil {
+			return i, err
+		}
+	}
+	if _, err := io.WriteString(w, "\n]}\n"); err != nil {
+		return len(menus), err
+	}
+	return len(menus), nil
+}
+
+// ReadBackup reads a backup, gunzipping it first if it is gzipped, and checks
+// it is one this version can restore.
+func ReadBackup(r io.Reader) (*Backup, error) {
+	buffered := bufio.NewReader(r)
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = buffered
+	}
+
+	var backup Backup
+	if err := json.NewDecoder(r).Decode(&backup); err != nil {
+		return nil, fmt.Errorf("invalid backup: %w", err)
+	}
+	if backup.Format != BackupFormat {
+		return nil, fmt.Errorf("not a %s file", BackupFormat)
+	}
+	if backup.Version < 1 || backup.Version > BackupVersion {
+		return nil, fmt.Errorf("backup version %d isn't supported; this build reads up to version %d", backup.Version, BackupVersion)
+	}
+	for _, day := range backup.Days {
+		if _, err := time.Parse(huds.ServeDateLayout, day.ServeDate); err != nil {
+			return nil, fmt.Errorf("invalid serve date %q in backup", day.ServeDate)
+		}
+		if day.Menu == nil {
+			return nil, fmt.Errorf("no menu for %s in backup", day.ServeDate)
+		}
+	}
+	return &backup, nil
+}
+
+// Menus decodes the backup's days into menus and location menus, as Upsert
+// and UpsertLocations take them.
+func (b *Backup) Menus() ([]huds.CondensedMenu, map[string]map[string]huds.LocationMenu) {
+	menus := make([]huds.CondensedMenu, 0, len(b.Days))
+	locations := make(map[string]map[string]huds.LocationMenu)
+	for _, day := range b.Days {
+		menus = append(menus, day.Menu.menu(day.ServeDate))
+		if len(day.Locations) == 0 {
+			continue
+		}
+		locations[day.ServeDate] = make(map[string]huds.LocationMenu, len(day.Locations))
+		for key, meals := range day.Locations {
+			locations[day.ServeDate][key] = huds.LocationMenuOf(meals.Name, meals.menu(day.ServeDate))
+		}
+	}
+	return menus, locations
+}
@@ -0,0 +1,28 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Counter is implemented by stores that can report how much they hold, keyed
+// by collection or table name.
+type Counter interface {
+	Counts(ctx context.Context) (map[string]int64, error)
+}
+
+// sqlTables are the tables both SQL stores create.
+var sqlTables = []string{"menus", "location_menus", "served_items", "menu_revisions", "menu_events", "raw_menus", "weeks", "recipe_tags", "overrides", "hours"}
+
+func countTables(ctx context.Context, db *sql.DB) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	for _, table := range sqlTables {
+		var count int64
+		if err := db.QueryRowContext(ctx, "SELECT count(*) FROM "+table).Scan(&count); err != nil {
+			return nil, fmt.Errorf("failed to count %s: %v", table, err)
+		}
+		counts[table] = count
+	}
+	return counts, nil
+}
@@ -0,0 +1,28 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// MenuEvent records a meal on a serve date being published, updated or
+// removed when fetched data was written. IDs increase in the order events
+// were recorded, so pollers can ask for everything after the last one seen.
+type MenuEvent struct {
+	ID        int64     `json:"id" bson:"_id"`
+	Type      string    `json:"type" bson:"type"`
+	ServeDate string    `json:"Serve_Date" bson:"serve_date"`
+	Meal      string    `json:"meal" bson:"meal"`
+	Added     []string  `json:"added" bson:"added"`
+	Removed   []string  `json:"removed" bson:"removed"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// EventLog is implemented by stores that keep the menu event log.
+type EventLog interface {
+	// AppendEvents assigns the events the next IDs, in order, and stores them.
+	AppendEvents(ctx context.Context, events []MenuEvent) error
+	// EventsAfter returns up to limit events with IDs after the given one,
+	// oldest first.
+	EventsAfter(ctx context.Context, after int64, limit int) ([]MenuEvent, error)
+}
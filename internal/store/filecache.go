@@ -0,0 +1,202 @@
+package store
+
+import (
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"hudsgry-api/internal/huds"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultFileCacheDays = 14
+
+// FileCache keeps the most recent days of menus in a local file, so that they
+// can still be served, a little stale, while the database is unreachable. It
+// is rewritten after every refresh. The file holds the menus as BSON, the way
+// Mongo stores them, so nothing is lost on the way through.
+type FileCache struct {
+	path string
+	days int
+
+	mu    sync.RWMutex
+	menus map[string]huds.CondensedMenu
+	saved time.Time
+}
+
+type fileCacheContents struct {
+	SavedAt time.Time            `bson:"saved_at"`
+	Menus   []huds.CondensedMenu `bson:"menus"`
+}
+
+// LoadFileCache reads MENU_CACHE_FILE and MENU_CACHE_DAYS, which defaults to
+// 14. It returns nil when no file is set. A file left by an earlier run is
+// read, so the cache can serve before the first refresh.
+func LoadFileCache() (*FileCache, error) {
+	path := os.Getenv("MENU_CACHE_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	days := defaultFileCacheDays
+	if s := os.Getenv("MENU_CACHE_DAYS"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("MENU_CACHE_DAYS must be a positive number, got %q", s)
+		}
+		days = n
+	}
+
+	cache := &FileCache{path: path, days: days, menus: make(map[string]huds.CondensedMenu)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var contents fileCacheContents
+	if err := bson.Unmarshal(data, &contents); err != nil {
+		return nil, fmt.Errorf("failed to read menu cache %s: %v", path, err)
+	}
+	cache.saved = contents.SavedAt
+	for _, menu := range contents.Menus {
+		cache.menus[menu.ServeDate] = menu
+	}
+	return cache, nil
+}
+
+// Days is how many of the latest days the cache keeps.
+func (c *FileCache) Days() int {
+	return c.days
+}
+
+// SavedAt is when the cache was last written.
+func (c *FileCache) SavedAt() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.saved
+}
+
+// Save replaces the cached menus and rewrites the file.
+func (c *FileCache) Save(menus []huds.CondensedMenu) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.write(menus, time.Now())
+}
+
+// Delete drops a day from the cache and rewrites the file, reporting whether
+// the day was cached.
+func (c *FileCache) Delete(date string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.menus[date]; !ok {
+		return false, nil
+	}
+	menus := make([]huds.CondensedMenu, 0, len(c.menus)-1)
+	for cached, menu := range c.menus {
+		if cached != date {
+			menus = append(menus, menu)
+		}
+	}
+	return true, c.write(menus, c.saved)
+}
+
+// Clear empties the cache and the file.
+func (c *FileCache) Clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.write(nil, time.Time{})
+}
+
+// write replaces the file with menus, saved at savedAt. It is written to a
+// temporary file first, so a crash never leaves half a cache. The caller
+// holds the lock.
+func (c *FileCache) write(menus []huds.CondensedMenu, savedAt time.Time) error {
+	contents := fileCacheContents{SavedAt: savedAt, Menus: menus}
+	data, err := bson.Marshal(contents)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return err
+	}
+
+	c.saved = contents.SavedAt
+	c.menus = make(map[string]huds.CondensedMenu, len(menus))
+	for _, menu := range menus {
+		c.menus[menu.ServeDate] = menu
+	}
+	return nil
+}
+
+// Menus returns every cached menu, in chronological order.
+func (c *FileCache) Menus() []huds.CondensedMenu {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	menus := make([]huds.CondensedMenu, 0, len(c.menus))
+	for _, menu := range c.menus {
+		menus = append(menus, menu)
+	}
+	sortMenus(menus)
+	return menus
+}
+
+// GetByDate returns the cached menu for a serve date, or ErrMenuNotFound.
+func (c *FileCache) GetByDate(date string) (huds.CondensedMenu, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	menu, ok := c.menus[date]
+	if !ok {
+		return huds.CondensedMenu{}, ErrMenuNotFound
+	}
+	return menu, nil
+}
+
+// GetRange returns the cached menus from start to end inclusive, in
+// chronological order.
+func (c *FileCache) GetRange(start string, end string) ([]huds.CondensedMenu, error) {
+	startTime, err := time.Parse(huds.ServeDateLayout, start)
+	if err != nil {
+		return nil, fmt.Errorf("invalid serve date %q: %v", start, err)
+	}
+	endTime, err := time.Parse(huds.ServeDateLayout, end)
+	if err != nil {
+		return nil, fmt.Errorf("invalid serve date %q: %v", end, err)
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var menus []huds.CondensedMenu
+	for date, menu := range c.menus {
+		day, err := time.Parse(huds.ServeDateLayout, date)
+		if err != nil || day.Before(startTime) || day.After(endTime) {
+			continue
+		}
+		menus = append(menus, menu)
+	}
+	sortMenus(menus)
+	return menus, nil
+}
+
+func sortMenus(menus []huds.CondensedMenu) {
+	sort.Slice(menus, func(i, j int) bool {
+		a, _ := time.Parse(huds.ServeDateLayout, menus[i].ServeDate)
+		b, _ := time.Parse(huds.ServeDateLayout, menus[j].ServeDate)
+		return a.Before(b)
+	})
+}
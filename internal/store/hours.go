@@ -0,0 +1,71 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ServiceWindow is when a meal is served, as times of day such as "07:30" in
+// the dining halls' time zone.
+type ServiceWindow struct {
+	Meal   string `json:"meal" bson:"meal"`
+	Opens  string `json:"opens" bson:"opens"`
+	Closes string `json:"closes" bson:"closes"`
+}
+
+// HoursSchedule is an entry in the admin-maintained dining hours. It applies
+// on its Weekdays, every day if there are none, from From until Until, which
+// are serve dates and either may be open-ended. Where several apply, the one
+// with dates beats the one without, e.g. interim hours over term hours, then
+// the one with weekdays, then the most recently updated.
+type HoursSchedule struct {
+	ID string `json:"id" bson:"_id"`
+	// Name says what the hours are, e.g. "Thanksgiving break"
+	Name     string   `json:"name" bson:"name"`
+	Weekdays []string `json:"weekdays,omitempty" bson:"weekdays,omitempty"`
+	From     string   `json:"from,omitempty" bson:"from,omitempty"`
+	Until    string   `json:"until,omitempty" bson:"until,omitempty"`
+	// Closed closes the dining halls entirely; Windows is empty then
+	Closed    bool            `json:"closed" bson:"closed"`
+	Windows   []ServiceWindow `json:"windows" bson:"windows"`
+	Note      string          `json:"note,omitempty" bson:"note,omitempty"`
+	UpdatedAt time.Time       `json:"updated_at" bson:"updated_at"`
+}
+
+// HoursStore is implemented by stores that keep dining hours.
+type HoursStore interface {
+	// ListHours returns every schedule, ordered by ID.
+	ListHours(ctx context.Context) ([]HoursSchedule, error)
+	// PutHours replaces the schedule with the same ID.
+	PutHours(ctx context.Context, schedule HoursSchedule) error
+	// DeleteHours removes a schedule, or returns ErrMenuNotFound if there is
+	// none with the ID.
+	DeleteHours(ctx context.Context, id string) error
+}
+
+// listSQLHours reads the hours table both SQL stores keep, with each
+// schedule encoded as JSON.
+func listSQLHours(ctx context.Context, db *sql.DB) ([]HoursSchedule, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id, schedule FROM hours ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	schedules := []HoursSchedule{}
+	for rows.Next() {
+		var id string
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, err
+		}
+		var schedule HoursSchedule
+		if err := json.Unmarshal(data, &schedule); err != nil {
+			return nil, fmt.Errorf("failed to decode hours %s: %v", id, err)
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules, rows.Err()
+}
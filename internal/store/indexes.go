@@ -0,0 +1,116 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+)
+
+// IndexSpec is an index a collection needs to serve its queries.
+type IndexSpec struct {
+	Collection string
+	Model      mongo.IndexModel
+}
+
+// Name is the index's name: the one it was given, or the name Mongo derives
+// from its keys, such as "date_1_meal_1".
+func (spec IndexSpec) Name() string {
+	if spec.Model.Options != nil && spec.Model.Options.Name != nil {
+		return *spec.Model.Options.Name
+	}
+	keys, _ := spec.Model.Keys.(bson.D)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%s_%v", key.Key, key.Value))
+	}
+	return strings.Join(parts, "_")
+}
+
+// menuIndexes are the indexes the menu store's queries rely on. Menus and
+// location menus need none of their own: buckets are looked up by _id, which
+// is unique per month and so per serve date.
+var menuIndexes = []IndexSpec{
+	{"served_items", mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "food_name", Value: "text"},
+			{Key: "menu_category", Value: "text"},
+			{Key: "ingredients", Value: "text"},
+		},
+		Options: options.Index().SetName("search").SetWeights(bson.D{
+			{Key: "food_name", Value: 10},
+			{Key: "menu_category", Value: 3},
+			{Key: "ingredients", Value: 1},
+		}),
+	}},
+	{"served_items", mongo.IndexModel{Keys: bson.D{{Key: "item_id", Value: 1}}}},
+	{"served_items", mongo.IndexModel{
+		Keys:    bson.D{{Key: "food_name", Value: 1}, {Key: "date", Value: -1}},
+		Options: options.Index().SetCollation(foodNameCollation),
+	}},
+	{"served_items", mongo.IndexModel{Keys: bson.D{{Key: "date", Value: 1}}}},
+	// Analytics group served items by meal and look categories up by date
+	{"served_items", mongo.IndexModel{Keys: bson.D{{Key: "date", Value: 1}, {Key: "meal", Value: 1}}}},
+	{"served_items", mongo.IndexModel{
+		Keys:    bson.D{{Key: "menu_category", Value: 1}, {Key: "date", Value: -1}},
+		Options: options.Index().SetCollation(foodNameCollation),
+	}},
+	{"raw", mongo.IndexModel{Keys: bson.D{{Key: "date", Value: 1}}}},
+	{"revisions", mongo.IndexModel{
+		Keys:    bson.D{{Key: "serve_date", Value: 1}, {Key: "revision", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}},
+	// Expired leases are only kept around until Mongo's TTL monitor gets to them
+	{"locks", mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}},
+}
+
+// EnsureIndexes creates the indexes in specs that are missing from db, unless
+// create is false, and then checks that every one of them exists. Creating an
+// index that is already there is a no-op, so this is safe on every startup;
+// skipping creation suits deployments whose indexes are managed by hand.
+func EnsureIndexes(ctx context.Context, db *mongo.Database, specs []IndexSpec, create bool) error {
+	if create {
+		models := map[string][]mongo.IndexModel{}
+		var order []string
+		for _, spec := range specs {
+			if _, ok := models[spec.Collection]; !ok {
+				order = append(order, spec.Collection)
+			}
+			models[spec.Collection] = append(models[spec.Collection], spec.Model)
+		}
+		for _, collection := range order {
+			if _, err := db.Collection(collection).Indexes().CreateMany(ctx, models[collection]); err != nil {
+				return fmt.Errorf("failed to create %s indexes: %v", collection, err)
+			}
+		}
+	}
+
+	existing := map[string]map[string]bool{}
+	var missing []string
+	for _, spec := range specs {
+		names, ok := existing[spec.Collection]
+		if !ok {
+			indexes, err := db.Collection(spec.Collection).Indexes().ListSpecifications(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to list %s indexes: %v", spec.Collection, err)
+			}
+			names = map[string]bool{}
+			for _, index := range indexes {
+				names[index.Name] = true
+			}
+			existing[spec.Collection] = names
+		}
+		if !names[spec.Name()] {
+			missing = append(missing, spec.Collection+"."+spec.Name())
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing indexes: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// JobLocker is implemented by stores that can hand out leases shared by every
+// replica using them, so a scheduled job runs on exactly one of them.
+type JobLocker interface {
+	// AcquireLock takes the named lease for owner until ttl has passed. It
+	// reports false without error when another owner holds it.
+	AcquireLock(ctx context.Context, name string, owner string, ttl time.Duration) (bool, error)
+}
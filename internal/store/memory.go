@@ -0,0 +1,626 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"hudsgry-api/internal/huds"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryMenuStore keeps menus in maps for demos, CI and integration tests.
+// Nothing survives a restart; seed it from a fixture with --fixture.
+type MemoryMenuStore struct {
+	mu        sync.RWMutex
+	menus     map[string]huds.CondensedMenu
+	items     map[string][]ServedItem
+	locations map[string]map[string]huds.LocationMenu
+	raw       map[string][]huds.MenuItem
+	revisions map[string][]MenuRevision
+	events    []MenuEvent
+	weeks     map[string]Week
+	tags      map[string]RecipeTags
+	overrides map[string]Override
+	hours     map[string]HoursSchedule
+	locks     map[string]jobLock
+}
+
+type jobLock struct {
+	owner     string
+	expiresAt time.Time
+}
+
+func NewMemoryMenuStore() *MemoryMenuStore {
+	return &MemoryMenuStore{
+		menus:     make(map[string]huds.CondensedMenu),
+		items:     make(map[string][]ServedItem),
+		locations: make(map[string]map[string]huds.LocationMenu),
+		raw:       make(map[string][]huds.MenuItem),
+		revisions: make(map[string][]MenuRevision),
+		weeks:     make(map[string]Week),
+		tags:      make(map[string]RecipeTags),
+		overrides: make(map[string]Override),
+		hours:     make(map[string]HoursSchedule),
+		locks:     make(map[string]jobLock),
+	}
+}
+
+// servedItemsInRange returns every served item from start to end inclusive,
+// oldest first. Zero times leave that end unbounded.
+func (s *MemoryMenuStore) servedItemsInRange(start time.Time, end time.Time) []ServedItem {
+	var served []ServedItem
+	for _, items := range s.items {
+		for _, item := range items {
+			if (!start.IsZero() && item.Date.Before(start)) || (!end.IsZero() && item.Date.After(end)) {
+				continue
+			}
+			served = append(served, item)
+		}
+	}
+	sort.SliceStable(served, func(i, j int) bool { return served[i].Date.Before(served[j].Date) })
+	return served
+}
+
+func (s *MemoryMenuStore) GetByDate(ctx context.Context, date string) (huds.CondensedMenu, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	menu, exists := s.menus[date]
+	if !exists {
+		return huds.CondensedMenu{}, ErrMenuNotFound
+	}
+	return menu, nil
+}
+
+func (s *MemoryMenuStore) GetRange(ctx context.Context, start string, end string) ([]huds.CondensedMenu, error) {
+	startTime, err := time.Parse(huds.ServeDateLayout, start)
+	if err != nil {
+		return nil, fmt.Errorf("invalid serve date %q: %v", start, err)
+	}
+	endTime, err := time.Parse(huds.ServeDateLayout, end)
+	if err != nil {
+		return nil, fmt.Errorf("invalid serve date %q: %v", end, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var menus []huds.CondensedMenu
+	for day := startTime; !day.After(endTime); day = day.AddDate(0, 0, 1) {
+		if menu, exists := s.menus[day.Format(huds.ServeDateLayout)]; exists {
+			menus = append(menus, menu)
+		}
+	}
+	return menus, nil
+}
+
+func (s *MemoryMenuStore) Upsert(ctx context.Context, menus []huds.CondensedMenu) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, menu := range menus {
+		if _, _, err := bucketKeys(menu.ServeDate); err != nil {
+			continue
+		}
+		s.menus[menu.ServeDate] = menu
+		s.items[menu.ServeDate] = servedItemsFromMenu(menu)
+	}
+	return nil
+}
+
+func (s *MemoryMenuStore) EarliestLatest(ctx context.Context) (string, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var earliest, latest time.Time
+	for date := range s.menus {
+		t, err := time.Parse(huds.ServeDateLayout, date)
+		if err != nil {
+			continue
+		}
+		if earliest.IsZero() || t.Before(earliest) {
+			earliest = t
+		}
+		if t.After(latest) {
+			latest = t
+		}
+	}
+	if earliest.IsZero() {
+		return "", "", nil
+	}
+	return earliest.Format(huds.ServeDateLayout), latest.Format(huds.ServeDateLayout), nil
+}
+
+// Search scores each query word found in the food name, category or
+// ingredients with the same weights as the Mongo text index.
+func (s *MemoryMenuStore) Search(ctx context.Context, query SearchQuery) ([]SearchResult, error) {
+	terms := strings.Fields(strings.ToLower(query.Text))
+	s.mu.RLock()
+	served := s.servedItemsInRange(query.Start, query.End)
+	s.mu.RUnlock()
+
+	results := []SearchResult{}
+	for _, item := range served {
+		if query.Meal != "" && item.Meal != query.Meal {
+			continue
+		}
+		var score float64
+		for _, term := range terms {
+			if strings.Contains(strings.ToLower(item.FoodName), term) {
+				score += 10
+			}
+			if strings.Contains(strings.ToLower(item.MenuCategory), term) {
+				score += 3
+			}
+			if strings.Contains(strings.ToLower(item.Ingredients), term) {
+				score++
+			}
+		}
+		if score > 0 {
+			item.Ingredients = ""
+			results = append(results, SearchResult{ServedItem: item, Score: score})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if query.Sort != SortRelevance {
+			if c := compareServed(results[i].ServedItem, results[j].ServedItem, query.Sort, query.Descending); c != 0 {
+				return c < 0
+			}
+		}
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Date.After(results[j].Date)
+	})
+	if len(results) > query.Limit {
+		results = results[:query.Limit]
+	}
+	return results, nil
+}
+
+func (s *MemoryMenuStore) ItemByID(ctx context.Context, id int) (huds.CondensedMenuItem, string, string, error) {
+	s.mu.RLock()
+	served := s.servedItemsInRange(time.Time{}, time.Time{})
+	s.mu.RUnlock()
+	for i := len(served) - 1; i >= 0; i-- {
+		if served[i].ItemID != id {
+			continue
+		}
+		menu, err := s.GetByDate(ctx, served[i].ServeDate)
+		if err != nil {
+			return huds.CondensedMenuItem{}, "", "", err
+		}
+		for _, item := range huds.MealItems(menu, served[i].Meal) {
+			if item.ID == id {
+				return item, served[i].ServeDate, served[i].Meal, nil
+			}
+		}
+	}
+	return huds.CondensedMenuItem{}, "", "", ErrMenuNotFound
+}
+
+func (s *MemoryMenuStore) FoodOccurrences(ctx context.Context, name string, day time.Time, ahead bool, limit int) ([]Occurrence, error) {
+	s.mu.RLock()
+	served := s.servedItemsInRange(time.Time{}, time.Time{})
+	s.mu.RUnlock()
+	if !ahead {
+		for i, j := 0, len(served)-1; i < j; i, j = i+1, j-1 {
+			served[i], served[j] = served[j], served[i]
+		}
+	}
+
+	occurrences := []Occurrence{}
+	for _, item := range served {
+		inWindow := !item.Date.After(day)
+		if ahead {
+			inWindow = item.Date.After(day)
+		}
+		if !inWindow || !strings.EqualFold(item.FoodName, name) {
+			continue
+		}
+		if n := len(occurrences); n > 0 && occurrences[n-1].ServeDate == item.ServeDate {
+			occurrences[n-1].Meals = append(occurrences[n-1].Meals, item.Meal)
+			continue
+		}
+		if len(occurrences) == limit {
+			break
+		}
+		occurrences = append(occurrences, Occurrence{ServeDate: item.ServeDate, Meals: []string{item.Meal}})
+	}
+	for _, occurrence := range occurrences {
+		huds.SortMeals(occurrence.Meals)
+	}
+	return occurrences, nil
+}
+
+func (s *MemoryMenuStore) GetLocations(ctx context.Context, date string) (map[string]huds.LocationMenu, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	locations, exists := s.locations[date]
+	if !exists || len(locations) == 0 {
+		return nil, ErrMenuNotFound
+	}
+	return locations, nil
+}
+
+func (s *MemoryMenuStore) UpsertLocations(ctx context.Context, data map[string]map[string]huds.LocationMenu) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for date, locations := range data {
+		if s.locations[date] == nil {
+			s.locations[date] = make(map[string]huds.LocationMenu)
+		}
+		for key, menu := range locations {
+			s.locations[date][key] = menu
+		}
+	}
+	return nil
+}
+
+func (s *MemoryMenuStore) ArchiveRaw(ctx context.Context, fetchedAt time.Time, items []huds.MenuItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for date, day := range rawByDate(items) {
+		s.raw[date] = day
+	}
+	return nil
+}
+
+func (s *MemoryMenuStore) UpsertWeeks(ctx context.Context, weeks []Week) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, week := range weeks {
+		s.weeks[week.Start] = week
+	}
+	return nil
+}
+
+func (s *MemoryMenuStore) GetWeek(ctx context.Context, start string) (Week, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	week, exists := s.weeks[start]
+	if !exists {
+		return Week{}, ErrMenuNotFound
+	}
+	return week, nil
+}
+
+func (s *MemoryMenuStore) ListTags(ctx context.Context) ([]RecipeTags, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tags := make([]RecipeTags, 0, len(s.tags))
+	for _, recipe := range s.tags {
+		tags = append(tags, recipe)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].RecipeNumber < tags[j].RecipeNumber })
+	return tags, nil
+}
+
+func (s *MemoryMenuStore) SetTags(ctx context.Context, tags RecipeTags) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(tags.Tags) == 0 {
+		delete(s.tags, tags.RecipeNumber)
+	} else {
+		s.tags[tags.RecipeNumber] = tags
+	}
+	return nil
+}
+
+func (s *MemoryMenuStore) ListOverrides(ctx context.Context) ([]Override, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	overrides := make([]Override, 0, len(s.overrides))
+	for _, override := range s.overrides {
+		overrides = append(overrides, override)
+	}
+	sort.Slice(overrides, func(i, j int) bool { return overrides[i].ID < overrides[j].ID })
+	return overrides, nil
+}
+
+func (s *MemoryMenuStore) PutOverride(ctx context.Context, override Override) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.overrides[override.ID] = override
+	return nil
+}
+
+func (s *MemoryMenuStore) DeleteOverride(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.overrides[id]; !exists {
+		return ErrMenuNotFound
+	}
+	delete(s.overrides, id)
+	return nil
+}
+
+func (s *MemoryMenuStore) ListHours(ctx context.Context) ([]HoursSchedule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	schedules := make([]HoursSchedule, 0, len(s.hours))
+	for _, schedule := range s.hours {
+		schedules = append(schedules, schedule)
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].ID < schedules[j].ID })
+	return schedules, nil
+}
+
+func (s *MemoryMenuStore) PutHours(ctx context.Context, schedule HoursSchedule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hours[schedule.ID] = schedule
+	return nil
+}
+
+func (s *MemoryMenuStore) DeleteHours(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.hours[id]; !exists {
+		return ErrMenuNotFound
+	}
+	delete(s.hours, id)
+	return nil
+}
+
+func (s *MemoryMenuStore) GetRaw(ctx context.Context, date string) ([]huds.MenuItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	items, exists := s.raw[date]
+	if !exists {
+		return nil, ErrMenuNotFound
+	}
+	return items, nil
+}
+
+func (s *MemoryMenuStore) RawDates(ctx context.Context, start time.Time, end time.Time) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var days []time.Time
+	for date := range s.raw {
+		day, _ := time.Parse(huds.ServeDateLayout, date)
+		if !day.Before(start) && !day.After(end) {
+			days = append(days, day)
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	dates := make([]string, len(days))
+	for i, day := range days {
+		dates[i] = day.Format(huds.ServeDateLayout)
+	}
+	return dates, nil
+}
+
+func (s *MemoryMenuStore) AddRevision(ctx context.Context, date string, recordedAt time.Time, menu huds.CondensedMenu) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	revision := MenuRevision{ServeDate: date, Revision: len(s.revisions[date]) + 1, RecordedAt: recordedAt, Menu: menu}
+	s.revisions[date] = append(s.revisions[date], revision)
+	return nil
+}
+
+func (s *MemoryMenuStore) Revisions(ctx context.Context, date string) ([]MenuRevision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]MenuRevision(nil), s.revisions[date]...), nil
+}
+
+func (s *MemoryMenuStore) AppendEvents(ctx context.Context, events []MenuEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, event := range events {
+		event.ID = int64(len(s.events)) + 1
+		s.events = append(s.events, event)
+	}
+	return nil
+}
+
+func (s *MemoryMenuStore) EventsAfter(ctx context.Context, after int64, limit int) ([]MenuEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if after < 0 {
+		after = 0
+	}
+	if after >= int64(len(s.events)) {
+		return []MenuEvent{}, nil
+	}
+	events := s.events[after:]
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return append([]MenuEvent{}, events...), nil
+}
+
+func (s *MemoryMenuStore) AcquireLock(ctx context.Context, name string, owner string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for held, lock := range s.locks {
+		if !now.Before(lock.expiresAt) {
+			delete(s.locks, held)
+		}
+	}
+	if lock, held := s.locks[name]; held && lock.owner != owner && now.Before(lock.expiresAt) {
+		return false, nil
+	}
+	s.locks[name] = jobLock{owner: owner, expiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+func (s *MemoryMenuStore) Counts(ctx context.Context) (map[string]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	counts := map[string]int64{
+		"menus":     int64(len(s.menus)),
+		"locations": int64(len(s.locations)),
+		"raw":       int64(len(s.raw)),
+		"events":    int64(len(s.events)),
+	}
+	for _, items := range s.items {
+		counts["served_items"] += int64(len(items))
+	}
+	for _, revisions := range s.revisions {
+		counts["revisions"] += int64(len(revisions))
+	}
+	return counts, nil
+}
+
+// PruneBefore keeps the event log, whose IDs are positions in it.
+func (s *MemoryMenuStore) PruneBefore(ctx context.Context, cutoff time.Time) (map[string]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deleted := map[string]int64{"menus": 0, "locations": 0, "raw": 0, "served_items": 0, "revisions": 0, "weeks": 0}
+	for date := range s.menus {
+		if servedBefore(date, cutoff) {
+			delete(s.menus, date)
+			deleted["menus"]++
+			deleted["served_items"] += int64(len(s.items[date]))
+			delete(s.items, date)
+		}
+	}
+	for date := range s.locations {
+		if servedBefore(date, cutoff) {
+			delete(s.locations, date)
+			deleted["locations"]++
+		}
+	}
+	for date := range s.raw {
+		if servedBefore(date, cutoff) {
+			delete(s.raw, date)
+			deleted["raw"]++
+		}
+	}
+	for date, revisions := range s.revisions {
+		if servedBefore(date, cutoff) {
+			delete(s.revisions, date)
+			deleted["revisions"] += int64(len(revisions))
+		}
+	}
+	for start := range s.weeks {
+		if servedBefore(start, cutoff) {
+			delete(s.weeks, start)
+			deleted["weeks"]++
+		}
+	}
+	return deleted, nil
+}
+
+func (s *MemoryMenuStore) FoodFrequency(ctx context.Context, start time.Time, end time.Time, limit int) ([]FoodFrequency, error) {
+	s.mu.RLock()
+	served := s.servedItemsInRange(start, end)
+	s.mu.RUnlock()
+
+	byName := make(map[string]*FoodFrequency)
+	days := make(map[string]map[string]bool)
+	for _, item := range served {
+		food, exists := byName[item.FoodName]
+		if !exists {
+			food = &FoodFrequency{FoodName: item.FoodName}
+			byName[item.FoodName] = food
+			days[item.FoodName] = make(map[string]bool)
+		}
+		food.Count++
+		days[item.FoodName][item.ServeDate] = true
+		switch item.Meal {
+		case "breakfast":
+			food.Breakfast++
+		case "lunch":
+			food.Lunch++
+		case "dinner":
+			food.Dinner++
+		}
+	}
+	foods := []FoodFrequency{}
+	for name, food := range byName {
+		food.Days = len(days[name])
+		foods = append(foods, *food)
+	}
+	sortFrequencies(foods)
+	if len(foods) > limit {
+		foods = foods[:limit]
+	}
+	return foods, nil
+}
+
+// sortFrequencies puts the most frequent foods first, then sorts by name.
+func sortFrequencies(foods []FoodFrequency) {
+	sort.Slice(foods, func(i, j int) bool {
+		if foods[i].Count != foods[j].Count {
+			return foods[i].Count > foods[j].Count
+		}
+		return foods[i].FoodName < foods[j].FoodName
+	})
+}
+
+func (s *MemoryMenuStore) Summary(ctx context.Context, start time.Time, end time.Time) (AnalyticsSummary, error) {
+	s.mu.RLock()
+	served := s.servedItemsInRange(start, end)
+	s.mu.RUnlock()
+
+	entrees := make(map[string]int)
+	mealItemCounts := make(map[string]map[string]int)
+	type monthCounts struct{ items, vegan, vegetarian int }
+	months := make(map[string]*monthCounts)
+	for _, item := range served {
+		category := strings.ToLower(item.MenuCategory)
+		if strings.Contains(category, "entree") || strings.Contains(category, "entrée") {
+			entrees[item.FoodName]++
+		}
+		if mealItemCounts[item.Meal] == nil {
+			mealItemCounts[item.Meal] = make(map[string]int)
+		}
+		mealItemCounts[item.Meal][item.ServeDate]++
+		month := item.Date.Format("2006-01")
+		if months[month] == nil {
+			months[month] = &monthCounts{}
+		}
+		months[month].items++
+		if item.Vegan {
+			months[month].vegan++
+		}
+		if item.Vegetarian {
+			months[month].vegetarian++
+		}
+	}
+
+	summary := AnalyticsSummary{TopEntrees: []FoodFrequency{}, MealSizes: []MealSize{}, VeganShare: []VeganShare{}}
+	for name, count := range entrees {
+		summary.TopEntrees = append(summary.TopEntrees, FoodFrequency{FoodName: name, Count: count})
+	}
+	sortFrequencies(summary.TopEntrees)
+	if len(summary.TopEntrees) > 10 {
+		summary.TopEntrees = summary.TopEntrees[:10]
+	}
+	for meal, days := range mealItemCounts {
+		total := 0
+		for _, count := range days {
+			total += count
+		}
+		summary.MealSizes = append(summary.MealSizes, MealSize{Meal: meal, AverageItems: float64(total) / float64(len(days))})
+	}
+	sort.Slice(summary.MealSizes, func(i, j int) bool { return summary.MealSizes[i].Meal < summary.MealSizes[j].Meal })
+	percent := func(part, whole int) float64 {
+		return math.Round(float64(part)*1000/float64(whole)) / 10
+	}
+	for month, counts := range months {
+		summary.VeganShare = append(summary.VeganShare, VeganShare{
+			Month:             month,
+			Items:             counts.items,
+			VeganPercent:      percent(counts.vegan, counts.items),
+			VegetarianPercent: percent(counts.vegetarian, counts.items),
+		})
+	}
+	sort.Slice(summary.VeganShare, func(i, j int) bool { return summary.VeganShare[i].Month < summary.VeganShare[j].Month })
+	return summary, nil
+}
+
+func (s *MemoryMenuStore) CategoryLastServed(ctx context.Context, category string, day time.Time) (string, error) {
+	s.mu.RLock()
+	served := s.servedItemsInRange(time.Time{}, day)
+	s.mu.RUnlock()
+	for i := len(served) - 1; i >= 0; i-- {
+		if strings.EqualFold(served[i].MenuCategory, category) {
+			return served[i].ServeDate, nil
+		}
+	}
+	return "", ErrMenuNotFound
+}
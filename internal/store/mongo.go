@@ -0,0 +1,895 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"hudsgry-api/internal/huds"
+	"log"
+	"sort"
+	"time"
+)
+
+// MonthBucket holds every served day of a single month in one document, keyed
+// by the two-digit day of month. Bucketing keeps each query's working set to at
+// most a month of menus no matter how many years of history accumulate.
+type MonthBucket struct {
+	Month string                        `bson:"_id"`
+	Days  map[string]huds.CondensedMenu `bson:"days"`
+}
+
+// LocationBucket is the same layout for per-location menus, which are kept
+// apart so reading the house default view never has to load every location.
+type LocationBucket struct {
+	Month string                                  `bson:"_id"`
+	Days  map[string]map[string]huds.LocationMenu `bson:"days"`
+}
+
+// RawDocument is one serve date's upstream payload as fetched, gzipped.
+type RawDocument struct {
+	ServeDate string    `bson:"_id"`
+	Date      time.Time `bson:"date"`
+	FetchedAt time.Time `bson:"fetched_at"`
+	Items     []byte    `bson:"items"`
+}
+
+// foodNameCollation compares food names case-insensitively. Queries on
+// food_name must use it too to be served by the index.
+var foodNameCollation = &options.Collation{Locale: "en", Strength: 2}
+
+// MongoMenuStore keeps menus in month buckets, with a flat served_items
+// collection alongside for searches and lookups that would otherwise scan
+// every bucket.
+type MongoMenuStore struct {
+	months      *mongo.Collection
+	servedItems *mongo.Collection
+	locations   *mongo.Collection
+	raw         *mongo.Collection
+	revisions   *mongo.Collection
+	events      *mongo.Collection
+	counters    *mongo.Collection
+	locks       *mongo.Collection
+	weeks       *mongo.Collection
+	tags        *mongo.Collection
+	overrides   *mongo.Collection
+	hours       *mongo.Collection
+}
+
+// NewMongoMenuStore prepares the store's collections in db: indexes are
+// created if missing (unless opts.CreateIndexes is false) and verified, the
+// legacy one-document-per-day collection is migrated, and served items are
+// backfilled, all within ctx. A read-only store only verifies the indexes.
+// Failures there are logged rather than returned, since the menus themselves
+// can still be served.
+func NewMongoMenuStore(ctx context.Context, db *mongo.Database, opts OpenOptions) *MongoMenuStore {
+	s := &MongoMenuStore{
+		months:      db.Collection("months"),
+		servedItems: db.Collection("served_items"),
+		locations:   db.Collection("location_months"),
+		raw:         db.Collection("raw"),
+		revisions:   db.Collection("revisions"),
+		events:      db.Collection("events"),
+		counters:    db.Collection("counters"),
+		locks:       db.Collection("locks"),
+		weeks:       db.Collection("weeks"),
+		tags:        db.Collection("recipe_tags"),
+		overrides:   db.Collection("overrides"),
+		hours:       db.Collection("hours"),
+	}
+	if err := EnsureIndexes(ctx, db, menuIndexes, opts.CreateIndexes && !opts.ReadOnly); err != nil {
+		log.Printf("Failed to prepare menu indexes: %v\n", err)
+	}
+	if opts.ReadOnly {
+		return s
+	}
+	if err := s.migrateLegacyData(ctx, db.Collection("data")); err != nil {
+		log.Printf("Failed to migrate legacy data: %v\n", err)
+	}
+	if err := s.ensureServedItems(ctx); err != nil {
+		log.Printf("Failed to prepare served items: %v\n", err)
+	}
+	return s
+}
+
+// bucketDates returns the serve dates held in a bucket in chronological order.
+func bucketDates(bucket MonthBucket) []string {
+	days := make([]string, 0, len(bucket.Days))
+	for day := range bucket.Days {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+
+	dates := make([]string, 0, len(days))
+	for _, day := range days {
+		t, err := time.Parse("2006-01-02", bucket.Month+"-"+day)
+		if err != nil {
+			continue
+		}
+		dates = append(dates, t.Format(huds.ServeDateLayout))
+	}
+	return dates
+}
+
+func (s *MongoMenuStore) GetByDate(ctx context.Context, date string) (huds.CondensedMenu, error) {
+	month, day, err := bucketKeys(date)
+	if err != nil {
+		return huds.CondensedMenu{}, err
+	}
+
+	// Only project the requested day out of the month bucket
+	filter := bson.M{"_id": month}
+	opts := options.FindOne().SetProjection(bson.M{"days." + day: 1})
+	var bucket MonthBucket
+	err = s.months.FindOne(ctx, filter, opts).Decode(&bucket)
+	if err == mongo.ErrNoDocuments {
+		return huds.CondensedMenu{}, ErrMenuNotFound
+	}
+	if err != nil {
+		return huds.CondensedMenu{}, err
+	}
+
+	result, exists := bucket.Days[day]
+	if !exists {
+		// The month exists but this day was never stored
+		return huds.CondensedMenu{}, ErrMenuNotFound
+	}
+	log.Println("Found data in MongoDB")
+
+	return result, nil
+}
+
+// GetRange only reads the month buckets overlapping the range.
+func (s *MongoMenuStore) GetRange(ctx context.Context, start string, end string) ([]huds.CondensedMenu, error) {
+	startTime, err := time.Parse(huds.ServeDateLayout, start)
+	if err != nil {
+		return nil, fmt.Errorf("invalid serve date %q: %v", start, err)
+	}
+	endTime, err := time.Parse(huds.ServeDateLayout, end)
+	if err != nil {
+		return nil, fmt.Errorf("invalid serve date %q: %v", end, err)
+	}
+
+	filter := bson.M{"_id": bson.M{"$gte": startTime.Format("2006-01"), "$lte": endTime.Format("2006-01")}}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := s.months.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var buckets []MonthBucket
+	if err := cursor.All(ctx, &buckets); err != nil {
+		return nil, err
+	}
+
+	var menus []huds.CondensedMenu
+	for _, bucket := range buckets {
+		for _, date := range bucketDates(bucket) {
+			t, _ := time.Parse(huds.ServeDateLayout, date)
+			if t.Before(startTime) || t.After(endTime) {
+				continue
+			}
+			_, day, _ := bucketKeys(date)
+			menu := bucket.Days[day]
+			menu.ServeDate = date
+			menus = append(menus, menu)
+		}
+	}
+	return menus, nil
+}
+
+func (s *MongoMenuStore) Upsert(ctx context.Context, menus []huds.CondensedMenu) error {
+	// Group the days by month so each bucket is written with a single update
+	updatesByMonth := make(map[string]bson.D)
+	for _, menu := range menus {
+		month, day, err := bucketKeys(menu.ServeDate)
+		if err != nil {
+			log.Printf("Skipping %v\n", err)
+			continue
+		}
+		updatesByMonth[month] = append(updatesByMonth[month], bson.E{Key: "days." + day, Value: menu})
+	}
+
+	models := make([]mongo.WriteModel, 0, len(updatesByMonth))
+	for month, days := range updatesByMonth {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": month}).
+			SetUpdate(bson.D{{Key: "$set", Value: days}}).
+			SetUpsert(true))
+	}
+	if err := bulkWrite(ctx, s.months, models); err != nil {
+		log.Println("Failed to update data in MongoDB", err)
+		return fmt.Errorf("failed to insert item into collection: %v", err)
+	}
+
+	return s.indexServedItems(ctx, menus)
+}
+
+func (s *MongoMenuStore) EarliestLatest(ctx context.Context) (string, string, error) {
+	var earliest, latest string
+	// Month keys are YYYY-MM, so sorting on _id is chronological
+	for _, direction := range []int{1, -1} {
+		var bucket MonthBucket
+		opts := options.FindOne().SetSort(bson.D{{Key: "_id", Value: direction}})
+		err := s.months.FindOne(ctx, bson.D{}, opts).Decode(&bucket)
+		if err == mongo.ErrNoDocuments {
+			return "", "", nil
+		}
+		if err != nil {
+			return "", "", err
+		}
+		dates := bucketDates(bucket)
+		if len(dates) == 0 {
+			continue
+		}
+		if direction == 1 {
+			earliest = dates[0]
+		} else {
+			latest = dates[len(dates)-1]
+		}
+	}
+	return earliest, latest, nil
+}
+
+// migrateLegacyData copies the old one-document-per-day collection into month
+// buckets. It only runs while the bucketed collection is still empty.
+func (s *MongoMenuStore) migrateLegacyData(ctx context.Context, legacy *mongo.Collection) error {
+	bucketCount, err := s.months.EstimatedDocumentCount(ctx)
+	if err != nil || bucketCount > 0 {
+		return err
+	}
+
+	cursor, err := legacy.Find(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+	var menus []huds.CondensedMenu
+	if err := cursor.All(ctx, &menus); err != nil {
+		return err
+	}
+	if len(menus) == 0 {
+		return nil
+	}
+
+	log.Printf("Migrating %d legacy documents into month buckets\n", len(menus))
+	return s.Upsert(ctx, menus)
+}
+
+// ensureServedItems fills the served items collection from the month buckets
+// the first time it is used, or again when documents predate a field added
+// since.
+func (s *MongoMenuStore) ensureServedItems(ctx context.Context) error {
+	stale, err := s.servedItems.CountDocuments(ctx, bson.M{"$or": bson.A{
+		bson.M{"vegan": bson.M{"$exists": false}},
+		bson.M{"calories": bson.M{"$exists": false}},
+	}}, options.Count().SetLimit(1))
+	if err != nil {
+		return err
+	}
+	count, err := s.servedItems.EstimatedDocumentCount(ctx)
+	if err != nil || (count > 0 && stale == 0) {
+		return err
+	}
+	cursor, err := s.months.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	var buckets []MonthBucket
+	if err := cursor.All(ctx, &buckets); err != nil {
+		return err
+	}
+	for _, bucket := range buckets {
+		var menus []huds.CondensedMenu
+		for _, date := range bucketDates(bucket) {
+			_, day, _ := bucketKeys(date)
+			menu := bucket.Days[day]
+			menu.ServeDate = date
+			menus = append(menus, menu)
+		}
+		if err := s.indexServedItems(ctx, menus); err != nil {
+			return err
+		}
+	}
+	if len(buckets) > 0 {
+		log.Printf("Indexed served items for %d months\n", len(buckets))
+	}
+	return nil
+}
+
+func (s *MongoMenuStore) indexServedItems(ctx context.Context, menus []huds.CondensedMenu) error {
+	var models []mongo.WriteModel
+	for _, menu := range menus {
+		for _, served := range servedItemsFromMenu(menu) {
+			models = append(models, mongo.NewReplaceOneModel().
+				SetFilter(bson.M{"_id": served.Key}).
+				SetReplacement(served).
+				SetUpsert(true))
+		}
+	}
+	if err := bulkWrite(ctx, s.servedItems, models); err != nil {
+		return fmt.Errorf("failed to index served items: %v", err)
+	}
+	return nil
+}
+
+// bulkBatchSize caps the operations sent in one bulk write, so a backfill of
+// years of menus isn't held in a single request.
+const bulkBatchSize = 1000
+
+// bulkWrite applies models unordered, in batches of bulkBatchSize. The writes
+// are independent upserts, so one failing doesn't stop the rest.
+func bulkWrite(ctx context.Context, collection *mongo.Collection, models []mongo.WriteModel) error {
+	for start := 0; start < len(models); start += bulkBatchSize {
+		end := start + bulkBatchSize
+		if end > len(models) {
+			end = len(models)
+		}
+		if _, err := collection.BulkWrite(ctx, models[start:end], options.BulkWrite().SetOrdered(false)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Search uses the served_items text index, which weighs food names above
+// categories and categories above ingredients.
+func (s *MongoMenuStore) Search(ctx context.Context, query SearchQuery) ([]SearchResult, error) {
+	filter := bson.M{"$text": bson.M{"$search": query.Text}}
+	dates := bson.M{}
+	if !query.Start.IsZero() {
+		dates["$gte"] = query.Start
+	}
+	if !query.End.IsZero() {
+		dates["$lte"] = query.End
+	}
+	if len(dates) > 0 {
+		filter["date"] = dates
+	}
+	if query.Meal != "" {
+		filter["meal"] = query.Meal
+	}
+
+	direction := 1
+	if query.Descending {
+		direction = -1
+	}
+	var order bson.D
+	switch query.Sort {
+	case SortName:
+		order = bson.D{{Key: "sort_name", Value: direction}}
+	case SortCategory:
+		order = bson.D{{Key: "sort_category", Value: direction}, {Key: "sort_name", Value: direction}}
+	case SortCalories:
+		order = bson.D{{Key: "no_calories", Value: 1}, {Key: "calories", Value: direction}}
+	}
+	order = append(order, bson.E{Key: "score", Value: -1}, bson.E{Key: "date", Value: -1})
+
+	// Text queries can't take a collation, so names are lowercased to sort
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$addFields", Value: bson.M{
+			"score":         bson.M{"$meta": "textScore"},
+			"sort_name":     bson.M{"$toLower": "$food_name"},
+			"sort_category": bson.M{"$toLower": "$menu_category"},
+			"no_calories":   bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{"$calories", nil}}, nil}},
+		}}},
+		{{Key: "$sort", Value: order}},
+		{{Key: "$limit", Value: query.Limit}},
+		{{Key: "$project", Value: bson.M{"ingredients": 0, "sort_name": 0, "sort_category": 0, "no_calories": 0}}},
+	}
+	cursor, err := s.servedItems.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	results := []SearchResult{}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (s *MongoMenuStore) ItemByID(ctx context.Context, id int) (huds.CondensedMenuItem, string, string, error) {
+	var served ServedItem
+	opts := options.FindOne().SetSort(bson.D{{Key: "date", Value: -1}})
+	err := s.servedItems.FindOne(ctx, bson.M{"item_id": id}, opts).Decode(&served)
+	if err == mongo.ErrNoDocuments {
+		return huds.CondensedMenuItem{}, "", "", ErrMenuNotFound
+	}
+	if err != nil {
+		return huds.CondensedMenuItem{}, "", "", err
+	}
+	menu, err := s.GetByDate(ctx, served.ServeDate)
+	if err != nil {
+		return huds.CondensedMenuItem{}, "", "", err
+	}
+	for _, item := range huds.MealItems(menu, served.Meal) {
+		if item.ID == id {
+			return item, served.ServeDate, served.Meal, nil
+		}
+	}
+	return huds.CondensedMenuItem{}, "", "", ErrMenuNotFound
+}
+
+func (s *MongoMenuStore) FoodOccurrences(ctx context.Context, name string, day time.Time, ahead bool, limit int) ([]Occurrence, error) {
+	dateRange, direction := bson.M{"$lte": day}, -1
+	if ahead {
+		dateRange, direction = bson.M{"$gt": day}, 1
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"food_name": name, "date": dateRange}}},
+		{{Key: "$sort", Value: bson.D{{Key: "date", Value: direction}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$serve_date"},
+			{Key: "date", Value: bson.M{"$first": "$date"}},
+			{Key: "meals", Value: bson.M{"$addToSet": "$meal"}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "date", Value: direction}}}},
+		{{Key: "$limit", Value: limit}},
+	}
+	cursor, err := s.servedItems.Aggregate(ctx, pipeline, options.Aggregate().SetCollation(foodNameCollation))
+	if err != nil {
+		return nil, err
+	}
+	occurrences := []Occurrence{}
+	if err := cursor.All(ctx, &occurrences); err != nil {
+		return nil, err
+	}
+	for _, occurrence := range occurrences {
+		huds.SortMeals(occurrence.Meals)
+	}
+	return occurrences, nil
+}
+
+func (s *MongoMenuStore) GetLocations(ctx context.Context, date string) (map[string]huds.LocationMenu, error) {
+	month, day, err := bucketKeys(date)
+	if err != nil {
+		return nil, err
+	}
+	opts := options.FindOne().SetProjection(bson.M{"days." + day: 1})
+	var bucket LocationBucket
+	err = s.locations.FindOne(ctx, bson.M{"_id": month}, opts).Decode(&bucket)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrMenuNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	locations, exists := bucket.Days[day]
+	if !exists {
+		return nil, ErrMenuNotFound
+	}
+	return locations, nil
+}
+
+func (s *MongoMenuStore) UpsertLocations(ctx context.Context, data map[string]map[string]huds.LocationMenu) error {
+	updatesByMonth := make(map[string]bson.D)
+	for date, locations := range data {
+		month, day, err := bucketKeys(date)
+		if err != nil {
+			continue
+		}
+		for key, menu := range locations {
+			updatesByMonth[month] = append(updatesByMonth[month], bson.E{Key: "days." + day + "." + key, Value: menu})
+		}
+	}
+
+	models := make([]mongo.WriteModel, 0, len(updatesByMonth))
+	for month, days := range updatesByMonth {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": month}).
+			SetUpdate(bson.D{{Key: "$set", Value: days}}).
+			SetUpsert(true))
+	}
+	if err := bulkWrite(ctx, s.locations, models); err != nil {
+		return fmt.Errorf("failed to store location menus: %v", err)
+	}
+	return nil
+}
+
+func (s *MongoMenuStore) ArchiveRaw(ctx context.Context, fetchedAt time.Time, items []huds.MenuItem) error {
+	var models []mongo.WriteModel
+	for date, day := range rawByDate(items) {
+		t, _ := time.Parse(huds.ServeDateLayout, date)
+		data, err := compressRaw(day)
+		if err != nil {
+			return err
+		}
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"_id": date}).
+			SetReplacement(RawDocument{ServeDate: date, Date: t, FetchedAt: fetchedAt, Items: data}).
+			SetUpsert(true))
+	}
+	if err := bulkWrite(ctx, s.raw, models); err != nil {
+		return fmt.Errorf("failed to archive raw menus: %v", err)
+	}
+	return nil
+}
+
+func (s *MongoMenuStore) UpsertWeeks(ctx context.Context, weeks []Week) error {
+	models := make([]mongo.WriteModel, 0, len(weeks))
+	for _, week := range weeks {
+		models = append(models, mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": week.Start}).SetReplacement(week).SetUpsert(true))
+	}
+	return bulkWrite(ctx, s.weeks, models)
+}
+
+func (s *MongoMenuStore) GetWeek(ctx context.Context, start string) (Week, error) {
+	var week Week
+	err := s.weeks.FindOne(ctx, bson.M{"_id": start}).Decode(&week)
+	if err == mongo.ErrNoDocuments {
+		return Week{}, ErrMenuNotFound
+	}
+	if err != nil {
+		return Week{}, err
+	}
+	for _, menu := range week.Days {
+		for _, extra := range menu.Extra {
+			huds.LearnMealPeriod(extra.Number, extra.Key)
+		}
+	}
+	return week, nil
+}
+
+func (s *MongoMenuStore) ListTags(ctx context.Context) ([]RecipeTags, error) {
+	cursor, err := s.tags.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	tags := []RecipeTags{}
+	if err := cursor.All(ctx, &tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+func (s *MongoMenuStore) SetTags(ctx context.Context, tags RecipeTags) error {
+	if len(tags.Tags) == 0 {
+		_, err := s.tags.DeleteOne(ctx, bson.M{"_id": tags.RecipeNumber})
+		return err
+	}
+	_, err := s.tags.ReplaceOne(ctx, bson.M{"_id": tags.RecipeNumber}, tags, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (s *MongoMenuStore) ListOverrides(ctx context.Context) ([]Override, error) {
+	cursor, err := s.overrides.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	overrides := []Override{}
+	if err := cursor.All(ctx, &overrides); err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+func (s *MongoMenuStore) PutOverride(ctx context.Context, override Override) error {
+	_, err := s.overrides.ReplaceOne(ctx, bson.M{"_id": override.ID}, override, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (s *MongoMenuStore) DeleteOverride(ctx context.Context, id string) error {
+	result, err := s.overrides.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrMenuNotFound
+	}
+	return nil
+}
+
+func (s *MongoMenuStore) ListHours(ctx context.Context) ([]HoursSchedule, error) {
+	cursor, err := s.hours.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	schedules := []HoursSchedule{}
+	if err := cursor.All(ctx, &schedules); err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+func (s *MongoMenuStore) PutHours(ctx context.Context, schedule HoursSchedule) error {
+	_, err := s.hours.ReplaceOne(ctx, bson.M{"_id": schedule.ID}, schedule, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (s *MongoMenuStore) DeleteHours(ctx context.Context, id string) error {
+	result, err := s.hours.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrMenuNotFound
+	}
+	return nil
+}
+
+func (s *MongoMenuStore) GetRaw(ctx context.Context, date string) ([]huds.MenuItem, error) {
+	var doc RawDocument
+	err := s.raw.FindOne(ctx, bson.M{"_id": date}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrMenuNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decompressRaw(doc.Items)
+}
+
+func (s *MongoMenuStore) RawDates(ctx context.Context, start time.Time, end time.Time) ([]string, error) {
+	opts := options.Find().SetProjection(bson.M{"_id": 1}).SetSort(bson.M{"date": 1})
+	cursor, err := s.raw.Find(ctx, bson.M{"date": bson.M{"$gte": start, "$lte": end}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var docs []RawDocument
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	dates := make([]string, len(docs))
+	for i, doc := range docs {
+		dates[i] = doc.ServeDate
+	}
+	return dates, nil
+}
+
+func (s *MongoMenuStore) AddRevision(ctx context.Context, date string, recordedAt time.Time, menu huds.CondensedMenu) error {
+	count, err := s.revisions.CountDocuments(ctx, bson.M{"serve_date": date})
+	if err != nil {
+		return err
+	}
+	revision := MenuRevision{ServeDate: date, Revision: int(count) + 1, RecordedAt: recordedAt, Menu: menu}
+	if _, err := s.revisions.InsertOne(ctx, revision); err != nil {
+		return fmt.Errorf("failed to store revision of %s: %v", date, err)
+	}
+	return nil
+}
+
+func (s *MongoMenuStore) Revisions(ctx context.Context, date string) ([]MenuRevision, error) {
+	opts := options.Find().SetSort(bson.M{"revision": 1})
+	cursor, err := s.revisions.Find(ctx, bson.M{"serve_date": date}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var revisions []MenuRevision
+	if err := cursor.All(ctx, &revisions); err != nil {
+		return nil, err
+	}
+	for i := range revisions {
+		revisions[i].Menu.ServeDate = date
+		for _, extra := range revisions[i].Menu.Extra {
+			huds.LearnMealPeriod(extra.Number, extra.Key)
+		}
+	}
+	return revisions, nil
+}
+
+// AppendEvents reserves a block of IDs from the events counter, so concurrent
+// writers never hand out the same ID.
+func (s *MongoMenuStore) AppendEvents(ctx context.Context, events []MenuEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := s.counters.FindOneAndUpdate(ctx, bson.M{"_id": "events"}, bson.M{"$inc": bson.M{"seq": len(events)}}, opts).Decode(&counter)
+	if err != nil {
+		return fmt.Errorf("failed to reserve event IDs: %v", err)
+	}
+	docs := make([]interface{}, len(events))
+	for i, event := range events {
+		event.ID = counter.Seq - int64(len(events)) + int64(i) + 1
+		docs[i] = event
+	}
+	if _, err := s.events.InsertMany(ctx, docs); err != nil {
+		return fmt.Errorf("failed to store events: %v", err)
+	}
+	return nil
+}
+
+func (s *MongoMenuStore) EventsAfter(ctx context.Context, after int64, limit int) ([]MenuEvent, error) {
+	opts := options.Find().SetSort(bson.M{"_id": 1}).SetLimit(int64(limit))
+	cursor, err := s.events.Find(ctx, bson.M{"_id": bson.M{"$gt": after}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	events := []MenuEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// AcquireLock takes over the lease only if it has expired or is already
+// owner's. While someone else holds it, the upsert collides with their
+// document on _id and fails with a duplicate key error.
+func (s *MongoMenuStore) AcquireLock(ctx context.Context, name string, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	filter := bson.M{"_id": name, "$or": bson.A{bson.M{"expires_at": bson.M{"$lte": now}}, bson.M{"owner": owner}}}
+	update := bson.M{"$set": bson.M{"owner": owner, "expires_at": now.Add(ttl)}}
+	_, err := s.locks.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lock %s: %v", name, err)
+	}
+	return true, nil
+}
+
+// Counts uses each collection's metadata, so it is cheap but may be slightly
+// off after an unclean shutdown.
+func (s *MongoMenuStore) Counts(ctx context.Context) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	for _, collection := range []*mongo.Collection{s.months, s.servedItems, s.locations, s.raw, s.revisions, s.events, s.weeks, s.tags, s.overrides, s.hours} {
+		count, err := collection.EstimatedDocumentCount(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count %s: %v", collection.Name(), err)
+		}
+		counts[collection.Name()] = count
+	}
+	return counts, nil
+}
+
+// PruneBefore deletes the month buckets before cutoff's month whole, and the
+// days before cutoff from its month's buckets. Revisions and weeks are keyed
+// by MM/DD/YYYY, which doesn't sort, so their dates are listed and filtered
+// here.
+func (s *MongoMenuStore) PruneBefore(ctx context.Context, cutoff time.Time) (map[string]int64, error) {
+	deleted := make(map[string]int64)
+	month := cutoff.Format("2006-01")
+	var earlierDays bson.D
+	for day := 1; day < cutoff.Day(); day++ {
+		earlierDays = append(earlierDays, bson.E{Key: fmt.Sprintf("days.%02d", day), Value: ""})
+	}
+	for _, buckets := range []*mongo.Collection{s.months, s.locations} {
+		result, err := buckets.DeleteMany(ctx, bson.M{"_id": bson.M{"$lt": month}})
+		if err != nil {
+			return deleted, fmt.Errorf("failed to prune %s: %v", buckets.Name(), err)
+		}
+		deleted[buckets.Name()] = result.DeletedCount
+		if len(earlierDays) == 0 {
+			continue
+		}
+		if _, err := buckets.UpdateOne(ctx, bson.M{"_id": month}, bson.M{"$unset": earlierDays}); err != nil {
+			return deleted, fmt.Errorf("failed to prune %s: %v", buckets.Name(), err)
+		}
+	}
+
+	for _, dated := range []struct {
+		collection *mongo.Collection
+		filter     bson.M
+	}{
+		{s.servedItems, bson.M{"date": bson.M{"$lt": cutoff}}},
+		{s.raw, bson.M{"date": bson.M{"$lt": cutoff}}},
+		{s.events, bson.M{"created_at": bson.M{"$lt": cutoff}}},
+	} {
+		result, err := dated.collection.DeleteMany(ctx, dated.filter)
+		if err != nil {
+			return deleted, fmt.Errorf("failed to prune %s: %v", dated.collection.Name(), err)
+		}
+		deleted[dated.collection.Name()] = result.DeletedCount
+	}
+
+	for _, keyed := range []struct {
+		collection *mongo.Collection
+		field      string
+	}{
+		{s.revisions, "serve_date"},
+		{s.weeks, "_id"},
+	} {
+		dates, err := keyed.collection.Distinct(ctx, keyed.field, bson.M{})
+		if err != nil {
+			return deleted, fmt.Errorf("failed to prune %s: %v", keyed.collection.Name(), err)
+		}
+		var expired bson.A
+		for _, date := range dates {
+			if date, ok := date.(string); ok && servedBefore(date, cutoff) {
+				expired = append(expired, date)
+			}
+		}
+		if len(expired) == 0 {
+			deleted[keyed.collection.Name()] = 0
+			continue
+		}
+		result, err := keyed.collection.DeleteMany(ctx, bson.M{keyed.field: bson.M{"$in": expired}})
+		if err != nil {
+			return deleted, fmt.Errorf("failed to prune %s: %v", keyed.collection.Name(), err)
+		}
+		deleted[keyed.collection.Name()] = result.DeletedCount
+	}
+	return deleted, nil
+}
+
+func (s *MongoMenuStore) FoodFrequency(ctx context.Context, start time.Time, end time.Time, limit int) ([]FoodFrequency, error) {
+	mealCount := func(meal string) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$meal", meal}}, 1, 0}}}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"date": bson.M{"$gte": start, "$lte": end}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$food_name"},
+			{Key: "count", Value: bson.M{"$sum": 1}},
+			{Key: "dates", Value: bson.M{"$addToSet": "$date"}},
+			{Key: "breakfast", Value: mealCount("breakfast")},
+			{Key: "lunch", Value: mealCount("lunch")},
+			{Key: "dinner", Value: mealCount("dinner")},
+		}}},
+		{{Key: "$addFields", Value: bson.M{"days": bson.M{"$size": "$dates"}}}},
+		{{Key: "$project", Value: bson.M{"dates": 0}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+	}
+	cursor, err := s.servedItems.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	foods := []FoodFrequency{}
+	if err := cursor.All(ctx, &foods); err != nil {
+		return nil, err
+	}
+	return foods, nil
+}
+
+// Summary computes all of its statistics in a single faceted aggregation.
+func (s *MongoMenuStore) Summary(ctx context.Context, start time.Time, end time.Time) (AnalyticsSummary, error) {
+	percent := func(field string) bson.M {
+		return bson.M{"$round": bson.A{bson.M{"$multiply": bson.A{bson.M{"$divide": bson.A{field, "$items"}}, 100}}, 1}}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"date": bson.M{"$gte": start, "$lte": end}}}},
+		{{Key: "$facet", Value: bson.M{
+			"top_entrees": bson.A{
+				bson.M{"$match": bson.M{"menu_category": bson.M{"$regex": "entr[eé]e", "$options": "i"}}},
+				bson.M{"$group": bson.M{"_id": "$food_name", "count": bson.M{"$sum": 1}}},
+				bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+				bson.M{"$limit": 10},
+			},
+			"meal_sizes": bson.A{
+				bson.M{"$group": bson.M{"_id": bson.M{"date": "$date", "meal": "$meal"}, "items": bson.M{"$sum": 1}}},
+				bson.M{"$group": bson.M{"_id": "$_id.meal", "average_items": bson.M{"$avg": "$items"}}},
+				bson.M{"$sort": bson.M{"_id": 1}},
+			},
+			"vegan_share": bson.A{
+				bson.M{"$group": bson.M{
+					"_id":        bson.M{"$dateToString": bson.M{"format": "%Y-%m", "date": "$date"}},
+					"items":      bson.M{"$sum": 1},
+					"vegan":      bson.M{"$sum": bson.M{"$cond": bson.A{"$vegan", 1, 0}}},
+					"vegetarian": bson.M{"$sum": bson.M{"$cond": bson.A{"$vegetarian", 1, 0}}},
+				}},
+				bson.M{"$project": bson.M{"items": 1, "vegan_percent": percent("$vegan"), "vegetarian_percent": percent("$vegetarian")}},
+				bson.M{"$sort": bson.M{"_id": 1}},
+			},
+		}}},
+	}
+	cursor, err := s.servedItems.Aggregate(ctx, pipeline)
+	if err != nil {
+		return AnalyticsSummary{}, err
+	}
+	var results []AnalyticsSummary
+	if err := cursor.All(ctx, &results); err != nil {
+		return AnalyticsSummary{}, err
+	}
+	if len(results) == 0 {
+		return AnalyticsSummary{}, nil
+	}
+	return results[0], nil
+}
+
+func (s *MongoMenuStore) CategoryLastServed(ctx context.Context, category string, day time.Time) (string, error) {
+	var last ServedItem
+	opts := options.FindOne().SetSort(bson.D{{Key: "date", Value: -1}}).SetCollation(foodNameCollation)
+	err := s.servedItems.FindOne(ctx, bson.M{"menu_category": category, "date": bson.M{"$lte": day}}, opts).Decode(&last)
+	if err == mongo.ErrNoDocuments {
+		return "", ErrMenuNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return last.ServeDate, nil
+}
@@ -0,0 +1,110 @@
+package store
+
+import (
+	"fmt"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"os"
+	"strconv"
+	"time"
+)
+
+// LoadMongoClientOptions builds the MongoDB client options from uri and the
+// settings below, which override the same options given in the URI. Left
+// unset, the driver's defaults apply.
+//
+//   - MONGODB_MAX_POOL_SIZE and MONGODB_MIN_POOL_SIZE bound the connections
+//     kept per server; a free-tier cluster allows few, so keep the maximum
+//     small there
+//   - MONGODB_MAX_CONNECTING is how many connections may be established at
+//     once
+//   - MONGODB_MAX_CONN_IDLE_TIME closes connections left idle this long
+//   - MONGODB_CONNECT_TIMEOUT bounds opening a connection
+//   - MONGODB_SOCKET_TIMEOUT bounds a read or write on one
+//   - MONGODB_SERVER_SELECTION_TIMEOUT is how long an operation waits for a
+//     suitable server, e.g. a primary during an election, before failing
+//   - MONGODB_READ_PREFERENCE is primary, primaryPreferred, secondary,
+//     secondaryPreferred or nearest; reading from secondaries spreads load
+//     on a replica set but may briefly miss the latest refresh
+//   - MONGODB_RETRY_WRITES and MONGODB_RETRY_READS turn the driver's single
+//     retry of a failed operation on or off
+func LoadMongoClientOptions(uri string) (*options.ClientOptions, error) {
+	opts := options.Client().ApplyURI(uri).SetAppName("hudsgry-api")
+
+	counts := []struct {
+		name string
+		set  func(uint64) *options.ClientOptions
+	}{
+		{"MONGODB_MAX_POOL_SIZE", opts.SetMaxPoolSize},
+		{"MONGODB_MIN_POOL_SIZE", opts.SetMinPoolSize},
+		{"MONGODB_MAX_CONNECTING", opts.SetMaxConnecting},
+	}
+	for _, count := range counts {
+		s := os.Getenv(count.name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a whole number, got %q", count.name, s)
+		}
+		count.set(n)
+	}
+
+	durations := []struct {
+		name string
+		set  func(time.Duration) *options.ClientOptions
+	}{
+		{"MONGODB_MAX_CONN_IDLE_TIME", opts.SetMaxConnIdleTime},
+		{"MONGODB_CONNECT_TIMEOUT", opts.SetConnectTimeout},
+		{"MONGODB_SOCKET_TIMEOUT", opts.SetSocketTimeout},
+		{"MONGODB_SERVER_SELECTION_TIMEOUT", opts.SetServerSelectionTimeout},
+	}
+	for _, duration := range durations {
+		s := os.Getenv(duration.name)
+		if s == "" {
+			continue
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("%s must be a positive duration, got %q", duration.name, s)
+		}
+		duration.set(d)
+	}
+
+	flags := []struct {
+		name string
+		set  func(bool) *options.ClientOptions
+	}{
+		{"MONGODB_RETRY_WRITES", opts.SetRetryWrites},
+		{"MONGODB_RETRY_READS", opts.SetRetryReads},
+	}
+	for _, flag := range flags {
+		s := os.Getenv(flag.name)
+		if s == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be true or false, got %q", flag.name, s)
+		}
+		flag.set(b)
+	}
+
+	if s := os.Getenv("MONGODB_READ_PREFERENCE"); s != "" {
+		mode, err := readpref.ModeFromString(s)
+		if err != nil {
+			return nil, fmt.Errorf("MONGODB_READ_PREFERENCE must be primary, primaryPreferred, secondary, secondaryPreferred or nearest, got %q", s)
+		}
+		pref, err := readpref.New(mode)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetReadPreference(pref)
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid MongoDB client options: %v", err)
+	}
+	return opts, nil
+}
@@ -0,0 +1,81 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Override corrects upstream data for a recipe, on every day it is served or
+// just on ServeDate. It is applied each time menus are condensed, so it
+// survives refreshes that would otherwise restore HUDS's version. Nil fields
+// are left as HUDS has them.
+type Override struct {
+	ID           string  `json:"id" bson:"_id"`
+	RecipeNumber string  `json:"Recipe_Number" bson:"recipe_number"`
+	ServeDate    string  `json:"Serve_Date,omitempty" bson:"serve_date,omitempty"`
+	FoodName     *string `json:"Food_Name,omitempty" bson:"food_name,omitempty"`
+	Allergens    *string `json:"Allergens,omitempty" bson:"allergens,omitempty"`
+	Ingredients  *string `json:"Ingredient_List,omitempty" bson:"ingredients,omitempty"`
+	Cancelled    bool    `json:"Cancelled" bson:"cancelled"`
+	// Note says why, for whoever finds the override later
+	Note      string    `json:"note,omitempty" bson:"note,omitempty"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// OverrideID is the key of the override for a recipe on serveDate, or on
+// every day when serveDate is empty.
+func OverrideID(recipeNumber string, serveDate string) string {
+	if serveDate == "" {
+		return recipeNumber
+	}
+	return recipeNumber + "@" + serveDate
+}
+
+// OverrideStore is implemented by stores that keep data corrections.
+type OverrideStore interface {
+	// ListOverrides returns every override, ordered by ID.
+	ListOverrides(ctx context.Context) ([]Override, error)
+	// PutOverride replaces the override with the same ID.
+	PutOverride(ctx context.Context, override Override) error
+	// DeleteOverride removes an override, or returns ErrMenuNotFound if
+	// there is none with the ID.
+	DeleteOverride(ctx context.Context, id string) error
+}
+
+// listSQLOverrides reads the overrides table both SQL stores keep, with each
+// override encoded as JSON.
+func listSQLOverrides(ctx context.Context, db *sql.DB) ([]Override, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id, override FROM overrides ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	overrides := []Override{}
+	for rows.Next() {
+		var id string
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, err
+		}
+		var override Override
+		if err := json.Unmarshal(data, &override); err != nil {
+			return nil, fmt.Errorf("failed to decode override %s: %v", id, err)
+		}
+		overrides = append(overrides, override)
+	}
+	return overrides, rows.Err()
+}
+
+func deleteSQLByID(ctx context.Context, db *sql.DB, query string, id string) error {
+	result, err := db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+		return ErrMenuNotFound
+	}
+	return nil
+}
@@ -0,0 +1,755 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/lib/pq"
+	"hudsgry-api/internal/huds"
+	"strings"
+	"time"
+)
+
+// postgresSchema is applied on startup. The search column needs PostgreSQL 12
+// or later for generated columns.
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS menus (
+	serve_date date PRIMARY KEY,
+	meals jsonb NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS location_menus (
+	serve_date date NOT NULL,
+	location text NOT NULL,
+	name text NOT NULL,
+	meals jsonb NOT NULL,
+	PRIMARY KEY (serve_date, location)
+);
+
+CREATE TABLE IF NOT EXISTS served_items (
+	serve_date date NOT NULL,
+	meal text NOT NULL,
+	food_name text NOT NULL,
+	item_id integer,
+	menu_category text NOT NULL DEFAULT '',
+	ingredients text NOT NULL DEFAULT '',
+	vegan boolean NOT NULL DEFAULT false,
+	vegetarian boolean NOT NULL DEFAULT false,
+	calories double precision,
+	search tsvector GENERATED ALWAYS AS (
+		setweight(to_tsvector('english', food_name), 'A') ||
+		setweight(to_tsvector('english', menu_category), 'B') ||
+		setweight(to_tsvector('english', ingredients), 'C')
+	) STORED
+);
+
+CREATE TABLE IF NOT EXISTS menu_revisions (
+	serve_date date NOT NULL,
+	revision integer NOT NULL,
+	recorded_at timestamptz NOT NULL,
+	meals jsonb NOT NULL,
+	PRIMARY KEY (serve_date, revision)
+);
+
+CREATE TABLE IF NOT EXISTS menu_events (
+	id bigserial PRIMARY KEY,
+	type text NOT NULL,
+	serve_date text NOT NULL,
+	meal text NOT NULL,
+	added text[] NOT NULL,
+	removed text[] NOT NULL,
+	created_at timestamptz NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS raw_menus (
+	serve_date date PRIMARY KEY,
+	fetched_at timestamptz NOT NULL,
+	items bytea NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS weeks (
+	week_start date PRIMARY KEY,
+	built_at timestamptz NOT NULL,
+	days jsonb NOT NULL,
+	summary jsonb NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS recipe_tags (
+	recipe_number text PRIMARY KEY,
+	tags text[] NOT NULL,
+	updated_at timestamptz NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS overrides (
+	id text PRIMARY KEY,
+	override jsonb NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS hours (
+	id text PRIMARY KEY,
+	schedule jsonb NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS job_locks (
+	name text PRIMARY KEY,
+	owner text NOT NULL,
+	expires_at timestamptz NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS served_items_key ON served_items (serve_date, meal, lower(food_name));
+CREATE INDEX IF NOT EXISTS served_items_food_name ON served_items (lower(food_name), serve_date);
+CREATE INDEX IF NOT EXISTS served_items_item_id ON served_items (item_id);
+CREATE INDEX IF NOT EXISTS served_items_search ON served_items USING gin (search);
+`
+
+// PostgresMenuStore keeps each day's menu as a JSONB row, with served items
+// normalized into their own table for searches and analytics.
+type PostgresMenuStore struct {
+	db *sql.DB
+}
+
+// NewPostgresMenuStore connects to the database at url and brings its schema
+// up to date, unless opts.ReadOnly is set, all within ctx.
+func NewPostgresMenuStore(ctx context.Context, url string, opts OpenOptions) (*PostgresMenuStore, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		return nil, fmt.Errorf("failed to connect to PostgreSQL: %v", err)
+	}
+	if opts.ReadOnly {
+		return &PostgresMenuStore{db: db}, nil
+	}
+	if _, err := db.ExecContext(ctx, postgresSchema); err != nil {
+		return nil, fmt.Errorf("failed to create PostgreSQL schema: %v", err)
+	}
+	store := &PostgresMenuStore{db: db}
+	if err := store.addServedCalories(ctx); err != nil {
+		return nil, fmt.Errorf("failed to add calories to served items: %v", err)
+	}
+	return store, nil
+}
+
+// addServedCalories adds the calories column to databases created before it
+// existed, re-indexing every stored menu to fill it in.
+func (s *PostgresMenuStore) addServedCalories(ctx context.Context) error {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'served_items' AND column_name = 'calories')`).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, `ALTER TABLE served_items ADD COLUMN IF NOT EXISTS calories double precision`); err != nil {
+		return err
+	}
+	return reindexServedItems(ctx, s)
+}
+
+// nullDate turns a zero time into NULL.
+func nullDate(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+func (s *PostgresMenuStore) GetByDate(ctx context.Context, date string) (huds.CondensedMenu, error) {
+	t, err := time.Parse(huds.ServeDateLayout, date)
+	if err != nil {
+		return huds.CondensedMenu{}, fmt.Errorf("invalid serve date %q: %v", date, err)
+	}
+	var data []byte
+	err = s.db.QueryRowContext(ctx, `SELECT meals FROM menus WHERE serve_date = $1`, t).Scan(&data)
+	if err == sql.ErrNoRows {
+		return huds.CondensedMenu{}, ErrMenuNotFound
+	}
+	if err != nil {
+		return huds.CondensedMenu{}, err
+	}
+	return decodeMeals(data, date)
+}
+
+func (s *PostgresMenuStore) GetRange(ctx context.Context, start string, end string) ([]huds.CondensedMenu, error) {
+	startTime, err := time.Parse(huds.ServeDateLayout, start)
+	if err != nil {
+		return nil, fmt.Errorf("invalid serve date %q: %v", start, err)
+	}
+	endTime, err := time.Parse(huds.ServeDateLayout, end)
+	if err != nil {
+		return nil, fmt.Errorf("invalid serve date %q: %v", end, err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT serve_date, meals FROM menus WHERE serve_date BETWEEN $1 AND $2 ORDER BY serve_date`, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var menus []huds.CondensedMenu
+	for rows.Next() {
+		var date time.Time
+		var data []byte
+		if err := rows.Scan(&date, &data); err != nil {
+			return nil, err
+		}
+		menu, err := decodeMeals(data, date.Format(huds.ServeDateLayout))
+		if err != nil {
+			return nil, err
+		}
+		menus = append(menus, menu)
+	}
+	return menus, rows.Err()
+}
+
+// Upsert replaces each day's menu and its served items in one transaction.
+func (s *PostgresMenuStore) Upsert(ctx context.Context, menus []huds.CondensedMenu) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, menu := range menus {
+		t, err := time.Parse(huds.ServeDateLayout, menu.ServeDate)
+		if err != nil {
+			continue
+		}
+		data, err := encodeMeals(menu)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO menus (serve_date, meals) VALUES ($1, $2)
+			ON CONFLICT (serve_date) DO UPDATE SET meals = excluded.meals`, t, data)
+		if err != nil {
+			return fmt.Errorf("failed to store menu for %s: %v", menu.ServeDate, err)
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM served_items WHERE serve_date = $1`, t); err != nil {
+			return err
+		}
+		for _, meal := range menu.Meals() {
+			for _, item := range huds.MealItems(menu, meal) {
+				_, err := tx.ExecContext(ctx, `INSERT INTO served_items
+					(serve_date, meal, food_name, item_id, menu_category, ingredients, vegan, vegetarian, calories)
+					VALUES ($1, $2, $3, NULLIF($4, 0), $5, $6, $7, $8, $9)
+					ON CONFLICT DO NOTHING`,
+					t, meal, item.FoodName, item.ID, item.MenuCategory, item.Ingredients, item.Vegan, item.Vegetarian, itemCalories(item))
+				if err != nil {
+					return fmt.Errorf("failed to index served items: %v", err)
+				}
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresMenuStore) EarliestLatest(ctx context.Context) (string, string, error) {
+	var earliest, latest sql.NullTime
+	err := s.db.QueryRowContext(ctx, `SELECT min(serve_date), max(serve_date) FROM menus`).Scan(&earliest, &latest)
+	if err != nil || !earliest.Valid {
+		return "", "", err
+	}
+	return earliest.Time.Format(huds.ServeDateLayout), latest.Time.Format(huds.ServeDateLayout), nil
+}
+
+// Search ranks food names above categories and categories above ingredients,
+// like the Mongo text index.
+func (s *PostgresMenuStore) Search(ctx context.Context, query SearchQuery) ([]SearchResult, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`SELECT serve_date, meal, food_name, coalesce(item_id, 0), menu_category,
+			vegan, vegetarian, calories, ts_rank(search, q) AS score
+		FROM served_items, websearch_to_tsquery('english', $1) q
+		WHERE search @@ q
+			AND ($2::date IS NULL OR serve_date >= $2)
+			AND ($3::date IS NULL OR serve_date <= $3)
+			AND ($4 = '' OR meal = $4)
+		ORDER BY %s
+		LIMIT $5`, sqlSearchOrder(query)), query.Text, nullDate(query.Start), nullDate(query.End), query.Meal, query.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := []SearchResult{}
+	for rows.Next() {
+		var result SearchResult
+		err := rows.Scan(&result.Date, &result.Meal, &result.FoodName, &result.ItemID, &result.MenuCategory,
+			&result.Vegan, &result.Vegetarian, &result.Calories, &result.Score)
+		if err != nil {
+			return nil, err
+		}
+		result.ServeDate = result.Date.Format(huds.ServeDateLayout)
+		result.Key = result.ServeDate + "|" + result.Meal + "|" + strings.ToLower(result.FoodName)
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+func (s *PostgresMenuStore) ItemByID(ctx context.Context, id int) (huds.CondensedMenuItem, string, string, error) {
+	var date time.Time
+	var meal string
+	err := s.db.QueryRowContext(ctx, `SELECT serve_date, meal FROM served_items WHERE item_id = $1
+		ORDER BY serve_date DESC LIMIT 1`, id).Scan(&date, &meal)
+	if err == sql.ErrNoRows {
+		return huds.CondensedMenuItem{}, "", "", ErrMenuNotFound
+	}
+	if err != nil {
+		return huds.CondensedMenuItem{}, "", "", err
+	}
+	serveDate := date.Format(huds.ServeDateLayout)
+	menu, err := s.GetByDate(ctx, serveDate)
+	if err != nil {
+		return huds.CondensedMenuItem{}, "", "", err
+	}
+	for _, item := range huds.MealItems(menu, meal) {
+		if item.ID == id {
+			return item, serveDate, meal, nil
+		}
+	}
+	return huds.CondensedMenuItem{}, "", "", ErrMenuNotFound
+}
+
+func (s *PostgresMenuStore) FoodOccurrences(ctx context.Context, name string, day time.Time, ahead bool, limit int) ([]Occurrence, error) {
+	comparison, direction := "<=", "DESC"
+	if ahead {
+		comparison, direction = ">", "ASC"
+	}
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`SELECT serve_date, array_agg(DISTINCT meal) FROM served_items
+		WHERE lower(food_name) = lower($1) AND serve_date %s $2
+		GROUP BY serve_date ORDER BY serve_date %s LIMIT $3`, comparison, direction), name, day, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	occurrences := []Occurrence{}
+	for rows.Next() {
+		var date time.Time
+		var occurrence Occurrence
+		if err := rows.Scan(&date, pq.Array(&occurrence.Meals)); err != nil {
+			return nil, err
+		}
+		occurrence.ServeDate = date.Format(huds.ServeDateLayout)
+		huds.SortMeals(occurrence.Meals)
+		occurrences = append(occurrences, occurrence)
+	}
+	return occurrences, rows.Err()
+}
+
+func (s *PostgresMenuStore) GetLocations(ctx context.Context, date string) (map[string]huds.LocationMenu, error) {
+	t, err := time.Parse(huds.ServeDateLayout, date)
+	if err != nil {
+		return nil, fmt.Errorf("invalid serve date %q: %v", date, err)
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT location, name, meals FROM location_menus WHERE serve_date = $1`, t)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	locations := make(map[string]huds.LocationMenu)
+	for rows.Next() {
+		var key, name string
+		var data []byte
+		if err := rows.Scan(&key, &name, &data); err != nil {
+			return nil, err
+		}
+		menu, err := decodeMeals(data, date)
+		if err != nil {
+			return nil, err
+		}
+		locations[key] = huds.LocationMenu{Name: name, Breakfast: menu.Breakfast, Lunch: menu.Lunch, Dinner: menu.Dinner, Extra: menu.Extra}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(locations) == 0 {
+		return nil, ErrMenuNotFound
+	}
+	return locations, nil
+}
+
+func (s *PostgresMenuStore) UpsertLocations(ctx context.Context, data map[string]map[string]huds.LocationMenu) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for date, locations := range data {
+		t, err := time.Parse(huds.ServeDateLayout, date)
+		if err != nil {
+			continue
+		}
+		for key, location := range locations {
+			meals, err := encodeMeals(huds.CondensedMenu{Breakfast: location.Breakfast, Lunch: location.Lunch, Dinner: location.Dinner, Extra: location.Extra})
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `INSERT INTO location_menus (serve_date, location, name, meals) VALUES ($1, $2, $3, $4)
+				ON CONFLICT (serve_date, location) DO UPDATE SET name = excluded.name, meals = excluded.meals`,
+				t, key, location.Name, meals)
+			if err != nil {
+				return fmt.Errorf("failed to store location menus for %s: %v", date, err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresMenuStore) ArchiveRaw(ctx context.Context, fetchedAt time.Time, items []huds.MenuItem) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for date, day := range rawByDate(items) {
+		data, err := compressRaw(day)
+		if err != nil {
+			return err
+		}
+		t, _ := time.Parse(huds.ServeDateLayout, date)
+		_, err = tx.ExecContext(ctx, `INSERT INTO raw_menus (serve_date, fetched_at, items) VALUES ($1, $2, $3)
+			ON CONFLICT (serve_date) DO UPDATE SET fetched_at = excluded.fetched_at, items = excluded.items`,
+			t, fetchedAt, data)
+		if err != nil {
+			return fmt.Errorf("failed to archive raw menus for %s: %v", date, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresMenuStore) UpsertWeeks(ctx context.Context, weeks []Week) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, week := range weeks {
+		start, err := time.Parse(huds.ServeDateLayout, week.Start)
+		if err != nil {
+			return fmt.Errorf("invalid serve date %q: %v", week.Start, err)
+		}
+		days, err := encodeWeekDays(week.Days)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO weeks (week_start, built_at, days, summary) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (week_start) DO UPDATE SET built_at = excluded.built_at, days = excluded.days, summary = excluded.summary`,
+			start, week.BuiltAt, days, week.Summary)
+		if err != nil {
+			return fmt.Errorf("failed to store week of %s: %v", week.Start, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresMenuStore) GetWeek(ctx context.Context, start string) (Week, error) {
+	t, err := time.Parse(huds.ServeDateLayout, start)
+	if err != nil {
+		return Week{}, fmt.Errorf("invalid serve date %q: %v", start, err)
+	}
+	week := Week{Start: start}
+	var days []byte
+	err = s.db.QueryRowContext(ctx, `SELECT built_at, days, summary FROM weeks WHERE week_start = $1`, t).Scan(&week.BuiltAt, &days, &week.Summary)
+	if err == sql.ErrNoRows {
+		return Week{}, ErrMenuNotFound
+	}
+	if err != nil {
+		return Week{}, err
+	}
+	if week.Days, err = decodeWeekDays(days); err != nil {
+		return Week{}, err
+	}
+	return week, nil
+}
+
+func (s *PostgresMenuStore) ListTags(ctx context.Context) ([]RecipeTags, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT recipe_number, tags, updated_at FROM recipe_tags ORDER BY recipe_number`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := []RecipeTags{}
+	for rows.Next() {
+		var recipe RecipeTags
+		if err := rows.Scan(&recipe.RecipeNumber, pq.Array(&recipe.Tags), &recipe.UpdatedAt); err != nil {
+			return nil, err
+		}
+		tags = append(tags, recipe)
+	}
+	return tags, rows.Err()
+}
+
+func (s *PostgresMenuStore) SetTags(ctx context.Context, tags RecipeTags) error {
+	if len(tags.Tags) == 0 {
+		_, err := s.db.ExecContext(ctx, `DELETE FROM recipe_tags WHERE recipe_number = $1`, tags.RecipeNumber)
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `INSERT INTO recipe_tags (recipe_number, tags, updated_at) VALUES ($1, $2, $3)
+		ON CONFLICT (recipe_number) DO UPDATE SET tags = excluded.tags, updated_at = excluded.updated_at`,
+		tags.RecipeNumber, pq.Array(tags.Tags), tags.UpdatedAt)
+	return err
+}
+
+func (s *PostgresMenuStore) ListOverrides(ctx context.Context) ([]Override, error) {
+	return listSQLOverrides(ctx, s.db)
+}
+
+func (s *PostgresMenuStore) PutOverride(ctx context.Context, override Override) error {
+	data, err := json.Marshal(override)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO overrides (id, override) VALUES ($1, $2)
+		ON CONFLICT (id) DO UPDATE SET override = excluded.override`, override.ID, data)
+	return err
+}
+
+func (s *PostgresMenuStore) DeleteOverride(ctx context.Context, id string) error {
+	return deleteSQLByID(ctx, s.db, `DELETE FROM overrides WHERE id = $1`, id)
+}
+
+func (s *PostgresMenuStore) ListHours(ctx context.Context) ([]HoursSchedule, error) {
+	return listSQLHours(ctx, s.db)
+}
+
+func (s *PostgresMenuStore) PutHours(ctx context.Context, schedule HoursSchedule) error {
+	data, err := json.Marshal(schedule)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO hours (id, schedule) VALUES ($1, $2)
+		ON CONFLICT (id) DO UPDATE SET schedule = excluded.schedule`, schedule.ID, data)
+	return err
+}
+
+func (s *PostgresMenuStore) DeleteHours(ctx context.Context, id string) error {
+	return deleteSQLByID(ctx, s.db, `DELETE FROM hours WHERE id = $1`, id)
+}
+
+func (s *PostgresMenuStore) GetRaw(ctx context.Context, date string) ([]huds.MenuItem, error) {
+	t, err := time.Parse(huds.ServeDateLayout, date)
+	if err != nil {
+		return nil, fmt.Errorf("invalid serve date %q: %v", date, err)
+	}
+	var data []byte
+	err = s.db.QueryRowContext(ctx, `SELECT items FROM raw_menus WHERE serve_date = $1`, t).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, ErrMenuNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decompressRaw(data)
+}
+
+func (s *PostgresMenuStore) RawDates(ctx context.Context, start time.Time, end time.Time) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT serve_date FROM raw_menus WHERE serve_date BETWEEN $1 AND $2 ORDER BY serve_date`, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var dates []string
+	for rows.Next() {
+		var day time.Time
+		if err := rows.Scan(&day); err != nil {
+			return nil, err
+		}
+		dates = append(dates, day.Format(huds.ServeDateLayout))
+	}
+	return dates, rows.Err()
+}
+
+func (s *PostgresMenuStore) AddRevision(ctx context.Context, date string, recordedAt time.Time, menu huds.CondensedMenu) error {
+	t, err := time.Parse(huds.ServeDateLayout, date)
+	if err != nil {
+		return fmt.Errorf("invalid serve date %q: %v", date, err)
+	}
+	meals, err := encodeMeals(menu)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO menu_revisions (serve_date, revision, recorded_at, meals)
+		SELECT $1, coalesce(max(revision), 0) + 1, $2, $3 FROM menu_revisions WHERE serve_date = $1`,
+		t, recordedAt, meals)
+	if err != nil {
+		return fmt.Errorf("failed to store revision of %s: %v", date, err)
+	}
+	return nil
+}
+
+func (s *PostgresMenuStore) Revisions(ctx context.Context, date string) ([]MenuRevision, error) {
+	t, err := time.Parse(huds.ServeDateLayout, date)
+	if err != nil {
+		return nil, fmt.Errorf("invalid serve date %q: %v", date, err)
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT revision, recorded_at, meals FROM menu_revisions WHERE serve_date = $1 ORDER BY revision`, t)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var revisions []MenuRevision
+	for rows.Next() {
+		var revision MenuRevision
+		var data []byte
+		if err := rows.Scan(&revision.Revision, &revision.RecordedAt, &data); err != nil {
+			return nil, err
+		}
+		revision.ServeDate = date
+		if revision.Menu, err = decodeMeals(data, date); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, rows.Err()
+}
+
+func (s *PostgresMenuStore) AppendEvents(ctx context.Context, events []MenuEvent) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, event := range events {
+		_, err := tx.ExecContext(ctx, `INSERT INTO menu_events (type, serve_date, meal, added, removed, created_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+			event.Type, event.ServeDate, event.Meal, pq.Array(event.Added), pq.Array(event.Removed), event.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to store events: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresMenuStore) EventsAfter(ctx context.Context, after int64, limit int) ([]MenuEvent, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, type, serve_date, meal, added, removed, created_at
+		FROM menu_events WHERE id > $1 ORDER BY id LIMIT $2`, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []MenuEvent{}
+	for rows.Next() {
+		var event MenuEvent
+		if err := rows.Scan(&event.ID, &event.Type, &event.ServeDate, &event.Meal, pq.Array(&event.Added), pq.Array(&event.Removed), &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// AcquireLock compares expiry against the database clock, so replicas with
+// skewed clocks still agree on when a lease has run out. Expired leases are
+// cleared out on the way.
+func (s *PostgresMenuStore) AcquireLock(ctx context.Context, name string, owner string, ttl time.Duration) (bool, error) {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM job_locks WHERE expires_at <= now()`); err != nil {
+		return false, fmt.Errorf("failed to clear expired locks: %v", err)
+	}
+	result, err := s.db.ExecContext(ctx, `INSERT INTO job_locks (name, owner, expires_at) VALUES ($1, $2, now() + $3 * interval '1 millisecond')
+		ON CONFLICT (name) DO UPDATE SET owner = excluded.owner, expires_at = excluded.expires_at
+		WHERE job_locks.expires_at <= now() OR job_locks.owner = excluded.owner`,
+		name, owner, ttl.Milliseconds())
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lock %s: %v", name, err)
+	}
+	acquired, err := result.RowsAffected()
+	return acquired == 1, err
+}
+
+func (s *PostgresMenuStore) Counts(ctx context.Context) (map[string]int64, error) {
+	return countTables(ctx, s.db)
+}
+
+func (s *PostgresMenuStore) PruneBefore(ctx context.Context, cutoff time.Time) (map[string]int64, error) {
+	return pruneTables(ctx, s.db, "$1", cutoff, cutoff)
+}
+
+func (s *PostgresMenuStore) FoodFrequency(ctx context.Context, start time.Time, end time.Time, limit int) ([]FoodFrequency, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT food_name, count(*), count(DISTINCT serve_date),
+			count(*) FILTER (WHERE meal = 'breakfast'),
+			count(*) FILTER (WHERE meal = 'lunch'),
+			count(*) FILTER (WHERE meal = 'dinner')
+		FROM served_items WHERE serve_date BETWEEN $1 AND $2
+		GROUP BY food_name ORDER BY count(*) DESC, food_name LIMIT $3`, start, end, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	foods := []FoodFrequency{}
+	for rows.Next() {
+		var food FoodFrequency
+		if err := rows.Scan(&food.FoodName, &food.Count, &food.Days, &food.Breakfast, &food.Lunch, &food.Dinner); err != nil {
+			return nil, err
+		}
+		foods = append(foods, food)
+	}
+	return foods, rows.Err()
+}
+
+func (s *PostgresMenuStore) Summary(ctx context.Context, start time.Time, end time.Time) (AnalyticsSummary, error) {
+	summary := AnalyticsSummary{TopEntrees: []FoodFrequency{}, MealSizes: []MealSize{}, VeganShare: []VeganShare{}}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT food_name, count(*) FROM served_items
+		WHERE serve_date BETWEEN $1 AND $2 AND menu_category ~* 'entr[eé]e'
+		GROUP BY food_name ORDER BY count(*) DESC, food_name LIMIT 10`, start, end)
+	if err != nil {
+		return summary, err
+	}
+	for rows.Next() {
+		var food FoodFrequency
+		if err := rows.Scan(&food.FoodName, &food.Count); err != nil {
+			rows.Close()
+			return summary, err
+		}
+		summary.TopEntrees = append(summary.TopEntrees, food)
+	}
+	rows.Close()
+
+	rows, err = s.db.QueryContext(ctx, `SELECT meal, avg(items) FROM (
+			SELECT serve_date, meal, count(*) AS items FROM served_items
+			WHERE serve_date BETWEEN $1 AND $2 GROUP BY serve_date, meal
+		) meals GROUP BY meal ORDER BY meal`, start, end)
+	if err != nil {
+		return summary, err
+	}
+	for rows.Next() {
+		var size MealSize
+		if err := rows.Scan(&size.Meal, &size.AverageItems); err != nil {
+			rows.Close()
+			return summary, err
+		}
+		summary.MealSizes = append(summary.MealSizes, size)
+	}
+	rows.Close()
+
+	rows, err = s.db.QueryContext(ctx, `SELECT to_char(serve_date, 'YYYY-MM') AS month, count(*),
+			round(100.0 * count(*) FILTER (WHERE vegan) / count(*), 1),
+			round(100.0 * count(*) FILTER (WHERE vegetarian) / count(*), 1)
+		FROM served_items WHERE serve_date BETWEEN $1 AND $2
+		GROUP BY month ORDER BY month`, start, end)
+	if err != nil {
+		return summary, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var share VeganShare
+		if err := rows.Scan(&share.Month, &share.Items, &share.VeganPercent, &share.VegetarianPercent); err != nil {
+			return summary, err
+		}
+		summary.VeganShare = append(summary.VeganShare, share)
+	}
+	return summary, rows.Err()
+}
+
+func (s *PostgresMenuStore) CategoryLastServed(ctx context.Context, category string, day time.Time) (string, error) {
+	var date time.Time
+	err := s.db.QueryRowContext(ctx, `SELECT max(serve_date) FROM served_items
+		WHERE lower(menu_category) = lower($1) AND serve_date <= $2
+		HAVING max(serve_date) IS NOT NULL`, category, day).Scan(&date)
+	if err == sql.ErrNoRows {
+		return "", ErrMenuNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return date.Format(huds.ServeDateLayout), nil
+}
@@ -0,0 +1,66 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"hudsgry-api/internal/huds"
+	"io"
+	"time"
+)
+
+// RawArchive is implemented by stores that keep the upstream feed as it was
+// fetched, one gzipped payload per serve date, so stored menus can be rebuilt
+// when condensing improves instead of losing the fields it drops.
+type RawArchive interface {
+	// ArchiveRaw replaces the raw payload of every serve date in items.
+	ArchiveRaw(ctx context.Context, fetchedAt time.Time, items []huds.MenuItem) error
+	// GetRaw returns the raw payload archived for a serve date.
+	GetRaw(ctx context.Context, date string) ([]huds.MenuItem, error)
+	// RawDates lists the archived serve dates from start to end inclusive,
+	// in chronological order.
+	RawDates(ctx context.Context, start time.Time, end time.Time) ([]string, error)
+}
+
+// rawByDate groups a feed by serve date, skipping items without a valid one.
+func rawByDate(items []huds.MenuItem) map[string][]huds.MenuItem {
+	days := make(map[string][]huds.MenuItem)
+	for _, item := range items {
+		if _, err := time.Parse(huds.ServeDateLayout, item.ServeDate); err != nil {
+			continue
+		}
+		days[item.ServeDate] = append(days[item.ServeDate], item)
+	}
+	return days
+}
+
+// compressRaw encodes a day's payload as gzipped JSON.
+func compressRaw(items []huds.MenuItem) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(w).Encode(items); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompressRaw(data []byte) ([]huds.MenuItem, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var items []huds.MenuItem
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
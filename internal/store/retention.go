@@ -0,0 +1,124 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hudsgry-api/internal/huds"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultRetentionSchedule prunes once a night, after the early refreshes
+const defaultRetentionSchedule = "30 4 * * *"
+
+// Pruner is implemented by stores that can delete old history. PruneBefore
+// removes everything about serve dates before cutoff, and events created
+// before it, returning how much it deleted keyed by collection or table name.
+type Pruner interface {
+	PruneBefore(ctx context.Context, cutoff time.Time) (map[string]int64, error)
+}
+
+// RetentionPolicy is how much history to keep. The zero policy keeps
+// everything.
+type RetentionPolicy struct {
+	// Keep is the policy as configured, e.g. 2y
+	Keep   string
+	years  int
+	months int
+	days   int
+	// Schedule is the cron spec the cleanup runs on
+	Schedule string
+}
+
+// LoadRetentionPolicy reads RETENTION, a count of years, months, weeks or
+// days such as 2y, 18mo, 26w or 120d, and RETENTION_SCHEDULE, which defaults
+// to nightly. Without RETENTION nothing is deleted.
+func LoadRetentionPolicy() (RetentionPolicy, error) {
+	policy := RetentionPolicy{Keep: strings.TrimSpace(os.Getenv("RETENTION")), Schedule: os.Getenv("RETENTION_SCHEDULE")}
+	if policy.Schedule == "" {
+		policy.Schedule = defaultRetentionSchedule
+	}
+	if policy.Keep == "" {
+		return policy, nil
+	}
+	number := strings.TrimRight(policy.Keep, "abcdefghijklmnopqrstuvwxyz")
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 1 {
+		return policy, fmt.Errorf("RETENTION must be a positive number of years, months, weeks or days such as 2y, 18mo, 26w or 120d, got %q", policy.Keep)
+	}
+	switch policy.Keep[len(number):] {
+	case "y":
+		policy.years = n
+	case "mo":
+		policy.months = n
+	case "w":
+		policy.days = 7 * n
+	case "d":
+		policy.days = n
+	default:
+		return policy, fmt.Errorf("RETENTION must be a positive number of years, months, weeks or days such as 2y, 18mo, 26w or 120d, got %q", policy.Keep)
+	}
+	return policy, nil
+}
+
+// Enabled reports whether anything is ever deleted.
+func (p RetentionPolicy) Enabled() bool {
+	return p.years > 0 || p.months > 0 || p.days > 0
+}
+
+// Cutoff is the earliest serve date kept when today is today.
+func (p RetentionPolicy) Cutoff(today time.Time) time.Time {
+	day := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+	return day.AddDate(-p.years, -p.months, -p.days)
+}
+
+// servedBefore reports whether a serve date falls before cutoff. Dates that
+// don't parse are kept.
+func servedBefore(date string, cutoff time.Time) bool {
+	t, err := time.Parse(huds.ServeDateLayout, date)
+	return err == nil && t.Before(cutoff)
+}
+
+// sqlRetention are the tables both SQL stores prune, with the column dated by.
+// Events are pruned by when they were created, as their serve dates are
+// stored as sent.
+var sqlRetention = []struct {
+	table  string
+	column string
+}{
+	{"menus", "serve_date"},
+	{"location_menus", "serve_date"},
+	{"served_items", "serve_date"},
+	{"menu_revisions", "serve_date"},
+	{"raw_menus", "serve_date"},
+	{"weeks", "week_start"},
+	{"menu_events", "created_at"},
+}
+
+// pruneTables deletes the rows dated before cutoff in one transaction. day
+// and instant are cutoff as each store binds a date and a timestamp, and
+// placeholder is its parameter marker.
+func pruneTables(ctx context.Context, db *sql.DB, placeholder string, day interface{}, instant interface{}) (map[string]int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	deleted := make(map[string]int64)
+	for _, dated := range sqlRetention {
+		cutoff := day
+		if dated.column == "created_at" {
+			cutoff = instant
+		}
+		result, err := tx.ExecContext(ctx, "DELETE FROM "+dated.table+" WHERE "+dated.column+" < "+placeholder, cutoff)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prune %s: %v", dated.table, err)
+		}
+		deleted[dated.table], _ = result.RowsAffected()
+	}
+	return deleted, tx.Commit()
+}
@@ -0,0 +1,25 @@
+package store
+
+import (
+	"context"
+	"hudsgry-api/internal/huds"
+	"time"
+)
+
+// MenuRevision is a serve date's menu as it was stored at one point in time.
+type MenuRevision struct {
+	ServeDate  string             `bson:"serve_date"`
+	Revision   int                `bson:"revision"`
+	RecordedAt time.Time          `bson:"recorded_at"`
+	Menu       huds.CondensedMenu `bson:"menu"`
+}
+
+// RevisionStore is implemented by stores that keep a snapshot of each version
+// of a day's menu, since Upsert overwrites what was there.
+type RevisionStore interface {
+	// AddRevision stores a snapshot as the date's next revision, numbering
+	// from 1.
+	AddRevision(ctx context.Context, date string, recordedAt time.Time, menu huds.CondensedMenu) error
+	// Revisions returns every snapshot of a date's menu, oldest first.
+	Revisions(ctx context.Context, date string) ([]MenuRevision, error)
+}
@@ -0,0 +1,257 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/mongo"
+	"hudsgry-api/internal/huds"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxDivergentDates is how many of the latest dates found to differ between
+// the stores are kept for ShadowStats.
+const maxDivergentDates = 20
+
+// Shadow is a second MenuStore written alongside the primary while migrating
+// to another backend, or to a new schema, before cutting over. Its failures
+// never fail a write to the primary; they are counted, as are the reads and
+// verifications that found the two stores apart.
+type Shadow struct {
+	backend      string
+	store        MenuStore
+	compareReads bool
+
+	mu    sync.Mutex
+	stats ShadowStats
+}
+
+// ShadowStats say how far the shadow store has kept up with the primary.
+type ShadowStats struct {
+	Backend      string `json:"backend"`
+	CompareReads bool   `json:"compare_reads"`
+	Writes       int    `json:"writes"`
+	WriteErrors  int    `json:"write_errors"`
+	// Compared counts the days read or verified against the shadow store:
+	// Matching had the same meals in both, Divergent differed and Missing
+	// weren't in the shadow store at all
+	Compared   int `json:"compared"`
+	Matching   int `json:"matching"`
+	Divergent  int `json:"divergent"`
+	Missing    int `json:"missing"`
+	ReadErrors int `json:"read_errors"`
+	// DivergentDates are the latest days found to differ or be missing,
+	// newest first
+	DivergentDates []string   `json:"divergent_dates"`
+	LastWriteError string     `json:"last_write_error,omitempty"`
+	LastDivergence *time.Time `json:"last_divergence,omitempty"`
+}
+
+// ShadowComparison is how one day compared between the stores.
+type ShadowComparison string
+
+const (
+	ShadowMatching  ShadowComparison = "matching"
+	ShadowDivergent ShadowComparison = "divergent"
+	ShadowMissing   ShadowComparison = "missing"
+)
+
+// OpenShadow opens the shadow store SHADOW_STORE names, one of the same
+// backends as Open. As it may be the same kind of store as the primary, it
+// has settings of its own: a mongo shadow uses the SHADOW_MONGODB_DATABASE
+// database (huds_shadow by default) through the primary's client, postgres
+// needs SHADOW_POSTGRES_URL and sqlite uses SHADOW_SQLITE_PATH
+// (huds_shadow.db by default). SHADOW_COMPARE_READS=true compares each day
+// read from the primary with the shadow store's copy in the background.
+func OpenShadow(ctx context.Context, backend string, client *mongo.Client, opts OpenOptions) (*Shadow, error) {
+	var store MenuStore
+	var err error
+	switch backend {
+	case "mongo":
+		if client == nil {
+			return nil, errors.New("MONGODB_URI must be set when SHADOW_STORE is mongo")
+		}
+		name := os.Getenv("SHADOW_MONGODB_DATABASE")
+		if name == "" {
+			name = "huds_shadow"
+		}
+		if name == "huds" {
+			return nil, errors.New("SHADOW_MONGODB_DATABASE must not be the primary's huds database")
+		}
+		store = NewMongoMenuStore(ctx, client.Database(name), opts)
+	case "postgres":
+		url := os.Getenv("SHADOW_POSTGRES_URL")
+		if url == "" {
+			return nil, errors.New("SHADOW_POSTGRES_URL must be set when SHADOW_STORE is postgres")
+		}
+		if url == os.Getenv("POSTGRES_URL") {
+			return nil, errors.New("SHADOW_POSTGRES_URL must not be the primary's POSTGRES_URL")
+		}
+		store, err = NewPostgresMenuStore(ctx, url, opts)
+	case "sqlite":
+		path := os.Getenv("SHADOW_SQLITE_PATH")
+		if path == "" {
+			path = "huds_shadow.db"
+		}
+		store, err = NewSQLiteMenuStore(ctx, path, opts)
+	case "memory":
+		store = NewMemoryMenuStore()
+	default:
+		return nil, fmt.Errorf("SHADOW_STORE must be mongo, postgres, sqlite or memory, got %q", backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewShadow(backend, store, os.Getenv("SHADOW_COMPARE_READS") == "true"), nil
+}
+
+// NewShadow shadows the primary with store, which is a backend of the given
+// name.
+func NewShadow(backend string, store MenuStore, compareReads bool) *Shadow {
+	return &Shadow{
+		backend:      backend,
+		store:        store,
+		compareReads: compareReads,
+		stats:        ShadowStats{Backend: backend, CompareReads: compareReads, DivergentDates: []string{}},
+	}
+}
+
+// CompareReads reports whether reads from the primary should be compared.
+func (s *Shadow) CompareReads() bool {
+	return s.compareReads
+}
+
+// Upsert writes menus the primary has just stored.
+func (s *Shadow) Upsert(ctx context.Context, menus []huds.CondensedMenu) {
+	s.recordWrite(s.store.Upsert(ctx, menus))
+}
+
+// UpsertLocations writes location menus the primary has just stored.
+func (s *Shadow) UpsertLocations(ctx context.Context, data map[string]map[string]huds.LocationMenu) {
+	s.recordWrite(s.store.UpsertLocations(ctx, data))
+}
+
+// PruneBefore deletes the history the primary has just pruned, when the
+// shadow store can prune, so that verifying doesn't count it as kept only by
+// the shadow.
+func (s *Shadow) PruneBefore(ctx context.Context, cutoff time.Time) {
+	if pruner, ok := s.store.(Pruner); ok {
+		_, err := pruner.PruneBefore(ctx, cutoff)
+		s.recordWrite(err)
+	}
+}
+
+func (s *Shadow) recordWrite(err error) {
+	if err != nil {
+		log.Printf("Failed to write to the %s shadow store: %v\n", s.backend, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Writes++
+	if err != nil {
+		s.stats.WriteErrors++
+		s.stats.LastWriteError = err.Error()
+	}
+}
+
+// Compare checks a day read from the primary against the shadow store's
+// copy. Only the meals count: when each store last saved the day doesn't.
+func (s *Shadow) Compare(ctx context.Context, primary huds.CondensedMenu) (ShadowComparison, error) {
+	shadow, err := s.store.GetByDate(ctx, primary.ServeDate)
+	if err == ErrMenuNotFound {
+		return s.record(primary.ServeDate, ShadowMissing), nil
+	}
+	if err != nil {
+		s.mu.Lock()
+		s.stats.ReadErrors++
+		s.mu.Unlock()
+		return "", err
+	}
+	return s.record(primary.ServeDate, compareMenus(primary, shadow)), nil
+}
+
+// Verify compares every day the primary has from start to end inclusive,
+// returning how each compared by date. With repair, the days that differ are
+// copied from the primary to the shadow store, as when seeding it; location
+// menus are neither compared nor copied.
+func (s *Shadow) Verify(ctx context.Context, primary MenuStore, start string, end string, repair bool) (map[string]ShadowComparison, error) {
+	menus, err := primary.GetRange(ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+	shadowMenus, err := s.store.GetRange(ctx, start, end)
+	if err != nil {
+		s.mu.Lock()
+		s.stats.ReadErrors++
+		s.mu.Unlock()
+		return nil, err
+	}
+	shadowByDate := make(map[string]huds.CondensedMenu, len(shadowMenus))
+	for _, menu := range shadowMenus {
+		shadowByDate[menu.ServeDate] = menu
+	}
+	results := make(map[string]ShadowComparison, len(menus))
+	var stale []huds.CondensedMenu
+	for _, menu := range menus {
+		result := ShadowMissing
+		if shadow, ok := shadowByDate[menu.ServeDate]; ok {
+			result = compareMenus(menu, shadow)
+		}
+		results[menu.ServeDate] = s.record(menu.ServeDate, result)
+		if result != ShadowMatching {
+			stale = append(stale, menu)
+		}
+	}
+	if repair && len(stale) > 0 {
+		err := s.store.Upsert(ctx, stale)
+		s.recordWrite(err)
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+func compareMenus(primary huds.CondensedMenu, shadow huds.CondensedMenu) ShadowComparison {
+	if huds.ContentHash(primary, nil) != huds.ContentHash(shadow, nil) {
+		return ShadowDivergent
+	}
+	return ShadowMatching
+}
+
+func (s *Shadow) record(date string, result ShadowComparison) ShadowComparison {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Compared++
+	switch result {
+	case ShadowMatching:
+		s.stats.Matching++
+		return result
+	case ShadowDivergent:
+		s.stats.Divergent++
+	case ShadowMissing:
+		s.stats.Missing++
+	}
+	now := time.Now()
+	s.stats.LastDivergence = &now
+	dates := []string{date}
+	for _, seen := range s.stats.DivergentDates {
+		if seen != date && len(dates) < maxDivergentDates {
+			dates = append(dates, seen)
+		}
+	}
+	s.stats.DivergentDates = dates
+	return result
+}
+
+// Stats returns the shadow store's counters.
+func (s *Shadow) Stats() ShadowStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats
+	stats.DivergentDates = append([]string{}, s.stats.DivergentDates...)
+	return stats
+}
@@ -0,0 +1,815 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	_ "github.com/mattn/go-sqlite3"
+	"hudsgry-api/internal/huds"
+	"strings"
+	"time"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS menus (
+	serve_date TEXT PRIMARY KEY,
+	meals TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS location_menus (
+	serve_date TEXT NOT NULL,
+	location TEXT NOT NULL,
+	name TEXT NOT NULL,
+	meals TEXT NOT NULL,
+	PRIMARY KEY (serve_date, location)
+);
+
+CREATE TABLE IF NOT EXISTS served_items (
+	serve_date TEXT NOT NULL,
+	meal TEXT NOT NULL,
+	food_name TEXT NOT NULL COLLATE NOCASE,
+	item_id INTEGER,
+	menu_category TEXT NOT NULL DEFAULT '' COLLATE NOCASE,
+	ingredients TEXT NOT NULL DEFAULT '',
+	vegan INTEGER NOT NULL DEFAULT 0,
+	vegetarian INTEGER NOT NULL DEFAULT 0,
+	calories REAL,
+	PRIMARY KEY (serve_date, meal, food_name)
+);
+
+CREATE TABLE IF NOT EXISTS menu_revisions (
+	serve_date TEXT NOT NULL,
+	revision INTEGER NOT NULL,
+	recorded_at TEXT NOT NULL,
+	meals TEXT NOT NULL,
+	PRIMARY KEY (serve_date, revision)
+);
+
+CREATE TABLE IF NOT EXISTS menu_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	type TEXT NOT NULL,
+	serve_date TEXT NOT NULL,
+	meal TEXT NOT NULL,
+	added TEXT NOT NULL,
+	removed TEXT NOT NULL,
+	created_at TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS raw_menus (
+	serve_date TEXT PRIMARY KEY,
+	fetched_at TEXT NOT NULL,
+	items BLOB NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS weeks (
+	week_start TEXT PRIMARY KEY,
+	built_at TEXT NOT NULL,
+	days TEXT NOT NULL,
+	summary TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS recipe_tags (
+	recipe_number TEXT PRIMARY KEY,
+	tags TEXT NOT NULL,
+	updated_at TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS overrides (
+	id TEXT PRIMARY KEY,
+	override TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS hours (
+	id TEXT PRIMARY KEY,
+	schedule TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS job_locks (
+	name TEXT PRIMARY KEY,
+	owner TEXT NOT NULL,
+	expires_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS served_items_food_name ON served_items (food_name, serve_date);
+CREATE INDEX IF NOT EXISTS served_items_item_id ON served_items (item_id);
+`
+
+// isoDateLayout is how the SQLite store writes serve dates, so that they sort
+// and compare as text.
+const isoDateLayout = "2006-01-02"
+
+// maxSearchTerms caps how many words of a query the SQLite store matches on.
+const maxSearchTerms = 8
+
+// SQLiteMenuStore keeps everything in one local database file, so the service
+// can run without any database server.
+type SQLiteMenuStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteMenuStore opens the database file at path, creating it and
+// bringing its schema up to date within ctx, or with opts.ReadOnly opens an
+// existing file read-only.
+func NewSQLiteMenuStore(ctx context.Context, path string, opts OpenOptions) (*SQLiteMenuStore, error) {
+	if opts.ReadOnly {
+		db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro&_busy_timeout=5000")
+		if err != nil {
+			return nil, err
+		}
+		if err := db.PingContext(ctx); err != nil {
+			return nil, fmt.Errorf("failed to open %s read-only: %v", path, err)
+		}
+		return &SQLiteMenuStore{db: db}, nil
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer at a time anyway
+	db.SetMaxOpenConns(1)
+	if _, err := db.ExecContext(ctx, sqliteSchema); err != nil {
+		return nil, fmt.Errorf("failed to create SQLite schema in %s: %v", path, err)
+	}
+	store := &SQLiteMenuStore{db: db}
+	if err := store.addServedCalories(ctx); err != nil {
+		return nil, fmt.Errorf("failed to add calories to served items in %s: %v", path, err)
+	}
+	return store, nil
+}
+
+// addServedCalories adds the calories column to databases created before it
+// existed, re-indexing every stored menu to fill it in.
+func (s *SQLiteMenuStore) addServedCalories(ctx context.Context) error {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `SELECT count(*) > 0 FROM pragma_table_info('served_items') WHERE name = 'calories'`).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, `ALTER TABLE served_items ADD COLUMN calories REAL`); err != nil {
+		return err
+	}
+	return reindexServedItems(ctx, s)
+}
+
+// isoDate converts a serve date to the layout stored in SQLite.
+func isoDate(date string) (string, error) {
+	t, err := time.Parse(huds.ServeDateLayout, date)
+	if err != nil {
+		return "", fmt.Errorf("invalid serve date %q: %v", date, err)
+	}
+	return t.Format(isoDateLayout), nil
+}
+
+func serveDateFromISO(date string) string {
+	t, err := time.Parse(isoDateLayout, date)
+	if err != nil {
+		return date
+	}
+	return t.Format(huds.ServeDateLayout)
+}
+
+func (s *SQLiteMenuStore) GetByDate(ctx context.Context, date string) (huds.CondensedMenu, error) {
+	day, err := isoDate(date)
+	if err != nil {
+		return huds.CondensedMenu{}, err
+	}
+	var data []byte
+	err = s.db.QueryRowContext(ctx, `SELECT meals FROM menus WHERE serve_date = ?`, day).Scan(&data)
+	if err == sql.ErrNoRows {
+		return huds.CondensedMenu{}, ErrMenuNotFound
+	}
+	if err != nil {
+		return huds.CondensedMenu{}, err
+	}
+	return decodeMeals(data, date)
+}
+
+func (s *SQLiteMenuStore) GetRange(ctx context.Context, start string, end string) ([]huds.CondensedMenu, error) {
+	startDay, err := isoDate(start)
+	if err != nil {
+		return nil, err
+	}
+	endDay, err := isoDate(end)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT serve_date, meals FROM menus WHERE serve_date BETWEEN ? AND ? ORDER BY serve_date`, startDay, endDay)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var menus []huds.CondensedMenu
+	for rows.Next() {
+		var day string
+		var data []byte
+		if err := rows.Scan(&day, &data); err != nil {
+			return nil, err
+		}
+		menu, err := decodeMeals(data, serveDateFromISO(day))
+		if err != nil {
+			return nil, err
+		}
+		menus = append(menus, menu)
+	}
+	return menus, rows.Err()
+}
+
+// Upsert replaces each day's menu and its served items in one transaction.
+func (s *SQLiteMenuStore) Upsert(ctx context.Context, menus []huds.CondensedMenu) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, menu := range menus {
+		day, err := isoDate(menu.ServeDate)
+		if err != nil {
+			continue
+		}
+		data, err := encodeMeals(menu)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO menus (serve_date, meals) VALUES (?, ?)
+			ON CONFLICT (serve_date) DO UPDATE SET meals = excluded.meals`, day, data)
+		if err != nil {
+			return fmt.Errorf("failed to store menu for %s: %v", menu.ServeDate, err)
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM served_items WHERE serve_date = ?`, day); err != nil {
+			return err
+		}
+		for _, meal := range menu.Meals() {
+			for _, item := range huds.MealItems(menu, meal) {
+				_, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO served_items
+					(serve_date, meal, food_name, item_id, menu_category, ingredients, vegan, vegetarian, calories)
+					VALUES (?, ?, ?, NULLIF(?, 0), ?, ?, ?, ?, ?)`,
+					day, meal, item.FoodName, item.ID, item.MenuCategory, item.Ingredients, item.Vegan, item.Vegetarian, itemCalories(item))
+				if err != nil {
+					return fmt.Errorf("failed to index served items: %v", err)
+				}
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteMenuStore) EarliestLatest(ctx context.Context) (string, string, error) {
+	var earliest, latest sql.NullString
+	err := s.db.QueryRowContext(ctx, `SELECT min(serve_date), max(serve_date) FROM menus`).Scan(&earliest, &latest)
+	if err != nil || !earliest.Valid {
+		return "", "", err
+	}
+	return serveDateFromISO(earliest.String), serveDateFromISO(latest.String), nil
+}
+
+// Search scores each query word found in the food name, category or
+// ingredients, weighted the same way as the Mongo text index. SQLite has no
+// stemming, so words are matched as substrings instead.
+func (s *SQLiteMenuStore) Search(ctx context.Context, query SearchQuery) ([]SearchResult, error) {
+	terms := strings.Fields(strings.ToLower(query.Text))
+	if len(terms) > maxSearchTerms {
+		terms = terms[:maxSearchTerms]
+	}
+	if len(terms) == 0 {
+		return []SearchResult{}, nil
+	}
+	var scores []string
+	var args []interface{}
+	for _, term := range terms {
+		pattern := "%" + term + "%"
+		scores = append(scores, `(CASE WHEN lower(food_name) LIKE ? THEN 10 ELSE 0 END +
+			CASE WHEN lower(menu_category) LIKE ? THEN 3 ELSE 0 END +
+			CASE WHEN lower(ingredients) LIKE ? THEN 1 ELSE 0 END)`)
+		args = append(args, pattern, pattern, pattern)
+	}
+	where := []string{"score > 0"}
+	if !query.Start.IsZero() {
+		where = append(where, "serve_date >= ?")
+		args = append(args, query.Start.Format(isoDateLayout))
+	}
+	if !query.End.IsZero() {
+		where = append(where, "serve_date <= ?")
+		args = append(args, query.End.Format(isoDateLayout))
+	}
+	if query.Meal != "" {
+		where = append(where, "meal = ?")
+		args = append(args, query.Meal)
+	}
+	args = append(args, query.Limit)
+
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`SELECT * FROM (
+			SELECT serve_date, meal, food_name, coalesce(item_id, 0), menu_category, vegan, vegetarian, calories, %s AS score
+			FROM served_items
+		) WHERE %s ORDER BY %s LIMIT ?`,
+		strings.Join(scores, " + "), strings.Join(where, " AND "), sqlSearchOrder(query)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := []SearchResult{}
+	for rows.Next() {
+		var day string
+		var result SearchResult
+		err := rows.Scan(&day, &result.Meal, &result.FoodName, &result.ItemID, &result.MenuCategory,
+			&result.Vegan, &result.Vegetarian, &result.Calories, &result.Score)
+		if err != nil {
+			return nil, err
+		}
+		result.Date, _ = time.Parse(isoDateLayout, day)
+		result.ServeDate = serveDateFromISO(day)
+		result.Key = result.ServeDate + "|" + result.Meal + "|" + strings.ToLower(result.FoodName)
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+func (s *SQLiteMenuStore) ItemByID(ctx context.Context, id int) (huds.CondensedMenuItem, string, string, error) {
+	var day, meal string
+	err := s.db.QueryRowContext(ctx, `SELECT serve_date, meal FROM served_items WHERE item_id = ?
+		ORDER BY serve_date DESC LIMIT 1`, id).Scan(&day, &meal)
+	if err == sql.ErrNoRows {
+		return huds.CondensedMenuItem{}, "", "", ErrMenuNotFound
+	}
+	if err != nil {
+		return huds.CondensedMenuItem{}, "", "", err
+	}
+	serveDate := serveDateFromISO(day)
+	menu, err := s.GetByDate(ctx, serveDate)
+	if err != nil {
+		return huds.CondensedMenuItem{}, "", "", err
+	}
+	for _, item := range huds.MealItems(menu, meal) {
+		if item.ID == id {
+			return item, serveDate, meal, nil
+		}
+	}
+	return huds.CondensedMenuItem{}, "", "", ErrMenuNotFound
+}
+
+func (s *SQLiteMenuStore) FoodOccurrences(ctx context.Context, name string, day time.Time, ahead bool, limit int) ([]Occurrence, error) {
+	comparison, direction := "<=", "DESC"
+	if ahead {
+		comparison, direction = ">", "ASC"
+	}
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`SELECT serve_date, group_concat(DISTINCT meal) FROM served_items
+		WHERE food_name = ? AND serve_date %s ?
+		GROUP BY serve_date ORDER BY serve_date %s LIMIT ?`, comparison, direction), name, day.Format(isoDateLayout), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	occurrences := []Occurrence{}
+	for rows.Next() {
+		var date, meals string
+		if err := rows.Scan(&date, &meals); err != nil {
+			return nil, err
+		}
+		occurrence := Occurrence{ServeDate: serveDateFromISO(date), Meals: strings.Split(meals, ",")}
+		huds.SortMeals(occurrence.Meals)
+		occurrences = append(occurrences, occurrence)
+	}
+	return occurrences, rows.Err()
+}
+
+func (s *SQLiteMenuStore) GetLocations(ctx context.Context, date string) (map[string]huds.LocationMenu, error) {
+	day, err := isoDate(date)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT location, name, meals FROM location_menus WHERE serve_date = ?`, day)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	locations := make(map[string]huds.LocationMenu)
+	for rows.Next() {
+		var key, name string
+		var data []byte
+		if err := rows.Scan(&key, &name, &data); err != nil {
+			return nil, err
+		}
+		menu, err := decodeMeals(data, date)
+		if err != nil {
+			return nil, err
+		}
+		locations[key] = huds.LocationMenu{Name: name, Breakfast: menu.Breakfast, Lunch: menu.Lunch, Dinner: menu.Dinner, Extra: menu.Extra}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(locations) == 0 {
+		return nil, ErrMenuNotFound
+	}
+	return locations, nil
+}
+
+func (s *SQLiteMenuStore) UpsertLocations(ctx context.Context, data map[string]map[string]huds.LocationMenu) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for date, locations := range data {
+		day, err := isoDate(date)
+		if err != nil {
+			continue
+		}
+		for key, location := range locations {
+			meals, err := encodeMeals(huds.CondensedMenu{Breakfast: location.Breakfast, Lunch: location.Lunch, Dinner: location.Dinner, Extra: location.Extra})
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `INSERT INTO location_menus (serve_date, location, name, meals) VALUES (?, ?, ?, ?)
+				ON CONFLICT (serve_date, location) DO UPDATE SET name = excluded.name, meals = excluded.meals`,
+				day, key, location.Name, meals)
+			if err != nil {
+				return fmt.Errorf("failed to store location menus for %s: %v", date, err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteMenuStore) ArchiveRaw(ctx context.Context, fetchedAt time.Time, items []huds.MenuItem) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for date, day := range rawByDate(items) {
+		data, err := compressRaw(day)
+		if err != nil {
+			return err
+		}
+		iso, _ := isoDate(date)
+		_, err = tx.ExecContext(ctx, `INSERT INTO raw_menus (serve_date, fetched_at, items) VALUES (?, ?, ?)
+			ON CONFLICT (serve_date) DO UPDATE SET fetched_at = excluded.fetched_at, items = excluded.items`,
+			iso, fetchedAt.UTC().Format(time.RFC3339), data)
+		if err != nil {
+			return fmt.Errorf("failed to archive raw menus for %s: %v", date, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteMenuStore) UpsertWeeks(ctx context.Context, weeks []Week) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, week := range weeks {
+		start, err := isoDate(week.Start)
+		if err != nil {
+			return err
+		}
+		days, err := encodeWeekDays(week.Days)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO weeks (week_start, built_at, days, summary) VALUES (?, ?, ?, ?)
+			ON CONFLICT (week_start) DO UPDATE SET built_at = excluded.built_at, days = excluded.days, summary = excluded.summary`,
+			start, week.BuiltAt.UTC().Format(time.RFC3339), string(days), string(week.Summary))
+		if err != nil {
+			return fmt.Errorf("failed to store week of %s: %v", week.Start, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteMenuStore) GetWeek(ctx context.Context, start string) (Week, error) {
+	day, err := isoDate(start)
+	if err != nil {
+		return Week{}, err
+	}
+	var builtAt, days, summary string
+	err = s.db.QueryRowContext(ctx, `SELECT built_at, days, summary FROM weeks WHERE week_start = ?`, day).Scan(&builtAt, &days, &summary)
+	if err == sql.ErrNoRows {
+		return Week{}, ErrMenuNotFound
+	}
+	if err != nil {
+		return Week{}, err
+	}
+	week := Week{Start: start, Summary: []byte(summary)}
+	week.BuiltAt, _ = time.Parse(time.RFC3339, builtAt)
+	if week.Days, err = decodeWeekDays([]byte(days)); err != nil {
+		return Week{}, err
+	}
+	return week, nil
+}
+
+func (s *SQLiteMenuStore) ListTags(ctx context.Context) ([]RecipeTags, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT recipe_number, tags, updated_at FROM recipe_tags ORDER BY recipe_number`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := []RecipeTags{}
+	for rows.Next() {
+		var recipe RecipeTags
+		var names, updatedAt string
+		if err := rows.Scan(&recipe.RecipeNumber, &names, &updatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(names), &recipe.Tags); err != nil {
+			return nil, fmt.Errorf("failed to decode tags of recipe %s: %v", recipe.RecipeNumber, err)
+		}
+		recipe.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
+		tags = append(tags, recipe)
+	}
+	return tags, rows.Err()
+}
+
+func (s *SQLiteMenuStore) SetTags(ctx context.Context, tags RecipeTags) error {
+	if len(tags.Tags) == 0 {
+		_, err := s.db.ExecContext(ctx, `DELETE FROM recipe_tags WHERE recipe_number = ?`, tags.RecipeNumber)
+		return err
+	}
+	names, _ := json.Marshal(tags.Tags)
+	_, err := s.db.ExecContext(ctx, `INSERT INTO recipe_tags (recipe_number, tags, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (recipe_number) DO UPDATE SET tags = excluded.tags, updated_at = excluded.updated_at`,
+		tags.RecipeNumber, string(names), tags.UpdatedAt.UTC().Format(time.RFC3339))
+	return err
+}
+
+func (s *SQLiteMenuStore) ListOverrides(ctx context.Context) ([]Override, error) {
+	return listSQLOverrides(ctx, s.db)
+}
+
+func (s *SQLiteMenuStore) PutOverride(ctx context.Context, override Override) error {
+	data, err := json.Marshal(override)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO overrides (id, override) VALUES (?, ?)
+		ON CONFLICT (id) DO UPDATE SET override = excluded.override`, override.ID, string(data))
+	return err
+}
+
+func (s *SQLiteMenuStore) DeleteOverride(ctx context.Context, id string) error {
+	return deleteSQLByID(ctx, s.db, `DELETE FROM overrides WHERE id = ?`, id)
+}
+
+func (s *SQLiteMenuStore) ListHours(ctx context.Context) ([]HoursSchedule, error) {
+	return listSQLHours(ctx, s.db)
+}
+
+func (s *SQLiteMenuStore) PutHours(ctx context.Context, schedule HoursSchedule) error {
+	data, err := json.Marshal(schedule)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO hours (id, schedule) VALUES (?, ?)
+		ON CONFLICT (id) DO UPDATE SET schedule = excluded.schedule`, schedule.ID, string(data))
+	return err
+}
+
+func (s *SQLiteMenuStore) DeleteHours(ctx context.Context, id string) error {
+	return deleteSQLByID(ctx, s.db, `DELETE FROM hours WHERE id = ?`, id)
+}
+
+func (s *SQLiteMenuStore) GetRaw(ctx context.Context, date string) ([]huds.MenuItem, error) {
+	day, err := isoDate(date)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	err = s.db.QueryRowContext(ctx, `SELECT items FROM raw_menus WHERE serve_date = ?`, day).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, ErrMenuNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decompressRaw(data)
+}
+
+func (s *SQLiteMenuStore) RawDates(ctx context.Context, start time.Time, end time.Time) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT serve_date FROM raw_menus WHERE serve_date BETWEEN ? AND ? ORDER BY serve_date`,
+		start.Format(isoDateLayout), end.Format(isoDateLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var dates []string
+	for rows.Next() {
+		var day string
+		if err := rows.Scan(&day); err != nil {
+			return nil, err
+		}
+		dates = append(dates, serveDateFromISO(day))
+	}
+	return dates, rows.Err()
+}
+
+func (s *SQLiteMenuStore) AddRevision(ctx context.Context, date string, recordedAt time.Time, menu huds.CondensedMenu) error {
+	day, err := isoDate(date)
+	if err != nil {
+		return err
+	}
+	meals, err := encodeMeals(menu)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO menu_revisions (serve_date, revision, recorded_at, meals)
+		SELECT ?, coalesce(max(revision), 0) + 1, ?, ? FROM menu_revisions WHERE serve_date = ?`,
+		day, recordedAt.UTC().Format(time.RFC3339), meals, day)
+	if err != nil {
+		return fmt.Errorf("failed to store revision of %s: %v", date, err)
+	}
+	return nil
+}
+
+func (s *SQLiteMenuStore) Revisions(ctx context.Context, date string) ([]MenuRevision, error) {
+	day, err := isoDate(date)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT revision, recorded_at, meals FROM menu_revisions WHERE serve_date = ? ORDER BY revision`, day)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var revisions []MenuRevision
+	for rows.Next() {
+		var revision MenuRevision
+		var recordedAt string
+		var data []byte
+		if err := rows.Scan(&revision.Revision, &recordedAt, &data); err != nil {
+			return nil, err
+		}
+		revision.ServeDate = date
+		revision.RecordedAt, _ = time.Parse(time.RFC3339, recordedAt)
+		if revision.Menu, err = decodeMeals(data, date); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, rows.Err()
+}
+
+func (s *SQLiteMenuStore) AppendEvents(ctx context.Context, events []MenuEvent) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, event := range events {
+		added, _ := json.Marshal(event.Added)
+		removed, _ := json.Marshal(event.Removed)
+		_, err := tx.ExecContext(ctx, `INSERT INTO menu_events (type, serve_date, meal, added, removed, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+			event.Type, event.ServeDate, event.Meal, added, removed, event.CreatedAt.UTC().Format(time.RFC3339))
+		if err != nil {
+			return fmt.Errorf("failed to store events: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteMenuStore) EventsAfter(ctx context.Context, after int64, limit int) ([]MenuEvent, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, type, serve_date, meal, added, removed, created_at
+		FROM menu_events WHERE id > ? ORDER BY id LIMIT ?`, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []MenuEvent{}
+	for rows.Next() {
+		var event MenuEvent
+		var added, removed []byte
+		var createdAt string
+		if err := rows.Scan(&event.ID, &event.Type, &event.ServeDate, &event.Meal, &added, &removed, &createdAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(added, &event.Added); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(removed, &event.Removed); err != nil {
+			return nil, err
+		}
+		event.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// AcquireLock stores expiry as Unix nanoseconds so it compares as a number.
+// Expired leases are cleared out on the way.
+func (s *SQLiteMenuStore) AcquireLock(ctx context.Context, name string, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM job_locks WHERE expires_at <= ?`, now.UnixNano()); err != nil {
+		return false, fmt.Errorf("failed to clear expired locks: %v", err)
+	}
+	result, err := s.db.ExecContext(ctx, `INSERT INTO job_locks (name, owner, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET owner = excluded.owner, expires_at = excluded.expires_at
+		WHERE job_locks.expires_at <= ? OR job_locks.owner = excluded.owner`,
+		name, owner, now.Add(ttl).UnixNano(), now.UnixNano())
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lock %s: %v", name, err)
+	}
+	acquired, err := result.RowsAffected()
+	return acquired == 1, err
+}
+
+func (s *SQLiteMenuStore) Counts(ctx context.Context) (map[string]int64, error) {
+	return countTables(ctx, s.db)
+}
+
+func (s *SQLiteMenuStore) PruneBefore(ctx context.Context, cutoff time.Time) (map[string]int64, error) {
+	return pruneTables(ctx, s.db, "?", cutoff.Format(isoDateLayout), cutoff.UTC().Format(time.RFC3339))
+}
+
+func (s *SQLiteMenuStore) FoodFrequency(ctx context.Context, start time.Time, end time.Time, limit int) ([]FoodFrequency, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT food_name, count(*), count(DISTINCT serve_date),
+			sum(meal = 'breakfast'), sum(meal = 'lunch'), sum(meal = 'dinner')
+		FROM served_items WHERE serve_date BETWEEN ? AND ?
+		GROUP BY food_name COLLATE BINARY ORDER BY count(*) DESC, food_name LIMIT ?`,
+		start.Format(isoDateLayout), end.Format(isoDateLayout), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	foods := []FoodFrequency{}
+	for rows.Next() {
+		var food FoodFrequency
+		if err := rows.Scan(&food.FoodName, &food.Count, &food.Days, &food.Breakfast, &food.Lunch, &food.Dinner); err != nil {
+			return nil, err
+		}
+		foods = append(foods, food)
+	}
+	return foods, rows.Err()
+}
+
+func (s *SQLiteMenuStore) Summary(ctx context.Context, start time.Time, end time.Time) (AnalyticsSummary, error) {
+	summary := AnalyticsSummary{TopEntrees: []FoodFrequency{}, MealSizes: []MealSize{}, VeganShare: []VeganShare{}}
+	from, to := start.Format(isoDateLayout), end.Format(isoDateLayout)
+
+	rows, err := s.db.QueryContext(ctx, `SELECT food_name, count(*) FROM served_items
+		WHERE serve_date BETWEEN ? AND ? AND (menu_category LIKE '%entree%' OR menu_category LIKE '%entrée%')
+		GROUP BY food_name COLLATE BINARY ORDER BY count(*) DESC, food_name LIMIT 10`, from, to)
+	if err != nil {
+		return summary, err
+	}
+	for rows.Next() {
+		var food FoodFrequency
+		if err := rows.Scan(&food.FoodName, &food.Count); err != nil {
+			rows.Close()
+			return summary, err
+		}
+		summary.TopEntrees = append(summary.TopEntrees, food)
+	}
+	rows.Close()
+
+	rows, err = s.db.QueryContext(ctx, `SELECT meal, avg(items) FROM (
+			SELECT serve_date, meal, count(*) AS items FROM served_items
+			WHERE serve_date BETWEEN ? AND ? GROUP BY serve_date, meal
+		) GROUP BY meal ORDER BY meal`, from, to)
+	if err != nil {
+		return summary, err
+	}
+	for rows.Next() {
+		var size MealSize
+		if err := rows.Scan(&size.Meal, &size.AverageItems); err != nil {
+			rows.Close()
+			return summary, err
+		}
+		summary.MealSizes = append(summary.MealSizes, size)
+	}
+	rows.Close()
+
+	rows, err = s.db.QueryContext(ctx, `SELECT substr(serve_date, 1, 7) AS month, count(*),
+			round(100.0 * sum(vegan) / count(*), 1), round(100.0 * sum(vegetarian) / count(*), 1)
+		FROM served_items WHERE serve_date BETWEEN ? AND ?
+		GROUP BY month ORDER BY month`, from, to)
+	if err != nil {
+		return summary, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var share VeganShare
+		if err := rows.Scan(&share.Month, &share.Items, &share.VeganPercent, &share.VegetarianPercent); err != nil {
+			return summary, err
+		}
+		summary.VeganShare = append(summary.VeganShare, share)
+	}
+	return summary, rows.Err()
+}
+
+func (s *SQLiteMenuStore) CategoryLastServed(ctx context.Context, category string, day time.Time) (string, error) {
+	var last sql.NullString
+	err := s.db.QueryRowContext(ctx, `SELECT max(serve_date) FROM served_items WHERE menu_category = ? AND serve_date <= ?`,
+		category, day.Format(isoDateLayout)).Scan(&last)
+	if err != nil {
+		return "", err
+	}
+	if !last.Valid {
+		return "", ErrMenuNotFound
+	}
+	return serveDateFromISO(last.String), nil
+}
@@ -0,0 +1,357 @@
+// Package store keeps condensed menus in MongoDB, PostgreSQL, SQLite or memory.
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/mongo"
+	"hudsgry-api/internal/huds"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrMenuNotFound is returned by stores when nothing is stored for a date,
+// item or food.
+var ErrMenuNotFound = errors.New("menu not found")
+
+// MenuStore is everything the service needs from menu storage. Handlers go
+// through the server's store rather than talking to a database directly.
+type MenuStore interface {
+	// GetByDate returns the house default menu for a serve date.
+	GetByDate(ctx context.Context, date string) (huds.CondensedMenu, error)
+	// GetRange returns every stored menu from start to end inclusive, in
+	// chronological order.
+	GetRange(ctx context.Context, start string, end string) ([]huds.CondensedMenu, error)
+	// Upsert replaces the stored menus for each menu's serve date.
+	Upsert(ctx context.Context, menus []huds.CondensedMenu) error
+	// EarliestLatest returns the first and last stored serve dates, or empty
+	// strings when nothing is stored.
+	EarliestLatest(ctx context.Context) (string, string, error)
+	// Search ranks served items against a free-text query.
+	Search(ctx context.Context, query SearchQuery) ([]SearchResult, error)
+	// ItemByID finds an item by its upstream ID, with the serve date and meal
+	// it was most recently served at.
+	ItemByID(ctx context.Context, id int) (huds.CondensedMenuItem, string, string, error)
+	// FoodOccurrences groups a food's appearances by day, newest first when
+	// looking back from day or oldest first when looking ahead of it.
+	FoodOccurrences(ctx context.Context, name string, day time.Time, ahead bool, limit int) ([]Occurrence, error)
+	// GetLocations returns every location's menu for a date, keyed by
+	// location key.
+	GetLocations(ctx context.Context, date string) (map[string]huds.LocationMenu, error)
+	// UpsertLocations replaces location menus, keyed by date and location key.
+	UpsertLocations(ctx context.Context, data map[string]map[string]huds.LocationMenu) error
+}
+
+// AnalyticsStore is implemented by stores that can run the dataset-style
+// aggregations behind /analytics.
+type AnalyticsStore interface {
+	FoodFrequency(ctx context.Context, start time.Time, end time.Time, limit int) ([]FoodFrequency, error)
+	Summary(ctx context.Context, start time.Time, end time.Time) (AnalyticsSummary, error)
+	// CategoryLastServed returns the last serve date up to day that had an
+	// item in the category.
+	CategoryLastServed(ctx context.Context, category string, day time.Time) (string, error)
+}
+
+// ServedItem is one item at one meal on one day, as returned by searches.
+type ServedItem struct {
+	Key          string    `json:"-" bson:"_id"`
+	ItemID       int       `json:"ID,omitempty" bson:"item_id,omitempty"`
+	ServeDate    string    `json:"Serve_Date" bson:"serve_date"`
+	Date         time.Time `json:"-" bson:"date"`
+	Meal         string    `json:"meal" bson:"meal"`
+	FoodName     string    `json:"Food_Name" bson:"food_name"`
+	MenuCategory string    `json:"Menu_Category_Name" bson:"menu_category"`
+	Ingredients  string    `json:"-" bson:"ingredients"`
+	Vegan        bool      `json:"Vegan" bson:"vegan"`
+	Vegetarian   bool      `json:"Vegetarian" bson:"vegetarian"`
+	// Calories is parsed from the item's calorie string, nil if HUDS sent none
+	Calories *float64 `json:"calories,omitempty" bson:"calories"`
+}
+
+// itemCalories parses an item's calories for sorting.
+func itemCalories(item huds.CondensedMenuItem) *float64 {
+	amount := huds.ParseAmount(item.Calories, "")
+	if amount == nil {
+		return nil
+	}
+	return &amount.Value
+}
+
+// servedItemsFromMenu flattens a menu into one served item per item per meal.
+func servedItemsFromMenu(menu huds.CondensedMenu) []ServedItem {
+	t, err := time.Parse(huds.ServeDateLayout, menu.ServeDate)
+	if err != nil {
+		return nil
+	}
+	var items []ServedItem
+	for _, meal := range menu.Meals() {
+		for _, item := range huds.MealItems(menu, meal) {
+			items = append(items, ServedItem{
+				Key:          menu.ServeDate + "|" + meal + "|" + strings.ToLower(item.FoodName),
+				ItemID:       item.ID,
+				ServeDate:    menu.ServeDate,
+				Date:         t,
+				Meal:         meal,
+				FoodName:     item.FoodName,
+				MenuCategory: item.MenuCategory,
+				Ingredients:  item.Ingredients,
+				Vegan:        item.Vegan,
+				Vegetarian:   item.Vegetarian,
+				Calories:     itemCalories(item),
+			})
+		}
+	}
+	return items
+}
+
+// SearchResult is a served item with its text relevance score.
+type SearchResult struct {
+	ServedItem `bson:",inline"`
+	Score      float64 `json:"score" bson:"score"`
+}
+
+// Occurrence is one day a food was served, with the meals it was served at.
+type Occurrence struct {
+	ServeDate string   `json:"Serve_Date" bson:"_id"`
+	Meals     []string `json:"meals" bson:"meals"`
+}
+
+type FoodFrequency struct {
+	FoodName  string `json:"Food_Name" bson:"_id"`
+	Count     int    `json:"count" bson:"count"`
+	Days      int    `json:"days" bson:"days"`
+	Breakfast int    `json:"breakfast" bson:"breakfast"`
+	Lunch     int    `json:"lunch" bson:"lunch"`
+	Dinner    int    `json:"dinner" bson:"dinner"`
+}
+
+type MealSize struct {
+	Meal         string  `json:"meal" bson:"_id"`
+	AverageItems float64 `json:"average_items" bson:"average_items"`
+}
+
+type VeganShare struct {
+	Month             string  `json:"month" bson:"_id"`
+	Items             int     `json:"items" bson:"items"`
+	VeganPercent      float64 `json:"vegan_percent" bson:"vegan_percent"`
+	VegetarianPercent float64 `json:"vegetarian_percent" bson:"vegetarian_percent"`
+}
+
+type AnalyticsSummary struct {
+	Start         string          `json:"start"`
+	End           string          `json:"end"`
+	TopEntrees    []FoodFrequency `json:"top_entrees" bson:"top_entrees"`
+	MealSizes     []MealSize      `json:"average_menu_size" bson:"meal_sizes"`
+	VeganShare    []VeganShare    `json:"vegan_share_by_month" bson:"vegan_share"`
+	Category      string          `json:"category,omitempty"`
+	CategoryLast  *string         `json:"category_last_served,omitempty"`
+	DaysSinceLast *int            `json:"days_since_category,omitempty"`
+}
+
+// bucketKeys splits a serve date into the month bucket it is stored in and its
+// day-of-month key within that bucket.
+func bucketKeys(date string) (string, string, error) {
+	t, err := time.Parse(huds.ServeDateLayout, date)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid serve date %q: %v", date, err)
+	}
+	return t.Format("2006-01"), t.Format("02"), nil
+}
+
+type SearchQuery struct {
+	Text string
+	// Start and End bound the serve dates searched; zero means unbounded
+	Start time.Time
+	End   time.Time
+	Meal  string
+	Limit int
+	// Sort orders the results, most relevant first by default. Ties are
+	// broken by relevance, then most recent first
+	Sort       ItemSort
+	Descending bool
+}
+
+// ItemSort is a field menu items and search results can be sorted by.
+type ItemSort string
+
+const (
+	SortRelevance ItemSort = ""
+	SortName      ItemSort = "name"
+	// SortCalories puts items without calories last in either direction
+	SortCalories ItemSort = "calories"
+	SortCategory ItemSort = "category"
+)
+
+// reindexServedItems rewrites every stored menu, so the SQL stores fill in
+// served item columns added since the menus were stored.
+func reindexServedItems(ctx context.Context, s MenuStore) error {
+	earliest, latest, err := s.EarliestLatest(ctx)
+	if err != nil || earliest == "" {
+		return err
+	}
+	menus, err := s.GetRange(ctx, earliest, latest)
+	if err != nil {
+		return err
+	}
+	if err := s.Upsert(ctx, menus); err != nil {
+		return err
+	}
+	log.Printf("Re-indexed served items for %d days\n", len(menus))
+	return nil
+}
+
+// sqlSearchOrder is the ORDER BY clause for a search in the SQL stores,
+// matching compareServed.
+func sqlSearchOrder(query SearchQuery) string {
+	direction := "ASC"
+	if query.Descending {
+		direction = "DESC"
+	}
+	var order []string
+	switch query.Sort {
+	case SortName:
+		order = append(order, "lower(food_name) "+direction)
+	case SortCategory:
+		order = append(order, "lower(menu_category) "+direction, "lower(food_name) "+direction)
+	case SortCalories:
+		order = append(order, "calories IS NULL", "calories "+direction)
+	}
+	return strings.Join(append(order, "score DESC", "serve_date DESC"), ", ")
+}
+
+// sqlMeals is how the SQL stores, and backups, encode a day's meals as JSON.
+// Unlike the API encoding it keeps each extra meal's number.
+type sqlMeals struct {
+	Breakfast []huds.CondensedMenuItem `json:"breakfast"`
+	Lunch     []huds.CondensedMenuItem `json:"lunch"`
+	Dinner    []huds.CondensedMenuItem `json:"dinner"`
+	Extra     []sqlExtraMeal           `json:"extra,omitempty"`
+	UpdatedAt time.Time                `json:"updated_at"`
+	Provider  string                   `json:"provider,omitempty"`
+	Hash      string                   `json:"content_hash,omitempty"`
+	// Annenberg holds the menu's Annenberg view, and Name its location name
+	Annenberg *sqlMeals `json:"annenberg,omitempty"`
+	Name      string    `json:"name,omitempty"`
+}
+
+type sqlExtraMeal struct {
+	Number int                      `json:"number"`
+	Key    string                   `json:"key"`
+	Items  []huds.CondensedMenuItem `json:"items"`
+}
+
+func encodeMeals(menu huds.CondensedMenu) ([]byte, error) {
+	return json.Marshal(sqlMealsOf(menu))
+}
+
+func sqlMealsOf(menu huds.CondensedMenu) *sqlMeals {
+	meals := &sqlMeals{Breakfast: menu.Breakfast, Lunch: menu.Lunch, Dinner: menu.Dinner, UpdatedAt: menu.UpdatedAt, Provider: menu.Provider, Hash: menu.ContentHash}
+	for _, extra := range menu.Extra {
+		meals.Extra = append(meals.Extra, sqlExtraMeal{Number: extra.Number, Key: extra.Key, Items: extra.Items})
+	}
+	if menu.Annenberg != nil {
+		meals.Annenberg = sqlMealsOf(menu.Annenberg.Menu(menu.ServeDate))
+		meals.Annenberg.Name = menu.Annenberg.Name
+	}
+	return meals
+}
+
+func decodeMeals(data []byte, date string) (huds.CondensedMenu, error) {
+	var meals sqlMeals
+	if err := json.Unmarshal(data, &meals); err != nil {
+		return huds.CondensedMenu{}, err
+	}
+	return meals.menu(date), nil
+}
+
+func (meals *sqlMeals) menu(date string) huds.CondensedMenu {
+	menu := huds.CondensedMenu{ServeDate: date, Breakfast: meals.Breakfast, Lunch: meals.Lunch, Dinner: meals.Dinner, UpdatedAt: meals.UpdatedAt, Provider: meals.Provider, ContentHash: meals.Hash}
+	for _, extra := range meals.Extra {
+		huds.LearnMealPeriod(extra.Number, extra.Key)
+		menu.Extra = append(menu.Extra, huds.ExtraMeal{Number: extra.Number, Key: extra.Key, Items: extra.Items})
+	}
+	if meals.Annenberg != nil {
+		annenberg := huds.LocationMenuOf(meals.Annenberg.Name, meals.Annenberg.menu(date))
+		menu.Annenberg = &annenberg
+	}
+	return menu
+}
+
+// OpenOptions are what opening a store may do to its database.
+type OpenOptions struct {
+	// CreateIndexes creates missing mongo indexes rather than only checking
+	// them; see EnsureIndexes
+	CreateIndexes bool
+	// ReadOnly leaves the database as it is: no schema, indexes or
+	// migrations are written, for commands such as a dry run that promise not
+	// to write
+	ReadOnly bool
+}
+
+// Open opens a storage backend: "mongo", the default, keeps menus in
+// the huds database alongside everything else, "postgres" keeps them in the
+// PostgreSQL database at POSTGRES_URL, "sqlite" keeps them in a local file at
+// SQLITE_PATH (huds.db by default), and "memory" keeps them until exit.
+// ctx bounds preparing the store: its schema, indexes and migrations.
+func Open(ctx context.Context, backend string, client *mongo.Client, opts OpenOptions) (MenuStore, error) {
+	switch backend {
+	case "", "mongo":
+		if client == nil {
+			return nil, errors.New("MONGODB_URI must be set when MENU_STORE is mongo")
+		}
+		return NewMongoMenuStore(ctx, client.Database("huds"), opts), nil
+	case "postgres":
+		url := os.Getenv("POSTGRES_URL")
+		if url == "" {
+			return nil, errors.New("POSTGRES_URL must be set when MENU_STORE is postgres")
+		}
+		return NewPostgresMenuStore(ctx, url, opts)
+	case "sqlite":
+		path := os.Getenv("SQLITE_PATH")
+		if path == "" {
+			path = "huds.db"
+		}
+		return NewSQLiteMenuStore(ctx, path, opts)
+	case "memory":
+		return NewMemoryMenuStore(), nil
+	default:
+		return nil, fmt.Errorf("storage must be mongo, postgres, sqlite or memory, got %q", backend)
+	}
+}
+
+// compareServed orders two served items by field, returning a negative
+// number if a comes first. Categories are broken by name, and items without
+// calories always come last.
+func compareServed(a ServedItem, b ServedItem, field ItemSort, descending bool) int {
+	var c int
+	switch field {
+	case SortName:
+		c = strings.Compare(strings.ToLower(a.FoodName), strings.ToLower(b.FoodName))
+	case SortCategory:
+		c = strings.Compare(strings.ToLower(a.MenuCategory), strings.ToLower(b.MenuCategory))
+		if c == 0 {
+			c = strings.Compare(strings.ToLower(a.FoodName), strings.ToLower(b.FoodName))
+		}
+	case SortCalories:
+		switch {
+		case a.Calories == nil && b.Calories == nil:
+			return 0
+		case a.Calories == nil:
+			return 1
+		case b.Calories == nil:
+			return -1
+		case *a.Calories < *b.Calories:
+			c = -1
+		case *a.Calories > *b.Calories:
+			c = 1
+		}
+	}
+	if descending {
+		return -c
+	}
+	return c
+}
@@ -0,0 +1,23 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// RecipeTags are the curated tags on a recipe, such as "spicy" or "soup",
+// kept by upstream recipe number so they survive HUDS renaming the dish.
+type RecipeTags struct {
+	RecipeNumber string    `json:"Recipe_Number" bson:"_id"`
+	Tags         []string  `json:"tags" bson:"tags"`
+	UpdatedAt    time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// TagStore is implemented by stores that keep curated recipe tags.
+type TagStore interface {
+	// ListTags returns every tagged recipe, ordered by recipe number.
+	ListTags(ctx context.Context) ([]RecipeTags, error)
+	// SetTags replaces a recipe's tags, removing the recipe when there are
+	// none.
+	SetTags(ctx context.Context, tags RecipeTags) error
+}
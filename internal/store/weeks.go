@@ -0,0 +1,71 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"hudsgry-api/internal/huds"
+	"time"
+)
+
+// Week is a calendar week's menus with aggregates over them, built when its
+// days are refreshed so that serving it is a single lookup.
+type Week struct {
+	// Start is the week's Monday as a serve date
+	Start   string    `bson:"_id"`
+	BuiltAt time.Time `bson:"built_at"`
+	// Days are the week's stored menus in order; days without one are left
+	// out
+	Days []huds.CondensedMenu `bson:"days"`
+	// Summary holds the aggregates as JSON, encoded by whoever built the week
+	Summary []byte `bson:"summary"`
+}
+
+// WeekStore is implemented by stores that keep precomputed weeks.
+type WeekStore interface {
+	// UpsertWeeks replaces the stored weeks starting on each week's Start.
+	UpsertWeeks(ctx context.Context, weeks []Week) error
+	// GetWeek returns the week starting on start, or ErrMenuNotFound if it
+	// hasn't been built.
+	GetWeek(ctx context.Context, start string) (Week, error)
+}
+
+// WeekStart returns the Monday of the week day falls in.
+func WeekStart(day time.Time) time.Time {
+	offset := (int(day.Weekday()) + 6) % 7
+	return time.Date(day.Year(), day.Month(), day.Day()-offset, 0, 0, 0, 0, day.Location())
+}
+
+// sqlWeekDay is how the SQL stores encode one day of a week, with its meals
+// encoded as in the menus table.
+type sqlWeekDay struct {
+	ServeDate string          `json:"serve_date"`
+	Meals     json.RawMessage `json:"meals"`
+}
+
+func encodeWeekDays(menus []huds.CondensedMenu) ([]byte, error) {
+	days := make([]sqlWeekDay, 0, len(menus))
+	for _, menu := range menus {
+		meals, err := encodeMeals(menu)
+		if err != nil {
+			return nil, err
+		}
+		days = append(days, sqlWeekDay{ServeDate: menu.ServeDate, Meals: meals})
+	}
+	return json.Marshal(days)
+}
+
+func decodeWeekDays(data []byte) ([]huds.CondensedMenu, error) {
+	var days []sqlWeekDay
+	if err := json.Unmarshal(data, &days); err != nil {
+		return nil, err
+	}
+	menus := make([]huds.CondensedMenu, 0, len(days))
+	for _, day := range days {
+		menu, err := decodeMeals(day.Meals, day.ServeDate)
+		if err != nil {
+			return nil, err
+		}
+		menus = append(menus, menu)
+	}
+	return menus, nil
+}